@@ -0,0 +1,378 @@
+// Package acme obtains and renews the TLS certificate of the server from
+// an ACME CA like Let's Encrypt, answering the dns-01 challenges from the
+// zones the server is authoritative for
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bernoussama/mercury/logging"
+)
+
+var acmeLog = logging.For(logging.ACME)
+
+// defaults of the options
+const (
+	// LetsEncrypt is the directory of the Let's Encrypt production CA
+	LetsEncrypt = "https://acme-v02.api.letsencrypt.org/directory"
+	// DefaultRenewBefore renews certificates a month before they expire
+	DefaultRenewBefore = 30 * 24 * time.Hour
+)
+
+// timings of the exchanges with the CA
+var (
+	pollInterval  = 2 * time.Second
+	retryInterval = time.Hour
+	obtainTimeout = 5 * time.Minute
+)
+
+// files kept in the directory of the options
+const (
+	accountFile = "account.pem"
+	certFile    = "cert.pem"
+	keyFile     = "key.pem"
+)
+
+// Options configures the certificate obtained from an ACME CA
+type Options struct {
+	// Domains are the names of the certificate, empty to obtain none.
+	// Each must be in a zone the server answers authoritatively, wildcards
+	// included.
+	Domains []string `yaml:"domains"`
+	// Email is where the CA sends expiry notices, optional
+	Email string `yaml:"email"`
+	// Directory is the URL of the CA, Let's Encrypt by default
+	Directory string `yaml:"directory"`
+	// Dir keeps the account key and the certificate across restarts
+	Dir string `yaml:"dir"`
+	// RenewBefore is how long before expiry the certificate is renewed
+	RenewBefore time.Duration `yaml:"renew_before"`
+}
+
+// Enabled reports whether a certificate is to be obtained
+func (o Options) Enabled() bool {
+	return len(o.Domains) > 0
+}
+
+// Validate reports problems with the options without applying them
+func (o Options) Validate() []error {
+	if !o.Enabled() {
+		return nil
+	}
+	var errs []error
+	for _, domain := range o.Domains {
+		if name := strings.TrimPrefix(domain, "*."); name == "" || strings.ContainsAny(name, "*/: ") {
+			errs = append(errs, fmt.Errorf("invalid domain %q", domain))
+		}
+	}
+	if o.Directory != "" {
+		if u, err := url.Parse(o.Directory); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid directory URL %q", o.Directory))
+		}
+	}
+	if o.Dir == "" {
+		errs = append(errs, errors.New("dir is required to keep the account and certificate"))
+	}
+	if o.RenewBefore < 0 {
+		errs = append(errs, fmt.Errorf("renew_before must not be negative, got %s", o.RenewBefore))
+	}
+	return errs
+}
+
+func (o Options) directory() string {
+	if o.Directory == "" {
+		return LetsEncrypt
+	}
+	return o.Directory
+}
+
+func (o Options) renewBefore() time.Duration {
+	if o.RenewBefore == 0 {
+		return DefaultRenewBefore
+	}
+	return o.RenewBefore
+}
+
+// Publisher publishes the TXT records of dns-01 challenges in the zones
+type Publisher interface {
+	Present(name, value string)
+	Cleanup(name, value string) bool
+}
+
+// Manager keeps the certificate of the options valid, serving it to the
+// TLS listeners through GetCertificate
+type Manager struct {
+	opts      Options
+	publisher Publisher
+	http      *http.Client
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// New returns a manager answering challenges through publisher. The
+// certificate kept in the directory of the options is served until Run
+// renews it.
+func New(opts Options, publisher Publisher) *Manager {
+	m := &Manager{opts: opts, publisher: publisher, http: &http.Client{Timeout: 30 * time.Second}}
+	if cert, err := tls.LoadX509KeyPair(filepath.Join(opts.Dir, certFile), filepath.Join(opts.Dir, keyFile)); err == nil {
+		m.cert = &cert
+	} else if !errors.Is(err, os.ErrNotExist) {
+		acmeLog.Warn("kept certificate not loaded", "dir", opts.Dir, "err", err)
+	}
+	return m
+}
+
+// GetCertificate returns the certificate for tls.Config, an error until
+// one was obtained
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return nil, errors.New("acme: certificate not obtained yet")
+	}
+	return m.cert, nil
+}
+
+// TLSConfig returns a server config serving the certificate
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: m.GetCertificate, MinVersion: tls.VersionTLS12}
+}
+
+// renewIn returns how long until the certificate is to be renewed, zero
+// when it is due or there is none
+func (m *Manager) renewIn(now time.Time) time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil || m.cert.Leaf == nil || !m.covers(m.cert.Leaf) {
+		return 0
+	}
+	return max(m.cert.Leaf.NotAfter.Add(-m.opts.renewBefore()).Sub(now), 0)
+}
+
+// covers reports whether cert holds every domain of the options, which
+// may have changed since it was obtained
+func (m *Manager) covers(cert *x509.Certificate) bool {
+	for _, domain := range m.opts.Domains {
+		if !containsName(cert.DNSNames, domain) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run obtains the certificate when it is missing or due for renewal, and
+// again before each expiry, until ctx is done. Failures are retried
+// hourly.
+func (m *Manager) Run(ctx context.Context) {
+	for {
+		wait := m.renewIn(time.Now())
+		if wait == 0 {
+			octx, cancel := context.WithTimeout(ctx, obtainTimeout)
+			err := m.Obtain(octx)
+			cancel()
+			if err == nil {
+				continue
+			}
+			acmeLog.Error("certificate not obtained", "domains", strings.Join(m.opts.Domains, ","), "err", err)
+			wait = retryInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Obtain orders a new certificate for the domains, answers its challenges
+// and keeps it in the directory of the options
+func (m *Manager) Obtain(ctx context.Context) error {
+	if err := os.MkdirAll(m.opts.Dir, 0o700); err != nil {
+		return err
+	}
+	accountKey, err := loadKey(filepath.Join(m.opts.Dir, accountFile))
+	if err != nil {
+		return fmt.Errorf("account key: %w", err)
+	}
+	c := &client{http: m.http, key: accountKey}
+	if err := c.discover(ctx, m.opts.directory()); err != nil {
+		return err
+	}
+	if err := c.register(ctx, m.opts.Email); err != nil {
+		return fmt.Errorf("account: %w", err)
+	}
+
+	ids := make([]identifier, len(m.opts.Domains))
+	for i, domain := range m.opts.Domains {
+		ids[i] = identifier{Type: "dns", Value: domain}
+	}
+	var o order
+	res, err := c.post(ctx, c.dir.NewOrder, map[string]any{"identifiers": ids}, &o)
+	if err != nil {
+		return fmt.Errorf("order: %w", err)
+	}
+	orderURL := res.Header.Get("Location")
+	for _, authzURL := range o.Authorizations {
+		if err := m.authorize(ctx, c, authzURL); err != nil {
+			return err
+		}
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: strings.TrimPrefix(m.opts.Domains[0], "*.")},
+		DNSNames: m.opts.Domains,
+	}, certKey)
+	if err != nil {
+		return err
+	}
+	// the order turns ready once every authorization is valid
+	if err := c.poll(ctx, orderURL, &o, func() bool { return o.Status != statusPending }); err != nil {
+		return fmt.Errorf("order: %w", err)
+	}
+	if _, err := c.post(ctx, o.Finalize, map[string]string{"csr": base64.RawURLEncoding.EncodeToString(csr)}, &o); err != nil {
+		return fmt.Errorf("finalize: %w", err)
+	}
+	if err := c.poll(ctx, orderURL, &o, func() bool { return o.Status == statusValid || o.Status == statusInvalid }); err != nil {
+		return fmt.Errorf("order: %w", err)
+	}
+	if o.Status != statusValid {
+		return fmt.Errorf("order %s: %v", o.Status, o.Error)
+	}
+	chain, err := c.postRaw(ctx, o.Certificate)
+	if err != nil {
+		return fmt.Errorf("certificate: %w", err)
+	}
+	return m.keep(chain, certKey)
+}
+
+// authorize answers the dns-01 challenge of an authorization and waits
+// for the CA to validate it, cleaning up the record after
+func (m *Manager) authorize(ctx context.Context, c *client, authzURL string) error {
+	var authz authorization
+	if _, err := c.post(ctx, authzURL, nil, &authz); err != nil {
+		return fmt.Errorf("authorization: %w", err)
+	}
+	if authz.Status == statusValid {
+		return nil
+	}
+	var ch *challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == "dns-01" {
+			ch = &authz.Challenges[i]
+		}
+	}
+	if ch == nil {
+		return fmt.Errorf("%s: no dns-01 challenge offered", authz.Identifier.Value)
+	}
+	// wildcards are validated at their base name
+	name := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.") + "."
+	value := keyAuthorization(ch.Token, &c.key.PublicKey)
+	m.publisher.Present(name, value)
+	defer m.publisher.Cleanup(name, value)
+	acmeLog.Info("answering challenge", "name", name)
+
+	if _, err := c.post(ctx, ch.URL, struct{}{}, nil); err != nil {
+		return fmt.Errorf("challenge: %w", err)
+	}
+	if err := c.poll(ctx, authzURL, &authz, func() bool { return authz.Status != statusPending }); err != nil {
+		return fmt.Errorf("authorization: %w", err)
+	}
+	if authz.Status != statusValid {
+		for _, ch := range authz.Challenges {
+			if ch.Error != nil {
+				return fmt.Errorf("%s: %w", authz.Identifier.Value, ch.Error)
+			}
+		}
+		return fmt.Errorf("%s: authorization %s", authz.Identifier.Value, authz.Status)
+	}
+	return nil
+}
+
+// keep writes the certificate chain and its key to the directory and
+// serves them
+func (m *Manager) keep(chain []byte, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	cert, err := tls.X509KeyPair(chain, keyPEM)
+	if err != nil {
+		return fmt.Errorf("certificate: %w", err)
+	}
+	if err := writeFile(filepath.Join(m.opts.Dir, keyFile), keyPEM); err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(m.opts.Dir, certFile), chain); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.cert = &cert
+	m.mu.Unlock()
+	acmeLog.Info("certificate obtained", "domains", strings.Join(cert.Leaf.DNSNames, ","), "expires", cert.Leaf.NotAfter)
+	return nil
+}
+
+// loadKey reads the P-256 key at path, creating it when missing
+func loadKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return key, writeFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block", path)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// writeFile replaces the file at path, readable by the owner only
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
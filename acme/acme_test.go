@@ -0,0 +1,294 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// records is a Publisher keeping the challenge records in a map
+type records struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (r *records) Present(name, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[name] = value
+}
+
+func (r *records) Cleanup(name, value string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.values[name]
+	delete(r.values, name)
+	return ok
+}
+
+func (r *records) lookup(name string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.values[name]
+}
+
+// fakeCA issues certificates for the dns-01 challenges it finds answered
+// in its records, checking the signature of every request
+type fakeCA struct {
+	t       *testing.T
+	records *records
+	url     string
+	key     *ecdsa.PrivateKey
+	ca      *x509.Certificate
+	account *ecdsa.PublicKey
+
+	mu          sync.Mutex
+	domains     []string
+	validated   map[string]bool
+	certificate []byte
+}
+
+func newFakeCA(t *testing.T, records *records) *fakeCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	f := &fakeCA{t: t, records: records, key: key, ca: ca, validated: make(map[string]bool)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	f.url = srv.URL
+	return f
+}
+
+func (f *fakeCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprint(time.Now().UnixNano()))
+	if r.URL.Path == "/directory" {
+		json.NewEncoder(w).Encode(directory{NewNonce: f.url + "/nonce", NewAccount: f.url + "/account", NewOrder: f.url + "/order"})
+		return
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	payload, ok := f.verify(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(Problem{Type: "urn:ietf:params:acme:error:malformed", Detail: "bad signature"})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path, arg, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch path {
+	case "account":
+		w.Header().Set("Location", f.url+"/accounts/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	case "order":
+		if len(payload) > 0 {
+			var req struct{ Identifiers []identifier }
+			json.Unmarshal(payload, &req)
+			f.domains = nil
+			for _, id := range req.Identifiers {
+				f.domains = append(f.domains, id.Value)
+			}
+			w.Header().Set("Location", f.url+"/order")
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(f.order())
+	case "authz":
+		json.NewEncoder(w).Encode(f.authz(arg))
+	case "challenge":
+		// like a CA, query the record of the challenge
+		name := "_acme-challenge." + strings.TrimPrefix(arg, "*.") + "."
+		if f.records.lookup(name) == keyAuthorization("token-"+arg, f.account) {
+			f.validated[arg] = true
+		}
+		w.Write([]byte("{}"))
+	case "finalize":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			f.t.Errorf("csr: %v", err)
+			return
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		}
+		cert, err := x509.CreateCertificate(rand.Reader, tmpl, f.ca, csr.PublicKey, f.key)
+		if err != nil {
+			f.t.Errorf("certificate: %v", err)
+			return
+		}
+		f.certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+		json.NewEncoder(w).Encode(f.order())
+	case "cert":
+		w.Write(f.certificate)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// verify checks the JWS of a request and returns its payload
+func (f *fakeCA) verify(r *http.Request) ([]byte, bool) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, false
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var protected struct {
+		Alg, URL, Kid string
+		JWK           map[string]string
+	}
+	json.Unmarshal(header, &protected)
+	if protected.JWK != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(protected.JWK["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(protected.JWK["y"])
+		f.account = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	} else if protected.Kid != f.url+"/accounts/1" {
+		return nil, false
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if protected.Alg != "ES256" || protected.URL != f.url+r.URL.Path || len(sig) != 64 ||
+		!ecdsa.Verify(f.account, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, false
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload, true
+}
+
+func (f *fakeCA) order() order {
+	o := order{Status: statusPending, Finalize: f.url + "/finalize"}
+	ready := true
+	for _, domain := range f.domains {
+		o.Authorizations = append(o.Authorizations, f.url+"/authz/"+domain)
+		ready = ready && f.validated[domain]
+	}
+	switch {
+	case f.certificate != nil:
+		o.Status, o.Certificate = statusValid, f.url+"/cert"
+	case ready:
+		o.Status = "ready"
+	}
+	return o
+}
+
+func (f *fakeCA) authz(domain string) authorization {
+	a := authorization{Status: statusPending, Identifier: identifier{Type: "dns", Value: domain}}
+	if f.validated[domain] {
+		a.Status = statusValid
+	}
+	a.Challenges = []challenge{
+		{Type: "http-01", URL: f.url + "/nope", Token: "other"},
+		{Type: "dns-01", URL: f.url + "/challenge/" + domain, Token: "token-" + domain},
+	}
+	return a
+}
+
+func TestObtain(t *testing.T) {
+	pollInterval = time.Millisecond
+	recs := &records{values: make(map[string]string)}
+	ca := newFakeCA(t, recs)
+	opts := Options{Domains: []string{"dns.example.com", "*.example.com"}, Directory: ca.url + "/directory", Dir: t.TempDir()}
+	m := New(opts, recs)
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Error("certificate served before it was obtained")
+	}
+	if m.renewIn(time.Now()) != 0 {
+		t.Error("renewal not due without a certificate")
+	}
+
+	if err := m.Obtain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsName(cert.Leaf.DNSNames, "*.example.com") || cert.Leaf.Subject.CommonName != "dns.example.com" {
+		t.Errorf("certificate for %s %v", cert.Leaf.Subject.CommonName, cert.Leaf.DNSNames)
+	}
+	if len(recs.values) != 0 {
+		t.Errorf("challenge records left: %v", recs.values)
+	}
+	if wait := m.renewIn(time.Now()); wait < 59*24*time.Hour || wait > 60*24*time.Hour {
+		t.Errorf("renewal in %s, want 60 days", wait)
+	}
+
+	// the certificate and account are kept for the next start
+	again := New(opts, recs)
+	if _, err := again.GetCertificate(&tls.ClientHelloInfo{}); err != nil {
+		t.Errorf("kept certificate: %v", err)
+	}
+	opts.Domains = append(opts.Domains, "www.example.org")
+	if New(opts, recs).renewIn(time.Now()) != 0 {
+		t.Error("renewal not due for a new domain")
+	}
+}
+
+func TestObtainUnanswered(t *testing.T) {
+	pollInterval = time.Millisecond
+	ca := newFakeCA(t, &records{values: make(map[string]string)})
+	// the challenges are published where the CA does not look
+	m := New(Options{Domains: []string{"dns.example.com"}, Directory: ca.url + "/directory", Dir: t.TempDir()}, &records{values: make(map[string]string)})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := m.Obtain(ctx); err == nil {
+		t.Error("certificate obtained without answering the challenge")
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		errs int
+	}{
+		{"disabled", Options{}, 0},
+		{"wildcard", Options{Domains: []string{"*.example.com"}, Dir: "/var/lib/mercury"}, 0},
+		{"no dir", Options{Domains: []string{"example.com"}}, 1},
+		{"bad domain", Options{Domains: []string{"exa mple.com"}, Dir: "d"}, 1},
+		{"bad directory", Options{Domains: []string{"example.com"}, Dir: "d", Directory: "ftp://ca"}, 1},
+	}
+	for _, tt := range tests {
+		if errs := tt.opts.Validate(); len(errs) != tt.errs {
+			t.Errorf("%s: Validate() = %v, want %d errors", tt.name, errs, tt.errs)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// statuses of orders, authorizations and challenges
+const (
+	statusPending = "pending"
+	statusValid   = "valid"
+	statusInvalid = "invalid"
+)
+
+// Problem is an error reported by the CA, RFC 7807
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Type, p.Detail)
+}
+
+// badNonce is the problem of requests with a stale nonce, retried once
+const badNonce = "urn:ietf:params:acme:error:badNonce"
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string       `json:"status"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate"`
+	Error          *Problem     `json:"error"`
+}
+
+type authorization struct {
+	Status     string      `json:"status"`
+	Identifier identifier  `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Token  string   `json:"token"`
+	Status string   `json:"status"`
+	Error  *Problem `json:"error"`
+}
+
+// client speaks RFC 8555 with a CA, signing its requests with the
+// account key
+type client struct {
+	http  *http.Client
+	key   *ecdsa.PrivateKey
+	dir   directory
+	kid   string
+	nonce string
+}
+
+// discover reads the directory of the CA at url
+func (c *client) discover(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("directory: %s", res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(&c.dir)
+}
+
+// register creates the account of the key, or finds it when it exists
+func (c *client) register(ctx context.Context, email string) error {
+	account := map[string]any{"termsOfServiceAgreed": true}
+	if email != "" {
+		account["contact"] = []string{"mailto:" + email}
+	}
+	res, err := c.post(ctx, c.dir.NewAccount, account, nil)
+	if err != nil {
+		return err
+	}
+	c.kid = res.Header.Get("Location")
+	if c.kid == "" {
+		return errors.New("account without a location")
+	}
+	return nil
+}
+
+// post sends a signed request with payload, a POST-as-GET when nil, and
+// decodes the reply into v unless it is nil
+func (c *client) post(ctx context.Context, url string, payload, v any) (*http.Response, error) {
+	res, body, err := c.send(ctx, url, payload)
+	var problem *Problem
+	if errors.As(err, &problem) && problem.Type == badNonce {
+		res, body, err = c.send(ctx, url, payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		if err := json.Unmarshal(body, v); err != nil {
+			return nil, fmt.Errorf("%s: %w", url, err)
+		}
+	}
+	return res, nil
+}
+
+// postRaw sends a POST-as-GET and returns the body as it is, for the
+// certificate chain
+func (c *client) postRaw(ctx context.Context, url string) ([]byte, error) {
+	_, body, err := c.send(ctx, url, nil)
+	return body, err
+}
+
+func (c *client) send(ctx context.Context, url string, payload any) (*http.Response, []byte, error) {
+	if c.nonce == "" {
+		if err := c.newNonce(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+	jws, err := c.sign(url, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jws))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	c.nonce = res.Header.Get("Replay-Nonce")
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode >= 400 {
+		problem := &Problem{Status: res.StatusCode}
+		if json.Unmarshal(body, problem) != nil || problem.Type == "" {
+			return nil, nil, fmt.Errorf("%s: %s", url, res.Status)
+		}
+		return nil, nil, problem
+	}
+	return res, body, nil
+}
+
+func (c *client) newNonce(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.dir.NewNonce, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if c.nonce = res.Header.Get("Replay-Nonce"); c.nonce == "" {
+		return errors.New("no nonce from the CA")
+	}
+	return nil
+}
+
+// sign returns the flattened JWS of payload for url, identifying the
+// account by its key until it has a key ID
+func (c *client) sign(url string, payload any) ([]byte, error) {
+	protected := map[string]any{"alg": "ES256", "nonce": c.nonce, "url": url}
+	if c.kid == "" {
+		protected["jwk"] = jwk(&c.key.PublicKey)
+	} else {
+		protected["kid"] = c.kid
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if payload != nil {
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(encoded))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	// ES256 signatures are r and s as 32 octets each, RFC 7518
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	// the nonce is spent
+	c.nonce = ""
+	return json.Marshal(map[string]string{
+		"protected": base64.RawURLEncoding.EncodeToString(header),
+		"payload":   base64.RawURLEncoding.EncodeToString(body),
+		"signature": base64.RawURLEncoding.EncodeToString(sig),
+	})
+}
+
+// jwk returns the JSON web key of a P-256 public key, its members in the
+// order of the thumbprint, RFC 7638
+func jwk(key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// thumbprint returns the RFC 7638 thumbprint of the key
+func thumbprint(key *ecdsa.PublicKey) string {
+	// json.Marshal sorts map keys as the thumbprint requires
+	data, _ := json.Marshal(jwk(key))
+	digest := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// keyAuthorization returns the TXT value answering the dns-01 challenge
+// of token, RFC 8555 section 8.4
+func keyAuthorization(token string, key *ecdsa.PublicKey) string {
+	digest := sha256.Sum256([]byte(token + "." + thumbprint(key)))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// poll fetches url into v until done reports true, waiting between the
+// attempts, until ctx is done
+func (c *client) poll(ctx context.Context, url string, v any, done func() bool) error {
+	for {
+		if _, err := c.post(ctx, url, nil, v); err != nil {
+			return err
+		}
+		if done() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
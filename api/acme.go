@@ -0,0 +1,130 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bernoussama/mercury/dns"
+)
+
+// ACMERecord is the body of POST /api/acme/present and /api/acme/cleanup,
+// as sent by the httpreq provider of lego. Fqdn and Value are sent in the
+// default mode, Domain and KeyAuth in the raw mode.
+type ACMERecord struct {
+	Fqdn    string `json:"fqdn"`
+	Value   string `json:"value"`
+	Domain  string `json:"domain"`
+	Token   string `json:"token"`
+	KeyAuth string `json:"keyAuth"`
+}
+
+// challenge returns the name and value of the TXT record
+func (rec ACMERecord) challenge() (name, value string, err error) {
+	switch {
+	case rec.Fqdn != "":
+		return rec.Fqdn, rec.Value, nil
+	case rec.Domain != "" && rec.KeyAuth != "":
+		// RFC 8555 section 8.4
+		digest := sha256.Sum256([]byte(rec.KeyAuth))
+		return "_acme-challenge." + strings.TrimPrefix(rec.Domain, "*."), base64.RawURLEncoding.EncodeToString(digest[:]), nil
+	}
+	return "", "", errors.New("fqdn and value, or domain and keyAuth are required")
+}
+
+// ACMEUpdate is the body and reply of POST /api/acme/update, the update
+// call of acme-dns. Subdomain is the name the _acme-challenge CNAME of the
+// validated domain points at, itself an _acme-challenge name.
+type ACMEUpdate struct {
+	Subdomain string `json:"subdomain,omitempty"`
+	TXT       string `json:"txt"`
+}
+
+// presentChallenge publishes the TXT record of an ACME DNS-01 challenge
+func (s *Server) presentChallenge(w http.ResponseWriter, r *http.Request) {
+	var rec ACMERecord
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	name, value, err := rec.challenge()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if status, err := s.checkChallenge(r, name); err != nil {
+		writeError(w, status, err)
+		return
+	}
+	s.Challenges.Present(name, value)
+	u, _ := UserFrom(r.Context())
+	apiLog.Info("acme challenge presented", "user", u.Name, "name", name)
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// cleanupChallenge removes the TXT record of an ACME DNS-01 challenge
+func (s *Server) cleanupChallenge(w http.ResponseWriter, r *http.Request) {
+	var rec ACMERecord
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	name, value, err := rec.challenge()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if status, err := s.checkChallenge(r, name); err != nil {
+		writeError(w, status, err)
+		return
+	}
+	removed := s.Challenges.Cleanup(name, value)
+	u, _ := UserFrom(r.Context())
+	apiLog.Info("acme challenge cleaned up", "user", u.Name, "name", name, "removed", removed)
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// updateChallenge publishes a challenge like acme-dns, replacing the
+// previous value, which acme-dns keeps only the last two of
+func (s *Server) updateChallenge(w http.ResponseWriter, r *http.Request) {
+	var update ACMEUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil || update.Subdomain == "" || update.TXT == "" {
+		writeError(w, http.StatusBadRequest, errors.New("subdomain and txt are required"))
+		return
+	}
+	if status, err := s.checkChallenge(r, update.Subdomain); err != nil {
+		writeError(w, status, err)
+		return
+	}
+	s.Challenges.Present(update.Subdomain, update.TXT)
+	u, _ := UserFrom(r.Context())
+	apiLog.Info("acme challenge updated", "user", u.Name, "name", update.Subdomain)
+	writeJSON(w, http.StatusOK, ACMEUpdate{TXT: update.TXT})
+}
+
+// challengeLabel starts the names challenge records are published at, so
+// the acme role can not add TXT records, like SPF policies, at other names
+const challengeLabel = "_acme-challenge."
+
+// checkChallenge checks that a challenge record may be published at name,
+// an _acme-challenge name in a zone served and owned by the tenant of the
+// user, and returns the status to fail with
+func (s *Server) checkChallenge(r *http.Request, name string) (int, error) {
+	if s.Challenges == nil || s.Zones == nil {
+		return http.StatusNotFound, errors.New("acme challenges disabled")
+	}
+	if len(name) <= len(challengeLabel) || !strings.EqualFold(name[:len(challengeLabel)], challengeLabel) {
+		return http.StatusBadRequest, fmt.Errorf("%s: challenges are only published at %s names", name, strings.TrimSuffix(challengeLabel, "."))
+	}
+	if _, ok := dns.FindZone(s.Zones(), name); !ok {
+		return http.StatusNotFound, fmt.Errorf("%s: not in a zone served", name)
+	}
+	if !s.scope(r).OwnsName(name) {
+		return http.StatusForbidden, fmt.Errorf("%s: %w", name, errNotOwned)
+	}
+	return 0, nil
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bernoussama/mercury/dns"
+)
+
+func TestACMEChallenges(t *testing.T) {
+	opts := testTenants
+	opts.Users = append([]User{{Name: "lego", Token: "acme-token", Role: RoleACME}}, opts.Users...)
+	s := New(opts, testCache())
+	s.Zones = newTenantServer().Zones
+	s.Challenges = dns.NewChallenges()
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		auth   func(*http.Request)
+		status int
+	}{
+		{"present", "/api/acme/present", `{"fqdn": "_acme-challenge.example.com.", "value": "abc"}`, basicAuth("acme-token"), http.StatusOK},
+		{"present raw", "/api/acme/present", `{"domain": "*.example.net", "token": "t", "keyAuth": "t.key"}`, basicAuth("acme-token"), http.StatusOK},
+		{"outside the zones", "/api/acme/present", `{"fqdn": "_acme-challenge.example.io.", "value": "abc"}`, basicAuth("acme-token"), http.StatusNotFound},
+		{"missing value", "/api/acme/present", `{"value": "abc"}`, basicAuth("acme-token"), http.StatusBadRequest},
+		{"wrong password", "/api/acme/present", `{"fqdn": "_acme-challenge.example.com.", "value": "abc"}`, basicAuth("nope"), http.StatusUnauthorized},
+		{"acme-dns update", "/api/acme/update", `{"subdomain": "_acme-challenge.d420c923.example.org", "txt": "xyz"}`, apiKey("acme-token"), http.StatusOK},
+		{"apex", "/api/acme/present", `{"fqdn": "example.com.", "value": "v=spf1 +all"}`, basicAuth("acme-token"), http.StatusBadRequest},
+		{"update outside challenges", "/api/acme/update", `{"subdomain": "www.example.org", "txt": "xyz"}`, apiKey("acme-token"), http.StatusBadRequest},
+		{"tenant zone", "/api/acme/present", `{"fqdn": "_acme-challenge.example.com.", "value": "def"}`, apiKey("smith-token"), http.StatusOK},
+		{"other tenant zone", "/api/acme/present", `{"fqdn": "_acme-challenge.example.org.", "value": "def"}`, apiKey("smith-token"), http.StatusForbidden},
+		{"reader", "/api/acme/cleanup", `{"fqdn": "_acme-challenge.example.com.", "value": "abc"}`, apiKey("doe-token"), http.StatusForbidden},
+		{"cleanup", "/api/acme/cleanup", `{"fqdn": "_acme-challenge.example.com.", "value": "abc"}`, basicAuth("acme-token"), http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		tt.auth(req)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+
+	for name, want := range map[string]int{
+		"_acme-challenge.example.com.":          1,
+		"_acme-challenge.example.net.":          1,
+		"_acme-challenge.d420c923.example.org.": 1,
+		"example.com.":                          0,
+		"www.example.org.":                      0,
+	} {
+		if got := s.Challenges.Lookup(name, dns.TypeTXT, 1); len(got) != want {
+			t.Errorf("%s: %d records, want %d", name, len(got), want)
+		}
+	}
+
+	// the acme role only publishes challenges
+	req := httptest.NewRequest(http.MethodGet, "/api/cache", nil)
+	basicAuth("acme-token")(req)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("acme user reading the cache: status = %d, want 403", rec.Code)
+	}
+}
+
+func basicAuth(token string) func(*http.Request) {
+	return func(r *http.Request) { r.SetBasicAuth("lego", token) }
+}
+
+func apiKey(token string) func(*http.Request) {
+	return func(r *http.Request) { r.Header.Set("X-Api-Key", token) }
+}
+
+func TestACMERecordRaw(t *testing.T) {
+	name, value, err := ACMERecord{Domain: "*.example.com", Token: "token", KeyAuth: "token.thumbprint"}.challenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// echo -n token.thumbprint | openssl dgst -sha256 -binary | base64 | tr '+/' '-_' | tr -d =
+	if name != "_acme-challenge.example.com" || value != "61rBZ_4knHblO0MNoxFsXZ_eTFUHum0B6IVRbhvUn5I" {
+		t.Errorf("challenge %s %q", name, value)
+	}
+}
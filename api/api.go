@@ -0,0 +1,379 @@
+package api
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/buildinfo"
+	"github.com/bernoussama/mercury/cache"
+	"github.com/bernoussama/mercury/clients"
+	"github.com/bernoussama/mercury/detect"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/bernoussama/mercury/logging"
+	"github.com/bernoussama/mercury/peer"
+	"github.com/bernoussama/mercury/querylog"
+)
+
+var apiLog = logging.For(logging.API)
+
+// Server exposes admin endpoints over HTTP
+type Server struct {
+	Cache cache.Cache[dns.Message]
+	// Listeners reports the query counters of each DNS listener
+	Listeners func() []ListenerStats
+	// Sinkhole holds the blocklist and threat feeds
+	Sinkhole *blocklist.Sinkhole
+	// Clients counts the queries of each client
+	Clients *clients.Directory
+	// Detector raises alerts about clients tunneling or resolving
+	// generated names
+	Detector *detect.Detector
+	// Reload applies the config file again
+	Reload func() (ReloadSummary, error)
+	// Tap streams the queries answered to the clients watching them
+	Tap *querylog.Tap
+	// Peers reports the state sync with each peer
+	Peers func() []peer.Stats
+	// Zones returns the zones served, by origin
+	Zones func() map[string]dns.Zone
+	// CacheUsage breaks the cache stats down by query type and zone
+	CacheUsage func() dns.CacheUsageStats
+	// Challenges holds the ACME challenge records published in the zones
+	Challenges *dns.Challenges
+	// GetCertificate serves the certificate obtained through ACME, when
+	// the TLS options ask for it
+	GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	// Instance labels the replies, to tell replicas apart
+	Instance string
+
+	opts Options
+	mux  *http.ServeMux
+}
+
+// New returns a server managing dnsCache
+func New(opts Options, dnsCache cache.Cache[dns.Message]) *Server {
+	s := &Server{Cache: dnsCache, opts: opts, mux: http.NewServeMux()}
+	s.handleScoped("GET /api/cache", RoleRead, s.listCache)
+	s.handle("GET /api/cache/stats", RoleRead, s.cacheStats)
+	s.handleScoped("GET /api/cache/entry", RoleRead, s.getCache)
+	s.handleScoped("POST /api/cache/flush", RoleAdmin, s.flushCache)
+	s.handle("GET /api/listeners", RoleRead, s.listListeners)
+	s.handleScoped("GET /api/blocklist", RoleRead, s.blocklistStats)
+	s.handleScoped("POST /api/blocklist/categories", RoleAdmin, s.setCategory)
+	s.handle("POST /api/blocklist/pause", RoleAdmin, s.pauseBlocking)
+	s.handle("GET /api/clients", RoleRead, s.listClients)
+	s.handle("GET /api/alerts", RoleRead, s.listAlerts)
+	s.handle("POST /api/reload", RoleAdmin, s.reload)
+	s.handle("GET /api/peers", RoleRead, s.listPeers)
+	s.handleScoped("GET /api/zones", RoleRead, s.listZones)
+	s.handleScoped("GET /api/zones/{origin}/records", RoleRead, s.zoneRecords)
+	s.handleScoped("POST /api/acme/present", RoleACME, s.presentChallenge)
+	s.handleScoped("POST /api/acme/cleanup", RoleACME, s.cleanupChallenge)
+	s.handleScoped("POST /api/acme/update", RoleACME, s.updateChallenge)
+	s.handleScoped("GET /api/version", RoleRead, s.version)
+	s.handle("GET /api/tap", RoleRead, s.streamTap)
+	s.handle("POST "+GRPCService+"Version", RoleRead, s.grpcVersion)
+	s.handle("POST "+GRPCService+"Tap", RoleRead, s.grpcTap)
+	s.handle("GET /admin/api.php", RoleRead, s.pihole)
+	return s
+}
+
+// InstanceHeader carries the instance label of the server in every reply
+const InstanceHeader = "Mercury-Instance"
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Instance != "" {
+		w.Header().Set(InstanceHeader, s.Instance)
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the API until it fails
+func (s *Server) ListenAndServe() error {
+	tlsConfig, err := s.opts.tlsConfig(s.GetCertificate)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", s.opts.Listen)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	apiLog.Info("admin API running", "address", ln.Addr(), "tls", tlsConfig != nil, "users", len(s.opts.Users))
+	srv := &http.Server{Handler: s, ReadHeaderTimeout: 5 * time.Second}
+	return srv.Serve(ln)
+}
+
+// page sizes of list endpoints
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// CacheEntry describes one cached answer
+type CacheEntry struct {
+	Key   string `json:"key"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+	// TTL is the remaining time to live in seconds
+	TTL     int    `json:"ttl"`
+	Size    int    `json:"size"`
+	Answers int    `json:"answers"`
+	Hits    uint64 `json:"hits"`
+	Source  string `json:"source"`
+}
+
+// CacheList is the reply of GET /api/cache
+type CacheList struct {
+	// Total counts the entries matching the filter, across all pages
+	Total   int          `json:"total"`
+	Offset  int          `json:"offset"`
+	Entries []CacheEntry `json:"entries"`
+}
+
+// CacheStats is the reply of GET /api/cache/stats
+type CacheStats struct {
+	// Instance labels the server the stats come from
+	Instance string `json:"instance,omitempty"`
+	cache.Stats
+	HitRatio float64 `json:"hit_ratio"`
+	// Bytes is the wire size of all cached answers
+	Bytes int `json:"bytes"`
+	// Types and Zones break the lookups and entries down by query type
+	// and by zone
+	Types map[string]dns.CacheCounts `json:"types,omitempty"`
+	Zones map[string]dns.CacheCounts `json:"zones,omitempty"`
+}
+
+// listCache lists cached answers sorted by key, filtered to the names
+// under the suffix param and paginated with offset and limit. Tenant users
+// only see the names of their zones.
+func (s *Server) listCache(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := pagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	suffix := r.FormValue("suffix")
+	if suffix != "" && !strings.HasSuffix(suffix, ".") {
+		suffix += "."
+	}
+
+	now := time.Now()
+	scope := s.scope(r)
+	entries := []CacheEntry{}
+	s.Cache.Range(func(e cache.Entry[dns.Message]) bool {
+		name := dns.KeyName(e.Key)
+		if (suffix != "" && !dns.IsSubdomain(name, suffix)) || !scope.OwnsName(name) {
+			return true
+		}
+		entries = append(entries, cacheEntry(e, now))
+		return true
+	})
+	slices.SortFunc(entries, func(a, b CacheEntry) int { return strings.Compare(a.Key, b.Key) })
+
+	writeJSON(w, http.StatusOK, CacheList{Total: len(entries), Offset: offset, Entries: paginate(entries, offset, limit)})
+}
+
+// cacheEntry describes the cached answer e at now
+func cacheEntry(e cache.Entry[dns.Message], now time.Time) CacheEntry {
+	return CacheEntry{
+		Key:     e.Key,
+		Name:    dns.KeyName(e.Key),
+		Type:    e.Value.Question.QType.String(),
+		Class:   dns.ClassName(e.Value.Question.QClass),
+		TTL:     int(e.Value.Expiry.Sub(now).Seconds()),
+		Size:    e.Value.Size(),
+		Answers: len(e.Value.Answers),
+		Hits:    e.Hits,
+		Source:  e.Value.Source,
+	}
+}
+
+// CacheRecord is the reply of GET /api/cache/entry, a cached answer along
+// with its records, aged like the answers served from it
+type CacheRecord struct {
+	CacheEntry
+	Message dns.Message `json:"message"`
+}
+
+// getCache shows the cached answer with the key param. Looking it up
+// through Range leaves the hit counts alone.
+func (s *Server) getCache(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	if !s.scope(r).OwnsName(dns.KeyName(key)) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s: %w", dns.KeyName(key), errNotOwned))
+		return
+	}
+	now := time.Now()
+	var record *CacheRecord
+	s.Cache.Range(func(e cache.Entry[dns.Message]) bool {
+		if e.Key != key {
+			return true
+		}
+		record = &CacheRecord{CacheEntry: cacheEntry(e, now), Message: e.Value.Aged(now)}
+		return false
+	})
+	if record == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not cached", key))
+		return
+	}
+	writeJSON(w, http.StatusOK, record)
+}
+
+func (s *Server) cacheStats(w http.ResponseWriter, r *http.Request) {
+	stats := CacheStats{Instance: s.Instance, Stats: s.Cache.Stats()}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(lookups)
+	}
+	s.Cache.Range(func(e cache.Entry[dns.Message]) bool {
+		stats.Bytes += e.Value.Size()
+		return true
+	})
+	if s.CacheUsage != nil {
+		usage := s.CacheUsage()
+		stats.Types, stats.Zones = usage.Types, usage.Zones
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// ListenerStats counts the queries received by one DNS listener, the
+// reply of GET /api/listeners is a list of them
+type ListenerStats struct {
+	// Instance labels the server of the listener
+	Instance  string `json:"instance,omitempty"`
+	Name      string `json:"name"`
+	Protocol  string `json:"protocol"`
+	Address   string `json:"address"`
+	Queries   uint64 `json:"queries"`
+	Refused   uint64 `json:"refused"`
+	Malformed uint64 `json:"malformed"`
+	// Deduplicated counts the UDP retransmits answered together with the
+	// query still in flight
+	Deduplicated uint64 `json:"deduplicated"`
+	// Connections are the tcp, dot and doh connections open now, and
+	// RejectedConnections those closed as the protocol had too many
+	Connections         int64  `json:"connections"`
+	RejectedConnections uint64 `json:"rejected_connections"`
+}
+
+func (s *Server) listListeners(w http.ResponseWriter, r *http.Request) {
+	stats := []ListenerStats{}
+	if s.Listeners != nil {
+		stats = s.Listeners()
+	}
+	for i := range stats {
+		stats[i].Instance = s.Instance
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// Version is the reply of GET /api/version
+type Version struct {
+	Instance string `json:"instance,omitempty"`
+	buildinfo.Info
+}
+
+func (s *Server) version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Version{Instance: s.Instance, Info: buildinfo.Get()})
+}
+
+func (s *Server) listPeers(w http.ResponseWriter, r *http.Request) {
+	stats := []peer.Stats{}
+	if s.Peers != nil {
+		stats = s.Peers()
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// pagination reads the offset and limit params of list endpoints
+func pagination(r *http.Request) (offset, limit int, err error) {
+	offset, limit = 0, DefaultLimit
+	if v := r.FormValue("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", v)
+		}
+	}
+	if v := r.FormValue("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > MaxLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d, got %q", MaxLimit, v)
+		}
+	}
+	return offset, limit, nil
+}
+
+// paginate returns the page of items starting at offset
+func paginate[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return []T{}
+	}
+	return items[offset:min(offset+limit, len(items))]
+}
+
+// FlushResult is the reply of POST /api/cache/flush
+type FlushResult struct {
+	Flushed int `json:"flushed"`
+}
+
+// Error is the body of failed requests
+type Error struct {
+	Error string `json:"error"`
+}
+
+// flushCache removes cached answers for a name, for every name under a
+// suffix, or all of them, as selected by the name, suffix and all params.
+// Tenant users only flush the names of their zones.
+func (s *Server) flushCache(w http.ResponseWriter, r *http.Request) {
+	name, suffix, all := r.FormValue("name"), r.FormValue("suffix"), r.FormValue("all") == "true"
+	set := 0
+	for _, selected := range []bool{name != "", suffix != "", all} {
+		if selected {
+			set++
+		}
+	}
+	if set != 1 {
+		writeError(w, http.StatusBadRequest, errors.New("exactly one of name, suffix or all is required"))
+		return
+	}
+
+	scope := s.scope(r)
+	if !all && !scope.OwnsName(name+suffix) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s: %w", name+suffix, errNotOwned))
+		return
+	}
+
+	var n int
+	switch {
+	case all:
+		n = s.Cache.DeleteFunc(func(key string) bool { return scope.OwnsName(dns.KeyName(key)) })
+	case name != "":
+		n = dns.FlushName(s.Cache, name)
+	default:
+		n = dns.FlushSuffix(s.Cache, suffix)
+	}
+	u, _ := UserFrom(r.Context())
+	apiLog.Info("cache flushed", "user", u.Name, "name", name, "suffix", suffix, "all", all, "entries", n)
+	writeJSON(w, http.StatusOK, FlushResult{Flushed: n})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, Error{Error: err.Error()})
+}
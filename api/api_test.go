@@ -0,0 +1,219 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/detect"
+	"github.com/bernoussama/mercury/dns"
+)
+
+func testCache() *dns.RecordsCache {
+	c := &dns.RecordsCache{Records: make(map[string]dns.Message)}
+	for _, q := range []dns.Question{
+		{DomainName: "example.com.", QType: dns.TypeA, QClass: 1},
+		{DomainName: "example.com.", QType: dns.TypeAAAA, QClass: 1},
+		{DomainName: "www.example.com.", QType: dns.TypeA, QClass: 1},
+		{DomainName: "notexample.com.", QType: dns.TypeA, QClass: 1},
+		{DomainName: "example.org.", QType: dns.TypeA, QClass: 1},
+	} {
+		c.Set(dns.CacheKey(q, false), dns.Message{Question: q}, 60)
+	}
+	return c
+}
+
+func TestFlushCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  url.Values
+		status  int
+		flushed int
+		left    int
+	}{
+		{"by name", url.Values{"name": {"Example.com"}}, http.StatusOK, 2, 3},
+		{"by suffix", url.Values{"suffix": {"example.com."}}, http.StatusOK, 3, 2},
+		{"all", url.Values{"all": {"true"}}, http.StatusOK, 5, 0},
+		{"missing selector", url.Values{}, http.StatusBadRequest, 0, 5},
+		{"several selectors", url.Values{"name": {"a."}, "all": {"true"}}, http.StatusBadRequest, 0, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testCache()
+			req := httptest.NewRequest(http.MethodPost, "/api/cache/flush?"+tt.params.Encode(), nil)
+			rec := httptest.NewRecorder()
+			New(Options{}, c).ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK {
+				var res FlushResult
+				if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+					t.Fatal(err)
+				}
+				if res.Flushed != tt.flushed {
+					t.Errorf("flushed = %d, want %d", res.Flushed, tt.flushed)
+				}
+			}
+			if len(c.Records) != tt.left {
+				t.Errorf("%d entries left, want %d", len(c.Records), tt.left)
+			}
+		})
+	}
+}
+
+func TestFlushCacheMethod(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New(Options{}, testCache()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/cache/flush?all=true", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestListCache(t *testing.T) {
+	c := testCache()
+	c.Get(dns.CacheKey(dns.Question{DomainName: "www.example.com.", QType: dns.TypeA, QClass: 1}, false))
+
+	tests := []struct {
+		name   string
+		params url.Values
+		status int
+		total  int
+		names  []string
+	}{
+		{"all", url.Values{}, http.StatusOK, 5, []string{"example.com.", "example.com.", "example.org.", "notexample.com.", "www.example.com."}},
+		{"suffix", url.Values{"suffix": {"example.com"}}, http.StatusOK, 3, []string{"example.com.", "example.com.", "www.example.com."}},
+		{"page", url.Values{"offset": {"1"}, "limit": {"2"}}, http.StatusOK, 5, []string{"example.com.", "example.org."}},
+		{"past the end", url.Values{"offset": {"10"}}, http.StatusOK, 5, nil},
+		{"bad limit", url.Values{"limit": {"0"}}, http.StatusBadRequest, 0, nil},
+		{"bad offset", url.Values{"offset": {"-1"}}, http.StatusBadRequest, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			New(Options{}, c).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/cache?"+tt.params.Encode(), nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var list CacheList
+			if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+				t.Fatal(err)
+			}
+			if list.Total != tt.total || len(list.Entries) != len(tt.names) {
+				t.Fatalf("got %d of %d entries, want %d of %d", len(list.Entries), list.Total, len(tt.names), tt.total)
+			}
+			for i, e := range list.Entries {
+				if e.Name != tt.names[i] {
+					t.Errorf("entry %d = %s, want %s", i, e.Name, tt.names[i])
+				}
+				if e.TTL <= 0 || e.TTL > 60 {
+					t.Errorf("entry %s ttl = %d, want within the 60s it was cached for", e.Key, e.TTL)
+				}
+				wantHits := uint64(0)
+				if e.Name == "www.example.com." {
+					wantHits = 1
+				}
+				if e.Hits != wantHits {
+					t.Errorf("entry %s hits = %d, want %d", e.Key, e.Hits, wantHits)
+				}
+			}
+		})
+	}
+}
+
+func TestGetCache(t *testing.T) {
+	c := testCache()
+	q := dns.Question{DomainName: "example.com.", QType: dns.TypeA, QClass: 1}
+	answer := dns.Answer{Name: []byte("\x07example\x03com\x00"), Type: uint16(dns.TypeA), Class: 1, TTL: 60, RDLength: 4, RData: []byte{192, 0, 2, 1}}
+	c.Set(dns.CacheKey(q, false), dns.Message{Question: q, Answers: []dns.Answer{answer}}, 60)
+
+	rec := httptest.NewRecorder()
+	New(Options{}, c).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/cache/entry?key="+url.QueryEscape(dns.CacheKey(q, false)), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		Name    string `json:"name"`
+		Answers int    `json:"answers"`
+		Message struct {
+			Question struct{ Name, Type string }
+			Answer   []struct{ Name, Type, Data string }
+		} `json:"message"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "example.com." || got.Answers != 1 || got.Message.Question.Type != "A" ||
+		len(got.Message.Answer) != 1 || got.Message.Answer[0].Data != "192.0.2.1" {
+		t.Errorf("entry = %+v", got)
+	}
+	if hits := c.Stats().Hits; hits != 0 {
+		t.Errorf("viewing the entry counted %d hits", hits)
+	}
+
+	rec = httptest.NewRecorder()
+	New(Options{}, c).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/cache/entry?key=missing./A/IN", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing key status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	c := testCache()
+	c.Get(dns.CacheKey(dns.Question{DomainName: "example.com.", QType: dns.TypeA, QClass: 1}, false))
+	c.Get("missing./A/IN")
+
+	rec := httptest.NewRecorder()
+	s := New(Options{}, c)
+	s.Instance = "ns1-ams"
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/cache/stats", nil))
+	var stats CacheStats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 5 || stats.Hits != 1 || stats.Misses != 1 || stats.HitRatio != 0.5 || stats.Bytes == 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.Instance != "ns1-ams" || rec.Header().Get(InstanceHeader) != "ns1-ams" {
+		t.Errorf("instance %q, header %q", stats.Instance, rec.Header().Get(InstanceHeader))
+	}
+}
+
+func TestVersion(t *testing.T) {
+	rec := httptest.NewRecorder()
+	s := New(Options{}, testCache())
+	s.Instance = "ns1-ams"
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+	var version Version
+	if err := json.NewDecoder(rec.Body).Decode(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version.Instance != "ns1-ams" || version.Version == "" || version.GoVersion == "" || len(version.Features) == 0 {
+		t.Errorf("version = %+v", version)
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	s := New(Options{}, testCache())
+	for _, want := range []int{0, 1} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/alerts", nil))
+		var alerts []detect.Alert
+		if err := json.NewDecoder(rec.Body).Decode(&alerts); err != nil {
+			t.Fatal(err)
+		}
+		if len(alerts) != want {
+			t.Errorf("%d alerts, want %d", len(alerts), want)
+		}
+		s.Detector = detect.New(detect.Options{Enabled: true})
+		s.Detector.Check(net.ParseIP("192.168.1.20"), "xjkqzhfslvbt.com.", time.Now())
+	}
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Role is what a user may do through the API
+type Role string
+
+const (
+	// RoleRead may call the endpoints that only inspect state
+	RoleRead Role = "read"
+	// RoleAdmin may call every endpoint
+	RoleAdmin Role = "admin"
+	// RoleACME may only publish ACME challenge records, for certificate
+	// clients
+	RoleACME Role = "acme"
+)
+
+// Allows reports whether r grants the access required
+func (r Role) Allows(required Role) bool {
+	return r == RoleAdmin || r == required
+}
+
+// Options configures the admin API
+type Options struct {
+	// Listen is the address of the API, empty to disable it
+	Listen string     `yaml:"listen"`
+	TLS    TLSOptions `yaml:"tls"`
+	// Users may call the API. Without users every client is an admin,
+	// which is only allowed on loopback addresses.
+	Users []User `yaml:"users"`
+	// Tenants own zones and blocklist categories, and scope the users
+	// belonging to them
+	Tenants []Tenant `yaml:"tenants"`
+}
+
+// TLSOptions serves the API over HTTPS. Setting ClientCA requires clients
+// to present a certificate it signed.
+type TLSOptions struct {
+	Cert     string `yaml:"cert"`
+	Key      string `yaml:"key"`
+	ClientCA string `yaml:"client_ca"`
+	// ACME serves the certificate obtained by the acme settings instead
+	// of Cert and Key
+	ACME bool `yaml:"acme"`
+}
+
+// Enabled reports whether the API is served over HTTPS
+func (t TLSOptions) Enabled() bool {
+	return t.Cert != "" || t.ACME
+}
+
+// User is an API client, identified by a bearer token or by the common
+// name of its client certificate
+type User struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+	Cert  string `yaml:"cert"`
+	Role  Role   `yaml:"role"`
+	// Tenant limits the user to the zones and categories of a tenant,
+	// empty for users of the whole server
+	Tenant string `yaml:"tenant"`
+}
+
+// UnmarshalYAML also accepts a bare address as a shorthand for listen
+func (o *Options) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&o.Listen)
+	}
+	type options Options
+	return node.Decode((*options)(o))
+}
+
+// Validate reports problems with the options without applying them
+func (o Options) Validate() []error {
+	var errs []error
+	if o.Listen == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(o.Listen)
+	if err != nil {
+		return append(errs, fmt.Errorf("invalid listen address %q: %v", o.Listen, err))
+	}
+	if ip := net.ParseIP(host); len(o.Users) == 0 && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		errs = append(errs, fmt.Errorf("listening on %s needs users, only loopback addresses may go without authentication", o.Listen))
+	}
+	if (o.TLS.Cert == "") != (o.TLS.Key == "") {
+		errs = append(errs, errors.New("tls needs both cert and key"))
+	}
+	if o.TLS.ClientCA != "" && !o.TLS.Enabled() {
+		errs = append(errs, errors.New("tls client_ca needs cert and key, or acme"))
+	}
+	if o.TLS.ACME && o.TLS.Cert != "" {
+		errs = append(errs, errors.New("tls takes cert and key or acme, not both"))
+	}
+	for i, u := range o.Users {
+		if u.Role != RoleRead && u.Role != RoleAdmin && u.Role != RoleACME {
+			errs = append(errs, fmt.Errorf("user %d: unknown role %q, want read, admin or acme", i, u.Role))
+		}
+		if u.Token == "" && u.Cert == "" {
+			errs = append(errs, fmt.Errorf("user %d: needs a token or a cert", i))
+		}
+		if u.Cert != "" && o.TLS.ClientCA == "" {
+			errs = append(errs, fmt.Errorf("user %d: cert authentication needs tls client_ca", i))
+		}
+	}
+	return append(errs, o.validateTenants()...)
+}
+
+// tlsConfig returns the server TLS config, nil when serving plain HTTP.
+// getCertificate serves the acme certificate.
+func (o Options) tlsConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (*tls.Config, error) {
+	if !o.TLS.Enabled() {
+		return nil, nil
+	}
+	// h2 carries the gRPC service
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, NextProtos: []string{"h2", "http/1.1"}}
+	if o.TLS.ACME {
+		if getCertificate == nil {
+			return nil, errors.New("tls acme needs the acme settings")
+		}
+		cfg.GetCertificate = getCertificate
+	} else {
+		cert, err := tls.LoadX509KeyPair(o.TLS.Cert, o.TLS.Key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if o.TLS.ClientCA != "" {
+		pem, err := os.ReadFile(o.TLS.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", o.TLS.ClientCA)
+		}
+		cfg.ClientCAs = pool
+		// token users may still connect without a certificate
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+type userKey struct{}
+
+// UserFrom returns the authenticated user of a request
+func UserFrom(ctx context.Context) (User, bool) {
+	u, ok := ctx.Value(userKey{}).(User)
+	return u, ok
+}
+
+// TokenUser returns the user authenticated by a bearer token
+func (o Options) TokenUser(token string) (User, bool) {
+	for _, u := range o.Users {
+		if u.Token != "" && subtle.ConstantTimeCompare([]byte(u.Token), []byte(token)) == 1 {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+// authenticate finds the user making the request
+func (s *Server) authenticate(r *http.Request) (User, bool) {
+	if len(s.opts.Users) == 0 {
+		return User{Name: "local", Role: RoleAdmin}, true
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		return s.opts.TokenUser(token)
+	}
+	// ACME clients pass the token as the basic auth password or, like
+	// acme-dns, in the X-Api-Key header
+	if _, token, ok := r.BasicAuth(); ok && token != "" {
+		return s.opts.TokenUser(token)
+	}
+	if token := r.Header.Get("X-Api-Key"); token != "" {
+		return s.opts.TokenUser(token)
+	}
+	// Pi-hole clients pass the token as the auth param
+	if token := r.URL.Query().Get("auth"); token != "" && r.URL.Path == "/admin/api.php" {
+		return s.opts.TokenUser(token)
+	}
+	// the TLS stack has verified the chain against the client CA
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		for _, u := range s.opts.Users {
+			if u.Cert != "" && u.Cert == cn {
+				return u, true
+			}
+		}
+	}
+	return User{}, false
+}
+
+// handle registers an endpoint callable by users with the required role,
+// except tenant users
+func (s *Server) handle(pattern string, required Role, h http.HandlerFunc) {
+	s.register(pattern, required, false, h)
+}
+
+// handleScoped registers an endpoint tenant users may call too, which
+// limits them to what their tenant owns
+func (s *Server) handleScoped(pattern string, required Role, h http.HandlerFunc) {
+	s.register(pattern, required, true, h)
+}
+
+func (s *Server) register(pattern string, required Role, scoped bool, h http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		u, ok := s.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mercury"`)
+			writeError(w, http.StatusUnauthorized, errors.New("authentication required"))
+			return
+		}
+		if !u.Role.Allows(required) {
+			apiLog.Warn("access denied", "user", u.Name, "path", r.URL.Path)
+			writeError(w, http.StatusForbidden, fmt.Errorf("%s role required", required))
+			return
+		}
+		if u.Tenant != "" && !scoped {
+			apiLog.Warn("access denied", "user", u.Name, "tenant", u.Tenant, "path", r.URL.Path)
+			writeError(w, http.StatusForbidden, errors.New("not available to tenant users"))
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), userKey{}, u)))
+	})
+}
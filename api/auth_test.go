@@ -0,0 +1,178 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var testUsers = []User{
+	{Name: "viewer", Token: "read-token", Role: RoleRead},
+	{Name: "ops", Token: "admin-token", Role: RoleAdmin},
+	{Name: "robot", Cert: "robot.example.com", Role: RoleAdmin},
+}
+
+func TestTokenRoles(t *testing.T) {
+	s := New(Options{Users: testUsers}, testCache())
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"no token", http.MethodGet, "/api/cache/stats", "", http.StatusUnauthorized},
+		{"unknown token", http.MethodGet, "/api/cache/stats", "nope", http.StatusUnauthorized},
+		{"reader reads", http.MethodGet, "/api/cache/stats", "read-token", http.StatusOK},
+		{"reader flushes", http.MethodPost, "/api/cache/flush?all=true", "read-token", http.StatusForbidden},
+		{"admin reads", http.MethodGet, "/api/cache", "admin-token", http.StatusOK},
+		{"admin flushes", http.MethodPost, "/api/cache/flush?all=true", "admin-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+// newCert returns a certificate for cn signed by parent, self signed when
+// parent is nil
+func newCert(t *testing.T, cn string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, any(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientCertificates(t *testing.T) {
+	ca := newCert(t, "test ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	srv := httptest.NewUnstartedServer(New(Options{Users: testUsers}, testCache()))
+	srv.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	srv.StartTLS()
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		cert   *tls.Certificate
+		status int
+	}{
+		{"known common name", ptr(newCert(t, "robot.example.com", &ca)), http.StatusOK},
+		{"unknown common name", ptr(newCert(t, "stranger.example.com", &ca)), http.StatusUnauthorized},
+		{"no certificate", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpClient := srv.Client()
+			transport := httpClient.Transport.(*http.Transport).Clone()
+			if tt.cert != nil {
+				transport.TLSClientConfig.Certificates = []tls.Certificate{*tt.cert}
+			}
+			httpClient.Transport = transport
+			resp, err := httpClient.Post(srv.URL+"/api/cache/flush?all=true", "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+
+	// a certificate from another CA is either not sent or fails the handshake
+	other := newCert(t, "robot.example.com", ptr(newCert(t, "other ca", nil)))
+	httpClient := srv.Client()
+	transport := httpClient.Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{other}
+	httpClient.Transport = transport
+	if resp, err := httpClient.Get(srv.URL + "/api/cache"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("certificate from an unknown CA: status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		errs int
+	}{
+		{"disabled", Options{}, 0},
+		{"loopback without users", Options{Listen: "127.0.0.1:53180"}, 0},
+		{"localhost without users", Options{Listen: "localhost:53180"}, 0},
+		{"exposed without users", Options{Listen: "0.0.0.0:53180"}, 1},
+		{"exposed with users", Options{Listen: "0.0.0.0:53180", Users: testUsers[:2]}, 0},
+		{"bad role", Options{Listen: "127.0.0.1:1", Users: []User{{Token: "x", Role: "root"}}}, 1},
+		{"no credentials", Options{Listen: "127.0.0.1:1", Users: []User{{Role: RoleRead}}}, 1},
+		{"cert without client ca", Options{Listen: "127.0.0.1:1", Users: testUsers}, 1},
+		{"key without cert", Options{Listen: "127.0.0.1:1", TLS: TLSOptions{Key: "k"}}, 1},
+	}
+	for _, tt := range tests {
+		if errs := tt.opts.Validate(); len(errs) != tt.errs {
+			t.Errorf("%s: Validate() = %v, want %d errors", tt.name, errs, tt.errs)
+		}
+	}
+}
+
+func TestOptionsShorthand(t *testing.T) {
+	var cfg struct {
+		Short Options `yaml:"short"`
+		Long  Options `yaml:"long"`
+	}
+	data := "short: 127.0.0.1:1\nlong:\n  listen: 127.0.0.1:2\n  users:\n    - token: x\n      role: read\n"
+	if err := yaml.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Short.Listen != "127.0.0.1:1" || cfg.Long.Listen != "127.0.0.1:2" || len(cfg.Long.Users) != 1 {
+		t.Errorf("decoded %+v", cfg)
+	}
+}
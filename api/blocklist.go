@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bernoussama/mercury/blocklist"
+)
+
+var errNoSinkhole = errors.New("sinkhole disabled")
+
+// blocklistStats replies with the sinkhole stats, limited to the lists and
+// categories of their tenant for tenant users
+func (s *Server) blocklistStats(w http.ResponseWriter, r *http.Request) {
+	if s.Sinkhole == nil {
+		writeError(w, http.StatusNotFound, errNoSinkhole)
+		return
+	}
+	stats := s.Sinkhole.Stats()
+	if scope := s.scope(r); scope != nil {
+		// groups and pauses span every tenant
+		scoped := blocklist.Stats{
+			Lists:         slices.DeleteFunc(stats.Lists, func(l blocklist.ListStats) bool { return !scope.OwnsCategory(l.Category) }),
+			Categories:    make(map[string]uint64),
+			Groups:        []blocklist.GroupStats{},
+			Allowed:       map[string]time.Time{},
+			PausedClients: map[string]time.Time{},
+			DryRun:        stats.DryRun,
+		}
+		for category, blocked := range stats.Categories {
+			if scope.OwnsCategory(category) {
+				scoped.Categories[category] = blocked
+			}
+		}
+		stats = scoped
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// setCategory enables or disables a category for the group param, the
+// default group when empty, and replies with the group
+func (s *Server) setCategory(w http.ResponseWriter, r *http.Request) {
+	if s.Sinkhole == nil {
+		writeError(w, http.StatusNotFound, errNoSinkhole)
+		return
+	}
+	group, category := r.FormValue("group"), r.FormValue("category")
+	enabled, err := strconv.ParseBool(r.FormValue("enabled"))
+	if category == "" || err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("category and enabled=true|false are required"))
+		return
+	}
+	if !s.scope(r).OwnsCategory(category) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("category %s: %w", category, errNotOwned))
+		return
+	}
+	stats, err := s.Sinkhole.SetCategory(group, category, enabled)
+	if errors.Is(err, blocklist.ErrUnknownGroup) || errors.Is(err, blocklist.ErrUnknownCategory) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	u, _ := UserFrom(r.Context())
+	apiLog.Info("blocklist category set", "user", u.Name, "group", stats.Name, "category", category, "enabled", enabled)
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// pauseBlocking stops blocking for the duration param, for the client param
+// or every client when empty, and replies with when blocking resumes
+func (s *Server) pauseBlocking(w http.ResponseWriter, r *http.Request) {
+	if s.Sinkhole == nil {
+		writeError(w, http.StatusNotFound, errNoSinkhole)
+		return
+	}
+	d, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("duration is required, 0 to resume blocking"))
+		return
+	}
+	var client net.IP
+	if c := r.FormValue("client"); c != "" {
+		if client = net.ParseIP(c); client == nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid client address %q", c))
+			return
+		}
+	}
+	until := s.Sinkhole.Pause(client, d)
+	u, _ := UserFrom(r.Context())
+	apiLog.Info("blocking paused", "user", u.Name, "client", r.FormValue("client"), "until", until)
+	writeJSON(w, http.StatusOK, Pause{Client: r.FormValue("client"), Until: until})
+}
+
+// Pause reports when paused blocking resumes for a client, or every client
+// when Client is empty
+type Pause struct {
+	Client string    `json:"client,omitempty"`
+	Until  time.Time `json:"until"`
+}
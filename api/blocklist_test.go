@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/bernoussama/mercury/blocklist"
+)
+
+func TestSetCategory(t *testing.T) {
+	ads := blocklist.NewHash()
+	ads.Add("ads.example.com.")
+	sinkhole := blocklist.NewSinkhole()
+	sinkhole.Set("ads", "ads", ads)
+	sinkhole.SetGroups([]blocklist.GroupOptions{{Name: "office", Clients: []string{"10.0.0.0/8"}}})
+	srv := New(Options{}, testCache())
+	srv.Sinkhole = sinkhole
+
+	tests := []struct {
+		name   string
+		params url.Values
+		status int
+	}{
+		{"disable", url.Values{"group": {"office"}, "category": {"ads"}, "enabled": {"false"}}, http.StatusOK},
+		{"default group", url.Values{"category": {"ads"}, "enabled": {"true"}}, http.StatusOK},
+		{"unknown group", url.Values{"group": {"guests"}, "category": {"ads"}, "enabled": {"false"}}, http.StatusNotFound},
+		{"unknown category", url.Values{"category": {"adult"}, "enabled": {"false"}}, http.StatusNotFound},
+		{"missing enabled", url.Values{"category": {"ads"}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/blocklist/categories?"+tt.params.Encode(), nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/blocklist", nil))
+	var stats blocklist.Stats
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Groups) != 2 || len(stats.Groups[1].Disabled) != 1 || len(stats.Groups[0].Disabled) != 0 {
+		t.Errorf("groups %+v", stats.Groups)
+	}
+}
+
+func TestPauseBlocking(t *testing.T) {
+	ads := blocklist.NewHash()
+	ads.Add("ads.example.com.")
+	srv := New(Options{}, testCache())
+	srv.Sinkhole = blocklist.NewSinkhole()
+	srv.Sinkhole.Set("ads", "ads", ads)
+
+	tests := []struct {
+		name   string
+		params url.Values
+		status int
+	}{
+		{"client", url.Values{"duration": {"5m"}, "client": {"192.168.1.42"}}, http.StatusOK},
+		{"everyone", url.Values{"duration": {"5m"}}, http.StatusOK},
+		{"bad client", url.Values{"duration": {"5m"}, "client": {"laptop"}}, http.StatusBadRequest},
+		{"missing duration", url.Values{}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/blocklist/pause?"+tt.params.Encode(), nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+	if _, blocked := srv.Sinkhole.Match(nil, "ads.example.com."); blocked {
+		t.Error("blocking not paused")
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/blocklist/pause?duration=0", nil))
+	var pause Pause
+	if err := json.NewDecoder(rec.Body).Decode(&pause); err != nil || pause.Client != "" {
+		t.Fatalf("resume replied %v %+v", err, pause)
+	}
+	if _, blocked := srv.Sinkhole.Match(nil, "ads.example.com."); !blocked {
+		t.Error("blocking not resumed")
+	}
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/bernoussama/mercury/clients"
+)
+
+// ClientList is one page of the clients, the busiest first
+type ClientList struct {
+	Total   int             `json:"total"`
+	Offset  int             `json:"offset"`
+	Clients []clients.Stats `json:"clients"`
+}
+
+// listClients lists the clients with their names and query counts,
+// paginated with offset and limit
+func (s *Server) listClients(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := pagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	stats := s.Clients.Stats()
+	writeJSON(w, http.StatusOK, ClientList{Total: len(stats), Offset: offset, Clients: paginate(stats, offset, limit)})
+}
+
+// listAlerts lists the alerts of the detection, the latest first, none
+// when it is disabled
+func (s *Server) listAlerts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Detector.Alerts())
+}
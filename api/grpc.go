@@ -0,0 +1,226 @@
+package api
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/bernoussama/mercury/buildinfo"
+	"github.com/bernoussama/mercury/querylog"
+)
+
+// GRPCService is the path prefix of the methods of the gRPC admin service,
+// described by admin.proto
+const GRPCService = "/mercury.admin.v1.Admin/"
+
+// gRPC status codes
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcFailedPrecondition = 9
+)
+
+// maxGRPCMessage bounds the request messages read
+const maxGRPCMessage = 1 << 20
+
+// grpcVersion answers the Version method with the build of the server
+func (s *Server) grpcVersion(w http.ResponseWriter, r *http.Request) {
+	if _, err := readGRPCMessage(r.Body); err != nil {
+		writeGRPCStatus(w, grpcInvalidArgument, err.Error())
+		return
+	}
+	info := buildinfo.Get()
+	var msg []byte
+	msg = appendProtoString(msg, 1, info.Version)
+	msg = appendProtoString(msg, 2, info.Commit)
+	msg = appendProtoString(msg, 3, s.Instance)
+	startGRPC(w)
+	w.Write(grpcFrame(msg))
+	writeGRPCStatus(w, grpcOK, "")
+}
+
+// grpcTap answers the Tap method, streaming a QueryEvent per query
+// answered until the client cancels the call
+func (s *Server) grpcTap(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		writeError(w, http.StatusHTTPVersionNotSupported, errors.New("gRPC needs HTTP/2, serve the admin API over TLS"))
+		return
+	}
+	req, err := readGRPCMessage(r.Body)
+	if err != nil {
+		writeGRPCStatus(w, grpcInvalidArgument, err.Error())
+		return
+	}
+	filter, err := decodeTapRequest(req)
+	if err != nil {
+		writeGRPCStatus(w, grpcInvalidArgument, err.Error())
+		return
+	}
+	if s.Tap == nil {
+		writeGRPCStatus(w, grpcFailedPrecondition, errNoTap.Error())
+		return
+	}
+	tap := s.Tap
+	sub := tap.Subscribe(filter)
+	defer tap.Unsubscribe(sub)
+	u, _ := UserFrom(r.Context())
+	apiLog.Info("query tap opened", "user", u.Name, "client", filter.Client, "suffix", filter.Suffix, "api", "grpc")
+
+	startGRPC(w)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-sub.Entries:
+			if _, err := w.Write(grpcFrame(encodeQueryEvent(e))); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// startGRPC sends the headers of a gRPC reply
+func startGRPC(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+}
+
+// writeGRPCStatus ends a gRPC reply with its status in the trailers, or in
+// the headers of a reply without messages
+func writeGRPCStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", message)
+	}
+}
+
+// readGRPCMessage reads the one message of a unary or server-streaming
+// call
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, fmt.Errorf("reading request message: %w", err)
+	}
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > maxGRPCMessage {
+		return nil, fmt.Errorf("request message of %d bytes is too large", n)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, fmt.Errorf("reading request message: %w", err)
+	}
+	return msg, nil
+}
+
+// grpcFrame returns msg prefixed as an uncompressed gRPC message
+func grpcFrame(msg []byte) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+// decodeTapRequest returns the filter of a TapRequest
+func decodeTapRequest(msg []byte) (querylog.Filter, error) {
+	var f querylog.Filter
+	err := rangeProto(msg, func(field int, value []byte) {
+		switch field {
+		case 1:
+			f.Client = string(value)
+		case 2:
+			f.Suffix = string(value)
+		}
+	})
+	return f, err
+}
+
+// encodeQueryEvent returns e as a QueryEvent
+func encodeQueryEvent(e querylog.Entry) []byte {
+	var b []byte
+	b = appendProtoInt(b, 1, e.Time.UnixNano())
+	b = appendProtoString(b, 2, e.Client)
+	b = appendProtoString(b, 3, e.Device)
+	b = appendProtoString(b, 4, e.Name)
+	b = appendProtoString(b, 5, e.Type)
+	b = appendProtoString(b, 6, e.Rcode)
+	b = appendProtoInt(b, 7, int64(e.Answers))
+	b = appendProtoString(b, 8, e.Source)
+	b = appendProtoString(b, 9, e.Category)
+	b = appendProtoInt(b, 10, int64(e.Duration))
+	return b
+}
+
+// protobuf wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func appendProtoInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field<<3|wireVarint))
+	return binary.AppendUvarint(b, uint64(v))
+}
+
+func appendProtoString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field<<3|wireBytes))
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// rangeProto calls fn with the length-delimited fields of a protobuf
+// message, skipping the others
+func rangeProto(msg []byte, fn func(field int, value []byte)) error {
+	errMalformed := errors.New("malformed protobuf message")
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errMalformed
+		}
+		msg = msg[n:]
+		field := int(tag >> 3)
+		switch tag & 7 {
+		case wireVarint:
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				return errMalformed
+			}
+			msg = msg[n:]
+		case 1, 5:
+			size := 8
+			if tag&7 == 5 {
+				size = 4
+			}
+			if len(msg) < size {
+				return errMalformed
+			}
+			msg = msg[size:]
+		case wireBytes:
+			length, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < length {
+				return errMalformed
+			}
+			fn(field, msg[n:n+int(length)])
+			msg = msg[n+int(length):]
+		default:
+			return errMalformed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PiholeSummary is the reply of the summaryRaw query of Pi-hole's
+// /admin/api.php, for the dashboards and integrations reading it. The
+// counts are since the server started rather than since midnight.
+type PiholeSummary struct {
+	DomainsBeingBlocked int     `json:"domains_being_blocked"`
+	DNSQueriesToday     uint64  `json:"dns_queries_today"`
+	AdsBlockedToday     uint64  `json:"ads_blocked_today"`
+	AdsPercentageToday  float64 `json:"ads_percentage_today"`
+	// UniqueDomains counts the cached answers, mercury keeps no list of
+	// the names queried
+	UniqueDomains    int    `json:"unique_domains"`
+	QueriesForwarded uint64 `json:"queries_forwarded"`
+	QueriesCached    uint64 `json:"queries_cached"`
+	ClientsEverSeen  int    `json:"clients_ever_seen"`
+	UniqueClients    int    `json:"unique_clients"`
+	Status           string `json:"status"`
+}
+
+// PiholeStatus is the reply of the status, enable and disable queries
+type PiholeStatus struct {
+	Status string `json:"status"`
+}
+
+// pihole answers the queries of Pi-hole's /admin/api.php that mercury has
+// an equivalent for: summaryRaw, the default, summary with its numbers
+// formatted, status, and enable and disable=<seconds> pausing blocking for
+// admin users. Other queries get an empty list, like from Pi-hole.
+func (s *Server) pihole(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch {
+	case query.Has("enable"), query.Has("disable"):
+		if u, _ := UserFrom(r.Context()); !u.Role.Allows(RoleAdmin) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("%s role required", RoleAdmin))
+			return
+		}
+		if s.Sinkhole == nil {
+			writeError(w, http.StatusNotFound, errNoSinkhole)
+			return
+		}
+		var d time.Duration
+		if query.Has("disable") {
+			// Pi-hole disables blocking until enabled again without seconds
+			seconds, err := strconv.Atoi(query.Get("disable"))
+			if err != nil || seconds <= 0 {
+				seconds = 365 * 24 * 3600
+			}
+			d = time.Duration(seconds) * time.Second
+		}
+		until := s.Sinkhole.Pause(nil, d)
+		u, _ := UserFrom(r.Context())
+		apiLog.Info("blocking paused", "user", u.Name, "until", until, "api", "pihole")
+		writeJSON(w, http.StatusOK, PiholeStatus{Status: s.piholeStatus()})
+	case query.Has("status"):
+		writeJSON(w, http.StatusOK, PiholeStatus{Status: s.piholeStatus()})
+	case query.Has("summary"):
+		writeJSON(w, http.StatusOK, s.piholeSummary().formatted())
+	case query.Has("summaryRaw"), len(query) == 0, len(query) == 1 && query.Has("auth"):
+		writeJSON(w, http.StatusOK, s.piholeSummary())
+	default:
+		writeJSON(w, http.StatusOK, []any{})
+	}
+}
+
+// piholeStatus returns enabled while blocking, disabled while paused or
+// without a sinkhole
+func (s *Server) piholeStatus() string {
+	if s.Sinkhole == nil || s.Sinkhole.Paused() {
+		return "disabled"
+	}
+	return "enabled"
+}
+
+func (s *Server) piholeSummary() PiholeSummary {
+	cacheStats := s.Cache.Stats()
+	summary := PiholeSummary{
+		UniqueDomains:    cacheStats.Entries,
+		QueriesCached:    cacheStats.Hits,
+		QueriesForwarded: cacheStats.Misses,
+		Status:           s.piholeStatus(),
+	}
+	if s.Sinkhole != nil {
+		stats := s.Sinkhole.Stats()
+		for _, list := range stats.Lists {
+			summary.DomainsBeingBlocked += list.Names
+		}
+		for _, blocked := range stats.Categories {
+			summary.AdsBlockedToday += blocked
+		}
+	}
+	clients := s.Clients.Stats()
+	summary.ClientsEverSeen, summary.UniqueClients = len(clients), len(clients)
+	if s.Listeners != nil {
+		for _, listener := range s.Listeners() {
+			summary.DNSQueriesToday += listener.Queries
+		}
+	} else {
+		for _, client := range clients {
+			summary.DNSQueriesToday += client.Queries
+		}
+	}
+	if summary.DNSQueriesToday > 0 {
+		summary.AdsPercentageToday = 100 * float64(summary.AdsBlockedToday) / float64(summary.DNSQueriesToday)
+	}
+	return summary
+}
+
+// formatted returns the summary as the summary query has it, numbers as
+// strings with thousands separators and the percentage with one decimal
+func (p PiholeSummary) formatted() map[string]string {
+	return map[string]string{
+		"domains_being_blocked": thousands(uint64(p.DomainsBeingBlocked)),
+		"dns_queries_today":     thousands(p.DNSQueriesToday),
+		"ads_blocked_today":     thousands(p.AdsBlockedToday),
+		"ads_percentage_today":  strconv.FormatFloat(p.AdsPercentageToday, 'f', 1, 64),
+		"unique_domains":        thousands(uint64(p.UniqueDomains)),
+		"queries_forwarded":     thousands(p.QueriesForwarded),
+		"queries_cached":        thousands(p.QueriesCached),
+		"clients_ever_seen":     thousands(uint64(p.ClientsEverSeen)),
+		"unique_clients":        thousands(uint64(p.UniqueClients)),
+		"status":                p.Status,
+	}
+}
+
+// thousands formats n with commas between groups of three digits
+func thousands(n uint64) string {
+	digits := strconv.FormatUint(n, 10)
+	b := make([]byte, 0, len(digits)+len(digits)/3)
+	for i := range len(digits) {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b = append(b, ',')
+		}
+		b = append(b, digits[i])
+	}
+	return string(b)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bernoussama/mercury/blocklist"
+)
+
+func TestPihole(t *testing.T) {
+	ads := blocklist.NewHash()
+	ads.Add("ads.example.com.")
+	ads.Add("track.example.com.")
+	srv := New(Options{Users: testUsers}, testCache())
+	srv.Sinkhole = blocklist.NewSinkhole()
+	srv.Sinkhole.Set("ads", "ads", ads)
+	srv.Sinkhole.Match(nil, "ads.example.com.")
+	srv.Listeners = func() []ListenerStats {
+		return []ListenerStats{{Name: "udp", Queries: 1200}, {Name: "tcp", Queries: 800}}
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   string
+	}{
+		{"no token", "summaryRaw", http.StatusUnauthorized, ""},
+		{"raw", "summaryRaw&auth=read-token", http.StatusOK, `"domains_being_blocked":2,"dns_queries_today":2000,"ads_blocked_today":1,"ads_percentage_today":0.05`},
+		{"default", "auth=read-token", http.StatusOK, `"dns_queries_today":2000`},
+		{"formatted", "summary&auth=read-token", http.StatusOK, `"dns_queries_today":"2,000"`},
+		{"status", "status&auth=read-token", http.StatusOK, `{"status":"enabled"}`},
+		{"reader disables", "disable=60&auth=read-token", http.StatusForbidden, ""},
+		{"admin disables", "disable=60&auth=admin-token", http.StatusOK, `{"status":"disabled"}`},
+		{"admin enables", "enable&auth=admin-token", http.StatusOK, `{"status":"enabled"}`},
+		{"unknown", "topItems&auth=read-token", http.StatusOK, `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/api.php?"+tt.query, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if !json.Valid(rec.Body.Bytes()) || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("body %s, want %s", rec.Body, tt.want)
+			}
+		})
+	}
+
+	// the token is only taken from the query by the Pi-hole endpoint
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/cache/stats?auth=read-token", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("auth param accepted by /api/cache/stats: status %d", rec.Code)
+	}
+}
+
+func TestThousands(t *testing.T) {
+	for n, want := range map[uint64]string{0: "0", 999: "999", 1000: "1,000", 123456: "123,456", 1234567: "1,234,567"} {
+		if got := thousands(n); got != want {
+			t.Errorf("thousands(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bernoussama/mercury/dns"
+)
+
+// ReloadSummary tells what a reload changed, the reply of POST /api/reload
+type ReloadSummary struct {
+	Zones Changes `json:"zones"`
+	// ZoneDiffs are the records changed in each zone changed
+	ZoneDiffs  []dns.ZoneDiff    `json:"zone_diffs"`
+	Blocklists []BlocklistChange `json:"blocklists"`
+	Feeds      Changes           `json:"threat_feeds"`
+	Groups     Changes           `json:"client_groups"`
+	// RestartNeeded are the changed settings only a restart applies
+	RestartNeeded []string `json:"restart_needed"`
+}
+
+// Changes names what a reload added, removed and changed
+type Changes struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+// Empty reports whether nothing changed
+func (c Changes) Empty() bool {
+	return len(c.Added)+len(c.Removed)+len(c.Changed) == 0
+}
+
+// BlocklistChange counts the names of a blocklist category before and
+// after a reload, and those it gained and lost
+type BlocklistChange struct {
+	Category string `json:"category"`
+	Before   int    `json:"before"`
+	After    int    `json:"after"`
+	Added    int    `json:"added"`
+	Removed  int    `json:"removed"`
+}
+
+// reload applies the config file again, replying with what changed or
+// with why nothing did
+func (s *Server) reload(w http.ResponseWriter, r *http.Request) {
+	if s.Reload == nil {
+		writeError(w, http.StatusNotFound, errors.New("reload unavailable"))
+		return
+	}
+	summary, err := s.Reload()
+	u, _ := UserFrom(r.Context())
+	if err != nil {
+		apiLog.Warn("reload failed", "user", u.Name, "err", err)
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	apiLog.Info("config reloaded", "user", u.Name, "restart_needed", summary.RestartNeeded)
+	writeJSON(w, http.StatusOK, summary)
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	srv := New(Options{}, testCache())
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/reload", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("without reload: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	var fail bool
+	srv.Reload = func() (ReloadSummary, error) {
+		if fail {
+			return ReloadSummary{}, errors.New("config.yml:3: invalid CIDR")
+		}
+		return ReloadSummary{Zones: Changes{Added: []string{"example.com."}}, RestartNeeded: []string{"listen"}}, nil
+	}
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/reload", nil))
+	var summary ReloadSummary
+	if err := json.NewDecoder(rec.Body).Decode(&summary); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(summary.Zones.Added) != 1 || !summary.Feeds.Empty() {
+		t.Errorf("status %d, summary %+v", rec.Code, summary)
+	}
+
+	fail = true
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/reload", nil))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("failed reload: status %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
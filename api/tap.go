@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/bernoussama/mercury/querylog"
+)
+
+var errNoTap = errors.New("query tap disabled")
+
+// tapFilter reads the client and suffix params selecting the queries
+// streamed
+func tapFilter(r *http.Request) querylog.Filter {
+	return querylog.Filter{Client: r.FormValue("client"), Suffix: r.FormValue("suffix")}
+}
+
+// streamTap streams the queries answered as they come, a JSON object per
+// line, filtered by the client and suffix params, until the client goes
+func (s *Server) streamTap(w http.ResponseWriter, r *http.Request) {
+	if s.Tap == nil {
+		writeError(w, http.StatusNotFound, errNoTap)
+		return
+	}
+	tap := s.Tap
+	sub := tap.Subscribe(tapFilter(r))
+	defer tap.Unsubscribe(sub)
+	u, _ := UserFrom(r.Context())
+	apiLog.Info("query tap opened", "user", u.Name, "client", r.FormValue("client"), "suffix", r.FormValue("suffix"))
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-sub.Entries:
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			// the entries that arrived meanwhile go out together
+			for len(sub.Entries) > 0 {
+				if err := enc.Encode(<-sub.Entries); err != nil {
+					return
+				}
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/privacy"
+	"github.com/bernoussama/mercury/querylog"
+)
+
+// feedTap adds entries to tap once it has a subscriber
+func feedTap(t *testing.T, tap *querylog.Tap, entries ...querylog.Entry) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !tap.Active() {
+		if time.Now().After(deadline) {
+			t.Fatal("nobody subscribed to the tap")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for _, e := range entries {
+		tap.Add(e)
+	}
+}
+
+func TestStreamTap(t *testing.T) {
+	s := New(Options{}, testCache())
+	s.Tap = querylog.NewTap(privacy.Options{})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/tap?suffix=example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	feedTap(t, s.Tap,
+		querylog.Entry{Client: "192.0.2.1", Name: "example.org.", Source: "upstream"},
+		querylog.Entry{Client: "192.0.2.1", Name: "www.example.com.", Source: "blocklist"})
+
+	var e querylog.Entry
+	if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Name != "www.example.com." || e.Source != "blocklist" {
+		t.Errorf("streamed %+v, want www.example.com.", e)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("content type %q", ct)
+	}
+}
+
+func TestGRPCTap(t *testing.T) {
+	s := New(Options{}, testCache())
+	s.Tap = querylog.NewTap(privacy.Options{})
+	s.Instance = "dns1"
+	srv := httptest.NewUnstartedServer(s)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	client := srv.Client()
+
+	call := func(method string, msg []byte) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+GRPCService+method, bytes.NewReader(grpcFrame(msg)))
+		req.Header.Set("Content-Type", "application/grpc")
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.ProtoMajor != 2 || res.StatusCode != http.StatusOK {
+			t.Fatalf("%s over %s: %s", method, res.Proto, res.Status)
+		}
+		return res
+	}
+
+	res := call("Version", nil)
+	io.ReadAll(res.Body)
+	res.Body.Close()
+	if status := res.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Errorf("Version status %q", status)
+	}
+
+	res = call("Tap", appendProtoString(nil, 2, "example.com"))
+	defer res.Body.Close()
+	feedTap(t, s.Tap,
+		querylog.Entry{Name: "example.org."},
+		querylog.Entry{Client: "192.0.2.1", Name: "example.com.", Answers: 2, Duration: time.Millisecond})
+
+	r := bufio.NewReader(res.Body)
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		t.Fatal(err)
+	}
+	fields := map[int]string{}
+	if err := rangeProto(msg, func(field int, value []byte) { fields[field] = string(value) }); err != nil || fields[2] != "192.0.2.1" || fields[4] != "example.com." {
+		t.Errorf("event fields %q, err %v", fields, err)
+	}
+}
+
+func TestGRPCTapNeedsHTTP2(t *testing.T) {
+	s := New(Options{}, testCache())
+	s.Tap = querylog.NewTap(privacy.Options{})
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, GRPCService+"Tap", bytes.NewReader(grpcFrame(nil))))
+	if rec.Code != http.StatusHTTPVersionNotSupported {
+		t.Errorf("status %d over HTTP/1.1, want %d", rec.Code, http.StatusHTTPVersionNotSupported)
+	}
+}
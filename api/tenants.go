@@ -0,0 +1,168 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/dns"
+	"github.com/bernoussama/mercury/fqdn"
+)
+
+// Tenant owns zones and blocklist categories no other tenant owns. Users of
+// a tenant only see and change what it owns.
+type Tenant struct {
+	Name string `yaml:"name"`
+	// Zones are the origins of the zones of the tenant, their cached
+	// answers included
+	Zones []string `yaml:"zones"`
+	// Categories are the blocklist and feed categories of the tenant
+	Categories []string `yaml:"categories"`
+}
+
+// OwnsName reports whether name is in a zone of the tenant. A nil tenant,
+// that of users of the whole server, owns every name.
+func (t *Tenant) OwnsName(name string) bool {
+	if t == nil {
+		return true
+	}
+	for _, origin := range t.Zones {
+		if dns.IsSubdomain(name, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// OwnsCategory reports whether the tenant owns a blocklist category, which
+// a nil tenant does for every category
+func (t *Tenant) OwnsCategory(category string) bool {
+	return t == nil || slices.Contains(t.Categories, category)
+}
+
+// validateTenants checks that tenants have unique names and own disjoint
+// zones and categories, and that users belong to a known tenant
+func (o Options) validateTenants() []error {
+	var errs []error
+	names := make(map[string]bool)
+	zones := make(map[string]string)
+	categories := make(map[string]string)
+	for i, t := range o.Tenants {
+		if t.Name == "" {
+			errs = append(errs, fmt.Errorf("tenant %d: needs a name", i))
+		} else if names[t.Name] {
+			errs = append(errs, fmt.Errorf("tenant %d: duplicate name %q", i, t.Name))
+		}
+		names[t.Name] = true
+		for _, zone := range t.Zones {
+			origin := string(fqdn.Canonical(zone))
+			for owned, owner := range zones {
+				if owner != t.Name && (dns.IsSubdomain(origin, owned) || dns.IsSubdomain(owned, origin)) {
+					errs = append(errs, fmt.Errorf("tenant %q: zone %s overlaps zone %s of tenant %q", t.Name, origin, owned, owner))
+				}
+			}
+			zones[origin] = t.Name
+		}
+		for _, category := range t.Categories {
+			if owner, ok := categories[category]; ok {
+				errs = append(errs, fmt.Errorf("tenant %q: category %s is owned by tenant %q", t.Name, category, owner))
+			}
+			categories[category] = t.Name
+		}
+	}
+	for i, u := range o.Users {
+		if u.Tenant != "" && !names[u.Tenant] {
+			errs = append(errs, fmt.Errorf("user %d: unknown tenant %q", i, u.Tenant))
+		}
+	}
+	return errs
+}
+
+// tenant returns the tenant named name, nil when there is none
+func (o Options) tenant(name string) *Tenant {
+	for i := range o.Tenants {
+		if o.Tenants[i].Name == name {
+			return &o.Tenants[i]
+		}
+	}
+	return nil
+}
+
+// scope returns the tenant of the user making the request, nil for users
+// of the whole server. Users of an unknown tenant own nothing.
+func (s *Server) scope(r *http.Request) *Tenant {
+	u, _ := UserFrom(r.Context())
+	if u.Tenant == "" {
+		return nil
+	}
+	if t := s.opts.tenant(u.Tenant); t != nil {
+		return t
+	}
+	return &Tenant{Name: u.Tenant}
+}
+
+var errNotOwned = errors.New("not owned by your tenant")
+
+// ZoneInfo describes a served zone, the reply of GET /api/zones is a list
+// of them
+type ZoneInfo struct {
+	Origin string `json:"origin"`
+	Serial uint32 `json:"serial"`
+	// Tenant owns the zone, empty for zones of the whole server
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// listZones lists the served zones sorted by origin, those of their tenant
+// for tenant users
+func (s *Server) listZones(w http.ResponseWriter, r *http.Request) {
+	zones := []ZoneInfo{}
+	if s.Zones == nil {
+		writeJSON(w, http.StatusOK, zones)
+		return
+	}
+	scope := s.scope(r)
+	for origin, z := range s.Zones() {
+		if !scope.OwnsName(origin) {
+			continue
+		}
+		info := ZoneInfo{Origin: origin}
+		info.Serial, _ = z.Serial()
+		for _, t := range s.opts.Tenants {
+			if t.OwnsName(origin) {
+				info.Tenant = t.Name
+			}
+		}
+		zones = append(zones, info)
+	}
+	slices.SortFunc(zones, func(a, b ZoneInfo) int { return strings.Compare(a.Origin, b.Origin) })
+	writeJSON(w, http.StatusOK, zones)
+}
+
+// ZoneRecords is the reply of GET /api/zones/{origin}/records, the records
+// of a zone with their comments, owners, tickets, labels and expiry
+type ZoneRecords struct {
+	Origin  string                `json:"origin"`
+	Records []dns.AnnotatedRecord `json:"records"`
+}
+
+// zoneRecords lists the records of the zone with the origin path value,
+// expired ones included. Tenant users only see their zones.
+func (s *Server) zoneRecords(w http.ResponseWriter, r *http.Request) {
+	origin := fqdn.Canonical(r.PathValue("origin"))
+	if !s.scope(r).OwnsName(string(origin)) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s: %w", origin, errNotOwned))
+		return
+	}
+	if s.Zones != nil {
+		for name, z := range s.Zones() {
+			if fqdn.Canonical(name) == origin {
+				writeJSON(w, http.StatusOK, ZoneRecords{Origin: string(origin), Records: z.AnnotatedRecords(time.Now())})
+				return
+			}
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("%s: not a zone served", origin))
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/dns"
+)
+
+var testTenants = Options{
+	Tenants: []Tenant{
+		{Name: "smiths", Zones: []string{"example.com"}, Categories: []string{"ads"}},
+		{Name: "does", Zones: []string{"example.org."}, Categories: []string{"adult"}},
+	},
+	Users: []User{
+		{Name: "ops", Token: "admin-token", Role: RoleAdmin},
+		{Name: "smith", Token: "smith-token", Role: RoleAdmin, Tenant: "smiths"},
+		{Name: "doe", Token: "doe-token", Role: RoleRead, Tenant: "does"},
+	},
+}
+
+func newTenantServer() *Server {
+	s := New(testTenants, testCache())
+	s.Sinkhole = blocklist.NewSinkhole()
+	for _, category := range []string{"ads", "adult", "malware"} {
+		s.Sinkhole.Set(category, category, blocklist.NewHash())
+	}
+	s.Zones = func() map[string]dns.Zone {
+		return map[string]dns.Zone{
+			"example.com.": {Origin: "example.com."},
+			"example.org.": {Origin: "example.org.", SOA: map[string]interface{}{"serial": 7}},
+			"example.net.": {Origin: "example.net."},
+		}
+	}
+	return s
+}
+
+func tenantRequest(s *Server, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTenantAccess(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"global endpoint", http.MethodGet, "/api/clients", "smith-token", http.StatusForbidden},
+		{"reload", http.MethodPost, "/api/reload", "smith-token", http.StatusForbidden},
+		{"flush own name", http.MethodPost, "/api/cache/flush?name=www.example.com", "smith-token", http.StatusOK},
+		{"flush other name", http.MethodPost, "/api/cache/flush?name=example.org", "smith-token", http.StatusForbidden},
+		{"flush lookalike suffix", http.MethodPost, "/api/cache/flush?suffix=notexample.com", "smith-token", http.StatusForbidden},
+		{"set own category", http.MethodPost, "/api/blocklist/categories?category=ads&enabled=false", "smith-token", http.StatusOK},
+		{"set other category", http.MethodPost, "/api/blocklist/categories?category=adult&enabled=false", "smith-token", http.StatusForbidden},
+		{"reader flushes", http.MethodPost, "/api/cache/flush?all=true", "doe-token", http.StatusForbidden},
+		{"admin sets any category", http.MethodPost, "/api/blocklist/categories?category=malware&enabled=false", "admin-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := tenantRequest(newTenantServer(), tt.method, tt.path, tt.token)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
+
+func TestTenantFlushAll(t *testing.T) {
+	s := newTenantServer()
+	rec := tenantRequest(s, http.MethodPost, "/api/cache/flush?all=true", "smith-token")
+	var res FlushResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	// example.com A and AAAA and www.example.com A
+	if res.Flushed != 3 {
+		t.Errorf("flushed %d, want 3", res.Flushed)
+	}
+	if n := len(s.Cache.(*dns.RecordsCache).Records); n != 2 {
+		t.Errorf("%d entries left, want 2", n)
+	}
+}
+
+func TestTenantViews(t *testing.T) {
+	s := newTenantServer()
+
+	var list CacheList
+	if err := json.NewDecoder(tenantRequest(s, http.MethodGet, "/api/cache", "doe-token").Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 1 || list.Entries[0].Name != "example.org." {
+		t.Errorf("cache %+v, want example.org. only", list)
+	}
+
+	var zones []ZoneInfo
+	if err := json.NewDecoder(tenantRequest(s, http.MethodGet, "/api/zones", "doe-token").Body).Decode(&zones); err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 1 || zones[0] != (ZoneInfo{Origin: "example.org.", Serial: 7, Tenant: "does"}) {
+		t.Errorf("zones %+v", zones)
+	}
+	zones = nil
+	if err := json.NewDecoder(tenantRequest(s, http.MethodGet, "/api/zones", "admin-token").Body).Decode(&zones); err != nil {
+		t.Fatal(err)
+	}
+	if len(zones) != 3 || zones[0].Origin != "example.com." || zones[0].Tenant != "smiths" || zones[1].Tenant != "" {
+		t.Errorf("zones %+v", zones)
+	}
+
+	var stats blocklist.Stats
+	if err := json.NewDecoder(tenantRequest(s, http.MethodGet, "/api/blocklist", "smith-token").Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Lists) != 1 || stats.Lists[0].Category != "ads" || len(stats.Groups) != 0 {
+		t.Errorf("blocklist stats %+v, want the ads list only", stats)
+	}
+	if _, ok := stats.Categories["malware"]; ok {
+		t.Errorf("categories %v include malware", stats.Categories)
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants []Tenant
+		users   []User
+		errs    int
+	}{
+		{"disjoint", testTenants.Tenants, testTenants.Users, 0},
+		{"duplicate name", []Tenant{{Name: "a"}, {Name: "a"}}, nil, 1},
+		{"no name", []Tenant{{}}, nil, 1},
+		{"nested zones", []Tenant{{Name: "a", Zones: []string{"example.com"}}, {Name: "b", Zones: []string{"home.example.com."}}}, nil, 1},
+		{"own nested zones", []Tenant{{Name: "a", Zones: []string{"example.com", "home.example.com"}}}, nil, 0},
+		{"shared category", []Tenant{{Name: "a", Categories: []string{"ads"}}, {Name: "b", Categories: []string{"ads"}}}, nil, 1},
+		{"unknown tenant", nil, []User{{Token: "x", Role: RoleRead, Tenant: "nobody"}}, 1},
+	}
+	for _, tt := range tests {
+		opts := Options{Listen: "127.0.0.1:1", Tenants: tt.tenants, Users: tt.users}
+		if errs := opts.Validate(); len(errs) != tt.errs {
+			t.Errorf("%s: Validate() = %v, want %d errors", tt.name, errs, tt.errs)
+		}
+	}
+}
+
+func TestZoneRecords(t *testing.T) {
+	s := newTenantServer()
+	s.Zones = func() map[string]dns.Zone {
+		return map[string]dns.Zone{
+			"example.com.": {Origin: "example.com.", TTL: 300, A: []dns.Record{
+				{Name: "www", Value: "192.0.2.1", RecordMeta: dns.RecordMeta{Owner: "web", Ticket: "OPS-1"}},
+				{Name: "demo", Value: "192.0.2.2", RecordMeta: dns.RecordMeta{Expires: "2000-01-01"}},
+			}},
+			"example.org.": {Origin: "example.org."},
+		}
+	}
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{"own zone", "/api/zones/Example.com/records", "smith-token", http.StatusOK},
+		{"other zone", "/api/zones/example.org./records", "smith-token", http.StatusForbidden},
+		{"unknown zone", "/api/zones/example.net/records", "admin-token", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := tenantRequest(s, http.MethodGet, tt.path, tt.token)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+
+	var zone ZoneRecords
+	if err := json.NewDecoder(tenantRequest(s, http.MethodGet, "/api/zones/example.com./records", "admin-token").Body).Decode(&zone); err != nil {
+		t.Fatal(err)
+	}
+	if zone.Origin != "example.com." || len(zone.Records) != 2 {
+		t.Fatalf("zone %+v, want the 2 records of example.com.", zone)
+	}
+	demo, www := zone.Records[0], zone.Records[1]
+	if demo.Name != "demo.example.com." || !demo.Expired || demo.Meta.Expires != "2000-01-01" {
+		t.Errorf("record %+v, want demo expired", demo)
+	}
+	if www.Expired || www.Meta.Owner != "web" || www.Meta.Ticket != "OPS-1" || www.TTL != 300 {
+		t.Errorf("record %+v, want www with its owner and ticket", www)
+	}
+}
@@ -0,0 +1,142 @@
+// Package bench measures the hot paths of the query handler, from the
+// bytes of a query to the bytes of its response.
+package bench
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/cache"
+	"github.com/bernoussama/mercury/dns"
+)
+
+// Scenario is a query and the handler answering it along one path
+type Scenario struct {
+	Name    string
+	Query   []byte
+	Handler *dns.Handler
+}
+
+// Run answers the query b.N times
+func (s Scenario) Run(b *testing.B) {
+	msg := &dns.Message{}
+	buf := make([]byte, 0, dns.BUFFER_SIZE)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = s.Answer(msg, buf[:0])
+	}
+	if len(buf) == 0 {
+		b.Fatal("empty response")
+	}
+}
+
+// Answer decodes the query into msg and appends the response to buf,
+// reusing both like the server does
+func (s Scenario) Answer(msg *dns.Message, buf []byte) []byte {
+	msg.Reset()
+	msg.Bytes = s.Query
+	if _, err := msg.Decode(s.Query); err != nil {
+		return buf
+	}
+	return s.Handler.AppendResponse(context.Background(), buf, msg)
+}
+
+// Suite holds the scenarios of the cached, zone, blocked and forwarded
+// paths along with the in-process upstream the forwarded one queries
+type Suite struct {
+	Scenarios []Scenario
+	upstream  net.PacketConn
+}
+
+// NewSuite starts the upstream and builds the scenarios. Close stops it.
+func NewSuite() (*Suite, error) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go answer(upstream)
+
+	zone := dns.Zone{
+		Origin: "example.test.",
+		TTL:    3600,
+		A:      []dns.Record{{Name: "www", Value: "192.0.2.1"}},
+		AAAA:   []dns.Record{{Name: "www", Value: "2001:db8::1"}},
+	}
+	zone.Index()
+
+	store := blocklist.NewMap()
+	store.Reload([]string{"ads.test."})
+	sinkhole := blocklist.NewSinkhole()
+	sinkhole.Set(blocklist.CategoryBlocklist, blocklist.CategoryBlocklist, store)
+
+	cached := &dns.RecordsCache{Records: make(map[string]dns.Message)}
+	question := dns.Question{DomainName: "cached.test.", QType: dns.TypeA, QClass: 1}
+	name, _ := dns.EncodeDomainName(question.DomainName)
+	cached.Set(dns.CacheKey(question, false), dns.Message{
+		Question: question,
+		Answers:  []dns.Answer{{Name: name, Type: uint16(dns.TypeA), Class: 1, TTL: 3600, RData: []byte{192, 0, 2, 2}, RDLength: 4}},
+	}, 3600)
+
+	handler := func(c cache.Cache[dns.Message]) *dns.Handler {
+		return &dns.Handler{
+			Zones:     map[string]dns.Zone{zone.Origin: zone},
+			Cache:     c,
+			Blocklist: sinkhole,
+			Upstreams: []dns.Upstream{{Address: upstream.LocalAddr().String()}},
+		}
+	}
+	return &Suite{
+		Scenarios: []Scenario{
+			{Name: "cached", Query: query("cached.test."), Handler: handler(cached)},
+			{Name: "zone", Query: query("www.example.test."), Handler: handler(cached)},
+			{Name: "blocked", Query: query("ads.test."), Handler: handler(cached)},
+			{Name: "forwarded", Query: query("forwarded.test."), Handler: handler(discard{})},
+		},
+		upstream: upstream,
+	}, nil
+}
+
+// Close stops the upstream
+func (s *Suite) Close() error {
+	return s.upstream.Close()
+}
+
+// query returns an A query for name in wire format
+func query(name string) []byte {
+	msg := dns.Message{
+		Header:   dns.Header{ID: 1, RD: 1, QDCount: 1},
+		Question: dns.Question{DomainName: name, QType: dns.TypeA, QClass: 1},
+	}
+	return msg.Encode()
+}
+
+// answer replies to every query on conn with an address
+func answer(conn net.PacketConn) {
+	buf := make([]byte, dns.BUFFER_SIZE)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		msg := &dns.Message{}
+		if _, err := msg.Decode(buf[:n]); err != nil {
+			continue
+		}
+		name, _ := dns.EncodeDomainName(msg.Question.DomainName)
+		rr := dns.Answer{Name: name, Type: uint16(dns.TypeA), Class: 1, TTL: 60, RData: []byte{192, 0, 2, 3}, RDLength: 4}
+		conn.WriteTo(dns.NewResponse(msg).Answer(rr).Encode(), addr)
+	}
+}
+
+// discard is a cache that keeps nothing, so every query is forwarded
+type discard struct{}
+
+func (discard) Get(string) (*dns.Message, bool)           { return nil, false }
+func (discard) Set(string, dns.Message, uint32)           {}
+func (discard) Delete(string)                             {}
+func (discard) DeleteFunc(func(string) bool) int          { return 0 }
+func (discard) Invalidate()                               {}
+func (discard) Range(func(cache.Entry[dns.Message]) bool) {}
+func (discard) Stats() cache.Stats                        { return cache.Stats{} }
@@ -0,0 +1,49 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/bernoussama/mercury/dns"
+)
+
+func BenchmarkHandler(b *testing.B) {
+	suite, err := NewSuite()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer suite.Close()
+	for _, s := range suite.Scenarios {
+		b.Run(s.Name, s.Run)
+	}
+}
+
+// maxAllocs are the allocations per query each path may make, lower them
+// along with the improvements that allow it
+var maxAllocs = map[string]float64{
+	"cached":    7,
+	"zone":      8,
+	"blocked":   8,
+	"forwarded": 50,
+}
+
+func TestAllocations(t *testing.T) {
+	suite, err := NewSuite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer suite.Close()
+	for _, s := range suite.Scenarios {
+		msg := &dns.Message{}
+		buf := make([]byte, 0, dns.BUFFER_SIZE)
+		allocs := testing.AllocsPerRun(100, func() {
+			buf = s.Answer(msg, buf[:0])
+		})
+		res := dns.Message{}
+		if _, err := res.Decode(buf); err != nil || res.Header.RCODE != dns.RcodeSuccess || len(res.Answers) != 1 {
+			t.Errorf("%s: response %+v, %v, want one answer", s.Name, res.Header, err)
+		}
+		if want, ok := maxAllocs[s.Name]; !ok || allocs > want {
+			t.Errorf("%s: %v allocations per query, want at most %v", s.Name, allocs, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package blocklist
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+const benchNames = 1 << 20
+
+// listNames returns n names shaped like a blocklist: hosts below a few
+// thousand domains under a handful of TLDs
+func listNames(n int) []string {
+	tlds := []string{"com", "net", "org", "io", "co.uk"}
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("ads-%d.tracker%d.%s.", i, i%4096, tlds[i%len(tlds)])
+	}
+	return names
+}
+
+// heap returns the live heap after a collection
+func heap() uint64 {
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// BenchmarkStoreMemory reports the heap each store keeps for a list of a
+// million names read from files, so the names themselves count. mapbool
+// is the map[string]bool the blocklist used to be.
+func BenchmarkStoreMemory(b *testing.B) {
+	stores := map[string]func() Store{
+		TypeMap:   func() Store { return NewMap() },
+		TypeTrie:  func() Store { return NewTrie() },
+		TypeHash:  func() Store { return NewHash() },
+		TypeBloom: func() Store { return NewBloom(0, DefaultFalsePositiveRate) },
+	}
+	b.Run("mapbool", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			before := heap()
+			list := make(map[string]bool)
+			for _, name := range listNames(benchNames) {
+				list[name] = true
+			}
+			b.ReportMetric(float64(heap()-before)/benchNames, "bytes/name")
+			runtime.KeepAlive(list)
+		}
+	})
+	for _, name := range []string{TypeMap, TypeTrie, TypeHash, TypeBloom} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				before := heap()
+				store := stores[name]()
+				store.Reload(listNames(benchNames))
+				b.ReportMetric(float64(heap()-before)/benchNames, "bytes/name")
+				runtime.KeepAlive(store)
+			}
+		})
+	}
+}
+
+func BenchmarkStoreContains(b *testing.B) {
+	names := listNames(benchNames)
+	unlisted := make([]string, len(names))
+	for i, name := range names {
+		unlisted[i] = "www." + name
+	}
+	for _, typ := range []string{TypeMap, TypeTrie, TypeHash, TypeBloom} {
+		store := New(Options{Type: typ})
+		store.Reload(names)
+		b.Run(typ, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				// half listed, half not
+				if i%2 == 0 {
+					store.Contains(names[i/2%benchNames])
+				} else {
+					store.Contains(unlisted[i/2%benchNames])
+				}
+			}
+		})
+	}
+}
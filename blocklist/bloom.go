@@ -0,0 +1,151 @@
+package blocklist
+
+import (
+	"math"
+	"sync"
+)
+
+// minBloomCapacity sizes empty filters, so a few names added at runtime
+// keep the false positive rate low
+const minBloomCapacity = 1024
+
+// Bloom is a Store keeping a few bits per name instead of the names, about
+// 2.4 bytes at a rate of 1 in 10000. It blocks that share of the names that
+// are not in it. Names removed are kept aside to stay unblocked, and adding
+// many more names than it was loaded with raises the rate.
+type Bloom struct {
+	mu   sync.RWMutex
+	bits []uint64
+	// k is the number of bits set per name
+	k       uint64
+	rate    float64
+	n       int
+	removed map[string]struct{}
+}
+
+// NewBloom returns a filter sized for capacity names at false positive rate
+func NewBloom(capacity int, rate float64) *Bloom {
+	b := &Bloom{rate: rate, removed: make(map[string]struct{})}
+	b.bits, b.k = bloomSize(capacity, rate)
+	return b
+}
+
+// bloomSize returns the bits and hash count holding capacity names at rate
+func bloomSize(capacity int, rate float64) ([]uint64, uint64) {
+	n := float64(max(capacity, minBloomCapacity))
+	m := math.Ceil(-n * math.Log(rate) / (math.Ln2 * math.Ln2))
+	k := max(1, math.Round(m/n*math.Ln2))
+	return make([]uint64, (uint64(m)+63)/64), uint64(k)
+}
+
+// fnv returns the 64-bit FNV-1a hash of name
+func fnv(name string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(name); i++ {
+		h ^= uint64(name[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// mix is the splitmix64 finalizer, spreading every bit of h over the result
+func mix(h uint64) uint64 {
+	h += 0x9e3779b97f4a7c15
+	h = (h ^ h>>30) * 0xbf58476d1ce4e5b9
+	h = (h ^ h>>27) * 0x94d049bb133111eb
+	return h ^ h>>31
+}
+
+// hashes returns the two hashes the k bit positions of name derive from,
+// the second odd so the positions cycle through the whole array
+func hashes(name string) (uint64, uint64) {
+	h := fnv(name)
+	return h, mix(h) | 1
+}
+
+// test reports whether every bit of name is set in bits
+func test(bits []uint64, k uint64, name string) bool {
+	h1, h2 := hashes(name)
+	m := uint64(len(bits)) * 64
+	for i := uint64(0); i < k; i++ {
+		pos := (h1 + i*h2) % m
+		if bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// set sets the bits of name in bits and reports whether any was unset
+func set(bits []uint64, k uint64, name string) bool {
+	h1, h2 := hashes(name)
+	m := uint64(len(bits)) * 64
+	added := false
+	for i := uint64(0); i < k; i++ {
+		pos := (h1 + i*h2) % m
+		if bits[pos/64]&(1<<(pos%64)) == 0 {
+			bits[pos/64] |= 1 << (pos % 64)
+			added = true
+		}
+	}
+	return added
+}
+
+func (b *Bloom) Contains(name string) bool {
+	name = normalize(name)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if _, ok := b.removed[name]; ok {
+		return false
+	}
+	return test(b.bits, b.k, name)
+}
+
+func (b *Bloom) Add(name string) {
+	name = normalize(name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.removed[name]; ok {
+		delete(b.removed, name)
+		b.n++
+		return
+	}
+	if set(b.bits, b.k, name) {
+		b.n++
+	}
+}
+
+func (b *Bloom) Remove(name string) bool {
+	name = normalize(name)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.removed[name]; ok || !test(b.bits, b.k, name) {
+		return false
+	}
+	b.removed[name] = struct{}{}
+	b.n--
+	return true
+}
+
+// Len returns the number of names added, which undercounts by the names
+// that were false positives when added
+func (b *Bloom) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.n
+}
+
+// Reload resizes the filter for names at the configured rate
+func (b *Bloom) Reload(names []string) {
+	bits, k := bloomSize(len(names), b.rate)
+	n := 0
+	for _, name := range names {
+		if set(bits, k, normalize(name)) {
+			n++
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bits, b.k, b.n = bits, k, n
+	b.removed = make(map[string]struct{})
+}
@@ -0,0 +1,78 @@
+package blocklist
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// SourceResult is what reading one source of a compiled list gave
+type SourceResult struct {
+	Source string
+	Names  int
+	Err    error
+}
+
+// Compiled is a blocklist built from sources, less the allowlisted names
+type Compiled struct {
+	*Hash
+	Sources []SourceResult
+	// Listed counts the names read from every source, duplicates included
+	Listed int
+	// Allowed counts the listed names the allowlists removed
+	Allowed int
+}
+
+// Compile reads every source, a URL or a file, into one deduplicated set
+// and removes the names of the allowlist files. Sources that fail are
+// reported in the result and skipped, an unreadable allowlist fails.
+func Compile(ctx context.Context, client *http.Client, sources, allowlists []string) (*Compiled, error) {
+	allowed, err := ReadFiles(allowlists)
+	if err != nil {
+		return nil, err
+	}
+	c := &Compiled{Hash: NewHash()}
+	var names []string
+	for _, source := range sources {
+		listed, err := Fetch(ctx, client, source)
+		c.Sources = append(c.Sources, SourceResult{Source: source, Names: len(listed), Err: err})
+		names = append(names, listed...)
+	}
+	c.Listed = len(names)
+	c.Reload(names)
+	for _, name := range allowed {
+		if c.Remove(name) {
+			c.Allowed++
+		}
+	}
+	return c, nil
+}
+
+// Fetch returns the names of a blocklist at an http(s) URL or in a file
+func Fetch(ctx context.Context, client *http.Client, source string) ([]string, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return Read(f)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, res.Body)
+		return nil, fmt.Errorf("HTTP %s", res.Status)
+	}
+	return Read(res.Body)
+}
@@ -0,0 +1,100 @@
+package blocklist
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+)
+
+// A compiled list is a Hash table written as is: the magic, a version, the
+// name and slot counts, then every slot, all little endian. Open maps it
+// into memory so loading takes no time whatever the size of the list.
+const (
+	compiledMagic   = "MBLK"
+	compiledVersion = 1
+	compiledHeader  = 24
+)
+
+var errCompiled = errors.New("not a compiled blocklist")
+
+// littleEndian reports whether slots can point into a compiled list as is
+var littleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// WriteFile writes the names of h as a compiled list. The file is replaced
+// by a rename, so servers that mapped the previous one keep reading it.
+func WriteFile(path string, h *Hash) error {
+	h.mu.RLock()
+	data := make([]byte, compiledHeader, compiledHeader+8*len(h.slots))
+	copy(data, compiledMagic)
+	binary.LittleEndian.PutUint32(data[4:], compiledVersion)
+	binary.LittleEndian.PutUint64(data[8:], uint64(h.n))
+	binary.LittleEndian.PutUint64(data[16:], uint64(len(h.slots)))
+	for _, k := range h.slots {
+		data = binary.LittleEndian.AppendUint64(data, k)
+	}
+	h.mu.RUnlock()
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Open returns the hash set of a compiled list, mapped from the file when
+// the platform allows. Names added or removed afterwards are not written
+// back.
+func Open(path string) (*Hash, error) {
+	data, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := decodeCompiled(data)
+	if err != nil {
+		unmap(data)
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return h, nil
+}
+
+func decodeCompiled(data []byte) (*Hash, error) {
+	if len(data) < compiledHeader || string(data[:4]) != compiledMagic {
+		return nil, errCompiled
+	}
+	if v := binary.LittleEndian.Uint32(data[4:]); v != compiledVersion {
+		return nil, fmt.Errorf("compiled blocklist version %d, want %d", v, compiledVersion)
+	}
+	n := binary.LittleEndian.Uint64(data[8:])
+	size := binary.LittleEndian.Uint64(data[16:])
+	if size == 0 || size&(size-1) != 0 || n >= size || uint64(len(data)-compiledHeader) != 8*size {
+		return nil, fmt.Errorf("%w: %d names in %d slots of a %d bytes file", errCompiled, n, size, len(data))
+	}
+
+	h := &Hash{n: int(n)}
+	if littleEndian {
+		h.slots = unsafe.Slice((*uint64)(unsafe.Pointer(&data[compiledHeader])), size)
+		h.mapping = data
+		return h, nil
+	}
+	h.slots = make([]uint64, size)
+	for i := range h.slots {
+		h.slots[i] = binary.LittleEndian.Uint64(data[compiledHeader+8*i:])
+	}
+	unmap(data)
+	return h, nil
+}
@@ -0,0 +1,133 @@
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompiledRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.bin")
+	h := NewHash()
+	h.Reload(listNames(5000))
+	if err := WriteFile(path, h); err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Len() != 5000 {
+		t.Errorf("Len() = %d, want 5000", opened.Len())
+	}
+	for _, name := range listNames(5000) {
+		if !opened.Contains(name) {
+			t.Fatalf("%s not blocked after opening", name)
+		}
+	}
+	if opened.Contains("example.com.") {
+		t.Error("unlisted name blocked")
+	}
+
+	// changes are made to a copy, never to the mapped file
+	opened.Add("example.com.")
+	opened.Remove(listNames(1)[0])
+	if !opened.Contains("example.com.") || opened.Contains(listNames(1)[0]) || opened.Len() != 5000 {
+		t.Errorf("after Add and Remove: Len() = %d", opened.Len())
+	}
+	after, _ := os.ReadFile(path)
+	if !bytes.Equal(written, after) {
+		t.Error("compiled file modified")
+	}
+	opened.Reload(nil)
+}
+
+func TestOpenInvalid(t *testing.T) {
+	h := NewHash()
+	h.Add("example.com.")
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.bin")
+	if err := WriteFile(valid, h); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(valid)
+	version := bytes.Clone(data)
+	version[4] = 9
+	slots := bytes.Clone(data)
+	slots[16] = 17
+
+	for name, content := range map[string][]byte{
+		"empty":     nil,
+		"text":      []byte("ads.example.com\n"),
+		"truncated": data[:len(data)-8],
+		"version":   version,
+		"slots":     slots,
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, content, 0o644)
+		if _, err := Open(path); err == nil {
+			t.Errorf("%s: Open() succeeded", name)
+		}
+	}
+	if _, err := Open(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing: Open() succeeded")
+	}
+}
+
+func TestCompile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hosts":
+			w.Write([]byte("127.0.0.1 localhost\n0.0.0.0 ads.example.com tracker.example.com\n"))
+		case "/adblock":
+			w.Write([]byte("! Title: ads\n||ads.example.com^\n||metrics.example.net^\n@@||cdn.example.net^\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	local := filepath.Join(dir, "local.txt")
+	allow := filepath.Join(dir, "allow.txt")
+	os.WriteFile(local, []byte("Local.Example.ORG\n"), 0o644)
+	os.WriteFile(allow, []byte("tracker.example.com\nunlisted.example.\n"), 0o644)
+
+	sources := []string{srv.URL + "/hosts", srv.URL + "/adblock", srv.URL + "/missing", local}
+	c, err := Compile(context.Background(), srv.Client(), sources, []string{allow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNames := []int{2, 2, 0, 1}
+	for i, source := range c.Sources {
+		if source.Names != wantNames[i] || (source.Err != nil) != (i == 2) {
+			t.Errorf("%s: %d names, err %v", source.Source, source.Names, source.Err)
+		}
+	}
+	if c.Listed != 5 || c.Allowed != 1 || c.Len() != 3 {
+		t.Errorf("listed %d, allowed %d, len %d, want 5, 1, 3", c.Listed, c.Allowed, c.Len())
+	}
+	for _, name := range []string{"ads.example.com.", "metrics.example.net.", "local.example.org."} {
+		if !c.Contains(name) {
+			t.Errorf("%s not blocked", name)
+		}
+	}
+	for _, name := range []string{"tracker.example.com.", "cdn.example.net.", "localhost."} {
+		if c.Contains(name) {
+			t.Errorf("%s blocked", name)
+		}
+	}
+
+	if _, err := Compile(context.Background(), srv.Client(), sources, []string{filepath.Join(dir, "missing")}); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("unreadable allowlist: err %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package blocklist
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// kinds of runtime edits of the sinkhole
+const (
+	EditCategories = "categories"
+	EditAllow      = "allow"
+	EditPause      = "pause"
+)
+
+// editRetention is how long the time of an expired allowance or pause is
+// kept
+const editRetention = 24 * time.Hour
+
+// Edit is a runtime change of the sinkhole, as instances sharing their
+// state exchange them. Of the edits of one group, name or client the
+// latest wins.
+type Edit struct {
+	Kind string `json:"kind"`
+	// Group and Disabled are the categories not blocked for a group
+	Group    string   `json:"group,omitempty"`
+	Disabled []string `json:"disabled,omitempty"`
+	// Name is the allowed name and Client the paused client, empty for
+	// every client, until a time
+	Name   string    `json:"name,omitempty"`
+	Client string    `json:"client,omitempty"`
+	Until  time.Time `json:"until,omitempty"`
+	// At is when the edit was made
+	At time.Time `json:"at"`
+}
+
+// subject names what the edit changes
+func (e Edit) subject() string {
+	switch e.Kind {
+	case EditCategories:
+		if e.Group == "" {
+			return EditCategories + "/" + DefaultGroup
+		}
+		return EditCategories + "/" + e.Group
+	case EditAllow:
+		return EditAllow + "/" + normalize(e.Name)
+	default:
+		return e.Kind + "/" + e.Client
+	}
+}
+
+// OnEdit calls fn after each category set, allow and pause, not after the
+// edits applied with Apply. Set it before serving.
+func (s *Sinkhole) OnEdit(fn func(Edit)) {
+	s.mu.Lock()
+	s.onEdit = fn
+	s.mu.Unlock()
+}
+
+func (s *Sinkhole) notify(e Edit) {
+	s.mu.RLock()
+	fn := s.onEdit
+	s.mu.RUnlock()
+	if fn != nil {
+		fn(e)
+	}
+}
+
+// Apply makes an edit of another instance unless a later one of the same
+// subject was made
+func (s *Sinkhole) Apply(e Edit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !e.At.After(s.edited[e.subject()]) {
+		return nil
+	}
+	switch e.Kind {
+	case EditCategories:
+		g := s.findGroup(e.Group)
+		if g == nil {
+			return fmt.Errorf("%w %q", ErrUnknownGroup, e.Group)
+		}
+		g.disabled = make(map[string]bool, len(e.Disabled))
+		for _, category := range e.Disabled {
+			g.disabled[category] = true
+		}
+	case EditAllow:
+		s.allow(normalize(e.Name), e.Until)
+	case EditPause:
+		if e.Client != "" && net.ParseIP(e.Client) == nil {
+			return fmt.Errorf("invalid paused client %q", e.Client)
+		}
+		s.pause(e.Client, e.Until)
+	default:
+		return fmt.Errorf("unknown edit kind %q", e.Kind)
+	}
+	s.edited[e.subject()] = e.At
+	return nil
+}
+
+// Edits returns the state changed by edits as one edit per subject, the
+// categories of groups and the unexpired allowances and pauses
+func (s *Sinkhole) Edits() []Edit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var edits []Edit
+	for _, g := range append([]*group{s.defaultGroup}, s.groups...) {
+		e := Edit{Kind: EditCategories, Group: g.name, Disabled: g.stats().Disabled}
+		if at, ok := s.edited[e.subject()]; ok {
+			e.At = at
+			edits = append(edits, e)
+		}
+	}
+	for subject, at := range s.edited {
+		var e Edit
+		if name, ok := strings.CutPrefix(subject, EditAllow+"/"); ok {
+			e = Edit{Kind: EditAllow, Name: name, Until: s.allowed[name]}
+		} else if client, ok := strings.CutPrefix(subject, EditPause+"/"); ok {
+			e = Edit{Kind: EditPause, Client: client, Until: s.pausedAll}
+			if client != "" {
+				e.Until = s.paused[client]
+			}
+		} else {
+			continue
+		}
+		if !now.Before(e.Until) {
+			// expired edits are kept a while so an older one arriving
+			// late is not applied
+			if now.Sub(at) > editRetention {
+				delete(s.edited, subject)
+			}
+			continue
+		}
+		e.At = at
+		edits = append(edits, e)
+	}
+	return edits
+}
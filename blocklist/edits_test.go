@@ -0,0 +1,53 @@
+package blocklist
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSinkholeApplyEdits(t *testing.T) {
+	ads := NewHash()
+	ads.Add("ads.example.com.")
+	primary, standby := NewSinkhole(), NewSinkhole()
+	for _, s := range []*Sinkhole{primary, standby} {
+		s.Set("easylist", "ads", ads)
+		s.SetGroups([]GroupOptions{{Name: "kids", Clients: []string{"192.168.1.42"}}})
+	}
+	var published []Edit
+	primary.OnEdit(func(e Edit) { published = append(published, e) })
+	standby.OnEdit(func(e Edit) { t.Errorf("applied edit %v published again", e) })
+
+	primary.Allow("ads.example.com.", time.Hour)
+	primary.Pause(net.ParseIP("192.168.1.7"), time.Hour)
+	if _, err := primary.SetCategory("kids", "ads", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 3 {
+		t.Fatalf("published %d edits, want 3", len(published))
+	}
+	for _, e := range published {
+		if err := standby.Apply(e); err != nil {
+			t.Fatalf("apply %v: %v", e, err)
+		}
+	}
+	stats := standby.Stats()
+	if stats.Allowed["ads.example.com."].IsZero() || stats.PausedClients["192.168.1.7"].IsZero() {
+		t.Errorf("allow or pause not applied: %v %v", stats.Allowed, stats.PausedClients)
+	}
+	if got := len(standby.Edits()); got != 3 {
+		t.Errorf("standby has %d edits, want 3", got)
+	}
+
+	// the latest edit of a subject wins, whatever the order they arrive in
+	older := Edit{Kind: EditAllow, Name: "ads.example.com.", Until: time.Now().Add(2 * time.Hour), At: published[0].At.Add(-time.Second)}
+	if err := standby.Apply(older); err != nil {
+		t.Fatal(err)
+	}
+	if until := standby.Stats().Allowed["ads.example.com."]; !until.Equal(published[0].Until) {
+		t.Errorf("older edit applied, allowed until %v", until)
+	}
+	if err := standby.Apply(Edit{Kind: EditCategories, Group: "unknown", At: time.Now()}); err == nil {
+		t.Error("edit of an unknown group applied")
+	}
+}
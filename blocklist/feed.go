@@ -0,0 +1,135 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bernoussama/mercury/logging"
+	"gopkg.in/yaml.v3"
+)
+
+var feedLog = logging.For(logging.Blocklist)
+
+// CategoryThreat labels the names of threat feeds configured without a
+// category
+const CategoryThreat = "threat"
+
+// DefaultFeedInterval is how often feeds are fetched when no interval is
+// configured
+const DefaultFeedInterval = time.Hour
+
+// MinFeedInterval keeps feeds from being polled more often than their
+// providers allow
+const MinFeedInterval = time.Minute
+
+// FeedOptions configures a threat intelligence feed, a list of domains,
+// hosts or URLs whose hosts are blocked, like the URLhaus host file
+type FeedOptions struct {
+	// Name identifies the feed in logs and stats, its URL by default
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Category labels the queries the feed blocks, threat by default
+	Category string        `yaml:"category"`
+	Interval time.Duration `yaml:"interval"`
+	// Rule is what is done with the queries of the names
+	Rule Rule `yaml:",inline"`
+}
+
+// UnmarshalYAML also accepts a bare URL
+func (o *FeedOptions) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&o.URL)
+	}
+	type options FeedOptions
+	return node.Decode((*options)(o))
+}
+
+// Validate reports problems with the options without applying them
+func (o FeedOptions) Validate() []error {
+	var errs []error
+	if u, err := url.Parse(o.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("feed URL must be http or https, got %q", o.URL))
+	}
+	if o.Interval != 0 && o.Interval < MinFeedInterval {
+		errs = append(errs, fmt.Errorf("feed interval must be at least %s, got %s", MinFeedInterval, o.Interval))
+	}
+	return append(errs, o.Rule.Validate()...)
+}
+
+// ListName returns the name of the list of the feed in the sinkhole
+func (o FeedOptions) ListName() string {
+	return o.name()
+}
+
+// List returns the list of the feed holding the names of store
+func (o FeedOptions) List(store Store) List {
+	return List{Name: o.name(), Category: o.category(), Store: store, Rule: o.Rule}
+}
+
+func (o FeedOptions) name() string {
+	if o.Name == "" {
+		return o.URL
+	}
+	return o.Name
+}
+
+func (o FeedOptions) category() string {
+	if o.Category == "" {
+		return CategoryThreat
+	}
+	return o.Category
+}
+
+func (o FeedOptions) interval() time.Duration {
+	if o.Interval == 0 {
+		return DefaultFeedInterval
+	}
+	return o.Interval
+}
+
+// Subscribe adds the feed to the sinkhole as an empty list and refreshes
+// it at once, then every interval until ctx is done. A failed refresh keeps
+// the names of the previous one.
+func (s *Sinkhole) Subscribe(ctx context.Context, client *http.Client, opts FeedOptions) {
+	s.SetList(opts.List(NewHash()))
+	l := s.list(opts.name())
+	go func() {
+		ticker := time.NewTicker(opts.interval())
+		defer ticker.Stop()
+		for {
+			refresh(ctx, client, l, opts)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// refresh fetches the names of a feed into its list
+func refresh(ctx context.Context, client *http.Client, l *list, opts FeedOptions) {
+	names, err := Fetch(ctx, client, opts.URL)
+	if err == nil && len(names) == 0 {
+		// an empty download is more likely a broken feed than no threats
+		err = errors.New("no domains in feed")
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		msg := err.Error()
+		l.err.Store(&msg)
+		feedLog.Warn("threat feed refresh failed", "feed", l.name, "err", err)
+		return
+	}
+	l.store.Reload(names)
+	now := time.Now()
+	l.updated.Store(&now)
+	l.err.Store(nil)
+	feedLog.Info("updated threat feed", "feed", l.name, "category", l.category, "domains", l.store.Len())
+}
@@ -0,0 +1,144 @@
+package blocklist
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSinkholeMatch(t *testing.T) {
+	ads, malware, phishing := NewMap(), NewHash(), NewHash()
+	ads.Reload([]string{"ads.example.com", "shared.example.com"})
+	malware.Reload([]string{"shared.example.com", "c2.example.net"})
+	phishing.Reload([]string{"login.example.org"})
+
+	sinkhole := NewSinkhole()
+	sinkhole.Set(CategoryBlocklist, CategoryBlocklist, ads)
+	sinkhole.Set("urlhaus", CategoryThreat, malware)
+	sinkhole.Set("phishtank", CategoryThreat, phishing)
+
+	tests := []struct {
+		name     string
+		category string
+		blocked  bool
+	}{
+		{"ads.example.com.", CategoryBlocklist, true},
+		// the first list listing a name counts it
+		{"shared.example.com.", CategoryBlocklist, true},
+		{"C2.example.net.", CategoryThreat, true},
+		{"login.example.org.", CategoryThreat, true},
+		{"example.com.", "", false},
+	}
+	for _, tt := range tests {
+		verdict, blocked := sinkhole.Match(nil, tt.name)
+		if verdict.Category != tt.category || blocked != tt.blocked {
+			t.Errorf("Match(%s) = %q, %v, want %q, %v", tt.name, verdict.Category, blocked, tt.category, tt.blocked)
+		}
+	}
+
+	// replacing a list keeps its count
+	sinkhole.Set("urlhaus", CategoryThreat, NewHash())
+	if _, blocked := sinkhole.Match(nil, "c2.example.net."); blocked {
+		t.Error("name of a replaced list still blocked")
+	}
+	stats := sinkhole.Stats()
+	want := map[string]uint64{CategoryBlocklist: 2, CategoryThreat: 2}
+	if len(stats.Categories) != len(want) || stats.Categories[CategoryBlocklist] != 2 || stats.Categories[CategoryThreat] != 2 {
+		t.Errorf("categories %v, want %v", stats.Categories, want)
+	}
+	if len(stats.Lists) != 3 || stats.Lists[2].Name != "urlhaus" || stats.Lists[2].Blocked != 1 || stats.Lists[2].Names != 0 {
+		t.Errorf("lists %+v", stats.Lists)
+	}
+	if sinkhole.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", sinkhole.Len())
+	}
+
+	var none *Sinkhole
+	if _, blocked := none.Match(nil, "ads.example.com."); blocked {
+		t.Error("nil sinkhole blocks")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	var fail atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("# URLhaus\nhttp://malware.example.com/payload.exe\nhttps://198.51.100.7/x.sh\nhttp://c2.example.net:8080/\n"))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sinkhole := NewSinkhole()
+	opts := FeedOptions{Name: "urlhaus", URL: srv.URL, Category: "malware", Interval: 20 * time.Millisecond}
+	sinkhole.Subscribe(ctx, srv.Client(), opts)
+
+	feed := func() ListStats {
+		for _, l := range sinkhole.Stats().Lists {
+			if l.Name == "urlhaus" {
+				return l
+			}
+		}
+		t.Fatal("feed not in the sinkhole")
+		return ListStats{}
+	}
+	waitFor(t, func() bool { return feed().Updated != nil })
+	if verdict, blocked := sinkhole.Match(nil, "malware.example.com"); !blocked || verdict.Category != "malware" {
+		t.Errorf("Match(malware.example.com) = %q, %v", verdict.Category, blocked)
+	}
+	if l := feed(); l.Names != 2 || l.Category != "malware" {
+		t.Errorf("feed %+v, want 2 names", l)
+	}
+
+	// a failed refresh keeps the names of the previous one
+	fail.Store(true)
+	waitFor(t, func() bool { return feed().Error != "" })
+	if _, blocked := sinkhole.Match(nil, "c2.example.net"); !blocked || feed().Names != 2 {
+		t.Errorf("names dropped after a failed refresh: %+v", feed())
+	}
+	fail.Store(false)
+	waitFor(t, func() bool { return feed().Error == "" })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFeedOptions(t *testing.T) {
+	var feeds []FeedOptions
+	data := "- https://urlhaus.abuse.ch/downloads/hostfile/\n- name: phishing\n  url: https://lists.example.com/phishing.txt\n  category: phishing\n  interval: 30m\n"
+	if err := yaml.Unmarshal([]byte(data), &feeds); err != nil {
+		t.Fatal(err)
+	}
+	if feeds[0].name() != "https://urlhaus.abuse.ch/downloads/hostfile/" || feeds[0].category() != CategoryThreat || feeds[0].interval() != DefaultFeedInterval {
+		t.Errorf("shorthand decoded %+v", feeds[0])
+	}
+	if feeds[1].name() != "phishing" || feeds[1].category() != "phishing" || feeds[1].interval() != 30*time.Minute {
+		t.Errorf("mapping decoded %+v", feeds[1])
+	}
+	for _, feed := range feeds {
+		if errs := feed.Validate(); len(errs) > 0 {
+			t.Errorf("Validate(%+v) = %v", feed, errs)
+		}
+	}
+	for _, bad := range []FeedOptions{{URL: "/etc/hosts"}, {URL: "ftp://example.com/list"}, {URL: "https://example.com/", Interval: time.Second}} {
+		if len(bad.Validate()) == 0 {
+			t.Errorf("Validate(%+v) found no problem", bad)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package blocklist
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultGroup holds the clients of no other group
+const DefaultGroup = "default"
+
+var (
+	ErrUnknownGroup    = errors.New("unknown client group")
+	ErrUnknownCategory = errors.New("unknown category")
+)
+
+// File is a blocklist file whose names are blocked under a category
+type File struct {
+	Path string `yaml:"path"`
+	// Category labels the names of the file, blocklist by default
+	Category string `yaml:"category"`
+	// Rule is what is done with the queries of the names, the same for
+	// every file of the category
+	Rule Rule `yaml:",inline"`
+}
+
+// UnmarshalYAML also accepts a bare path
+func (f *File) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&f.Path)
+	}
+	type file File
+	return node.Decode((*file)(f))
+}
+
+// Categorize returns the paths of files grouped by category, in the order
+// categories first appear
+func Categorize(files []File) ([]string, map[string][]string) {
+	var categories []string
+	paths := make(map[string][]string)
+	for _, f := range files {
+		category := f.Category
+		if category == "" {
+			category = CategoryBlocklist
+		}
+		if _, ok := paths[category]; !ok {
+			categories = append(categories, category)
+		}
+		paths[category] = append(paths[category], f.Path)
+	}
+	return categories, paths
+}
+
+// GroupOptions configures the categories blocked for a group of clients
+type GroupOptions struct {
+	Name string `yaml:"name"`
+	// Clients are the addresses or networks of the group, empty for the
+	// default group
+	Clients []string `yaml:"clients"`
+	// Disabled are the categories not blocked for the group
+	Disabled []string `yaml:"disabled"`
+}
+
+// Validate reports problems with the options without applying them
+func (o GroupOptions) Validate() []error {
+	var errs []error
+	switch {
+	case o.Name == "":
+		errs = append(errs, errors.New("client group without a name"))
+	case o.Name == DefaultGroup && len(o.Clients) > 0:
+		errs = append(errs, fmt.Errorf("the %s group holds the clients of no other group, it takes no clients", DefaultGroup))
+	case o.Name != DefaultGroup && len(o.Clients) == 0:
+		errs = append(errs, fmt.Errorf("client group %q without clients", o.Name))
+	}
+	for _, client := range o.Clients {
+		if _, err := parseClient(client); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// parseClient returns the network of an address or CIDR
+func parseClient(client string) (*net.IPNet, error) {
+	if !strings.Contains(client, "/") {
+		ip := net.ParseIP(client)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid client address %q", client)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(client)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client network %q", client)
+	}
+	return ipnet, nil
+}
+
+// group is a set of clients with categories they are not blocked for
+type group struct {
+	name    string
+	clients []string
+	nets    []*net.IPNet
+	// disabled is guarded by the lock of the sinkhole
+	disabled map[string]bool
+	blocked  atomic.Uint64
+}
+
+// GroupStats describes one client group of the sinkhole
+type GroupStats struct {
+	Name     string   `json:"name"`
+	Clients  []string `json:"clients"`
+	Disabled []string `json:"disabled"`
+	// Blocked counts the queries of the group's clients that were blocked
+	Blocked uint64 `json:"blocked"`
+}
+
+func newGroup(opts GroupOptions) *group {
+	g := &group{name: opts.Name, clients: opts.Clients, disabled: make(map[string]bool)}
+	for _, client := range opts.Clients {
+		if ipnet, err := parseClient(client); err == nil {
+			g.nets = append(g.nets, ipnet)
+		}
+	}
+	for _, category := range opts.Disabled {
+		g.disabled[category] = true
+	}
+	return g
+}
+
+func (g *group) stats() GroupStats {
+	disabled := make([]string, 0, len(g.disabled))
+	for category := range g.disabled {
+		disabled = append(disabled, category)
+	}
+	sort.Strings(disabled)
+	clients := g.clients
+	if clients == nil {
+		clients = []string{}
+	}
+	return GroupStats{Name: g.name, Clients: clients, Disabled: disabled, Blocked: g.blocked.Load()}
+}
+
+// SetGroups replaces the client groups. Clients are in the first group
+// holding them, or else in the default group.
+func (s *Sinkhole) SetGroups(groups []GroupOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for subject := range s.edited {
+		if strings.HasPrefix(subject, EditCategories+"/") {
+			delete(s.edited, subject)
+		}
+	}
+	s.groups = nil
+	s.defaultGroup = newGroup(GroupOptions{Name: DefaultGroup})
+	for _, opts := range groups {
+		if opts.Name == DefaultGroup {
+			s.defaultGroup = newGroup(opts)
+			continue
+		}
+		s.groups = append(s.groups, newGroup(opts))
+	}
+}
+
+// groupOf returns the group of client, called with the lock held
+func (s *Sinkhole) groupOf(client net.IP) *group {
+	if client != nil {
+		for _, g := range s.groups {
+			for _, ipnet := range g.nets {
+				if ipnet.Contains(client) {
+					return g
+				}
+			}
+		}
+	}
+	return s.defaultGroup
+}
+
+// findGroup returns the group called name, called with the lock held
+func (s *Sinkhole) findGroup(name string) *group {
+	if name == "" || name == DefaultGroup {
+		return s.defaultGroup
+	}
+	for _, g := range s.groups {
+		if g.name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// SetCategory enables or disables blocking the category for the clients of
+// a group, the default group when name is empty. The change lasts until
+// the groups are set again.
+func (s *Sinkhole) SetCategory(name, category string, enabled bool) (GroupStats, error) {
+	s.mu.Lock()
+	g := s.findGroup(name)
+	if g == nil {
+		s.mu.Unlock()
+		return GroupStats{}, fmt.Errorf("%w %q", ErrUnknownGroup, name)
+	}
+	if !slices.ContainsFunc(s.lists, func(l *list) bool { return l.category == category }) {
+		s.mu.Unlock()
+		return GroupStats{}, fmt.Errorf("%w %q", ErrUnknownCategory, category)
+	}
+	if enabled {
+		delete(g.disabled, category)
+	} else {
+		g.disabled[category] = true
+	}
+	stats := g.stats()
+	edit := Edit{Kind: EditCategories, Group: g.name, Disabled: stats.Disabled, At: time.Now()}
+	s.edited[edit.subject()] = edit.At
+	s.mu.Unlock()
+	s.notify(edit)
+	return stats, nil
+}
@@ -0,0 +1,137 @@
+package blocklist
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSinkholeGroups(t *testing.T) {
+	ads, adult, malware := NewHash(), NewHash(), NewHash()
+	ads.Reload([]string{"ads.example.com"})
+	adult.Reload([]string{"adult.example.com"})
+	malware.Reload([]string{"c2.example.net"})
+	sinkhole := NewSinkhole()
+	sinkhole.Set("ads", "ads", ads)
+	sinkhole.Set("adult", "adult", adult)
+	sinkhole.Set("urlhaus", "malware", malware)
+	sinkhole.SetGroups([]GroupOptions{
+		{Name: DefaultGroup, Disabled: []string{"adult"}},
+		{Name: "kids", Clients: []string{"192.168.1.0/24", "fd00::/8"}},
+		{Name: "office", Clients: []string{"10.0.0.7"}, Disabled: []string{"ads"}},
+	})
+
+	tests := []struct {
+		client  string
+		name    string
+		blocked bool
+	}{
+		{"192.168.1.20", "adult.example.com.", true},
+		{"fd00::20", "adult.example.com.", true},
+		{"10.0.0.8", "adult.example.com.", false},
+		{"", "adult.example.com.", false},
+		{"10.0.0.7", "ads.example.com.", false},
+		{"10.0.0.7", "c2.example.net.", true},
+		{"10.0.0.8", "ads.example.com.", true},
+	}
+	for _, tt := range tests {
+		if _, blocked := sinkhole.Match(net.ParseIP(tt.client), tt.name); blocked != tt.blocked {
+			t.Errorf("Match(%s, %s) blocked = %v, want %v", tt.client, tt.name, blocked, tt.blocked)
+		}
+	}
+
+	// at runtime
+	group, err := sinkhole.SetCategory("kids", "malware", false)
+	if err != nil || group.Name != "kids" || len(group.Disabled) != 1 || group.Disabled[0] != "malware" {
+		t.Fatalf("SetCategory() = %+v, %v", group, err)
+	}
+	if _, blocked := sinkhole.Match(net.ParseIP("192.168.1.20"), "c2.example.net."); blocked {
+		t.Error("disabled category blocked")
+	}
+	if _, err := sinkhole.SetCategory("", "adult", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, blocked := sinkhole.Match(nil, "adult.example.com."); !blocked {
+		t.Error("enabled category not blocked")
+	}
+	if _, err := sinkhole.SetCategory("guests", "ads", false); !errors.Is(err, ErrUnknownGroup) {
+		t.Errorf("unknown group: err %v", err)
+	}
+	if _, err := sinkhole.SetCategory("kids", "gambling", false); !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("unknown category: err %v", err)
+	}
+
+	stats := sinkhole.Stats()
+	blocked := map[string]uint64{}
+	for _, g := range stats.Groups {
+		blocked[g.Name] = g.Blocked
+	}
+	if len(stats.Groups) != 3 || stats.Groups[0].Name != DefaultGroup || blocked["kids"] != 2 || blocked["office"] != 1 || blocked[DefaultGroup] != 2 {
+		t.Errorf("groups %+v", stats.Groups)
+	}
+}
+
+func TestGroupOptionsValidate(t *testing.T) {
+	for _, tt := range []struct {
+		opts GroupOptions
+		errs int
+	}{
+		{GroupOptions{Name: DefaultGroup, Disabled: []string{"ads"}}, 0},
+		{GroupOptions{Name: "kids", Clients: []string{"192.168.1.0/24", "192.168.1.1", "::1"}}, 0},
+		{GroupOptions{Name: DefaultGroup, Clients: []string{"10.0.0.0/8"}}, 1},
+		{GroupOptions{Name: "kids"}, 1},
+		{GroupOptions{Clients: []string{"10.0.0.0/8"}}, 1},
+		{GroupOptions{Name: "kids", Clients: []string{"10.0.0/8", "host.lan"}}, 2},
+	} {
+		if errs := tt.opts.Validate(); len(errs) != tt.errs {
+			t.Errorf("Validate(%+v) = %v, want %d problems", tt.opts, errs, tt.errs)
+		}
+	}
+}
+
+func TestCategorize(t *testing.T) {
+	var files []File
+	data := "- /lists/a.txt\n- path: /lists/ads.txt\n  category: ads\n- /lists/b.txt\n- path: /lists/porn.txt\n  category: adult\n- path: /lists/more-ads.txt\n  category: ads\n"
+	if err := yaml.Unmarshal([]byte(data), &files); err != nil {
+		t.Fatal(err)
+	}
+	categories, paths := Categorize(files)
+	if len(categories) != 3 || categories[0] != CategoryBlocklist || categories[1] != "ads" || categories[2] != "adult" {
+		t.Errorf("categories %v", categories)
+	}
+	if len(paths[CategoryBlocklist]) != 2 || len(paths["ads"]) != 2 || paths["adult"][0] != "/lists/porn.txt" {
+		t.Errorf("paths %v", paths)
+	}
+}
+
+func TestSinkholeAllow(t *testing.T) {
+	ads := NewHash()
+	ads.Reload([]string{"ads.example.com", "tracker.example.com"})
+	sinkhole := NewSinkhole()
+	sinkhole.Set("easylist", "ads", ads)
+
+	if verdict, ok := sinkhole.Explain(nil, "ADS.example.com"); !ok || verdict.List != "easylist" || verdict.Category != "ads" {
+		t.Errorf("Explain() = %+v, %v", verdict, ok)
+	}
+	sinkhole.Allow("ADS.example.com", time.Hour)
+	sinkhole.Allow("tracker.example.com.", -time.Second)
+	if _, blocked := sinkhole.Match(nil, "ads.example.com."); blocked {
+		t.Error("allowed name blocked")
+	}
+	if _, ok := sinkhole.Explain(nil, "ads.example.com."); ok {
+		t.Error("allowed name explained as blocked")
+	}
+	if _, blocked := sinkhole.Match(nil, "tracker.example.com."); !blocked {
+		t.Error("expired allowance still unblocks")
+	}
+	if allowed := sinkhole.Stats().Allowed; len(allowed) != 1 || allowed["ads.example.com."].IsZero() {
+		t.Errorf("allowed %v", allowed)
+	}
+	// only counted when matched
+	if stats := sinkhole.Stats(); stats.Categories["ads"] != 1 {
+		t.Errorf("categories %v, want 1 ads block", stats.Categories)
+	}
+}
@@ -0,0 +1,171 @@
+package blocklist
+
+import "sync"
+
+// maxHashLoad is the share of slots a Hash fills before it grows, in eighths
+const maxHashLoad = 6
+
+// Hash is a Store keeping a 64-bit hash per name in an open addressing
+// table instead of the names, 11 to 21 bytes a name. Two names only collide
+// about once in 10^12 lists of ten million names.
+type Hash struct {
+	mu sync.RWMutex
+	// slots is a power of two long, 0 marking an empty slot
+	slots []uint64
+	n     int
+	// mapping is the read-only file slots point into when opened from a
+	// compiled list, copied before the first change
+	mapping []byte
+}
+
+func NewHash() *Hash {
+	return &Hash{slots: make([]uint64, 16)}
+}
+
+// key returns the hash a name is stored as, never 0
+func key(name string) uint64 {
+	return max(mix(fnv(normalize(name))), 1)
+}
+
+// hashSlots returns an empty table holding n hashes below the maximum load
+func hashSlots(n int) []uint64 {
+	size := 16
+	for size*maxHashLoad/8 < n+1 {
+		size *= 2
+	}
+	return make([]uint64, size)
+}
+
+// find returns the slot holding k or the empty slot ending its probe
+func find(slots []uint64, k uint64) int {
+	mask := len(slots) - 1
+	i := int(k) & mask
+	for slots[i] != 0 && slots[i] != k {
+		i = (i + 1) & mask
+	}
+	return i
+}
+
+func (h *Hash) Contains(name string) bool {
+	k := key(name)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.slots[find(h.slots, k)] == k
+}
+
+func (h *Hash) Add(name string) {
+	k := key(name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := find(h.slots, k)
+	if h.slots[i] == k {
+		return
+	}
+	h.own()
+	h.n++
+	if h.n > len(h.slots)*maxHashLoad/8 {
+		h.grow()
+		i = find(h.slots, k)
+	}
+	h.slots[i] = k
+}
+
+// own copies slots out of a mapped file so they can change, called with
+// the lock held
+func (h *Hash) own() {
+	if h.mapping == nil {
+		return
+	}
+	h.slots = append([]uint64(nil), h.slots...)
+	unmap(h.mapping)
+	h.mapping = nil
+}
+
+// grow doubles the table, called with the lock held
+func (h *Hash) grow() {
+	slots := make([]uint64, len(h.slots)*2)
+	for _, k := range h.slots {
+		if k != 0 {
+			slots[find(slots, k)] = k
+		}
+	}
+	h.slots = slots
+}
+
+func (h *Hash) Remove(name string) bool {
+	k := key(name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := find(h.slots, k)
+	if h.slots[i] != k {
+		return false
+	}
+	h.own()
+	h.n--
+	// shift the following hashes of the probe back instead of leaving a
+	// tombstone, so lookups stay as short as after a reload
+	mask := len(h.slots) - 1
+	for j := (i + 1) & mask; h.slots[j] != 0; j = (j + 1) & mask {
+		home := int(h.slots[j]) & mask
+		// move slots[j] into the hole at i unless its home lies in (i, j]
+		if (j > i && (home <= i || home > j)) || (j < i && home <= i && home > j) {
+			h.slots[i] = h.slots[j]
+			i = j
+		}
+	}
+	h.slots[i] = 0
+	return true
+}
+
+func (h *Hash) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.n
+}
+
+func (h *Hash) Reload(names []string) {
+	slots, n := hashSlots(len(names)), 0
+	for _, name := range names {
+		k := key(name)
+		if i := find(slots, k); slots[i] != k {
+			slots[i] = k
+			n++
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.mapping != nil {
+		unmap(h.mapping)
+		h.mapping = nil
+	}
+	h.slots, h.n = slots, n
+}
+
+// Close unmaps the file of a compiled list, leaving the set empty
+func (h *Hash) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.mapping != nil {
+		unmap(h.mapping)
+		h.mapping = nil
+	}
+	h.slots, h.n = make([]uint64, 16), 0
+}
+
+// Diff counts the hashes of h missing from old and those of old missing
+// from h
+func (h *Hash) Diff(old *Hash) (added, removed int) {
+	if h == old {
+		return 0, 0
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	old.mu.RLock()
+	defer old.mu.RUnlock()
+	for _, k := range h.slots {
+		if k != 0 && old.slots[find(old.slots, k)] != k {
+			added++
+		}
+	}
+	return added, old.n - (h.n - added)
+}
@@ -0,0 +1,53 @@
+package blocklist
+
+import "sync"
+
+// Map is a Store backed by a hash map, one string per name
+type Map struct {
+	mu    sync.RWMutex
+	names map[string]struct{}
+}
+
+func NewMap() *Map {
+	return &Map{names: make(map[string]struct{})}
+}
+
+func (m *Map) Contains(name string) bool {
+	name = normalize(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.names[name]
+	return ok
+}
+
+func (m *Map) Add(name string) {
+	name = normalize(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.names[name] = struct{}{}
+}
+
+func (m *Map) Remove(name string) bool {
+	name = normalize(name)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.names[name]
+	delete(m.names, name)
+	return ok
+}
+
+func (m *Map) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.names)
+}
+
+func (m *Map) Reload(names []string) {
+	fresh := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		fresh[normalize(name)] = struct{}{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.names = fresh
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package blocklist
+
+import "os"
+
+// mapFile reads the file at path where memory mapping is not supported
+func mapFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func unmap([]byte) {}
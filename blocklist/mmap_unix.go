@@ -0,0 +1,31 @@
+//go:build unix
+
+package blocklist
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the file at path read only
+func mapFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, nil
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func unmap(data []byte) {
+	if data != nil {
+		syscall.Munmap(data)
+	}
+}
@@ -0,0 +1,64 @@
+package blocklist
+
+import (
+	"net"
+	"time"
+)
+
+// Pause stops blocking for client during d, or for every client when client
+// is nil. A duration of zero or less resumes blocking. Pause returns when
+// blocking resumes.
+func (s *Sinkhole) Pause(client net.IP, d time.Duration) time.Time {
+	now := time.Now()
+	until := now.Add(max(d, 0))
+	edit := Edit{Kind: EditPause, Until: until, At: now}
+	if client != nil {
+		edit.Client = client.String()
+	}
+	s.mu.Lock()
+	s.pause(edit.Client, until)
+	s.edited[edit.subject()] = now
+	s.mu.Unlock()
+	s.notify(edit)
+	return until
+}
+
+// pause stops blocking for the client address, every client when empty,
+// until a time, called with the lock held
+func (s *Sinkhole) pause(client string, until time.Time) {
+	now := time.Now()
+	for c, u := range s.paused {
+		if !now.Before(u) {
+			delete(s.paused, c)
+		}
+	}
+	switch {
+	case client == "":
+		s.pausedAll = until
+	case !now.Before(until):
+		delete(s.paused, client)
+	default:
+		s.paused[client] = until
+	}
+}
+
+// pausedFor reports whether blocking is paused for client, called with the
+// lock held
+func (s *Sinkhole) pausedFor(client net.IP) bool {
+	now := time.Now()
+	if now.Before(s.pausedAll) {
+		return true
+	}
+	if client == nil {
+		return false
+	}
+	until, ok := s.paused[client.String()]
+	return ok && now.Before(until)
+}
+
+// Paused reports whether blocking is paused for every client
+func (s *Sinkhole) Paused() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return time.Now().Before(s.pausedAll)
+}
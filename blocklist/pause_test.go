@@ -0,0 +1,49 @@
+package blocklist
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSinkholePause(t *testing.T) {
+	ads := NewHash()
+	ads.Add("ads.example.com.")
+	sinkhole := NewSinkhole()
+	sinkhole.Set("easylist", "ads", ads)
+	kid, laptop := net.ParseIP("192.168.1.42"), net.ParseIP("192.168.1.7")
+	blocked := func(client net.IP) bool {
+		_, blocked := sinkhole.Match(client, "ads.example.com.")
+		return blocked
+	}
+
+	sinkhole.Pause(laptop, time.Hour)
+	if !blocked(kid) || !blocked(nil) || blocked(laptop) {
+		t.Error("client pause not limited to the client")
+	}
+	if _, ok := sinkhole.Explain(laptop, "ads.example.com."); ok {
+		t.Error("paused client explained as blocked")
+	}
+	sinkhole.Pause(nil, time.Hour)
+	if blocked(kid) || blocked(nil) {
+		t.Error("global pause still blocks")
+	}
+	stats := sinkhole.Stats()
+	if stats.PausedUntil == nil || len(stats.PausedClients) != 1 || stats.PausedClients["192.168.1.7"].IsZero() {
+		t.Errorf("paused %v %v", stats.PausedUntil, stats.PausedClients)
+	}
+
+	sinkhole.Pause(nil, 0)
+	sinkhole.Pause(laptop, 0)
+	if !blocked(kid) || !blocked(laptop) {
+		t.Error("blocking not resumed")
+	}
+	// an ended pause resumes by itself
+	sinkhole.Pause(kid, -time.Second)
+	if !blocked(kid) {
+		t.Error("expired pause still unblocks")
+	}
+	if stats := sinkhole.Stats(); stats.PausedUntil != nil || len(stats.PausedClients) != 0 {
+		t.Errorf("paused %v %v after resuming", stats.PausedUntil, stats.PausedClients)
+	}
+}
@@ -0,0 +1,110 @@
+package blocklist
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// hostsNames are the entries of hosts files that are not ads
+var hostsNames = map[string]bool{
+	"localhost.": true, "localhost.localdomain.": true, "local.": true,
+	"broadcasthost.": true, "ip6-localhost.": true, "ip6-loopback.": true,
+	"ip6-localnet.": true, "ip6-mcastprefix.": true, "ip6-allnodes.": true,
+	"ip6-allrouters.": true, "ip6-allhosts.": true,
+}
+
+// Read returns the domains in a plain list, hosts file, list of URLs or
+// adblock list of ||domain^ rules. Comments, other adblock rules, local
+// host entries and invalid names are skipped.
+func Read(r io.Reader) ([]string, error) {
+	var names []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		// ! starts adblock comments
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "!") {
+			continue
+		}
+		// hosts format: "0.0.0.0 example.com www.example.com"
+		if len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+			fields = fields[1:]
+		}
+		for _, field := range fields {
+			if name, ok := clean(field); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, scanner.Err()
+}
+
+// clean returns the normalized domain of a list entry and whether it is one
+func clean(entry string) (string, bool) {
+	if rule, ok := strings.CutPrefix(entry, "||"); ok {
+		entry, ok = strings.CutSuffix(rule, "^")
+		if !ok {
+			return "", false
+		}
+	}
+	entry, ok := urlHost(entry)
+	if !ok {
+		return "", false
+	}
+	if net.ParseIP(entry) != nil {
+		return "", false
+	}
+	name := normalize(entry)
+	if len(name) > 254 || hostsNames[name] {
+		return "", false
+	}
+	label := 0
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c == '.':
+			if label == 0 || label > 63 {
+				return "", false
+			}
+			label = 0
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+			label++
+		default:
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// urlHost returns the host of a URL list entry, like the URLhaus URL dumps
+func urlHost(entry string) (string, bool) {
+	if !strings.Contains(entry, "://") {
+		return entry, true
+	}
+	u, err := url.Parse(entry)
+	if err != nil || u.Hostname() == "" {
+		return "", false
+	}
+	return u.Hostname(), true
+}
+
+// ReadFiles returns the domains of every list file
+func ReadFiles(files []string) ([]string, error) {
+	var names []string
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		listed, err := Read(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, listed...)
+	}
+	return names, nil
+}
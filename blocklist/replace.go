@@ -0,0 +1,63 @@
+package blocklist
+
+import (
+	"slices"
+)
+
+// List is a store of names blocked under a category, as given to Replace
+type List struct {
+	Name     string
+	Category string
+	Store    Store
+	// Rule is what is done with the queries the list matches
+	Rule Rule
+}
+
+// Replace sets every list like Set and removes the lists called remove, in
+// one step so queries see either the old lists or the new ones. It returns
+// the stores it replaced or removed.
+func (s *Sinkhole) Replace(lists []List, remove []string) []Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var old []Store
+	for _, l := range lists {
+		if prev := s.set(l); prev != nil {
+			old = append(old, prev)
+		}
+	}
+	s.lists = slices.DeleteFunc(s.lists, func(l *list) bool {
+		if slices.Contains(remove, l.name) {
+			old = append(old, l.store)
+			return true
+		}
+		return false
+	})
+	return old
+}
+
+// Delta counts the names of store missing from old and those of old
+// missing from store, names being what store was loaded with. Hash sets
+// are compared directly, other stores through names, which bloom filters
+// answer with their false positives.
+func Delta(old, store Store, names []string) (added, removed int) {
+	if h, ok := store.(*Hash); ok {
+		if oldHash, ok := old.(*Hash); ok {
+			return h.Diff(oldHash)
+		}
+	}
+	if old == nil {
+		return store.Len(), 0
+	}
+	names = slices.Clone(names)
+	for i, name := range names {
+		names[i] = normalize(name)
+	}
+	slices.Sort(names)
+	kept := 0
+	for _, name := range slices.Compact(names) {
+		if old.Contains(name) {
+			kept++
+		}
+	}
+	return store.Len() - kept, old.Len() - kept
+}
@@ -0,0 +1,39 @@
+package blocklist
+
+import "testing"
+
+func TestReplace(t *testing.T) {
+	sinkhole := NewSinkhole()
+	old := NewHash()
+	old.Reload([]string{"ads.example.com", "tracker.example.com"})
+	sinkhole.Set("ads", "ads", old)
+	sinkhole.Set("adult", "adult", NewMap())
+	sinkhole.Match(nil, "ads.example.com.")
+
+	for _, tt := range []struct {
+		store Store
+		names []string
+	}{
+		{NewHash(), []string{"ads.example.com", "metrics.example.com", "beacon.example.com"}},
+		{NewMap(), []string{"ADS.example.com.", "metrics.example.com", "beacon.example.com", "beacon.example.com"}},
+	} {
+		tt.store.Reload(tt.names)
+		if added, removed := Delta(old, tt.store, tt.names); added != 2 || removed != 1 {
+			t.Errorf("Delta() to %T = +%d -%d, want +2 -1", tt.store, added, removed)
+		}
+	}
+
+	store := NewHash()
+	store.Reload([]string{"beacon.example.com"})
+	replaced := sinkhole.Replace([]List{{Name: "ads", Category: "ads", Store: store}}, []string{"adult"})
+	if len(replaced) != 2 || replaced[0] != Store(old) {
+		t.Errorf("Replace() returned %v, want the old ads and adult stores", replaced)
+	}
+	stats := sinkhole.Stats()
+	if len(stats.Lists) != 1 || stats.Lists[0].Blocked != 1 || stats.Lists[0].Names != 1 {
+		t.Errorf("lists %+v, want ads keeping its count", stats.Lists)
+	}
+	if _, blocked := sinkhole.Match(nil, "ads.example.com."); blocked {
+		t.Error("replaced list still blocks")
+	}
+}
@@ -0,0 +1,122 @@
+package blocklist
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+// actions a rule takes on the queries its list matches
+const (
+	// ActionSinkhole answers with the sinkhole addresses
+	ActionSinkhole = "sinkhole"
+	// ActionNXDomain answers that the name does not exist
+	ActionNXDomain = "nxdomain"
+	// ActionRefuse answers REFUSED
+	ActionRefuse = "refuse"
+	// ActionRedirect answers with the addresses of the rule
+	ActionRedirect = "redirect"
+	// ActionDrop sends no answer at all
+	ActionDrop = "drop"
+	// ActionLog only logs the query, which is resolved
+	ActionLog = "log"
+)
+
+// Rule is what the sinkhole does with the queries a list matches. Of the
+// lists matching a name, the one with the highest priority decides, lists
+// of equal priority in the order they were added.
+type Rule struct {
+	// Action is sinkhole, the default, nxdomain, refuse, redirect, drop or
+	// log
+	Action string `yaml:"action"`
+	// Redirect are the addresses redirect answers with, the first IPv4 one
+	// to A queries and the first IPv6 one to AAAA queries
+	Redirect []string `yaml:"redirect"`
+	Priority int      `yaml:"priority"`
+	// Expires is when the list stops matching, never when zero
+	Expires time.Time `yaml:"expires"`
+}
+
+// Validate reports problems with the rule without applying it
+func (r Rule) Validate() []error {
+	var errs []error
+	switch r.Action {
+	case "", ActionSinkhole, ActionNXDomain, ActionRefuse, ActionDrop, ActionLog:
+		if len(r.Redirect) > 0 {
+			errs = append(errs, fmt.Errorf("redirect addresses need the %s action", ActionRedirect))
+		}
+	case ActionRedirect:
+		if len(r.Redirect) == 0 {
+			errs = append(errs, fmt.Errorf("the %s action needs redirect addresses", ActionRedirect))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown action %q, want sinkhole, nxdomain, refuse, redirect, drop or log", r.Action))
+	}
+	for _, address := range r.Redirect {
+		if net.ParseIP(address) == nil {
+			errs = append(errs, fmt.Errorf("invalid redirect address %q", address))
+		}
+	}
+	return errs
+}
+
+// Kind returns the action of the rule, sinkhole when unset
+func (r Rule) Kind() string {
+	if r.Action == "" {
+		return ActionSinkhole
+	}
+	return r.Action
+}
+
+// Blocks reports whether the rule keeps the name from being resolved
+func (r Rule) Blocks() bool {
+	return r.Kind() != ActionLog
+}
+
+// Expired reports whether the rule no longer applies at now
+func (r Rule) Expired(now time.Time) bool {
+	return !r.Expires.IsZero() && !now.Before(r.Expires)
+}
+
+// RedirectIPs returns the parsed redirect addresses, skipping invalid ones
+func (r Rule) RedirectIPs() []net.IP {
+	ips := make([]net.IP, 0, len(r.Redirect))
+	for _, address := range r.Redirect {
+		if ip := net.ParseIP(address); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// Verdict is the list matching a query and its rule
+type Verdict struct {
+	List     string
+	Category string
+	Rule     Rule
+}
+
+// CategoryRules returns the rule of each category of files, that of its
+// first file, and the categories whose files disagree on their rule
+func CategoryRules(files []File) (map[string]Rule, []string) {
+	rules := make(map[string]Rule)
+	var conflicts []string
+	for _, f := range files {
+		category := f.Category
+		if category == "" {
+			category = CategoryBlocklist
+		}
+		rule, ok := rules[category]
+		if !ok {
+			rules[category] = f.Rule
+		} else if !rule.equal(f.Rule) && !slices.Contains(conflicts, category) {
+			conflicts = append(conflicts, category)
+		}
+	}
+	return rules, conflicts
+}
+
+func (r Rule) equal(other Rule) bool {
+	return r.Kind() == other.Kind() && r.Priority == other.Priority && r.Expires.Equal(other.Expires) && slices.Equal(r.Redirect, other.Redirect)
+}
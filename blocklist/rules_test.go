@@ -0,0 +1,89 @@
+package blocklist
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSinkholeRules(t *testing.T) {
+	list := func(names ...string) Store {
+		store := NewHash()
+		store.Reload(names)
+		return store
+	}
+	sinkhole := NewSinkhole()
+	sinkhole.Set("ads", "ads", list("ads.example.com", "tracker.example.com"))
+	sinkhole.SetList(List{Name: "watch", Category: "watch", Store: list("tracker.example.com"), Rule: Rule{Action: ActionLog, Priority: 10}})
+	sinkhole.SetList(List{Name: "expired", Category: "malware", Store: list("ads.example.com"), Rule: Rule{Action: ActionDrop, Priority: 20, Expires: time.Now().Add(-time.Minute)}})
+	sinkhole.SetList(List{Name: "gone", Category: "malware", Store: list("gone.example.com"), Rule: Rule{Action: ActionNXDomain}})
+
+	tests := []struct {
+		name   string
+		list   string
+		action string
+	}{
+		// the highest priority list decides
+		{"tracker.example.com.", "watch", ActionLog},
+		// expired rules match nothing
+		{"ads.example.com.", "ads", ActionSinkhole},
+		{"gone.example.com.", "gone", ActionNXDomain},
+	}
+	for _, tt := range tests {
+		verdict, ok := sinkhole.Match(nil, tt.name)
+		if !ok || verdict.List != tt.list || verdict.Rule.Kind() != tt.action {
+			t.Errorf("Match(%s) = %+v, %v, want list %s with %s", tt.name, verdict, ok, tt.list, tt.action)
+		}
+	}
+	if stats := sinkhole.Stats(); stats.Groups[0].Blocked != 2 {
+		t.Errorf("group blocked %d queries, want 2 without the log-only match", stats.Groups[0].Blocked)
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		errs int
+	}{
+		{Rule{}, 0},
+		{Rule{Action: ActionRedirect, Redirect: []string{"192.168.1.2", "fd00::2"}}, 0},
+		{Rule{Action: ActionRedirect}, 1},
+		{Rule{Action: ActionRedirect, Redirect: []string{"192.168.1"}}, 1},
+		{Rule{Action: ActionRefuse, Redirect: []string{"192.168.1.2"}}, 1},
+		{Rule{Action: "block"}, 1},
+	}
+	for _, tt := range tests {
+		if errs := tt.rule.Validate(); len(errs) != tt.errs {
+			t.Errorf("%+v: Validate() = %v, want %d errors", tt.rule, errs, tt.errs)
+		}
+	}
+}
+
+func TestCategoryRules(t *testing.T) {
+	var files []File
+	if err := yaml.Unmarshal([]byte(`
+- ads.txt
+- path: malware.txt
+  category: malware
+  action: nxdomain
+  priority: 5
+  expires: 2026-12-31T00:00:00Z
+- path: more-malware.txt
+  category: malware
+  action: drop
+`), &files); err != nil {
+		t.Fatal(err)
+	}
+	rules, conflicts := CategoryRules(files)
+	malware := rules["malware"]
+	if malware.Action != ActionNXDomain || malware.Priority != 5 || malware.Expires.Year() != 2026 {
+		t.Errorf("malware rule %+v", malware)
+	}
+	if rules[CategoryBlocklist].Kind() != ActionSinkhole {
+		t.Errorf("blocklist rule %+v, want sinkhole", rules[CategoryBlocklist])
+	}
+	if len(conflicts) != 1 || conflicts[0] != "malware" {
+		t.Errorf("conflicts %v, want malware", conflicts)
+	}
+}
@@ -0,0 +1,305 @@
+package blocklist
+
+import (
+	"cmp"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CategoryBlocklist labels the names of blocklist files without a category
+const CategoryBlocklist = "blocklist"
+
+// Sinkhole checks query names against named lists, the blocklist files of
+// each category and each threat feed, skipping the categories disabled for
+// the group of the client, and counts the queries blocked per category
+type Sinkhole struct {
+	mu           sync.RWMutex
+	lists        []*list
+	groups       []*group
+	defaultGroup *group
+	// allowed holds names unblocked until a time
+	allowed map[string]time.Time
+	// pausedAll and paused stop blocking for every client and for single
+	// clients until a time
+	pausedAll time.Time
+	paused    map[string]time.Time
+	// edited holds when each subject of an edit last changed, and onEdit
+	// is told of local edits
+	edited map[string]time.Time
+	onEdit func(Edit)
+	// dryRun counts and reports matches without blocking them
+	dryRun atomic.Bool
+}
+
+// list is a store of names blocked under a category
+type list struct {
+	name     string
+	category string
+	store    Store
+	rule     Rule
+	blocked  atomic.Uint64
+	// updated and err are the outcome of the last feed refresh
+	updated atomic.Pointer[time.Time]
+	err     atomic.Pointer[string]
+}
+
+// ListStats describes one list of the sinkhole
+type ListStats struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Names    int    `json:"names"`
+	// Action, Priority and Expires are the rule of the list
+	Action   string     `json:"action"`
+	Priority int        `json:"priority,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	// Blocked counts the queries the list blocked
+	Blocked uint64 `json:"blocked"`
+	// Updated and Error report the last refresh of a feed
+	Updated *time.Time `json:"updated,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// Stats is the state of the sinkhole with blocked queries summed per
+// category
+type Stats struct {
+	Lists      []ListStats       `json:"lists"`
+	Categories map[string]uint64 `json:"categories"`
+	Groups     []GroupStats      `json:"groups"`
+	// Allowed are the names temporarily unblocked and until when
+	Allowed map[string]time.Time `json:"allowed"`
+	// PausedUntil and PausedClients report when paused blocking resumes for
+	// every client and for single clients
+	PausedUntil   *time.Time           `json:"paused_until,omitempty"`
+	PausedClients map[string]time.Time `json:"paused_clients"`
+	// DryRun is set when the counts are of queries that would have been
+	// blocked, see SetDryRun
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+func NewSinkhole() *Sinkhole {
+	return &Sinkhole{
+		defaultGroup: newGroup(GroupOptions{Name: DefaultGroup}),
+		allowed:      make(map[string]time.Time),
+		paused:       make(map[string]time.Time),
+		edited:       make(map[string]time.Time),
+	}
+}
+
+// Set blocks the names of store under category as the list called name,
+// replacing the list of that name and keeping its count
+func (s *Sinkhole) Set(name, category string, store Store) {
+	s.SetList(List{Name: name, Category: category, Store: store})
+}
+
+// SetList sets the list like Set, along with its rule
+func (s *Sinkhole) SetList(l List) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(l)
+}
+
+// set sets the list and returns the store it replaced, called with the lock
+// held. Lists are kept by decreasing priority, in the order they were first
+// set within a priority.
+func (s *Sinkhole) set(set List) Store {
+	l := &list{name: set.Name, category: set.Category, store: set.Store, rule: set.Rule}
+	var old Store
+	if i := slices.IndexFunc(s.lists, func(old *list) bool { return old.name == set.Name }); i >= 0 {
+		l.blocked.Store(s.lists[i].blocked.Load())
+		old = s.lists[i].store
+		s.lists[i] = l
+	} else {
+		s.lists = append(s.lists, l)
+	}
+	slices.SortStableFunc(s.lists, func(a, b *list) int { return cmp.Compare(b.rule.Priority, a.rule.Priority) })
+	return old
+}
+
+// Store returns the store of the list called name
+func (s *Sinkhole) Store(name string) (Store, bool) {
+	if l := s.list(name); l != nil {
+		return l.store, true
+	}
+	return nil, false
+}
+
+func (s *Sinkhole) list(name string) *list {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, l := range s.lists {
+		if l.name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// Match returns the list matching name for client, which may be nil, and
+// counts the query as blocked by it. The rule of the list tells whether and
+// how the query is blocked.
+func (s *Sinkhole) Match(client net.IP, name string) (Verdict, bool) {
+	if s == nil {
+		return Verdict{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.pausedFor(client) {
+		return Verdict{}, false
+	}
+	g := s.groupOf(client)
+	l := s.match(g, name)
+	if l == nil {
+		return Verdict{}, false
+	}
+	l.blocked.Add(1)
+	if l.rule.Blocks() {
+		g.blocked.Add(1)
+	}
+	return l.verdict(), true
+}
+
+// SetDryRun turns dry run on or off. In a dry run Match still counts the
+// queries the lists match but the server resolves them, so new lists can
+// be evaluated before enforcing them.
+func (s *Sinkhole) SetDryRun(on bool) {
+	s.dryRun.Store(on)
+}
+
+// DryRun reports whether matches are counted without being blocked
+func (s *Sinkhole) DryRun() bool {
+	return s != nil && s.dryRun.Load()
+}
+
+// Explain returns the list matching name for client without counting a
+// query
+func (s *Sinkhole) Explain(client net.IP, name string) (Verdict, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.pausedFor(client) {
+		return Verdict{}, false
+	}
+	l := s.match(s.groupOf(client), name)
+	if l == nil {
+		return Verdict{}, false
+	}
+	return l.verdict(), true
+}
+
+// match returns the list of highest priority matching name for the group,
+// called with the lock held
+func (s *Sinkhole) match(g *group, name string) *list {
+	if until, ok := s.allowed[normalize(name)]; ok && time.Now().Before(until) {
+		return nil
+	}
+	for _, l := range s.lists {
+		if g.disabled[l.category] || l.rule.Expired(time.Now()) {
+			continue
+		}
+		if l.store.Contains(name) {
+			return l
+		}
+	}
+	return nil
+}
+
+func (l *list) verdict() Verdict {
+	return Verdict{List: l.name, Category: l.category, Rule: l.rule}
+}
+
+// Allow unblocks name for every client during d, replacing an earlier
+// allowance of the name
+func (s *Sinkhole) Allow(name string, d time.Duration) time.Time {
+	now := time.Now()
+	edit := Edit{Kind: EditAllow, Name: normalize(name), Until: now.Add(d), At: now}
+	s.mu.Lock()
+	s.allow(edit.Name, edit.Until)
+	s.edited[edit.subject()] = now
+	s.mu.Unlock()
+	s.notify(edit)
+	return edit.Until
+}
+
+// allow unblocks the normalized name until a time, called with the lock
+// held
+func (s *Sinkhole) allow(name string, until time.Time) {
+	now := time.Now()
+	for allowed, u := range s.allowed {
+		if !now.Before(u) {
+			delete(s.allowed, allowed)
+		}
+	}
+	if now.Before(until) {
+		s.allowed[name] = until
+	}
+}
+
+// Len returns the number of names in every list, counting names listed
+// twice once per list
+func (s *Sinkhole) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, l := range s.lists {
+		n += l.store.Len()
+	}
+	return n
+}
+
+// Stats returns the lists sorted by name, the queries blocked per category
+// and the client groups, the default one first
+func (s *Sinkhole) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := Stats{
+		Lists:         []ListStats{},
+		Categories:    make(map[string]uint64),
+		Allowed:       make(map[string]time.Time),
+		PausedClients: make(map[string]time.Time),
+		DryRun:        s.dryRun.Load(),
+	}
+	now := time.Now()
+	for name, until := range s.allowed {
+		if now.Before(until) {
+			stats.Allowed[name] = until
+		}
+	}
+	if now.Before(s.pausedAll) {
+		until := s.pausedAll
+		stats.PausedUntil = &until
+	}
+	for client, until := range s.paused {
+		if now.Before(until) {
+			stats.PausedClients[client] = until
+		}
+	}
+	stats.Groups = append(stats.Groups, s.defaultGroup.stats())
+	for _, g := range s.groups {
+		stats.Groups = append(stats.Groups, g.stats())
+	}
+	for _, l := range s.lists {
+		ls := ListStats{
+			Name:     l.name,
+			Category: l.category,
+			Names:    l.store.Len(),
+			Action:   l.rule.Kind(),
+			Priority: l.rule.Priority,
+			Blocked:  l.blocked.Load(),
+			Updated:  l.updated.Load(),
+		}
+		if !l.rule.Expires.IsZero() {
+			expires := l.rule.Expires
+			ls.Expires = &expires
+		}
+		if err := l.err.Load(); err != nil {
+			ls.Error = *err
+		}
+		stats.Lists = append(stats.Lists, ls)
+		stats.Categories[l.category] += ls.Blocked
+	}
+	sort.Slice(stats.Lists, func(i, j int) bool { return stats.Lists[i].Name < stats.Lists[j].Name })
+	return stats
+}
@@ -0,0 +1,88 @@
+package blocklist
+
+import (
+	"fmt"
+
+	"github.com/bernoussama/mercury/fqdn"
+	"gopkg.in/yaml.v3"
+)
+
+// Store holds the domain names to sinkhole. Names are matched exactly and
+// case insensitively, with or without the trailing dot.
+type Store interface {
+	Contains(name string) bool
+	Add(name string)
+	// Remove unblocks name and reports whether it was blocked
+	Remove(name string) bool
+	Len() int
+	// Reload replaces every name in the store at once
+	Reload(names []string)
+}
+
+// store types
+const (
+	TypeMap   = "map"
+	TypeTrie  = "trie"
+	TypeHash  = "hash"
+	TypeBloom = "bloom"
+)
+
+// DefaultFalsePositiveRate is the share of unlisted names a bloom store
+// blocks by mistake when no rate is configured
+const DefaultFalsePositiveRate = 0.0001
+
+// Options selects the store holding the blocklist
+type Options struct {
+	// Type is hash, keeping a hash per name, map, keeping the names, trie,
+	// sharing the labels common to names, or bloom, the smallest but
+	// blocking a few unlisted names
+	Type string `yaml:"type"`
+	// FalsePositiveRate is the share of unlisted names a bloom store blocks
+	FalsePositiveRate float64 `yaml:"false_positive_rate"`
+}
+
+// UnmarshalYAML also accepts a bare store type
+func (o *Options) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&o.Type)
+	}
+	type options Options
+	return node.Decode((*options)(o))
+}
+
+// Validate reports problems with the options without applying them
+func (o Options) Validate() []error {
+	var errs []error
+	switch o.Type {
+	case "", TypeHash, TypeMap, TypeTrie, TypeBloom:
+	default:
+		errs = append(errs, fmt.Errorf("unknown store type %q, want hash, map, trie or bloom", o.Type))
+	}
+	if o.FalsePositiveRate < 0 || o.FalsePositiveRate >= 1 {
+		errs = append(errs, fmt.Errorf("false_positive_rate must be between 0 and 1, got %g", o.FalsePositiveRate))
+	}
+	return errs
+}
+
+// New returns an empty store of the configured type, a hash set by default
+func New(opts Options) Store {
+	switch opts.Type {
+	case TypeMap:
+		return NewMap()
+	case TypeTrie:
+		return NewTrie()
+	case TypeBloom:
+		rate := opts.FalsePositiveRate
+		if rate == 0 {
+			rate = DefaultFalsePositiveRate
+		}
+		return NewBloom(0, rate)
+	default:
+		return NewHash()
+	}
+}
+
+// normalize returns name in the form stores keep it, the canonical one
+func normalize(name string) string {
+	return string(fqdn.Canonical(name))
+}
@@ -0,0 +1,181 @@
+package blocklist
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func stores() map[string]Store {
+	return map[string]Store{
+		TypeMap:   NewMap(),
+		TypeTrie:  NewTrie(),
+		TypeHash:  NewHash(),
+		TypeBloom: NewBloom(0, DefaultFalsePositiveRate),
+	}
+}
+
+func TestStores(t *testing.T) {
+	for name, store := range stores() {
+		t.Run(name, func(t *testing.T) {
+			store.Reload([]string{"ads.example.com", "Tracker.Example.NET.", "ads.example.com."})
+			if store.Len() != 2 {
+				t.Errorf("Len() = %d after reload, want 2", store.Len())
+			}
+			for _, tt := range []struct {
+				name string
+				want bool
+			}{
+				{"ads.example.com.", true},
+				{"ADS.example.com", true},
+				{"tracker.example.net.", true},
+				{"example.com.", false},
+				{"www.ads.example.com.", false},
+				{"com.", false},
+			} {
+				if got := store.Contains(tt.name); got != tt.want {
+					t.Errorf("Contains(%q) = %v, want %v", tt.name, got, tt.want)
+				}
+			}
+
+			store.Add("example.com")
+			if !store.Contains("example.com.") || !store.Contains("ads.example.com.") || store.Len() != 3 {
+				t.Errorf("after Add(example.com): Len() = %d", store.Len())
+			}
+			if !store.Remove("ads.example.com.") || store.Remove("ads.example.com.") || store.Remove("other.example.") {
+				t.Error("Remove() should report true only for blocked names")
+			}
+			if store.Contains("ads.example.com.") || !store.Contains("example.com.") || store.Len() != 2 {
+				t.Errorf("after Remove(ads.example.com): Len() = %d", store.Len())
+			}
+			store.Add("ads.example.com.")
+			if !store.Contains("ads.example.com.") {
+				t.Error("name added back after Remove() is not blocked")
+			}
+
+			store.Reload(nil)
+			if store.Len() != 0 || store.Contains("example.com.") {
+				t.Errorf("Reload(nil) left %d names", store.Len())
+			}
+		})
+	}
+}
+
+func TestTrieRemovePrunes(t *testing.T) {
+	trie := NewTrie()
+	trie.Reload([]string{"a.b.example.com.", "example.com."})
+	trie.Remove("a.b.example.com.")
+	com := trie.root.children["com"]
+	if example := com.children["example"]; example == nil || len(example.children) != 0 {
+		t.Errorf("nodes of removed name left behind: %+v", example)
+	}
+	if !trie.Contains("example.com.") {
+		t.Error("parent name removed along with child")
+	}
+}
+
+func TestBloomFalsePositiveRate(t *testing.T) {
+	const n, rate = 100000, 0.001
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("host%d.blocked.example.", i)
+	}
+	bloom := NewBloom(0, rate)
+	bloom.Reload(names)
+	for _, name := range names {
+		if !bloom.Contains(name) {
+			t.Fatalf("listed name %s not blocked", name)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if bloom.Contains(fmt.Sprintf("host%d.allowed.example.", i)) {
+			falsePositives++
+		}
+	}
+	if got := float64(falsePositives) / n; got > 2*rate {
+		t.Errorf("false positive rate %.4f, want about %.4f", got, rate)
+	}
+	if bytes := len(bloom.bits) * 8; bytes > 2*n {
+		t.Errorf("filter uses %d bytes for %d names", bytes, n)
+	}
+}
+
+func TestRead(t *testing.T) {
+	list := `# ads
+ads.example.com
+
+0.0.0.0 Tracker.example.net # hosts format
+127.0.0.1	local.example. localhost
+::1 ip6-localhost
+0.0.0.0 0.0.0.0
+! adblock comment
+||metrics.example.org^
+||cdn.example.org^$third-party
+@@||allowed.example.org^
+bad_label!.example
+192.0.2.1
+`
+	names, err := Read(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ads.example.com.", "tracker.example.net.", "local.example.", "metrics.example.org."}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("Read() = %v, want %v", names, want)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	var cfg struct {
+		Short Options `yaml:"short"`
+		Long  Options `yaml:"long"`
+	}
+	if err := yaml.Unmarshal([]byte("short: trie\nlong:\n  type: bloom\n  false_positive_rate: 0.01\n"), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Short.Type != TypeTrie || cfg.Long.Type != TypeBloom || cfg.Long.FalsePositiveRate != 0.01 {
+		t.Errorf("decoded %+v", cfg)
+	}
+	if _, ok := New(Options{}).(*Hash); !ok {
+		t.Error("New() without a type is not a hash set")
+	}
+	if _, ok := New(cfg.Long).(*Bloom); !ok {
+		t.Errorf("New(%+v) is not a bloom filter", cfg.Long)
+	}
+	for _, bad := range []Options{{Type: "btree"}, {Type: TypeBloom, FalsePositiveRate: 1}} {
+		if len(bad.Validate()) == 0 {
+			t.Errorf("Validate(%+v) found no problem", bad)
+		}
+	}
+}
+
+func TestHashAgainstMap(t *testing.T) {
+	hash, want := NewHash(), make(map[string]bool)
+	rng := rand.New(rand.NewSource(1))
+	// few names so probes collide, wrap around the table and get shifted
+	// back on removal
+	for i := 0; i < 20000; i++ {
+		name := fmt.Sprintf("host%d.example.", rng.Intn(64))
+		if rng.Intn(2) == 0 {
+			hash.Add(name)
+			want[name] = true
+		} else if got := hash.Remove(name); got != want[name] {
+			t.Fatalf("step %d: Remove(%s) = %v, want %v", i, name, got, want[name])
+		} else {
+			delete(want, name)
+		}
+	}
+	for i := 0; i < 64; i++ {
+		name := fmt.Sprintf("host%d.example.", i)
+		if hash.Contains(name) != want[name] {
+			t.Errorf("Contains(%s) = %v, want %v", name, !want[name], want[name])
+		}
+	}
+	if hash.Len() != len(want) {
+		t.Errorf("Len() = %d, want %d", hash.Len(), len(want))
+	}
+}
@@ -0,0 +1,131 @@
+package blocklist
+
+import (
+	"strings"
+	"sync"
+)
+
+// Trie is a Store keeping names as paths of labels from the root down, so
+// the labels shared by many names, like com. or a blocked domain with
+// hundreds of tracking hosts below it, are stored once.
+type Trie struct {
+	mu   sync.RWMutex
+	root *trieNode
+	n    int
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	// blocked marks a node whose name is in the store, not just a parent
+	blocked bool
+}
+
+func NewTrie() *Trie {
+	return &Trie{root: &trieNode{}}
+}
+
+// labels calls fn with the labels of a normalized name from the root down
+// until it returns false
+func labels(name string, fn func(label string) bool) {
+	name = strings.TrimSuffix(name, ".")
+	for name != "" {
+		i := strings.LastIndexByte(name, '.')
+		if !fn(name[i+1:]) {
+			return
+		}
+		if i < 0 {
+			return
+		}
+		name = name[:i]
+	}
+}
+
+func (t *Trie) Contains(name string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	node := t.root
+	labels(normalize(name), func(label string) bool {
+		node = node.children[label]
+		return node != nil
+	})
+	return node != nil && node.blocked
+}
+
+func (t *Trie) Add(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if insert(t.root, normalize(name)) {
+		t.n++
+	}
+}
+
+// insert adds name below root and reports whether it was new
+func insert(root *trieNode, name string) bool {
+	node := root
+	labels(name, func(label string) bool {
+		child, ok := node.children[label]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[string]*trieNode, 1)
+			}
+			// clone so the node does not pin the whole line of a list file
+			child = &trieNode{}
+			node.children[strings.Clone(label)] = child
+		}
+		node = child
+		return true
+	})
+	added := !node.blocked
+	node.blocked = true
+	return added
+}
+
+func (t *Trie) Remove(name string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// remember the path to prune the nodes left without names
+	path := []*trieNode{t.root}
+	var keys []string
+	found := true
+	labels(normalize(name), func(label string) bool {
+		child := path[len(path)-1].children[label]
+		if child == nil {
+			found = false
+			return false
+		}
+		path = append(path, child)
+		keys = append(keys, label)
+		return true
+	})
+	node := path[len(path)-1]
+	if !found || !node.blocked {
+		return false
+	}
+	node.blocked = false
+	t.n--
+	for i := len(path) - 1; i > 0; i-- {
+		if path[i].blocked || len(path[i].children) > 0 {
+			break
+		}
+		delete(path[i-1].children, keys[i-1])
+	}
+	return true
+}
+
+func (t *Trie) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.n
+}
+
+func (t *Trie) Reload(names []string) {
+	root, n := &trieNode{}, 0
+	for _, name := range names {
+		if insert(root, normalize(name)) {
+			n++
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.root, t.n = root, n
+}
@@ -0,0 +1,208 @@
+package blockpage
+
+import (
+	"crypto/tls"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/api"
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/logging"
+	"gopkg.in/yaml.v3"
+)
+
+var pageLog = logging.For(logging.Blocklist)
+
+// DefaultAllowFor is how long the allow button unblocks a name when no
+// duration is configured
+const DefaultAllowFor = 10 * time.Minute
+
+// allowPath receives the allow button, out of the way of blocked sites
+const allowPath = "/.mercury/allow"
+
+// Options configures the page served to browsers sent to the server by a
+// blocked name. Blocked names must resolve to the server, see the
+// sinkhole_addresses setting.
+type Options struct {
+	// Listen is the HTTP address of the page, empty to disable it
+	Listen string `yaml:"listen"`
+	// TLSListen also serves the page over HTTPS with Cert and Key.
+	// Browsers warn about the certificate unless it is valid for the
+	// blocked name.
+	TLSListen string `yaml:"tls_listen"`
+	Cert      string `yaml:"cert"`
+	Key       string `yaml:"key"`
+	// AllowFor is how long the allow button unblocks a name
+	AllowFor time.Duration `yaml:"allow_for"`
+}
+
+// UnmarshalYAML also accepts a bare address as a shorthand for listen
+func (o *Options) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&o.Listen)
+	}
+	type options Options
+	return node.Decode((*options)(o))
+}
+
+// Validate reports problems with the options without applying them
+func (o Options) Validate() []error {
+	var errs []error
+	for _, address := range []string{o.Listen, o.TLSListen} {
+		if _, _, err := net.SplitHostPort(address); address != "" && err != nil {
+			errs = append(errs, fmt.Errorf("invalid listen address %q: %v", address, err))
+		}
+	}
+	if o.TLSListen != "" && o.Listen == "" {
+		errs = append(errs, errors.New("tls_listen needs listen"))
+	}
+	if (o.TLSListen != "") != (o.Cert != "" && o.Key != "") {
+		errs = append(errs, errors.New("tls_listen needs cert and key, and only goes with them"))
+	}
+	if o.AllowFor < 0 {
+		errs = append(errs, fmt.Errorf("allow_for must not be negative, got %s", o.AllowFor))
+	}
+	return errs
+}
+
+func (o Options) allowFor() time.Duration {
+	if o.AllowFor == 0 {
+		return DefaultAllowFor
+	}
+	return o.AllowFor
+}
+
+//go:embed page.html
+var pageHTML string
+
+var page = template.Must(template.New("page").Parse(pageHTML))
+
+// pageData fills the page template
+type pageData struct {
+	Name     string
+	List     string
+	Category string
+	Blocked  bool
+	// CanAllow hides the button when no admin could sign in
+	CanAllow bool
+	AllowFor time.Duration
+	Action   string
+	Message  string
+}
+
+// Server serves the block page. Allowing a name takes the token of an
+// admin user of the API, sent as the password of HTTP basic auth.
+type Server struct {
+	opts     Options
+	admin    api.Options
+	sinkhole *blocklist.Sinkhole
+}
+
+func New(opts Options, admin api.Options, sinkhole *blocklist.Sinkhole) *Server {
+	return &Server{opts: opts, admin: admin, sinkhole: sinkhole}
+}
+
+// ListenAndServe serves the page over HTTP, and HTTPS when configured,
+// until one of them fails
+func (s *Server) ListenAndServe() error {
+	errs := make(chan error, 2)
+	go func() {
+		srv := &http.Server{Addr: s.opts.Listen, Handler: s, ReadHeaderTimeout: 5 * time.Second}
+		errs <- srv.ListenAndServe()
+	}()
+	if s.opts.TLSListen != "" {
+		cert, err := tls.LoadX509KeyPair(s.opts.Cert, s.opts.Key)
+		if err != nil {
+			return err
+		}
+		go func() {
+			srv := &http.Server{
+				Addr:              s.opts.TLSListen,
+				Handler:           s,
+				ReadHeaderTimeout: 5 * time.Second,
+				TLSConfig:         &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+			}
+			errs <- srv.ListenAndServeTLS("", "")
+		}()
+	}
+	pageLog.Info("block page running", "address", s.opts.Listen, "tls", s.opts.TLSListen)
+	return <-errs
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == allowPath {
+		s.allow(w, r)
+		return
+	}
+	name := host(r)
+	verdict, blocked := s.sinkhole.Explain(clientIP(r), name)
+	s.render(w, http.StatusForbidden, pageData{Name: name, List: verdict.List, Category: verdict.Category, Blocked: blocked})
+}
+
+// allow unblocks the name of the form for every client after checking the
+// admin token
+func (s *Server) allow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// a page of another site may not submit the form for the admin
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+	}
+	name := r.FormValue("name")
+	_, token, _ := r.BasicAuth()
+	u, ok := s.admin.TokenUser(token)
+	if token == "" || !ok || !u.Role.Allows(api.RoleAdmin) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="mercury admin token", charset="UTF-8"`)
+		s.render(w, http.StatusUnauthorized, pageData{Name: name, Message: "Sign in with the token of an admin user to allow this site."})
+		return
+	}
+	if name == "" || strings.ContainsAny(name, "/:") {
+		http.Error(w, "invalid name", http.StatusBadRequest)
+		return
+	}
+	until := s.sinkhole.Allow(name, s.opts.allowFor())
+	pageLog.Info("allowed blocked name", "user", u.Name, "name", name, "until", until, "client", clientIP(r))
+	http.Redirect(w, r, "http://"+name+"/", http.StatusSeeOther)
+}
+
+func (s *Server) render(w http.ResponseWriter, status int, data pageData) {
+	data.CanAllow = len(s.admin.Users) > 0
+	data.AllowFor = s.opts.allowFor()
+	data.Action = allowPath
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := page.Execute(w, data); err != nil {
+		pageLog.Warn("block page failed", "err", err)
+	}
+}
+
+// host returns the name the browser asked for
+func host(r *http.Request) string {
+	name := r.Host
+	if h, _, err := net.SplitHostPort(name); err == nil {
+		name = h
+	}
+	return strings.ToLower(name)
+}
+
+func clientIP(r *http.Request) net.IP {
+	h, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(h)
+}
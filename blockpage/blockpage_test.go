@@ -0,0 +1,120 @@
+package blockpage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/bernoussama/mercury/api"
+	"github.com/bernoussama/mercury/blocklist"
+)
+
+func testSinkhole() *blocklist.Sinkhole {
+	ads := blocklist.NewHash()
+	ads.Add("ads.example.com.")
+	sinkhole := blocklist.NewSinkhole()
+	sinkhole.Set("easylist", "ads", ads)
+	return sinkhole
+}
+
+var admin = api.Options{Users: []api.User{
+	{Name: "ops", Token: "admin-token", Role: api.RoleAdmin},
+	{Name: "grafana", Token: "read-token", Role: api.RoleRead},
+}}
+
+func TestPage(t *testing.T) {
+	srv := New(Options{}, admin, testSinkhole())
+	tests := []struct {
+		host string
+		want []string
+	}{
+		{"ads.example.com", []string{"This site is blocked", "<code>ads.example.com</code>", "easylist</strong> (ads)", `action="/.mercury/allow"`, "Allow for 10m0s"}},
+		{"ADS.example.com:8080", []string{"This site is blocked"}},
+		{"www.example.com", []string{"Not blocked anymore"}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://"+tt.host+"/some/path", nil)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: status %d, want %d", tt.host, rec.Code, http.StatusForbidden)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("%s: page lacks %q:\n%s", tt.host, want, rec.Body)
+			}
+		}
+	}
+
+	// without admin users nobody could allow the name
+	rec := httptest.NewRecorder()
+	New(Options{}, api.Options{}, testSinkhole()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://ads.example.com/", nil))
+	if strings.Contains(rec.Body.String(), "<form") {
+		t.Error("allow button shown without admin users")
+	}
+}
+
+func TestAllow(t *testing.T) {
+	sinkhole := testSinkhole()
+	srv := New(Options{}, admin, sinkhole)
+	form := url.Values{"name": {"ads.example.com"}}.Encode()
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		origin string
+		status int
+	}{
+		{"get", http.MethodGet, "admin-token", "", http.StatusMethodNotAllowed},
+		{"no token", http.MethodPost, "", "", http.StatusUnauthorized},
+		{"read role", http.MethodPost, "read-token", "", http.StatusUnauthorized},
+		{"wrong token", http.MethodPost, "nope", "", http.StatusUnauthorized},
+		{"other site", http.MethodPost, "admin-token", "http://evil.example", http.StatusForbidden},
+		{"admin", http.MethodPost, "admin-token", "http://ads.example.com", http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "http://ads.example.com/.mercury/allow", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.token != "" {
+			req.SetBasicAuth("", tt.token)
+		}
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		_, blocked := sinkhole.Match(nil, "ads.example.com.")
+		if want := tt.status != http.StatusSeeOther; blocked != want {
+			t.Errorf("%s: blocked = %v, want %v", tt.name, blocked, want)
+		}
+		if loc := rec.Header().Get("Location"); tt.status == http.StatusSeeOther && loc != "http://ads.example.com/" {
+			t.Errorf("%s: redirected to %q", tt.name, loc)
+		}
+	}
+	if sinkhole.Stats().Allowed["ads.example.com."].IsZero() {
+		t.Error("allowed name missing from stats")
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	for _, tt := range []struct {
+		opts Options
+		errs int
+	}{
+		{Options{Listen: ":80"}, 0},
+		{Options{Listen: ":80", TLSListen: ":443", Cert: "c.pem", Key: "k.pem"}, 0},
+		{Options{Listen: "80"}, 1},
+		{Options{Listen: ":80", TLSListen: ":443"}, 1},
+		{Options{TLSListen: ":443", Cert: "c.pem", Key: "k.pem"}, 1},
+		{Options{Listen: ":80", AllowFor: -1}, 1},
+	} {
+		if errs := tt.opts.Validate(); len(errs) != tt.errs {
+			t.Errorf("Validate(%+v) = %v, want %d problems", tt.opts, errs, tt.errs)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Package budget watches the share of queries answered SERVFAIL, timeouts
+// among them, and alerts when it stays past a threshold, catching upstream
+// or network degradation without anyone watching the stats
+package budget
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bernoussama/mercury/logging"
+)
+
+var budgetLog = logging.For(logging.Resolver)
+
+// defaults of the options left unset
+const (
+	DefaultWindow     = 5 * time.Minute
+	DefaultFor        = 5 * time.Minute
+	DefaultMinQueries = 100
+)
+
+// buckets split the window, the rate sliding one bucket at a time
+const buckets = 30
+
+// webhookTimeout bounds the delivery of an alert to the webhook
+const webhookTimeout = 10 * time.Second
+
+// states of alerts
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Options configures the error budget
+type Options struct {
+	// Threshold is the share of queries, like 0.05, answered SERVFAIL past
+	// which the budget is spent. Zero watches nothing.
+	Threshold float64 `yaml:"threshold"`
+	// Window is how far back the rate is measured
+	Window time.Duration `yaml:"window"`
+	// For is how long the rate stays past the threshold before alerting
+	For time.Duration `yaml:"for"`
+	// MinQueries is the queries in the window below which the rate is not
+	// judged, so a handful of failures at night alert no one
+	MinQueries int `yaml:"min_queries"`
+	// Webhook is the URL alerts are POSTed to as JSON, besides the log
+	Webhook string `yaml:"webhook"`
+}
+
+// Validate reports problems with the options without applying them
+func (o Options) Validate() []error {
+	var errs []error
+	if o.Threshold < 0 || o.Threshold > 1 {
+		errs = append(errs, fmt.Errorf("threshold must be between 0 and 1, got %g", o.Threshold))
+	}
+	if o.Window < 0 || o.For < 0 || o.MinQueries < 0 {
+		errs = append(errs, errors.New("window, for and min_queries must not be negative"))
+	}
+	if o.Window > 0 && o.Window < buckets*time.Second {
+		errs = append(errs, fmt.Errorf("window must be at least %s, got %s", buckets*time.Second, o.Window))
+	}
+	if o.Webhook != "" {
+		if u, err := url.Parse(o.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid webhook %q, want an http or https URL", o.Webhook))
+		}
+	}
+	return errs
+}
+
+// withDefaults fills in the options left unset
+func (o Options) withDefaults() Options {
+	if o.Window == 0 {
+		o.Window = DefaultWindow
+	}
+	if o.For == 0 {
+		o.For = DefaultFor
+	}
+	if o.MinQueries == 0 {
+		o.MinQueries = DefaultMinQueries
+	}
+	return o
+}
+
+// Alert tells the error rate stayed past the threshold, or went back
+// under it
+type Alert struct {
+	// Instance labels the server raising the alert
+	Instance  string  `json:"instance,omitempty"`
+	State     string  `json:"state"`
+	Rate      float64 `json:"rate"`
+	Threshold float64 `json:"threshold"`
+	// Queries, ServFail and Timeouts are counted over the window
+	Queries  uint64 `json:"queries"`
+	ServFail uint64 `json:"servfail"`
+	Timeouts uint64 `json:"timeouts"`
+	// Since is when the rate went past the threshold
+	Since time.Time `json:"since"`
+	Time  time.Time `json:"time"`
+}
+
+// Stats are the queries and failures over the window
+type Stats struct {
+	Queries  uint64  `json:"queries"`
+	ServFail uint64  `json:"servfail"`
+	Timeouts uint64  `json:"timeouts"`
+	Rate     float64 `json:"rate"`
+	// Firing is set while an alert is raised
+	Firing bool `json:"firing"`
+}
+
+// bucket counts the queries of one slice of the window
+type bucket struct {
+	start    time.Time
+	queries  uint64
+	servfail uint64
+	timeouts uint64
+}
+
+// Monitor counts the queries answered and raises alerts when too many of
+// them fail. A nil Monitor counts nothing.
+type Monitor struct {
+	opts     Options
+	instance string
+	size     time.Duration
+	// notify delivers alerts, to the log and webhook
+	notify func(Alert)
+
+	mu      sync.Mutex
+	buckets [buckets]bucket
+	// over is when the rate went past the threshold, zero while under
+	over   time.Time
+	firing bool
+}
+
+// New returns a monitor applying opts, alerts labelled with instance. It
+// is nil when opts set no threshold.
+func New(opts Options, instance string) *Monitor {
+	if opts.Threshold <= 0 {
+		return nil
+	}
+	opts = opts.withDefaults()
+	m := &Monitor{opts: opts, instance: instance, size: opts.Window / buckets}
+	m.notify = m.deliver
+	return m
+}
+
+// Count adds a query answered at now, failed when answered SERVFAIL,
+// timed out when no upstream answered in time
+func (m *Monitor) Count(now time.Time, failed, timedOut bool) {
+	if m == nil {
+		return
+	}
+	start := now.Truncate(m.size)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := &m.buckets[start.UnixNano()/int64(m.size)%buckets]
+	if !b.start.Equal(start) {
+		*b = bucket{start: start}
+	}
+	b.queries++
+	if failed {
+		b.servfail++
+	}
+	if timedOut {
+		b.timeouts++
+	}
+}
+
+// stats sums the buckets of the window ending at now, the caller holds mu
+func (m *Monitor) stats(now time.Time) Stats {
+	var s Stats
+	for _, b := range m.buckets {
+		if b.start.IsZero() || !b.start.After(now.Add(-m.opts.Window)) || b.start.After(now) {
+			continue
+		}
+		s.Queries += b.queries
+		s.ServFail += b.servfail
+		s.Timeouts += b.timeouts
+	}
+	if s.Queries > 0 {
+		s.Rate = float64(s.ServFail) / float64(s.Queries)
+	}
+	s.Firing = m.firing
+	return s
+}
+
+// Stats returns the queries and failures of the window ending now
+func (m *Monitor) Stats() Stats {
+	if m == nil {
+		return Stats{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats(time.Now())
+}
+
+// check judges the rate at now, returning the alert to deliver when it
+// has been past the threshold for long enough or went back under it
+func (m *Monitor) check(now time.Time) (Alert, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stats(now)
+	alert := Alert{
+		Instance:  m.instance,
+		Rate:      s.Rate,
+		Threshold: m.opts.Threshold,
+		Queries:   s.Queries,
+		ServFail:  s.ServFail,
+		Timeouts:  s.Timeouts,
+		Since:     m.over,
+		Time:      now,
+	}
+	if s.Queries < uint64(m.opts.MinQueries) || s.Rate <= m.opts.Threshold {
+		m.over = time.Time{}
+		if !m.firing {
+			return Alert{}, false
+		}
+		m.firing = false
+		alert.State = StateResolved
+		return alert, true
+	}
+	if m.over.IsZero() {
+		m.over = now
+		alert.Since = now
+	}
+	if m.firing || now.Sub(m.over) < m.opts.For {
+		return Alert{}, false
+	}
+	m.firing = true
+	alert.State = StateFiring
+	return alert, true
+}
+
+// Run judges the rate every slice of the window until ctx is done
+func (m *Monitor) Run(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	ticker := time.NewTicker(m.size)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if alert, ok := m.check(now); ok {
+				m.notify(alert)
+			}
+		}
+	}
+}
+
+// deliver logs the alert and posts it to the webhook
+func (m *Monitor) deliver(alert Alert) {
+	args := []any{"rate", alert.Rate, "threshold", alert.Threshold, "queries", alert.Queries,
+		"servfail", alert.ServFail, "timeouts", alert.Timeouts, "since", alert.Since}
+	if alert.State == StateFiring {
+		budgetLog.Warn("error budget spent", args...)
+	} else {
+		budgetLog.Info("error rate back under budget", args...)
+	}
+	if m.opts.Webhook == "" {
+		return
+	}
+	if err := post(m.opts.Webhook, alert); err != nil {
+		budgetLog.Warn("error budget webhook failed", "url", m.opts.Webhook, "err", err)
+	}
+}
+
+// post sends the alert to webhook as JSON
+func post(webhook string, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("status %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package budget
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	m := New(Options{Threshold: 0.1, Window: time.Minute, For: 2 * time.Minute, MinQueries: 10}, "ams-1")
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	// a query a second, every fourth failing and every eighth timing out,
+	// the rate judged after each
+	run := func(seconds int, failing bool) []Alert {
+		var alerts []Alert
+		for i := 0; i < seconds; i++ {
+			m.Count(now, failing && i%4 == 0, failing && i%8 == 0)
+			if alert, ok := m.check(now); ok {
+				alerts = append(alerts, alert)
+			}
+			now = now.Add(time.Second)
+		}
+		return alerts
+	}
+
+	if alerts := run(60, false); len(alerts) != 0 {
+		t.Fatalf("alerts %+v without failures", alerts)
+	}
+	if alerts := run(60, true); len(alerts) != 0 {
+		t.Fatalf("alerts %+v before the rate stayed past the threshold for 2m", alerts)
+	}
+	if s := m.stats(now.Add(-time.Second)); s.Queries != 60 || s.ServFail != 15 || s.Timeouts != 8 || s.Rate != 0.25 {
+		t.Errorf("stats %+v, want 60 queries, 15 servfail and 8 timeouts", s)
+	}
+	since := m.over
+	alerts := run(120, true)
+	if len(alerts) != 1 || alerts[0].State != StateFiring || alerts[0].Instance != "ams-1" || !alerts[0].Since.Equal(since) || since.IsZero() {
+		t.Fatalf("alerts %+v, want one firing since %s", alerts, since)
+	}
+	if alerts[0].Time.Sub(since) != 2*time.Minute {
+		t.Errorf("fired %s after going past the threshold, want 2m", alerts[0].Time.Sub(since))
+	}
+	if !m.stats(now).Firing {
+		t.Error("stats not firing")
+	}
+	if alerts := run(120, false); len(alerts) != 1 || alerts[0].State != StateResolved {
+		t.Fatalf("alerts %+v, want one resolved", alerts)
+	}
+
+	// too few queries to judge
+	now = now.Add(time.Hour)
+	if alerts := run(5, true); len(alerts) != 0 || !m.over.IsZero() {
+		t.Errorf("alerts %+v, a handful of failed queries counted against the budget", alerts)
+	}
+}
+
+func TestMonitorWebhook(t *testing.T) {
+	got := make(chan Alert, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&alert) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		got <- alert
+	}))
+	defer srv.Close()
+	m := New(Options{Threshold: 0.5, Webhook: srv.URL}, "")
+	m.deliver(Alert{State: StateFiring, Rate: 0.75, Threshold: 0.5, Queries: 400})
+	select {
+	case alert := <-got:
+		if alert.State != StateFiring || alert.Rate != 0.75 || alert.Queries != 400 {
+			t.Errorf("webhook got %+v", alert)
+		}
+	default:
+		t.Fatal("webhook not called")
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts Options
+		errs int
+	}{
+		{Options{}, 0},
+		{Options{Threshold: 0.05, Window: 10 * time.Minute, For: time.Minute, Webhook: "https://hooks.example.com/dns"}, 0},
+		{Options{Threshold: 1.5}, 1},
+		{Options{Threshold: 0.1, For: -time.Minute}, 1},
+		{Options{Threshold: 0.1, Window: time.Second, Webhook: "hooks.example.com"}, 2},
+	}
+	for _, tt := range tests {
+		if errs := tt.opts.Validate(); len(errs) != tt.errs {
+			t.Errorf("Validate(%+v) = %v, want %d errors", tt.opts, errs, tt.errs)
+		}
+	}
+	if New(Options{}, "") != nil {
+		t.Error("New() without a threshold watches")
+	}
+}
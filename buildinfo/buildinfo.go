@@ -0,0 +1,79 @@
+// Package buildinfo describes the build of the running binary. Release
+// builds set the version, commit and date with ldflags, other builds fall
+// back to what the Go toolchain recorded.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"slices"
+)
+
+// set by Set from the ldflags of main
+var version, commit, date string
+
+// features are the optional features compiled in, the files of their
+// build tags add to them
+var features = []string{"dot", "doh"}
+
+// Set records the version, commit and date given with ldflags, empty
+// values keep what the toolchain recorded
+func Set(v, c, d string) {
+	version, commit, date = v, c, d
+}
+
+// Info describes a build
+type Info struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit,omitempty"`
+	Date      string   `json:"date,omitempty"`
+	GoVersion string   `json:"go_version"`
+	Platform  string   `json:"platform"`
+	Features  []string `json:"features"`
+}
+
+// Get returns the build of the running binary
+func Get() Info {
+	info := Info{
+		Version:   "dev",
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Features:  slices.Sorted(slices.Values(features)),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		// go install records the module version
+		if v := bi.Main.Version; v != "" && v != "(devel)" {
+			info.Version = v
+		}
+		modified := false
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.Commit = s.Value
+			case "vcs.time":
+				info.Date = s.Value
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if modified && info.Commit != "" {
+			info.Commit += "-dirty"
+		}
+	}
+	if version != "" {
+		info.Version = version
+	}
+	if commit != "" {
+		info.Commit = commit
+	}
+	if date != "" {
+		info.Date = date
+	}
+	return info
+}
+
+// String returns the name and version of the build, as answered to
+// version.bind queries
+func (i Info) String() string {
+	return "mercury " + i.Version
+}
@@ -0,0 +1,9 @@
+//go:build chaos
+
+package buildinfo
+
+// upstream queries can be delayed, lost and mangled for resilience tests,
+// see dns/exchange_chaos.go
+func init() {
+	features = append(features, "chaos")
+}
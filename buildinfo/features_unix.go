@@ -0,0 +1,8 @@
+//go:build unix
+
+package buildinfo
+
+// compiled blocklists are memory mapped, see blocklist/mmap_unix.go
+func init() {
+	features = append(features, "mmap")
+}
@@ -4,5 +4,24 @@ type Cache[T any] interface {
 	Get(key string) (*T, bool)
 	Set(key string, msg T, ttl uint32)
 	Delete(key string)
+	// DeleteFunc removes the entries whose key matches and returns how many
+	DeleteFunc(match func(key string) bool) int
 	Invalidate()
+	// Range calls fn for every live entry until it returns false
+	Range(fn func(Entry[T]) bool)
+	Stats() Stats
+}
+
+// Entry is a cached value along with how often it was served
+type Entry[T any] struct {
+	Key   string
+	Value *T
+	Hits  uint64
+}
+
+// Stats are the counters of a cache since it was created
+type Stats struct {
+	Entries int    `json:"entries"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
 }
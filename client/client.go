@@ -0,0 +1,339 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/dns"
+)
+
+// Protocol is the transport queries are sent over
+type Protocol string
+
+const (
+	UDP Protocol = "udp"
+	TCP Protocol = "tcp"
+	// DoT is DNS over TLS, RFC 7858
+	DoT Protocol = "dot"
+	// DoH is DNS over HTTPS, RFC 8484
+	DoH Protocol = "doh"
+)
+
+// defaults for clients created with New
+const (
+	DefaultTimeout = 2 * time.Second
+	DefaultRetries = 2
+)
+
+// Client sends queries to a single server
+type Client struct {
+	// Server is host:port, or the query URL for DoH
+	Server   string
+	Protocol Protocol
+	// Timeout bounds a single attempt
+	Timeout time.Duration
+	// Retries is the number of attempts made after the first one fails
+	Retries int
+	// RecursionDesired sets the RD flag on queries
+	RecursionDesired bool
+	// EDNS adds an OPT record advertising UDPSize to queries
+	EDNS    bool
+	UDPSize uint16
+	// DNSSEC sets the DO bit, implying EDNS
+	DNSSEC bool
+	// CheckingDisabled sets the CD flag
+	CheckingDisabled bool
+	// Padding pads DoT and DoH queries, implying EDNS, which asks the
+	// server to pad its replies too, RFC 8467
+	Padding bool
+
+	// TLSConfig is used by DoT, and by DoH when HTTPClient is nil
+	TLSConfig  *tls.Config
+	HTTPClient *http.Client
+}
+
+// New returns a UDP client for server with the default timeout and retries.
+// A missing port defaults to 53.
+func New(server string) *Client {
+	if _, _, err := net.SplitHostPort(server); err != nil && !strings.Contains(server, "://") {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &Client{
+		Server:           server,
+		Protocol:         UDP,
+		Timeout:          DefaultTimeout,
+		Retries:          DefaultRetries,
+		RecursionDesired: true,
+	}
+}
+
+// Record is a resource record in presentation form
+type Record struct {
+	Name  string
+	Type  dns.QType
+	Class uint16
+	TTL   uint32
+	// Data is the RDATA as it appears in zone files, e.g. "10 mail.example.com."
+	Data string
+}
+
+func (r Record) String() string {
+	return fmt.Sprintf("%s\t%d\tIN\t%s\t%s", r.Name, r.TTL, r.Type, r.Data)
+}
+
+// Response is a parsed reply
+type Response struct {
+	Header     dns.Header
+	Question   dns.Question
+	Answers    []Record
+	Authority  []Record
+	Additional []Record
+	// Options are the EDNS options of the reply
+	Options []dns.Option
+	// Raw is the reply in wire format
+	Raw []byte
+}
+
+// RcodeError is returned by Resolve when the server answers with an error
+type RcodeError struct {
+	Name  string
+	Rcode uint16
+}
+
+func (e *RcodeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Name, dns.RcodeName(e.Rcode))
+}
+
+// NewQuery returns a query for name and qtype with a random ID and the
+// flags and EDNS options of the client
+func (c *Client) NewQuery(name string, qtype dns.QType) *dns.Message {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	query := &dns.Message{
+		Header:   dns.Header{ID: uint16(rand.UintN(1 << 16)), RD: flag(c.RecursionDesired), Z: flag(c.CheckingDisabled), QDCount: 1},
+		Question: dns.Question{DomainName: name, QType: qtype, QClass: 1},
+	}
+	padded := c.Padding && (c.Protocol == DoT || c.Protocol == DoH)
+	if c.EDNS || c.DNSSEC || padded {
+		size := c.UDPSize
+		if size == 0 {
+			size = dns.DefaultUDPSize
+		}
+		query.Additional = append(query.Additional, dns.NewOPT(size, c.DNSSEC))
+		query.Header.ARCount = 1
+	}
+	if padded {
+		query.Pad(dns.QueryPaddingBlockSize)
+	}
+	return query
+}
+
+func flag(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Resolve queries name and returns the answer records. Responses with an
+// error code are reported as *RcodeError.
+func (c *Client) Resolve(ctx context.Context, name string, qtype dns.QType) ([]Record, error) {
+	res, err := c.Query(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	if res.Header.RCODE != dns.RcodeSuccess {
+		return nil, &RcodeError{Name: res.Question.DomainName, Rcode: res.Header.RCODE}
+	}
+	return res.Answers, nil
+}
+
+// Query sends a query for name and qtype and returns the parsed reply
+func (c *Client) Query(ctx context.Context, name string, qtype dns.QType) (*Response, error) {
+	query := c.NewQuery(name, qtype)
+	raw, err := c.Exchange(ctx, query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	res, err := Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if res.Header.ID != query.Header.ID && c.Protocol != DoH {
+		return nil, errors.New("reply ID does not match the query")
+	}
+	return res, nil
+}
+
+// Parse decodes a reply in wire format
+func Parse(raw []byte) (*Response, error) {
+	if len(raw) < 12 {
+		return nil, errors.New("reply shorter than a header")
+	}
+	msg := dns.Message{}
+	if _, err := msg.Decode(raw); err != nil {
+		return nil, err
+	}
+	res := &Response{Header: msg.Header, Question: msg.Question, Raw: raw}
+	res.Answers = records(raw, msg.Answers)
+	res.Authority = records(raw, msg.Authority)
+	res.Additional = records(raw, msg.Additional)
+	res.Options = msg.Options
+	return res, nil
+}
+
+func records(raw []byte, answers []dns.Answer) []Record {
+	recs := make([]Record, 0, len(answers))
+	for i := range answers {
+		answer := &answers[i]
+		if dns.QType(answer.Type) == dns.TypeOPT {
+			continue
+		}
+		name, err := answer.OwnerName(raw)
+		if err != nil {
+			name = "<invalid>"
+		}
+		recs = append(recs, Record{
+			Name:  name,
+			Type:  dns.QType(answer.Type),
+			Class: answer.Class,
+			TTL:   answer.TTL,
+			Data:  answer.Data(raw),
+		})
+	}
+	return recs
+}
+
+// Exchange sends a query in wire format and returns the raw reply,
+// retrying failed attempts. Truncated UDP replies are retried over TCP.
+func (c *Client) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	var err error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		var res []byte
+		res, err = c.exchange(attemptCtx, c.Protocol, query)
+		if err == nil && c.Protocol == UDP && len(res) > 2 && res[2]&0x02 != 0 {
+			res, err = c.exchange(attemptCtx, TCP, query)
+		}
+		cancel()
+		if err == nil {
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, err
+}
+
+func (c *Client) exchange(ctx context.Context, protocol Protocol, query []byte) ([]byte, error) {
+	switch protocol {
+	case UDP, "":
+		return c.exchangeUDP(ctx, query)
+	case TCP:
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", c.Server)
+		if err != nil {
+			return nil, err
+		}
+		return exchangeStream(ctx, conn, query)
+	case DoT:
+		dialer := tls.Dialer{Config: c.TLSConfig}
+		conn, err := dialer.DialContext(ctx, "tcp", c.Server)
+		if err != nil {
+			return nil, err
+		}
+		return exchangeStream(ctx, conn, query)
+	case DoH:
+		return c.exchangeHTTPS(ctx, query)
+	}
+	return nil, fmt.Errorf("unknown protocol %q", protocol)
+}
+
+func (c *Client) exchangeUDP(ctx context.Context, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", c.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// ignore stray datagrams for other queries
+		if n >= 2 && buf[0] == query[0] && buf[1] == query[1] {
+			return buf[:n], nil
+		}
+	}
+}
+
+// exchangeStream sends a length prefixed query over TCP or TLS, RFC 1035 4.2.2
+func exchangeStream(ctx context.Context, conn net.Conn, query []byte) ([]byte, error) {
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	msg := binary.BigEndian.AppendUint16(make([]byte, 0, len(query)+2), uint16(len(query)))
+	if _, err := conn.Write(append(msg, query...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	res := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) exchangeHTTPS(ctx context.Context, query []byte) ([]byte, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: c.TLSConfig}}
+	}
+	// RFC 8484 recommends ID 0 for cache friendliness
+	query = bytes.Clone(query)
+	query[0], query[1] = 0, 0
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Server, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh server replied %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 65535))
+}
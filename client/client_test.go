@@ -0,0 +1,240 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/dns"
+)
+
+var testZone = dns.Zone{
+	Origin: "example.com.",
+	TTL:    300,
+	A:      []dns.Record{{Name: "@", Value: "192.0.2.1"}},
+	MX:     []dns.MXRecord{{Name: "@", Host: "mail", Preference: 10}},
+}
+
+// answer builds an authoritative reply to query from zone
+func answer(t *testing.T, zone *dns.Zone, query []byte, truncate bool) []byte {
+	t.Helper()
+	msg := dns.Message{}
+	if _, err := msg.Decode(query); err != nil {
+		t.Fatal(err)
+	}
+	b := dns.NewResponse(&msg).Authoritative(true)
+	if truncate {
+		res := b.Encode()
+		res[2] |= 0x02
+		return res
+	}
+	answers := zone.Lookup(msg.Question.DomainName, msg.Question.QType, msg.Question.QClass)
+	if len(answers) == 0 {
+		b.SetRcode(dns.RcodeNameError)
+	}
+	return b.Answer(answers...).Encode()
+}
+
+// serveUDP answers queries from zone on a local socket, dropping the first
+// drop ones and setting TC when truncate is true
+func serveUDP(t *testing.T, zone dns.Zone, addr string, drop int, truncate bool) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if drop > 0 {
+				drop--
+				continue
+			}
+			conn.WriteTo(answer(t, &zone, buf[:n], truncate), from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func serveTCP(t *testing.T, zone dns.Zone, addr string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var length [2]byte
+			io.ReadFull(conn, length[:])
+			query := make([]byte, binary.BigEndian.Uint16(length[:]))
+			io.ReadFull(conn, query)
+			res := answer(t, &zone, query, false)
+			conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(res))), res...))
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestResolve(t *testing.T) {
+	c := New(serveUDP(t, testZone, "127.0.0.1:0", 0, false))
+
+	tests := []struct {
+		name  string
+		qtype dns.QType
+		want  []Record
+		rcode uint16
+	}{
+		{"example.com", dns.TypeA, []Record{{"example.com.", dns.TypeA, 1, 300, "192.0.2.1"}}, 0},
+		{"example.com.", dns.TypeMX, []Record{{"example.com.", dns.TypeMX, 1, 300, "10 mail.example.com."}}, 0},
+		{"missing.example.com", dns.TypeA, nil, dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		got, err := c.Resolve(context.Background(), tt.name, tt.qtype)
+		if tt.rcode != 0 {
+			var rerr *RcodeError
+			if !errors.As(err, &rerr) || rerr.Rcode != tt.rcode {
+				t.Errorf("Resolve(%s) error = %v, want rcode %d", tt.name, err, tt.rcode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Resolve(%s): %v", tt.name, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("Resolve(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Resolve(%s)[%d] = %+v, want %+v", tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	c := New(serveUDP(t, testZone, "127.0.0.1:0", 1, false))
+	c.Timeout = 100 * time.Millisecond
+	c.Retries = 0
+	if _, err := c.Resolve(context.Background(), "example.com", dns.TypeA); err == nil {
+		t.Fatal("expected a timeout without retries")
+	}
+
+	c = New(serveUDP(t, testZone, "127.0.0.1:0", 1, false))
+	c.Timeout = 100 * time.Millisecond
+	c.Retries = 1
+	if _, err := c.Resolve(context.Background(), "example.com", dns.TypeA); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+}
+
+func TestTruncatedFallsBackToTCP(t *testing.T) {
+	addr := serveTCP(t, testZone, "127.0.0.1:0")
+	serveUDP(t, testZone, addr, 0, true)
+
+	got, err := New(addr).Resolve(context.Background(), "example.com", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Data != "192.0.2.1" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestDoH(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(answer(t, &testZone, query, false))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	c.Protocol = DoH
+	got, err := c.Resolve(context.Background(), "example.com", dns.TypeMX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Data != "10 mail.example.com." {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestLookupHost(t *testing.T) {
+	zone := testZone
+	zone.A = []dns.Record{{Name: "@", Value: "192.0.2.1"}, {Name: "@", Value: "192.0.2.2"}}
+	zone.AAAA = []dns.Record{{Name: "@", Value: "2001:db8::1"}}
+
+	c := New(serveUDP(t, zone, "127.0.0.1:0", 0, false))
+	got, err := c.LookupHost(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2001:db8::1", "192.0.2.1", "192.0.2.2"}
+	if len(got) != len(want) {
+		t.Fatalf("LookupHost = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("LookupHost[%d] = %v, want %s", i, got[i], want[i])
+		}
+	}
+
+	if _, err := c.LookupHost(context.Background(), "missing.example.com"); err == nil {
+		t.Error("expected an error for a missing name")
+	}
+}
+
+func TestNewQueryFlags(t *testing.T) {
+	c := New("127.0.0.1")
+	if c.Server != "127.0.0.1:53" {
+		t.Errorf("Server = %q, want the default port", c.Server)
+	}
+	q := c.NewQuery("example.com", dns.TypeA)
+	if q.Header.RD != 1 || q.Header.Z != 0 || len(q.Additional) != 0 {
+		t.Errorf("default query header = %+v, additional %v", q.Header, q.Additional)
+	}
+
+	c.RecursionDesired = false
+	c.CheckingDisabled = true
+	c.DNSSEC = true
+	q = c.NewQuery("example.com", dns.TypeA)
+	if q.Header.RD != 0 || q.Header.Z != 1 || q.Header.ARCount != 1 {
+		t.Fatalf("query header = %+v", q.Header)
+	}
+	opt := q.Additional[0]
+	if dns.QType(opt.Type) != dns.TypeOPT || opt.Class != dns.DefaultUDPSize || opt.TTL&(1<<15) == 0 {
+		t.Errorf("OPT = %+v, want DO bit and default size", opt)
+	}
+	c.Padding = true
+	if q = c.NewQuery("example.com", dns.TypeA); len(q.Encode()) >= dns.QueryPaddingBlockSize {
+		t.Errorf("udp query padded to %d bytes", len(q.Encode()))
+	}
+	c.Protocol = DoT
+	if q = c.NewQuery("example.com", dns.TypeA); len(q.Encode()) != dns.QueryPaddingBlockSize {
+		t.Errorf("dot query of %d bytes, want %d", len(q.Encode()), dns.QueryPaddingBlockSize)
+	}
+	if _, err := Parse(q.Encode()[:4]); err == nil {
+		t.Error("expected an error for a short reply")
+	}
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+
+	"github.com/bernoussama/mercury/dns"
+)
+
+// LookupHost queries the A and AAAA records of name concurrently and
+// returns the addresses in the order connections should be attempted,
+// alternating families starting with IPv6 as in RFC 8305 section 4.
+// It fails only when neither lookup returns an address.
+func (c *Client) LookupHost(ctx context.Context, name string) ([]netip.Addr, error) {
+	type result struct {
+		addrs []netip.Addr
+		err   error
+	}
+	lookup := func(qtype dns.QType, out chan<- result) {
+		records, err := c.Resolve(ctx, name, qtype)
+		out <- result{addrs(records, qtype), err}
+	}
+	v4, v6 := make(chan result, 1), make(chan result, 1)
+	go lookup(dns.TypeA, v4)
+	go lookup(dns.TypeAAAA, v6)
+	r4, r6 := <-v4, <-v6
+
+	if len(r4.addrs) == 0 && len(r6.addrs) == 0 {
+		if err := errors.Join(r6.err, r4.err); err != nil {
+			return nil, err
+		}
+		return nil, &RcodeError{Name: name, Rcode: dns.RcodeNameError}
+	}
+	return interleave(r6.addrs, r4.addrs), nil
+}
+
+// addrs extracts the addresses of qtype, skipping CNAMEs and the like
+func addrs(records []Record, qtype dns.QType) []netip.Addr {
+	var out []netip.Addr
+	for _, r := range records {
+		if r.Type != qtype {
+			continue
+		}
+		if addr, err := netip.ParseAddr(r.Data); err == nil {
+			out = append(out, addr)
+		}
+	}
+	return out
+}
+
+// interleave alternates between the preferred and the other family
+func interleave(preferred, other []netip.Addr) []netip.Addr {
+	out := make([]netip.Addr, 0, len(preferred)+len(other))
+	for i := 0; i < len(preferred) || i < len(other); i++ {
+		if i < len(preferred) {
+			out = append(out, preferred[i])
+		}
+		if i < len(other) {
+			out = append(out, other[i])
+		}
+	}
+	return out
+}
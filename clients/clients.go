@@ -0,0 +1,408 @@
+// Package clients names the clients of the server after the hostnames of
+// their DHCP leases, or a static map, and counts their queries
+package clients
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bernoussama/mercury/logging"
+	"github.com/bernoussama/mercury/privacy"
+)
+
+var clientsLog = logging.For(logging.Server)
+
+// DefaultRefresh is how often lease files are checked for changes when no
+// interval is configured
+const DefaultRefresh = 30 * time.Second
+
+// MaxClients bounds the clients whose queries are counted, the queries of
+// further clients are not
+const MaxClients = 10000
+
+// Options configures where client names come from
+type Options struct {
+	// Leases are DHCP lease files naming clients after the hostname they
+	// sent
+	Leases []LeaseFile `yaml:"leases"`
+	// Static names clients by MAC or IP address, over the hostnames of
+	// their leases
+	Static map[string]string `yaml:"static"`
+	// Refresh is how often lease files are checked for changes
+	Refresh time.Duration `yaml:"refresh"`
+	// Domain registers the named clients as hosts of this local domain,
+	// like lan, none when empty
+	Domain string `yaml:"domain"`
+}
+
+// Validate reports problems with the options without applying them
+func (o Options) Validate() []error {
+	var errs []error
+	for _, file := range o.Leases {
+		switch {
+		case file.Path == "":
+			errs = append(errs, errors.New("lease file without a path"))
+		case file.Format != "" && file.Format != FormatDnsmasq && file.Format != FormatKea:
+			errs = append(errs, fmt.Errorf("lease file %s: unknown format %q, want %s or %s", file.Path, file.Format, FormatDnsmasq, FormatKea))
+		}
+		if _, err := os.Stat(file.Path); file.Path != "" && err != nil {
+			errs = append(errs, fmt.Errorf("unreadable lease file: %v", err))
+		}
+	}
+	for address, name := range o.Static {
+		if _, err := net.ParseMAC(address); err != nil && net.ParseIP(address) == nil {
+			errs = append(errs, fmt.Errorf("static name %q: %q is no MAC or IP address", name, address))
+		}
+		if name == "" {
+			errs = append(errs, fmt.Errorf("static address %s without a name", address))
+		}
+	}
+	if o.Refresh < 0 {
+		errs = append(errs, fmt.Errorf("refresh must not be negative, got %s", o.Refresh))
+	}
+	if o.Domain != "" && !validDomain(o.Domain) {
+		errs = append(errs, fmt.Errorf("invalid domain %q", o.Domain))
+	}
+	return errs
+}
+
+func (o Options) refresh() time.Duration {
+	if o.Refresh == 0 {
+		return DefaultRefresh
+	}
+	return o.Refresh
+}
+
+// Stats describes the queries of one client
+type Stats struct {
+	Address  string    `json:"address"`
+	Name     string    `json:"name,omitempty"`
+	MAC      string    `json:"mac,omitempty"`
+	Queries  uint64    `json:"queries"`
+	Blocked  uint64    `json:"blocked"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type counter struct {
+	queries  atomic.Uint64
+	blocked  atomic.Uint64
+	lastSeen atomic.Int64
+}
+
+// Directory names clients and counts their queries. A nil directory names
+// no client and counts nothing.
+type Directory struct {
+	opts Options
+	// static holds the static names by normalized MAC or IP address
+	static map[string]string
+
+	mu sync.RWMutex
+	// files holds the leases and modification time of each lease file
+	files    map[string][]Lease
+	modTimes map[string]time.Time
+	// names and macs are by IP address
+	names map[string]string
+	macs  map[string]string
+	// onChange is called after the names change
+	onChange func()
+
+	countMu  sync.RWMutex
+	counters map[string]*counter
+}
+
+func New(opts Options) *Directory {
+	d := &Directory{
+		opts:     opts,
+		static:   make(map[string]string),
+		files:    make(map[string][]Lease),
+		modTimes: make(map[string]time.Time),
+		counters: make(map[string]*counter),
+	}
+	for address, name := range opts.Static {
+		d.static[normalize(address)] = name
+	}
+	d.index()
+	return d
+}
+
+// normalize spells MAC and IP addresses one way
+func normalize(address string) string {
+	if mac, err := net.ParseMAC(address); err == nil {
+		return mac.String()
+	}
+	if ip := net.ParseIP(address); ip != nil {
+		return ip.String()
+	}
+	return strings.ToLower(address)
+}
+
+// Load reads the lease files changed since the last load. A file that
+// fails keeps its previous leases.
+func (d *Directory) Load() error {
+	var errs []error
+	changed := false
+	for _, file := range d.opts.Leases {
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		d.mu.RLock()
+		modTime, seen := d.modTimes[file.Path]
+		d.mu.RUnlock()
+		if seen && modTime.Equal(info.ModTime()) {
+			continue
+		}
+		leases, err := ReadLeases(file)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		d.mu.Lock()
+		d.files[file.Path] = leases
+		d.modTimes[file.Path] = info.ModTime()
+		d.mu.Unlock()
+		changed = true
+		clientsLog.Debug("read lease file", "file", file.Path, "leases", len(leases))
+	}
+	if changed {
+		d.index()
+	}
+	return errors.Join(errs...)
+}
+
+// OnChange calls fn after the names change, replacing an earlier fn. Set
+// it before Load.
+func (d *Directory) OnChange(fn func()) {
+	d.mu.Lock()
+	d.onChange = fn
+	d.mu.Unlock()
+}
+
+// index rebuilds the names and MAC addresses by IP from the leases, later
+// files overriding earlier ones, and the static names
+func (d *Directory) index() {
+	d.mu.Lock()
+	defer func() {
+		fn := d.onChange
+		d.mu.Unlock()
+		if fn != nil {
+			fn()
+		}
+	}()
+	d.names = make(map[string]string)
+	d.macs = make(map[string]string)
+	for _, file := range d.opts.Leases {
+		for _, l := range d.files[file.Path] {
+			ip := l.IP.String()
+			name := l.Hostname
+			if l.MAC != "" {
+				d.macs[ip] = l.MAC
+				if static, ok := d.static[normalize(l.MAC)]; ok {
+					name = static
+				}
+			}
+			if name != "" {
+				d.names[ip] = name
+			}
+		}
+	}
+	for address, name := range d.static {
+		if net.ParseIP(address) != nil {
+			d.names[address] = name
+		}
+	}
+}
+
+// Watch reloads the lease files when they change until ctx is done
+func (d *Directory) Watch(ctx context.Context) {
+	if len(d.opts.Leases) == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(d.opts.refresh())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.Load(); err != nil {
+					clientsLog.Warn("lease files not reloaded", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// Name returns the name of the client at ip, empty when it has none
+func (d *Directory) Name(ip net.IP) string {
+	if d == nil || ip == nil {
+		return ""
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.names[ip.String()]
+}
+
+// Hosts returns the addresses of each named client by host label, the name
+// made a valid DNS label. Clients without such a name are left out.
+func (d *Directory) Hosts() map[string][]net.IP {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	hosts := make(map[string][]net.IP)
+	for address, name := range d.names {
+		label := hostLabel(name)
+		if label == "" {
+			continue
+		}
+		hosts[label] = append(hosts[label], net.ParseIP(address))
+	}
+	for _, ips := range hosts {
+		sort.Slice(ips, func(i, j int) bool { return bytes.Compare(ips[i].To16(), ips[j].To16()) < 0 })
+	}
+	return hosts
+}
+
+// hostLabel returns the first label of name lowercased, with the
+// characters DNS labels do not allow replaced by hyphens
+func hostLabel(name string) string {
+	label, _, _ := strings.Cut(strings.ToLower(name), ".")
+	label = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '-'
+	}, label)
+	label = strings.Trim(label, "-")
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	return label
+}
+
+// validDomain reports whether domain is a name of valid labels
+func validDomain(domain string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || hostLabel(label) != strings.ToLower(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// Leases returns the current leases of every lease file
+func (d *Directory) Leases() []Lease {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var leases []Lease
+	for _, file := range d.opts.Leases {
+		leases = append(leases, d.files[file.Path]...)
+	}
+	return leases
+}
+
+// Count records a query of the client at ip, blocked or not
+func (d *Directory) Count(ip net.IP, blocked bool) {
+	if d == nil || ip == nil {
+		return
+	}
+	address := ip.String()
+	d.countMu.RLock()
+	c, ok := d.counters[address]
+	d.countMu.RUnlock()
+	if !ok {
+		d.countMu.Lock()
+		if c, ok = d.counters[address]; !ok {
+			if len(d.counters) >= MaxClients {
+				d.countMu.Unlock()
+				return
+			}
+			c = new(counter)
+			d.counters[address] = c
+		}
+		d.countMu.Unlock()
+	}
+	c.queries.Add(1)
+	if blocked {
+		c.blocked.Add(1)
+	}
+	c.lastSeen.Store(time.Now().Unix())
+}
+
+// Anonymize counts the queries of the clients last seen before cutoff
+// under their network as truncated by opts, forgetting their addresses.
+// Stats name no device for a network.
+func (d *Directory) Anonymize(opts privacy.Options, cutoff time.Time) {
+	if d == nil || !opts.TruncatesAddresses() {
+		return
+	}
+	d.countMu.Lock()
+	defer d.countMu.Unlock()
+	for address, c := range d.counters {
+		ip := net.ParseIP(address)
+		if ip == nil || c.lastSeen.Load() >= cutoff.Unix() {
+			// a network already, or seen within the retention
+			continue
+		}
+		network := opts.Address(ip)
+		if network == address {
+			continue
+		}
+		n, ok := d.counters[network]
+		if !ok {
+			n = new(counter)
+			d.counters[network] = n
+		}
+		n.queries.Add(c.queries.Load())
+		n.blocked.Add(c.blocked.Load())
+		if seen := c.lastSeen.Load(); seen > n.lastSeen.Load() {
+			n.lastSeen.Store(seen)
+		}
+		delete(d.counters, address)
+	}
+}
+
+// Stats returns the counted clients with their names, the busiest first
+func (d *Directory) Stats() []Stats {
+	stats := []Stats{}
+	if d == nil {
+		return stats
+	}
+	d.countMu.RLock()
+	for address, c := range d.counters {
+		stats = append(stats, Stats{
+			Address:  address,
+			Queries:  c.queries.Load(),
+			Blocked:  c.blocked.Load(),
+			LastSeen: time.Unix(c.lastSeen.Load(), 0),
+		})
+	}
+	d.countMu.RUnlock()
+	d.mu.RLock()
+	for i := range stats {
+		stats[i].Name = d.names[stats[i].Address]
+		stats[i].MAC = d.macs[stats[i].Address]
+	}
+	d.mu.RUnlock()
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Queries != stats[j].Queries {
+			return stats[i].Queries > stats[j].Queries
+		}
+		return stats[i].Address < stats[j].Address
+	})
+	return stats
+}
@@ -0,0 +1,202 @@
+package clients
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/privacy"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseDnsmasq(t *testing.T) {
+	later := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	data := later + " AA:BB:CC:DD:EE:01 192.168.1.42 laptop 01:aa:bb:cc:dd:ee:01\n" +
+		"0 aa:bb:cc:dd:ee:02 192.168.1.2 * *\n" +
+		"duid 00:01:00:01:2c:4f:5e:6d:aa:bb:cc:dd:ee:01\n" +
+		later + " 12345678 fd00::42 laptop 00:01:00:01\n"
+	leases, err := ParseDnsmasq(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 3 {
+		t.Fatalf("got %d leases, want 3: %+v", len(leases), leases)
+	}
+	if l := leases[0]; l.Hostname != "laptop" || l.MAC != "aa:bb:cc:dd:ee:01" || !l.IP.Equal(net.ParseIP("192.168.1.42")) || l.Expires.IsZero() {
+		t.Errorf("lease %+v", l)
+	}
+	if l := leases[1]; l.Hostname != "" || !l.Expires.IsZero() {
+		t.Errorf("lease without hostname or expiry %+v", l)
+	}
+	if _, err := ParseDnsmasq(strings.NewReader("soon aa:bb 10.0.0.1 x\n")); err == nil {
+		t.Error("invalid expiry accepted")
+	}
+}
+
+func TestReadLeasesKea(t *testing.T) {
+	now := time.Now()
+	later, earlier := now.Add(time.Hour).Unix(), now.Add(-time.Hour).Unix()
+	data := "address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context\n" +
+		"192.168.1.10,aa:bb:cc:dd:ee:10,,3600," + strconv.FormatInt(later, 10) + ",1,0,0,printer.lan.,0,\n" +
+		"192.168.1.11,aa:bb:cc:dd:ee:11,,3600," + strconv.FormatInt(later, 10) + ",1,0,0,tv,0,\n" +
+		// renewed then released
+		"192.168.1.11,aa:bb:cc:dd:ee:11,,3600," + strconv.FormatInt(earlier, 10) + ",1,0,0,tv,0,\n" +
+		"192.168.1.12,aa:bb:cc:dd:ee:12,,3600," + strconv.FormatInt(later, 10) + ",1,0,0,declined,1,\n"
+	path := filepath.Join(t.TempDir(), "kea-leases4.csv")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	leases, err := ReadLeases(LeaseFile{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 1 || leases[0].Hostname != "printer.lan" || leases[0].MAC != "aa:bb:cc:dd:ee:10" {
+		t.Errorf("leases %+v, want the printer only", leases)
+	}
+	if _, err := ReadLeases(LeaseFile{Path: path, Format: FormatDnsmasq}); err == nil {
+		t.Error("Kea file read as dnsmasq")
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	write := func(data string, mod time.Time) {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("0 aa:bb:cc:dd:ee:01 192.168.1.42 android-5f2e *\n0 aa:bb:cc:dd:ee:02 192.168.1.43 laptop *\n", time.Now().Add(-time.Minute))
+	d := New(Options{
+		Leases: []LeaseFile{{Path: path}},
+		Static: map[string]string{"AA-BB-CC-DD-EE-01": "kids-tablet", "192.168.1.2": "nas"},
+	})
+	if err := d.Load(); err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]string{"192.168.1.42": "kids-tablet", "192.168.1.43": "laptop", "192.168.1.2": "nas", "192.168.1.99": ""} {
+		if name := d.Name(net.ParseIP(ip)); name != want {
+			t.Errorf("Name(%s) = %q, want %q", ip, name, want)
+		}
+	}
+
+	// a changed file is read again, a broken one keeps its leases
+	write("0 aa:bb:cc:dd:ee:02 192.168.1.44 laptop *\n", time.Now())
+	if err := d.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if d.Name(net.ParseIP("192.168.1.44")) != "laptop" || d.Name(net.ParseIP("192.168.1.43")) != "" {
+		t.Error("changed lease file not read again")
+	}
+	write("garbage\n", time.Now().Add(time.Minute))
+	if err := d.Load(); err == nil {
+		t.Error("broken lease file loaded")
+	}
+	if len(d.Leases()) != 1 {
+		t.Errorf("leases %+v after a failed load", d.Leases())
+	}
+
+	d.Count(net.ParseIP("192.168.1.44"), false)
+	d.Count(net.ParseIP("192.168.1.44"), true)
+	d.Count(net.ParseIP("192.168.1.2"), false)
+	d.Count(nil, false)
+	stats := d.Stats()
+	if len(stats) != 2 || stats[0].Name != "laptop" || stats[0].MAC != "aa:bb:cc:dd:ee:02" || stats[0].Queries != 2 || stats[0].Blocked != 1 || stats[1].Name != "nas" {
+		t.Errorf("stats %+v", stats)
+	}
+
+	var none *Directory
+	none.Count(net.ParseIP("192.168.1.44"), true)
+	if none.Name(net.ParseIP("192.168.1.44")) != "" || len(none.Stats()) != 0 {
+		t.Error("nil directory names or counts")
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	d := New(Options{Static: map[string]string{"192.168.1.44": "laptop"}})
+	for _, ip := range []string{"192.168.1.44", "192.168.1.2", "2001:db8:0:1::5"} {
+		d.Count(net.ParseIP(ip), false)
+	}
+	// seen within the retention, nothing changes
+	d.Anonymize(privacy.Options{IPv4Prefix: 24}, time.Now().Add(-time.Hour))
+	if stats := d.Stats(); len(stats) != 3 {
+		t.Errorf("stats %+v, want three clients", stats)
+	}
+	d.Anonymize(privacy.Options{IPv4Prefix: 24}, time.Now().Add(time.Second))
+	d.Count(net.ParseIP("192.168.1.2"), true)
+	d.Anonymize(privacy.Options{IPv4Prefix: 24}, time.Now().Add(time.Second))
+	stats := d.Stats()
+	if len(stats) != 2 || stats[0].Address != "192.168.1.0/24" || stats[0].Name != "" || stats[0].Queries != 3 || stats[0].Blocked != 1 || stats[1].Address != "2001:db8:0:1::5" {
+		t.Errorf("stats %+v, want the IPv4 clients under their network", stats)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var opts Options
+	data := "leases:\n  - " + path + "\n  - path: " + path + "\n    format: kea\nstatic:\n  aa:bb:cc:dd:ee:01: kids-tablet\n  192.168.1.2: nas\nrefresh: 1m\n"
+	if err := yaml.Unmarshal([]byte(data), &opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.Leases) != 2 || opts.Leases[0].Path != path || opts.Leases[1].Format != FormatKea || opts.refresh() != time.Minute {
+		t.Errorf("decoded %+v", opts)
+	}
+	if errs := opts.Validate(); len(errs) > 0 {
+		t.Errorf("Validate() = %v", errs)
+	}
+	bad := Options{
+		Leases:  []LeaseFile{{Path: path, Format: "isc"}, {Path: "/nonexistent/leases"}},
+		Static:  map[string]string{"laptop": "x", "10.0.0.1": ""},
+		Refresh: -time.Second,
+	}
+	if errs := bad.Validate(); len(errs) != 5 {
+		t.Errorf("Validate() = %v, want 5 problems", errs)
+	}
+}
+
+func TestHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	data := "0 aa:bb:cc:dd:ee:01 192.168.1.42 Kids_Tablet *\n0 aa:bb:cc:dd:ee:01 fd00::42 Kids_Tablet *\n" +
+		"0 aa:bb:cc:dd:ee:02 192.168.1.43 printer.home.arpa *\n0 aa:bb:cc:dd:ee:03 192.168.1.44 * *\n0 aa:bb:cc:dd:ee:04 192.168.1.45 ___ *\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d := New(Options{Leases: []LeaseFile{{Path: path}}, Static: map[string]string{"192.168.1.2": "NAS box"}, Domain: "lan"})
+	changes := 0
+	d.OnChange(func() { changes++ })
+	if err := d.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if changes != 1 {
+		t.Errorf("OnChange called %d times, want 1", changes)
+	}
+	hosts := d.Hosts()
+	want := map[string]string{"kids-tablet": "192.168.1.42 fd00::42", "printer": "192.168.1.43", "nas-box": "192.168.1.2"}
+	if len(hosts) != len(want) {
+		t.Errorf("hosts %v, want %v", hosts, want)
+	}
+	for label, addrs := range want {
+		var got []string
+		for _, ip := range hosts[label] {
+			got = append(got, ip.String())
+		}
+		if strings.Join(got, " ") != addrs {
+			t.Errorf("host %s = %v, want %s", label, got, addrs)
+		}
+	}
+
+	for domain, valid := range map[string]bool{"lan": true, "home.arpa.": true, "my_lan": false, "lan..": false, "-lan": false} {
+		if errs := (Options{Domain: domain}).Validate(); (len(errs) == 0) != valid {
+			t.Errorf("Validate(domain %q) = %v", domain, errs)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package clients
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// lease file formats
+const (
+	FormatDnsmasq = "dnsmasq"
+	FormatKea     = "kea"
+)
+
+// Lease is an address handed out by a DHCP server
+type Lease struct {
+	IP  net.IP `json:"ip"`
+	MAC string `json:"mac,omitempty"`
+	// Hostname is the name the client sent, empty when none
+	Hostname string `json:"hostname,omitempty"`
+	// Expires is zero for leases that never expire
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// LeaseFile is a lease file of a DHCP server
+type LeaseFile struct {
+	Path string `yaml:"path"`
+	// Format is dnsmasq or kea, guessed from the first line when empty
+	Format string `yaml:"format"`
+}
+
+// UnmarshalYAML also accepts a bare path
+func (f *LeaseFile) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&f.Path)
+	}
+	type leaseFile LeaseFile
+	return node.Decode((*leaseFile)(f))
+}
+
+// ReadLeases returns the unexpired leases of the file
+func ReadLeases(file LeaseFile) ([]Lease, error) {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	format := file.Format
+	if format == "" {
+		// Kea memfiles start with a CSV header
+		first, _ := r.Peek(8)
+		format = FormatDnsmasq
+		if string(first) == "address," {
+			format = FormatKea
+		}
+	}
+	var leases []Lease
+	switch format {
+	case FormatDnsmasq:
+		leases, err = ParseDnsmasq(r)
+	case FormatKea:
+		leases, err = ParseKea(r)
+	default:
+		return nil, fmt.Errorf("unknown lease file format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file.Path, err)
+	}
+	return current(leases, time.Now()), nil
+}
+
+// current keeps the last lease of each address, dropping it when expired
+func current(leases []Lease, now time.Time) []Lease {
+	index := make(map[string]int)
+	var last []Lease
+	for _, l := range leases {
+		if i, ok := index[l.IP.String()]; ok {
+			last[i] = l
+			continue
+		}
+		index[l.IP.String()] = len(last)
+		last = append(last, l)
+	}
+	kept := last[:0]
+	for _, l := range last {
+		if l.Expires.IsZero() || now.Before(l.Expires) {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
+
+// ParseDnsmasq reads leases in the format of dnsmasq: expiry, MAC address,
+// IP address, hostname and client id per line, with a star for no hostname.
+// The MAC of DHCPv6 leases is their IAID.
+func ParseDnsmasq(r io.Reader) ([]Lease, error) {
+	var leases []Lease
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "duid" {
+			continue
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("line %d: want expiry, MAC, IP and hostname", n)
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", n, fields[0])
+		}
+		ip := net.ParseIP(fields[2])
+		if ip == nil {
+			return nil, fmt.Errorf("line %d: invalid IP %q", n, fields[2])
+		}
+		l := Lease{IP: ip, MAC: strings.ToLower(fields[1])}
+		if fields[3] != "*" {
+			l.Hostname = fields[3]
+		}
+		if expiry != 0 {
+			l.Expires = time.Unix(expiry, 0)
+		}
+		leases = append(leases, l)
+	}
+	return leases, scanner.Err()
+}
+
+// ParseKea reads a Kea memfile, the CSV of DHCPv4 or DHCPv6 leases Kea
+// appends to, skipping declined and reclaimed leases
+func ParseKea(r io.Reader) ([]Lease, error) {
+	records := csv.NewReader(r)
+	records.FieldsPerRecord = -1
+	header, err := records.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	column := make(map[string]int)
+	for i, name := range header {
+		column[name] = i
+	}
+	for _, name := range []string{"address", "expire", "hostname"} {
+		if _, ok := column[name]; !ok {
+			return nil, fmt.Errorf("header lacks the %s column", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := column[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	var leases []Lease
+	for {
+		record, err := records.Read()
+		if errors.Is(err, io.EOF) {
+			return leases, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if state := field(record, "state"); state != "" && state != "0" {
+			continue
+		}
+		ip := net.ParseIP(field(record, "address"))
+		if ip == nil {
+			line, _ := records.FieldPos(0)
+			return nil, fmt.Errorf("line %d: invalid address %q", line, field(record, "address"))
+		}
+		l := Lease{
+			IP:       ip,
+			MAC:      strings.ToLower(field(record, "hwaddr")),
+			Hostname: strings.TrimSuffix(field(record, "hostname"), "."),
+		}
+		if expire, err := strconv.ParseInt(field(record, "expire"), 10, 64); err == nil && expire != 0 {
+			l.Expires = time.Unix(expire, 0)
+		}
+		leases = append(leases, l)
+	}
+}
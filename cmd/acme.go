@@ -0,0 +1,12 @@
+package cmd
+
+import "context"
+
+// startACME obtains the certificate of the acme settings and renews it
+// before it expires, answering the challenges from the zones
+func (s *Server) startACME() {
+	if s.certs == nil {
+		return
+	}
+	go s.certs.Run(context.Background())
+}
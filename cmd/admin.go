@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/api"
+	"github.com/bernoussama/mercury/config"
+)
+
+var (
+	// AdminAddress overrides the admin API address from the config. It may
+	// be a URL to pick the scheme explicitly.
+	AdminAddress string
+	AdminToken   string
+	AdminCert    string
+	AdminKey     string
+	AdminCA      string
+)
+
+// adminURL returns the base URL of the admin API
+func adminURL() (*url.URL, error) {
+	if strings.Contains(AdminAddress, "://") {
+		return url.Parse(AdminAddress)
+	}
+	cfg, err := config.Load(ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	address := cfg.Admin.Listen
+	if AdminAddress != "" {
+		address = AdminAddress
+	}
+	if address == "" {
+		return nil, fmt.Errorf("admin API disabled in %s", cfg.Path())
+	}
+	scheme := "http"
+	if cfg.Admin.TLS.Enabled() {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: address}, nil
+}
+
+// adminClient returns an HTTP client presenting the client certificate and
+// trusting the CA given by flags
+func adminClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if AdminCert != "" {
+		cert, err := tls.LoadX509KeyPair(AdminCert, AdminKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if AdminCA != "" {
+		pem, err := os.ReadFile(AdminCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", AdminCA)
+		}
+	}
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+// adminRequest calls the admin API of the running server and decodes the
+// JSON reply into out
+func adminRequest(method, path string, params url.Values, out any) error {
+	u, err := adminURL()
+	if err != nil {
+		return err
+	}
+	u = u.JoinPath(path)
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+AdminToken)
+	}
+	httpClient, err := adminClient()
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w (is the server running?)", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr api.Error
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+		}
+		return fmt.Errorf("admin API replied %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func init() {
+	flags := rootCmd.PersistentFlags()
+	flags.StringVar(&AdminAddress, "admin", os.Getenv("ADMIN"), "admin API address or URL (default from the config)")
+	flags.StringVar(&AdminToken, "admin-token", os.Getenv("ADMIN_TOKEN"), "bearer token for the admin API")
+	flags.StringVar(&AdminCert, "admin-cert", os.Getenv("ADMIN_CERT"), "client certificate for the admin API")
+	flags.StringVar(&AdminKey, "admin-key", os.Getenv("ADMIN_KEY"), "client certificate key for the admin API")
+	flags.StringVar(&AdminCA, "admin-ca", os.Getenv("ADMIN_CA"), "CA verifying the admin API certificate")
+}
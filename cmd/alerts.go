@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bernoussama/mercury/detect"
+	"github.com/spf13/cobra"
+)
+
+// alertsCmd lists the alerts of the running server
+var alertsCmd = &cobra.Command{
+	Use:   "alerts",
+	Short: "list the clients flagged for tunneling or generated names",
+	Long: `Alerts lists the suspicious queries the detection of the running server
+counted, the latest first: labels longer than max_label, random looking
+names, registered names that look generated (dga) and more distinct names
+under one domain than unique_names in a window. Clients rate limited for
+it show until when.
+
+Example usage:
+$ mercury alerts
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var alerts []detect.Alert
+		if err := adminRequest(http.MethodGet, "/api/alerts", nil, &alerts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CLIENT\tREASON\tDOMAIN\tCOUNT\tLAST\tLIMITED UNTIL\tEXAMPLE")
+		for _, a := range alerts {
+			limited := "-"
+			if a.LimitedUntil != nil {
+				limited = a.LimitedUntil.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", a.Client, a.Reason, a.Domain, a.Count, a.Last.Local().Format(time.DateTime), limited, a.Example)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(alertsCmd)
+}
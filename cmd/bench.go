@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bernoussama/mercury/bench"
+	"github.com/spf13/cobra"
+)
+
+var BenchTime time.Duration
+
+// benchCmd groups the performance measurements
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "measure the performance of mercury",
+}
+
+// benchSelfCmd runs the hot path benchmarks in process
+var benchSelfCmd = &cobra.Command{
+	Use:   "self [path...]",
+	Short: "measure the cached, zone, blocked and forwarded query paths",
+	Long: `Self answers queries along each hot path of the handler in process, from
+the bytes of a query to the bytes of its response, and prints the time and
+allocations per query. Forwarded queries go to an upstream in the same
+process, so the numbers leave out the network. The same paths are measured
+by go test ./bench -bench .
+
+Example usage:
+$ mercury bench self
+$ mercury bench self cached zone --benchtime 3s
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// testing.Benchmark reads its duration from the test flags
+		testing.Init()
+		check(flag.Set("test.benchtime", BenchTime.String()))
+
+		suite, err := bench.NewSuite()
+		check(err)
+		defer suite.Close()
+		for _, name := range args {
+			if !slices.ContainsFunc(suite.Scenarios, func(s bench.Scenario) bool { return s.Name == name }) {
+				fmt.Fprintf(os.Stderr, "unknown path %q\n", name)
+				os.Exit(1)
+			}
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "PATH\tQUERIES/S\tNS/QUERY\tALLOCS/QUERY\tBYTES/QUERY\t")
+		for _, s := range suite.Scenarios {
+			if len(args) > 0 && !slices.Contains(args, s.Name) {
+				continue
+			}
+			r := testing.Benchmark(s.Run)
+			qps := 0.0
+			if r.NsPerOp() > 0 {
+				qps = float64(time.Second) / float64(r.NsPerOp())
+			}
+			fmt.Fprintf(w, "%s\t%.0f\t%d\t%d\t%d\t\n", s.Name, qps, r.NsPerOp(), r.AllocsPerOp(), r.AllocedBytesPerOp())
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	benchSelfCmd.Flags().DurationVar(&BenchTime, "benchtime", time.Second, "time spent on each path")
+	benchCmd.AddCommand(benchSelfCmd)
+	rootCmd.AddCommand(benchCmd)
+}
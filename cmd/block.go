@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/bernoussama/mercury/api"
+	"github.com/spf13/cobra"
+)
+
+// blockCmd groups the commands switching blocking of the running server
+var blockCmd = &cobra.Command{
+	Use:   "block",
+	Short: "pause and resume blocking of the running server",
+}
+
+var PauseClient string
+
+// blockPauseCmd stops blocking for a while
+var blockPauseCmd = &cobra.Command{
+	Use:   "pause <duration>",
+	Short: "stop blocking for a while, for every client or one",
+	Long: `Pause stops the running server from blocking names for the given duration,
+for every client or only for --client, then blocking resumes by itself.
+Pausing again replaces the earlier pause. Pauses last until the server
+restarts.
+
+Example usage:
+$ mercury block pause 5m
+$ mercury block pause 1h --client 192.168.1.42
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		d, err := time.ParseDuration(args[0])
+		if err != nil || d <= 0 {
+			fmt.Fprintf(os.Stderr, "invalid duration %q, want a positive duration like 5m\n", args[0])
+			os.Exit(1)
+		}
+		pauseBlocking(d)
+	},
+}
+
+// blockResumeCmd ends a pause early
+var blockResumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "block again before a pause ends, for every client or one",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		pauseBlocking(0)
+	},
+}
+
+func pauseBlocking(d time.Duration) {
+	if PauseClient != "" && net.ParseIP(PauseClient) == nil {
+		fmt.Fprintf(os.Stderr, "invalid client address %q\n", PauseClient)
+		os.Exit(1)
+	}
+	params := url.Values{}
+	params.Set("duration", d.String())
+	params.Set("client", PauseClient)
+	var pause api.Pause
+	if err := adminRequest(http.MethodPost, "/api/blocklist/pause", params, &pause); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	who := "every client"
+	if pause.Client != "" {
+		who = pause.Client
+	}
+	if d <= 0 {
+		fmt.Printf("blocking resumed for %s\n", who)
+		return
+	}
+	fmt.Printf("blocking paused for %s until %s\n", who, pause.Until.Local().Format(time.DateTime))
+}
+
+func init() {
+	for _, c := range []*cobra.Command{blockPauseCmd, blockResumeCmd} {
+		c.Flags().StringVar(&PauseClient, "client", "", "address of the only client to pause blocking for")
+		blockCmd.AddCommand(c)
+	}
+	rootCmd.AddCommand(blockCmd)
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/config"
+	"github.com/spf13/cobra"
+)
+
+// blocklistCmd groups blocklist subcommands
+var blocklistCmd = &cobra.Command{
+	Use:   "blocklist",
+	Short: "manage the sinkhole blocklist",
+}
+
+var (
+	CompileOutput  string
+	CompileTimeout time.Duration
+)
+
+// blocklistCompileCmd builds the compiled blocklist the server maps
+var blocklistCompileCmd = &cobra.Command{
+	Use:   "compile",
+	Short: "download, dedupe and compile the blocklist sources",
+	Long: `Compile downloads every blocklist_sources URL and reads every source file of
+the config, keeps the valid domains once, removes those of the allowlists and
+writes the result to blocklist_compiled. The server maps that file at startup
+instead of parsing lists, and keeps serving the previous one until restarted.
+
+Sources that fail are reported and skipped.
+
+Example usage:
+$ mercury blocklist compile
+$ mercury blocklist compile -o /tmp/blocklist.bin
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load(ConfigFile)
+		check(err)
+		output := cfg.BlocklistCompiled
+		if CompileOutput != "" {
+			output = CompileOutput
+		}
+		if output == "" {
+			fmt.Fprintln(os.Stderr, "no blocklist_compiled in the config, use --output")
+			os.Exit(1)
+		}
+		if len(cfg.BlocklistSources) == 0 {
+			fmt.Fprintln(os.Stderr, "no blocklist_sources in the config")
+			os.Exit(1)
+		}
+
+		client := &http.Client{Timeout: CompileTimeout}
+		compiled, err := blocklist.Compile(context.Background(), client, cfg.BlocklistSources, cfg.Allowlists)
+		check(err)
+		failed := 0
+		for _, source := range compiled.Sources {
+			if source.Err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "%s: %v\n", source.Source, source.Err)
+				continue
+			}
+			fmt.Printf("%s: %d names\n", source.Source, source.Names)
+		}
+		if failed == len(compiled.Sources) {
+			fmt.Fprintln(os.Stderr, "every source failed, keeping", output)
+			os.Exit(1)
+		}
+		fmt.Printf("%d names listed, %d unique, %d allowlisted\n", compiled.Listed, compiled.Len()+compiled.Allowed, compiled.Allowed)
+		check(blocklist.WriteFile(output, compiled.Hash))
+		fmt.Printf("wrote %d names to %s\n", compiled.Len(), output)
+	},
+}
+
+// blocklistStatsCmd prints the lists of the running server
+var blocklistStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "print the blocklist and threat feeds with their block counts",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var stats blocklist.Stats
+		if err := adminRequest(http.MethodGet, "/api/blocklist", nil, &stats); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if stats.DryRun {
+			fmt.Print("dry run: nothing is blocked, the counts are of queries that would have been\n\n")
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "LIST\tCATEGORY\tDOMAINS\tBLOCKED\tUPDATED\tERROR")
+		for _, l := range stats.Lists {
+			updated := "-"
+			if l.Updated != nil {
+				updated = l.Updated.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", l.Name, l.Category, l.Names, l.Blocked, updated, l.Error)
+		}
+		w.Flush()
+
+		categories := make([]string, 0, len(stats.Categories))
+		for category := range stats.Categories {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CATEGORY\tBLOCKED")
+		for _, category := range categories {
+			fmt.Fprintf(w, "%s\t%d\n", category, stats.Categories[category])
+		}
+		w.Flush()
+
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "GROUP\tCLIENTS\tDISABLED\tBLOCKED")
+		for _, g := range stats.Groups {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", g.Name, listOrDash(g.Clients), listOrDash(g.Disabled), g.Blocked)
+		}
+		w.Flush()
+
+		printUntil("ALLOWED", stats.Allowed)
+		paused := stats.PausedClients
+		if stats.PausedUntil != nil {
+			paused["*"] = *stats.PausedUntil
+		}
+		printUntil("PAUSED FOR", paused)
+	},
+}
+
+// printUntil prints a table of times by key sorted by key, nothing when
+// empty
+func printUntil(header string, until map[string]time.Time) {
+	if len(until) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(until))
+	for key := range until {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, header+"\tUNTIL")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%s\n", key, until[key].Local().Format(time.DateTime))
+	}
+	w.Flush()
+}
+
+func listOrDash(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	return strings.Join(values, ",")
+}
+
+var CategoryGroup string
+
+// setCategory returns the command enabling or disabling a category
+func setCategory(enabled bool) *cobra.Command {
+	use, short := "enable <category>", "block a category again"
+	if !enabled {
+		use, short = "disable <category>", "stop blocking a category"
+	}
+	return &cobra.Command{
+		Use:   use,
+		Short: short + " for a client group of the running server",
+		Long: `Enable and disable turn the blocking of a category on and off for a client
+group of the running server, the default group holding the clients of no
+other group unless --group is given. Changes last until the server restarts.
+
+Example usage:
+$ mercury blocklist disable ads --group office
+$ mercury blocklist enable ads --group office
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			params := url.Values{}
+			params.Set("category", args[0])
+			params.Set("group", CategoryGroup)
+			params.Set("enabled", strconv.FormatBool(enabled))
+			var group blocklist.GroupStats
+			if err := adminRequest(http.MethodPost, "/api/blocklist/categories", params, &group); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Printf("group %s: disabled %s\n", group.Name, listOrDash(group.Disabled))
+		},
+	}
+}
+
+func init() {
+	blocklistCompileCmd.Flags().StringVarP(&CompileOutput, "output", "o", "", "file to write, blocklist_compiled by default")
+	blocklistCompileCmd.Flags().DurationVar(&CompileTimeout, "timeout", time.Minute, "timeout of a single download")
+	blocklistCmd.AddCommand(blocklistCompileCmd)
+	blocklistCmd.AddCommand(blocklistStatsCmd)
+	for _, enabled := range []bool{true, false} {
+		c := setCategory(enabled)
+		c.Flags().StringVar(&CategoryGroup, "group", blocklist.DefaultGroup, "client group")
+		blocklistCmd.AddCommand(c)
+	}
+	rootCmd.AddCommand(blocklistCmd)
+}
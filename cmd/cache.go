@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bernoussama/mercury/api"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/spf13/cobra"
+)
+
+var (
+	FlushSuffix string
+	FlushAll    bool
+	DumpSuffix  string
+	DumpOffset  int
+	DumpLimit   int
+)
+
+// cacheCmd groups commands acting on the cache of the running server
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "manage the cache of the running server",
+}
+
+// cacheFlushCmd purges cached answers without restarting the server
+var cacheFlushCmd = &cobra.Command{
+	Use:   "flush [name | --suffix domain | --all]",
+	Short: "remove cached answers",
+	Long: `Flush removes cached answers from the running server through its admin API.
+Give a name to drop its answers of every type, --suffix to drop a domain and
+every name below it, or --all to empty the cache.
+
+Example usage:
+$ mercury cache flush www.example.com
+$ mercury cache flush --suffix example.com
+$ mercury cache flush --all
+`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		params := url.Values{}
+		switch {
+		case len(args) == 1 && FlushSuffix == "" && !FlushAll:
+			params.Set("name", args[0])
+		case len(args) == 0 && FlushSuffix != "" && !FlushAll:
+			params.Set("suffix", FlushSuffix)
+		case len(args) == 0 && FlushSuffix == "" && FlushAll:
+			params.Set("all", "true")
+		default:
+			fmt.Fprintln(os.Stderr, "give exactly one of a name, --suffix or --all")
+			os.Exit(1)
+		}
+
+		var res api.FlushResult
+		if err := adminRequest(http.MethodPost, "/api/cache/flush", params, &res); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("flushed %d entries\n", res.Flushed)
+	},
+}
+
+// cacheDumpCmd lists the entries of the cache
+var cacheDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "list cached answers",
+	Long: `Dump lists the cached answers of the running server sorted by name, with
+their remaining TTL, wire size, hit count and the upstream they came from.
+
+Example usage:
+$ mercury cache dump --suffix example.com
+$ mercury cache dump --offset 100 --limit 100
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		params := url.Values{}
+		if DumpSuffix != "" {
+			params.Set("suffix", DumpSuffix)
+		}
+		params.Set("offset", strconv.Itoa(DumpOffset))
+		params.Set("limit", strconv.Itoa(DumpLimit))
+
+		var list api.CacheList
+		if err := adminRequest(http.MethodGet, "/api/cache", params, &list); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tTYPE\tCLASS\tTTL\tANSWERS\tSIZE\tHITS\tSOURCE")
+		for _, e := range list.Entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\n", e.Name, e.Type, e.Class, e.TTL, e.Answers, e.Size, e.Hits, e.Source)
+		}
+		w.Flush()
+		if end := list.Offset + len(list.Entries); end < list.Total {
+			fmt.Printf("showing %d-%d of %d, next page with --offset %d\n", list.Offset+1, end, list.Total, end)
+		}
+	},
+}
+
+// cacheStatsCmd prints the cache counters
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "print cache counters",
+	Long: `Stats prints the cache counters of the running server, then its entries,
+size, hits and misses by query type and by zone, the most misses first. The
+zone of a name is the served zone holding it, else its top level domain.
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var stats api.CacheStats
+		if err := adminRequest(http.MethodGet, "/api/cache/stats", nil, &stats); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if stats.Instance != "" {
+			fmt.Printf("instance:  %s\n", stats.Instance)
+		}
+		fmt.Printf("entries:   %d\n", stats.Entries)
+		fmt.Printf("bytes:     %d\n", stats.Bytes)
+		fmt.Printf("hits:      %d\n", stats.Hits)
+		fmt.Printf("misses:    %d\n", stats.Misses)
+		fmt.Printf("hit ratio: %.1f%%\n", stats.HitRatio*100)
+		printCacheCounts("TYPE", stats.Types)
+		printCacheCounts("ZONE", stats.Zones)
+	},
+}
+
+// printCacheCounts prints a breakdown of the cache stats, the most misses
+// first as they are what is resolved upstream
+func printCacheCounts(column string, counts map[string]dns.CacheCounts) {
+	if len(counts) == 0 {
+		return
+	}
+	keys := slices.Collect(maps.Keys(counts))
+	slices.SortFunc(keys, func(a, b string) int {
+		if c := cmp.Compare(counts[b].Misses, counts[a].Misses); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, column+"\tENTRIES\tBYTES\tHITS\tMISSES")
+	for _, key := range keys {
+		c := counts[key]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", key, c.Entries, c.Bytes, c.Hits, c.Misses)
+	}
+	w.Flush()
+}
+
+func init() {
+	cacheFlushCmd.Flags().StringVar(&FlushSuffix, "suffix", "", "flush this domain and every name below it")
+	cacheFlushCmd.Flags().BoolVar(&FlushAll, "all", false, "flush the whole cache")
+	cacheDumpCmd.Flags().StringVar(&DumpSuffix, "suffix", "", "only list this domain and the names below it")
+	cacheDumpCmd.Flags().IntVar(&DumpOffset, "offset", 0, "entries to skip")
+	cacheDumpCmd.Flags().IntVar(&DumpLimit, "limit", api.DefaultLimit, "entries per page")
+	cacheCmd.AddCommand(cacheFlushCmd, cacheDumpCmd, cacheStatsCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,33 @@
+//go:build chaos
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bernoussama/mercury/dns"
+)
+
+// Chaos are the faults injected into the upstream queries, like
+// latency=200ms,loss=0.1,malformed=0.05
+var Chaos string
+
+// startChaos injects the faults of --chaos into the upstream queries
+func startChaos() {
+	if Chaos == "" {
+		return
+	}
+	c, err := dns.ParseChaos(Chaos)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "chaos:", err)
+		os.Exit(1)
+	}
+	dns.SetChaos(c)
+	serverLog.Warn("injecting faults into upstream queries", "latency", c.Latency, "jitter", c.Jitter, "loss", c.Loss, "malformed", c.Malformed)
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&Chaos, "chaos", os.Getenv("CHAOS"), "faults injected into upstream queries, like latency=200ms,jitter=50ms,loss=0.1,malformed=0.05")
+	serveCmd.Flags().MarkHidden("chaos")
+}
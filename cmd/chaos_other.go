@@ -0,0 +1,6 @@
+//go:build !chaos
+
+package cmd
+
+// startChaos does nothing, only builds with the chaos tag inject faults
+func startChaos() {}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bernoussama/mercury/api"
+	"github.com/spf13/cobra"
+)
+
+var ClientsOffset, ClientsLimit int
+
+// clientsCmd lists the clients of the running server
+var clientsCmd = &cobra.Command{
+	Use:   "clients",
+	Short: "list the clients of the running server with their names",
+	Long: `Clients lists the clients that queried the running server, the busiest first,
+named after the hostname of their DHCP lease or the static names of the
+clients setting.
+
+Example usage:
+$ mercury clients
+$ mercury clients --offset 100 --limit 100
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		params := url.Values{}
+		params.Set("offset", strconv.Itoa(ClientsOffset))
+		params.Set("limit", strconv.Itoa(ClientsLimit))
+		var list api.ClientList
+		if err := adminRequest(http.MethodGet, "/api/clients", params, &list); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CLIENT\tNAME\tMAC\tQUERIES\tBLOCKED\tLAST SEEN")
+		for _, c := range list.Clients {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", c.Address, orDash(c.Name), orDash(c.MAC), c.Queries, c.Blocked, c.LastSeen.Local().Format(time.DateTime))
+		}
+		w.Flush()
+		if end := list.Offset + len(list.Clients); end < list.Total {
+			fmt.Printf("showing %d-%d of %d, next page with --offset %d\n", list.Offset+1, end, list.Total, end)
+		}
+	},
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	clientsCmd.Flags().IntVar(&ClientsOffset, "offset", 0, "clients to skip")
+	clientsCmd.Flags().IntVar(&ClientsLimit, "limit", api.DefaultLimit, "clients per page")
+	rootCmd.AddCommand(clientsCmd)
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bernoussama/mercury/config"
+	"github.com/spf13/cobra"
+)
+
+// configCmd groups config related subcommands
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "manage the server configuration",
+}
+
+// configCheckCmd validates the config and the files it references
+var configCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "validate the config, zones and blocklists",
+	Long: `Check loads the config file along with every zone and blocklist it references
+and reports all problems found at once, with file and line where possible.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load(ConfigFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := cfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			if verr, ok := err.(*config.ValidationError); ok {
+				fmt.Fprintf(os.Stderr, "%d problem(s) found\n", len(verr.Problems))
+			}
+			os.Exit(1)
+		}
+		path := cfg.Path()
+		if path == "" {
+			path = "default config"
+		}
+		fmt.Printf("%s: ok\n", path)
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configCheckCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/conformance"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ConformanceServer string
+	ConformanceZone   bool
+)
+
+// conformanceCmd checks mercury, or another server, against the RFCs
+var conformanceCmd = &cobra.Command{
+	Use:   "conformance",
+	Short: "check DNS protocol conformance",
+	Long: `Conformance sends a battery of queries to a DNS server and prints which
+behaviors of the RFCs it gets right: UDP and TCP transport, truncation and
+TCP fallback, NXDOMAIN, EDNS and more. Without --server it checks mercury,
+serving the conformance zone in process on the loopback. Other servers must
+serve the zone printed by --zone.
+
+It exits with status 1 when a core RFC 1035 check fails, go test ./cmd runs
+the same checks.
+
+Example usage:
+$ mercury conformance
+$ mercury conformance --zone > zones/conformance.test.yml
+$ mercury conformance --server 127.0.0.1:53
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if ConformanceZone {
+			os.Stdout.Write(conformance.ZoneFile)
+			return
+		}
+		server := ConformanceServer
+		if server == "" {
+			addr, stop, err := serveConformance()
+			check(err)
+			defer stop()
+			server = addr
+		}
+		report := conformance.Run(context.Background(), server)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CHECK\tRFC\tCORE\tRESULT")
+		for _, result := range report {
+			status := "pass"
+			if result.Err != nil {
+				status = "FAIL: " + result.Err.Error()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", result.Name, result.RFC, result.Core, status)
+		}
+		w.Flush()
+		if !report.Passed() {
+			os.Exit(1)
+		}
+	},
+}
+
+// serveConformance serves the conformance zone over UDP and TCP on one
+// port of the loopback and returns its address and a function stopping it
+func serveConformance() (string, func(), error) {
+	zone, err := conformance.Zone()
+	if err != nil {
+		return "", nil, err
+	}
+	s := &Server{
+		timeout: config.Default().Timeout,
+		handler: &dns.Handler{
+			Zones:     map[string]dns.Zone{conformance.Origin: zone},
+			Cache:     &dns.RecordsCache{Records: make(map[string]dns.Message)},
+			Blocklist: blocklist.NewSinkhole(),
+		},
+	}
+	// the port picked for UDP may be taken for TCP, try a few
+	for attempt := 0; attempt < 5; attempt++ {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return "", nil, err
+		}
+		addr := conn.LocalAddr().String()
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		go s.serveUDP(newListener(config.Listener{Protocol: config.UDP, Address: addr}), conn)
+		go s.serveStream(newListener(config.Listener{Protocol: config.TCP, Address: addr}), ln)
+		return addr, func() {
+			conn.Close()
+			ln.Close()
+		}, nil
+	}
+	return "", nil, errors.New("no loopback port free for both UDP and TCP")
+}
+
+func init() {
+	conformanceCmd.Flags().StringVar(&ConformanceServer, "server", "", "host:port of the server to check instead of mercury")
+	conformanceCmd.Flags().BoolVar(&ConformanceZone, "zone", false, "print the zone the checks query")
+	rootCmd.AddCommand(conformanceCmd)
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bernoussama/mercury/conformance"
+)
+
+func TestConformance(t *testing.T) {
+	addr, stop, err := serveConformance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	for _, result := range conformance.Run(context.Background(), addr) {
+		switch {
+		case result.Err == nil:
+		case result.Core:
+			t.Errorf("%s (RFC %s): %v", result.Name, result.RFC, result.Err)
+		default:
+			t.Logf("%s (RFC %s): %v", result.Name, result.RFC, result.Err)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/bernoussama/mercury/dns"
+	"github.com/spf13/cobra"
+)
+
+// decodeCmd dissects DNS messages from hex dumps or packet captures
+var decodeCmd = &cobra.Command{
+	Use:   "decode <hexfile|pcap>",
+	Short: "dissect DNS messages from a hex dump or pcap file",
+	Long: `Decode reads DNS messages and prints every field with its offset and length.
+
+The input is either a pcap capture, from which the UDP and TCP payloads are
+taken, or hex text with one message per paragraph. Hex may be plain, spaced
+or in hexdump -C / xxd form. Use - to read from stdin.
+
+Example usage:
+$ mercury decode capture.pcap
+$ echo "ae8701000001000000000000076578616d706c6503636f6d0000010001" | mercury decode -
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var data []byte
+		var err error
+		if args[0] == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(args[0])
+		}
+		check(err)
+
+		var packets []packet
+		if isPcap(data) {
+			packets, err = readPcap(data)
+		} else {
+			packets, err = readHex(data)
+		}
+		check(err)
+
+		failed := 0
+		for i, p := range packets {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("message %d, %d bytes%s\n", i+1, len(p.data), p.from)
+			fields, err := dns.Dissect(p.data)
+			for _, f := range fields {
+				fmt.Printf("  %04x %4d  %-24s %s\n", f.Offset, f.Length, f.Name, f.Value)
+			}
+			if err != nil {
+				failed++
+				fmt.Printf("  error: %v\n", err)
+			}
+		}
+		if failed > 0 {
+			fmt.Fprintf(os.Stderr, "%d of %d message(s) failed to decode\n", failed, len(packets))
+			os.Exit(1)
+		}
+	},
+}
+
+// packet is a DNS message along with where it was captured
+type packet struct {
+	data []byte
+	// from describes the source, e.g. ", udp 10.0.0.1:53 > 10.0.0.2:4242"
+	from string
+}
+
+// readHex parses hex text, one message per blank line separated paragraph.
+// Offsets and the ASCII column of hexdump -C and xxd output are skipped.
+func readHex(data []byte) ([]packet, error) {
+	var packets []packet
+	var cur []byte
+	flush := func() {
+		if len(cur) > 0 {
+			packets = append(packets, packet{data: cur})
+			cur = nil
+		}
+	}
+	for n, line := range strings.Split(string(data), "\n") {
+		if i := strings.IndexAny(line, "#;|"); i >= 0 {
+			line = line[:i]
+		}
+		// xxd: "00000010: 0765 7861  .exa", the text column follows two spaces
+		if offset, rest, ok := strings.Cut(line, ": "); ok && !strings.Contains(offset, " ") {
+			line, _, _ = strings.Cut(rest, "  ")
+		}
+		tokens := strings.Fields(line)
+		if len(tokens) == 0 {
+			flush()
+			continue
+		}
+		// hexdump -C offset column: "00000010  07 65 78 61"
+		if len(tokens) > 1 && len(tokens[0]) >= 6 && len(tokens[1]) == 2 {
+			tokens = tokens[1:]
+		}
+		for _, tok := range tokens {
+			tok = strings.TrimPrefix(strings.TrimPrefix(tok, "0x"), "0X")
+			b, err := hex.DecodeString(tok)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid hex %q", n+1, tok)
+			}
+			cur = append(cur, b...)
+		}
+	}
+	flush()
+	if len(packets) == 0 {
+		return nil, errors.New("no messages found")
+	}
+	return packets, nil
+}
+
+// pcap magic numbers for microsecond and nanosecond timestamps
+const (
+	pcapMagic     = 0xa1b2c3d4
+	pcapMagicNano = 0xa1b23c4d
+)
+
+// link layer types
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLinuxSLL = 113
+)
+
+func isPcap(data []byte) bool {
+	if len(data) < 24 {
+		return false
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		if magic := order.Uint32(data); magic == pcapMagic || magic == pcapMagicNano {
+			return true
+		}
+	}
+	return false
+}
+
+// readPcap extracts the UDP and TCP payloads of a classic pcap capture.
+// TCP segments are expected to carry whole length prefixed messages.
+func readPcap(data []byte) ([]packet, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if magic := order.Uint32(data); magic != pcapMagic && magic != pcapMagicNano {
+		order = binary.BigEndian
+	}
+	link := order.Uint32(data[20:]) & 0xFFFF
+
+	var packets []packet
+	for offset := 24; offset+16 <= len(data); {
+		length := int(order.Uint32(data[offset+8:]))
+		offset += 16
+		if offset+length > len(data) {
+			return packets, errors.New("pcap record truncated")
+		}
+		frame := data[offset : offset+length]
+		offset += length
+
+		ip, ok := stripLink(frame, link)
+		if !ok {
+			continue
+		}
+		packets = append(packets, transportPayloads(ip)...)
+	}
+	if len(packets) == 0 {
+		return nil, errors.New("no DNS messages found in capture")
+	}
+	return packets, nil
+}
+
+// stripLink returns the IP packet inside a link layer frame
+func stripLink(frame []byte, link uint32) ([]byte, bool) {
+	switch link {
+	case linkRaw:
+		return frame, true
+	case linkNull:
+		if len(frame) < 4 {
+			return nil, false
+		}
+		return frame[4:], true
+	case linkLinuxSLL:
+		if len(frame) < 16 {
+			return nil, false
+		}
+		return frame[16:], true
+	case linkEthernet:
+		if len(frame) < 14 {
+			return nil, false
+		}
+		etherType, rest := binary.BigEndian.Uint16(frame[12:]), frame[14:]
+		// 802.1Q VLAN tag
+		if etherType == 0x8100 && len(rest) >= 4 {
+			etherType, rest = binary.BigEndian.Uint16(rest[2:]), rest[4:]
+		}
+		return rest, etherType == 0x0800 || etherType == 0x86DD
+	}
+	return nil, false
+}
+
+// transportPayloads returns the DNS messages in an IPv4 or IPv6 packet
+func transportPayloads(ip []byte) []packet {
+	if len(ip) < 1 {
+		return nil
+	}
+	var proto byte
+	var src, dst netip.Addr
+	var payload []byte
+	switch ip[0] >> 4 {
+	case 4:
+		ihl := int(ip[0]&0x0F) * 4
+		if len(ip) < 20 || len(ip) < ihl {
+			return nil
+		}
+		proto = ip[9]
+		src, _ = netip.AddrFromSlice(ip[12:16])
+		dst, _ = netip.AddrFromSlice(ip[16:20])
+		end := min(int(binary.BigEndian.Uint16(ip[2:])), len(ip))
+		payload = ip[ihl:max(end, ihl)]
+	case 6:
+		if len(ip) < 40 {
+			return nil
+		}
+		proto = ip[6]
+		src, _ = netip.AddrFromSlice(ip[8:24])
+		dst, _ = netip.AddrFromSlice(ip[24:40])
+		payload = ip[40:]
+	default:
+		return nil
+	}
+
+	from := func(network string, ports []byte) string {
+		return fmt.Sprintf(", %s %s > %s", network,
+			netip.AddrPortFrom(src, binary.BigEndian.Uint16(ports)),
+			netip.AddrPortFrom(dst, binary.BigEndian.Uint16(ports[2:])))
+	}
+	switch proto {
+	case 17: // UDP
+		if len(payload) < 8 {
+			return nil
+		}
+		return []packet{{data: payload[8:], from: from("udp", payload)}}
+	case 6: // TCP
+		if len(payload) < 20 {
+			return nil
+		}
+		header := int(payload[12]>>4) * 4
+		if len(payload) < header {
+			return nil
+		}
+		var packets []packet
+		for stream := payload[header:]; len(stream) >= 2; {
+			n := int(binary.BigEndian.Uint16(stream))
+			if len(stream) < 2+n {
+				break
+			}
+			packets = append(packets, packet{data: stream[2 : 2+n], from: from("tcp", payload)})
+			stream = stream[2+n:]
+		}
+		return packets
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(decodeCmd)
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// query for example.com A
+const testQuery = "ae8701000001000000000000076578616d706c6503636f6d0000010001"
+
+func TestReadHex(t *testing.T) {
+	q, _ := hex.DecodeString(testQuery)
+	input := testQuery + "\n\n" + hex.Dump(q) + "\n" +
+		"00000000: ae87 0100 0001 0000 0000 0000 0765 7861  .............exa\n" +
+		"00000010: 6d70 6c65 0363 6f6d 0000 0100 01         mple.com.....\n"
+
+	packets, err := readHex([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 3 {
+		t.Fatalf("got %d packets, want 3", len(packets))
+	}
+	for i, p := range packets {
+		if hex.EncodeToString(p.data) != testQuery {
+			t.Errorf("packet %d = %x", i, p.data)
+		}
+	}
+}
+
+func TestReadPcap(t *testing.T) {
+	q, _ := hex.DecodeString(testQuery)
+
+	// ethernet + IPv4 + UDP from 10.0.0.2:4242 to 10.0.0.1:53
+	frame := make([]byte, 14+20+8)
+	binary.BigEndian.PutUint16(frame[12:], 0x0800)
+	ip := frame[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+8+len(q)))
+	ip[9] = 17
+	copy(ip[12:], []byte{10, 0, 0, 2})
+	copy(ip[16:], []byte{10, 0, 0, 1})
+	udp := ip[20:]
+	binary.BigEndian.PutUint16(udp, 4242)
+	binary.BigEndian.PutUint16(udp[2:], 53)
+	frame = append(frame, q...)
+
+	capture := make([]byte, 24, 24+16+len(frame))
+	binary.LittleEndian.PutUint32(capture, pcapMagic)
+	binary.LittleEndian.PutUint32(capture[20:], linkEthernet)
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(frame)))
+	capture = append(append(capture, record...), frame...)
+
+	if !isPcap(capture) {
+		t.Fatal("capture not recognized")
+	}
+	packets, err := readPcap(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 1 || hex.EncodeToString(packets[0].data) != testQuery {
+		t.Fatalf("packets = %+v", packets)
+	}
+	if want := ", udp 10.0.0.2:4242 > 10.0.0.1:53"; packets[0].from != want {
+		t.Errorf("from = %q, want %q", packets[0].from, want)
+	}
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/client"
+	"github.com/bernoussama/mercury/clients"
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ExplainClient  string
+	ExplainTimeout time.Duration
+)
+
+// explainCmd traces a query through the handler
+var explainCmd = &cobra.Command{
+	Use:   "explain <name> [type]",
+	Short: "explain how the server answers a name",
+	Long: `Explain answers a query the way a server started with the config and the
+same --zone and --sinkhole flags would, and prints each decision taken on
+the way: whether the client may query, whether an allowlist left the name
+out of the compiled blocklist, which list blocks it, whether a zone, the
+local hosts or the cache answers it and which upstreams resolve it.
+
+The server's cache, allowances and pauses live in its memory, the query
+starts from an empty cache and names not answered locally are resolved
+through the upstreams for real. The type defaults to A.
+
+Example usage:
+$ mercury explain -s ads.example.com
+$ mercury explain -z -s www.home.example.com aaaa --client 192.168.1.20
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		qtype := dns.TypeA
+		if len(args) == 2 {
+			t, err := dns.ParseQType(args[1])
+			check(err)
+			qtype = t
+		}
+		ip := net.ParseIP(ExplainClient)
+		if ip == nil {
+			fmt.Fprintf(os.Stderr, "invalid client address %q\n", ExplainClient)
+			os.Exit(1)
+		}
+		cfg, err := config.Load(ConfigFile)
+		check(err)
+		handler, err := explainHandler(cfg, Zone, Sinkhole)
+		check(err)
+
+		query := client.New("").NewQuery(args[0], qtype)
+		ctx, cancel := context.WithTimeout(context.Background(), ExplainTimeout)
+		defer cancel()
+		steps, data := explain(ctx, cfg, handler, ip, query)
+
+		fmt.Printf(";; %s %s from %s\n\n", query.Question.DomainName, qtype, ip)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "STAGE\tDECISION")
+		for _, step := range steps {
+			fmt.Fprintf(w, "%s\t%s\n", step.Stage, step.Detail)
+		}
+		w.Flush()
+		res, err := client.Parse(data)
+		check(err)
+		fmt.Printf("\n;; status: %s\n", dns.RcodeName(res.Header.RCODE))
+		printSection(os.Stdout, "ANSWER", res.Answers)
+		printSection(os.Stdout, "AUTHORITY", res.Authority)
+		printSection(os.Stdout, "ADDITIONAL", res.Additional)
+	},
+}
+
+// explainHandler returns a handler answering like a server started with
+// cfg, with zones and the sinkhole when enabled. Threat feeds are fetched
+// once, a feed that fails is reported and left out.
+func explainHandler(cfg *config.Config, zone, sinkhole bool) (*dns.Handler, error) {
+	h := &dns.Handler{
+		Zones:         make(map[string]dns.Zone),
+		Cache:         &dns.RecordsCache{Records: make(map[string]dns.Message)},
+		Upstreams:     upstreams(cfg),
+		QueryBudget:   cfg.QueryBudget,
+		NoRecursion:   !cfg.Recursion,
+		Unanswered:    cfg.UnansweredRcode(),
+		Identity:      cfg.Identity,
+		SinkholeAddrs: cfg.SinkholeIPs(),
+	}
+	if zone {
+		loaded, err := readZones(cfg)
+		if err != nil {
+			return nil, err
+		}
+		h.Zones = loaded
+	}
+	if sinkhole {
+		lists, err := readBlocklists(cfg)
+		if err != nil {
+			return nil, err
+		}
+		h.Blocklist = blocklist.NewSinkhole()
+		for _, l := range lists {
+			h.Blocklist.SetList(l.List)
+		}
+		h.Blocklist.SetGroups(cfg.ClientGroups)
+		for _, feed := range cfg.ThreatFeeds {
+			names, err := blocklist.Fetch(context.Background(), feedClient, feed.URL)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "threat feed %s: %v\n", feed.ListName(), err)
+				continue
+			}
+			store := blocklist.NewHash()
+			store.Reload(names)
+			h.Blocklist.SetList(feed.List(store))
+		}
+	}
+	h.Clients = clients.New(cfg.Clients)
+	if err := h.Clients.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "lease files not read: %v\n", err)
+	}
+	if cfg.Clients.Domain != "" {
+		hosts := newHostTable(cfg.Clients.Domain)
+		hosts.set("", h.Clients.Hosts())
+		h.Hosts = hosts.dnsHosts()
+	}
+	return h, nil
+}
+
+// explain answers query from client with h, returning the steps taken and
+// the response. Clients outside the allowed networks of cfg are refused
+// like the server does.
+func explain(ctx context.Context, cfg *config.Config, h *dns.Handler, ip net.IP, query *dns.Message) ([]dns.Step, []byte) {
+	// decode the query like the server does, filling in its options
+	data := query.Encode()
+	msg := &dns.Message{Bytes: data}
+	msg.Decode(data)
+
+	step, allowed := allowStep(cfg, ip)
+	if name := h.Clients.Name(ip); name != "" {
+		step.Detail += ", device " + name
+	}
+	steps := []dns.Step{step}
+	if !allowed {
+		return steps, dns.NewResponse(msg).SetRcode(dns.RcodeRefused).Encode()
+	}
+	if h.Blocklist != nil && cfg.BlocklistCompiled != "" {
+		steps = append(steps, allowlistStep(cfg, msg.Question.DomainName))
+	}
+	trace := &dns.Trace{}
+	res := h.BuildResponse(dns.WithTrace(dns.WithClient(ctx, ip), trace), msg)
+	return append(steps, trace.Steps()...), res
+}
+
+// allowStep tells whether the allowed networks of cfg let ip query
+func allowStep(cfg *config.Config, ip net.IP) (dns.Step, bool) {
+	nets := cfg.AllowedNets()
+	if len(nets) == 0 {
+		return dns.Step{Stage: "allow", Detail: "every client allowed"}, true
+	}
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return dns.Step{Stage: "allow", Detail: "client in " + ipnet.String()}, true
+		}
+	}
+	return dns.Step{Stage: "allow", Detail: "client outside the allowed networks, refused"}, false
+}
+
+// allowlistStep tells which allowlist, if any, left name out of the
+// compiled blocklist
+func allowlistStep(cfg *config.Config, name string) dns.Step {
+	for _, file := range cfg.Allowlists {
+		names, err := blocklist.ReadFiles([]string{file})
+		if err != nil {
+			return dns.Step{Stage: "allowlist", Detail: err.Error()}
+		}
+		allowed := blocklist.NewMap()
+		allowed.Reload(names)
+		if allowed.Contains(name) {
+			return dns.Step{Stage: "allowlist", Detail: "listed in " + file + ", left out of the compiled blocklist"}
+		}
+	}
+	return dns.Step{Stage: "allowlist", Detail: "not listed"}
+}
+
+func init() {
+	explainCmd.Flags().StringVar(&ExplainClient, "client", "127.0.0.1", "address of the client asking")
+	explainCmd.Flags().BoolVar(&NoSystemResolvers, "no-system-resolvers", os.Getenv("NO_SYSTEM_RESOLVERS") != "", "start recursion at the root servers when no upstreams are configured")
+	explainCmd.Flags().DurationVar(&ExplainTimeout, "timeout", 5*time.Second, "time allowed to resolve the name")
+	rootCmd.AddCommand(explainCmd)
+}
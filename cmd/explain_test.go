@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bernoussama/mercury/client"
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/dns"
+)
+
+func TestExplain(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "zones"), 0o755)
+	writeTestFile(t, filepath.Join(dir, "zones", "home.test.yml"), []byte("origin: home.test.\nttl: 60\nsoa:\n  mname: ns1.home.test.\n  rname: admin.home.test.\n  serial: 1\nns:\n  - host: ns1.home.test.\na:\n  - name: nas\n    value: 10.0.0.5\n"))
+	writeTestFile(t, filepath.Join(dir, "ads.txt"), []byte("ads.example.com\n"))
+	writeTestFile(t, filepath.Join(dir, "config.yml"), []byte("zones: "+filepath.Join(dir, "zones")+"\nallow:\n  - 127.0.0.0/8\nblocklists:\n  - path: "+filepath.Join(dir, "ads.txt")+"\n    category: ads\n"))
+	cfg, err := config.Load(filepath.Join(dir, "config.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := explainHandler(cfg, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		client string
+		rcode  uint16
+		steps  []string
+	}{
+		{"ads.example.com", "127.0.0.1", dns.RcodeSuccess, []string{"allow: client in 127.0.0.0/8", "blocklist: listed in ads, category ads", "sinkhole: answered with 127.0.0.1"}},
+		{"nas.home.test", "127.0.0.1", dns.RcodeSuccess, []string{"blocklist: not listed", "zone: in zone home.test.", "zone: answered with 1 records"}},
+		{"nope.home.test", "127.0.0.1", dns.RcodeNameError, []string{"zone: no such name"}},
+		{"ads.example.com", "192.0.2.1", dns.RcodeRefused, []string{"allow: client outside the allowed networks, refused"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" from "+tt.client, func(t *testing.T) {
+			query := client.New("").NewQuery(tt.name, dns.TypeA)
+			steps, data := explain(context.Background(), cfg, h, net.ParseIP(tt.client), query)
+			var got []string
+			for _, step := range steps {
+				got = append(got, step.String())
+			}
+			for _, want := range tt.steps {
+				if !strings.Contains(strings.Join(got, "\n"), want) {
+					t.Errorf("steps lack %q:\n%s", want, strings.Join(got, "\n"))
+				}
+			}
+			res, err := client.Parse(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Header.RCODE != tt.rcode {
+				t.Errorf("status %s, want %s", dns.RcodeName(res.Header.RCODE), dns.RcodeName(tt.rcode))
+			}
+		})
+	}
+}
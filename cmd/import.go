@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bernoussama/mercury/migrate"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importDir   = "/opt/mercury"
+	importForce bool
+)
+
+// importCmd converts the settings of another DNS server
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "convert the settings of Pi-hole or AdGuard Home",
+	Long: `Import writes a setup to --dir from the settings of another DNS server:
+config.yml with its upstreams, blocklists, allowed and blocked domains,
+client names and the groups of clients blocking nothing, and a zone file
+for each local DNS record. Settings mercury has no equivalent for are
+listed as skipped. Run blocklist compile afterwards, the allowed domains
+are left out of the compiled list.
+
+Example usage:
+$ mercury import pihole /etc/pihole --dir ./mercury
+$ mercury import adguard /opt/AdGuardHome/AdGuardHome.yaml
+`,
+}
+
+var importPiholeCmd = &cobra.Command{
+	Use:   "pihole <dir>",
+	Short: "convert a Pi-hole config directory like /etc/pihole",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := migrate.Pihole(args[0])
+		check(err)
+		writeImport(s, "Pi-hole "+args[0])
+	},
+}
+
+var importAdGuardCmd = &cobra.Command{
+	Use:   "adguard <AdGuardHome.yaml>",
+	Short: "convert an AdGuard Home config file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		s, err := migrate.AdGuard(args[0])
+		check(err)
+		writeImport(s, "AdGuard Home "+args[0])
+	},
+}
+
+// writeImport writes the files of an import to importDir
+func writeImport(s *migrate.Setup, from string) {
+	dir, err := filepath.Abs(importDir)
+	check(err)
+	files := renderImport(s, dir, from)
+	if !importForce {
+		for _, file := range files {
+			if _, err := os.Stat(file.path); err == nil {
+				fmt.Fprintf(os.Stderr, "%s already exists, use --force to overwrite\n", file.path)
+				os.Exit(1)
+			}
+		}
+	}
+	check(os.MkdirAll(filepath.Join(dir, "zones"), 0o755))
+	for _, file := range files {
+		check(os.WriteFile(file.path, file.data, 0o644))
+		fmt.Printf("created %s\n", file.path)
+	}
+	if len(s.Skipped) > 0 {
+		fmt.Printf("\nskipped:\n")
+		for _, note := range s.Skipped {
+			fmt.Printf("  %s\n", note)
+		}
+	}
+	config := filepath.Join(dir, "config.yml")
+	fmt.Printf("\nnext steps:\n")
+	if len(s.Sources) > 0 {
+		fmt.Printf("  mercury blocklist compile --config %s\n", config)
+	}
+	fmt.Printf("  mercury serve --config %s --zone --sinkhole\n", config)
+}
+
+// renderImport returns the config and zone files of an import to dir
+func renderImport(s *migrate.Setup, dir, from string) []setupFile {
+	zones := filepath.Join(dir, "zones")
+	var files []setupFile
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# imported from %s by mercury import, the README describes every setting\n", from)
+	fmt.Fprintf(&b, "zones: %s\n", zones)
+	if len(s.Upstreams) > 0 {
+		fmt.Fprintf(&b, "upstreams:\n")
+		for _, upstream := range s.Upstreams {
+			fmt.Fprintf(&b, "  - address: %q\n", upstream)
+		}
+	} else {
+		fmt.Fprintf(&b, "# no upstreams, recursing from the root servers\n")
+	}
+	if len(s.Sources) > 0 {
+		fmt.Fprintf(&b, "blocklist_sources:  # compiled by mercury blocklist compile\n")
+		for _, source := range s.Sources {
+			fmt.Fprintf(&b, "  - %q\n", source)
+		}
+		fmt.Fprintf(&b, "blocklist_compiled: %s\n", filepath.Join(dir, "blocklist.bin"))
+	}
+	allowlists := s.AllowSources
+	if len(s.Allowed) > 0 {
+		allow := filepath.Join(dir, "allow.txt")
+		allowlists = append([]string{allow}, allowlists...)
+		files = append(files, setupFile{path: allow, data: domainList(s.Allowed)})
+	}
+	if len(allowlists) > 0 {
+		fmt.Fprintf(&b, "allowlists:\n")
+		for _, list := range allowlists {
+			fmt.Fprintf(&b, "  - %q\n", list)
+		}
+	}
+	if len(s.Blocked) > 0 {
+		blocked := filepath.Join(dir, "blocked.txt")
+		fmt.Fprintf(&b, "blocklists:\n  - %s\n", blocked)
+		files = append(files, setupFile{path: blocked, data: domainList(s.Blocked)})
+	}
+	if len(s.Groups) > 0 {
+		fmt.Fprintf(&b, "client_groups:\n")
+		for _, group := range s.Groups {
+			fmt.Fprintf(&b, "  - name: %q\n", group.Name)
+			fmt.Fprintf(&b, "    clients: %s\n", yamlList(group.Clients))
+			fmt.Fprintf(&b, "    disabled: %s\n", yamlList(group.Disabled))
+		}
+	}
+	if len(s.Clients) > 0 {
+		addresses := make([]string, 0, len(s.Clients))
+		for address := range s.Clients {
+			addresses = append(addresses, address)
+		}
+		sort.Strings(addresses)
+		fmt.Fprintf(&b, "clients:\n  static:\n")
+		for _, address := range addresses {
+			fmt.Fprintf(&b, "    %q: %q\n", address, s.Clients[address])
+		}
+	}
+	files = append([]setupFile{{path: filepath.Join(dir, "config.yml"), data: b.Bytes()}}, files...)
+
+	for _, name := range s.Names() {
+		var z bytes.Buffer
+		fmt.Fprintf(&z, "# imported from %s\n", from)
+		fmt.Fprintf(&z, "origin: %s.\n", name)
+		var a, aaaa []string
+		for _, address := range s.Hosts[name] {
+			if net.ParseIP(address).To4() != nil {
+				a = append(a, address)
+			} else {
+				aaaa = append(aaaa, address)
+			}
+		}
+		for _, family := range []struct {
+			key       string
+			addresses []string
+		}{{"a", a}, {"aaaa", aaaa}} {
+			if len(family.addresses) == 0 {
+				continue
+			}
+			fmt.Fprintf(&z, "%s:\n", family.key)
+			for _, address := range family.addresses {
+				fmt.Fprintf(&z, "  - name: \"@\"\n    value: %q\n", address)
+			}
+		}
+		files = append(files, setupFile{path: filepath.Join(zones, name+".yml"), data: z.Bytes()})
+	}
+	return files
+}
+
+// domainList returns domains one per line
+func domainList(domains []string) []byte {
+	var b bytes.Buffer
+	for _, domain := range domains {
+		fmt.Fprintf(&b, "%s\n", domain)
+	}
+	return b.Bytes()
+}
+
+// yamlList returns values as a YAML flow sequence of quoted strings
+func yamlList(values []string) string {
+	var b bytes.Buffer
+	b.WriteString("[")
+	for i, value := range values {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%q", value)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+func init() {
+	importCmd.PersistentFlags().StringVar(&importDir, "dir", importDir, "directory of the config and zones")
+	importCmd.PersistentFlags().BoolVar(&importForce, "force", false, "overwrite existing files")
+	importCmd.AddCommand(importPiholeCmd, importAdGuardCmd)
+	rootCmd.AddCommand(importCmd)
+}
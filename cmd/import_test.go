@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/migrate"
+)
+
+func TestRenderImport(t *testing.T) {
+	dir := t.TempDir()
+	s := &migrate.Setup{
+		Upstreams:    []string{"1.1.1.1:53", "[2606:4700:4700::1111]:53"},
+		Sources:      []string{"https://lists.example.com/ads.txt"},
+		AllowSources: []string{filepath.Join(dir, "more-allowed.txt")},
+		Blocked:      []string{"ads.example.com"},
+		Allowed:      []string{"cdn.example.com"},
+		Hosts:        map[string][]string{"nas.lan": {"192.168.1.2", "fd00::2"}, "tv.lan": {"192.168.1.3"}},
+		Clients:      map[string]string{"aa:bb:cc:dd:ee:ff": "kids tablet", "192.168.1.20": "kids"},
+		Groups: []blocklist.GroupOptions{
+			{Name: "work", Clients: []string{"10.0.0.0/24"}, Disabled: []string{blocklist.CategoryBlocklist}},
+		},
+	}
+
+	os.MkdirAll(filepath.Join(dir, "zones"), 0o755)
+	for _, file := range renderImport(s, dir, "Pi-hole /etc/pihole") {
+		writeTestFile(t, file.path, file.data)
+	}
+	writeTestFile(t, filepath.Join(dir, "more-allowed.txt"), nil)
+	// stands in for the list mercury blocklist compile writes
+	writeTestFile(t, filepath.Join(dir, "blocklist.bin"), nil)
+
+	cfg, err := config.Load(filepath.Join(dir, "config.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("imported config is invalid: %v", err)
+	}
+	if len(cfg.Upstreams) != 2 || cfg.Upstreams[1].Address != "[2606:4700:4700::1111]:53" ||
+		len(cfg.BlocklistSources) != 1 || len(cfg.Allowlists) != 2 || len(cfg.Blocklists) != 1 ||
+		len(cfg.ClientGroups) != 1 || cfg.Clients.Static["aa:bb:cc:dd:ee:ff"] != "kids tablet" {
+		t.Errorf("config = %+v", cfg)
+	}
+	zones, err := config.LoadZones(cfg.Zones, nil)
+	if err != nil || len(zones) != 2 {
+		t.Fatalf("zones %v, err %v, want nas.lan. and tv.lan.", zones, err)
+	}
+	nas := zones["nas.lan."]
+	if records := nas.Lookup("nas.lan.", 28, 1); len(records) != 1 {
+		t.Errorf("nas.lan. AAAA = %v, want fd00::2", records)
+	}
+}
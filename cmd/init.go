@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// setupTemplate is what init fills in
+type setupTemplate struct {
+	dir        string
+	listen     string
+	allow      []string
+	upstreams  []string
+	blocklists []string
+	// domain and ip create a first zone when set
+	domain string
+	ip     string
+	// exe is the binary the systemd unit starts
+	exe string
+}
+
+// defaults of init, answering the home networks and blocking ads
+var newSetup = setupTemplate{
+	dir:        "/opt/mercury",
+	listen:     "0.0.0.0:53",
+	allow:      []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"},
+	blocklists: []string{"https://raw.githubusercontent.com/StevenBlack/hosts/master/hosts"},
+}
+
+var (
+	initYes   bool
+	initForce bool
+)
+
+// initCmd writes a starter setup
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "create a config, zones directory and systemd unit",
+	Long: `Init writes a working setup to --dir: config.yml answering the local
+networks and blocking the blocklist sources, a zones directory, with a
+first zone when --domain is given, and mercury.service to run the server
+with systemd. It asks for each setting with the flags as defaults when run
+in a terminal, --yes takes the flags as they are.
+
+Example usage:
+$ sudo mercury init
+$ mercury init --yes --dir ./mercury --listen 127.0.0.1:5353 --upstream 1.1.1.1:53
+$ mercury init --yes --domain home.example.com --ip 192.168.1.2
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		s := newSetup
+		if exe, err := os.Executable(); err == nil {
+			s.exe = exe
+		}
+		if !initYes && isTerminal(os.Stdin) {
+			check(s.ask(bufio.NewReader(os.Stdin), os.Stdout))
+		}
+		dir, err := filepath.Abs(s.dir)
+		check(err)
+		s.dir = dir
+		files, err := s.render(time.Now())
+		check(err)
+		if !initForce {
+			for _, file := range files {
+				if _, err := os.Stat(file.path); err == nil {
+					fmt.Fprintf(os.Stderr, "%s already exists, use --force to overwrite\n", file.path)
+					os.Exit(1)
+				}
+			}
+		}
+		check(os.MkdirAll(filepath.Join(s.dir, "zones"), 0o755))
+		for _, file := range files {
+			check(os.WriteFile(file.path, file.data, 0o644))
+			fmt.Printf("created %s\n", file.path)
+		}
+		config := filepath.Join(s.dir, "config.yml")
+		fmt.Printf(`
+next steps:
+  %[1]s blocklist compile --config %[2]s
+  sudo systemctl link %[3]s
+  sudo systemctl enable --now mercury
+`, s.exe, config, filepath.Join(s.dir, "mercury.service"))
+	},
+}
+
+// setupFile is a file init writes
+type setupFile struct {
+	path string
+	data []byte
+}
+
+// ask prompts for each setting on w, reading answers from r. An empty
+// answer keeps the current value, "none" empties a list.
+func (s *setupTemplate) ask(r *bufio.Reader, w io.Writer) error {
+	prompts := []struct {
+		question string
+		value    *string
+		list     *[]string
+	}{
+		{question: "directory of the config and zones", value: &s.dir},
+		{question: "address to answer on", value: &s.listen},
+		{question: "networks allowed to query, comma separated", list: &s.allow},
+		{question: "upstreams to forward to, comma separated, none to recurse from the root servers", list: &s.upstreams},
+		{question: "blocklist sources, comma separated", list: &s.blocklists},
+		{question: "domain of a first zone, none to skip", value: &s.domain},
+	}
+	for _, p := range prompts {
+		current := ""
+		if p.value != nil {
+			current = *p.value
+		} else {
+			current = strings.Join(*p.list, ", ")
+		}
+		if current == "" {
+			current = "none"
+		}
+		fmt.Fprintf(w, "%s [%s]: ", p.question, current)
+		line, err := r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		answer := strings.TrimSpace(line)
+		switch {
+		case answer == "":
+		case answer == "none" && p.value != nil:
+			*p.value = ""
+		case answer == "none":
+			*p.list = nil
+		case p.value != nil:
+			*p.value = answer
+		default:
+			*p.list = splitList(answer)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if s.domain != "" && s.ip == "" {
+		fmt.Fprintf(w, "address of %s: ", s.domain)
+		line, _ := r.ReadString('\n')
+		s.ip = strings.TrimSpace(line)
+	}
+	return nil
+}
+
+// splitList splits a comma separated answer
+func splitList(answer string) []string {
+	var list []string
+	for _, item := range strings.Split(answer, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// render returns the files of the setup, the zone serial based on now
+func (s setupTemplate) render(now time.Time) ([]setupFile, error) {
+	if _, _, err := net.SplitHostPort(s.listen); err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %v", s.listen, err)
+	}
+	for _, network := range s.allow {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return nil, fmt.Errorf("invalid network %q", network)
+		}
+	}
+	upstreams := make([]string, 0, len(s.upstreams))
+	for _, upstream := range s.upstreams {
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			upstream = net.JoinHostPort(upstream, "53")
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	exe := s.exe
+	if exe == "" {
+		exe = "/usr/local/bin/mercury"
+	}
+	zones := filepath.Join(s.dir, "zones")
+	config := filepath.Join(s.dir, "config.yml")
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# written by mercury init, the README describes every setting\n")
+	fmt.Fprintf(&b, "listen: %q\n", s.listen)
+	fmt.Fprintf(&b, "zones: %s\n", zones)
+	if len(s.allow) > 0 {
+		fmt.Fprintf(&b, "allow:\n")
+		for _, network := range s.allow {
+			fmt.Fprintf(&b, "  - %q\n", network)
+		}
+	}
+	if len(upstreams) > 0 {
+		fmt.Fprintf(&b, "upstreams:\n")
+		for _, upstream := range upstreams {
+			fmt.Fprintf(&b, "  - address: %q\n", upstream)
+		}
+	} else {
+		fmt.Fprintf(&b, "# no upstreams, recursing from the root servers\n")
+	}
+	if len(s.blocklists) > 0 {
+		fmt.Fprintf(&b, "blocklist_sources:  # compiled by mercury blocklist compile\n")
+		for _, source := range s.blocklists {
+			fmt.Fprintf(&b, "  - %s\n", source)
+		}
+		fmt.Fprintf(&b, "blocklist_compiled: %s\n", filepath.Join(s.dir, "blocklist.bin"))
+	}
+	fmt.Fprintf(&b, "admin: 127.0.0.1:53180\n")
+	files := []setupFile{{path: config, data: b.Bytes()}}
+
+	flags := "--zone"
+	if len(s.blocklists) > 0 {
+		flags += " --sinkhole"
+	}
+	unit := fmt.Sprintf(`[Unit]
+Description=Mercury DNS server
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart=%[1]s serve --config %[2]s %[3]s
+ExecReload=%[1]s reload --config %[2]s
+Restart=on-failure
+DynamicUser=yes
+AmbientCapabilities=CAP_NET_BIND_SERVICE
+ProtectSystem=strict
+ProtectHome=yes
+
+[Install]
+WantedBy=multi-user.target
+`, exe, config, flags)
+	files = append(files, setupFile{path: filepath.Join(s.dir, "mercury.service"), data: []byte(unit)})
+
+	if s.domain != "" {
+		zone := zoneTemplate{origin: s.domain, ttl: 3600}
+		if ip := net.ParseIP(s.ip); ip != nil && ip.To4() == nil {
+			zone.ipv6 = s.ip
+		} else {
+			zone.ipv4 = s.ip
+		}
+		data, err := zone.render(now)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(strings.ToLower(s.domain), ".") + ".yml"
+		files = append(files, setupFile{path: filepath.Join(zones, name), data: data})
+	}
+	return files, nil
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func init() {
+	initCmd.Flags().StringVar(&newSetup.dir, "dir", newSetup.dir, "directory of the config, zones and unit")
+	initCmd.Flags().StringVar(&newSetup.listen, "listen", newSetup.listen, "address to answer on")
+	initCmd.Flags().StringSliceVar(&newSetup.allow, "allow", newSetup.allow, "networks allowed to query")
+	initCmd.Flags().StringSliceVar(&newSetup.upstreams, "upstream", nil, "upstreams to forward to, the root servers by default")
+	initCmd.Flags().StringSliceVar(&newSetup.blocklists, "blocklist", newSetup.blocklists, "blocklist sources")
+	initCmd.Flags().StringVar(&newSetup.domain, "domain", "", "domain of a first zone")
+	initCmd.Flags().StringVar(&newSetup.ip, "ip", "", "address of the first zone")
+	initCmd.Flags().BoolVarP(&initYes, "yes", "y", false, "take the flags without asking")
+	initCmd.Flags().BoolVar(&initForce, "force", false, "overwrite existing files")
+	rootCmd.AddCommand(initCmd)
+}
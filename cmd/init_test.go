@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/config"
+)
+
+func TestSetupTemplateRender(t *testing.T) {
+	dir := t.TempDir()
+	s := newSetup
+	s.dir = dir
+	s.upstreams = []string{"1.1.1.1", "[2606:4700:4700::1111]:53"}
+	s.domain = "home.example.com"
+	s.ip = "192.168.1.2"
+	s.exe = "/usr/bin/mercury"
+
+	files, err := s.render(time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "zones"), 0o755)
+	for _, file := range files {
+		writeTestFile(t, file.path, file.data)
+	}
+	// stands in for the list mercury blocklist compile writes
+	writeTestFile(t, filepath.Join(dir, "blocklist.bin"), nil)
+
+	cfg, err := config.Load(filepath.Join(dir, "config.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("generated config is invalid: %v", err)
+	}
+	if cfg.Listen != "0.0.0.0:53" || len(cfg.Allow) != 6 || cfg.Upstreams[0].Address != "1.1.1.1:53" || len(cfg.BlocklistSources) != 1 {
+		t.Errorf("config = %+v", cfg)
+	}
+	zones, err := config.LoadZones(cfg.Zones, nil)
+	if err != nil || len(zones) != 1 {
+		t.Fatalf("zones %v, err %v, want home.example.com.", zones, err)
+	}
+	unit, _ := os.ReadFile(filepath.Join(dir, "mercury.service"))
+	want := "ExecStart=/usr/bin/mercury serve --config " + filepath.Join(dir, "config.yml") + " --zone --sinkhole\n"
+	if !strings.Contains(string(unit), want) {
+		t.Errorf("unit lacks %q:\n%s", want, unit)
+	}
+}
+
+func TestSetupTemplateAsk(t *testing.T) {
+	s := newSetup
+	answers := strings.Join([]string{"/srv/dns", "", "10.0.0.0/8, fd00::/8", "9.9.9.9", "none", "lan.example.com", "10.0.0.2"}, "\n")
+	if err := s.ask(bufio.NewReader(strings.NewReader(answers)), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if s.dir != "/srv/dns" || s.listen != newSetup.listen || !slices.Equal(s.allow, []string{"10.0.0.0/8", "fd00::/8"}) ||
+		!slices.Equal(s.upstreams, []string{"9.9.9.9"}) || s.blocklists != nil || s.domain != "lan.example.com" || s.ip != "10.0.0.2" {
+		t.Errorf("setup = %+v", s)
+	}
+}
@@ -0,0 +1,419 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bernoussama/mercury/api"
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/bernoussama/mercury/proxyproto"
+)
+
+// listener receives queries on one address and counts them
+type listener struct {
+	config.Listener
+	log *slog.Logger
+	// limits bound the connections of tcp, dot and doh listeners
+	limits config.ConnectionLimits
+	// gate counts the connections of every listener of the protocol, nil
+	// when they are unlimited
+	gate *connGate
+	// socket sizes the buffers and sets the TOS and fragmentation of its
+	// sockets
+	socket config.SocketOptions
+
+	queries, refused, malformed atomic.Uint64
+	// deduplicated counts the retransmits answered with the first query
+	deduplicated atomic.Uint64
+	// connections are open now, rejected were closed over the limit
+	connections atomic.Int64
+	rejected    atomic.Uint64
+
+	pendingMu sync.Mutex
+	// pending holds the addresses waiting for the answer to each UDP query
+	// being answered, by dedupKey
+	pending map[string][]*net.UDPAddr
+}
+
+func newListener(l config.Listener) *listener {
+	return &listener{Listener: l, log: serverLog.With("listener", l.Label()), pending: make(map[string][]*net.UDPAddr)}
+}
+
+// dedupKey identifies a query by client, ID and the question and options
+// after the header, empty when too short to be a query
+func dedupKey(ip net.IP, query []byte) string {
+	if len(query) < 12 {
+		return ""
+	}
+	return string(ip.To16()) + string(query[:2]) + string(query[12:])
+}
+
+// begin reports whether the query under key is not being answered yet,
+// otherwise addr waits for its answer
+func (l *listener) begin(key string, addr *net.UDPAddr) bool {
+	l.pendingMu.Lock()
+	defer l.pendingMu.Unlock()
+	if waiting, ok := l.pending[key]; ok {
+		l.pending[key] = append(waiting, addr)
+		return false
+	}
+	l.pending[key] = nil
+	return true
+}
+
+// finish returns the addresses that waited for the answer to the query
+// under key
+func (l *listener) finish(key string) []*net.UDPAddr {
+	l.pendingMu.Lock()
+	defer l.pendingMu.Unlock()
+	waiting := l.pending[key]
+	delete(l.pending, key)
+	return waiting
+}
+
+func (l *listener) stats() api.ListenerStats {
+	return api.ListenerStats{
+		Name:      l.Label(),
+		Protocol:  l.Protocol,
+		Address:   l.Address,
+		Queries:   l.queries.Load(),
+		Refused:   l.refused.Load(),
+		Malformed: l.malformed.Load(),
+		// retransmits answered with the query in flight
+		Deduplicated:        l.deduplicated.Load(),
+		Connections:         l.connections.Load(),
+		RejectedConnections: l.rejected.Load(),
+	}
+}
+
+// idleTimeout is how long a connection may go without a query
+func (l *listener) idleTimeout() time.Duration {
+	if l.limits.IdleTimeout > 0 {
+		return l.limits.IdleTimeout
+	}
+	return config.DefaultIdleTimeout
+}
+
+// connGate caps the connections open at once across the listeners of a
+// protocol
+type connGate struct {
+	max  int64
+	open atomic.Int64
+}
+
+// newGates returns a gate for each protocol whose connections are capped
+func newGates(cfg *config.Config) map[string]*connGate {
+	gates := make(map[string]*connGate)
+	for _, protocol := range []string{config.TCP, config.DoT, config.DoH} {
+		if limits := cfg.ConnectionLimits(protocol); limits.MaxConnections > 0 {
+			gates[protocol] = &connGate{max: int64(limits.MaxConnections)}
+		}
+	}
+	return gates
+}
+
+// enter reports whether another connection may open, counting it when so
+func (g *connGate) enter() bool {
+	if g == nil {
+		return true
+	}
+	if g.open.Add(1) > g.max {
+		g.open.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (g *connGate) leave() {
+	if g != nil {
+		g.open.Add(-1)
+	}
+}
+
+// limited returns ln closing the connections past the limit of the
+// protocol as it accepts them, and counting the others until closed
+func (l *listener) limited(ln net.Listener) net.Listener {
+	return &limitedListener{Listener: ln, l: l}
+}
+
+type limitedListener struct {
+	net.Listener
+	l *listener
+}
+
+func (ln *limitedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !ln.l.gate.enter() {
+			ln.l.rejected.Add(1)
+			// the address may wait on a PROXY header, leave it out
+			ln.l.log.Debug("connection over the limit closed", "max", ln.l.gate.max)
+			conn.Close()
+			continue
+		}
+		ln.l.connections.Add(1)
+		return &countedConn{Conn: conn, l: ln.l}, nil
+	}
+}
+
+// countedConn leaves the gate of its listener when closed
+type countedConn struct {
+	net.Conn
+	l    *listener
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.l.connections.Add(-1)
+		c.l.gate.leave()
+	})
+	return c.Conn.Close()
+}
+
+// serve answers queries on l until it fails
+func (s *Server) serve(l *listener) error {
+	switch l.Protocol {
+	case config.UDP:
+		conn, err := l.listenConfig().ListenPacket(context.Background(), "udp", l.Address)
+		if err != nil {
+			return err
+		}
+		return s.serveUDP(l, conn.(*net.UDPConn))
+	case config.TCP:
+		ln, err := l.listenConfig().Listen(context.Background(), "tcp", l.Address)
+		if err != nil {
+			return err
+		}
+		return s.serveStream(l, l.proxied(ln))
+	case config.DoT:
+		tlsConfig, err := s.tlsConfig(l)
+		if err != nil {
+			return err
+		}
+		ln, err := l.listenConfig().Listen(context.Background(), "tcp", l.Address)
+		if err != nil {
+			return err
+		}
+		// the PROXY header comes ahead of the TLS handshake
+		return s.serveStream(l, tls.NewListener(l.proxied(ln), tlsConfig))
+	case config.DoH:
+		return s.serveDoH(l)
+	}
+	return fmt.Errorf("unknown protocol %q", l.Protocol)
+}
+
+// listenConfig opens the sockets of l with its socket options, see
+// sockopt_linux.go
+func (l *listener) listenConfig() *net.ListenConfig {
+	if l.socket == (config.SocketOptions{}) {
+		return &net.ListenConfig{}
+	}
+	return &net.ListenConfig{Control: l.control}
+}
+
+// proxied wraps ln to read the PROXY protocol header of its connections
+// when the listener expects one
+func (l *listener) proxied(ln net.Listener) net.Listener {
+	if !l.ProxyProtocol {
+		return ln
+	}
+	return &proxyproto.Listener{Listener: ln, Trusted: l.ProxyNets()}
+}
+
+// serveUDP answers queries received on conn, one goroutine per query
+func (s *Server) serveUDP(l *listener, conn *net.UDPConn) error {
+	defer conn.Close()
+	l.log.Info("DNS Server running", "protocol", l.Protocol, "address", conn.LocalAddr())
+	for {
+		bufp := packetPool.Get().(*[]byte)
+		n, remoteAddr, err := conn.ReadFromUDP(*bufp)
+		if err != nil {
+			return err
+		}
+		l.log.Debug("received query", "bytes", n, "from", remoteAddr)
+		// a retransmit of a query still being answered gets its answer
+		key := dedupKey(remoteAddr.IP, (*bufp)[:n])
+		if key != "" && !l.begin(key, remoteAddr) {
+			l.deduplicated.Add(1)
+			packetPool.Put(bufp)
+			continue
+		}
+		go func() {
+			resp := responsePool.Get().(*[]byte)
+			res, ok := s.answer(l, remoteAddr.IP, (*bufp)[:n], (*resp)[:0])
+			var waiting []*net.UDPAddr
+			if key != "" {
+				waiting = l.finish(key)
+			}
+			if ok {
+				conn.WriteToUDP(res, remoteAddr)
+				for _, addr := range waiting {
+					conn.WriteToUDP(res, addr)
+				}
+				*resp = res
+			}
+			responsePool.Put(resp)
+			packetPool.Put(bufp)
+		}()
+	}
+}
+
+// serveStream answers length prefixed queries on TCP or DoT connections
+func (s *Server) serveStream(l *listener, ln net.Listener) error {
+	defer ln.Close()
+	ln = l.limited(ln)
+	l.log.Info("DNS Server running", "protocol", l.Protocol, "address", ln.Addr())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(l, conn)
+	}
+}
+
+// serveConn answers the queries of one connection in order, closing it
+// when idle, on the first malformed query or past the queries allowed
+func (s *Server) serveConn(l *listener, conn net.Conn) {
+	defer conn.Close()
+	var ip net.IP
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+	var length [2]byte
+	for n := 0; l.limits.MaxQueries == 0 || n < l.limits.MaxQueries; n++ {
+		conn.SetReadDeadline(time.Now().Add(l.idleTimeout()))
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		l.log.Debug("received query", "bytes", len(data), "from", conn.RemoteAddr())
+		// reserve the length prefix in front of the response
+		res, ok := s.answer(l, ip, data, make([]byte, 2, dns.BUFFER_SIZE))
+		if !ok {
+			return
+		}
+		if len(res)-2 > math.MaxUint16 {
+			l.log.Warn("response too large for TCP, answered SERVFAIL", "bytes", len(res)-2, "from", conn.RemoteAddr())
+			res = tooLarge(data, res[:2])
+		}
+		binary.BigEndian.PutUint16(res, uint16(len(res)-2))
+		conn.SetWriteDeadline(time.Now().Add(l.idleTimeout()))
+		if _, err := conn.Write(res); err != nil {
+			return
+		}
+	}
+}
+
+// tooLarge appends to buf a SERVFAIL answering the query in data, whose
+// response does not fit the 16 bit length prefix of TCP
+func tooLarge(data, buf []byte) []byte {
+	var query dns.Message
+	query.Decode(data)
+	return dns.NewResponse(&query).SetRcode(dns.RcodeServerFailure).AppendEncode(buf)
+}
+
+// serveDoH answers DNS over HTTPS on the listener path
+func (s *Server) serveDoH(l *listener) error {
+	mux := http.NewServeMux()
+	mux.Handle(l.Path, s.dohHandler(l))
+	ln, err := l.listenConfig().Listen(context.Background(), "tcp", l.Address)
+	if err != nil {
+		return err
+	}
+	ln = l.limited(ln)
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       l.idleTimeout(),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, connQueriesKey{}, new(atomic.Int64))
+		},
+	}
+	l.log.Info("DNS Server running", "protocol", l.Protocol, "address", ln.Addr(), "path", l.Path, "tls", l.TLS())
+	if l.TLS() {
+		if srv.TLSConfig, err = s.tlsConfig(l); err != nil {
+			ln.Close()
+			return err
+		}
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
+}
+
+// tlsConfig returns the TLS config of a dot or doh listener, serving its
+// cert and key or the certificate of the acme settings
+func (s *Server) tlsConfig(l *listener) (*tls.Config, error) {
+	if l.ACME {
+		if s.certs == nil {
+			return nil, errors.New("acme needs the acme settings")
+		}
+		return s.certs.TLSConfig(), nil
+	}
+	cert, err := tls.LoadX509KeyPair(l.Cert, l.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// connQueriesKey holds the count of the queries a DoH connection sent
+type connQueriesKey struct{}
+
+// dohHandler answers RFC 8484 GET and POST requests
+func (s *Server) dohHandler(l *listener) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data []byte
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			data, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if r.Header.Get("Content-Type") != "application/dns-message" {
+				http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+				return
+			}
+			data, err = io.ReadAll(io.LimitReader(r.Body, 65535))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil || len(data) == 0 {
+			http.Error(w, "missing or invalid dns message", http.StatusBadRequest)
+			return
+		}
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		l.log.Debug("received query", "bytes", len(data), "from", r.RemoteAddr)
+		res, ok := s.answer(l, net.ParseIP(host), data, nil)
+		if !ok {
+			http.Error(w, "malformed or dropped dns message", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		if n, ok := r.Context().Value(connQueriesKey{}).(*atomic.Int64); ok && l.limits.MaxQueries > 0 && n.Add(1) >= int64(l.limits.MaxQueries) {
+			// HTTP/1.1 closes the connection after the reply
+			w.Header().Set("Connection", "close")
+		}
+		w.Write(res)
+	})
+}
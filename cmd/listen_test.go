@@ -0,0 +1,313 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/client"
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/dns"
+)
+
+func testServer(allow ...*net.IPNet) *Server {
+	return &Server{
+		allow:   allow,
+		timeout: time.Second,
+		handler: &dns.Handler{
+			Cache:     &dns.RecordsCache{Records: make(map[string]dns.Message)},
+			Blocklist: blocked("blocked.test."),
+		},
+	}
+}
+
+func TestListenerProtocols(t *testing.T) {
+	s := testServer()
+
+	tcp := newListener(config.Listener{Protocol: config.TCP, Address: "127.0.0.1:0"})
+	ln, err := net.Listen("tcp", tcp.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serveStream(tcp, ln)
+	defer ln.Close()
+
+	doh := newListener(config.Listener{Protocol: config.DoH, Address: "127.0.0.1:0", Path: config.DefaultDoHPath})
+	srv := httptest.NewServer(s.dohHandler(doh))
+	defer srv.Close()
+
+	for _, c := range []*client.Client{
+		{Server: ln.Addr().String(), Protocol: client.TCP, Timeout: time.Second},
+		{Server: srv.URL + config.DefaultDoHPath, Protocol: client.DoH, Timeout: time.Second},
+	} {
+		for i := 0; i < 2; i++ {
+			records, err := c.Resolve(context.Background(), "blocked.test", dns.TypeA)
+			if err != nil {
+				t.Fatalf("%s: %v", c.Protocol, err)
+			}
+			if len(records) != 1 || records[0].Data != "127.0.0.1" {
+				t.Errorf("%s: got %v, want the sinkhole address", c.Protocol, records)
+			}
+		}
+	}
+	for _, l := range []*listener{tcp, doh} {
+		if got := l.stats(); got.Queries != 2 || got.Name != l.Protocol+"/127.0.0.1:0" {
+			t.Errorf("stats = %+v, want 2 queries", got)
+		}
+	}
+}
+
+func TestListenerPadding(t *testing.T) {
+	s := testServer()
+	doh := newListener(config.Listener{Protocol: config.DoH, Address: "127.0.0.1:0", Path: config.DefaultDoHPath})
+	srv := httptest.NewServer(s.dohHandler(doh))
+	defer srv.Close()
+
+	c := &client.Client{Server: srv.URL + config.DefaultDoHPath, Protocol: client.DoH, Timeout: time.Second, Padding: true}
+	res, err := c.Query(context.Background(), "blocked.test", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Raw) != dns.PaddingBlockSize {
+		t.Errorf("reply of %d bytes, want padded to %d", len(res.Raw), dns.PaddingBlockSize)
+	}
+}
+
+func TestListenerCountsRefusedAndMalformed(t *testing.T) {
+	_, allowed, _ := net.ParseCIDR("10.0.0.0/8")
+	s := testServer(allowed)
+	l := newListener(config.Listener{Name: "lan", Protocol: config.UDP, Address: "127.0.0.1:0"})
+
+	query := client.New("127.0.0.1").NewQuery("blocked.test", dns.TypeA).Encode()
+	res, ok := s.answer(l, net.ParseIP("192.0.2.1"), query, nil)
+	if !ok || len(res) < 4 || res[3]&0x0F != byte(dns.RcodeRefused) {
+		t.Errorf("query from outside the allow list: got %v, %v, want REFUSED", res, ok)
+	}
+	if _, ok := s.answer(l, net.ParseIP("10.0.0.1"), query[:5], nil); ok {
+		t.Error("malformed query was answered")
+	}
+	// the reserved Z bit set
+	reserved := slices.Clone(query)
+	reserved[3] |= 0x40
+	res, ok = s.answer(l, net.ParseIP("10.0.0.1"), reserved, nil)
+	if !ok || len(res) < 4 || res[3]&0x0F != byte(dns.RcodeFormatError) {
+		t.Errorf("query with the reserved bit: got %v, %v, want FORMERR", res, ok)
+	}
+
+	got := l.stats()
+	want := struct{ queries, refused, malformed uint64 }{3, 1, 2}
+	if got.Name != "lan" || got.Queries != want.queries || got.Refused != want.refused || got.Malformed != want.malformed {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestListenerConnectionLimits(t *testing.T) {
+	s := testServer()
+	tcp := newListener(config.Listener{Protocol: config.TCP, Address: "127.0.0.1:0"})
+	tcp.limits = config.ConnectionLimits{MaxConnections: 1, MaxQueries: 2}
+	tcp.gate = &connGate{max: 1}
+	ln, err := net.Listen("tcp", tcp.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serveStream(tcp, ln)
+	defer ln.Close()
+
+	query := client.New("127.0.0.1").NewQuery("blocked.test", dns.TypeA).Encode()
+	exchange := func(conn net.Conn) error {
+		conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write(append([]byte{0, byte(len(query))}, query...)); err != nil {
+			return err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return err
+		}
+		_, err := io.ReadFull(conn, make([]byte, int(length[0])<<8|int(length[1])))
+		return err
+	}
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if err := exchange(first); err != nil {
+		t.Fatalf("first query: %v", err)
+	}
+	second, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if err := exchange(second); err == nil {
+		t.Error("connection past the limit was served")
+	}
+	if got := tcp.stats(); got.Connections != 1 || got.RejectedConnections != 1 {
+		t.Errorf("stats = %+v, want 1 open and 1 rejected connection", got)
+	}
+
+	if err := exchange(first); err != nil {
+		t.Fatalf("second query: %v", err)
+	}
+	if err := exchange(first); err == nil {
+		t.Error("third query answered past max_queries")
+	}
+	// the closed connection frees its place
+	for deadline := time.Now().Add(time.Second); tcp.stats().Connections != 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := tcp.stats(); got.Connections != 0 || tcp.gate.open.Load() != 0 {
+		t.Errorf("stats = %+v, gate holds %d, want no connection open", got, tcp.gate.open.Load())
+	}
+}
+
+func TestListenerTCPResponseOver64KiB(t *testing.T) {
+	s := testServer()
+	// two TXT records of 40000 bytes answer more than the 16 bit length
+	// prefix of TCP can frame
+	txt := strings.Repeat("x", 40000)
+	zone := dns.Zone{Origin: "big.test.", TXT: []dns.Record{{Name: "@", Value: txt + "a"}, {Name: "@", Value: txt + "b"}}}
+	s.handler.Zones = map[string]dns.Zone{zone.Origin: zone}
+
+	tcp := newListener(config.Listener{Protocol: config.TCP, Address: "127.0.0.1:0"})
+	ln, err := net.Listen("tcp", tcp.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serveStream(tcp, ln)
+	defer ln.Close()
+
+	c := &client.Client{Server: ln.Addr().String(), Protocol: client.TCP, Timeout: time.Second}
+	for _, name := range []string{"big.test", "blocked.test"} {
+		res, err := c.Query(context.Background(), name, dns.TypeTXT)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if name == "big.test" && res.Header.RCODE != dns.RcodeServerFailure {
+			t.Errorf("%s: %s with %d answers, want SERVFAIL", name, dns.RcodeName(res.Header.RCODE), len(res.Answers))
+		}
+	}
+}
+
+func TestAllowedIPv6(t *testing.T) {
+	var nets []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "2001:db8::/32"} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		nets = append(nets, ipnet)
+	}
+	s := testServer(nets...)
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		// IPv4 clients of a dual stack [::] listener
+		{"::ffff:10.1.2.3", true},
+		{"::ffff:192.0.2.1", false},
+		{"2001:db8::53", true},
+		{"2001:db9::53", false},
+		{"::1", false},
+	}
+	for _, tt := range tests {
+		if got := s.allowed(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("allowed(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestListenerUDPIPv6(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	s := testServer()
+	go s.serveUDP(newListener(config.Listener{Protocol: config.UDP, Address: "[::1]:0"}), conn)
+	defer conn.Close()
+
+	records, err := client.New(conn.LocalAddr().String()).Resolve(context.Background(), "blocked.test", dns.TypeAAAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Data != "::1" {
+		t.Errorf("got %v, want the IPv6 sinkhole address", records)
+	}
+}
+
+func TestListenerDeduplicatesRetransmits(t *testing.T) {
+	// the upstream answers slowly, counting the queries it gets
+	upstream, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	var asked atomic.Int32
+	go func() {
+		buf := make([]byte, dns.BUFFER_SIZE)
+		for {
+			n, addr, err := upstream.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			asked.Add(1)
+			query := &dns.Message{}
+			query.Decode(buf[:n])
+			name, _ := dns.EncodeDomainName(query.Question.DomainName)
+			time.Sleep(200 * time.Millisecond)
+			answer := dns.Answer{Name: name, Type: uint16(dns.TypeA), Class: 1, TTL: 60, RData: []byte{192, 0, 2, 1}, RDLength: 4}
+			upstream.WriteToUDP(dns.NewResponse(query).Answer(answer).Message().Encode(), addr)
+		}
+	}()
+
+	s := testServer()
+	s.handler.Upstreams = []dns.Upstream{{Address: upstream.LocalAddr().String(), Timeout: time.Second}}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newListener(config.Listener{Protocol: config.UDP, Address: "127.0.0.1:0"})
+	go s.serveUDP(l, conn)
+	defer conn.Close()
+
+	c, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	query := client.New("").NewQuery("slow.test", dns.TypeA).Encode()
+	c.Write(query)
+	c.Write(query)
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i := range 2 {
+		buf := make([]byte, dns.BUFFER_SIZE)
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatalf("reply %d: %v", i, err)
+		}
+		if res, err := client.Parse(buf[:n]); err != nil || len(res.Answers) != 1 {
+			t.Errorf("reply %d: %v, %v", i, res, err)
+		}
+	}
+	if got := asked.Load(); got != 1 {
+		t.Errorf("upstream asked %d times, want once", got)
+	}
+	if got := l.stats(); got.Queries != 1 || got.Deduplicated != 1 {
+		t.Errorf("stats = %+v, want 1 query and 1 deduplicated", got)
+	}
+}
+
+// blocked returns a sinkhole blocking names
+func blocked(names ...string) *blocklist.Sinkhole {
+	store := blocklist.NewMap()
+	store.Reload(names)
+	sinkhole := blocklist.NewSinkhole()
+	sinkhole.Set(blocklist.CategoryBlocklist, blocklist.CategoryBlocklist, store)
+	return sinkhole
+}
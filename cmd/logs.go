@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/querylog"
+	"github.com/spf13/cobra"
+)
+
+// logsCmd groups query log subcommands
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "work with the query log",
+}
+
+var (
+	ExportSince  time.Duration
+	ExportFormat string
+	ExportOutput string
+	ExportFile   string
+)
+
+// logsExportCmd dumps the query log for analysis
+var logsExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export the query log as csv or json",
+	Long: `Export writes the queries of the query_log file, and of the file it was
+last rotated to, logged during the last --since in csv with a header line,
+a json array or json lines, oldest first. Pandas reads the csv with
+read_csv and the json lines with read_json(lines=True).
+
+Example usage:
+$ mercury logs export --since 24h --format csv > queries.csv
+$ mercury logs export --since 168h --format jsonl -o week.jsonl
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		file := ExportFile
+		if file == "" {
+			cfg, err := config.Load(ConfigFile)
+			check(err)
+			file = cfg.QueryLog.File
+		}
+		if file == "" {
+			fmt.Fprintln(os.Stderr, "no query_log file in the config, use --file")
+			os.Exit(1)
+		}
+		var out io.Writer = os.Stdout
+		if ExportOutput != "" && ExportOutput != "-" {
+			f, err := os.Create(ExportOutput)
+			check(err)
+			defer f.Close()
+			out = f
+		}
+		n, err := exportQueries(out, file, ExportFormat, time.Now().Add(-ExportSince))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if out != os.Stdout {
+			fmt.Printf("exported %d queries to %s\n", n, ExportOutput)
+		}
+	},
+}
+
+// exportQueries writes the queries of the log at file logged since then
+// to w in format and returns how many were written
+func exportQueries(w io.Writer, file, format string, since time.Time) (int, error) {
+	exporter, err := querylog.NewExporter(w, format)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = querylog.Read(file, since, func(e querylog.Entry) error {
+		n++
+		return exporter.Write(e)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, exporter.Close()
+}
+
+func init() {
+	logsExportCmd.Flags().DurationVar(&ExportSince, "since", 24*time.Hour, "export the queries of this last period")
+	logsExportCmd.Flags().StringVar(&ExportFormat, "format", "csv", "export format: "+strings.Join(querylog.Formats, ", "))
+	logsExportCmd.Flags().StringVarP(&ExportOutput, "output", "o", "", "file to write, stdout by default")
+	logsExportCmd.Flags().StringVar(&ExportFile, "file", "", "query log to read (default query_log.file of the config)")
+	logsCmd.AddCommand(logsExportCmd)
+	rootCmd.AddCommand(logsCmd)
+}
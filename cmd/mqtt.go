@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/mqtt"
+)
+
+// blockingOff is how long the blocking switch turns blocking off, until it
+// is turned on again
+const blockingOff = 365 * 24 * time.Hour
+
+// startMQTT publishes the stats of the server to the MQTT broker of the
+// config, taking commands for the blocking switch
+func (s *Server) startMQTT(cfg *config.Config) {
+	if cfg.MQTT.Broker == "" {
+		return
+	}
+	setBlocking := func(on bool) {
+		if on {
+			sinkholed.Pause(nil, 0)
+		} else {
+			sinkholed.Pause(nil, blockingOff)
+		}
+	}
+	client := mqtt.New(cfg.MQTT, cfg.Identity.InstanceName(), s.mqttStats, setBlocking)
+	go client.Run(context.Background())
+}
+
+// mqttStats sums the counters of the listeners, sinkhole, cache and clients
+func (s *Server) mqttStats() mqtt.Stats {
+	stats := mqtt.Stats{
+		CacheHits: dnsCache.Stats().Hits,
+		Clients:   len(s.clients.Stats()),
+		Blocking:  Sinkhole && !sinkholed.Paused(),
+	}
+	for _, l := range s.Stats() {
+		stats.Queries += l.Queries
+	}
+	if Sinkhole {
+		for _, blocked := range sinkholed.Stats().Categories {
+			stats.Blocked += blocked
+		}
+		stats.BlockedDomains = sinkholed.Len()
+	}
+	if stats.Queries > 0 {
+		stats.BlockedPercent = 100 * float64(stats.Blocked) / float64(stats.Queries)
+	}
+	return stats
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/cache"
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/bernoussama/mercury/peer"
+	"github.com/spf13/cobra"
+)
+
+// maxCacheEvents bounds the cached answers sent in a full sync
+const maxCacheEvents = 10000
+
+// peering shares the state of the server with its peers, nil without
+// peering
+var peering *peer.Node
+
+// startPeering shares the sinkhole edits, the hosts registered from leases
+// and, when configured, the cached answers with the peers
+func (s *Server) startPeering(cfg *config.Config) {
+	opts := cfg.Peering
+	if opts.Listen == "" && len(opts.Peers) == 0 {
+		return
+	}
+	if opts.Name == "" {
+		opts.Name = cfg.Identity.InstanceName()
+	}
+	apply := func(e peer.Event) error {
+		switch {
+		case e.Kind == peer.KindEdit && e.Edit != nil && Sinkhole:
+			return sinkholed.Apply(*e.Edit)
+		case e.Kind == peer.KindHosts && s.hosts != nil:
+			s.hosts.set(e.From, e.Hosts)
+		case e.Kind == peer.KindCache && e.Cache != nil && opts.Cache:
+			msg := e.Cache.Message
+			msg.Source = "peer " + e.From
+			dnsCache.Set(e.Cache.Key, msg, e.Cache.TTL)
+		}
+		return nil
+	}
+	state := func() []peer.Event {
+		var events []peer.Event
+		if Sinkhole {
+			for _, edit := range sinkholed.Edits() {
+				events = append(events, peer.Event{Kind: peer.KindEdit, Edit: &edit})
+			}
+		}
+		if s.hosts != nil {
+			events = append(events, peer.Event{Kind: peer.KindHosts, Hosts: s.hosts.local()})
+		}
+		if opts.Cache {
+			now := time.Now()
+			dnsCache.Range(func(e cache.Entry[dns.Message]) bool {
+				if ttl := e.Value.Expiry.Sub(now); ttl >= time.Second {
+					// the peer stores it anew, send the TTLs left
+					aged := e.Value.Aged(now)
+					entry := &peer.CacheEntry{Key: e.Key, Message: aged.Clone(), TTL: uint32(ttl.Seconds())}
+					events = append(events, peer.Event{Kind: peer.KindCache, Cache: entry})
+				}
+				return len(events) < maxCacheEvents
+			})
+		}
+		return events
+	}
+	peering = peer.New(opts, apply, state)
+	sinkholed.OnEdit(func(edit blocklist.Edit) {
+		peering.Publish(peer.Event{Kind: peer.KindEdit, Edit: &edit})
+	})
+	if opts.Cache {
+		s.handler.Cache = sharedCache{dnsCache}
+	}
+	if opts.Listen != "" {
+		go func() {
+			if err := peering.ListenAndServe(); err != nil {
+				serverLog.Error("peering stopped", "err", err)
+			}
+		}()
+	}
+	peering.Run(context.Background())
+}
+
+// sharedCache sends the answers it caches to the peers
+type sharedCache struct {
+	cache.Cache[dns.Message]
+}
+
+func (c sharedCache) Set(key string, msg dns.Message, ttl uint32) {
+	c.Cache.Set(key, msg, ttl)
+	peering.Publish(peer.Event{Kind: peer.KindCache, Cache: &peer.CacheEntry{Key: key, Message: msg.Clone(), TTL: ttl}})
+}
+
+// hostTable serves the hosts registered from the local leases along with
+// those of the peers, local hosts taking precedence
+type hostTable struct {
+	hosts *dns.Hosts
+
+	mu sync.Mutex
+	// byOrigin holds the hosts of each peer, and the local ones under ""
+	byOrigin map[string]map[string][]net.IP
+}
+
+func newHostTable(domain string) *hostTable {
+	return &hostTable{hosts: dns.NewHosts(domain), byOrigin: make(map[string]map[string][]net.IP)}
+}
+
+// dnsHosts returns the hosts to answer from, nil without a table
+func (t *hostTable) dnsHosts() *dns.Hosts {
+	if t == nil {
+		return nil
+	}
+	return t.hosts
+}
+
+// setLocal replaces the local hosts and shares them with the peers
+func (t *hostTable) setLocal(hosts map[string][]net.IP) {
+	t.set("", hosts)
+	peering.Publish(peer.Event{Kind: peer.KindHosts, Hosts: hosts})
+}
+
+func (t *hostTable) local() map[string][]net.IP {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.byOrigin[""]
+}
+
+// set replaces the hosts of an origin and serves the merged hosts
+func (t *hostTable) set(origin string, hosts map[string][]net.IP) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.byOrigin[origin] = hosts
+	merged := make(map[string][]net.IP)
+	for label, ips := range t.byOrigin[""] {
+		merged[label] = ips
+	}
+	origins := make([]string, 0, len(t.byOrigin))
+	for o := range t.byOrigin {
+		if o != "" {
+			origins = append(origins, o)
+		}
+	}
+	slices.Sort(origins)
+	for _, o := range origins {
+		for label, ips := range t.byOrigin[o] {
+			if _, ok := t.byOrigin[""][label]; !ok {
+				merged[label] = append(merged[label], ips...)
+			}
+		}
+	}
+	t.hosts.Set(merged)
+}
+
+// peersCmd lists the peers of the running server
+var peersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "show the state sync of the running server with its peers",
+	Long: `Peers lists the instances the running server shares its state with, the
+events sent to each, those dropped while the peer lagged and the last
+successful sync.
+
+Example usage:
+$ mercury peers
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var stats []peer.Stats
+		if err := adminRequest(http.MethodGet, "/api/peers", nil, &stats); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PEER\tSENT\tDROPPED\tLAST SYNC\tERROR")
+		for _, s := range stats {
+			last := "-"
+			if s.LastSync != nil {
+				last = s.LastSync.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", s.URL, s.Sent, s.Dropped, last, orDash(s.Error))
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(peersCmd)
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bernoussama/mercury/client"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/spf13/cobra"
+)
+
+var (
+	QueryServer   string
+	QueryProtocol string
+	QueryTimeout  time.Duration
+	QueryRetries  int
+	QueryPadding  bool
+	QueryJSON     bool
+)
+
+// queryCmd sends a single query and prints the reply
+var queryCmd = &cobra.Command{
+	Use:   "query <name> [type]",
+	Short: "query a DNS server",
+	Long: `Query resolves name through a DNS server and prints the reply sections in
+zone file format. The type defaults to A. With --json the whole reply is
+printed as JSON instead.
+
+Example usage:
+$ mercury query example.com mx --server 127.0.0.1:53153
+$ mercury query example.com aaaa --protocol doh --server https://dns.google/dns-query
+`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		qtype := dns.TypeA
+		if len(args) == 2 {
+			t, err := dns.ParseQType(args[1])
+			check(err)
+			qtype = t
+		}
+
+		c := newQueryClient()
+		start := time.Now()
+		res, err := c.Query(context.Background(), args[0], qtype)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if QueryJSON {
+			msg := dns.Message{Bytes: res.Raw}
+			_, err := msg.Decode(res.Raw)
+			check(err)
+			check(json.NewEncoder(os.Stdout).Encode(msg))
+			return
+		}
+		fmt.Println(res.Header)
+		if len(res.Options) > 0 {
+			fmt.Println("\n;; OPT PSEUDOSECTION:")
+			for _, o := range res.Options {
+				fmt.Printf("; %s\n", o)
+			}
+		}
+		printSection(os.Stdout, "ANSWER", res.Answers)
+		printSection(os.Stdout, "AUTHORITY", res.Authority)
+		printSection(os.Stdout, "ADDITIONAL", res.Additional)
+		fmt.Printf(";; query time: %s, server: %s (%s), size: %d\n",
+			time.Since(start).Round(time.Millisecond), c.Server, c.Protocol, len(res.Raw))
+	},
+}
+
+// hostCmd resolves the addresses of a name
+var hostCmd = &cobra.Command{
+	Use:   "host <name>",
+	Short: "look up the addresses of a host",
+	Long: `Host queries the A and AAAA records of name in parallel and prints the
+addresses in the order a client should try them, alternating IPv6 and IPv4.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		addrs, err := newQueryClient().LookupHost(context.Background(), args[0])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, addr := range addrs {
+			fmt.Println(addr)
+		}
+	},
+}
+
+// newQueryClient returns a client configured from the query flags
+func newQueryClient() *client.Client {
+	c := client.New(QueryServer)
+	c.Protocol = client.Protocol(QueryProtocol)
+	c.Timeout = QueryTimeout
+	c.Retries = QueryRetries
+	c.Padding = QueryPadding
+	return c
+}
+
+func printSection(w io.Writer, name string, records []client.Record) {
+	if len(records) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n;; %s SECTION:\n", name)
+	for _, r := range records {
+		fmt.Fprintln(w, r)
+	}
+}
+
+func init() {
+	server := os.Getenv("QUERY_SERVER")
+	if server == "" {
+		server = "127.0.0.1:53153"
+	}
+	for _, c := range []*cobra.Command{queryCmd, hostCmd, shellCmd} {
+		c.Flags().StringVar(&QueryServer, "server", server, "server address, or the query URL for doh")
+		c.Flags().StringVarP(&QueryProtocol, "protocol", "p", string(client.UDP), "transport: udp, tcp, dot or doh")
+		c.Flags().DurationVar(&QueryTimeout, "timeout", client.DefaultTimeout, "timeout of a single attempt")
+		c.Flags().IntVar(&QueryRetries, "retries", client.DefaultRetries, "attempts made after the first one fails")
+		c.Flags().BoolVar(&QueryPadding, "padding", false, "pad dot and doh queries and ask for padded replies")
+		rootCmd.AddCommand(c)
+	}
+	queryCmd.Flags().BoolVar(&QueryJSON, "json", false, "print the reply as JSON")
+}
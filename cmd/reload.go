@@ -0,0 +1,343 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/api"
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/spf13/cobra"
+)
+
+// reloadable are the settings Reload applies, the others need a restart.
+// Blocklist sources and allowlists only matter to blocklist compile.
+var reloadable = []string{
+	"zones", "zone_values", "blocklists", "blocklist_store", "blocklist_sources",
+	"allowlists", "blocklist_compiled", "threat_feeds", "client_groups",
+}
+
+// Reload reads the config file again and applies its zones, blocklists,
+// client groups and threat feeds. Every file is read before any change is
+// applied, so a config that fails to load leaves the server as it was.
+func (s *Server) Reload() (api.ReloadSummary, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	cfg, err := config.Load(ConfigFile)
+	if err != nil {
+		return api.ReloadSummary{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return api.ReloadSummary{}, err
+	}
+	var newZones map[string]dns.Zone
+	if Zone {
+		if newZones, err = readZones(cfg); err != nil {
+			return api.ReloadSummary{}, err
+		}
+	}
+	var lists []categoryList
+	if Sinkhole {
+		if lists, err = readBlocklists(cfg); err != nil {
+			return api.ReloadSummary{}, err
+		}
+	}
+
+	summary := api.ReloadSummary{Blocklists: []api.BlocklistChange{}, ZoneDiffs: []dns.ZoneDiff{}, RestartNeeded: []string{}}
+	for _, key := range s.cfg.Changes(cfg) {
+		if !slices.Contains(reloadable, key) {
+			summary.RestartNeeded = append(summary.RestartNeeded, key)
+		}
+	}
+	if Zone {
+		s.health.Watch(newZones)
+		summary.Zones = diffZones(s.handler.Zones, newZones, time.Now())
+		summary.ZoneDiffs = zoneDiffs(s.handler.Zones, newZones, summary.Zones.Changed)
+		s.handler.SetZones(newZones)
+		notifySecondaries(newZones, append(summary.Zones.Added, summary.Zones.Changed...))
+	}
+	if Sinkhole {
+		summary.Blocklists = s.reloadBlocklists(cfg, lists)
+		summary.Groups = diff(groupsByName(s.cfg.ClientGroups), groupsByName(cfg.ClientGroups))
+		if !summary.Groups.Empty() {
+			// categories toggled at runtime are reset with the groups
+			sinkholed.SetGroups(cfg.ClientGroups)
+		}
+		summary.Feeds = s.reloadFeeds(cfg)
+	}
+	s.cfg = cfg
+	serverLog.Info("reloaded config", "zones", len(newZones), "blocklists", len(lists), "restart_needed", summary.RestartNeeded)
+	return summary, nil
+}
+
+// reloadBlocklists replaces the lists of the blocklist categories and
+// counts the names each gained and lost
+func (s *Server) reloadBlocklists(cfg *config.Config, lists []categoryList) []api.BlocklistChange {
+	var changes []api.BlocklistChange
+	replace := make([]blocklist.List, 0, len(lists))
+	for _, l := range lists {
+		change := api.BlocklistChange{Category: l.Category, After: l.Store.Len()}
+		old, ok := sinkholed.Store(l.Name)
+		if ok {
+			change.Before = old.Len()
+			change.Added, change.Removed = blocklist.Delta(old, l.Store, l.names)
+		} else {
+			change.Added = change.After
+		}
+		changes = append(changes, change)
+		replace = append(replace, l.List)
+	}
+	oldCategories, _ := blocklistCategories(s.cfg)
+	newCategories, _ := blocklistCategories(cfg)
+	var remove []string
+	for _, category := range oldCategories {
+		if slices.Contains(newCategories, category) {
+			continue
+		}
+		remove = append(remove, category)
+		if old, ok := sinkholed.Store(category); ok {
+			changes = append(changes, api.BlocklistChange{Category: category, Before: old.Len(), Removed: old.Len()})
+		}
+	}
+	for _, old := range sinkholed.Replace(replace, remove) {
+		closeStore(old)
+	}
+	return changes
+}
+
+// reloadFeeds subscribes to the added and changed threat feeds and drops
+// the removed ones. Unchanged feeds keep their names and schedule.
+func (s *Server) reloadFeeds(cfg *config.Config) api.Changes {
+	oldFeeds, newFeeds := feedsByName(s.cfg.ThreatFeeds), feedsByName(cfg.ThreatFeeds)
+	changes := diff(oldFeeds, newFeeds)
+	for _, name := range append(changes.Removed, changes.Changed...) {
+		feedCancels[name]()
+		delete(feedCancels, name)
+	}
+	sinkholed.Replace(nil, changes.Removed)
+	for _, name := range append(changes.Added, changes.Changed...) {
+		subscribe(newFeeds[name])
+	}
+	return changes
+}
+
+func groupsByName(groups []blocklist.GroupOptions) map[string]blocklist.GroupOptions {
+	byName := make(map[string]blocklist.GroupOptions, len(groups))
+	for _, g := range groups {
+		byName[g.Name] = g
+	}
+	return byName
+}
+
+func feedsByName(feeds []blocklist.FeedOptions) map[string]blocklist.FeedOptions {
+	byName := make(map[string]blocklist.FeedOptions, len(feeds))
+	for _, feed := range feeds {
+		byName[feed.ListName()] = feed
+	}
+	return byName
+}
+
+// diffZones returns the zones added, removed and changed from old to new.
+// Changed zones whose serial was not raised get the serial following the
+// previous one, unchanged ones keep the serial an earlier reload gave them.
+func diffZones(old, new map[string]dns.Zone, now time.Time) api.Changes {
+	for name, zone := range new {
+		prev, ok := old[name]
+		if !ok {
+			continue
+		}
+		serial, hasSerial := zone.Serial()
+		prevSerial, _ := prev.Serial()
+		if !hasSerial || dns.SerialAfter(serial, prevSerial) {
+			continue
+		}
+		zone.SetSerial(prevSerial)
+		if !dns.DiffZone(prev, zone).Empty() {
+			next := dns.NextSerial(prevSerial, now)
+			zone.SetSerial(next)
+			serverLog.Info("bumped zone serial", "zone", name, "from", prevSerial, "to", next)
+		}
+		new[name] = zone
+	}
+	return diff(old, new)
+}
+
+// zoneDiffs returns the records changed in the named zones from old to new
+func zoneDiffs(old, new map[string]dns.Zone, names []string) []dns.ZoneDiff {
+	diffs := make([]dns.ZoneDiff, 0, len(names))
+	for _, name := range names {
+		diffs = append(diffs, dns.DiffZone(old[name], new[name]))
+	}
+	return diffs
+}
+
+// notifyTimeout bounds the time spent notifying the secondaries of a zone
+const notifyTimeout = 30 * time.Second
+
+// notifySecondaries sends a NOTIFY for the named zones to their
+// secondaries in the background
+func notifySecondaries(zones map[string]dns.Zone, names []string) {
+	for _, name := range names {
+		zone := zones[name]
+		if len(zone.Notify) == 0 {
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			serial, _ := zone.Serial()
+			if err := zone.NotifySecondaries(ctx); err != nil {
+				serverLog.Warn("secondaries not notified", "zone", name, "serial", serial, "err", err)
+				return
+			}
+			serverLog.Info("notified secondaries", "zone", name, "serial", serial, "secondaries", len(zone.Notify))
+		}()
+	}
+}
+
+// recordScheduleInterval bounds the wait between two checks of the zones
+// for records activating or expiring, so zones reloaded meanwhile are
+// checked too
+const recordScheduleInterval = time.Minute
+
+// scheduleRecords starts and stops answering the records of the zones as
+// they activate and expire, until ctx is done
+func (s *Server) scheduleRecords(ctx context.Context) {
+	last := time.Now()
+	for {
+		wait := recordScheduleInterval
+		for _, zone := range s.handler.CurrentZones() {
+			if next, ok := zone.NextSwitch(last); ok {
+				wait = min(wait, next.Sub(last))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		now := time.Now()
+		s.switchZones(last, now)
+		last = now
+	}
+}
+
+// switchZones indexes again the zones with records activating or expiring
+// after since and up to now, bumps their serial and notifies their
+// secondaries. It returns the sorted names of those zones.
+func (s *Server) switchZones(since, now time.Time) []string {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	zones := s.handler.CurrentZones()
+	var switched []string
+	for name, zone := range zones {
+		if zone.Switching(since, now) {
+			switched = append(switched, name)
+		}
+	}
+	if len(switched) == 0 {
+		return nil
+	}
+	slices.Sort(switched)
+	updated := maps.Clone(zones)
+	for _, name := range switched {
+		zone := updated[name]
+		// setting the serial indexes the zone again
+		if serial, ok := zone.Serial(); ok {
+			zone.SetSerial(dns.NextSerial(serial, now))
+		} else {
+			zone.Index()
+		}
+		updated[name] = zone
+		serial, _ := zone.Serial()
+		serverLog.Info("scheduled zone records switched", "zone", name, "serial", serial)
+	}
+	s.handler.SetZones(updated)
+	notifySecondaries(updated, switched)
+	return switched
+}
+
+// diff returns the sorted names added, removed and changed from old to new
+func diff[T any](old, new map[string]T) api.Changes {
+	var changes api.Changes
+	for name, v := range new {
+		if prev, ok := old[name]; !ok {
+			changes.Added = append(changes.Added, name)
+		} else if !reflect.DeepEqual(prev, v) {
+			changes.Changed = append(changes.Changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			changes.Removed = append(changes.Removed, name)
+		}
+	}
+	slices.Sort(changes.Added)
+	slices.Sort(changes.Removed)
+	slices.Sort(changes.Changed)
+	return changes
+}
+
+// reloadCmd asks the running server to apply its config file again
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "apply the config, zones and blocklists again without restarting",
+	Long: `Reload makes the running server read its config file again and apply the
+zones, blocklists, client groups and threat feeds, then prints what changed.
+A config that fails to load is reported and the server keeps the previous
+one. Other settings, like listeners and the admin API, need a restart.
+
+Example usage:
+$ mercury reload
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var summary api.ReloadSummary
+		if err := adminRequest(http.MethodPost, "/api/reload", nil, &summary); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		printChanges("zones", summary.Zones)
+		for _, d := range summary.ZoneDiffs {
+			fmt.Printf("zone %s:\n%s", d.Origin, d)
+		}
+		for _, b := range summary.Blocklists {
+			fmt.Printf("blocklist %s: %d -> %d domains (+%d -%d)\n", b.Category, b.Before, b.After, b.Added, b.Removed)
+		}
+		printChanges("threat feeds", summary.Feeds)
+		printChanges("client groups", summary.Groups)
+		if len(summary.RestartNeeded) > 0 {
+			fmt.Printf("restart to apply: %s\n", strings.Join(summary.RestartNeeded, ", "))
+		}
+	},
+}
+
+func printChanges(what string, changes api.Changes) {
+	if changes.Empty() {
+		fmt.Printf("%s: unchanged\n", what)
+		return
+	}
+	var parts []string
+	for _, name := range changes.Added {
+		parts = append(parts, "+"+name)
+	}
+	for _, name := range changes.Removed {
+		parts = append(parts, "-"+name)
+	}
+	for _, name := range changes.Changed {
+		parts = append(parts, "~"+name)
+	}
+	fmt.Printf("%s: %s\n", what, strings.Join(parts, " "))
+}
+
+func init() {
+	rootCmd.AddCommand(reloadCmd)
+}
@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/api"
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/dns"
+)
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	zonesDir := filepath.Join(dir, "zones")
+	write := func(name, data string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zone := func(origin, ip string) string {
+		return "origin: " + origin + "\nttl: 60\nsoa:\n  mname: ns1." + origin + "\n  rname: admin." + origin + "\n  serial: 1\nns:\n  - host: ns1." + origin + "\na:\n  - name: \"@\"\n    value: " + ip + "\n"
+	}
+	write("zones/a.test.yml", zone("a.test.", "10.0.0.1"))
+	write("zones/b.test.yml", zone("b.test.", "10.0.0.2"))
+	write("ads.txt", "ads.example.com\ntracker.example.com\n")
+	write("adult.txt", "adult.example.com\n")
+	write("config.yml", "listen: 127.0.0.1:53153\nzones: "+zonesDir+"\nblocklists:\n  - path: "+filepath.Join(dir, "ads.txt")+"\n    category: ads\n  - path: "+filepath.Join(dir, "adult.txt")+"\n    category: adult\n")
+
+	prevFile, prevZone, prevSinkhole, prevSinkholed := ConfigFile, Zone, Sinkhole, sinkholed
+	t.Cleanup(func() { ConfigFile, Zone, Sinkhole, sinkholed = prevFile, prevZone, prevSinkhole, prevSinkholed })
+	ConfigFile, Zone, Sinkhole, sinkholed = filepath.Join(dir, "config.yml"), true, true, blocklist.NewSinkhole()
+
+	cfg, err := config.Load(ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := readZones(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadBlocklist(cfg)
+	s := &Server{cfg: cfg, handler: &dns.Handler{Zones: loaded, Blocklist: sinkholed}}
+
+	// a zone changed, one removed and one added, a blocklist changed and
+	// one dropped
+	write("zones/b.test.yml", zone("b.test.", "10.0.0.22"))
+	os.Remove(filepath.Join(zonesDir, "a.test.yml"))
+	write("zones/c.test.yml", zone("c.test.", "10.0.0.3"))
+	write("ads.txt", "ads.example.com\nmetrics.example.com\nbeacon.example.com\n")
+	write("config.yml", "listen: 127.0.0.1:53154\nzones: "+zonesDir+"\nblocklists:\n  - path: "+filepath.Join(dir, "ads.txt")+"\n    category: ads\n")
+	summary, err := s.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := api.Changes{Added: []string{"c.test."}, Removed: []string{"a.test."}, Changed: []string{"b.test."}}
+	if !slices.Equal(summary.Zones.Added, want.Added) || !slices.Equal(summary.Zones.Removed, want.Removed) || !slices.Equal(summary.Zones.Changed, want.Changed) {
+		t.Errorf("zones %+v, want %+v", summary.Zones, want)
+	}
+	wantLists := []api.BlocklistChange{
+		{Category: "ads", Before: 2, After: 3, Added: 2, Removed: 1},
+		{Category: "adult", Before: 1, Removed: 1},
+	}
+	if !slices.Equal(summary.Blocklists, wantLists) {
+		t.Errorf("blocklists %+v, want %+v", summary.Blocklists, wantLists)
+	}
+	if !slices.Equal(summary.RestartNeeded, []string{"listen"}) {
+		t.Errorf("restart needed for %v, want listen", summary.RestartNeeded)
+	}
+	if _, blocked := sinkholed.Match(nil, "adult.example.com."); blocked {
+		t.Error("dropped blocklist still blocks")
+	}
+	if _, blocked := sinkholed.Match(nil, "beacon.example.com."); !blocked {
+		t.Error("added name not blocked")
+	}
+	if _, ok := dns.FindZone(s.handler.Zones, "a.test."); ok {
+		t.Error("removed zone still served")
+	}
+
+	// a broken config changes nothing
+	write("config.yml", "listen: 127.0.0.1:53154\nzones: "+zonesDir+"\nblocklists: ["+filepath.Join(dir, "missing.txt")+"]\n")
+	if _, err := s.Reload(); err == nil || !strings.Contains(err.Error(), "missing.txt") {
+		t.Errorf("Reload() = %v, want the missing blocklist", err)
+	}
+	if _, blocked := sinkholed.Match(nil, "beacon.example.com."); !blocked || len(s.handler.Zones) != 2 {
+		t.Error("failed reload applied changes")
+	}
+}
+
+func TestReloadZoneSerials(t *testing.T) {
+	// the secondary acknowledges NOTIFYs and reports their serial
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	notified := make(chan uint32, 4)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			msg := dns.Message{}
+			if _, err := msg.Decode(buf[:n]); err != nil || msg.Header.Opcode != dns.OpcodeNotify || len(msg.Answers) != 1 {
+				continue
+			}
+			soa := msg.Answers[0].RData
+			notified <- binary.BigEndian.Uint32(soa[len(soa)-20:])
+			conn.WriteToUDP(dns.NewResponse(&msg).Message().Encode(), addr)
+		}
+	}()
+
+	dir := t.TempDir()
+	zonesDir := filepath.Join(dir, "zones")
+	os.Mkdir(zonesDir, 0o755)
+	write := func(serial int, ip string) {
+		t.Helper()
+		zone := fmt.Sprintf("origin: a.test.\nsoa:\n  mname: ns1\n  rname: admin\n  serial: %d\nnotify:\n  - address: %s\na:\n  - name: \"@\"\n    value: %s\n", serial, conn.LocalAddr(), ip)
+		if err := os.WriteFile(filepath.Join(zonesDir, "a.test.yml"), []byte(zone), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(7, "10.0.0.1")
+	configFile := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(configFile, []byte("zones: "+zonesDir+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prevFile, prevZone, prevSinkhole := ConfigFile, Zone, Sinkhole
+	t.Cleanup(func() { ConfigFile, Zone, Sinkhole = prevFile, prevZone, prevSinkhole })
+	ConfigFile, Zone, Sinkhole = configFile, true, false
+	cfg, err := config.Load(ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := readZones(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{cfg: cfg, handler: &dns.Handler{Zones: loaded}}
+
+	tests := []struct {
+		name    string
+		serial  int
+		ip      string
+		changed bool
+		want    uint32
+	}{
+		{"edited without a new serial", 7, "10.0.0.2", true, 8},
+		{"unchanged", 7, "10.0.0.2", false, 8},
+		{"serial raised in the file", 20, "10.0.0.3", true, 20},
+	}
+	for _, tt := range tests {
+		write(tt.serial, tt.ip)
+		summary, err := s.Reload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed := len(summary.Zones.Changed) == 1; changed != tt.changed {
+			t.Errorf("%s: zones %+v, want changed %v", tt.name, summary.Zones, tt.changed)
+		}
+		if zone := s.handler.Zones["a.test."]; !serialIs(&zone, tt.want) {
+			t.Errorf("%s: serial %v, want %d", tt.name, zone.SOA["serial"], tt.want)
+		}
+		if !tt.changed {
+			if len(summary.ZoneDiffs) != 0 {
+				t.Errorf("%s: zone diffs %+v, want none", tt.name, summary.ZoneDiffs)
+			}
+			continue
+		}
+		if d := summary.ZoneDiffs; len(d) != 1 || !slices.ContainsFunc(d[0].Changed, func(c dns.RecordChange) bool {
+			return c.New.Type == "A" && c.New.Data == tt.ip
+		}) {
+			t.Errorf("%s: zone diffs %+v, want the A record changed to %s", tt.name, d, tt.ip)
+		}
+		select {
+		case serial := <-notified:
+			if serial != tt.want {
+				t.Errorf("%s: notified serial %d, want %d", tt.name, serial, tt.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: secondary not notified", tt.name)
+		}
+	}
+}
+
+func serialIs(zone *dns.Zone, want uint32) bool {
+	serial, ok := zone.Serial()
+	return ok && serial == want
+}
+
+func TestSwitchZones(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour).Format(time.RFC3339)
+	zone := dns.Zone{
+		Origin: "a.test.",
+		SOA:    map[string]interface{}{"mname": "ns1", "rname": "admin", "serial": 7},
+		A: []dns.Record{
+			{Name: "www", Value: "10.0.0.1", RecordMeta: dns.RecordMeta{Expires: later}},
+			{Name: "www", Value: "10.0.1.1", RecordMeta: dns.RecordMeta{Activates: later}},
+			{Name: "mail", Value: "10.0.0.25"},
+		},
+	}
+	// indexed before the cutover, the old address is still answered
+	zone.Index()
+	cutover := now.Add(-30 * time.Second).Format(time.RFC3339)
+	zone.A[0].Expires, zone.A[1].Activates = cutover, cutover
+	s := &Server{handler: &dns.Handler{Zones: map[string]dns.Zone{"a.test.": zone, "b.test.": {Origin: "b.test."}}}}
+	www := func() string {
+		z := s.handler.CurrentZones()["a.test."]
+		answers := z.Lookup("www.a.test.", dns.TypeA, 1)
+		if len(answers) != 1 {
+			return fmt.Sprintf("%d records", len(answers))
+		}
+		return answers[0].Data(nil)
+	}
+	if got := www(); got != "10.0.0.1" {
+		t.Fatalf("www before the cutover = %s, want 10.0.0.1", got)
+	}
+
+	if switched := s.switchZones(now.Add(-2*time.Minute), now.Add(-time.Minute)); len(switched) != 0 {
+		t.Errorf("switchZones() before the cutover = %v", switched)
+	}
+	if switched := s.switchZones(now.Add(-time.Minute), now); !slices.Equal(switched, []string{"a.test."}) {
+		t.Errorf("switchZones() = %v, want a.test.", switched)
+	}
+	if got := www(); got != "10.0.1.1" {
+		t.Errorf("www after the cutover = %s, want 10.0.1.1", got)
+	}
+	z := s.handler.CurrentZones()["a.test."]
+	if len(z.Lookup("mail.a.test.", dns.TypeA, 1)) != 1 {
+		t.Error("the record without a schedule is no longer answered")
+	}
+	if !serialIs(&z, 8) {
+		t.Errorf("serial %v, want 8", z.SOA["serial"])
+	}
+}
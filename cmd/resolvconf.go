@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"net"
+	"strings"
+
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/dns"
+)
+
+var (
+	// NoSystemResolvers starts recursion at the root servers when no
+	// upstreams are configured, instead of the resolv.conf name servers
+	NoSystemResolvers bool
+	// resolvConfPath is the file the system resolvers are read from
+	resolvConfPath = dns.ResolvConfPath
+)
+
+// upstreams returns the upstreams of cfg, or the name servers of
+// resolv.conf when it sets none. Name servers the server itself listens on
+// are left out, forwarding to them would loop. Without resolv.conf or a
+// name server left, recursion starts at the root servers, as it always
+// does with a copy of the root zone.
+func upstreams(cfg *config.Config) []dns.Upstream {
+	if len(cfg.Upstreams) > 0 || NoSystemResolvers || cfg.RootZone.Mirror {
+		return cfg.Upstreams
+	}
+	conf, err := dns.ReadResolvConf(resolvConfPath)
+	if err != nil {
+		serverLog.Debug("system resolvers not read", "file", resolvConfPath, "err", err)
+		return nil
+	}
+	var system []dns.Upstream
+	for _, upstream := range conf.Upstreams() {
+		if listensOn(cfg, upstream.Address) {
+			serverLog.Debug("skipped system resolver listened on by the server", "upstream", upstream.Address)
+			continue
+		}
+		system = append(system, upstream)
+	}
+	if len(system) > 0 {
+		serverLog.Info("using system resolvers", "file", resolvConfPath, "upstreams", len(system), "search", strings.Join(conf.Search, " "))
+	}
+	return system
+}
+
+// listensOn reports whether a udp listener of cfg answers at address,
+// counting loopback addresses for listeners on every address
+func listensOn(cfg *config.Config, address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, l := range cfg.Listening() {
+		lhost, lport, err := net.SplitHostPort(l.Address)
+		if l.Protocol != config.UDP || err != nil || lport != port {
+			continue
+		}
+		lip := net.ParseIP(lhost)
+		if lhost == "" {
+			lip = net.IPv4zero
+		}
+		if (lip != nil && lip.IsUnspecified() && ip.IsLoopback()) || lip.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/dns"
+)
+
+func TestUpstreams(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(file, []byte("nameserver 127.0.0.1\nnameserver 127.0.0.53\nnameserver 192.168.1.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	resolvConfPath = file
+	t.Cleanup(func() { resolvConfPath = dns.ResolvConfPath })
+	addresses := func(upstreams []dns.Upstream) []string {
+		var got []string
+		for _, u := range upstreams {
+			got = append(got, u.Address)
+		}
+		return got
+	}
+
+	// the server answers on the system resolvers it listens on itself
+	for listen, want := range map[string][]string{
+		"127.0.0.1:53":    {"127.0.0.53:53", "192.168.1.1:53"},
+		"0.0.0.0:53":      {"192.168.1.1:53"},
+		"127.0.0.1:53153": {"127.0.0.1:53", "127.0.0.53:53", "192.168.1.1:53"},
+	} {
+		if got := addresses(upstreams(&config.Config{Listen: listen})); !slices.Equal(got, want) {
+			t.Errorf("upstreams listening on %s = %v, want %v", listen, got, want)
+		}
+	}
+
+	configured := &config.Config{Listen: "0.0.0.0:53", Upstreams: []dns.Upstream{{Address: "9.9.9.9:53"}}}
+	if got := addresses(upstreams(configured)); !slices.Equal(got, []string{"9.9.9.9:53"}) {
+		t.Errorf("configured upstreams replaced by %v", got)
+	}
+
+	NoSystemResolvers = true
+	t.Cleanup(func() { NoSystemResolvers = false })
+	if got := upstreams(&config.Config{Listen: "0.0.0.0:53"}); got != nil {
+		t.Errorf("--no-system-resolvers still uses %v", addresses(got))
+	}
+}
@@ -6,10 +6,19 @@ package cmd
 import (
 	"os"
 
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/logging"
 	"github.com/spf13/cobra"
 )
 
-var Verbose bool
+var (
+	Verbose    bool
+	ConfigFile string
+	LogLevel   string
+	LogFormat  string
+	LogFile    string
+	LogTarget  string
+)
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -41,15 +50,41 @@ func Execute() {
 	}
 }
 
+// setupLogging applies the log settings of cfg, overridden by flags
+func setupLogging(cfg *config.Config) error {
+	opts := cfg.Log
+	if LogLevel != "" {
+		opts.Level = LogLevel
+	}
+	if Verbose {
+		opts.Level = "debug"
+	}
+	if LogFormat != "" {
+		opts.Format = LogFormat
+	}
+	if LogFile != "" {
+		opts.File = LogFile
+	}
+	if LogTarget != "" {
+		opts.Target = LogTarget
+	}
+	opts.Instance = cfg.Identity.InstanceName()
+	opts.Privacy = cfg.Privacy
+	return logging.Setup(opts)
+}
+
 func init() {
 	verbose := os.Getenv("VERBOSE") != ""
-	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", verbose, "verbose output")
+	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", verbose, "verbose output, same as --log-level debug")
+	rootCmd.PersistentFlags().StringVar(&LogLevel, "log-level", os.Getenv("LOG_LEVEL"), "log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&LogFormat, "log-format", os.Getenv("LOG_FORMAT"), "log format: text or json")
+	rootCmd.PersistentFlags().StringVar(&LogFile, "log-file", os.Getenv("LOG_FILE"), "append logs to this file instead of stderr")
+	rootCmd.PersistentFlags().StringVar(&LogTarget, "log-target", os.Getenv("LOG_TARGET"), "log target: stderr, file, syslog or journald")
+	rootCmd.PersistentFlags().StringVarP(&ConfigFile, "config", "c", os.Getenv("CONFIG"), "config file (default is "+config.DefaultPath+")")
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.mercury.yaml)")
-
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
@@ -4,52 +4,61 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
-	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/bernoussama/mercury/acme"
+	"github.com/bernoussama/mercury/api"
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/blockpage"
+	"github.com/bernoussama/mercury/budget"
+	"github.com/bernoussama/mercury/clients"
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/detect"
 	"github.com/bernoussama/mercury/dns"
+	"github.com/bernoussama/mercury/logging"
+	"github.com/bernoussama/mercury/privacy"
+	"github.com/bernoussama/mercury/querylog"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v2"
 )
 
-// Logln is a wrapper around log.Println that only prints if Verbose is true
-func Logln(a ...any) {
-	if Verbose {
-		log.Println(a...)
-	}
-}
+var (
+	serverLog    = logging.For(logging.Server)
+	blocklistLog = logging.For(logging.Blocklist)
+)
 
-// Logf is a wrapper around log.Printf that only prints if Verbose is true
-func Logf(format string, a ...any) {
-	if Verbose {
-		log.Printf(format, a...)
-	}
-}
+// dns sinkhole, holding the blocklist and threat feeds on serve
+var sinkholed = blocklist.NewSinkhole()
 
-// Printf is a wrapper around fmt.Printf that only prints if Verbose is true
-func Printf(format string, a ...any) (n int, err error) {
-	if Verbose {
-		return fmt.Printf(format, a...)
-	}
-	return 0, nil
+// responsePool holds response buffers reused across queries
+var responsePool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, dns.BUFFER_SIZE)
+		return &buf
+	},
 }
 
-// Println is a wrapper around fmt.Println that only prints if Verbose is true
-func Println(a ...any) (n int, err error) {
-	if Verbose {
-		return fmt.Println(a...)
-	}
-	return 0, nil
+// packetPool holds receive buffers, each owned by one query until handled
+var packetPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, dns.BUFFER_SIZE)
+		return &buf
+	},
 }
 
-// DNS header size
-const BUFFER_SIZE = 2048
-
-// dns sinkhole
-var blocklist = make(map[string]bool)
+// messagePool holds messages reused to decode queries
+var messagePool = sync.Pool{
+	New: func() any {
+		return new(dns.Message)
+	},
+}
 
 var (
 	zones    = make(map[string]dns.Zone)
@@ -62,70 +71,303 @@ func check(e error) {
 	}
 }
 
-func loadZones() {
-	files, err := filepath.Glob("/opt/mercury/zones/*.yml")
+func loadZones(cfg *config.Config) {
+	loaded, err := readZones(cfg)
 	check(err)
-	for _, file := range files {
-		data, err := os.ReadFile(file)
-		check(err)
-		zone := dns.Zone{}
-		yaml.Unmarshal(data, &zone)
-		name := zone.Origin
+	for name, zone := range loaded {
 		zones[name] = zone
 	}
-	Printf("%+v\n", zones)
+	serverLog.Debug("loaded zones", "count", len(zones))
 }
 
-type Server struct {
-	address string
+func readZones(cfg *config.Config) (map[string]dns.Zone, error) {
+	values, err := config.LoadValues(cfg.ZoneValues)
+	if err != nil {
+		return nil, err
+	}
+	return config.LoadZones(cfg.Zones, values)
 }
 
-func NewServer(address string) *Server {
-	return &Server{
-		address: address,
+// loadBlocklist maps the compiled blocklist, if any, reads domains to
+// sinkhole from plain lists or hosts files and subscribes to threat feeds
+func loadBlocklist(cfg *config.Config) {
+	lists, err := readBlocklists(cfg)
+	check(err)
+	for _, l := range lists {
+		sinkholed.SetList(l.List)
+		blocklistLog.Info("loaded blocklist", "category", l.Category, "domains", l.Store.Len(), "files", l.files, "store", l.storeType)
+	}
+	sinkholed.SetGroups(cfg.ClientGroups)
+	for _, feed := range cfg.ThreatFeeds {
+		subscribe(feed)
 	}
 }
 
-func (s *Server) Run() {
-	buffer := make([]byte, BUFFER_SIZE)
-	udpAddr, err := net.ResolveUDPAddr("udp", s.address)
-	if err != nil {
-		log.Fatal(err)
+// categoryList is the list of a blocklist category with the names read
+// from its files
+type categoryList struct {
+	blocklist.List
+	names     []string
+	files     int
+	storeType string
+}
+
+// blocklistCategories returns the categories of the blocklist files, and
+// of the compiled list
+func blocklistCategories(cfg *config.Config) ([]string, map[string][]string) {
+	categories, files := blocklist.Categorize(cfg.Blocklists)
+	if cfg.BlocklistCompiled != "" && len(files[blocklist.CategoryBlocklist]) == 0 {
+		categories = append([]string{blocklist.CategoryBlocklist}, categories...)
 	}
-	conn, err := net.ListenUDP("udp", udpAddr)
-	if err != nil {
-		log.Fatal(err)
+	return categories, files
+}
+
+// readBlocklists reads one list per category, named after it
+func readBlocklists(cfg *config.Config) ([]categoryList, error) {
+	configured := cfg.BlocklistStore.Type
+	if configured == "" {
+		configured = blocklist.TypeHash
 	}
-	log.Println("DNS Server running on ", s.address)
-	defer conn.Close()
-	for {
-		n, remoteAddr, err := conn.ReadFromUDP(buffer)
+	categories, files := blocklistCategories(cfg)
+	rules, _ := blocklist.CategoryRules(cfg.Blocklists)
+	var lists []categoryList
+	for _, category := range categories {
+		names, err := blocklist.ReadFiles(files[category])
 		if err != nil {
-			log.Fatal(err)
+			closeLists(lists)
+			return nil, err
+		}
+		store, storeType := blocklist.New(cfg.BlocklistStore), configured
+		if category == blocklist.CategoryBlocklist && cfg.BlocklistCompiled != "" {
+			// a compiled list is always a hash set
+			compiled, err := blocklist.Open(cfg.BlocklistCompiled)
+			if err != nil {
+				closeLists(lists)
+				return nil, err
+			}
+			blocklistLog.Info("mapped compiled blocklist", "domains", compiled.Len(), "file", cfg.BlocklistCompiled)
+			for _, name := range names {
+				compiled.Add(name)
+			}
+			store, storeType = compiled, blocklist.TypeHash
+		} else {
+			store.Reload(names)
 		}
-		Logln("Received", n, "bytes")
-		Logln("from: ", remoteAddr)
-		go s.handle(conn, remoteAddr, buffer[:n])
+		lists = append(lists, categoryList{
+			List:      blocklist.List{Name: category, Category: category, Store: store, Rule: rules[category]},
+			names:     names,
+			files:     len(files[category]),
+			storeType: storeType,
+		})
 	}
+	return lists, nil
 }
 
-func (s *Server) handle(conn *net.UDPConn, remoteAddr *net.UDPAddr, data []byte) {
-	// log.Println(data)
-	msg := dns.Message{}
+// closeLists unmaps the compiled list among lists no longer served
+func closeLists(lists []categoryList) {
+	for _, l := range lists {
+		closeStore(l.Store)
+	}
+}
+
+func closeStore(store blocklist.Store) {
+	if h, ok := store.(*blocklist.Hash); ok {
+		h.Close()
+	}
+}
+
+var (
+	feedClient = &http.Client{Timeout: time.Minute}
+	// feedCancels stop the subscription of each threat feed by list name
+	feedCancels = make(map[string]context.CancelFunc)
+)
+
+// subscribe refreshes the threat feed until unsubscribed
+func subscribe(feed blocklist.FeedOptions) {
+	ctx, cancel := context.WithCancel(context.Background())
+	feedCancels[feed.ListName()] = cancel
+	sinkholed.Subscribe(ctx, feedClient, feed)
+}
+
+// loadClients reads the DHCP lease files naming clients and watches them
+// for changes, registering the named clients as hosts of the local domain
+// when one is configured
+func loadClients(cfg *config.Config) (*clients.Directory, *hostTable) {
+	names := clients.New(cfg.Clients)
+	var hosts *hostTable
+	if cfg.Clients.Domain != "" {
+		hosts = newHostTable(cfg.Clients.Domain)
+		names.OnChange(func() {
+			hosts.setLocal(names.Hosts())
+			serverLog.Debug("registered local hosts", "domain", hosts.hosts.Domain(), "hosts", hosts.hosts.Len())
+		})
+	}
+	if err := names.Load(); err != nil {
+		serverLog.Warn("lease files not read", "err", err)
+	}
+	if hosts != nil {
+		hosts.setLocal(names.Hosts())
+		serverLog.Info("registered local hosts", "domain", hosts.hosts.Domain(), "hosts", hosts.hosts.Len())
+	}
+	names.Watch(context.Background())
+	return names, hosts
+}
+
+// anonymizeClients counts the queries of clients idle for the retention
+// under their network, checking every minute
+func anonymizeClients(names *clients.Directory, opts privacy.Options) {
+	for range time.Tick(time.Minute) {
+		names.Anonymize(opts, time.Now().Add(-opts.Retention))
+	}
+}
+
+type Server struct {
+	listeners []*listener
+	allow     []*net.IPNet
+	timeout   time.Duration
+	handler   *dns.Handler
+	clients   *clients.Directory
+	hosts     *hostTable
+	// certs serves the certificate of the acme settings, nil without them
+	certs *acme.Manager
+	// health probes the addresses of the health-checked zone records
+	health *dns.HealthChecks
+
+	// cfg is the config last applied, replaced by Reload
+	cfg      *config.Config
+	reloadMu sync.Mutex
+}
+
+func NewServer(cfg *config.Config) *Server {
+	names, hosts := loadClients(cfg)
+	s := &Server{
+		allow:   cfg.AllowedNets(),
+		timeout: cfg.Timeout,
+		clients: names,
+		hosts:   hosts,
+		cfg:     cfg,
+		handler: &dns.Handler{
+			Zones:         zones,
+			Cache:         dnsCache,
+			Blocklist:     sinkholed,
+			Clients:       names,
+			Hosts:         hosts.dnsHosts(),
+			Upstreams:     upstreams(cfg),
+			QueryBudget:   cfg.QueryBudget,
+			Outstanding:   dns.NewOutstanding(cfg.Concurrency),
+			Delegations:   dns.NewDelegations(cfg.Delegations),
+			RootZone:      dns.NewRootZone(cfg.RootZone),
+			LocalZones:    dns.NewLocalZones(cfg.LocalZones),
+			TTLOverrides:  dns.NewTTLOverrides(cfg.TTLOverrides),
+			CacheUsage:    dns.NewCacheUsage(),
+			ErrorBudget:   budget.New(cfg.ErrorBudget, cfg.Identity.InstanceName()),
+			NoRecursion:   !cfg.Recursion,
+			Unanswered:    cfg.UnansweredRcode(),
+			Identity:      cfg.Identity,
+			SinkholeAddrs: cfg.SinkholeIPs(),
+			Challenges:    dns.NewChallenges(),
+			DebugTrace:    cfg.DebugTrace,
+		},
+	}
+	s.health = dns.NewHealthChecks()
+	s.health.Watch(s.handler.Zones)
+	if cfg.ACME.Enabled() {
+		s.certs = acme.New(cfg.ACME, s.handler.Challenges)
+	}
+	gates := newGates(cfg)
+	for _, l := range cfg.Listening() {
+		ln := newListener(l)
+		ln.limits = cfg.ConnectionLimits(l.Protocol)
+		ln.gate = gates[l.Protocol]
+		ln.socket = cfg.Socket
+		s.listeners = append(s.listeners, ln)
+	}
+	return s
+}
+
+// allowed reports whether the client may query the server
+func (s *Server) allowed(ip net.IP) bool {
+	if len(s.allow) == 0 {
+		return true
+	}
+	for _, ipnet := range s.allow {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run serves every listener, exiting when one of them fails
+func (s *Server) Run() {
+	errs := make(chan error)
+	for _, l := range s.listeners {
+		go func() {
+			errs <- fmt.Errorf("listener %s: %w", l.Label(), s.serve(l))
+		}()
+	}
+	log.Fatal(<-errs)
+}
+
+// Stats returns the query counters of every listener
+func (s *Server) Stats() []api.ListenerStats {
+	stats := make([]api.ListenerStats, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		stats = append(stats, l.stats())
+	}
+	return stats
+}
+
+// answer appends the response to the query in data, received by l from
+// client, to buf. It reports false when the query is dropped, malformed or
+// by the rule of a blocklist.
+func (s *Server) answer(l *listener, client net.IP, data []byte, buf []byte) ([]byte, bool) {
+	l.queries.Add(1)
+	msg := messagePool.Get().(*dns.Message)
+	defer func() {
+		msg.Reset()
+		messagePool.Put(msg)
+	}()
 	msg.Bytes = data
 	_, err := msg.Decode(data)
 	if err != nil {
-		log.Println(err)
-		return
+		l.malformed.Add(1)
+		// a header the server cannot interpret is answered FORMERR,
+		// other malformed queries are dropped
+		if errors.Is(err, dns.ErrFormat) {
+			l.log.Warn("malformed query", "from", client, "device", s.clients.Name(client), "err", err, "header", msg.Header)
+			return dns.NewResponse(msg).SetRcode(dns.RcodeFormatError).AppendEncode(buf), true
+		}
+		l.log.Warn("malformed query", "from", client, "device", s.clients.Name(client), "err", err)
+		return buf, false
 	}
-	res := msg.BuildResponse(zones, dnsCache, blocklist)
-	conn.WriteToUDP(res, remoteAddr)
+	if !s.allowed(client) {
+		l.refused.Add(1)
+		l.log.Debug("refused query", "from", client, "device", s.clients.Name(client))
+		return dns.NewResponse(msg).SetRcode(dns.RcodeRefused).AppendEncode(buf), true
+	}
+	ctx := dns.WithClient(context.Background(), client)
+	if l.Encrypted() {
+		ctx = dns.WithEncryption(ctx)
+	}
+	if l.Protocol == config.UDP {
+		ctx = dns.WithUDP(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	res := s.handler.AppendResponse(ctx, buf, msg)
+	return res, len(res) > len(buf)
 }
 
 var (
 	Zone     bool
 	Sinkhole bool
-	Source   string
+	// SinkholeDryRun resolves the names the blocklists match, logging them
+	SinkholeDryRun bool
+	Source         string
+	// Instance overrides the instance label of the config, so replicas
+	// share one config file
+	Instance string
 )
 
 // serveCmd represents the serve command
@@ -136,22 +378,81 @@ var serveCmd = &cobra.Command{
 This server is designed to be used as as recursive resolver and a sinkhole, blocking unwanted DNS requests.`,
 
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("serve called")
-		fmt.Println(Zone)
-
-		ip := "0.0.0.0"
-		port := ":53153"
-		address := ip + port
+		cfg, err := config.Load(ConfigFile)
+		check(err)
+		if err := cfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if Instance != "" {
+			cfg.Identity.Instance = Instance
+		}
+		check(setupLogging(cfg))
+		startChaos()
+		serverLog.Debug("serve called", "zone", Zone, "sinkhole", Sinkhole)
 		if Zone {
-			loadZones()
+			loadZones(cfg)
 		}
 		if Sinkhole {
-			// loadBlocklist()
-			blocklist["google.com."] = true
+			loadBlocklist(cfg)
+		}
+		if SinkholeDryRun {
+			sinkholed.SetDryRun(true)
+			blocklistLog.Warn("blocklist dry run, queries are resolved and logged instead of blocked")
+		}
+		server := NewServer(cfg)
+		server.startPeering(cfg)
+		server.startMQTT(cfg)
+		server.startACME()
+		server.handleSignals()
+		go server.handler.Delegations.Prefetch(context.Background())
+		go server.handler.RootZone.Run(context.Background())
+		go server.handler.ErrorBudget.Run(context.Background())
+		if Zone {
+			go server.scheduleRecords(context.Background())
+		}
+		if cfg.Privacy.TruncatesAddresses() {
+			go anonymizeClients(server.clients, cfg.Privacy)
+		}
+		if cfg.Detection.Enabled {
+			server.handler.Detector = detect.New(cfg.Detection)
+		}
+		if cfg.QueryLog.File != "" {
+			server.handler.QueryLog, err = querylog.Open(cfg.QueryLog, cfg.Privacy)
+			check(err)
+			serverLog.Info("logging queries", "file", cfg.QueryLog.File)
+		}
+		if cfg.Admin.Listen != "" {
+			server.handler.Tap = querylog.NewTap(cfg.Privacy)
+			admin := api.New(cfg.Admin, dnsCache)
+			admin.Instance = cfg.Identity.InstanceName()
+			admin.Listeners = server.Stats
+			admin.Sinkhole = sinkholed
+			admin.Clients = server.clients
+			admin.Detector = server.handler.Detector
+			admin.Reload = server.Reload
+			admin.Peers = peering.Stats
+			admin.Zones = server.handler.CurrentZones
+			admin.CacheUsage = server.handler.CacheUsageStats
+			admin.Challenges = server.handler.Challenges
+			if server.certs != nil {
+				admin.GetCertificate = server.certs.GetCertificate
+			}
+			admin.Tap = server.handler.Tap
+			go func() {
+				if err := admin.ListenAndServe(); err != nil {
+					serverLog.Error("admin API stopped", "err", err)
+				}
+			}()
+		}
+		if Sinkhole && cfg.BlockPage.Listen != "" {
+			page := blockpage.New(cfg.BlockPage, cfg.Admin, sinkholed)
+			go func() {
+				if err := page.ListenAndServe(); err != nil {
+					serverLog.Error("block page stopped", "err", err)
+				}
+			}()
 		}
-		server := NewServer(
-			address,
-		)
 		server.Run()
 	},
 }
@@ -161,6 +462,9 @@ func init() {
 	sinkhole := os.Getenv("SINKHOLE") != ""
 	rootCmd.PersistentFlags().BoolVarP(&Zone, "zone", "z", zone, "authoritative zone")
 	rootCmd.PersistentFlags().BoolVarP(&Sinkhole, "sinkhole", "s", sinkhole, "dns sinkhole")
+	serveCmd.Flags().BoolVar(&SinkholeDryRun, "sinkhole-dry-run", os.Getenv("SINKHOLE_DRY_RUN") != "", "log the queries the blocklists match instead of blocking them")
+	serveCmd.Flags().BoolVar(&NoSystemResolvers, "no-system-resolvers", os.Getenv("NO_SYSTEM_RESOLVERS") != "", "start recursion at the root servers when no upstreams are configured, instead of the resolv.conf name servers")
+	serveCmd.Flags().StringVar(&Instance, "instance", os.Getenv("INSTANCE"), "instance label of logs and stats (default from the config)")
 
 	rootCmd.AddCommand(serveCmd)
 
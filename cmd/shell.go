@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/client"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/spf13/cobra"
+)
+
+const shellHelp = `<name> [type]          query name, type defaults to A
+server <addr>          switch server (host:port, or URL for doh)
+protocol <p>           udp, tcp, dot or doh
+timeout <duration>     per attempt timeout
+retries <n>            attempts after the first one fails
+set <flag> on|off      toggle rd, cd, edns, dnssec or raw
+bufsize <n>            EDNS UDP payload size
+show                   print current settings
+help                   print this help
+quit                   leave the shell`
+
+// shellCmd runs an interactive query prompt
+var shellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "interactive query prompt",
+	Long: `Shell opens a prompt to send successive queries, switch servers and toggle
+query flags without restarting. Type help at the prompt for the commands.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		sh := &shell{c: newQueryClient(), out: os.Stdout}
+		sh.run(os.Stdin)
+	},
+}
+
+// shell holds the settings of an interactive session
+type shell struct {
+	c   *client.Client
+	out io.Writer
+	// raw dumps the query and reply bytes
+	raw bool
+}
+
+func (sh *shell) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(sh.out, "mercury> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(sh.out)
+			return
+		}
+		if !sh.exec(strings.Fields(scanner.Text())) {
+			return
+		}
+	}
+}
+
+// exec runs one command line and reports whether the shell should go on
+func (sh *shell) exec(fields []string) bool {
+	if len(fields) == 0 {
+		return true
+	}
+	arg := func(i int) string {
+		if i < len(fields) {
+			return fields[i]
+		}
+		return ""
+	}
+
+	var err error
+	switch strings.ToLower(fields[0]) {
+	case "quit", "exit":
+		return false
+	case "help", "?":
+		fmt.Fprintln(sh.out, shellHelp)
+	case "show":
+		sh.show()
+	case "server":
+		if arg(1) == "" {
+			err = fmt.Errorf("usage: server <addr>")
+			break
+		}
+		// New fills in the default port
+		sh.c.Server = client.New(arg(1)).Server
+	case "protocol":
+		switch p := client.Protocol(strings.ToLower(arg(1))); p {
+		case client.UDP, client.TCP, client.DoT, client.DoH:
+			sh.c.Protocol = p
+		default:
+			err = fmt.Errorf("unknown protocol %q", arg(1))
+		}
+	case "timeout":
+		sh.c.Timeout, err = time.ParseDuration(arg(1))
+	case "retries":
+		sh.c.Retries, err = strconv.Atoi(arg(1))
+	case "bufsize":
+		var size uint64
+		size, err = strconv.ParseUint(arg(1), 10, 16)
+		sh.c.UDPSize = uint16(size)
+	case "set":
+		err = sh.set(arg(1), arg(2))
+	default:
+		err = sh.query(fields[0], arg(1))
+	}
+	if err != nil {
+		fmt.Fprintln(sh.out, "error:", err)
+	}
+	return true
+}
+
+func (sh *shell) set(name, value string) error {
+	var on bool
+	switch strings.ToLower(value) {
+	case "on", "true", "1":
+		on = true
+	case "off", "false", "0":
+	default:
+		return fmt.Errorf("usage: set %s on|off", name)
+	}
+	switch strings.ToLower(name) {
+	case "rd":
+		sh.c.RecursionDesired = on
+	case "cd":
+		sh.c.CheckingDisabled = on
+	case "edns":
+		sh.c.EDNS = on
+	case "dnssec", "do":
+		sh.c.DNSSEC = on
+	case "raw":
+		sh.raw = on
+	default:
+		return fmt.Errorf("unknown flag %q", name)
+	}
+	return nil
+}
+
+func (sh *shell) show() {
+	onOff := map[bool]string{true: "on", false: "off"}
+	fmt.Fprintf(sh.out, "server %s (%s), timeout %s, retries %d\n", sh.c.Server, sh.c.Protocol, sh.c.Timeout, sh.c.Retries)
+	fmt.Fprintf(sh.out, "rd %s, cd %s, edns %s, dnssec %s, raw %s\n",
+		onOff[sh.c.RecursionDesired], onOff[sh.c.CheckingDisabled], onOff[sh.c.EDNS], onOff[sh.c.DNSSEC], onOff[sh.raw])
+}
+
+func (sh *shell) query(name, qtypeArg string) error {
+	qtype := dns.TypeA
+	if qtypeArg != "" {
+		t, err := dns.ParseQType(qtypeArg)
+		if err != nil {
+			return err
+		}
+		qtype = t
+	}
+
+	query := sh.c.NewQuery(name, qtype).Encode()
+	start := time.Now()
+	raw, err := sh.c.Exchange(context.Background(), query)
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	res, err := client.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	if sh.raw {
+		fmt.Fprintf(sh.out, ";; query, %d bytes:\n%s", len(query), hex.Dump(query))
+		fmt.Fprintf(sh.out, ";; reply, %d bytes:\n%s", len(raw), hex.Dump(raw))
+	}
+	fmt.Fprintln(sh.out, res.Header)
+	printSection(sh.out, "ANSWER", res.Answers)
+	printSection(sh.out, "AUTHORITY", res.Authority)
+	printSection(sh.out, "ADDITIONAL", res.Additional)
+	fmt.Fprintf(sh.out, ";; query time: %s\n", elapsed.Round(time.Microsecond))
+	return nil
+}
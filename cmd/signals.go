@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"cmp"
+	"runtime"
+	"slices"
+
+	"github.com/bernoussama/mercury/cache"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/bernoussama/mercury/logging"
+)
+
+// topNames is how many of the most hit cached names a stats dump lists
+const topNames = 10
+
+// toggleVerbose switches every module between debug and its configured
+// level
+func toggleVerbose() {
+	if logging.ToggleVerbose() {
+		serverLog.Warn("verbose logging on, signal again to turn it off")
+	} else {
+		serverLog.Warn("verbose logging off")
+	}
+}
+
+// dumpStats logs the state of the server: the cache, the goroutines and
+// memory, the resolutions in flight, the error budget, the health checks,
+// the queries of each listener, the blocklist and the most hit cached
+// names
+func (s *Server) dumpStats() {
+	stats := dnsCache.Stats()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	serverLog.Info("stats",
+		"cache_entries", stats.Entries, "cache_hits", stats.Hits, "cache_misses", stats.Misses,
+		"goroutines", runtime.NumGoroutine(), "heap_bytes", mem.HeapAlloc,
+		"blocked_names", sinkholed.Len())
+	if s.handler != nil && s.handler.Outstanding != nil {
+		o := s.handler.Outstanding.Stats()
+		serverLog.Info("outstanding queries", "in_flight", o.InFlight, "clients", o.Clients, "rejected", o.Rejected)
+	}
+	if s.handler != nil && s.handler.Delegations != nil {
+		d := s.handler.Delegations.Stats()
+		serverLog.Info("delegation cache", "zones", d.Zones, "hits", d.Hits, "prefetched", d.Prefetched, "prefetch_failed", d.PrefetchFailed)
+	}
+	if s.handler != nil && s.handler.RootZone != nil {
+		r := s.handler.RootZone.Stats()
+		serverLog.Info("root zone copy", "serial", r.Serial, "tlds", r.TLDs, "expiry", r.Expiry, "nxdomain", r.NXDomain, "referrals", r.Referrals)
+	}
+	if s.handler != nil && s.handler.ErrorBudget != nil {
+		b := s.handler.ErrorBudget.Stats()
+		serverLog.Info("error budget", "queries", b.Queries, "servfail", b.ServFail, "timeouts", b.Timeouts, "rate", b.Rate, "firing", b.Firing)
+	}
+	for _, h := range s.health.Stats() {
+		serverLog.Info("health check", "target", h.Target, "healthy", h.Healthy, "since", h.Since, "err", h.Error)
+	}
+	for _, l := range s.Stats() {
+		serverLog.Info("listener stats", "listener", l.Name, "protocol", l.Protocol, "address", l.Address,
+			"queries", l.Queries, "refused", l.Refused, "malformed", l.Malformed)
+	}
+
+	var top []cache.Entry[dns.Message]
+	dnsCache.Range(func(e cache.Entry[dns.Message]) bool {
+		if e.Hits > 0 {
+			top = append(top, e)
+		}
+		return true
+	})
+	slices.SortFunc(top, func(a, b cache.Entry[dns.Message]) int { return cmp.Compare(b.Hits, a.Hits) })
+	for i, e := range top[:min(len(top), topNames)] {
+		serverLog.Info("top cached name", "rank", i+1, "name", dns.KeyName(e.Key),
+			"type", e.Value.Question.QType.String(), "hits", e.Hits)
+	}
+}
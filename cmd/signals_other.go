@@ -0,0 +1,6 @@
+//go:build !unix
+
+package cmd
+
+// handleSignals does nothing without SIGUSR1 and SIGUSR2
+func (s *Server) handleSignals() {}
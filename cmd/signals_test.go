@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bernoussama/mercury/dns"
+	"github.com/bernoussama/mercury/logging"
+)
+
+func TestDumpStats(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mercury.log")
+	if err := logging.Setup(logging.Options{File: file}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logging.Setup(logging.Options{}) })
+
+	q := dns.Question{DomainName: "popular.example.com.", QType: dns.TypeA, QClass: 1}
+	key := dns.CacheKey(q, false)
+	dnsCache.Set(key, dns.Message{Question: q}, 60)
+	t.Cleanup(func() { dnsCache.Delete(key) })
+	dnsCache.Get(key)
+	dnsCache.Get(key)
+
+	s := &Server{}
+	s.dumpStats()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"msg=stats", "goroutines=", `msg="top cached name" module=server rank=1 name=popular.example.com. type=A hits=2`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("stats dump lacks %q:\n%s", want, data)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals toggles verbose logging on SIGUSR1 and logs the stats of
+// the server on SIGUSR2
+func (s *Server) handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGUSR1 {
+				toggleVerbose()
+			} else {
+				s.dumpStats()
+			}
+		}
+	}()
+}
@@ -0,0 +1,78 @@
+//go:build linux
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// ipv6DontFrag is IPV6_DONTFRAG, which the syscall package leaves out
+const ipv6DontFrag = 62
+
+// control applies the socket options of l to a socket before it binds
+func (l *listener) control(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = l.setSocketOptions(int(fd), network)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// setSocketOptions sets the buffers, TOS and fragmentation of fd, a
+// socket of network like udp4 or tcp6
+func (l *listener) setSocketOptions(fd int, network string) error {
+	o := l.socket
+	ipv6 := strings.HasSuffix(network, "6")
+	buffers := []struct {
+		name       string
+		size       int
+		force, opt int
+		sysctl     string
+	}{
+		{"receive_buffer", o.ReceiveBuffer, syscall.SO_RCVBUFFORCE, syscall.SO_RCVBUF, "net.core.rmem_max"},
+		{"send_buffer", o.SendBuffer, syscall.SO_SNDBUFFORCE, syscall.SO_SNDBUF, "net.core.wmem_max"},
+	}
+	for _, b := range buffers {
+		if b.size == 0 {
+			continue
+		}
+		// forcing past the sysctl cap needs CAP_NET_ADMIN
+		if syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, b.force, b.size) != nil {
+			if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, b.opt, b.size); err != nil {
+				return fmt.Errorf("%s: %w", b.name, err)
+			}
+		}
+		// Linux reports twice the size asked for, keeping half for its
+		// bookkeeping
+		if got, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, b.opt); err == nil && got/2 < b.size {
+			l.log.Warn("socket buffer capped, raise "+b.sysctl, "option", b.name, "want", b.size, "got", got/2)
+		}
+	}
+	if o.TOS > 0 {
+		if ipv6 {
+			if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, o.TOS); err != nil {
+				return fmt.Errorf("tos: %w", err)
+			}
+		}
+		// dual stack IPv6 sockets reply to IPv4 clients too, only
+		// IPv6-only sockets refuse it
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_TOS, o.TOS); err != nil && !ipv6 {
+			return fmt.Errorf("tos: %w", err)
+		}
+	}
+	if o.DontFragment && strings.HasPrefix(network, "udp") {
+		if ipv6 {
+			if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, ipv6DontFrag, 1); err != nil {
+				return fmt.Errorf("dont_fragment: %w", err)
+			}
+		}
+		if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO); err != nil && !ipv6 {
+			return fmt.Errorf("dont_fragment: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+//go:build linux
+
+package cmd
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/bernoussama/mercury/config"
+)
+
+func TestSocketOptions(t *testing.T) {
+	l := newListener(config.Listener{Protocol: config.UDP, Address: "127.0.0.1:0"})
+	l.socket = config.SocketOptions{ReceiveBuffer: 64 << 10, SendBuffer: 32 << 10, TOS: 0xb8, DontFragment: true}
+	conn, err := l.listenConfig().ListenPacket(context.Background(), "udp", l.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	raw, err := conn.(*net.UDPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(level, opt int) int {
+		t.Helper()
+		var value int
+		var gerr error
+		raw.Control(func(fd uintptr) {
+			value, gerr = syscall.GetsockoptInt(int(fd), level, opt)
+		})
+		if gerr != nil {
+			t.Fatal(gerr)
+		}
+		return value
+	}
+	// Linux reports twice the buffer sizes set
+	if got := get(syscall.SOL_SOCKET, syscall.SO_RCVBUF); got < 2*l.socket.ReceiveBuffer {
+		t.Errorf("SO_RCVBUF = %d, want %d", got, 2*l.socket.ReceiveBuffer)
+	}
+	if got := get(syscall.SOL_SOCKET, syscall.SO_SNDBUF); got < 2*l.socket.SendBuffer {
+		t.Errorf("SO_SNDBUF = %d, want %d", got, 2*l.socket.SendBuffer)
+	}
+	if got := get(syscall.IPPROTO_IP, syscall.IP_TOS); got != 0xb8 {
+		t.Errorf("IP_TOS = %#x, want 0xb8", got)
+	}
+	if got := get(syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER); got != syscall.IP_PMTUDISC_DO {
+		t.Errorf("IP_MTU_DISCOVER = %d, want IP_PMTUDISC_DO", got)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package cmd
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// control leaves the socket as the OS sets it, the socket options are
+// only applied on Linux
+func (l *listener) control(network, address string, c syscall.RawConn) error {
+	l.log.Warn("socket options ignored", "os", runtime.GOOS)
+	return nil
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/api"
+	"github.com/bernoussama/mercury/querylog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	TailClient      string
+	TailSuffix      string
+	TailBlockedOnly bool
+	TailColor       string
+)
+
+// tailCmd prints the queries of the running server as they are answered
+var tailCmd = &cobra.Command{
+	Use:   "tail",
+	Short: "print the queries of the running server live",
+	Long: `Tail streams the queries the running server answers from its admin API and
+prints a line per query: the time, client, type and name, and the verdict,
+red when blocked, yellow when it failed, green when resolved and blue when
+answered locally. It runs until interrupted. Colors are on in a terminal
+unless NO_COLOR is set, --color always or never overrides that.
+
+Example usage:
+$ mercury tail
+$ mercury tail --client 192.168.1.20 --blocked-only
+$ mercury tail --suffix example.com --admin https://dns.example.com:53180 --admin-token s3cret
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		color, err := tailColors(TailColor)
+		check(err)
+		body, err := openTap(url.Values{"client": {TailClient}, "suffix": {TailSuffix}})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer body.Close()
+		dec := json.NewDecoder(body)
+		for {
+			var e querylog.Entry
+			if err := dec.Decode(&e); err != nil {
+				if err != io.EOF {
+					fmt.Fprintln(os.Stderr, err)
+				}
+				fmt.Fprintln(os.Stderr, "the server closed the stream")
+				os.Exit(1)
+			}
+			if TailBlockedOnly && e.Source != "blocklist" {
+				continue
+			}
+			fmt.Println(tailLine(e, color))
+		}
+	},
+}
+
+// tailColors reports whether to color the output for the --color flag
+func tailColors(flag string) (bool, error) {
+	switch flag {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "", nil
+	}
+	return false, fmt.Errorf("invalid --color %q, want auto, always or never", flag)
+}
+
+// openTap opens the query stream of the admin API, filtered by params
+func openTap(params url.Values) (io.ReadCloser, error) {
+	u, err := adminURL()
+	if err != nil {
+		return nil, err
+	}
+	u = u.JoinPath("/api/tap")
+	u.RawQuery = params.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+AdminToken)
+	}
+	httpClient, err := adminClient()
+	if err != nil {
+		return nil, err
+	}
+	// the stream lasts until interrupted
+	httpClient.Timeout = 0
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w (is the server running?)", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var apiErr api.Error
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+		}
+		return nil, fmt.Errorf("admin API replied %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// ANSI colors of the verdicts
+const (
+	ansiRed    = "\033[31m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+	ansiBlue   = "\033[34m"
+	ansiDim    = "\033[2m"
+	ansiReset  = "\033[0m"
+)
+
+// verdict returns what happened to a query and its color
+func verdict(e querylog.Entry) (string, string) {
+	switch {
+	case e.Source == "blocklist":
+		if e.Category != "" {
+			return "blocked " + e.Category, ansiRed
+		}
+		return "blocked", ansiRed
+	case e.Source == "detect":
+		return "refused, flagged", ansiRed
+	case e.Rcode != "NOERROR":
+		return strings.ToLower(e.Rcode), ansiYellow
+	case e.Source == "cache":
+		return "cached", ansiGreen
+	case e.Source == "upstream":
+		return "forwarded", ansiGreen
+	}
+	return e.Source, ansiBlue
+}
+
+// tailLine formats a query: time, client, type, name, verdict and
+// duration
+func tailLine(e querylog.Entry, color bool) string {
+	client := e.Client
+	if e.Device != "" {
+		client += " (" + e.Device + ")"
+	}
+	text, c := verdict(e)
+	if e.Answers > 0 && !strings.HasPrefix(text, "blocked") {
+		text += fmt.Sprintf(", %d answer", e.Answers)
+		if e.Answers > 1 {
+			text += "s"
+		}
+	}
+	took := fmt.Sprintf("%.1fms", float64(e.Duration)/float64(time.Millisecond))
+	if !color {
+		return fmt.Sprintf("%s  %-28s %-6s %s  %s  %s", e.Time.Local().Format("15:04:05.000"), client, e.Type, e.Name, text, took)
+	}
+	return fmt.Sprintf("%s%s%s  %-28s %-6s %s  %s%s%s  %s%s%s",
+		ansiDim, e.Time.Local().Format("15:04:05.000"), ansiReset, client, e.Type, e.Name, c, text, ansiReset, ansiDim, took, ansiReset)
+}
+
+func init() {
+	tailCmd.Flags().StringVar(&TailClient, "client", "", "only the queries of this client address or device name")
+	tailCmd.Flags().StringVar(&TailSuffix, "suffix", "", "only the names under this domain")
+	tailCmd.Flags().BoolVar(&TailBlockedOnly, "blocked-only", false, "only the blocked queries")
+	tailCmd.Flags().StringVar(&TailColor, "color", "auto", "color the verdicts: auto, always or never")
+	rootCmd.AddCommand(tailCmd)
+}
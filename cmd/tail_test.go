@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/api"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/bernoussama/mercury/privacy"
+	"github.com/bernoussama/mercury/querylog"
+)
+
+func TestTailLine(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 30, 0, 0, time.Local)
+	tests := []struct {
+		entry querylog.Entry
+		want  string
+	}{
+		{querylog.Entry{Source: "blocklist", Category: "ads", Rcode: "NOERROR", Answers: 1}, "blocked ads"},
+		{querylog.Entry{Source: "upstream", Rcode: "NXDOMAIN"}, "nxdomain"},
+		{querylog.Entry{Source: "cache", Rcode: "NOERROR", Answers: 2}, "cached, 2 answers"},
+		{querylog.Entry{Source: "zone", Rcode: "NOERROR", Answers: 1}, "zone, 1 answer"},
+	}
+	for _, tt := range tests {
+		tt.entry.Time, tt.entry.Client, tt.entry.Device, tt.entry.Type, tt.entry.Name = at, "192.168.1.20", "laptop", "A", "example.com."
+		line := tailLine(tt.entry, false)
+		if !strings.HasPrefix(line, "09:30:00.000  192.168.1.20 (laptop)") || !strings.Contains(line, "example.com.  "+tt.want+"  ") {
+			t.Errorf("line %q, want verdict %q", line, tt.want)
+		}
+		if colored := tailLine(tt.entry, true); !strings.Contains(colored, "\033[") {
+			t.Errorf("colored line %q has no colors", colored)
+		}
+	}
+}
+
+func TestOpenTap(t *testing.T) {
+	admin := api.New(api.Options{}, &dns.RecordsCache{Records: make(map[string]dns.Message)})
+	admin.Tap = querylog.NewTap(privacy.Options{})
+	srv := httptest.NewServer(admin)
+	defer srv.Close()
+	AdminAddress = srv.URL
+	defer func() { AdminAddress = "" }()
+
+	body, err := openTap(url.Values{"client": {"192.168.1.20"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	for !admin.Tap.Active() {
+		time.Sleep(time.Millisecond)
+	}
+	admin.Tap.Add(querylog.Entry{Client: "192.168.1.21", Name: "other.example.com."})
+	admin.Tap.Add(querylog.Entry{Client: "192.168.1.20", Name: "example.com."})
+	var e querylog.Entry
+	if err := json.NewDecoder(body).Decode(&e); err != nil || e.Name != "example.com." {
+		t.Errorf("streamed %+v, err %v", e, err)
+	}
+
+	disabled := httptest.NewServer(api.New(api.Options{}, &dns.RecordsCache{Records: make(map[string]dns.Message)}))
+	defer disabled.Close()
+	AdminAddress = disabled.URL
+	if _, err := openTap(nil); err == nil || !strings.Contains(err.Error(), "query tap disabled") {
+		t.Errorf("openTap without a tap: %v", err)
+	}
+}
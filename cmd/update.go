@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bernoussama/mercury/buildinfo"
+	"github.com/bernoussama/mercury/update"
+	"github.com/spf13/cobra"
+)
+
+var (
+	UpdateCheckOnly bool
+	UpdateForce     bool
+)
+
+// updateCmd replaces the binary with the latest release
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "update mercury to the latest release",
+	Long: `Update downloads the latest release of mercury from GitHub for this
+platform, checks the archive against the SHA-256 checksums published with
+the release and replaces the running binary with the one inside. The old
+binary stays in place when any step fails. Restart the server afterwards,
+e.g. with systemctl restart mercury.
+
+Example usage:
+$ mercury update --check-only
+$ sudo mercury update
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		current := buildinfo.Get().Version
+		u := update.New()
+		release, err := u.Latest(context.Background())
+		check(err)
+		if !update.Newer(current, release.Tag) && !UpdateForce {
+			fmt.Printf("mercury %s is up to date\n", current)
+			return
+		}
+		fmt.Printf("mercury %s is available, running %s\n", release.Tag, current)
+		if UpdateCheckOnly {
+			return
+		}
+		exe, err := os.Executable()
+		check(err)
+		exe, err = filepath.EvalSymlinks(exe)
+		check(err)
+		if err := u.Apply(context.Background(), release, exe); err != nil {
+			fmt.Fprintln(os.Stderr, "update failed:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("updated %s to %s\n", exe, release.Tag)
+	},
+}
+
+func init() {
+	updateCmd.Flags().BoolVar(&UpdateCheckOnly, "check-only", false, "report whether a newer release exists without installing it")
+	updateCmd.Flags().BoolVar(&UpdateForce, "force", false, "install the latest release even if it is not newer")
+	rootCmd.AddCommand(updateCmd)
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bernoussama/mercury/buildinfo"
+	"github.com/spf13/cobra"
+)
+
+var VersionJSON bool
+
+// versionCmd prints the build of the binary
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "print the version, commit, build date and features",
+	Long: `Version prints the version of mercury, the commit and date it was built
+from and the optional features compiled in.
+
+Example usage:
+$ mercury version
+$ mercury version --json
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		info := buildinfo.Get()
+		if VersionJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			check(enc.Encode(info))
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "version:\t%s\n", info.Version)
+		fmt.Fprintf(w, "commit:\t%s\n", orDash(info.Commit))
+		fmt.Fprintf(w, "built:\t%s\n", orDash(info.Date))
+		fmt.Fprintf(w, "go:\t%s %s\n", info.GoVersion, info.Platform)
+		fmt.Fprintf(w, "features:\t%s\n", strings.Join(info.Features, " "))
+		w.Flush()
+	},
+}
+
+func init() {
+	versionCmd.Flags().BoolVar(&VersionJSON, "json", false, "print the build info as JSON")
+	rootCmd.AddCommand(versionCmd)
+}
@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/bernoussama/mercury/fqdn"
+	"github.com/spf13/cobra"
+)
+
+// zonesCmd groups zone file subcommands
+var zonesCmd = &cobra.Command{
+	Use:   "zones",
+	Short: "manage authoritative zones",
+}
+
+// zoneTemplate is what zones new fills in
+type zoneTemplate struct {
+	origin string
+	ipv4   string
+	ipv6   string
+	// ns are the name servers, ns1 inside the zone when empty
+	ns    []string
+	email string
+	ttl   uint32
+}
+
+var newZone zoneTemplate
+
+var (
+	zonesDir    string
+	zonesForce  bool
+	zonesStdout bool
+)
+
+// zonesNewCmd scaffolds a zone file
+var zonesNewCmd = &cobra.Command{
+	Use:   "new <domain>",
+	Short: "create a zone file with SOA, NS and A records",
+	Long: `New writes <domain>.yml to the zones directory of the config, with a SOA
+record, name servers and the apex, www and name server addresses pointing to
+--ip and --ipv6. Edit the file afterwards to add more records.
+
+Example usage:
+$ mercury zones new example.com --ip 10.0.0.5
+$ mercury zones new example.com --ip 10.0.0.5 --ns ns1.provider.net --ns ns2.provider.net --stdout
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		t := newZone
+		t.origin = args[0]
+		data, err := t.render(time.Now())
+		check(err)
+		if zonesStdout {
+			os.Stdout.Write(data)
+			return
+		}
+
+		cfg, err := config.Load(ConfigFile)
+		check(err)
+		dir := cfg.Zones
+		if zonesDir != "" {
+			dir = zonesDir
+		}
+		values, err := config.LoadValues(cfg.ZoneValues)
+		check(err)
+		// zones that fail to load are still returned when they parsed
+		existing, _ := config.LoadZones(dir, values)
+		if _, ok := existing[string(fqdn.Canonical(t.origin))]; ok && !zonesForce {
+			fmt.Fprintf(os.Stderr, "zone %s already exists in %s, use --force to overwrite\n", absolute(t.origin), dir)
+			os.Exit(1)
+		}
+
+		file := filepath.Join(dir, strings.TrimSuffix(strings.ToLower(t.origin), ".")+".yml")
+		flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if zonesForce {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		f, err := os.OpenFile(file, flags, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			fmt.Fprintf(os.Stderr, "%s already exists, use --force to overwrite\n", file)
+			os.Exit(1)
+		}
+		check(err)
+		_, err = f.Write(data)
+		check(errors.Join(err, f.Close()))
+		fmt.Printf("created %s\n", file)
+	},
+}
+
+// render returns the zone file, with serial based on now
+func (t zoneTemplate) render(now time.Time) ([]byte, error) {
+	origin := string(fqdn.Canonical(t.origin))
+	if strings.Count(origin, ".") < 2 || strings.ContainsAny(origin, " /@") {
+		return nil, fmt.Errorf("invalid domain %q", t.origin)
+	}
+	if t.ipv4 == "" && t.ipv6 == "" {
+		return nil, errors.New("at least one of --ip and --ipv6 is required")
+	}
+	if ip := net.ParseIP(t.ipv4); t.ipv4 != "" && (ip == nil || ip.To4() == nil) {
+		return nil, fmt.Errorf("invalid IPv4 address %q", t.ipv4)
+	}
+	if ip := net.ParseIP(t.ipv6); t.ipv6 != "" && (ip == nil || ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid IPv6 address %q", t.ipv6)
+	}
+
+	ns := make([]string, 0, len(t.ns))
+	for _, host := range t.ns {
+		ns = append(ns, absolute(host))
+	}
+	// a name server inside the zone needs glue addresses
+	names := []string{"@", "www"}
+	if len(ns) == 0 {
+		ns = []string{"ns1." + origin}
+		names = append(names, "ns1")
+	}
+	email := t.email
+	if email == "" {
+		email = "hostmaster@" + strings.TrimSuffix(origin, ".")
+	}
+	rname := absolute(strings.Replace(email, "@", ".", 1))
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "origin: %s\n", origin)
+	fmt.Fprintf(&b, "ttl: %d\n", t.ttl)
+	fmt.Fprintf(&b, "soa:\n")
+	fmt.Fprintf(&b, "  mname: %s\n", ns[0])
+	fmt.Fprintf(&b, "  rname: %s  # %s\n", rname, email)
+	fmt.Fprintf(&b, "  serial: %s01  # raised by reload when left as is\n", now.Format("20060102"))
+	fmt.Fprintf(&b, "  refresh: 3600\n  retry: 600\n  expire: 604800\n  minimum: 300\n")
+	fmt.Fprintf(&b, "ns:\n")
+	for _, host := range ns {
+		fmt.Fprintf(&b, "  - host: %s\n", host)
+	}
+	for _, family := range []struct{ key, ip string }{{"a", t.ipv4}, {"aaaa", t.ipv6}} {
+		if family.ip == "" {
+			continue
+		}
+		value := family.ip
+		if family.key == "aaaa" {
+			// a leading colon, as in ::1, would not parse unquoted
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, "%s:\n", family.key)
+		for _, name := range names {
+			if name == "@" {
+				name = `"@"`
+			}
+			fmt.Fprintf(&b, "  - name: %s\n    value: %s\n", name, value)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+var zonesDiffJSON bool
+
+// zonesDiffCmd compares two versions of a zone file
+var zonesDiffCmd = &cobra.Command{
+	Use:   "diff <old.yml> <new.yml>",
+	Short: "print the records added, removed and changed between two zone files",
+	Long: `Diff compares two versions of a zone file record by record, with the
+${NAME} values of the config substituted, and prints the records added (+),
+removed (-) and changed (~), and the zone settings changed. A record is
+changed when it is the only one of its name and type in both files, or
+when only its TTL moved. It exits with status 1 when the zones differ, like
+diff.
+
+Example usage:
+$ mercury zones diff example.com.yml.orig example.com.yml
+$ git show HEAD:zones/example.com.yml > /tmp/old.yml && mercury zones diff /tmp/old.yml zones/example.com.yml --json
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load(ConfigFile)
+		check(err)
+		values, err := config.LoadValues(cfg.ZoneValues)
+		check(err)
+		old, err := config.LoadZone(args[0], values)
+		check(err)
+		new, err := config.LoadZone(args[1], values)
+		check(err)
+		d := dns.DiffZone(old, new)
+		if zonesDiffJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			check(enc.Encode(d))
+		} else {
+			fmt.Print(d)
+		}
+		if !d.Empty() {
+			os.Exit(1)
+		}
+	},
+}
+
+var (
+	tlsaName                           string
+	tlsaUsage, tlsaSelector, tlsaMatch uint8
+)
+
+// zonesTLSACmd prints the TLSA record pinning a certificate
+var zonesTLSACmd = &cobra.Command{
+	Use:   "tlsa <cert.pem>",
+	Short: "print the TLSA record of a certificate for a zone file",
+	Long: `TLSA prints the tlsa entry of a zone file pinning the first certificate of
+a PEM file, by default its public key hashed with SHA-256 as a DANE-EE
+record (3 1 1), which survives renewals keeping the key.
+
+Example usage:
+$ mercury zones tlsa /etc/ssl/www.pem --name _443._tcp.www
+$ mercury zones tlsa ca.pem --name _25._tcp.mail --usage 2 --selector 0
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := os.ReadFile(args[0])
+		check(err)
+		record, err := tlsaRecord(data, tlsaName, tlsaUsage, tlsaSelector, tlsaMatch)
+		check(err)
+		fmt.Print(record)
+	},
+}
+
+// tlsaRecord returns the zone file entry of the TLSA record of the first
+// certificate in certPEM
+func tlsaRecord(certPEM []byte, name string, usage, selector, matching uint8) (string, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", errors.New("no PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	data := cert.Raw
+	switch selector {
+	case 0:
+	case 1:
+		data = cert.RawSubjectPublicKeyInfo
+	default:
+		return "", fmt.Errorf("selector %d out of range 0-1", selector)
+	}
+	switch matching {
+	case 0:
+	case 1:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case 2:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	default:
+		return "", fmt.Errorf("matching type %d out of range 0-2", matching)
+	}
+	if usage > 3 {
+		return "", fmt.Errorf("usage %d out of range 0-3", usage)
+	}
+	return fmt.Sprintf("tlsa:\n  - name: %s\n    usage: %d\n    selector: %d\n    matching_type: %d\n    data: %x\n",
+		name, usage, selector, matching, data), nil
+}
+
+// absolute adds the trailing dot of a fully qualified name
+func absolute(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+func init() {
+	zonesNewCmd.Flags().StringVar(&newZone.ipv4, "ip", "", "IPv4 address of the apex, www and ns1")
+	zonesNewCmd.Flags().StringVar(&newZone.ipv6, "ipv6", "", "IPv6 address of the apex, www and ns1")
+	zonesNewCmd.Flags().StringSliceVar(&newZone.ns, "ns", nil, "name servers, ns1 in the zone by default")
+	zonesNewCmd.Flags().StringVar(&newZone.email, "email", "", "contact of the SOA record, hostmaster@<domain> by default")
+	zonesNewCmd.Flags().Uint32Var(&newZone.ttl, "ttl", 3600, "default TTL of the records")
+	zonesNewCmd.Flags().StringVar(&zonesDir, "dir", "", "zones directory, the one of the config by default")
+	zonesNewCmd.Flags().BoolVar(&zonesForce, "force", false, "overwrite an existing zone")
+	zonesNewCmd.Flags().BoolVar(&zonesStdout, "stdout", false, "print the zone instead of writing it")
+
+	zonesTLSACmd.Flags().StringVar(&tlsaName, "name", "_443._tcp", "owner of the record, _port._protocol.host")
+	zonesTLSACmd.Flags().Uint8Var(&tlsaUsage, "usage", 3, "certificate usage: 0 PKIX-TA, 1 PKIX-EE, 2 DANE-TA or 3 DANE-EE")
+	zonesTLSACmd.Flags().Uint8Var(&tlsaSelector, "selector", 1, "0 for the full certificate, 1 for its public key")
+	zonesTLSACmd.Flags().Uint8Var(&tlsaMatch, "matching-type", 1, "0 for the data itself, 1 for SHA-256, 2 for SHA-512")
+	zonesCmd.AddCommand(zonesNewCmd)
+	zonesDiffCmd.Flags().BoolVar(&zonesDiffJSON, "json", false, "print the diff as JSON")
+	zonesCmd.AddCommand(zonesTLSACmd)
+	zonesCmd.AddCommand(zonesDiffCmd)
+	rootCmd.AddCommand(zonesCmd)
+}
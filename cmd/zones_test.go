@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/config"
+	"github.com/bernoussama/mercury/dns"
+)
+
+func TestZoneTemplateRender(t *testing.T) {
+	now := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		t      zoneTemplate
+		lookup map[string]dns.QType
+		ns     string
+	}{
+		{
+			"in zone name server",
+			zoneTemplate{origin: "Example.com", ipv4: "10.0.0.5", ttl: 3600},
+			map[string]dns.QType{"example.com.": dns.TypeA, "www.example.com.": dns.TypeA, "ns1.example.com.": dns.TypeA},
+			"ns1.example.com.",
+		},
+		{
+			"external name servers",
+			zoneTemplate{origin: "example.org.", ipv6: "2001:db8::5", ns: []string{"ns1.provider.net", "ns2.provider.net."}, ttl: 300},
+			map[string]dns.QType{"example.org.": dns.TypeAAAA, "www.example.org.": dns.TypeAAAA},
+			"ns1.provider.net.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.t.render(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), "serial: 2026101601") {
+				t.Errorf("zone has no date based serial:\n%s", data)
+			}
+			dir := t.TempDir()
+			writeTestFile(t, filepath.Join(dir, "zone.yml"), data)
+			zones, err := config.LoadZones(dir, nil)
+			if err != nil {
+				t.Fatalf("generated zone is invalid: %v\n%s", err, data)
+			}
+			for _, zone := range zones {
+				if zone.SOA["mname"] != tt.ns {
+					t.Errorf("mname = %v, want %s", zone.SOA["mname"], tt.ns)
+				}
+				for name, qtype := range tt.lookup {
+					if len(zone.Lookup(name, qtype, 1)) != 1 {
+						t.Errorf("no %v record for %s:\n%s", qtype, name, data)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestZoneTemplateRenderErrors(t *testing.T) {
+	for _, tt := range []zoneTemplate{
+		{origin: "example.com"},
+		{origin: "com", ipv4: "10.0.0.5"},
+		{origin: "example.com", ipv4: "2001:db8::5"},
+		{origin: "example.com", ipv6: "10.0.0.5"},
+	} {
+		if data, err := tt.render(time.Now()); err == nil {
+			t.Errorf("render(%+v) = %s, want error", tt, data)
+		}
+	}
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSARecord(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "www.example.com"}, NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	record, err := tlsaRecord(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), "_443._tcp.www", 3, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "zone.yml"), []byte("origin: example.com.\n"+record))
+	zones, err := config.LoadZones(dir, nil)
+	if err != nil {
+		t.Fatalf("TLSA record is invalid: %v\n%s", err, record)
+	}
+	zone := zones["example.com."]
+	answers := zone.Lookup("_443._tcp.www.example.com.", dns.TypeTLSA, 1)
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	if len(answers) != 1 || !bytes.Equal(answers[0].RData, append([]byte{3, 1, 1}, sum[:]...)) {
+		t.Errorf("answers %v, want 3 1 1 and the key digest\n%s", answers, record)
+	}
+
+	if _, err := tlsaRecord([]byte("not pem"), "_443._tcp", 3, 1, 1); err == nil {
+		t.Error("expected an error without a certificate")
+	}
+	if _, err := tlsaRecord(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), "_443._tcp", 3, 2, 1); err == nil {
+		t.Error("expected an error for selector 2")
+	}
+}
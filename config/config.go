@@ -0,0 +1,523 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/acme"
+	"github.com/bernoussama/mercury/api"
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/blockpage"
+	"github.com/bernoussama/mercury/budget"
+	"github.com/bernoussama/mercury/buildinfo"
+	"github.com/bernoussama/mercury/clients"
+	"github.com/bernoussama/mercury/detect"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/bernoussama/mercury/fqdn"
+	"github.com/bernoussama/mercury/logging"
+	"github.com/bernoussama/mercury/mqtt"
+	"github.com/bernoussama/mercury/peer"
+	"github.com/bernoussama/mercury/privacy"
+	"github.com/bernoussama/mercury/querylog"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is where the server looks for its config file when none is given
+const DefaultPath = "/opt/mercury/config.yml"
+
+// Config holds the server settings read from the config file
+type Config struct {
+	Listen     string           `yaml:"listen"`
+	Zones      string           `yaml:"zones"`
+	Blocklists []blocklist.File `yaml:"blocklists"`
+	Allow      []string         `yaml:"allow"`
+
+	// BlocklistStore selects how blocked domains are held in memory
+	BlocklistStore blocklist.Options `yaml:"blocklist_store"`
+	// BlocklistSources are the URLs and files blocklist compile reads
+	BlocklistSources []string `yaml:"blocklist_sources"`
+	// Allowlists are files of domains blocklist compile leaves out
+	Allowlists []string `yaml:"allowlists"`
+	// BlocklistCompiled is the file blocklist compile writes, mapped by the
+	// server at startup with the blocklists files added on top
+	BlocklistCompiled string `yaml:"blocklist_compiled"`
+	// ThreatFeeds are fetched on a schedule and sinkholed under their
+	// category
+	ThreatFeeds []blocklist.FeedOptions `yaml:"threat_feeds"`
+	// ClientGroups disable blocklist categories for some clients
+	ClientGroups []blocklist.GroupOptions `yaml:"client_groups"`
+	// SinkholeAddresses are what blocked names resolve to, loopback
+	// addresses by default
+	SinkholeAddresses []string `yaml:"sinkhole_addresses"`
+	// BlockPage explains to browsers why a name is blocked
+	BlockPage blockpage.Options `yaml:"block_page"`
+	// Clients names clients in logs and stats after their DHCP leases
+	Clients clients.Options `yaml:"clients"`
+	// Peering shares runtime state with other instances
+	Peering peer.Options `yaml:"peering"`
+	// Privacy anonymizes clients and query names in logs and stats
+	Privacy privacy.Options `yaml:"privacy"`
+	// QueryLog keeps the history of the queries answered
+	QueryLog querylog.Options `yaml:"query_log"`
+	// MQTT publishes stats to a broker, for Home Assistant
+	MQTT mqtt.Options `yaml:"mqtt"`
+	// Detection flags clients tunneling through DNS or resolving
+	// generated names
+	Detection detect.Options `yaml:"detection"`
+	// ErrorBudget alerts when too many queries are answered SERVFAIL
+	ErrorBudget budget.Options `yaml:"error_budget"`
+
+	// ZoneValues is a YAML mapping of the variables zone files reference,
+	// overridden by environment variables of the same name
+	ZoneValues string `yaml:"zone_values"`
+
+	// Listeners replace Listen to serve on several addresses and protocols
+	Listeners []Listener `yaml:"listeners"`
+	// Connections limits the connections of the tcp, dot and doh
+	// listeners, by protocol
+	Connections map[string]ConnectionLimits `yaml:"connections"`
+	// Socket sizes the buffers and sets the TOS and fragmentation of the
+	// sockets of the listeners
+	Socket SocketOptions `yaml:"socket"`
+
+	// Timeout bounds the time spent answering a single query
+	Timeout time.Duration `yaml:"timeout"`
+	// Upstreams are where recursion starts, the resolv.conf name servers
+	// or the root servers by default
+	Upstreams []dns.Upstream `yaml:"upstreams"`
+	// QueryBudget caps the upstream queries sent for one client query
+	QueryBudget int `yaml:"query_budget"`
+	// Concurrency caps the resolutions in flight per client and in all
+	Concurrency dns.ConcurrencyLimits `yaml:"concurrency"`
+	// Delegations caches the delegations followed from the root servers
+	// and prefetches the hot ones before they expire
+	Delegations dns.DelegationOptions `yaml:"delegations"`
+	// RootZone keeps a local copy of the root zone, RFC 8806, when
+	// resolving from the root servers
+	RootZone dns.RootZoneOptions `yaml:"root_zone"`
+	// LocalZones answers the reverse zones of private addresses locally
+	// instead of resolving them upstream, RFC 6303
+	LocalZones dns.LocalZoneOptions `yaml:"local_zones"`
+	// TTLOverrides force the TTL of the forwarded answers of some
+	// domains, for services failing over fast
+	TTLOverrides []dns.TTLOverride `yaml:"ttl_overrides"`
+	// Recursion resolves the names outside the zones through the
+	// upstreams, on by default. Off, only the zones, hosts, blocklist and
+	// cache answer.
+	Recursion bool `yaml:"recursion"`
+	// Unanswered is the response code of the queries nothing can answer:
+	// refused, the default, or servfail
+	Unanswered string `yaml:"unanswered"`
+	// DebugTrace answers TXT queries for <name>.trace.mercury with the
+	// steps resolving <name> takes, for debugging from any stub client
+	DebugTrace bool `yaml:"debug_trace"`
+	// Identity answers version.bind, hostname.bind and NSID queries
+	Identity dns.Identity `yaml:"identity"`
+
+	Log logging.Options `yaml:"log"`
+
+	Admin api.Options `yaml:"admin"`
+	// ACME obtains the certificate of the listeners and admin API that ask
+	// for it, answering the dns-01 challenges from the zones
+	ACME acme.Options `yaml:"acme"`
+
+	path string
+	root *yaml.Node
+}
+
+// Default returns the config used when no config file is present
+func Default() *Config {
+	return &Config{
+		Listen:      "0.0.0.0:53153",
+		Zones:       "/opt/mercury/zones",
+		Timeout:     5 * time.Second,
+		QueryBudget: 32,
+		Recursion:   true,
+		Delegations: dns.DelegationOptions{Cache: true, PrefetchHits: 10},
+		LocalZones:  dns.LocalZoneOptions{Enabled: true},
+		Admin:       api.Options{Listen: "127.0.0.1:53180"},
+		Identity:    dns.Identity{Version: buildinfo.Get().String()},
+	}
+}
+
+// Load reads the config file at path on top of the defaults.
+// A missing file at the default path is not an error.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		path = DefaultPath
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && path == DefaultPath {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg.path = path
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(root.Content) == 0 {
+		return cfg, nil
+	}
+	cfg.root = root.Content[0]
+	if err := cfg.root.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Path returns the file the config was loaded from, empty for defaults
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Validate checks the config along with every zone and blocklist file it
+// references. All problems are reported at once in a *ValidationError.
+func (c *Config) Validate() error {
+	verr := &ValidationError{}
+	c.validateListeners(verr)
+	for _, err := range c.Admin.Validate() {
+		verr.add(c.path, lineOf(c.root, "admin"), "admin: %v", err)
+	}
+	for _, err := range c.ACME.Validate() {
+		verr.add(c.path, lineOf(c.root, "acme"), "acme: %v", err)
+	}
+	if c.Admin.TLS.ACME && !c.ACME.Enabled() {
+		verr.add(c.path, lineOf(c.root, "admin"), "admin: tls acme needs acme domains")
+	}
+	if c.Timeout <= 0 {
+		verr.add(c.path, lineOf(c.root, "timeout"), "timeout must be positive, got %s", c.Timeout)
+	}
+	for i, upstream := range c.Upstreams {
+		if _, err := net.ResolveUDPAddr("udp", upstream.Address); err != nil {
+			verr.add(c.path, lineOf(c.root, "upstreams", i, "address"), "invalid upstream address %q: %v", upstream.Address, err)
+		}
+		if upstream.Timeout < 0 || upstream.Backoff < 0 || upstream.Retries < 0 {
+			verr.add(c.path, lineOf(c.root, "upstreams", i), "upstream %q timeout, retries and backoff must not be negative", upstream.Address)
+		}
+	}
+	if c.QueryBudget < 0 {
+		verr.add(c.path, lineOf(c.root, "query_budget"), "query_budget must not be negative, got %d", c.QueryBudget)
+	}
+	for _, err := range c.Concurrency.Validate() {
+		verr.add(c.path, lineOf(c.root, "concurrency"), "concurrency: %v", err)
+	}
+	for _, err := range c.RootZone.Validate() {
+		verr.add(c.path, lineOf(c.root, "root_zone"), "root_zone: %v", err)
+	}
+	for _, err := range c.LocalZones.Validate() {
+		verr.add(c.path, lineOf(c.root, "local_zones"), "local_zones: %v", err)
+	}
+	for i, override := range c.TTLOverrides {
+		if err := override.Validate(); err != nil {
+			verr.add(c.path, lineOf(c.root, "ttl_overrides", i), "ttl_overrides: %v", err)
+		}
+	}
+	if c.RootZone.Mirror && len(c.Upstreams) > 0 {
+		verr.add(c.path, lineOf(c.root, "root_zone", "mirror"), "root_zone mirror resolves from the root servers, it cannot be used with upstreams")
+	}
+	if c.Unanswered != "" && c.Unanswered != "refused" && c.Unanswered != "servfail" {
+		verr.add(c.path, lineOf(c.root, "unanswered"), "unanswered must be refused or servfail, got %q", c.Unanswered)
+	}
+	for _, err := range c.BlocklistStore.Validate() {
+		verr.add(c.path, lineOf(c.root, "blocklist_store"), "blocklist_store: %v", err)
+	}
+	for _, err := range c.Log.Validate() {
+		verr.add(c.path, lineOf(c.root, "log"), "log: %v", err)
+	}
+	for i, cidr := range c.Allow {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			verr.add(c.path, lineOf(c.root, "allow", i), "invalid CIDR %q", cidr)
+		}
+	}
+	for i, file := range c.Blocklists {
+		for _, err := range file.Rule.Validate() {
+			verr.add(c.path, lineOf(c.root, "blocklists", i), "blocklists: %v", err)
+		}
+		f, err := os.Open(file.Path)
+		if err != nil {
+			verr.add(c.path, lineOf(c.root, "blocklists", i), "unreadable blocklist: %v", err)
+			continue
+		}
+		f.Close()
+	}
+	if _, conflicts := blocklist.CategoryRules(c.Blocklists); len(conflicts) > 0 {
+		verr.add(c.path, lineOf(c.root, "blocklists"), "blocklists: files of categories %s have different rules", strings.Join(conflicts, ", "))
+	}
+	c.validateCompiled(verr)
+	c.validateFeeds(verr)
+	c.validateGroups(verr)
+	for i, address := range c.SinkholeAddresses {
+		if net.ParseIP(address) == nil {
+			verr.add(c.path, lineOf(c.root, "sinkhole_addresses", i), "invalid sinkhole address %q", address)
+		}
+	}
+	for _, err := range c.BlockPage.Validate() {
+		verr.add(c.path, lineOf(c.root, "block_page"), "block_page: %v", err)
+	}
+	if c.BlockPage.Listen != "" && len(c.SinkholeAddresses) == 0 {
+		verr.add(c.path, lineOf(c.root, "block_page"), "block_page needs sinkhole_addresses pointing blocked names at this server")
+	}
+	for _, err := range c.Clients.Validate() {
+		verr.add(c.path, lineOf(c.root, "clients"), "clients: %v", err)
+	}
+	for _, err := range c.Peering.Validate() {
+		verr.add(c.path, lineOf(c.root, "peering"), "peering: %v", err)
+	}
+	for _, err := range c.Privacy.Validate() {
+		verr.add(c.path, lineOf(c.root, "privacy"), "privacy: %v", err)
+	}
+	for _, err := range c.QueryLog.Validate() {
+		verr.add(c.path, lineOf(c.root, "query_log"), "query_log: %v", err)
+	}
+	for _, err := range c.MQTT.Validate() {
+		verr.add(c.path, lineOf(c.root, "mqtt"), "mqtt: %v", err)
+	}
+	for _, err := range c.Detection.Validate() {
+		verr.add(c.path, lineOf(c.root, "detection"), "detection: %v", err)
+	}
+	for _, err := range c.ErrorBudget.Validate() {
+		verr.add(c.path, lineOf(c.root, "error_budget"), "error_budget: %v", err)
+	}
+	values, err := LoadValues(c.ZoneValues)
+	if err != nil {
+		verr.add(c.path, lineOf(c.root, "zone_values"), "unreadable zone values: %v", err)
+		return verr.err()
+	}
+	zones, err := LoadZones(c.Zones, values)
+	if err != nil {
+		if zerr, ok := err.(*ValidationError); ok {
+			verr.Problems = append(verr.Problems, zerr.Problems...)
+		} else {
+			verr.add(c.path, lineOf(c.root, "zones"), "%v", err)
+		}
+	}
+	c.validateTenants(verr, zones)
+	for i, domain := range c.ACME.Domains {
+		if _, ok := dns.FindZone(zones, strings.TrimPrefix(domain, "*.")); !ok {
+			verr.add(c.path, lineOf(c.root, "acme", "domains", i), "acme: %s is in no zone of %s, its challenges could not be answered", domain, c.Zones)
+		}
+	}
+	return verr.err()
+}
+
+// validateTenants checks that the zones and categories of admin tenants
+// are served
+func (c *Config) validateTenants(verr *ValidationError, zones map[string]dns.Zone) {
+	categories, _ := blocklist.Categorize(c.Blocklists)
+	categories = append(categories, blocklist.CategoryBlocklist)
+	for _, feed := range c.ThreatFeeds {
+		categories = append(categories, feed.List(nil).Category)
+	}
+	for i, tenant := range c.Admin.Tenants {
+		for j, origin := range tenant.Zones {
+			if _, ok := zones[string(fqdn.Canonical(origin))]; !ok {
+				verr.add(c.path, lineOf(c.root, "admin", "tenants", i, "zones", j), "admin: tenant %q: no zone %s in %s", tenant.Name, origin, c.Zones)
+			}
+		}
+		for j, category := range tenant.Categories {
+			if !slices.Contains(categories, category) {
+				verr.add(c.path, lineOf(c.root, "admin", "tenants", i, "categories", j), "admin: tenant %q: unknown blocklist category %q", tenant.Name, category)
+			}
+		}
+	}
+}
+
+// validateCompiled checks the files and URLs blocklist compile reads and
+// that the list it writes exists when the server is to map it
+func (c *Config) validateCompiled(verr *ValidationError) {
+	for i, source := range c.BlocklistSources {
+		if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+			if u, err := url.Parse(source); err != nil || u.Host == "" {
+				verr.add(c.path, lineOf(c.root, "blocklist_sources", i), "invalid blocklist source URL %q", source)
+			}
+			continue
+		}
+		if _, err := os.Stat(source); err != nil {
+			verr.add(c.path, lineOf(c.root, "blocklist_sources", i), "unreadable blocklist source: %v", err)
+		}
+	}
+	for i, file := range c.Allowlists {
+		if _, err := os.Stat(file); err != nil {
+			verr.add(c.path, lineOf(c.root, "allowlists", i), "unreadable allowlist: %v", err)
+		}
+	}
+	if c.BlocklistCompiled == "" {
+		return
+	}
+	if _, err := os.Stat(c.BlocklistCompiled); errors.Is(err, os.ErrNotExist) {
+		verr.add(c.path, lineOf(c.root, "blocklist_compiled"), "compiled blocklist %s not found, run mercury blocklist compile", c.BlocklistCompiled)
+	} else if err != nil {
+		verr.add(c.path, lineOf(c.root, "blocklist_compiled"), "unreadable compiled blocklist: %v", err)
+	}
+}
+
+// validateFeeds checks each threat feed and that their names are unique,
+// blocklist categories included
+func (c *Config) validateFeeds(verr *ValidationError) {
+	names := make(map[string]bool)
+	categories, _ := blocklist.Categorize(c.Blocklists)
+	for _, category := range append(categories, blocklist.CategoryBlocklist) {
+		names[category] = true
+	}
+	for i, feed := range c.ThreatFeeds {
+		for _, err := range feed.Validate() {
+			verr.add(c.path, lineOf(c.root, "threat_feeds", i), "threat_feeds: %v", err)
+		}
+		name := feed.Name
+		if name == "" {
+			name = feed.URL
+		}
+		if names[name] {
+			verr.add(c.path, lineOf(c.root, "threat_feeds", i), "threat_feeds: duplicate feed name %q", name)
+		}
+		names[name] = true
+	}
+}
+
+// validateGroups checks each client group and that their names are unique
+func (c *Config) validateGroups(verr *ValidationError) {
+	names := make(map[string]bool)
+	for i, group := range c.ClientGroups {
+		for _, err := range group.Validate() {
+			verr.add(c.path, lineOf(c.root, "client_groups", i), "client_groups: %v", err)
+		}
+		if names[group.Name] {
+			verr.add(c.path, lineOf(c.root, "client_groups", i), "client_groups: duplicate group name %q", group.Name)
+		}
+		names[group.Name] = true
+	}
+}
+
+// Changes returns the keys of the settings other sets differently, in the
+// order of the config struct
+func (c *Config) Changes(other *Config) []string {
+	a, b := reflect.ValueOf(*c), reflect.ValueOf(*other)
+	var keys []string
+	for i := range a.NumField() {
+		field := a.Type().Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || key == "" {
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// SinkholeIPs returns the parsed sinkhole addresses, skipping invalid ones
+func (c *Config) SinkholeIPs() []net.IP {
+	ips := make([]net.IP, 0, len(c.SinkholeAddresses))
+	for _, address := range c.SinkholeAddresses {
+		if ip := net.ParseIP(address); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// UnansweredRcode returns the response code of the unanswered setting
+func (c *Config) UnansweredRcode() uint16 {
+	if c.Unanswered == "servfail" {
+		return dns.RcodeServerFailure
+	}
+	return dns.RcodeRefused
+}
+
+// AllowedNets returns the parsed allow list, skipping invalid entries
+func (c *Config) AllowedNets() []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(c.Allow))
+	for _, cidr := range c.Allow {
+		if _, ipnet, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, ipnet)
+		}
+	}
+	return nets
+}
+
+// Problem is a single validation failure with its location
+type Problem struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (p Problem) Error() string {
+	switch {
+	case p.File == "":
+		return p.Msg
+	case p.Line == 0:
+		return fmt.Sprintf("%s: %s", p.File, p.Msg)
+	default:
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Msg)
+	}
+}
+
+// ValidationError collects every problem found during validation
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Problems))
+	for _, p := range e.Problems {
+		msgs = append(msgs, p.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e *ValidationError) add(file string, line int, format string, a ...any) {
+	e.Problems = append(e.Problems, Problem{File: file, Line: line, Msg: fmt.Sprintf(format, a...)})
+}
+
+func (e *ValidationError) err() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+// lineOf returns the line of the node at path, where each element is
+// either a mapping key or a sequence index. It returns 0 when not found.
+func lineOf(node *yaml.Node, path ...any) int {
+	if node == nil {
+		return 0
+	}
+	for _, p := range path {
+		var next *yaml.Node
+		switch key := p.(type) {
+		case string:
+			if node.Kind != yaml.MappingNode {
+				return node.Line
+			}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && key < len(node.Content) {
+				next = node.Content[key]
+			}
+		}
+		if next == nil {
+			return node.Line
+		}
+		node = next
+	}
+	return node.Line
+}
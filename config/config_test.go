@@ -0,0 +1,708 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/dns"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	zonesDir := filepath.Join(dir, "zones")
+	if err := os.Mkdir(zonesDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(zonesDir, "a.yml"), "origin: example.com.\na:\n  - name: \"@\"\n    value: 1.2.3\n")
+	writeFile(t, filepath.Join(zonesDir, "b.yml"), "origin: example.com.\ncaa:\n  - value: x\n")
+	writeFile(t, filepath.Join(zonesDir, "c.yml"), "origin: sub.example.com.\n")
+	cfgFile := filepath.Join(dir, "config.yml")
+	writeFile(t, cfgFile, "zones: "+zonesDir+"\nallow:\n  - 10.0.0.0/8\n  - 10.0.0/33\nblocklists:\n  - "+filepath.Join(dir, "missing.txt")+"\n")
+
+	cfg, err := Load(cfgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Validate() error = %v, want *ValidationError", err)
+	}
+
+	want := []Problem{
+		{File: cfgFile, Line: 4, Msg: `invalid CIDR "10.0.0/33"`},
+		{File: cfgFile, Line: 6},
+		{File: filepath.Join(zonesDir, "a.yml"), Line: 4, Msg: `invalid IPv4 address "1.2.3"`},
+		{File: filepath.Join(zonesDir, "b.yml"), Line: 2, Msg: `unknown record type "caa"`},
+		{File: filepath.Join(zonesDir, "b.yml"), Line: 1},
+	}
+	if len(verr.Problems) != len(want) {
+		t.Fatalf("Validate() got %d problems, want %d:\n%v", len(verr.Problems), len(want), err)
+	}
+	for i, p := range verr.Problems {
+		w := want[i]
+		if p.File != w.File || p.Line != w.Line || (w.Msg != "" && p.Msg != w.Msg) {
+			t.Errorf("problem %d = %v, want %v", i, p, w)
+		}
+	}
+}
+
+func TestValidateCompiled(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, "config.yml")
+	writeFile(t, cfgFile, `zones: `+t.TempDir()+`
+blocklist_sources:
+  - https://lists.example.com/hosts
+  - https://
+  - `+filepath.Join(dir, "missing.txt")+`
+allowlists:
+  - `+filepath.Join(dir, "allow.txt")+`
+blocklist_compiled: `+filepath.Join(dir, "blocklist.bin")+`
+`)
+	cfg, err := Load(cfgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Validate() error = %v, want *ValidationError", err)
+	}
+	lines := []int{4, 5, 7, 8}
+	if len(verr.Problems) != len(lines) {
+		t.Fatalf("Validate() got %d problems, want %d:\n%v", len(verr.Problems), len(lines), verr)
+	}
+	for i, p := range verr.Problems {
+		if p.Line != lines[i] {
+			t.Errorf("problem %d = %v, want line %d", i, p, lines[i])
+		}
+	}
+	if !strings.Contains(verr.Problems[3].Msg, "mercury blocklist compile") {
+		t.Errorf("missing compiled list: %s", verr.Problems[3].Msg)
+	}
+}
+
+func TestValidateFeeds(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "config.yml")
+	writeFile(t, cfgFile, `zones: `+t.TempDir()+`
+threat_feeds:
+  - https://urlhaus.abuse.ch/downloads/hostfile/
+  - name: urlhaus
+    url: https://urlhaus.abuse.ch/downloads/hostfile/
+  - name: urlhaus
+    url: /etc/hosts
+    interval: 1s
+`)
+	cfg, err := Load(cfgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Validate() error = %v, want *ValidationError", err)
+	}
+	// the URL, the interval and the name of the last feed
+	if len(verr.Problems) != 3 {
+		t.Fatalf("Validate() got %d problems, want 3:\n%v", len(verr.Problems), verr)
+	}
+	for _, p := range verr.Problems {
+		if p.Line != 6 {
+			t.Errorf("problem %v, want line 6", p)
+		}
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	cfg, err := Load(filepath.Join(t.TempDir(), "missing.yml"))
+	if err == nil {
+		t.Fatalf("Load() of missing explicit path = %+v, want error", cfg)
+	}
+}
+
+func TestListeners(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, "config.yml")
+	writeFile(t, cfgFile, `zones: `+t.TempDir()+`
+listeners:
+  - udp://0.0.0.0:53
+  - tcp://0.0.0.0:53
+  - name: doh
+    protocol: doh
+    address: :443
+  - dot://:853
+  - protocol: quic
+    address: :853
+  - udp://0.0.0.0:53
+`)
+	cfg, err := Load(cfgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listening := cfg.Listening()
+	if len(listening) != 6 || listening[0].Label() != "udp/0.0.0.0:53" || listening[2].Path != DefaultDoHPath {
+		t.Errorf("Listening() = %+v", listening)
+	}
+
+	verr, ok := cfg.Validate().(*ValidationError)
+	if !ok {
+		t.Fatalf("Validate() error = %v, want *ValidationError", cfg.Validate())
+	}
+	want := []Problem{
+		{File: cfgFile, Line: 8, Msg: "listener dot/:853: dot needs a cert and key, or acme"},
+		{File: cfgFile, Line: 9, Msg: `listener quic/:853: unknown protocol "quic", want udp, tcp, dot or doh`},
+		{File: cfgFile, Line: 9, Msg: "listener quic/:853: tcp address :853 used twice"},
+		{File: cfgFile, Line: 11, Msg: "listener udp/0.0.0.0:53: name used twice"},
+		{File: cfgFile, Line: 11, Msg: "listener udp/0.0.0.0:53: udp address 0.0.0.0:53 used twice"},
+	}
+	if len(verr.Problems) != len(want) {
+		t.Fatalf("Validate() got %d problems, want %d:\n%v", len(verr.Problems), len(want), verr)
+	}
+	for i, p := range verr.Problems {
+		if p != want[i] {
+			t.Errorf("problem %d = %v, want %v", i, p, want[i])
+		}
+	}
+
+	if got := Default().Listening(); len(got) != 1 || got[0].Protocol != UDP || got[0].Address != "0.0.0.0:53153" {
+		t.Errorf("default Listening() = %+v, want udp on the listen address", got)
+	}
+}
+
+func TestListenerProxyProtocol(t *testing.T) {
+	tests := []struct {
+		l    Listener
+		errs int
+	}{
+		{Listener{Protocol: TCP, Address: ":53", ProxyProtocol: true}, 0},
+		{Listener{Protocol: TCP, Address: ":53", ProxyProtocol: true, ProxyFrom: []string{"10.0.0.0/8"}}, 0},
+		{Listener{Protocol: UDP, Address: ":53", ProxyProtocol: true}, 1},
+		{Listener{Protocol: TCP, Address: ":53", ProxyFrom: []string{"10.0.0.0/8"}}, 1},
+		{Listener{Protocol: TCP, Address: ":53", ProxyProtocol: true, ProxyFrom: []string{"10.0.0.1"}}, 1},
+	}
+	for _, tt := range tests {
+		if errs := tt.l.Validate(); len(errs) != tt.errs {
+			t.Errorf("Validate(%+v) = %v, want %d errors", tt.l, errs, tt.errs)
+		}
+	}
+	l := Listener{ProxyFrom: []string{"10.0.0.0/8", "bogus", "2001:db8::/32"}}
+	if nets := l.ProxyNets(); len(nets) != 2 || nets[1].String() != "2001:db8::/32" {
+		t.Errorf("ProxyNets() = %v", nets)
+	}
+}
+
+func TestConnectionLimits(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "config.yml")
+	writeFile(t, cfgFile, `zones: `+t.TempDir()+`
+connections:
+  tcp:
+    max_connections: 100
+    max_queries: 50
+  dot:
+    idle_timeout: -1s
+  quic:
+    max_connections: 10
+socket:
+  receive_buffer: 4194304
+  tos: 256
+`)
+	cfg, err := Load(cfgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.ConnectionLimits(TCP); got.MaxConnections != 100 || got.MaxQueries != 50 || got.IdleTimeout != DefaultIdleTimeout {
+		t.Errorf("tcp limits = %+v", got)
+	}
+	if cfg.Socket.ReceiveBuffer != 4194304 {
+		t.Errorf("socket options = %+v", cfg.Socket)
+	}
+	if got := cfg.ConnectionLimits(DoH); got.MaxConnections != 0 || got.IdleTimeout != DefaultIdleTimeout {
+		t.Errorf("doh limits = %+v, want the defaults", got)
+	}
+
+	verr, ok := cfg.Validate().(*ValidationError)
+	if !ok {
+		t.Fatalf("Validate() error = %v, want *ValidationError", cfg.Validate())
+	}
+	want := []Problem{
+		{File: cfgFile, Line: 7, Msg: "connections dot: max_connections, idle_timeout and max_queries must not be negative"},
+		{File: cfgFile, Line: 9, Msg: `connections: unknown protocol "quic", want tcp, dot or doh`},
+		{File: cfgFile, Line: 11, Msg: "socket: tos must be between 0 and 255, got 256"},
+	}
+	if len(verr.Problems) != len(want) {
+		t.Fatalf("Validate() got %d problems, want %d:\n%v", len(verr.Problems), len(want), verr)
+	}
+	for i, p := range verr.Problems {
+		if p != want[i] {
+			t.Errorf("problem %d = %v, want %v", i, p, want[i])
+		}
+	}
+}
+
+func TestUpstreamRetries(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "config.yml")
+	writeFile(t, cfgFile, `zones: `+t.TempDir()+`
+upstreams:
+  - address: 192.0.2.1:53
+  - address: 192.0.2.2:53
+    retries: 0
+  - address: 192.0.2.3:53
+    retries: 5
+`)
+	cfg, err := Load(cfgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{dns.DefaultUpstreamRetries, 0, 5}
+	if len(cfg.Upstreams) != len(want) {
+		t.Fatalf("upstreams = %+v", cfg.Upstreams)
+	}
+	for i, upstream := range cfg.Upstreams {
+		if upstream.Retries != want[i] {
+			t.Errorf("%s: retries = %d, want %d", upstream.Address, upstream.Retries, want[i])
+		}
+	}
+}
+
+func TestValidateZoneReferences(t *testing.T) {
+	dir, cfgFile := t.TempDir(), filepath.Join(t.TempDir(), "config.yml")
+	writeFile(t, filepath.Join(dir, "example.yml"), "origin: example.com.\n")
+	writeFile(t, cfgFile, `zones: `+dir+`
+listeners:
+  - protocol: dot
+    address: :853
+    acme: true
+admin:
+  listen: 127.0.0.1:53180
+  tenants:
+    - name: smiths
+      zones: [example.com, example.org]
+      categories: [blocklist, adult]
+acme:
+  dir: `+dir+`
+  domains:
+    - "*.example.com"
+    - dns.example.net
+`)
+	cfg, err := Load(cfgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verr, ok := cfg.Validate().(*ValidationError)
+	if !ok {
+		t.Fatalf("Validate() error = %v, want *ValidationError", cfg.Validate())
+	}
+	want := []Problem{
+		{File: cfgFile, Line: 10, Msg: fmt.Sprintf("admin: tenant \"smiths\": no zone example.org in %s", dir)},
+		{File: cfgFile, Line: 11, Msg: `admin: tenant "smiths": unknown blocklist category "adult"`},
+		{File: cfgFile, Line: 16, Msg: fmt.Sprintf("acme: dns.example.net is in no zone of %s, its challenges could not be answered", dir)},
+	}
+	if !reflect.DeepEqual(verr.Problems, want) {
+		t.Errorf("Validate() problems:\n%v\nwant\n%v", verr.Problems, want)
+	}
+}
+
+func TestLoadZonesRecords(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "z.yml"), `origin: example.com.
+ttl: -1
+soa:
+  mname: ns1..example.com.
+  serial: soon
+a:
+  - name: www..x
+    value: 192.0.2.1
+  - name: www.example.org.
+    value: 192.0.2.1
+  - name: ok
+    ttl: 4294967295
+    value: 192.0.2.1
+txt:
+  - name: long
+    value: `+strings.Repeat("x", 300)+`
+mx:
+  - host: mail server
+ns:
+  - host: ns1.example.com.
+`)
+	_, err := LoadZones(dir, nil)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("LoadZones() error = %v, want *ValidationError", err)
+	}
+	want := []struct {
+		line int
+		msg  string
+	}{
+		{2, "ttl -1 out of range 0-2147483647"},
+		{4, `soa mname "ns1..example.com.": empty label`},
+		{5, "soa serial soon is not a 32 bit unsigned integer"},
+		{7, `a record name "www..x": empty label`},
+		{9, `a record name "www.example.org." is outside zone "example.com."`},
+		{12, "a record ttl 4294967295 exceeds 2147483647"},
+		{18, `mx host "mail server": contains whitespace or @`},
+	}
+	if len(verr.Problems) != len(want) {
+		t.Fatalf("LoadZones() got %d problems, want %d:\n%v", len(verr.Problems), len(want), err)
+	}
+	for i, p := range verr.Problems {
+		if p.Line != want[i].line || p.Msg != want[i].msg {
+			t.Errorf("problem %d = %d: %s, want %d: %s", i, p.Line, p.Msg, want[i].line, want[i].msg)
+		}
+	}
+}
+
+func TestLoadZonesNested(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.yml"), `origin: example.com.
+a:
+  - name: www
+    value: 192.0.2.1
+`)
+	writeFile(t, filepath.Join(dir, "b.yml"), `origin: sub.example.com.
+a:
+  - name: www
+    value: 192.0.2.2
+`)
+	writeFile(t, filepath.Join(dir, "c.yml"), `origin: Example.COM.
+`)
+	zones, err := LoadZones(dir, nil)
+	verr, ok := err.(*ValidationError)
+	if !ok || len(verr.Problems) != 1 || verr.Problems[0].Line != 1 ||
+		!strings.HasPrefix(verr.Problems[0].Msg, `duplicate origin "Example.COM."`) {
+		t.Fatalf("LoadZones() error = %v, want only the duplicate origin", err)
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"a.b.example.com.", "example.com."},
+		{"sub.example.com.", "sub.example.com."},
+		{"www.sub.example.com.", "sub.example.com."},
+	}
+	for _, tt := range tests {
+		if zone, _ := dns.FindZone(zones, tt.name); zone.Origin != tt.want {
+			t.Errorf("FindZone(%q) = %q, want %q", tt.name, zone.Origin, tt.want)
+		}
+	}
+}
+
+func TestLoadZonesOptions(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "z.yml"), `origin: corp.example.
+authoritative: false
+min_ttl: 4294967295
+forward:
+  - address: 10.0.0.53:53
+  - address: nowhere
+    retries: -1
+transfer:
+  - 10.0.0.0/8
+  - 10.0.0.1
+`)
+	zones, err := LoadZones(dir, nil)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("LoadZones() error = %v, want *ValidationError", err)
+	}
+	want := []struct {
+		line int
+		msg  string
+	}{
+		{3, "min_ttl 4294967295 exceeds 2147483647"},
+		{6, `invalid forward address "nowhere": address nowhere: missing port in address`},
+		{6, `forward "nowhere" timeout, retries and backoff must not be negative`},
+		{10, `invalid CIDR "10.0.0.1"`},
+	}
+	if len(verr.Problems) != len(want) {
+		t.Fatalf("LoadZones() got %d problems, want %d:\n%v", len(verr.Problems), len(want), err)
+	}
+	for i, p := range verr.Problems {
+		if p.Line != want[i].line || p.Msg != want[i].msg {
+			t.Errorf("problem %d = %d: %s, want %d: %s", i, p.Line, p.Msg, want[i].line, want[i].msg)
+		}
+	}
+	if zone := zones["corp.example."]; zone.IsAuthoritative() || len(zone.Forward) != 2 {
+		t.Errorf("zone options = authoritative %v, %d forwarders", zone.IsAuthoritative(), len(zone.Forward))
+	}
+}
+
+func TestLoadZonesServiceRecords(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "z.yml"), `origin: example.com.
+srv:
+  - name: _sip._udp
+    port: 5060
+    target: sip
+  - name: _sip._tcp
+    port: 5060
+naptr:
+  - flags: S
+    service: SIP+D2U
+    replacement: _sip._udp
+  - flags: U
+    service: E2U+sip
+    regexp: "!^.*$!sip:info@example.com!"
+    replacement: _sip._udp
+  - flags: S+
+    service: SIP+D2T
+  - flags: U
+    regexp: "!^.*$!sip:info@example.com!"
+tlsa:
+  - name: _443._tcp.www
+    usage: 4
+    selector: 1
+    matching_type: 1
+    data: abcd
+  - name: _25._tcp.mail
+    matching_type: 0
+    data: xyz
+generic:
+  - name: opaque
+    type: TYPE65280
+    data: '\# 3 0102'
+  - type: OPT
+    data: '\# 0'
+  - type: FOO
+    data: "0102"
+`)
+	_, err := LoadZones(dir, nil)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("LoadZones() error = %v, want *ValidationError", err)
+	}
+	want := []struct {
+		line int
+		msg  string
+	}{
+		{6, "srv record missing target"},
+		{12, "naptr record has both a regexp and a replacement"},
+		{16, `naptr flags "S+" must be letters or digits`},
+		{16, "naptr record needs a regexp or a replacement"},
+		{22, "tlsa usage 4 out of range 0-3"},
+		{25, "tlsa data of 2 bytes, matching_type 1 needs 32"},
+		{28, `tlsa data "xyz" is not hex`},
+		{32, "generic record data: rdata of 2 bytes, length says 3"},
+		{33, "generic record type OPT can not be stored in a zone"},
+		{35, `generic record type "FOO" is not a mnemonic or TYPEnnn`},
+		{36, `generic record data: rdata "0102" is not of the form \# length hex`},
+	}
+	if len(verr.Problems) != len(want) {
+		t.Fatalf("LoadZones() got %d problems, want %d:\n%v", len(verr.Problems), len(want), err)
+	}
+	for i, p := range verr.Problems {
+		if p.Line != want[i].line || p.Msg != want[i].msg {
+			t.Errorf("problem %d = %d: %s, want %d: %s", i, p.Line, p.Msg, want[i].line, want[i].msg)
+		}
+	}
+}
+
+func TestWriteZone(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "corp.yml")
+	writeFile(t, file, `# corp zone
+origin: corp.example.
+ttl: ${TTL:-300} # default
+soa:
+  mname: ns1
+  rname: admin
+  serial: 7
+a:
+  # the router
+  - name: "@"
+    value: ${LAN_IP}
+  - name: www
+    value: 10.0.0.2
+    ttl: 0
+`)
+	values := map[string]string{"LAN_IP": "10.0.0.1"}
+	zones, err := LoadZones(dir, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone := zones["corp.example."]
+	zone.SetSerial(8)
+	zone.A = append(zone.A, dns.Record{Name: "nas", Value: "10.0.0.3"})
+	zone.TXT = []dns.Record{{Name: "@", Value: "v=spf1 -all"}}
+	zone.Index()
+	if err := WriteZone(file, zone, values); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# corp zone
+origin: corp.example.
+ttl: ${TTL:-300} # default
+soa:
+  mname: ns1
+  rname: admin
+  serial: 8
+a:
+  # the router
+  - name: "@"
+    value: ${LAN_IP}
+  - name: www
+    value: 10.0.0.2
+    ttl: 0
+  - name: nas
+    value: 10.0.0.3
+txt:
+  - name: '@'
+    value: v=spf1 -all
+`
+	if string(data) != want {
+		t.Errorf("written zone:\n%s\nwant:\n%s", data, want)
+	}
+	reloaded, err := LoadZones(dir, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded["corp.example."]; !reflect.DeepEqual(got, zone) {
+		t.Errorf("zone read back %+v, want %+v", got, zone)
+	}
+
+	// a new file holds only the settings of the zone
+	file = filepath.Join(t.TempDir(), "corp.yml")
+	if err := WriteZone(file, zone, nil); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err = LoadZones(filepath.Dir(file), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded["corp.example."]; !reflect.DeepEqual(got, zone) {
+		t.Errorf("new zone file read back %+v, want %+v", got, zone)
+	}
+}
+
+func TestZoneRecordMeta(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "corp.yml")
+	writeFile(t, file, `origin: corp.example.
+a:
+  - name: nas
+    value: 10.0.0.3
+    comment: backups
+    owner: alice
+    ticket: OPS-42
+    labels:
+      env: prod
+  - name: demo
+    value: 10.0.0.9
+    expires: 2026-11-01
+`)
+	zones, err := LoadZones(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone := zones["corp.example."]
+	meta := zone.A[0].RecordMeta
+	if meta.Comment != "backups" || meta.Owner != "alice" || meta.Ticket != "OPS-42" || meta.Labels["env"] != "prod" || zone.A[1].Expires != "2026-11-01" {
+		t.Errorf("records %+v, want their metadata", zone.A)
+	}
+
+	zone.A[0].Value = "10.0.0.4"
+	zone.Index()
+	if err := WriteZone(file, zone, nil); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadZones(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded["corp.example."]; !reflect.DeepEqual(got, zone) {
+		t.Errorf("zone read back %+v, want %+v", got, zone)
+	}
+
+	writeFile(t, file, `origin: corp.example.
+a:
+  - name: demo
+    value: 10.0.0.9
+    expires: next week
+  - name: cutover
+    value: 10.0.0.10
+    activates: 2026-11-01T02:00:00+01:00
+    expires: 2026-11-01T01:00:00Z
+`)
+	_, err = LoadZones(dir, nil)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("LoadZones() error = %v, want *ValidationError", err)
+	}
+	want := []struct {
+		line int
+		msg  string
+	}{
+		{5, `a record expires "next week": not a date like 2026-11-01 or an RFC 3339 time`},
+		{9, "a record expires 2026-11-01T01:00:00Z, not after it activates 2026-11-01T02:00:00+01:00"},
+	}
+	if len(verr.Problems) != len(want) {
+		t.Fatalf("LoadZones() got %d problems, want %d:\n%v", len(verr.Problems), len(want), err)
+	}
+	for i, p := range verr.Problems {
+		if p.Line != want[i].line || p.Msg != want[i].msg {
+			t.Errorf("problem %d = %d: %s, want %d: %s", i, p.Line, p.Msg, want[i].line, want[i].msg)
+		}
+	}
+}
+
+func TestZoneHealthChecks(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "z.yml"), `origin: example.com.
+a:
+  - name: www
+    value: 10.0.0.1
+    health:
+      type: https
+      path: /healthz
+      interval: 5s
+  - name: www
+    value: 10.0.0.2
+    health: {type: tcp}
+  - name: www
+    value: 10.0.0.9
+    fallback: true
+txt:
+  - value: hello
+    fallback: true
+`)
+	zones, err := LoadZones(dir, nil)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("LoadZones() error = %v, want *ValidationError", err)
+	}
+	want := []struct {
+		line int
+		msg  string
+	}{
+		{11, "a record health: tcp check needs a port"},
+		{16, "txt records take no health check, fallback or weight"},
+	}
+	if len(verr.Problems) != len(want) {
+		t.Fatalf("LoadZones() got %d problems, want %d:\n%v", len(verr.Problems), len(want), err)
+	}
+	for i, p := range verr.Problems {
+		if p.Line != want[i].line || p.Msg != want[i].msg {
+			t.Errorf("problem %d = %d: %s, want %d: %s", i, p.Line, p.Msg, want[i].line, want[i].msg)
+		}
+	}
+	zone := zones["example.com."]
+	if h := zone.A[0].Health; h == nil || h.Type != "https" || h.Interval != 5*time.Second || !zone.A[2].Fallback {
+		t.Errorf("records %+v, want the https check and the fallback", zone.A)
+	}
+}
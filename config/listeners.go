@@ -0,0 +1,276 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// listener protocols
+const (
+	UDP = "udp"
+	TCP = "tcp"
+	// DoT is DNS over TLS, RFC 7858
+	DoT = "dot"
+	// DoH is DNS over HTTPS, RFC 8484
+	DoH = "doh"
+)
+
+// DefaultDoHPath is where DoH listeners answer when no path is set
+const DefaultDoHPath = "/dns-query"
+
+// Listener is an address the server answers queries on. Every listener
+// shares the same zones, cache, blocklist and upstreams.
+type Listener struct {
+	// Name labels the listener in logs and stats, protocol/address by default
+	Name     string `yaml:"name"`
+	Protocol string `yaml:"protocol"`
+	Address  string `yaml:"address"`
+	// Cert and Key are the TLS certificate of dot and doh listeners. A doh
+	// listener without them serves plain HTTP, e.g. behind a proxy.
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	// ACME serves the certificate obtained by the acme settings instead
+	// of Cert and Key
+	ACME bool `yaml:"acme"`
+	// Path is the URL path of doh listeners
+	Path string `yaml:"path"`
+	// ProxyProtocol reads the PROXY protocol v2 header a load balancer
+	// sends ahead of tcp and dot connections, taking the client address
+	// from it for the allow list, rate limits and logs
+	ProxyProtocol bool `yaml:"proxy_protocol"`
+	// ProxyFrom are the networks of the load balancers. Connections from
+	// other addresses are served without a header, every connection must
+	// send one when empty.
+	ProxyFrom []string `yaml:"proxy_from"`
+}
+
+// DefaultIdleTimeout closes tcp, dot and doh connections without queries
+// when the protocol sets no idle timeout
+const DefaultIdleTimeout = 10 * time.Second
+
+// ConnectionLimits bound the connections of the listeners of one stream
+// protocol, so a flood of connections over one protocol leaves the others
+// served
+type ConnectionLimits struct {
+	// MaxConnections caps the connections open at once across all
+	// listeners of the protocol, unlimited when zero. Connections past it
+	// are closed as they are accepted.
+	MaxConnections int `yaml:"max_connections"`
+	// IdleTimeout closes connections without queries, DefaultIdleTimeout
+	// when zero
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// MaxQueries closes a connection once it was sent that many queries,
+	// unlimited when zero
+	MaxQueries int `yaml:"max_queries"`
+}
+
+// Validate reports problems with the limits
+func (cl ConnectionLimits) Validate() []error {
+	var errs []error
+	if cl.MaxConnections < 0 || cl.MaxQueries < 0 || cl.IdleTimeout < 0 {
+		errs = append(errs, errors.New("max_connections, idle_timeout and max_queries must not be negative"))
+	}
+	return errs
+}
+
+// SocketOptions tune the sockets of every listener. Zero values leave the
+// defaults of the OS.
+type SocketOptions struct {
+	// ReceiveBuffer and SendBuffer size the kernel buffers in bytes,
+	// SO_RCVBUF and SO_SNDBUF, so bursts of UDP queries are not dropped.
+	// Without CAP_NET_ADMIN Linux caps them at net.core.rmem_max and
+	// net.core.wmem_max.
+	ReceiveBuffer int `yaml:"receive_buffer"`
+	SendBuffer    int `yaml:"send_buffer"`
+	// TOS is the IPv4 type of service and IPv6 traffic class of the
+	// replies, like 0xb8 for DSCP EF
+	TOS int `yaml:"tos"`
+	// DontFragment sends UDP replies without letting routers fragment
+	// them, replies over the path MTU being dropped instead
+	DontFragment bool `yaml:"dont_fragment"`
+}
+
+// maxSocketBuffer bounds the socket buffers asked for
+const maxSocketBuffer = 1 << 30
+
+// Validate reports problems with the options
+func (o SocketOptions) Validate() []error {
+	var errs []error
+	if o.ReceiveBuffer < 0 || o.SendBuffer < 0 || o.ReceiveBuffer > maxSocketBuffer || o.SendBuffer > maxSocketBuffer {
+		errs = append(errs, fmt.Errorf("receive_buffer and send_buffer must be between 0 and %d", maxSocketBuffer))
+	}
+	if o.TOS < 0 || o.TOS > 255 {
+		errs = append(errs, fmt.Errorf("tos must be between 0 and 255, got %d", o.TOS))
+	}
+	return errs
+}
+
+// ConnectionLimits returns the limits of the connections of protocol, with
+// the default idle timeout filled in
+func (c *Config) ConnectionLimits(protocol string) ConnectionLimits {
+	limits := c.Connections[protocol]
+	if limits.IdleTimeout == 0 {
+		limits.IdleTimeout = DefaultIdleTimeout
+	}
+	return limits
+}
+
+// UnmarshalYAML also accepts "protocol://address" as a shorthand
+func (l *Listener) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		protocol, address, ok := strings.Cut(node.Value, "://")
+		if !ok {
+			return fmt.Errorf("line %d: listener %q is not protocol://address", node.Line, node.Value)
+		}
+		*l = Listener{Protocol: protocol, Address: address}
+		return nil
+	}
+	type listener Listener
+	return node.Decode((*listener)(l))
+}
+
+// Label returns the name of the listener, protocol/address when unnamed
+func (l Listener) Label() string {
+	if l.Name != "" {
+		return l.Name
+	}
+	return l.Protocol + "/" + l.Address
+}
+
+// Encrypted reports whether clients reach the listener over TLS, counting
+// doh listeners without a certificate as behind a TLS proxy
+func (l Listener) Encrypted() bool {
+	return l.Protocol == DoT || l.Protocol == DoH
+}
+
+// TLS reports whether the listener terminates TLS itself
+func (l Listener) TLS() bool {
+	return l.Cert != "" || l.ACME
+}
+
+// ProxyNets returns the parsed networks of ProxyFrom, skipping invalid
+// entries
+func (l Listener) ProxyNets() []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(l.ProxyFrom))
+	for _, cidr := range l.ProxyFrom {
+		if _, ipnet, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, ipnet)
+		}
+	}
+	return nets
+}
+
+// network returns the network the listener binds, udp or tcp
+func (l Listener) network() string {
+	if l.Protocol == UDP {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// Validate reports problems with the listener without binding it
+func (l Listener) Validate() []error {
+	var errs []error
+	switch l.Protocol {
+	case UDP, TCP, DoT, DoH:
+	default:
+		errs = append(errs, fmt.Errorf("unknown protocol %q, want udp, tcp, dot or doh", l.Protocol))
+	}
+	if _, _, err := net.SplitHostPort(l.Address); err != nil {
+		errs = append(errs, fmt.Errorf("invalid address %q: %v", l.Address, err))
+	}
+	if (l.Cert == "") != (l.Key == "") {
+		errs = append(errs, errors.New("needs both cert and key"))
+	}
+	if l.Protocol == DoT && !l.TLS() {
+		errs = append(errs, errors.New("dot needs a cert and key, or acme"))
+	}
+	if l.ACME && l.Cert != "" {
+		errs = append(errs, errors.New("takes cert and key or acme, not both"))
+	}
+	if l.ACME && l.Protocol != DoT && l.Protocol != DoH {
+		errs = append(errs, errors.New("acme needs protocol dot or doh"))
+	}
+	if l.Path != "" && (l.Protocol != DoH || !strings.HasPrefix(l.Path, "/")) {
+		errs = append(errs, fmt.Errorf("path %q needs protocol doh and a leading /", l.Path))
+	}
+	if (l.ProxyProtocol || len(l.ProxyFrom) > 0) && l.Protocol != TCP && l.Protocol != DoT {
+		errs = append(errs, errors.New("proxy_protocol needs protocol tcp or dot"))
+	}
+	if len(l.ProxyFrom) > 0 && !l.ProxyProtocol {
+		errs = append(errs, errors.New("proxy_from needs proxy_protocol"))
+	}
+	for _, cidr := range l.ProxyFrom {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid proxy_from network %q: %v", cidr, err))
+		}
+	}
+	return errs
+}
+
+// Listening returns the listeners to serve on, a single UDP listener on
+// Listen when none are configured
+func (c *Config) Listening() []Listener {
+	if len(c.Listeners) == 0 {
+		return []Listener{{Protocol: UDP, Address: c.Listen}}
+	}
+	listeners := make([]Listener, len(c.Listeners))
+	for i, l := range c.Listeners {
+		if l.Protocol == DoH && l.Path == "" {
+			l.Path = DefaultDoHPath
+		}
+		listeners[i] = l
+	}
+	return listeners
+}
+
+// validateListeners adds the problems of the connection limits and of every
+// listener, including names and addresses used twice
+func (c *Config) validateListeners(verr *ValidationError) {
+	for _, protocol := range slices.Sorted(maps.Keys(c.Connections)) {
+		limits := c.Connections[protocol]
+		line := lineOf(c.root, "connections", protocol)
+		if protocol != TCP && protocol != DoT && protocol != DoH {
+			verr.add(c.path, line, "connections: unknown protocol %q, want tcp, dot or doh", protocol)
+		}
+		for _, err := range limits.Validate() {
+			verr.add(c.path, line, "connections %s: %v", protocol, err)
+		}
+	}
+	for _, err := range c.Socket.Validate() {
+		verr.add(c.path, lineOf(c.root, "socket"), "socket: %v", err)
+	}
+	if len(c.Listeners) == 0 {
+		if _, err := net.ResolveUDPAddr("udp", c.Listen); err != nil {
+			verr.add(c.path, lineOf(c.root, "listen"), "invalid listen address %q: %v", c.Listen, err)
+		}
+		return
+	}
+	names := make(map[string]bool)
+	bound := make(map[string]bool)
+	for i, l := range c.Listeners {
+		line := lineOf(c.root, "listeners", i)
+		for _, err := range l.Validate() {
+			verr.add(c.path, line, "listener %s: %v", l.Label(), err)
+		}
+		if l.ACME && !c.ACME.Enabled() {
+			verr.add(c.path, line, "listener %s: acme needs acme domains", l.Label())
+		}
+		if names[l.Label()] {
+			verr.add(c.path, line, "listener %s: name used twice", l.Label())
+		}
+		names[l.Label()] = true
+		if addr := l.network() + " " + l.Address; bound[addr] {
+			verr.add(c.path, line, "listener %s: %s address %s used twice", l.Label(), l.network(), l.Address)
+		} else {
+			bound[addr] = true
+		}
+	}
+}
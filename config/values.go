@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadValues returns the variables zone files may reference: the mapping
+// in the values file, if any, overridden by the environment
+func LoadValues(file string) (map[string]string, error) {
+	values := make(map[string]string)
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	for _, kv := range os.Environ() {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			values[name] = value
+		}
+	}
+	return values, nil
+}
+
+// expandNode substitutes variables in every scalar under node, reporting
+// undefined ones at their line
+func expandNode(file string, node *yaml.Node, values map[string]string, verr *ValidationError) {
+	if node.Kind == yaml.ScalarNode {
+		expanded, err := expand(node.Value, values)
+		if err != nil {
+			verr.add(file, node.Line, "%v", err)
+			return
+		}
+		if expanded != node.Value && node.Style == 0 {
+			// let plain scalars resolve again, e.g. ttl: ${TTL} to an int
+			node.Tag = ""
+		}
+		node.Value = expanded
+		return
+	}
+	for _, child := range node.Content {
+		expandNode(file, child, values, verr)
+	}
+}
+
+// expand replaces ${NAME} and ${NAME:-default} in s with values, and $$
+// with a literal $. Any other $ is left alone.
+func expand(s string, values map[string]string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 || i+1 == len(s) {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			s = s[i+2:]
+			continue
+		case '{':
+		default:
+			b.WriteByte('$')
+			s = s[i+1:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable in %q", s[i:])
+		}
+		ref := s[i+2 : i+end]
+		name, fallback, hasDefault := strings.Cut(ref, ":-")
+		if name == "" {
+			return "", fmt.Errorf("empty variable name in %q", s[i:i+end+1])
+		}
+		value, ok := values[name]
+		switch {
+		case ok && value != "":
+		case hasDefault:
+			value = fallback
+		case !ok:
+			return "", fmt.Errorf("undefined variable %q", name)
+		}
+		b.WriteString(value)
+		s = s[i+end+1:]
+	}
+}
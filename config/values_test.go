@@ -0,0 +1,75 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bernoussama/mercury/dns"
+)
+
+func TestExpand(t *testing.T) {
+	values := map[string]string{"LAN_IP": "192.168.1.10", "EMPTY": ""}
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "plain", false},
+		{"${LAN_IP}", "192.168.1.10", false},
+		{"host-${LAN_IP}-x", "host-192.168.1.10-x", false},
+		{"${MISSING:-10.0.0.1}", "10.0.0.1", false},
+		{"${EMPTY:-fallback}", "fallback", false},
+		{"${EMPTY}", "", false},
+		{"$$LAN_IP costs $5", "$LAN_IP costs $5", false},
+		{"trailing $", "trailing $", false},
+		{"${MISSING}", "", true},
+		{"${LAN_IP", "", true},
+		{"${}", "", true},
+	}
+	for _, tt := range tests {
+		got, err := expand(tt.in, values)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("expand(%q) = %q, %v, want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadZonesValues(t *testing.T) {
+	dir := t.TempDir()
+	valuesFile := filepath.Join(dir, "values.yml")
+	writeFile(t, valuesFile, "LAN_IP: 192.168.1.10\nDOMAIN: home.arpa\n")
+	zonesDir := filepath.Join(dir, "zones")
+	if err := os.Mkdir(zonesDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(zonesDir, "home.yml"), "origin: ${DOMAIN}.\na:\n  - name: nas\n    ttl: ${TTL:-300}\n    value: ${LAN_IP}\n")
+
+	// the environment overrides the values file
+	t.Setenv("LAN_IP", "10.0.0.2")
+	values, err := LoadValues(valuesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zones, err := LoadZones(zonesDir, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone, ok := zones["home.arpa."]
+	if !ok {
+		t.Fatalf("zones = %v, want home.arpa.", zones)
+	}
+	if a := zone.A[0]; a.Value != "10.0.0.2" || a.TTL != 300 {
+		t.Errorf("record = %+v, want 10.0.0.2 with ttl 300", a)
+	}
+	if got := zone.Lookup("nas.home.arpa.", dns.TypeA, 1); len(got) != 1 {
+		t.Errorf("lookup nas.home.arpa. = %v", got)
+	}
+
+	writeFile(t, filepath.Join(zonesDir, "home.yml"), "origin: home.arpa.\na:\n  - name: nas\n    value: ${NAS_IP}\n")
+	_, err = LoadZones(zonesDir, values)
+	verr, ok := err.(*ValidationError)
+	if !ok || len(verr.Problems) != 1 || verr.Problems[0].Line != 4 || verr.Problems[0].Msg != `undefined variable "NAS_IP"` {
+		t.Errorf("LoadZones() error = %v, want undefined NAS_IP at line 4", err)
+	}
+}
@@ -0,0 +1,404 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/dns"
+	"github.com/bernoussama/mercury/fqdn"
+	"gopkg.in/yaml.v3"
+)
+
+// zoneKeys are the top level keys allowed in a zone file
+var zoneKeys = map[string]bool{
+	"origin":  true,
+	"ttl":     true,
+	"soa":     true,
+	"ns":      true,
+	"a":       true,
+	"aaaa":    true,
+	"txt":     true,
+	"mx":      true,
+	"srv":     true,
+	"naptr":   true,
+	"tlsa":    true,
+	"generic": true,
+
+	"min_ttl":       true,
+	"authoritative": true,
+	"forward":       true,
+	"transfer":      true,
+	"notify":        true,
+}
+
+// zoneFile is a parsed zone along with where it came from
+type zoneFile struct {
+	zone dns.Zone
+	file string
+	root *yaml.Node
+}
+
+// LoadZones reads every *.yml zone file in dir, substituting ${NAME} and
+// ${NAME:-default} with values. When any zone is invalid it returns a
+// *ValidationError describing all of them.
+func LoadZones(dir string, values map[string]string) (map[string]dns.Zone, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yml"))
+	if err != nil {
+		return nil, err
+	}
+	verr := &ValidationError{}
+	parsed := make([]zoneFile, 0, len(files))
+	for _, file := range files {
+		zf, ok := parseZone(file, values, verr)
+		if ok {
+			parsed = append(parsed, zf)
+		}
+	}
+	unique := checkOrigins(parsed, verr)
+
+	zones := make(map[string]dns.Zone, len(unique))
+	for _, zf := range unique {
+		zf.zone.Index()
+		zones[string(fqdn.Canonical(zf.zone.Origin))] = zf.zone
+	}
+	return zones, verr.err()
+}
+
+// LoadZone reads the zone file at file, substituting values like
+// LoadZones. An invalid zone is reported as a *ValidationError.
+func LoadZone(file string, values map[string]string) (dns.Zone, error) {
+	verr := &ValidationError{}
+	zf, ok := parseZone(file, values, verr)
+	if !ok {
+		return dns.Zone{}, verr.err()
+	}
+	zf.zone.Index()
+	return zf.zone, verr.err()
+}
+
+func parseZone(file string, values map[string]string, verr *ValidationError) (zoneFile, bool) {
+	zf := zoneFile{file: file}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		verr.add(file, 0, "%v", err)
+		return zf, false
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		verr.add(file, 0, "%v", err)
+		return zf, false
+	}
+	if len(root.Content) == 0 {
+		verr.add(file, 0, "empty zone file")
+		return zf, false
+	}
+	zf.root = root.Content[0]
+	problems := len(verr.Problems)
+	expandNode(file, zf.root, values, verr)
+	if len(verr.Problems) > problems {
+		return zf, false
+	}
+	if err := zf.root.Decode(&zf.zone); err != nil {
+		verr.add(file, 0, "%v", err)
+		return zf, false
+	}
+
+	if zf.root.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(zf.root.Content); i += 2 {
+			key := zf.root.Content[i]
+			if !zoneKeys[key.Value] {
+				verr.add(file, key.Line, "unknown record type %q", key.Value)
+			}
+		}
+	}
+
+	ok := true
+	switch {
+	case zf.zone.Origin == "":
+		verr.add(file, zf.root.Line, "missing origin")
+		ok = false
+	case !strings.HasSuffix(zf.zone.Origin, "."):
+		verr.add(file, lineOf(zf.root, "origin"), "origin %q must be fully qualified (end with a dot)", zf.zone.Origin)
+	}
+	if ok {
+		checkRecords(zf, verr)
+		checkOptions(zf, verr)
+	}
+	return zf, ok
+}
+
+// soaTimers are the numeric fields of the SOA record
+var soaTimers = []string{"serial", "refresh", "retry", "expire", "minimum"}
+
+// checkRecords reports records whose owner, TTL or value can not be
+// encoded, at the line of the offending field
+func checkRecords(zf zoneFile, verr *ValidationError) {
+	z := zf.zone
+	if z.TTL < 0 || z.TTL > dns.MaxTTL {
+		verr.add(zf.file, lineOf(zf.root, "ttl"), "ttl %d out of range 0-%d", z.TTL, dns.MaxTTL)
+	}
+	for _, field := range []string{"mname", "rname"} {
+		if name, ok := z.SOA[field]; ok {
+			host, _ := name.(string)
+			if err := checkName(host); err != nil {
+				verr.add(zf.file, lineOf(zf.root, "soa", field), "soa %s %q: %v", field, name, err)
+			}
+		}
+	}
+	for _, field := range soaTimers {
+		if value, ok := z.SOA[field]; ok {
+			if n, isInt := value.(int); !isInt || n < 0 || int64(n) > math.MaxUint32 {
+				verr.add(zf.file, lineOf(zf.root, "soa", field), "soa %s %v is not a 32 bit unsigned integer", field, value)
+			}
+		}
+	}
+
+	// checkTime parses the activation or expiry of a record, false when it
+	// has none or it does not parse
+	checkTime := func(key string, i int, field, value string) (time.Time, bool) {
+		if value == "" {
+			return time.Time{}, false
+		}
+		t, err := dns.ParseRecordTime(value)
+		if err != nil {
+			verr.add(zf.file, lineOf(zf.root, key, i, field), "%s record %s %q: %v", key, field, value, err)
+			return time.Time{}, false
+		}
+		return t, true
+	}
+	// owner checks the owner name, TTL and metadata shared by every record
+	// type
+	owner := func(key string, i int, name string, ttl uint32, meta dns.RecordMeta) {
+		if err := checkName(z.Fqdn(name)); err != nil {
+			verr.add(zf.file, lineOf(zf.root, key, i, "name"), "%s record name %q: %v", key, name, err)
+		} else if !dns.IsSubdomain(z.Fqdn(name), z.Origin) {
+			verr.add(zf.file, lineOf(zf.root, key, i, "name"), "%s record name %q is outside zone %q", key, name, z.Origin)
+		}
+		if ttl > dns.MaxTTL {
+			verr.add(zf.file, lineOf(zf.root, key, i, "ttl"), "%s record ttl %d exceeds %d", key, ttl, dns.MaxTTL)
+		}
+		activates, activated := checkTime(key, i, "activates", meta.Activates)
+		if expires, ok := checkTime(key, i, "expires", meta.Expires); ok && activated && !activates.Before(expires) {
+			verr.add(zf.file, lineOf(zf.root, key, i, "expires"), "%s record expires %s, not after it activates %s", key, meta.Expires, meta.Activates)
+		}
+	}
+	// health checks the health check of an address record
+	health := func(key string, i int, record dns.Record) {
+		if record.Health == nil {
+			return
+		}
+		for _, err := range record.Health.Validate() {
+			verr.add(zf.file, lineOf(zf.root, key, i, "health"), "%s record health: %v", key, err)
+		}
+	}
+	for i, record := range z.A {
+		owner("a", i, record.Name, record.TTL, record.RecordMeta)
+		health("a", i, record)
+		ip := net.ParseIP(record.Value)
+		if ip == nil || ip.To4() == nil {
+			verr.add(zf.file, lineOf(zf.root, "a", i, "value"), "invalid IPv4 address %q", record.Value)
+		}
+	}
+	for i, record := range z.AAAA {
+		owner("aaaa", i, record.Name, record.TTL, record.RecordMeta)
+		health("aaaa", i, record)
+		ip := net.ParseIP(record.Value)
+		if ip == nil || ip.To4() != nil {
+			verr.add(zf.file, lineOf(zf.root, "aaaa", i, "value"), "invalid IPv6 address %q", record.Value)
+		}
+	}
+	for i, record := range z.TXT {
+		owner("txt", i, record.Name, record.TTL, record.RecordMeta)
+		if record.Health != nil || record.Fallback || record.Weight != 0 {
+			verr.add(zf.file, lineOf(zf.root, "txt", i), "txt records take no health check, fallback or weight")
+		}
+		// each 255 octet string costs a length octet
+		if size := len(record.Value) + len(record.Value)/255 + 1; size > 0xFFFF {
+			verr.add(zf.file, lineOf(zf.root, "txt", i, "value"), "txt value of %d bytes exceeds the 65535 byte record size", len(record.Value))
+		}
+	}
+	for i, record := range z.MX {
+		owner("mx", i, record.Name, record.TTL, record.RecordMeta)
+		if record.Host == "" {
+			verr.add(zf.file, lineOf(zf.root, "mx", i), "mx record missing host")
+		} else if err := checkName(z.Fqdn(record.Host)); err != nil {
+			verr.add(zf.file, lineOf(zf.root, "mx", i, "host"), "mx host %q: %v", record.Host, err)
+		}
+	}
+	for i, record := range z.SRV {
+		owner("srv", i, record.Name, record.TTL, record.RecordMeta)
+		if record.Target == "" {
+			verr.add(zf.file, lineOf(zf.root, "srv", i), "srv record missing target")
+		} else if err := checkName(z.Fqdn(record.Target)); err != nil {
+			verr.add(zf.file, lineOf(zf.root, "srv", i, "target"), "srv target %q: %v", record.Target, err)
+		}
+	}
+	for i, record := range z.NAPTR {
+		owner("naptr", i, record.Name, record.TTL, record.RecordMeta)
+		checkNAPTR(zf, i, record, verr)
+	}
+	for i, record := range z.TLSA {
+		owner("tlsa", i, record.Name, record.TTL, record.RecordMeta)
+		checkTLSA(zf, i, record, verr)
+	}
+	for i, record := range z.Generic {
+		owner("generic", i, record.Name, record.TTL, record.RecordMeta)
+		checkGeneric(zf, i, record, verr)
+	}
+	for i, record := range z.NS {
+		owner("ns", i, record.Name, record.TTL, record.RecordMeta)
+		if record.Host == "" {
+			verr.add(zf.file, lineOf(zf.root, "ns", i), "ns record missing host")
+			continue
+		}
+		child, host := z.Fqdn(record.Name), z.Fqdn(record.Host)
+		if err := checkName(host); err != nil {
+			verr.add(zf.file, lineOf(zf.root, "ns", i, "host"), "ns host %q: %v", record.Host, err)
+			continue
+		}
+		if strings.EqualFold(child, z.Origin) || !dns.IsSubdomain(host, child) {
+			continue
+		}
+		// in-bailiwick name server of a delegation needs glue
+		if len(z.Lookup(host, dns.TypeA, 1)) == 0 && len(z.Lookup(host, dns.TypeAAAA, 1)) == 0 {
+			verr.add(zf.file, lineOf(zf.root, "ns", i, "host"), "delegation of %q to %q has no glue address", child, host)
+		}
+	}
+}
+
+// checkOptions reports zone settings out of range, forwarders and
+// secondaries that can not be reached and transfer networks that do not
+// parse
+func checkOptions(zf zoneFile, verr *ValidationError) {
+	z := zf.zone
+	if z.MinTTL > dns.MaxTTL {
+		verr.add(zf.file, lineOf(zf.root, "min_ttl"), "min_ttl %d exceeds %d", z.MinTTL, dns.MaxTTL)
+	}
+	for i, upstream := range z.Forward {
+		if _, err := net.ResolveUDPAddr("udp", upstream.Address); err != nil {
+			verr.add(zf.file, lineOf(zf.root, "forward", i, "address"), "invalid forward address %q: %v", upstream.Address, err)
+		}
+		if upstream.Timeout < 0 || upstream.Backoff < 0 || upstream.Retries < 0 {
+			verr.add(zf.file, lineOf(zf.root, "forward", i), "forward %q timeout, retries and backoff must not be negative", upstream.Address)
+		}
+	}
+	for i, secondary := range z.Notify {
+		if _, err := net.ResolveUDPAddr("udp", secondary.Address); err != nil {
+			verr.add(zf.file, lineOf(zf.root, "notify", i, "address"), "invalid notify address %q: %v", secondary.Address, err)
+		}
+	}
+	for i, cidr := range z.Transfer {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			verr.add(zf.file, lineOf(zf.root, "transfer", i), "invalid CIDR %q", cidr)
+		}
+	}
+}
+
+// checkNAPTR reports NAPTR fields that can not be encoded or contradict
+// each other, RFC 3403 section 4.1
+func checkNAPTR(zf zoneFile, i int, record dns.NAPTRRecord, verr *ValidationError) {
+	for _, f := range []struct{ field, value string }{{"flags", record.Flags}, {"service", record.Service}, {"regexp", record.Regexp}} {
+		if len(f.value) > 255 {
+			verr.add(zf.file, lineOf(zf.root, "naptr", i, f.field), "naptr %s of %d bytes exceeds 255", f.field, len(f.value))
+		}
+	}
+	for _, c := range record.Flags {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			verr.add(zf.file, lineOf(zf.root, "naptr", i, "flags"), "naptr flags %q must be letters or digits", record.Flags)
+			break
+		}
+	}
+	replacement := record.Replacement != "" && record.Replacement != "."
+	switch {
+	case record.Regexp != "" && replacement:
+		verr.add(zf.file, lineOf(zf.root, "naptr", i), "naptr record has both a regexp and a replacement")
+	case record.Regexp == "" && !replacement:
+		verr.add(zf.file, lineOf(zf.root, "naptr", i), "naptr record needs a regexp or a replacement")
+	case replacement:
+		if err := checkName(zf.zone.Fqdn(record.Replacement)); err != nil {
+			verr.add(zf.file, lineOf(zf.root, "naptr", i, "replacement"), "naptr replacement %q: %v", record.Replacement, err)
+		}
+	}
+}
+
+// checkGeneric reports generic records of types that can not be served
+// or RDATA not in the RFC 3597 generic format
+func checkGeneric(zf zoneFile, i int, record dns.GenericRecord, verr *ValidationError) {
+	qtype, err := dns.ParseQType(record.Type)
+	switch {
+	case err != nil:
+		verr.add(zf.file, lineOf(zf.root, "generic", i, "type"), "generic record type %q is not a mnemonic or TYPEnnn", record.Type)
+	// OPT and the meta types of RFC 6895 are not data
+	case qtype == 0 || qtype == dns.TypeOPT || qtype >= 128 && qtype <= 255:
+		verr.add(zf.file, lineOf(zf.root, "generic", i, "type"), "generic record type %s can not be stored in a zone", qtype)
+	}
+	if _, err := dns.ParseGenericData(record.Data); err != nil {
+		verr.add(zf.file, lineOf(zf.root, "generic", i, "data"), "generic record data: %v", err)
+	}
+}
+
+// tlsaDigestSizes are the data sizes of the TLSA matching types hashing it
+var tlsaDigestSizes = map[uint8]int{1: sha256.Size, 2: sha512.Size}
+
+// checkTLSA reports TLSA fields out of the ranges of RFC 6698 section 2.1
+func checkTLSA(zf zoneFile, i int, record dns.TLSARecord, verr *ValidationError) {
+	if record.Usage > 3 {
+		verr.add(zf.file, lineOf(zf.root, "tlsa", i, "usage"), "tlsa usage %d out of range 0-3", record.Usage)
+	}
+	if record.Selector > 1 {
+		verr.add(zf.file, lineOf(zf.root, "tlsa", i, "selector"), "tlsa selector %d out of range 0-1", record.Selector)
+	}
+	if record.MatchingType > 2 {
+		verr.add(zf.file, lineOf(zf.root, "tlsa", i, "matching_type"), "tlsa matching_type %d out of range 0-2", record.MatchingType)
+	}
+	data, err := hex.DecodeString(strings.ReplaceAll(record.Data, " ", ""))
+	switch size, hashed := tlsaDigestSizes[record.MatchingType]; {
+	case err != nil || len(data) == 0:
+		verr.add(zf.file, lineOf(zf.root, "tlsa", i, "data"), "tlsa data %q is not hex", record.Data)
+	case hashed && len(data) != size:
+		verr.add(zf.file, lineOf(zf.root, "tlsa", i, "data"), "tlsa data of %d bytes, matching_type %d needs %d", len(data), record.MatchingType, size)
+	case len(data) > 0xFFFF-3:
+		verr.add(zf.file, lineOf(zf.root, "tlsa", i, "data"), "tlsa data of %d bytes exceeds the record size", len(data))
+	}
+}
+
+// checkName reports why name can not be encoded as a domain name
+func checkName(name string) error {
+	if name == "" {
+		return errors.New("empty name")
+	}
+	if strings.ContainsAny(name, " \t@") {
+		return errors.New("contains whitespace or @")
+	}
+	_, err := dns.EncodeDomainName(name)
+	return err
+}
+
+// checkOrigins reports zones sharing an origin and returns the first zone
+// of each origin. Nested zones are allowed, the closest enclosing zone
+// answers for a name.
+func checkOrigins(parsed []zoneFile, verr *ValidationError) []zoneFile {
+	sort.Slice(parsed, func(i, j int) bool { return parsed[i].file < parsed[j].file })
+	seen := make(map[fqdn.Name]zoneFile, len(parsed))
+	unique := make([]zoneFile, 0, len(parsed))
+	for _, zf := range parsed {
+		origin := fqdn.Canonical(zf.zone.Origin)
+		if prev, ok := seen[origin]; ok {
+			verr.add(zf.file, lineOf(zf.root, "origin"), "duplicate origin %q (already defined in %s:%d)",
+				zf.zone.Origin, prev.file, lineOf(prev.root, "origin"))
+			continue
+		}
+		seen[origin] = zf
+		unique = append(unique, zf)
+	}
+	return unique
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/bernoussama/mercury/dns"
+	"gopkg.in/yaml.v3"
+)
+
+// WriteZone writes zone to the zone file at path, replacing it atomically
+// through a temporary file renamed over it. The comments, key order and
+// ${NAME} references of the file replaced are kept wherever the zone holds
+// the values they stand for, values expanding them like for LoadZones.
+func WriteZone(path string, zone dns.Zone, values map[string]string) error {
+	var updated yaml.Node
+	if err := updated.Encode(zone); err != nil {
+		return err
+	}
+	prune(&updated)
+
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&updated}}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		var old yaml.Node
+		if yaml.Unmarshal(data, &old) == nil && len(old.Content) > 0 {
+			merge(old.Content[0], &updated, values)
+			doc = &old
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return replaceFile(path, buf.Bytes())
+}
+
+// durationKeys hold durations, encoded as 0s when unset
+var durationKeys = map[string]bool{"timeout": true, "backoff": true, "interval": true}
+
+// prune drops the unset settings from an encoded zone: nulls, empty
+// strings, lists and mappings, and zero numbers and durations. Zone files
+// leave them out.
+func prune(node *yaml.Node) {
+	for _, child := range node.Content {
+		prune(child)
+	}
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	content := node.Content[:0]
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if unset(value) || durationKeys[key.Value] && value.Value == "0s" {
+			continue
+		}
+		content = append(content, key, value)
+	}
+	node.Content = content
+}
+
+// unset reports whether an encoded value is the zero value of its type
+func unset(node *yaml.Node) bool {
+	switch node.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		return len(node.Content) == 0
+	case yaml.ScalarNode:
+		switch node.Tag {
+		case "!!null":
+			return true
+		case "!!str":
+			return node.Value == ""
+		case "!!int", "!!float":
+			return node.Value == "0"
+		}
+	}
+	return false
+}
+
+// merge updates the old node of a zone file in place to hold the values of
+// updated, keeping the comments and order of old and the scalars that
+// expand to the updated value
+func merge(old, updated *yaml.Node, values map[string]string) {
+	if old.Kind != updated.Kind || old.Kind == yaml.ScalarNode {
+		if old.Kind == yaml.ScalarNode && updated.Kind == yaml.ScalarNode {
+			if expanded, err := expand(old.Value, values); err == nil && expanded == updated.Value {
+				return
+			}
+		}
+		head, line, foot := old.HeadComment, old.LineComment, old.FootComment
+		*old = *updated
+		old.HeadComment, old.LineComment, old.FootComment = head, line, foot
+		return
+	}
+	switch old.Kind {
+	case yaml.MappingNode:
+		content := make([]*yaml.Node, 0, len(updated.Content))
+		for i := 0; i+1 < len(old.Content); i += 2 {
+			key, value := old.Content[i], old.Content[i+1]
+			if j := keyIndex(updated, key.Value); j >= 0 {
+				merge(value, updated.Content[j+1], values)
+				content = append(content, key, value)
+			} else if zeroValue(value, values) {
+				// left out of the encoding as unset, keep it as written
+				content = append(content, key, value)
+			}
+		}
+		for i := 0; i+1 < len(updated.Content); i += 2 {
+			if keyIndex(old, updated.Content[i].Value) < 0 {
+				content = append(content, updated.Content[i], updated.Content[i+1])
+			}
+		}
+		old.Content = content
+	case yaml.SequenceNode:
+		n := min(len(old.Content), len(updated.Content))
+		for i := range n {
+			merge(old.Content[i], updated.Content[i], values)
+		}
+		old.Content = append(old.Content[:n], updated.Content[n:]...)
+	}
+}
+
+// keyIndex returns the index of key in a mapping node, -1 without it
+func keyIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// zeroValue reports whether a node of a zone file holds a zero value once
+// expanded
+func zeroValue(node *yaml.Node, values map[string]string) bool {
+	if node.Kind != yaml.ScalarNode {
+		return len(node.Content) == 0
+	}
+	expanded, err := expand(node.Value, values)
+	if err != nil {
+		return false
+	}
+	switch expanded {
+	case "", "0", "0s", "~", "null":
+		return true
+	}
+	return false
+}
+
+// replaceFile replaces the file at path with data, through a temporary file
+// in the same directory renamed over it, keeping the mode of the file
+// replaced
+func replaceFile(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
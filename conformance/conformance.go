@@ -0,0 +1,411 @@
+// Package conformance runs a battery of queries against a DNS server with
+// the semantics of dig and delv, checking the behaviors the RFCs require of
+// it: TCP and UDP transport, truncation, NXDOMAIN, EDNS and more.
+package conformance
+
+import (
+	"context"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/client"
+	"github.com/bernoussama/mercury/dns"
+	"gopkg.in/yaml.v3"
+)
+
+// Origin is the zone the checks query, the server under test must serve
+// the zone returned by Zone
+const Origin = "conformance.test."
+
+// Timeout bounds a single check
+const Timeout = 2 * time.Second
+
+// ZoneFile is the zone in YAML, to copy to the zones of the server under
+// test
+//
+//go:embed conformance.test.yml
+var ZoneFile []byte
+
+// Zone returns the zone the checks query
+func Zone() (dns.Zone, error) {
+	var zone dns.Zone
+	if err := yaml.Unmarshal(ZoneFile, &zone); err != nil {
+		return zone, err
+	}
+	zone.Index()
+	return zone, nil
+}
+
+// Check is a behavior of the server under test
+type Check struct {
+	Name string
+	// RFC is the section specifying the behavior
+	RFC string
+	// Core checks are the RFC 1035 behaviors every server must get right,
+	// the others are reported without failing the run
+	Core bool
+	Run  func(ctx context.Context, server string) error
+}
+
+// Result is the outcome of a check, Err is nil when it passed
+type Result struct {
+	Check
+	Err error
+}
+
+// Report holds the results of a run in the order of Checks
+type Report []Result
+
+// Passed reports whether every core check passed
+func (r Report) Passed() bool {
+	for _, result := range r {
+		if result.Core && result.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Run runs every check against server, host:port answering over UDP and
+// TCP
+func Run(ctx context.Context, server string) Report {
+	report := make(Report, 0, len(Checks))
+	for _, check := range Checks {
+		checkCtx, cancel := context.WithTimeout(ctx, Timeout)
+		report = append(report, Result{Check: check, Err: check.Run(checkCtx, server)})
+		cancel()
+	}
+	return report
+}
+
+// names of the zone
+const (
+	www     = "www." + Origin
+	large   = "large." + Origin
+	missing = "missing." + Origin
+	empty   = "empty." + Origin
+)
+
+// Checks are run in order, the core ones first
+var Checks = []Check{
+	{Name: "udp", RFC: "1035 4.2.1", Core: true, Run: func(ctx context.Context, server string) error {
+		res, err := exchange(ctx, server, client.UDP, newQuery(www, dns.TypeA, false))
+		if err != nil {
+			return err
+		}
+		return answered(res, "192.0.2.1")
+	}},
+	{Name: "tcp", RFC: "1035 4.2.2", Core: true, Run: func(ctx context.Context, server string) error {
+		res, err := exchange(ctx, server, client.TCP, newQuery(www, dns.TypeA, false))
+		if err != nil {
+			return err
+		}
+		return answered(res, "192.0.2.1")
+	}},
+	{Name: "header", RFC: "1035 4.1.1", Core: true, Run: func(ctx context.Context, server string) error {
+		query := newQuery(www, dns.TypeA, false)
+		res, err := exchange(ctx, server, client.UDP, query)
+		if err != nil {
+			return err
+		}
+		switch {
+		case res.Header.QR != 1:
+			return errors.New("QR flag not set")
+		case res.Header.Opcode != query.Header.Opcode:
+			return fmt.Errorf("opcode %d, want %d", res.Header.Opcode, query.Header.Opcode)
+		case res.Header.QDCount != 1 || res.Question != query.Question:
+			return fmt.Errorf("question %+v, want %+v", res.Question, query.Question)
+		}
+		return nil
+	}},
+	{Name: "authoritative", RFC: "1035 4.1.1", Core: true, Run: func(ctx context.Context, server string) error {
+		res, err := exchange(ctx, server, client.UDP, newQuery(www, dns.TypeA, false))
+		if err != nil {
+			return err
+		}
+		if res.Header.AA != 1 {
+			return errors.New("AA flag not set on an answer from the zone")
+		}
+		return nil
+	}},
+	{Name: "case-insensitive", RFC: "1035 2.3.3", Core: true, Run: func(ctx context.Context, server string) error {
+		query := newQuery("wWw.CoNfOrMaNcE.tEsT.", dns.TypeA, false)
+		res, err := exchange(ctx, server, client.UDP, query)
+		if err != nil {
+			return err
+		}
+		if res.Question.DomainName != query.Question.DomainName {
+			return fmt.Errorf("question name %q, want the case of the query %q", res.Question.DomainName, query.Question.DomainName)
+		}
+		return answered(res, "192.0.2.1")
+	}},
+	{Name: "nxdomain", RFC: "1035 4.1.1", Core: true, Run: func(ctx context.Context, server string) error {
+		res, err := exchange(ctx, server, client.UDP, newQuery(missing, dns.TypeA, false))
+		if err != nil {
+			return err
+		}
+		return expect(res, dns.RcodeNameError, 0)
+	}},
+	{Name: "truncation", RFC: "1035 4.2.1", Core: true, Run: func(ctx context.Context, server string) error {
+		res, err := exchange(ctx, server, client.UDP, newQuery(large, dns.TypeTXT, false))
+		if err != nil {
+			return err
+		}
+		if len(res.Raw) > 512 {
+			return fmt.Errorf("UDP reply of %d bytes, want at most 512", len(res.Raw))
+		}
+		if res.Header.TC != 1 {
+			return errors.New("TC flag not set on a reply cut short")
+		}
+		return nil
+	}},
+	{Name: "tcp-fallback", RFC: "1035 4.2.1", Core: true, Run: func(ctx context.Context, server string) error {
+		// the client retries truncated replies over TCP like dig does
+		c := &client.Client{Server: server, Protocol: client.UDP, Timeout: Timeout}
+		res, err := c.Query(ctx, large, dns.TypeTXT)
+		if err != nil {
+			return err
+		}
+		if res.Header.TC != 0 {
+			return errors.New("TC flag set over TCP")
+		}
+		return expect(res, dns.RcodeSuccess, 3)
+	}},
+	{Name: "negative-soa", RFC: "2308 3", Core: true, Run: func(ctx context.Context, server string) error {
+		for _, q := range []struct {
+			name  string
+			qtype dns.QType
+		}{{missing, dns.TypeA}, {www, dns.TypeTXT}} {
+			res, err := exchange(ctx, server, client.UDP, newQuery(q.name, q.qtype, false))
+			if err != nil {
+				return err
+			}
+			if err := negativeSOA(res); err != nil {
+				return fmt.Errorf("%s %s: %w", q.name, q.qtype, err)
+			}
+		}
+		return nil
+	}},
+	{Name: "nodata", RFC: "2308 2.2", Run: func(ctx context.Context, server string) error {
+		res, err := exchange(ctx, server, client.UDP, newQuery(www, dns.TypeTXT, false))
+		if err != nil {
+			return err
+		}
+		return expect(res, dns.RcodeSuccess, 0)
+	}},
+	{Name: "empty-non-terminal", RFC: "8020 2", Run: func(ctx context.Context, server string) error {
+		res, err := exchange(ctx, server, client.UDP, newQuery(empty, dns.TypeA, false))
+		if err != nil {
+			return err
+		}
+		return expect(res, dns.RcodeSuccess, 0)
+	}},
+	{Name: "edns", RFC: "6891 7", Run: func(ctx context.Context, server string) error {
+		res, err := exchange(ctx, server, client.UDP, newQuery(www, dns.TypeA, true))
+		if err != nil {
+			return err
+		}
+		if _, ok := opt(res.Raw); !ok {
+			return errors.New("no OPT record in the reply to an EDNS query")
+		}
+		return answered(res, "192.0.2.1")
+	}},
+	{Name: "edns-payload", RFC: "6891 6.2.5", Run: func(ctx context.Context, server string) error {
+		res, err := exchange(ctx, server, client.UDP, newQuery(large, dns.TypeTXT, true))
+		if err != nil {
+			return err
+		}
+		if res.Header.TC != 0 {
+			return fmt.Errorf("reply truncated below the %d bytes advertised", dns.DefaultUDPSize)
+		}
+		return expect(res, dns.RcodeSuccess, 3)
+	}},
+	{Name: "edns-version", RFC: "6891 6.1.3", Run: func(ctx context.Context, server string) error {
+		query := newQuery(www, dns.TypeA, true)
+		// the version is the second byte of the TTL
+		query.Additional[0].TTL |= 1 << 16
+		res, err := exchange(ctx, server, client.UDP, query)
+		if err != nil {
+			return err
+		}
+		rr, ok := opt(res.Raw)
+		if !ok {
+			return errors.New("no OPT record in the reply")
+		}
+		if rcode := rr.TTL>>24<<4 | uint32(res.Header.RCODE); rcode != 16 {
+			return fmt.Errorf("extended rcode %d for EDNS version 1, want BADVERS (16)", rcode)
+		}
+		return nil
+	}},
+	{Name: "unknown-opcode", RFC: "1035 4.1.1", Run: func(ctx context.Context, server string) error {
+		query := newQuery(www, dns.TypeA, false)
+		// opcode 3 is unassigned
+		query.Header.Opcode = 3
+		res, err := exchange(ctx, server, client.UDP, query)
+		if err != nil {
+			return err
+		}
+		return expect(res, dns.RcodeNotImplemented, 0)
+	}},
+	{Name: "tcp-pipelining", RFC: "7766 6.2.1", Run: func(ctx context.Context, server string) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", server)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		// both queries are sent before reading the first reply
+		queries := []*dns.Message{newQuery(www, dns.TypeA, false), newQuery(missing, dns.TypeA, false)}
+		var out []byte
+		for _, query := range queries {
+			data := query.Encode()
+			out = binary.BigEndian.AppendUint16(out, uint16(len(data)))
+			out = append(out, data...)
+		}
+		if _, err := conn.Write(out); err != nil {
+			return err
+		}
+		for i := range queries {
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				return fmt.Errorf("reply %d: %w", i+1, err)
+			}
+			raw := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(conn, raw); err != nil {
+				return fmt.Errorf("reply %d: %w", i+1, err)
+			}
+			res, err := client.Parse(raw)
+			if err != nil {
+				return fmt.Errorf("reply %d: %w", i+1, err)
+			}
+			if res.Header.ID != queries[0].Header.ID && res.Header.ID != queries[1].Header.ID {
+				return fmt.Errorf("reply %d matches no query", i+1)
+			}
+		}
+		return nil
+	}},
+}
+
+// newQuery returns a query for name without the RD flag, with an OPT
+// record when edns is true
+func newQuery(name string, qtype dns.QType, edns bool) *dns.Message {
+	c := &client.Client{EDNS: edns}
+	return c.NewQuery(name, qtype)
+}
+
+// exchange sends query to server over UDP or TCP and parses the reply.
+// Unlike the client, it does not retry truncated UDP replies over TCP.
+func exchange(ctx context.Context, server string, protocol client.Protocol, query *dns.Message) (*client.Response, error) {
+	var raw []byte
+	var err error
+	if protocol == client.UDP {
+		raw, err = exchangeUDP(ctx, server, query.Encode())
+	} else {
+		c := &client.Client{Server: server, Protocol: protocol, Timeout: Timeout}
+		raw, err = c.Exchange(ctx, query.Encode())
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if res.Header.ID != query.Header.ID {
+		return nil, errors.New("reply ID does not match the query")
+	}
+	return res, nil
+}
+
+// exchangeUDP sends query in a single datagram and returns the reply
+func exchangeUDP(ctx context.Context, server string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// expect checks the response code and answer count of res
+func expect(res *client.Response, rcode uint16, answers int) error {
+	if res.Header.RCODE != rcode {
+		return fmt.Errorf("status %s, want %s", dns.RcodeName(res.Header.RCODE), dns.RcodeName(rcode))
+	}
+	if len(res.Answers) != answers {
+		return fmt.Errorf("%d answers, want %d", len(res.Answers), answers)
+	}
+	return nil
+}
+
+// answered checks that res answers with the single record data
+func answered(res *client.Response, data string) error {
+	if err := expect(res, dns.RcodeSuccess, 1); err != nil {
+		return err
+	}
+	if got := res.Answers[0].Data; got != data {
+		return fmt.Errorf("answer %s, want %s", got, data)
+	}
+	if !strings.EqualFold(res.Answers[0].Name, res.Question.DomainName) {
+		return fmt.Errorf("answer owned by %s, want %s", res.Answers[0].Name, res.Question.DomainName)
+	}
+	return nil
+}
+
+// negativeSOA checks that the negative answer res carries the SOA of the
+// zone in its authority section, its TTL no higher than the SOA minimum
+func negativeSOA(res *client.Response) error {
+	if len(res.Answers) != 0 {
+		return fmt.Errorf("%d answers, want none", len(res.Answers))
+	}
+	for _, rr := range res.Authority {
+		if rr.Type != dns.TypeSOA {
+			continue
+		}
+		if !strings.EqualFold(rr.Name, Origin) {
+			return fmt.Errorf("SOA owned by %s, want %s", rr.Name, Origin)
+		}
+		fields := strings.Fields(rr.Data)
+		if minimum, err := strconv.ParseUint(fields[len(fields)-1], 10, 32); err == nil && uint64(rr.TTL) > minimum {
+			return fmt.Errorf("SOA TTL %d above the minimum %d", rr.TTL, minimum)
+		}
+		return nil
+	}
+	return errors.New("no SOA in the authority section")
+}
+
+// opt returns the OPT record of the reply in raw
+func opt(raw []byte) (dns.Answer, bool) {
+	msg := dns.Message{}
+	if _, err := msg.Decode(raw); err != nil {
+		return dns.Answer{}, false
+	}
+	for _, rr := range msg.Additional {
+		if dns.QType(rr.Type) == dns.TypeOPT {
+			return rr, true
+		}
+	}
+	return dns.Answer{}, false
+}
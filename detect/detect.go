@@ -0,0 +1,358 @@
+// Package detect flags clients whose queries look like DNS tunneling or
+// malware generating domain names: very long labels, random looking
+// names, names made of few vowels or mixed letters and digits, or many
+// distinct names under one domain.
+package detect
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reasons of alerts
+const (
+	ReasonLongLabel  = "long-label"
+	ReasonEntropy    = "entropy"
+	ReasonDGA        = "dga"
+	ReasonSubdomains = "unique-subdomains"
+)
+
+// defaults of the options left unset
+const (
+	DefaultMaxLabel    = 50
+	DefaultEntropy     = 4.0
+	DefaultUniqueNames = 200
+	DefaultThreshold   = 20
+	DefaultWindow      = time.Minute
+	DefaultLimitFor    = 10 * time.Minute
+)
+
+// minEntropyLen is the length of the subdomain part below which its
+// entropy says too little to be judged
+const minEntropyLen = 24
+
+// bounds of the state kept, further clients, domains and alerts are not
+// tracked
+const (
+	maxClients = 10000
+	maxDomains = 1000
+	maxAlerts  = 1000
+)
+
+// Options configures the detection
+type Options struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxLabel is the longest label of a name not flagged
+	MaxLabel int `yaml:"max_label"`
+	// Entropy is the Shannon entropy in bits per character of the
+	// subdomain part of a name past which it looks random
+	Entropy float64 `yaml:"entropy"`
+	// UniqueNames is the number of distinct names under one domain a
+	// client may query in a window
+	UniqueNames int `yaml:"unique_names"`
+	// Threshold is the number of suspicious queries in a window that flags
+	// a client
+	Threshold int           `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+	// Limit is the number of queries per minute answered to flagged
+	// clients for LimitFor, the others are refused. Zero only alerts.
+	Limit    int           `yaml:"limit"`
+	LimitFor time.Duration `yaml:"limit_for"`
+}
+
+// Validate reports problems with the options without applying them
+func (o Options) Validate() []error {
+	var errs []error
+	if o.MaxLabel < 0 || o.MaxLabel > 63 {
+		errs = append(errs, fmt.Errorf("max_label must be between 0 and 63, got %d", o.MaxLabel))
+	}
+	if o.Entropy < 0 {
+		errs = append(errs, fmt.Errorf("entropy must not be negative, got %g", o.Entropy))
+	}
+	if o.UniqueNames < 0 || o.Threshold < 0 || o.Limit < 0 {
+		errs = append(errs, errors.New("unique_names, threshold and limit must not be negative"))
+	}
+	if o.Window < 0 || o.LimitFor < 0 {
+		errs = append(errs, errors.New("window and limit_for must not be negative"))
+	}
+	return errs
+}
+
+// withDefaults fills in the options left unset
+func (o Options) withDefaults() Options {
+	if o.MaxLabel == 0 {
+		o.MaxLabel = DefaultMaxLabel
+	}
+	if o.Entropy == 0 {
+		o.Entropy = DefaultEntropy
+	}
+	if o.UniqueNames == 0 {
+		o.UniqueNames = DefaultUniqueNames
+	}
+	if o.Threshold == 0 {
+		o.Threshold = DefaultThreshold
+	}
+	if o.Window == 0 {
+		o.Window = DefaultWindow
+	}
+	if o.LimitFor == 0 {
+		o.LimitFor = DefaultLimitFor
+	}
+	return o
+}
+
+// Alert is a kind of suspicious query a client sent under a domain
+type Alert struct {
+	Client string `json:"client"`
+	Reason string `json:"reason"`
+	Domain string `json:"domain"`
+	// Example is the first name that raised the alert
+	Example string    `json:"example"`
+	Count   uint64    `json:"count"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+	// LimitedUntil is set while the client is rate limited
+	LimitedUntil *time.Time `json:"limited_until,omitempty"`
+}
+
+type alertKey struct {
+	client, reason, domain string
+}
+
+// client is the state of the queries of one client in the current window
+type client struct {
+	windowStart time.Time
+	suspicious  int
+	// names holds the distinct names queried under each domain, up to the
+	// limit of unique names
+	names map[string]map[string]struct{}
+
+	limitedUntil time.Time
+	minuteStart  time.Time
+	answered     int
+}
+
+// Detector scores the queries of clients. A nil detector flags nothing.
+type Detector struct {
+	opts Options
+
+	mu      sync.Mutex
+	clients map[string]*client
+	alerts  map[alertKey]*Alert
+}
+
+// New returns a detector with the options, defaults filling in the unset
+// ones
+func New(opts Options) *Detector {
+	return &Detector{
+		opts:    opts.withDefaults(),
+		clients: make(map[string]*client),
+		alerts:  make(map[alertKey]*Alert),
+	}
+}
+
+// Check scores the query of name by ip at now, and reports whether it is
+// to be refused because the client is flagged and over its limit
+func (d *Detector) Check(ip net.IP, name string, now time.Time) bool {
+	if d == nil || ip == nil {
+		return false
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	domain, sub := split(name)
+	reasons := d.analyze(domain, sub)
+
+	address := ip.String()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.clients[address]
+	if !ok {
+		if len(d.clients) >= maxClients {
+			d.forget(now)
+			if len(d.clients) >= maxClients {
+				return false
+			}
+		}
+		c = &client{windowStart: now, names: make(map[string]map[string]struct{})}
+		d.clients[address] = c
+	}
+	if now.Sub(c.windowStart) >= d.opts.Window {
+		c.windowStart, c.suspicious = now, 0
+		clear(c.names)
+	}
+	if sub != "" && d.unique(c, domain, name) {
+		reasons = append(reasons, ReasonSubdomains)
+	}
+	for _, reason := range reasons {
+		d.alert(address, reason, domain, name, now)
+	}
+	if len(reasons) > 0 {
+		c.suspicious++
+		if c.suspicious >= d.opts.Threshold && d.opts.Limit > 0 && now.After(c.limitedUntil) {
+			c.limitedUntil = now.Add(d.opts.LimitFor)
+			c.minuteStart, c.answered = now, 0
+		}
+	}
+	if now.After(c.limitedUntil) {
+		return false
+	}
+	if now.Sub(c.minuteStart) >= time.Minute {
+		c.minuteStart, c.answered = now, 0
+	}
+	c.answered++
+	return c.answered > d.opts.Limit
+}
+
+// analyze returns the reasons the name, split into its domain and the
+// part below, looks suspicious
+func (d *Detector) analyze(domain, sub string) []string {
+	var reasons []string
+	for _, label := range strings.Split(sub, ".") {
+		if len(label) > d.opts.MaxLabel {
+			reasons = append(reasons, ReasonLongLabel)
+			break
+		}
+	}
+	if letters := strings.ReplaceAll(sub, ".", ""); len(letters) >= minEntropyLen && entropy(letters) > d.opts.Entropy {
+		reasons = append(reasons, ReasonEntropy)
+	}
+	if label, _, _ := strings.Cut(domain, "."); dgaLike(label) {
+		reasons = append(reasons, ReasonDGA)
+	}
+	return reasons
+}
+
+// unique counts name among the distinct names of domain in the window of
+// c, and reports whether there are more than allowed
+func (d *Detector) unique(c *client, domain, name string) bool {
+	names, ok := c.names[domain]
+	if !ok {
+		if len(c.names) >= maxDomains {
+			return false
+		}
+		names = make(map[string]struct{})
+		c.names[domain] = names
+	}
+	if len(names) > d.opts.UniqueNames {
+		return true
+	}
+	names[name] = struct{}{}
+	return len(names) > d.opts.UniqueNames
+}
+
+// alert counts a suspicious query, called with the lock held
+func (d *Detector) alert(address, reason, domain, name string, now time.Time) {
+	key := alertKey{address, reason, domain}
+	a, ok := d.alerts[key]
+	if !ok {
+		if len(d.alerts) >= maxAlerts {
+			return
+		}
+		a = &Alert{Client: address, Reason: reason, Domain: domain, Example: name, First: now}
+		d.alerts[key] = a
+	}
+	a.Count++
+	a.Last = now
+}
+
+// forget drops the clients idle for a window and not limited, called with
+// the lock held
+func (d *Detector) forget(now time.Time) {
+	for address, c := range d.clients {
+		if now.Sub(c.windowStart) >= d.opts.Window && now.After(c.limitedUntil) {
+			delete(d.clients, address)
+		}
+	}
+}
+
+// Alerts returns the alerts raised so far, the latest first
+func (d *Detector) Alerts() []Alert {
+	alerts := []Alert{}
+	if d == nil {
+		return alerts
+	}
+	now := time.Now()
+	d.mu.Lock()
+	for _, a := range d.alerts {
+		alert := *a
+		if c, ok := d.clients[a.Client]; ok && now.Before(c.limitedUntil) {
+			until := c.limitedUntil
+			alert.LimitedUntil = &until
+		}
+		alerts = append(alerts, alert)
+	}
+	d.mu.Unlock()
+	sort.Slice(alerts, func(i, j int) bool {
+		if !alerts[i].Last.Equal(alerts[j].Last) {
+			return alerts[i].Last.After(alerts[j].Last)
+		}
+		return alerts[i].Client+alerts[i].Reason+alerts[i].Domain < alerts[j].Client+alerts[j].Reason+alerts[j].Domain
+	})
+	return alerts
+}
+
+// split returns the registered domain of name, its last two labels or
+// three under a short second level like co.uk, and the labels below it
+func split(name string) (string, string) {
+	labels := strings.Split(name, ".")
+	n := 2
+	if len(labels) >= 3 && len(labels[len(labels)-1]) == 2 && len(labels[len(labels)-2]) <= 3 {
+		n = 3
+	}
+	if len(labels) <= n {
+		return name, ""
+	}
+	return strings.Join(labels[len(labels)-n:], "."), strings.Join(labels[:len(labels)-n], ".")
+}
+
+// entropy returns the Shannon entropy of s in bits per character
+func entropy(s string) float64 {
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	var h float64
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / float64(len(s))
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
+
+// dgaLike reports whether a registered label looks generated: long with
+// few vowels, a long run of consonants, or letters and digits mixed
+func dgaLike(label string) bool {
+	if len(label) < 10 {
+		return false
+	}
+	letters, vowels, run, longest, switches := 0, 0, 0, 0, 0
+	prevDigit := false
+	for i := 0; i < len(label); i++ {
+		ch := label[i]
+		digit := ch >= '0' && ch <= '9'
+		if i > 0 && digit != prevDigit {
+			switches++
+		}
+		prevDigit = digit
+		if ch < 'a' || ch > 'z' {
+			run = 0
+			continue
+		}
+		letters++
+		if strings.IndexByte("aeiouy", ch) >= 0 {
+			vowels++
+			run = 0
+			continue
+		}
+		run++
+		longest = max(longest, run)
+	}
+	return letters > 0 && float64(vowels)/float64(letters) < 0.2 || longest >= 6 || switches >= 4
+}
@@ -0,0 +1,102 @@
+package detect
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAnalyze(t *testing.T) {
+	d := New(Options{Enabled: true})
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"www.example.com", nil},
+		{"mail.google.co.uk", nil},
+		{"images.wikipedia.org", nil},
+		{"configuration.microsoftonline.com", nil},
+		{"t7d2q9lzx4mk1p8vb3nw6yrc5jh0fgs2ea.t.tunnel.example.com", []string{ReasonEntropy}},
+		{"aGVsbG8gd29ybGQgdGhpcyBpcyBhIHR1bm5lbGVkIHBheWxvYWQgb2YgZGF0YQ.tunnel.example.com", []string{ReasonLongLabel, ReasonEntropy}},
+		{"xjkqzhfslvbt.com", []string{ReasonDGA}},
+		{"a1b2c3d4e5f6.net", []string{ReasonDGA}},
+	}
+	for _, tt := range tests {
+		domain, sub := split(tt.name)
+		got := d.analyze(domain, sub)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("analyze(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct{ name, domain, sub string }{
+		{"example.com", "example.com", ""},
+		{"a.b.example.com", "example.com", "a.b"},
+		{"www.bbc.co.uk", "bbc.co.uk", "www"},
+		{"com", "com", ""},
+	}
+	for _, tt := range tests {
+		if domain, sub := split(tt.name); domain != tt.domain || sub != tt.sub {
+			t.Errorf("split(%q) = %q, %q, want %q, %q", tt.name, domain, sub, tt.domain, tt.sub)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	d := New(Options{Enabled: true, UniqueNames: 5, Threshold: 3, Limit: 2})
+	laptop, phone := net.ParseIP("192.168.1.20"), net.ParseIP("192.168.1.21")
+	now := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+
+	// distinct names under one domain, like a tunnel encoding data in them
+	for i := range 5 {
+		if d.Check(laptop, fmt.Sprintf("q%d.tunnel.example.", i), now) {
+			t.Fatalf("query %d refused before the client was flagged", i)
+		}
+	}
+	for i := 5; i < 8; i++ {
+		d.Check(laptop, fmt.Sprintf("q%d.tunnel.example.", i), now)
+	}
+	// flagged by the third suspicious query, which counts toward the two
+	// queries answered this minute
+	if d.Check(laptop, "www.example.com.", now) {
+		t.Error("flagged client refused within its limit")
+	}
+	if !d.Check(laptop, "www.example.com.", now) {
+		t.Error("flagged client over its limit answered")
+	}
+	if d.Check(phone, "www.example.com.", now) {
+		t.Error("other client refused")
+	}
+	if d.Check(laptop, "www.example.com.", now.Add(time.Minute)) {
+		t.Error("flagged client refused within its limit")
+	}
+	if d.Check(laptop, "www.example.com.", now.Add(DefaultLimitFor+time.Minute)) {
+		t.Error("client still limited after limit_for")
+	}
+
+	alerts := d.Alerts()
+	if len(alerts) != 1 || alerts[0].Client != "192.168.1.20" || alerts[0].Reason != ReasonSubdomains || alerts[0].Domain != "tunnel.example" || alerts[0].Count != 3 || alerts[0].Example != "q5.tunnel.example" {
+		t.Errorf("alerts %+v", alerts)
+	}
+
+	var none *Detector
+	if none.Check(laptop, "xjkqzhfslvbt.com.", now) || len(none.Alerts()) != 0 {
+		t.Error("nil detector flags")
+	}
+}
+
+func TestCheckWithoutLimit(t *testing.T) {
+	d := New(Options{Enabled: true, Threshold: 1})
+	now := time.Now()
+	for range 10 {
+		if d.Check(net.ParseIP("192.168.1.20"), "xjkqzhfslvbt.com.", now) {
+			t.Fatal("query refused without a limit")
+		}
+	}
+	if alerts := d.Alerts(); len(alerts) != 1 || alerts[0].Reason != ReasonDGA || alerts[0].Count != 10 || alerts[0].LimitedUntil != nil {
+		t.Errorf("alerts %+v", alerts)
+	}
+}
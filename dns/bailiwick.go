@@ -0,0 +1,137 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/bernoussama/mercury/fqdn"
+)
+
+// A response can only be trusted for the names its servers are
+// authoritative for, the bailiwick of the zone they were referred for.
+// Records outside it, like an address of an unrelated name slipped into a
+// response, are how caches get poisoned, so they are dropped before
+// anything is cached or followed.
+
+// ErrMismatchedReply is a reply whose ID or question differ from the query
+// sent, like one spoofed by an off-path attacker guessing at the query
+var ErrMismatchedReply = errors.New("reply does not match the query")
+
+// checkReply checks that reply answers query: a response with the ID and
+// question of the query, names compared case insensitively
+func checkReply(reply, query *Message) error {
+	switch {
+	case reply.Header.QR != 1:
+		return fmt.Errorf("%w: not a response", ErrMismatchedReply)
+	case reply.Header.ID != query.Header.ID:
+		return fmt.Errorf("%w: id %d, sent %d", ErrMismatchedReply, reply.Header.ID, query.Header.ID)
+	case reply.Question.Name() != query.Question.Name() || reply.Question.QType != query.Question.QType || reply.Question.QClass != query.Question.QClass:
+		return fmt.Errorf("%w: question %s %s, sent %s %s", ErrMismatchedReply, reply.Question.DomainName, reply.Question.QType, query.Question.DomainName, query.Question.QType)
+	}
+	return nil
+}
+
+// answersInBailiwick returns the records of qtype among the answers
+// decoded from packet that answer name: owned by name or by an alias it
+// leads to through the CNAMEs of the answers. With a zone, the servers of
+// zone answered, and records owned outside it are dropped too, as are the
+// aliases leading out of it. Zone is empty for the configured upstreams,
+// which answer for every name.
+func answersInBailiwick(packet []byte, answers []Answer, name string, qtype QType, zone string) (kept []Answer, dropped int) {
+	inZone := func(owner fqdn.Name) bool {
+		return zone == "" || owner.IsSubdomainOf(fqdn.Canonical(zone))
+	}
+	owners := make([]fqdn.Name, len(answers))
+	aliases := make(map[fqdn.Name]fqdn.Name)
+	for i := range answers {
+		owner, err := answers[i].OwnerName(packet)
+		if err != nil {
+			continue
+		}
+		owners[i] = fqdn.Canonical(owner)
+		if QType(answers[i].Type) == TypeCNAME && inZone(owners[i]) {
+			aliases[owners[i]] = fqdn.Canonical(answers[i].Data(packet))
+		}
+	}
+	// the chain is known to be short and loop free, checkCNAMEChain ran
+	chain := map[fqdn.Name]bool{}
+	for alias, links := fqdn.Canonical(name), 0; inZone(alias) && links <= MaxCNAMEChain; links++ {
+		chain[alias] = true
+		next, ok := aliases[alias]
+		if !ok {
+			break
+		}
+		alias = next
+	}
+	for i := range answers {
+		if answers[i].Type != uint16(qtype) {
+			continue
+		}
+		if owners[i] == "" || !chain[owners[i]] {
+			dropped++
+			continue
+		}
+		kept = append(kept, answers[i])
+	}
+	return kept, dropped
+}
+
+// glueInBailiwick returns the glue among the additional records decoded
+// from packet that a referral to servers may be followed with: addresses
+// of one of the servers, inside cut, the zone of the servers that sent the
+// referral. Cut is empty for the configured upstreams.
+func glueInBailiwick(packet []byte, additional []Answer, servers []string, cut string) []Answer {
+	names := make(map[fqdn.Name]bool, len(servers))
+	for _, server := range servers {
+		names[fqdn.Canonical(server)] = true
+	}
+	var glue []Answer
+	for i := range additional {
+		if t := QType(additional[i].Type); t != TypeA && t != TypeAAAA {
+			continue
+		}
+		owner, err := additional[i].OwnerName(packet)
+		if err != nil {
+			continue
+		}
+		if !names[fqdn.Canonical(owner)] || (cut != "" && !IsSubdomain(owner, cut)) {
+			continue
+		}
+		glue = append(glue, additional[i])
+	}
+	return glue
+}
+
+// soaInBailiwick returns the SOA records among the authority decoded from
+// packet that may tell name does not exist or has no records of the type
+// asked: owned by name or a domain above it, inside cut. They are expanded
+// so they no longer point into the packet.
+func soaInBailiwick(packet []byte, authority []Answer, name, cut string) []Answer {
+	var soa []Answer
+	for i := range authority {
+		if QType(authority[i].Type) != TypeSOA {
+			continue
+		}
+		owner, err := authority[i].OwnerName(packet)
+		if err != nil || !IsSubdomain(name, owner) || (cut != "" && !IsSubdomain(owner, cut)) {
+			continue
+		}
+		if record, err := expand(packet, &authority[i]); err == nil {
+			soa = append(soa, record)
+		}
+	}
+	return soa
+}
+
+// negativeTTL returns how long a negative answer with the SOA among
+// authority is cached, the lower of the SOA TTL and minimum, RFC 2308
+// section 5. It reports false without a SOA.
+func negativeTTL(authority []Answer) (uint32, bool) {
+	for _, rr := range authority {
+		if QType(rr.Type) == TypeSOA && len(rr.RData) >= 20 {
+			return min(rr.TTL, binary.BigEndian.Uint32(rr.RData[len(rr.RData)-4:])), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,135 @@
+package dns
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAnswersInBailiwick(t *testing.T) {
+	a := func(name string, last byte) Answer { return record(t, name, TypeA, []byte{192, 0, 2, last}) }
+	tests := []struct {
+		name    string
+		answers []Answer
+		zone    string
+		want    []byte
+	}{
+		{"answer", []Answer{a("www.example.com.", 1)}, "example.com.", []byte{1}},
+		{"case of the name", []Answer{a("WWW.Example.com.", 1)}, "example.com.", []byte{1}},
+		{"unrelated name", []Answer{a("www.example.com.", 1), a("bank.example.net.", 2)}, "", []byte{1}},
+		{"other type", []Answer{record(t, "www.example.com.", TypeAAAA, make([]byte, 16))}, "", nil},
+		{"alias in zone", []Answer{
+			nameRecord(t, "www.example.com.", TypeCNAME, "cdn.example.com."), a("cdn.example.com.", 3),
+		}, "example.com.", []byte{3}},
+		{"alias out of zone", []Answer{
+			nameRecord(t, "www.example.com.", TypeCNAME, "cdn.example.net."), a("cdn.example.net.", 4),
+		}, "example.com.", nil},
+		{"alias through an upstream", []Answer{
+			nameRecord(t, "www.example.com.", TypeCNAME, "cdn.example.net."), a("cdn.example.net.", 4),
+		}, "", []byte{4}},
+		{"alias planted out of zone", []Answer{
+			nameRecord(t, "www.example.com.", TypeCNAME, "cdn.example.com."),
+			nameRecord(t, "cdn.example.net.", TypeCNAME, "evil.example.net."), a("evil.example.net.", 5),
+		}, "example.com.", nil},
+	}
+	for _, tt := range tests {
+		kept, dropped := answersInBailiwick(nil, tt.answers, "www.example.com.", TypeA, tt.zone)
+		var got []byte
+		for _, answer := range kept {
+			got = append(got, answer.RData[3])
+		}
+		if string(got) != string(tt.want) {
+			t.Errorf("%s: kept %v (%d dropped), want %v", tt.name, got, dropped, tt.want)
+		}
+	}
+}
+
+func TestGlueInBailiwick(t *testing.T) {
+	additional := []Answer{
+		record(t, "a.gtld-servers.net.", TypeA, []byte{192, 0, 2, 1}),
+		record(t, "ns1.example.com.", TypeA, []byte{192, 0, 2, 2}),
+		record(t, "ns1.example.com.", TypeAAAA, make([]byte, 16)),
+		record(t, "www.example.com.", TypeA, []byte{192, 0, 2, 3}),
+		NewOPT(DefaultUDPSize, false),
+	}
+	tests := []struct {
+		name    string
+		servers []string
+		cut     string
+		want    int
+	}{
+		{"glue of the servers", []string{"ns1.example.com."}, "com.", 2},
+		{"not a server", []string{"ns2.example.com."}, "com.", 0},
+		{"out of the referring zone", []string{"a.gtld-servers.net.", "ns1.example.com."}, "com.", 2},
+		{"from an upstream", []string{"a.gtld-servers.net."}, "", 1},
+	}
+	for _, tt := range tests {
+		if got := glueInBailiwick(nil, additional, tt.servers, tt.cut); len(got) != tt.want {
+			t.Errorf("%s: %d glue records, want %d", tt.name, len(got), tt.want)
+		}
+	}
+}
+
+func TestHandlerDropsRecordsOutOfBailiwick(t *testing.T) {
+	handler := &Handler{
+		Cache: &RecordsCache{Records: make(map[string]Message)},
+		Upstreams: []Upstream{{Address: replyingUpstream(t, func(b *Builder) {
+			b.Answer(
+				record(t, "www.example.com.", TypeA, []byte{192, 0, 2, 1}),
+				record(t, "bank.example.net.", TypeA, []byte{203, 0, 113, 66}),
+			)
+		}), Timeout: time.Second}},
+	}
+	query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: "www.example.com.", QType: TypeA, QClass: 1}}
+	query.Bytes = query.Encode()
+	res := Message{}
+	if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Answers) != 1 || res.Answers[0].RData[3] != 1 {
+		t.Errorf("answers = %v, want only the record of the name", res.Answers)
+	}
+	if cached, ok := handler.Cache.Get(CacheKey(query.Question, false)); !ok || len(cached.Answers) != 1 {
+		t.Errorf("cached %v, want only the record of the name", cached)
+	}
+}
+
+func TestHandlerDropsMismatchedReplies(t *testing.T) {
+	tests := []struct {
+		name  string
+		spoof func(*Message)
+	}{
+		{"other id", func(m *Message) { m.Header.ID++ }},
+		{"other name", func(m *Message) { m.Question.DomainName = "www.example.net." }},
+		{"other type", func(m *Message) { m.Question.QType = TypeAAAA }},
+	}
+	for _, tt := range tests {
+		handler := &Handler{
+			Cache: &RecordsCache{Records: make(map[string]Message)},
+			Upstreams: []Upstream{{Address: replyingUpstream(t, func(b *Builder) {
+				b.Answer(record(t, "www.example.com.", TypeA, []byte{192, 0, 2, 1}))
+				tt.spoof(b.Message())
+			}), Timeout: time.Second}},
+		}
+		query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: "www.example.com.", QType: TypeA, QClass: 1}}
+		query.Bytes = query.Encode()
+		res := Message{}
+		if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+			t.Fatal(err)
+		}
+		if res.Header.RCODE != RcodeServerFailure || len(res.Answers) != 0 {
+			t.Errorf("%s: answered %s with %v, want SERVFAIL", tt.name, RcodeName(res.Header.RCODE), res.Answers)
+		}
+		if cached, ok := handler.Cache.Get(CacheKey(query.Question, false)); ok {
+			t.Errorf("%s: cached %v", tt.name, cached)
+		}
+	}
+}
+
+func TestCheckReplyIgnoresCase(t *testing.T) {
+	query := &Message{Header: Header{ID: 7}, Question: Question{DomainName: "www.example.com.", QType: TypeA, QClass: 1}}
+	reply := &Message{Header: Header{ID: 7, QR: 1}, Question: Question{DomainName: "WWW.Example.COM.", QType: TypeA, QClass: 1}}
+	if err := checkReply(reply, query); err != nil {
+		t.Errorf("checkReply() = %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package dns
+
+import "strconv"
+
+// response codes
+const (
+	RcodeSuccess        uint16 = 0
+	RcodeFormatError    uint16 = 1
+	RcodeServerFailure  uint16 = 2
+	RcodeNameError      uint16 = 3
+	RcodeNotImplemented uint16 = 4
+	RcodeRefused        uint16 = 5
+)
+
+var rcodeNames = map[uint16]string{
+	RcodeSuccess:        "NOERROR",
+	RcodeFormatError:    "FORMERR",
+	RcodeServerFailure:  "SERVFAIL",
+	RcodeNameError:      "NXDOMAIN",
+	RcodeNotImplemented: "NOTIMP",
+	RcodeRefused:        "REFUSED",
+}
+
+// operation codes
+const (
+	OpcodeQuery  uint16 = 0
+	OpcodeIQuery uint16 = 1
+	OpcodeStatus uint16 = 2
+	OpcodeNotify uint16 = 4
+	OpcodeUpdate uint16 = 5
+)
+
+var opcodeNames = map[uint16]string{
+	OpcodeQuery:  "QUERY",
+	OpcodeIQuery: "IQUERY",
+	OpcodeStatus: "STATUS",
+	OpcodeNotify: "NOTIFY",
+	OpcodeUpdate: "UPDATE",
+}
+
+// OpcodeName returns the mnemonic of an operation code
+func OpcodeName(opcode uint16) string {
+	if name, ok := opcodeNames[opcode]; ok {
+		return name
+	}
+	return "OPCODE" + strconv.Itoa(int(opcode))
+}
+
+// RcodeName returns the mnemonic of a response code
+func RcodeName(rcode uint16) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return "RCODE" + strconv.Itoa(int(rcode))
+}
+
+// Builder assembles a response to a query, keeping the header counts and
+// flags consistent with the sections it holds.
+//
+//	res := NewResponse(query).Answer(answers...).SetRcode(RcodeSuccess).Encode()
+type Builder struct {
+	msg Message
+}
+
+// NewResponse starts a response to query, echoing its ID, opcode,
+// question and the RD and CD flags.
+func NewResponse(query *Message) *Builder {
+	b := &Builder{}
+	b.msg.Header = Header{
+		ID:     query.Header.ID,
+		QR:     1,
+		Opcode: query.Header.Opcode,
+		RD:     query.Header.RD,
+	}
+	b.msg.Header.SetCheckingDisabled(query.Header.CheckingDisabled())
+	b.msg.Question = query.Question
+	return b
+}
+
+// Answer appends records to the answer section
+func (b *Builder) Answer(answers ...Answer) *Builder {
+	b.msg.Answers = appendSection(b.msg.Answers, answers)
+	return b
+}
+
+// Authority appends records to the authority section
+func (b *Builder) Authority(answers ...Answer) *Builder {
+	b.msg.Authority = appendSection(b.msg.Authority, answers)
+	return b
+}
+
+// Additional appends records to the additional section
+func (b *Builder) Additional(answers ...Answer) *Builder {
+	b.msg.Additional = appendSection(b.msg.Additional, answers)
+	return b
+}
+
+// SetRcode sets the response code
+func (b *Builder) SetRcode(rcode uint16) *Builder {
+	b.msg.Header.RCODE = rcode & 0x0F
+	return b
+}
+
+// Authoritative sets the AA flag
+func (b *Builder) Authoritative(aa bool) *Builder {
+	b.msg.Header.AA = flag(aa)
+	return b
+}
+
+// RecursionAvailable sets the RA flag
+func (b *Builder) RecursionAvailable(ra bool) *Builder {
+	b.msg.Header.RA = flag(ra)
+	return b
+}
+
+// Truncate empties the sections of the response but for its OPT record
+// and sets the TC flag, telling the client to retry over TCP, RFC 1035
+// 4.2.1
+func (b *Builder) Truncate() *Builder {
+	b.msg.Header.TC = 1
+	b.msg.Answers, b.msg.Authority = nil, nil
+	var opt []Answer
+	if rr, ok := b.msg.opt(); ok {
+		opt = []Answer{*rr}
+	}
+	b.msg.Additional = opt
+	return b
+}
+
+// Message returns the response with its section counts filled in
+func (b *Builder) Message() *Message {
+	b.msg.Header.QDCount = 1
+	b.msg.Header.ANCount = uint16(len(b.msg.Answers))
+	b.msg.Header.NSCount = uint16(len(b.msg.Authority))
+	b.msg.Header.ARCount = uint16(len(b.msg.Additional))
+	return &b.msg
+}
+
+// Encode returns the response in wire format
+func (b *Builder) Encode() []byte {
+	return b.Message().Encode()
+}
+
+// AppendEncode appends the response in wire format to buf
+func (b *Builder) AppendEncode(buf []byte) []byte {
+	return b.Message().AppendEncode(buf)
+}
+
+// appendSection adds records to a section. An empty section shares the
+// caller's records, capped so later appends copy rather than overwrite them.
+func appendSection(section, records []Answer) []Answer {
+	if section == nil {
+		return records[:len(records):len(records)]
+	}
+	return append(section, records...)
+}
+
+func flag(set bool) uint16 {
+	if set {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,33 @@
+package dns
+
+import "testing"
+
+func TestBuilder(t *testing.T) {
+	query := &Message{
+		Header:   Header{ID: 0xBEEF, RD: 1, QDCount: 1, ARCount: 1},
+		Question: Question{DomainName: "example.com.", QType: TypeA, QClass: 1},
+	}
+	zone := testZone()
+	res := NewResponse(query).
+		Authoritative(true).
+		Answer(zone.Lookup("example.com.", TypeA, 1)...).
+		Authority(zone.Lookup("example.com.", TypeNS, 1)...).
+		SetRcode(RcodeSuccess).
+		Message()
+
+	want := Header{ID: 0xBEEF, QR: 1, AA: 1, RD: 1, QDCount: 1, ANCount: 1, NSCount: 1}
+	if res.Header != want {
+		t.Errorf("Message().Header = %+v, want %+v", res.Header, want)
+	}
+
+	decoded := Message{}
+	if _, err := decoded.Decode(NewResponse(query).SetRcode(RcodeRefused).Encode()); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Header.RCODE != RcodeRefused || decoded.Header.QR != 1 || decoded.Header.ANCount != 0 {
+		t.Errorf("decoded header = %+v, want refused response", decoded.Header)
+	}
+	if decoded.Question != query.Question {
+		t.Errorf("decoded question = %+v, want %+v", decoded.Question, query.Question)
+	}
+}
@@ -0,0 +1,115 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/bernoussama/mercury/cache"
+	"github.com/bernoussama/mercury/fqdn"
+)
+
+// CacheCounts are the cache lookups and entries of one query type or zone
+type CacheCounts struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+	// Bytes is the wire size of the cached answers
+	Bytes int `json:"bytes"`
+}
+
+// CacheUsageStats break the cache lookups and entries down by query type
+// and by zone
+type CacheUsageStats struct {
+	Types map[string]CacheCounts `json:"types"`
+	Zones map[string]CacheCounts `json:"zones"`
+}
+
+// CacheUsage counts the cache hits and misses of the handler by query
+// type and zone, to tell which answers the upstream traffic goes to. The
+// zone of a name is the served zone holding it, else its top level
+// domain. A nil CacheUsage counts nothing.
+type CacheUsage struct {
+	mu    sync.Mutex
+	types map[string]*CacheCounts
+	zones map[string]*CacheCounts
+}
+
+// NewCacheUsage returns empty counters
+func NewCacheUsage() *CacheUsage {
+	return &CacheUsage{types: make(map[string]*CacheCounts), zones: make(map[string]*CacheCounts)}
+}
+
+// count adds a lookup of a name of zone for qtype
+func (u *CacheUsage) count(qtype, zone string, hit bool) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, c := range []*CacheCounts{counts(u.types, qtype), counts(u.zones, zone)} {
+		if hit {
+			c.Hits++
+		} else {
+			c.Misses++
+		}
+	}
+}
+
+// counts returns the counts of key, adding them when missing
+func counts(m map[string]*CacheCounts, key string) *CacheCounts {
+	c, ok := m[key]
+	if !ok {
+		c = &CacheCounts{}
+		m[key] = c
+	}
+	return c
+}
+
+// Stats returns the lookups counted along with the entries of c, their
+// zone looked up in zones
+func (u *CacheUsage) Stats(c cache.Cache[Message], zones map[string]Zone) CacheUsageStats {
+	types, byZone := make(map[string]*CacheCounts), make(map[string]*CacheCounts)
+	if u != nil {
+		u.mu.Lock()
+		for key, c := range u.types {
+			copied := *c
+			types[key] = &copied
+		}
+		for key, c := range u.zones {
+			copied := *c
+			byZone[key] = &copied
+		}
+		u.mu.Unlock()
+	}
+	c.Range(func(e cache.Entry[Message]) bool {
+		name, rest, _ := strings.Cut(e.Key, "/")
+		qtype, _, _ := strings.Cut(rest, "/")
+		size := e.Value.Size()
+		for _, c := range []*CacheCounts{counts(types, qtype), counts(byZone, cacheZone(zones, name))} {
+			c.Entries++
+			c.Bytes += size
+		}
+		return true
+	})
+	stats := CacheUsageStats{Types: make(map[string]CacheCounts), Zones: make(map[string]CacheCounts)}
+	for key, c := range types {
+		stats.Types[key] = *c
+	}
+	for key, c := range byZone {
+		stats.Zones[key] = *c
+	}
+	return stats
+}
+
+// cacheZone returns the zone name is counted under: the served zone
+// holding it, else its top level domain
+func cacheZone(zones map[string]Zone, name string) string {
+	if zone, ok := FindZone(zones, name); ok {
+		return zone.Origin
+	}
+	n := fqdn.Canonical(name)
+	for n.Labels() > 1 {
+		n = n.Parent()
+	}
+	return string(n)
+}
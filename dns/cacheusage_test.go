@@ -0,0 +1,47 @@
+package dns
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheUsage(t *testing.T) {
+	upstream := []Upstream{{Address: staticUpstream(t, TypeA, 60, []byte{192, 0, 2, 1}), Timeout: time.Second}}
+	handler := &Handler{
+		Zones:      map[string]Zone{"corp.test.": {Origin: "corp.test.", Forward: upstream}},
+		Cache:      &RecordsCache{Records: make(map[string]Message)},
+		Upstreams:  upstream,
+		CacheUsage: NewCacheUsage(),
+	}
+	for _, name := range []string{"www.example.com.", "WWW.example.com.", "x.example.net.", "db.corp.test."} {
+		query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: name, QType: TypeA, QClass: 1}}
+		query.Bytes = query.Encode()
+		handler.BuildResponse(context.Background(), query)
+	}
+
+	stats := handler.CacheUsageStats()
+	if a := stats.Types["A"]; a.Hits != 1 || a.Misses != 3 || a.Entries != 3 || a.Bytes == 0 {
+		t.Errorf("A counts %+v, want 1 hit, 3 misses and 3 entries", a)
+	}
+	want := map[string]CacheCounts{
+		"com.":       {Hits: 1, Misses: 1, Entries: 1},
+		"net.":       {Misses: 1, Entries: 1},
+		"corp.test.": {Misses: 1, Entries: 1},
+	}
+	if len(stats.Zones) != len(want) {
+		t.Errorf("zones %+v, want %v", stats.Zones, want)
+	}
+	for zone, w := range want {
+		got := stats.Zones[zone]
+		if got.Hits != w.Hits || got.Misses != w.Misses || got.Entries != w.Entries {
+			t.Errorf("zone %s counts %+v, want %+v", zone, got, w)
+		}
+	}
+
+	// without counters only the entries are broken down
+	handler.CacheUsage = nil
+	if stats := handler.CacheUsageStats(); stats.Types["A"].Entries != 3 || stats.Types["A"].Hits != 0 {
+		t.Errorf("A counts without usage %+v", stats.Types["A"])
+	}
+}
@@ -0,0 +1,108 @@
+package dns
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bernoussama/mercury/fqdn"
+)
+
+const (
+	// ChallengeTTL is the TTL of challenge records, short so resolvers
+	// pick up a new challenge for the same name
+	ChallengeTTL = 60
+	// ChallengeLifetime is how long a challenge record is answered when
+	// the ACME client does not clean it up
+	ChallengeLifetime = time.Hour
+)
+
+// Challenges are temporary TXT records ACME clients publish in the zones
+// to prove they control a name, RFC 8555 section 8.4
+type Challenges struct {
+	mu      sync.Mutex
+	records map[string][]challenge
+}
+
+type challenge struct {
+	value   string
+	expires time.Time
+}
+
+// NewChallenges returns an empty set of challenge records
+func NewChallenges() *Challenges {
+	return &Challenges{records: make(map[string][]challenge)}
+}
+
+// Present publishes a TXT record with value at name until it is cleaned up
+// or ChallengeLifetime passes. Clients validating a wildcard and its base
+// name publish two values at the same name.
+func (c *Challenges) Present(name, value string) {
+	name = string(fqdn.Canonical(name))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	records := slices.DeleteFunc(c.records[name], func(r challenge) bool { return r.value == value || !now.Before(r.expires) })
+	c.records[name] = append(records, challenge{value: value, expires: now.Add(ChallengeLifetime)})
+}
+
+// Cleanup removes the record with value at name, every record at name when
+// value is empty, and reports whether one was removed
+func (c *Challenges) Cleanup(name, value string) bool {
+	name = string(fqdn.Canonical(name))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	records := c.records[name]
+	n := len(records)
+	records = slices.DeleteFunc(records, func(r challenge) bool { return value == "" || r.value == value })
+	if len(records) == 0 {
+		delete(c.records, name)
+	} else {
+		c.records[name] = records
+	}
+	return len(records) < n
+}
+
+// values returns the live values published at name
+func (c *Challenges) values(name string) []string {
+	if c == nil {
+		return nil
+	}
+	name = string(fqdn.Canonical(name))
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var values []string
+	for _, r := range c.records[name] {
+		if now.Before(r.expires) {
+			values = append(values, r.value)
+		}
+	}
+	return values
+}
+
+// Exists reports whether a live record is published at name
+func (c *Challenges) Exists(name string) bool {
+	return len(c.values(name)) > 0
+}
+
+// Lookup returns the TXT records published at name for a query of qtype
+func (c *Challenges) Lookup(name string, qtype QType, qclass uint16) []Answer {
+	if qtype != TypeTXT && qtype != TypeANY {
+		return nil
+	}
+	values := c.values(name)
+	if len(values) == 0 {
+		return nil
+	}
+	encodedName, err := EncodeDomainName(name)
+	if err != nil {
+		return nil
+	}
+	answers := make([]Answer, 0, len(values))
+	for _, value := range values {
+		txt := encodeTXT(value)
+		answers = append(answers, Answer{Name: encodedName, Type: uint16(TypeTXT), Class: qclass, TTL: ChallengeTTL, RDLength: uint16(len(txt)), RData: txt})
+	}
+	return answers
+}
@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"context"
+	"testing"
+)
+
+func TestChallenges(t *testing.T) {
+	c := NewChallenges()
+	c.Present("_acme-challenge.Example.com", "one")
+	c.Present("_acme-challenge.example.com.", "two")
+	c.Present("_acme-challenge.example.com.", "two")
+	if answers := c.Lookup("_acme-challenge.example.com.", TypeTXT, 1); len(answers) != 2 || answers[0].TTL != ChallengeTTL {
+		t.Fatalf("lookup %+v, want 2 records", answers)
+	}
+	if answers := c.Lookup("_acme-challenge.example.com.", TypeA, 1); len(answers) != 0 {
+		t.Errorf("A lookup %+v, want none", answers)
+	}
+	if !c.Cleanup("_acme-challenge.example.com", "one") || c.Cleanup("_acme-challenge.example.com", "one") {
+		t.Error("cleanup of one should remove it once")
+	}
+	if !c.Cleanup("_acme-challenge.example.com", "") || c.Exists("_acme-challenge.example.com.") {
+		t.Error("cleanup without value should remove every record")
+	}
+	var nilChallenges *Challenges
+	if nilChallenges.Exists("example.com.") {
+		t.Error("nil challenges exist")
+	}
+}
+
+func TestHandlerChallenges(t *testing.T) {
+	zone := testZone()
+	handler := &Handler{
+		Zones:      map[string]Zone{zone.Origin: zone},
+		Cache:      &RecordsCache{Records: make(map[string]Message)},
+		Challenges: NewChallenges(),
+	}
+	handler.Challenges.Present("_acme-challenge.example.com.", "token")
+
+	tests := []struct {
+		qname   string
+		qtype   QType
+		rcode   uint16
+		answers int
+	}{
+		{"_acme-challenge.example.com.", TypeTXT, RcodeSuccess, 1},
+		{"_acme-challenge.example.com.", TypeA, RcodeSuccess, 0},
+		{"_acme-challenge.www.example.com.", TypeTXT, RcodeNameError, 0},
+	}
+	for _, tt := range tests {
+		query := &Message{Header: Header{ID: 1, QDCount: 1}, Question: Question{DomainName: tt.qname, QType: tt.qtype, QClass: 1}}
+		res := Message{}
+		if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+			t.Fatal(err)
+		}
+		if res.Header.RCODE != tt.rcode || len(res.Answers) != tt.answers || res.Header.AA != 1 {
+			t.Errorf("%s %s: %s with %d answers aa %d, want %s with %d", tt.qname, tt.qtype, RcodeName(res.Header.RCODE), len(res.Answers), res.Header.AA, RcodeName(tt.rcode), tt.answers)
+		}
+	}
+}
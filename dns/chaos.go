@@ -0,0 +1,87 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chaos are the faults injected into the upstream exchanges, so retries,
+// timeouts and the SERVFAIL paths can be exercised in integration tests.
+// Only builds with the chaos tag inject them.
+type Chaos struct {
+	// Latency delays every upstream query, Jitter adding up to as much
+	// again at random
+	Latency time.Duration
+	Jitter  time.Duration
+	// Loss is the share of upstream queries lost, the attempt timing out
+	Loss float64
+	// Malformed is the share of replies mangled so they fail to decode
+	Malformed float64
+}
+
+// ParseChaos parses faults like latency=200ms,jitter=50ms,loss=0.1,malformed=0.05
+func ParseChaos(spec string) (Chaos, error) {
+	var c Chaos
+	for _, field := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return Chaos{}, fmt.Errorf("invalid fault %q, want key=value", field)
+		}
+		var err error
+		switch key {
+		case "latency":
+			c.Latency, err = time.ParseDuration(value)
+		case "jitter":
+			c.Jitter, err = time.ParseDuration(value)
+		case "loss":
+			c.Loss, err = strconv.ParseFloat(value, 64)
+		case "malformed":
+			c.Malformed, err = strconv.ParseFloat(value, 64)
+		default:
+			return Chaos{}, fmt.Errorf("unknown fault %q, want latency, jitter, loss or malformed", key)
+		}
+		if err != nil {
+			return Chaos{}, fmt.Errorf("invalid %s %q: %v", key, value, err)
+		}
+	}
+	if c.Latency < 0 || c.Jitter < 0 {
+		return Chaos{}, fmt.Errorf("latency and jitter must not be negative")
+	}
+	if c.Loss < 0 || c.Loss > 1 || c.Malformed < 0 || c.Malformed > 1 {
+		return Chaos{}, fmt.Errorf("loss and malformed must be between 0 and 1")
+	}
+	return c, nil
+}
+
+// delay returns how long to hold an upstream query
+func (c Chaos) delay() time.Duration {
+	if c.Jitter <= 0 {
+		return c.Latency
+	}
+	return c.Latency + rand.N(c.Jitter+1)
+}
+
+// lost reports whether to lose an upstream query
+func (c Chaos) lost() bool {
+	return c.Loss > 0 && rand.Float64() < c.Loss
+}
+
+// malformed reports whether to mangle a reply
+func (c Chaos) malformed() bool {
+	return c.Malformed > 0 && rand.Float64() < c.Malformed
+}
+
+// malform returns a copy of the reply that fails to decode: cut in the
+// middle of its records, or claiming more answers than it holds
+func malform(res []byte) []byte {
+	if len(res) <= headerSize || rand.IntN(2) == 0 {
+		return append([]byte(nil), res[:len(res)/2]...)
+	}
+	mangled := append([]byte(nil), res...)
+	binary.BigEndian.PutUint16(mangled[6:8], binary.BigEndian.Uint16(mangled[6:8])+8)
+	return mangled
+}
@@ -0,0 +1,53 @@
+package dns
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseChaos(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Chaos
+		ok   bool
+	}{
+		{"latency=200ms", Chaos{Latency: 200 * time.Millisecond}, true},
+		{"latency=200ms, jitter=50ms,loss=0.1,malformed=0.05", Chaos{Latency: 200 * time.Millisecond, Jitter: 50 * time.Millisecond, Loss: 0.1, Malformed: 0.05}, true},
+		{"loss=1.5", Chaos{}, false},
+		{"latency=-1s", Chaos{}, false},
+		{"latency", Chaos{}, false},
+		{"reorder=0.1", Chaos{}, false},
+		{"loss=often", Chaos{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseChaos(tt.spec)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseChaos(%q) = %+v, %v, want %+v, ok %v", tt.spec, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestChaosFaults(t *testing.T) {
+	c := Chaos{Latency: 10 * time.Millisecond, Jitter: 5 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		if d := c.delay(); d < c.Latency || d > c.Latency+c.Jitter {
+			t.Fatalf("delay() = %s, want between 10ms and 15ms", d)
+		}
+	}
+	if (Chaos{}).lost() || (Chaos{}).malformed() || !(Chaos{Loss: 1}).lost() || !(Chaos{Malformed: 1}).malformed() {
+		t.Error("faults injected at the wrong rate")
+	}
+
+	query := &Message{Header: Header{ID: 7, QDCount: 1}, Question: Question{DomainName: "example.com.", QType: TypeA, QClass: 1}}
+	name, _ := EncodeDomainName("example.com.")
+	reply := NewResponse(query).Answer(Answer{Name: name, Type: uint16(TypeA), Class: 1, TTL: 60, RData: []byte{192, 0, 2, 1}, RDLength: 4}).Message().Encode()
+	for i := 0; i < 20; i++ {
+		res := malform(reply)
+		if _, err := (&Message{}).Decode(res); err == nil {
+			t.Fatalf("malformed reply %x decoded", res)
+		}
+	}
+	if _, err := (&Message{}).Decode(reply); err != nil {
+		t.Errorf("malform() changed the reply: %v", err)
+	}
+}
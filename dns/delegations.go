@@ -0,0 +1,273 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bernoussama/mercury/fqdn"
+)
+
+// DelegationOptions configures the cache of the delegations referrals
+// lead to
+type DelegationOptions struct {
+	// Cache starts resolutions at the name servers of the closest
+	// delegation referred to before instead of at the upstream, on by
+	// default
+	Cache bool `yaml:"cache"`
+	// PrefetchHits refreshes the name servers and glue of the delegations
+	// used that many times before they expire. Zero prefetches none.
+	PrefetchHits uint64 `yaml:"prefetch_hits"`
+}
+
+// limits of the delegation cache
+const (
+	// MaxDelegations caps the delegations cached, referrals past it are
+	// followed without being remembered
+	MaxDelegations = 10000
+	// DelegationPrefetchInterval is how often the delegations about to
+	// expire are looked for
+	DelegationPrefetchInterval = 30 * time.Second
+	// delegationRefreshTimeout bounds the refresh of one delegation
+	delegationRefreshTimeout = 10 * time.Second
+	// delegationRefreshBudget caps the upstream queries of one refresh
+	delegationRefreshBudget = 16
+)
+
+// delegation is a zone cut learned from a referral: the first name server
+// reachable through its glue, until the NS records of the referral expire
+type delegation struct {
+	// origin is the upstream the resolution that followed the referral
+	// started at
+	origin  Upstream
+	zone    fqdn.Name
+	address string
+	ttl     time.Duration
+	expiry  time.Time
+	hits    atomic.Uint64
+}
+
+// Delegations caches the delegations followed while resolving from the
+// root, so resolutions start at the name servers of the closest zone cut
+// instead of the root, and refreshes the hot ones ahead of expiry. They
+// are kept by upstream, the delegations found from one root are not used
+// for names resolved through another. A nil Delegations caches nothing.
+type Delegations struct {
+	prefetchHits uint64
+
+	mu    sync.Mutex
+	zones map[string]*delegation
+
+	hits           atomic.Uint64
+	prefetched     atomic.Uint64
+	prefetchFailed atomic.Uint64
+}
+
+// DelegationStats describes the delegation cache
+type DelegationStats struct {
+	Zones int `json:"zones"`
+	// Hits counts the resolutions started at a cached delegation
+	Hits           uint64 `json:"hits"`
+	Prefetched     uint64 `json:"prefetched"`
+	PrefetchFailed uint64 `json:"prefetch_failed"`
+}
+
+// NewDelegations returns the delegation cache of opts, nil when it is off
+func NewDelegations(opts DelegationOptions) *Delegations {
+	if !opts.Cache {
+		return nil
+	}
+	return &Delegations{prefetchHits: opts.PrefetchHits, zones: make(map[string]*delegation)}
+}
+
+type delegationsKey struct{}
+
+// WithDelegations has the resolutions on behalf of ctx start at the
+// delegations of d and remember the ones they follow
+func WithDelegations(ctx context.Context, d *Delegations) context.Context {
+	return context.WithValue(ctx, delegationsKey{}, d)
+}
+
+// delegationsOf returns the delegation cache of ctx, nil without one
+func delegationsOf(ctx context.Context) *Delegations {
+	d, _ := ctx.Value(delegationsKey{}).(*Delegations)
+	return d
+}
+
+// delegationKey is the key of the delegation of zone found from origin
+func delegationKey(origin string, zone fqdn.Name) string {
+	return origin + " " + string(zone)
+}
+
+// closest returns the unexpired delegation found from origin of the zone
+// closest to name, counting a hit on it
+func (d *Delegations) closest(origin, name string, now time.Time) (*delegation, bool) {
+	if d == nil {
+		return nil, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for zone := fqdn.Canonical(name); ; zone = zone.Parent() {
+		if del, ok := d.zones[delegationKey(origin, zone)]; ok && now.Before(del.expiry) {
+			del.hits.Add(1)
+			d.hits.Add(1)
+			return del, true
+		}
+		if zone == fqdn.Root {
+			return nil, false
+		}
+	}
+}
+
+// store remembers the delegation of zone found from origin, its name
+// servers reachable at address for ttl
+func (d *Delegations) store(origin Upstream, zone, address string, ttl uint32, now time.Time) {
+	if d == nil || ttl == 0 || address == "" {
+		return
+	}
+	del := &delegation{
+		origin:  origin,
+		zone:    fqdn.Canonical(zone),
+		address: address,
+		ttl:     time.Duration(ttl) * time.Second,
+	}
+	del.expiry = now.Add(del.ttl)
+	key := delegationKey(origin.Address, del.zone)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.zones[key]; !ok && len(d.zones) >= MaxDelegations {
+		d.prune(now)
+		if len(d.zones) >= MaxDelegations {
+			return
+		}
+	}
+	d.zones[key] = del
+}
+
+// forget drops the delegation of zone found from origin, when its name
+// servers no longer answer
+func (d *Delegations) forget(origin string, zone fqdn.Name) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.zones, delegationKey(origin, zone))
+}
+
+// prune drops the expired delegations, d.mu held
+func (d *Delegations) prune(now time.Time) {
+	for key, del := range d.zones {
+		if !now.Before(del.expiry) {
+			delete(d.zones, key)
+		}
+	}
+}
+
+// Stats returns the size and counters of the cache
+func (d *Delegations) Stats() DelegationStats {
+	d.mu.Lock()
+	zones := len(d.zones)
+	d.mu.Unlock()
+	return DelegationStats{Zones: zones, Hits: d.hits.Load(), Prefetched: d.prefetched.Load(), PrefetchFailed: d.prefetchFailed.Load()}
+}
+
+// due returns the delegations used PrefetchHits times since they were
+// stored that expire within a tenth of their TTL or the next prefetch
+// interval, dropping the expired ones
+func (d *Delegations) due(now time.Time) []*delegation {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune(now)
+	var due []*delegation
+	for _, del := range d.zones {
+		ahead := max(del.ttl/10, DelegationPrefetchInterval)
+		if del.hits.Load() >= d.prefetchHits && del.expiry.Sub(now) <= ahead {
+			due = append(due, del)
+		}
+	}
+	return due
+}
+
+// Prefetch refreshes the hot delegations about to expire every
+// DelegationPrefetchInterval until ctx is done. It returns at once when
+// no delegation is prefetched.
+func (d *Delegations) Prefetch(ctx context.Context) {
+	if d == nil || d.prefetchHits == 0 {
+		return
+	}
+	ticker := time.NewTicker(DelegationPrefetchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.prefetch(ctx, now)
+		}
+	}
+}
+
+// prefetch refreshes the delegations due at now
+func (d *Delegations) prefetch(ctx context.Context, now time.Time) {
+	for _, del := range d.due(now) {
+		if err := d.refresh(ctx, del); err != nil {
+			d.prefetchFailed.Add(1)
+			resolverLog.Debug("delegation prefetch failed", "zone", del.zone, "upstream", del.origin.Address, "err", err)
+			continue
+		}
+		d.prefetched.Add(1)
+		resolverLog.Debug("prefetched delegation", "zone", del.zone, "upstream", del.origin.Address)
+	}
+}
+
+// refresh asks for the NS records of the zone of del from the closest
+// cached delegation above it, so the referral to it is followed and
+// stored again. It succeeds once the referral is stored, whether the name
+// servers of the zone answer after or not.
+func (d *Delegations) refresh(ctx context.Context, del *delegation) error {
+	ctx, cancel := context.WithTimeout(ctx, delegationRefreshTimeout)
+	defer cancel()
+	ctx = WithQueryBudget(ctx, delegationRefreshBudget)
+	start, cut := del.origin, ""
+	if del.zone != fqdn.Root {
+		if parent, ok := d.closest(del.origin.Address, string(del.zone.Parent()), time.Now()); ok {
+			start.Address, cut = parent.address, string(parent.zone)
+		}
+	}
+	query := &Message{
+		Header:   Header{ID: uint16(rand.Uint32()), QDCount: 1},
+		Question: Question{DomainName: string(del.zone), QType: TypeNS, QClass: 1},
+	}
+	query.Bytes = query.Encode()
+	err := query.resolve(WithDelegations(ctx, d), del.origin, start, cut)
+	d.mu.Lock()
+	renewed := d.zones[delegationKey(del.origin.Address, del.zone)] != del
+	d.mu.Unlock()
+	if renewed {
+		return nil
+	}
+	if err == nil {
+		err = errors.New("no referral to the zone")
+	}
+	return err
+}
+
+// nsTTL returns the lowest TTL of the NS records of zone in the authority
+// section of the referral in packet
+func nsTTL(packet []byte, authority []Answer, zone string) uint32 {
+	var ttl uint32
+	found := false
+	for i := range authority {
+		if QType(authority[i].Type) != TypeNS {
+			continue
+		}
+		if owner, err := authority[i].OwnerName(packet); err != nil || fqdn.Canonical(owner) != fqdn.Canonical(zone) {
+			continue
+		}
+		if !found || authority[i].TTL < ttl {
+			ttl, found = authority[i].TTL, true
+		}
+	}
+	return ttl
+}
@@ -0,0 +1,148 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDelegationsClosest(t *testing.T) {
+	d := NewDelegations(DelegationOptions{Cache: true})
+	now := time.Now()
+	root := Upstream{Address: "198.41.0.4:53"}
+	d.store(root, "com.", "192.0.2.1:53", 3600, now)
+	d.store(root, "Example.COM", "192.0.2.2:53", 60, now)
+	tests := []struct {
+		origin string
+		name   string
+		at     time.Duration
+		want   string
+	}{
+		{root.Address, "www.example.com.", 0, "192.0.2.2:53"},
+		{root.Address, "example.com.", 0, "192.0.2.2:53"},
+		{root.Address, "www.example.net.", 0, ""},
+		{root.Address, "www.example.com.", time.Minute, "192.0.2.1:53"},
+		{"192.0.2.99:53", "www.example.com.", 0, ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if del, ok := d.closest(tt.origin, tt.name, now.Add(tt.at)); ok {
+			got = del.address
+		}
+		if got != tt.want {
+			t.Errorf("closest(%s, %s) after %v = %q, want %q", tt.origin, tt.name, tt.at, got, tt.want)
+		}
+	}
+	if stats := d.Stats(); stats.Zones != 2 || stats.Hits != 3 {
+		t.Errorf("Stats() = %+v, want 2 zones and 3 hits", stats)
+	}
+
+	var none *Delegations
+	none.store(root, "com.", "192.0.2.1:53", 3600, now)
+	if _, ok := none.closest(root.Address, "com.", now); ok {
+		t.Error("a nil cache found a delegation")
+	}
+}
+
+func TestResolveFromCachedDelegation(t *testing.T) {
+	root := Upstream{Address: replyingUpstream(t, func(b *Builder) {
+		b.Answer(record(t, "www.example.com.", TypeA, []byte{192, 0, 2, 99}))
+	}), Timeout: time.Second}
+	child := replyingUpstream(t, func(b *Builder) {
+		b.Answer(record(t, "www.example.com.", TypeA, []byte{192, 0, 2, 1}))
+	})
+	// a port nothing listens on, for name servers gone since the referral
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone := conn.LocalAddr().String()
+	conn.Close()
+
+	tests := []struct {
+		name   string
+		server string
+		want   byte
+		cached bool
+	}{
+		{"cached name servers", child, 1, true},
+		{"cached name servers gone", gone, 99, false},
+	}
+	for _, tt := range tests {
+		d := NewDelegations(DelegationOptions{Cache: true})
+		d.store(root, "example.com.", tt.server, 60, time.Now())
+		msg := &Message{Header: Header{ID: 1, QDCount: 1}, Question: Question{DomainName: "www.example.com.", QType: TypeA, QClass: 1}}
+		msg.Bytes = msg.Encode()
+		if err := msg.Resolve(WithDelegations(context.Background(), d), root); err != nil {
+			t.Fatalf("%s: Resolve() = %v", tt.name, err)
+		}
+		if len(msg.Answers) != 1 || msg.Answers[0].RData[3] != tt.want {
+			t.Errorf("%s: answers %v, want 192.0.2.%d", tt.name, msg.Answers, tt.want)
+		}
+		if _, ok := d.closest(root.Address, "www.example.com.", time.Now()); ok != tt.cached {
+			t.Errorf("%s: delegation cached %v, want %v", tt.name, ok, tt.cached)
+		}
+	}
+}
+
+func TestResolveStoresReferrals(t *testing.T) {
+	root := Upstream{Address: replyingUpstream(t, func(b *Builder) {
+		ns := nameRecord(t, "example.com.", TypeNS, "ns1.example.net.")
+		ns.TTL = 3600
+		b.Authority(ns).Additional(record(t, "ns1.example.net.", TypeA, []byte{192, 0, 2, 53}))
+	}), Timeout: 100 * time.Millisecond}
+	d := NewDelegations(DelegationOptions{Cache: true})
+	msg := &Message{Header: Header{ID: 1, QDCount: 1}, Question: Question{DomainName: "www.example.com.", QType: TypeA, QClass: 1}}
+	msg.Bytes = msg.Encode()
+	// the name servers referred to are unreachable, the referral is cached
+	// all the same
+	msg.Resolve(WithDelegations(context.Background(), d), root)
+
+	now := time.Now()
+	del, ok := d.closest(root.Address, "www.example.com.", now)
+	if !ok || del.zone != "example.com." || del.address != "192.0.2.53:53" {
+		t.Fatalf("closest() = %+v, %v, want the delegation of example.com.", del, ok)
+	}
+	if left := del.expiry.Sub(now); left < 59*time.Minute || left > time.Hour {
+		t.Errorf("delegation expires in %v, want the NS TTL of an hour", left)
+	}
+}
+
+func TestDelegationsPrefetch(t *testing.T) {
+	root := Upstream{Address: replyingUpstream(t, func(b *Builder) {
+		ns := nameRecord(t, "example.com.", TypeNS, "ns1.example.net.")
+		ns.TTL = 3600
+		b.Authority(ns).Additional(record(t, "ns1.example.net.", TypeA, []byte{192, 0, 2, 53}))
+	}), Timeout: 100 * time.Millisecond}
+	d := NewDelegations(DelegationOptions{Cache: true, PrefetchHits: 1})
+	now := time.Now()
+	d.store(root, "example.com.", "192.0.2.53:53", 60, now)
+	d.store(root, "example.org.", "192.0.2.54:53", 60, now)
+	d.closest(root.Address, "www.example.com.", now)
+
+	due := d.due(now.Add(55 * time.Second))
+	if len(due) != 1 || due[0].zone != "example.com." {
+		t.Fatalf("due() = %v, want only the delegation used", due)
+	}
+	d.prefetch(context.Background(), now.Add(55*time.Second))
+	if stats := d.Stats(); stats.Prefetched != 1 || stats.PrefetchFailed != 0 {
+		t.Errorf("Stats() = %+v, want one delegation prefetched", stats)
+	}
+	del, ok := d.closest(root.Address, "www.example.com.", now.Add(90*time.Second))
+	if !ok || del == due[0] {
+		t.Errorf("the delegation of example.com. was not renewed")
+	}
+}
+
+func TestNSTTL(t *testing.T) {
+	a := nameRecord(t, "example.com.", TypeNS, "ns1.example.net.")
+	a.TTL = 3600
+	b := nameRecord(t, "example.com.", TypeNS, "ns2.example.net.")
+	b.TTL = 300
+	other := nameRecord(t, "example.org.", TypeNS, "ns1.example.net.")
+	other.TTL = 10
+	if got := nsTTL(nil, []Answer{a, other, b}, "Example.com."); got != 300 {
+		t.Errorf("nsTTL() = %d, want the lowest of the zone, 300", got)
+	}
+}
@@ -0,0 +1,123 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// Field is one annotated part of a dissected message
+type Field struct {
+	Offset int
+	Length int
+	// Name is the path of the field, e.g. header.id or answer[0].ttl
+	Name  string
+	Value string
+}
+
+var classes = map[uint16]string{1: "IN", 3: "CH", 4: "HS", 254: "NONE", 255: "ANY"}
+
+// ClassName returns the mnemonic of a class, or CLASSnnn for unknown ones
+func ClassName(class uint16) string {
+	if name, ok := classes[class]; ok {
+		return name
+	}
+	return "CLASS" + strconv.Itoa(int(class))
+}
+
+// Dissect breaks packet down into its fields using the message decoders.
+// On malformed input it returns the fields decoded so far along with the
+// error.
+func Dissect(packet []byte) ([]Field, error) {
+	var fields []Field
+	add := func(offset, length int, name, format string, args ...any) {
+		fields = append(fields, Field{offset, length, name, fmt.Sprintf(format, args...)})
+	}
+
+	if len(packet) < headerSize {
+		return nil, errTruncated
+	}
+	var h Header
+	if err := h.Decode(packet); err != nil {
+		return nil, err
+	}
+	qr := map[uint16]string{0: "query", 1: "response"}
+	add(0, 2, "header.id", "%d", h.ID)
+	add(2, 2, "header.qr", "%d (%s)", h.QR, qr[h.QR])
+	add(2, 2, "header.opcode", "%d", h.Opcode)
+	add(2, 2, "header.aa", "%d", h.AA)
+	add(2, 2, "header.tc", "%d", h.TC)
+	add(2, 2, "header.rd", "%d", h.RD)
+	add(2, 2, "header.ra", "%d", h.RA)
+	add(2, 2, "header.z", "%d (ad %d, cd %d)", h.Z, h.Z>>1&1, h.Z&1)
+	add(2, 2, "header.rcode", "%d (%s)", h.RCODE, RcodeName(h.RCODE))
+	add(4, 2, "header.qdcount", "%d", h.QDCount)
+	add(6, 2, "header.ancount", "%d", h.ANCount)
+	add(8, 2, "header.nscount", "%d", h.NSCount)
+	add(10, 2, "header.arcount", "%d", h.ARCount)
+
+	offset := headerSize
+	for i := 0; i < int(h.QDCount); i++ {
+		prefix := fmt.Sprintf("question[%d]", i)
+		name, end, err := DecodeName(packet, offset)
+		if err != nil {
+			return fields, fmt.Errorf("%s: %w", prefix, err)
+		}
+		if end+4 > len(packet) {
+			return fields, fmt.Errorf("%s: %w", prefix, errTruncated)
+		}
+		add(offset, end-offset, prefix+".name", "%s", name)
+		add(end, 2, prefix+".type", "%s", QType(binary.BigEndian.Uint16(packet[end:])))
+		add(end+2, 2, prefix+".class", "%s", ClassName(binary.BigEndian.Uint16(packet[end+2:])))
+		offset = end + 4
+	}
+
+	sections := []struct {
+		name  string
+		count uint16
+	}{{"answer", h.ANCount}, {"authority", h.NSCount}, {"additional", h.ARCount}}
+	for _, section := range sections {
+		for i := 0; i < int(section.count); i++ {
+			prefix := fmt.Sprintf("%s[%d]", section.name, i)
+			var rr Answer
+			n, err := rr.Decode(packet[offset:])
+			if err != nil {
+				return fields, fmt.Errorf("%s: %w", prefix, err)
+			}
+			name, err := rr.OwnerName(packet)
+			if err != nil {
+				return fields, fmt.Errorf("%s: %w", prefix, err)
+			}
+			at := offset + len(rr.Name)
+			add(offset, len(rr.Name), prefix+".name", "%s", name)
+			add(at, 2, prefix+".type", "%s", QType(rr.Type))
+			if QType(rr.Type) == TypeOPT {
+				add(at+2, 2, prefix+".udpsize", "%d", rr.Class)
+				add(at+4, 4, prefix+".flags", "extended rcode %d, version %d, do %d",
+					rr.TTL>>24, rr.TTL>>16&0xFF, rr.TTL>>15&1)
+			} else {
+				add(at+2, 2, prefix+".class", "%s", ClassName(rr.Class))
+				add(at+4, 4, prefix+".ttl", "%d", rr.TTL)
+			}
+			add(at+8, 2, prefix+".rdlength", "%d", rr.RDLength)
+			if QType(rr.Type) == TypeOPT {
+				opts, err := ParseOptions(rr.RData, nil)
+				at += 10
+				for j, o := range opts {
+					add(at, 4+len(o.Data), fmt.Sprintf("%s.option[%d]", prefix, j), "%s", o)
+					at += 4 + len(o.Data)
+				}
+				if err != nil {
+					return fields, fmt.Errorf("%s: %w", prefix, err)
+				}
+			} else if rr.RDLength > 0 {
+				add(at+10, int(rr.RDLength), prefix+".rdata", "%s", rr.Data(packet))
+			}
+			offset += n
+		}
+	}
+	if offset < len(packet) {
+		add(offset, len(packet)-offset, "trailing", "%d unparsed bytes", len(packet)-offset)
+	}
+	return fields, nil
+}
@@ -0,0 +1,48 @@
+package dns
+
+import "testing"
+
+func TestDissect(t *testing.T) {
+	query := &Message{
+		Header:   Header{ID: 7, RD: 1, QDCount: 1},
+		Question: Question{DomainName: "example.com.", QType: TypeMX, QClass: 1},
+	}
+	zone := Zone{Origin: "example.com.", TTL: 300, MX: []MXRecord{{Name: "@", Host: "mail", Preference: 10}}}
+	res := NewResponse(query).Answer(zone.Lookup("example.com.", TypeMX, 1)...).Additional(NewOPT(DefaultUDPSize, true)).Encode()
+
+	fields, err := Dissect(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Field{
+		"header.id":              {0, 2, "header.id", "7"},
+		"header.qr":              {2, 2, "header.qr", "1 (response)"},
+		"question[0].name":       {12, 13, "question[0].name", "example.com."},
+		"question[0].type":       {25, 2, "question[0].type", "MX"},
+		"answer[0].rdata":        {0, 0, "answer[0].rdata", "10 mail.example.com."},
+		"additional[0].udpsize":  {0, 0, "additional[0].udpsize", "1232"},
+		"additional[0].flags":    {0, 0, "additional[0].flags", "extended rcode 0, version 0, do 1"},
+		"additional[0].rdlength": {0, 0, "additional[0].rdlength", "0"},
+	}
+	got := make(map[string]Field)
+	for _, f := range fields {
+		got[f.Name] = f
+	}
+	for name, w := range want {
+		g, ok := got[name]
+		if !ok {
+			t.Errorf("missing field %s", name)
+			continue
+		}
+		if g.Value != w.Value || (w.Length != 0 && (g.Offset != w.Offset || g.Length != w.Length)) {
+			t.Errorf("%s = %+v, want %+v", name, g, w)
+		}
+	}
+
+	// every prefix of the message fails cleanly instead of panicking
+	for n := 0; n < len(res); n++ {
+		if _, err := Dissect(res[:n]); err == nil {
+			t.Errorf("Dissect of %d/%d bytes succeeded", n, len(res))
+		}
+	}
+}
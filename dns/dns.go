@@ -2,50 +2,54 @@ package dns
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bernoussama/mercury/cache"
+	"github.com/bernoussama/mercury/fqdn"
 )
 
 const headerSize = 12
-const (
-	BUFFER_SIZE = 2048
-)
+
+// BUFFER_SIZE is the size of the buffers messages are read into and built
+// in, shared by the server and the resolver
+const BUFFER_SIZE = 2048
 
 type RecordsCache struct {
 	Records map[string]Message
 	Mu      sync.RWMutex
-}
 
-type ARecord struct {
-	Name  string `yaml:"name"`
-	Value string `yaml:"value"`
-	TTL   uint32 `yaml:"ttl"`
-}
-
-// Zone represents DNS zone data
-type Zone struct {
-	SOA    map[string]interface{}   `yaml:"soa"`
-	Origin string                   `yaml:"origin"`
-	NS     []map[string]interface{} `yaml:"ns"`
-	A      []ARecord                `yaml:"a"`
-	TTL    int                      `yaml:"ttl"`
+	// hits counts the hits of each entry, guarded by Mu for the map itself
+	hits        map[string]*atomic.Uint64
+	hitsTotal   atomic.Uint64
+	missesTotal atomic.Uint64
 }
 
 // DNS Message Structure
 type Message struct {
-	Expiry     time.Time
+	Expiry time.Time
+	// Stored is when a cached answer was stored, its TTLs are lowered by the
+	// time elapsed since
+	Stored time.Time
+	// Source is where a cached answer came from, e.g. the upstream address
+	Source     string
 	Bytes      []byte
 	Question   Question
 	Answers    []Answer
 	Authority  []Answer
 	Additional []Answer
-	Header     Header
+	// Options are the options of the OPT record among Additional, decoded
+	// with it and kept in sync by SetOptions
+	Options []Option
+	Header  Header
 }
 
 // 16bits used for bit shifting
@@ -67,11 +71,19 @@ type Header struct {
 
 // DNSQuestion represents a question in the DNS message
 type Question struct {
+	// DomainName is the name as the client sent it, whose case the
+	// response echoes
 	DomainName string
 	QType      QType
 	QClass     uint16
 }
 
+// Name returns the queried name in canonical form, which zones, the cache
+// and the blocklist compare
+func (q Question) Name() fqdn.Name {
+	return fqdn.Canonical(q.DomainName)
+}
+
 type Answer struct {
 	RData    []byte
 	Name     []byte
@@ -102,6 +114,14 @@ const (
 	TypeMINFO QType = 14
 	TypeMX    QType = 15
 	TypeTXT   QType = 16
+	TypeAAAA  QType = 28
+	TypeSRV   QType = 33
+	TypeNAPTR QType = 35
+	TypeOPT   QType = 41
+	TypeTLSA  QType = 52
+	TypeIXFR  QType = 251
+	TypeAXFR  QType = 252
+	TypeANY   QType = 255
 )
 
 var types = map[QType]string{
@@ -121,38 +141,54 @@ var types = map[QType]string{
 	TypeMINFO: "minfo",
 	TypeMX:    "mx",
 	TypeTXT:   "txt",
+	TypeAAAA:  "aaaa",
+	TypeSRV:   "srv",
+	TypeNAPTR: "naptr",
+	TypeOPT:   "opt",
+	TypeTLSA:  "tlsa",
+	TypeIXFR:  "ixfr",
+	TypeAXFR:  "axfr",
+	TypeANY:   "any",
 }
 
 func (header *Header) Encode() []byte {
-	headerBytes := make([]byte, headerSize)
-	// Encoding logic here
+	return header.AppendEncode(make([]byte, 0, headerSize))
+}
+
+// AppendEncode appends the header in wire format to buf
+func (header *Header) AppendEncode(buf []byte) []byte {
 	flags := uint16(header.QR<<15 | header.Opcode<<11 | header.AA<<10 | header.TC<<9 | header.RD<<8 | header.RA<<7 | header.Z<<4 | header.RCODE)
 
-	binary.BigEndian.PutUint16(headerBytes, header.ID)
-	binary.BigEndian.PutUint16(headerBytes[2:], flags)
-	binary.BigEndian.PutUint16(headerBytes[4:], header.QDCount)
-	binary.BigEndian.PutUint16(headerBytes[6:], header.ANCount)
-	binary.BigEndian.PutUint16(headerBytes[8:], header.NSCount)
-	binary.BigEndian.PutUint16(headerBytes[10:], header.ARCount)
-	return headerBytes
+	buf = binary.BigEndian.AppendUint16(buf, header.ID)
+	buf = binary.BigEndian.AppendUint16(buf, flags)
+	buf = binary.BigEndian.AppendUint16(buf, header.QDCount)
+	buf = binary.BigEndian.AppendUint16(buf, header.ANCount)
+	buf = binary.BigEndian.AppendUint16(buf, header.NSCount)
+	buf = binary.BigEndian.AppendUint16(buf, header.ARCount)
+	return buf
 }
 
 func (question *Question) Encode() []byte {
-	// Encoding logic here
-	dn, err := EncodeDomainName(question.DomainName)
-	if err != nil {
+	questionBytes := question.AppendEncode(make([]byte, 0, len(question.DomainName)+6))
+	if len(questionBytes) == 0 {
 		return nil
 	}
-	questionBytes := make([]byte, 0, len(dn)+4)
-	temp16 := make([]byte, 2)
-	questionBytes = append(questionBytes, dn...)
-	binary.BigEndian.PutUint16(temp16, uint16(question.QType))
-	questionBytes = append(questionBytes, temp16...)
-	binary.BigEndian.PutUint16(temp16, uint16(question.QClass))
-	questionBytes = append(questionBytes, temp16...)
 	return questionBytes
 }
 
+// AppendEncode appends the question in wire format to buf.
+// Nothing is appended when the domain name cannot be encoded.
+func (question *Question) AppendEncode(buf []byte) []byte {
+	start := len(buf)
+	buf, err := AppendDomainName(buf, question.DomainName)
+	if err != nil {
+		return buf[:start]
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(question.QType))
+	buf = binary.BigEndian.AppendUint16(buf, question.QClass)
+	return buf
+}
+
 func encodeIP(ip string) []byte {
 	ipBytes := net.ParseIP(ip)
 	if ipBytes == nil {
@@ -162,49 +198,59 @@ func encodeIP(ip string) []byte {
 }
 
 func (answer *Answer) Encode(msg *Message) []byte {
-	answerBytes := make([]byte, 0, len(answer.Name)+10+len(answer.RData))
-
-	temp16 := make([]byte, 2)
-	temp32 := make([]byte, 4)
-	answerBytes = append(answerBytes, answer.Name...)
-	binary.BigEndian.PutUint16(temp16, answer.Type)
-	answerBytes = append(answerBytes, temp16...)
-	binary.BigEndian.PutUint16(temp16, answer.Class)
-	answerBytes = append(answerBytes, temp16...)
-	binary.BigEndian.PutUint32(temp32, answer.TTL)
-	answerBytes = append(answerBytes, temp32...)
-	binary.BigEndian.PutUint16(temp16, answer.RDLength)
-	answerBytes = append(answerBytes, temp16...)
-	answerBytes = append(answerBytes, answer.RData...)
-	return answerBytes
+	return answer.AppendEncode(make([]byte, 0, answer.size()))
 }
 
-func (msg *Message) Encode() []byte {
-	headerBytes := msg.Header.Encode()
-	questionBytes := msg.Question.Encode()
-	cap := len(headerBytes) + len(questionBytes)
-	for _, answer := range msg.Answers {
-		cap += len(answer.Name) + 10 + len(answer.RData)
+// AppendEncode appends the resource record in wire format to buf
+func (answer *Answer) AppendEncode(buf []byte) []byte {
+	buf = append(buf, answer.Name...)
+	buf = binary.BigEndian.AppendUint16(buf, answer.Type)
+	buf = binary.BigEndian.AppendUint16(buf, answer.Class)
+	buf = binary.BigEndian.AppendUint32(buf, answer.TTL)
+	buf = binary.BigEndian.AppendUint16(buf, answer.RDLength)
+	buf = append(buf, answer.RData...)
+	return buf
+}
+
+// size is the encoded length of the resource record
+func (answer *Answer) size() int {
+	return len(answer.Name) + 10 + len(answer.RData)
+}
+
+// Size is the encoded length of the message
+func (msg *Message) Size() int {
+	size := headerSize + len(msg.Question.DomainName) + 6
+	for i := range msg.Answers {
+		size += msg.Answers[i].size()
 	}
-	for _, answer := range msg.Authority {
-		cap += len(answer.Name) + 10 + len(answer.RData)
+	for i := range msg.Authority {
+		size += msg.Authority[i].size()
 	}
-	for _, answer := range msg.Additional {
-		cap += len(answer.Name) + 10 + len(answer.RData)
+	for i := range msg.Additional {
+		size += msg.Additional[i].size()
 	}
-	msgBytes := make([]byte, 0, cap)
-	msgBytes = append(msgBytes, headerBytes...)
-	msgBytes = append(msgBytes, questionBytes...)
-	for _, answer := range msg.Answers {
-		msgBytes = append(msgBytes, answer.Encode(msg)...)
+	return size
+}
+
+func (msg *Message) Encode() []byte {
+	return msg.AppendEncode(make([]byte, 0, msg.Size()))
+}
+
+// AppendEncode appends the message in wire format to buf, allocating only
+// when buf lacks the capacity.
+func (msg *Message) AppendEncode(buf []byte) []byte {
+	buf = msg.Header.AppendEncode(buf)
+	buf = msg.Question.AppendEncode(buf)
+	for i := range msg.Answers {
+		buf = msg.Answers[i].AppendEncode(buf)
 	}
-	for _, answer := range msg.Authority {
-		msgBytes = append(msgBytes, answer.Encode(msg)...)
+	for i := range msg.Authority {
+		buf = msg.Authority[i].AppendEncode(buf)
 	}
-	for _, answer := range msg.Additional {
-		msgBytes = append(msgBytes, answer.Encode(msg)...)
+	for i := range msg.Additional {
+		buf = msg.Additional[i].AppendEncode(buf)
 	}
-	return msgBytes
+	return buf
 }
 
 type Decoder interface {
@@ -212,6 +258,9 @@ type Decoder interface {
 }
 
 func (header *Header) Decode(data []byte) error {
+	if len(data) < headerSize {
+		return errTruncated
+	}
 	header.ID = binary.BigEndian.Uint16(data[0:2])
 	header.QR = binary.BigEndian.Uint16(data[2:4]) >> 15
 	header.Opcode = (binary.BigEndian.Uint16(data[2:4]) >> 11) & 0x0F
@@ -234,6 +283,9 @@ func (question *Question) Decode(data []byte) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	if qOffset+4 > len(data) {
+		return 0, errTruncated
+	}
 	question.DomainName = dn
 	question.QType = QType(binary.BigEndian.Uint16(data[qOffset : qOffset+2]))
 	qOffset += 2
@@ -242,25 +294,18 @@ func (question *Question) Decode(data []byte) (int, error) {
 	return qOffset, nil
 }
 
-// checks if the name is compressed
-func nameCompressed(data []byte) bool {
-	return data[0] == 0xC0 // Compression pointer flag
-}
-
 func (answer *Answer) Decode(data []byte) (int, error) {
 	var aOffset int
-	aOffset = 0
-	if nameCompressed(data[aOffset:]) {
-		answer.Name = data[aOffset : aOffset+2] // Compression pointer
-		aOffset += 2
-	} else { // Uncompressed name
-		_, nameOffset, err := DecodeDomainName(data[aOffset:])
-		if err != nil {
-			return 0, err
-		}
-		answer.Name = data[aOffset : aOffset+nameOffset]
-		aOffset += nameOffset
+	// the name may be, or end in, a compression pointer
+	nameOffset, err := nameEnd(data)
+	if err != nil {
+		return 0, err
+	}
+	if nameOffset+10 > len(data) {
+		return 0, errTruncated
 	}
+	answer.Name = data[:nameOffset]
+	aOffset += nameOffset
 	answer.Type = binary.BigEndian.Uint16(data[aOffset : aOffset+2])
 	aOffset += 2
 	answer.Class = binary.BigEndian.Uint16(data[aOffset : aOffset+2])
@@ -269,6 +314,9 @@ func (answer *Answer) Decode(data []byte) (int, error) {
 	aOffset += 4
 	answer.RDLength = binary.BigEndian.Uint16(data[aOffset : aOffset+2])
 	aOffset += 2
+	if aOffset+int(answer.RDLength) > len(data) {
+		return 0, errTruncated
+	}
 	if answer.RDLength > 0 {
 		answer.RData = data[aOffset : aOffset+int(answer.RDLength)]
 		aOffset += int(answer.RDLength)
@@ -276,286 +324,432 @@ func (answer *Answer) Decode(data []byte) (int, error) {
 	return aOffset, nil
 }
 
-func decodeAnswers(msg *Message, data []byte) int {
-	var aOffset int
-	for i := 0; i < int(msg.Header.ANCount); i++ {
+// decodeRecords appends count records decoded from data to records. It
+// returns the records decoded before a malformed one along with the error.
+func decodeRecords(records []Answer, data []byte, count uint16) ([]Answer, int, error) {
+	var offset int
+	for i := 0; i < int(count); i++ {
 		answer := Answer{}
-		offset, err := answer.Decode(data[aOffset:])
+		n, err := answer.Decode(data[offset:])
 		if err != nil {
-			log.Fatal(err)
-			return 0
+			return records, offset, err
 		}
-		aOffset += offset
-		msg.Answers = append(msg.Answers, answer)
+		offset += n
+		records = append(records, answer)
 	}
-	return aOffset
+	return records, offset, nil
 }
 
-func decodeNS(msg *Message, data []byte) int {
-	var nsOffset int
+// Reset clears the message so it can be decoded into again,
+// keeping the capacity of its sections.
+func (msg *Message) Reset() {
+	clear(msg.Answers)
+	clear(msg.Authority)
+	clear(msg.Additional)
+	clear(msg.Options)
+	*msg = Message{
+		Answers:    msg.Answers[:0],
+		Authority:  msg.Authority[:0],
+		Additional: msg.Additional[:0],
+		Options:    msg.Options[:0],
+	}
+}
 
-	for i := 0; i < int(msg.Header.NSCount); i++ {
-		answer := Answer{}
-		offset, err := answer.Decode(data[nsOffset:])
-		if err != nil {
-			log.Fatal(err)
-			return 0
-		}
-		nsOffset += offset
-		msg.Authority = append(msg.Authority, answer)
+// Clone returns a copy of msg owning its records, without the wire bytes
+// it was decoded from
+func (msg *Message) Clone() Message {
+	clone := *msg
+	clone.Bytes = nil
+	clone.Answers = cloneAnswers(msg.Answers)
+	clone.Authority = cloneAnswers(msg.Authority)
+	clone.Additional = cloneAnswers(msg.Additional)
+	// the options alias the records, decode them from the copies
+	clone.Options = nil
+	if opt, ok := clone.opt(); ok {
+		clone.Options, _ = ParseOptions(opt.RData, nil)
 	}
-	return nsOffset
+	return clone
 }
 
-func decodeAdditional(msg *Message, data []byte) int {
-	var aOffset int
-	for i := 0; i < int(msg.Header.ARCount); i++ {
-		answer := Answer{}
-		offset, err := answer.Decode(data[aOffset:])
-		if err != nil {
-			log.Fatal(err)
-			return 0
+// Aged returns the cached msg with the TTLs of its records lowered by the
+// time elapsed since it was stored, so clients do not cache it longer than
+// the upstream allowed. The records share their names and data with msg.
+func (msg *Message) Aged(now time.Time) Message {
+	aged := *msg
+	if msg.Stored.IsZero() || !now.After(msg.Stored) {
+		return aged
+	}
+	elapsed := uint32(now.Sub(msg.Stored) / time.Second)
+	aged.Answers = ageAnswers(msg.Answers, elapsed)
+	aged.Authority = ageAnswers(msg.Authority, elapsed)
+	aged.Additional = ageAnswers(msg.Additional, elapsed)
+	return aged
+}
+
+func ageAnswers(answers []Answer, elapsed uint32) []Answer {
+	if answers == nil {
+		return nil
+	}
+	aged := make([]Answer, len(answers))
+	for i, answer := range answers {
+		// the TTL of OPT holds the extended rcode and flags
+		if QType(answer.Type) != TypeOPT {
+			answer.TTL -= min(answer.TTL, elapsed)
 		}
-		aOffset += offset
-		msg.Additional = append(msg.Additional, answer)
+		aged[i] = answer
+	}
+	return aged
+}
+
+// cloneAnswers deep copies records so they no longer alias a packet buffer
+func cloneAnswers(answers []Answer) []Answer {
+	if answers == nil {
+		return nil
+	}
+	clone := make([]Answer, len(answers))
+	for i, answer := range answers {
+		answer.Name = bytes.Clone(answer.Name)
+		answer.RData = bytes.Clone(answer.RData)
+		clone[i] = answer
 	}
-	return aOffset
+	return clone
 }
 
+// Decode reads the message in data. A malformed record ends the decoding
+// with an error, keeping the records before it. A header failing Validate
+// is an error wrapping ErrFormat, returned once the question is decoded so
+// the FORMERR response can echo it.
 func (msg *Message) Decode(data []byte) (int, error) {
-	// Decoding logic here
-	err := msg.Header.Decode(data[:headerSize])
+	if err := msg.Header.Decode(data); err != nil {
+		return 0, err
+	}
 	qOffset, err := msg.Question.Decode(data[headerSize:])
 	if err != nil {
 		return 0, err
 	}
+	if err := msg.Header.Validate(); err != nil {
+		return headerSize + qOffset, err
+	}
 
 	mSize := qOffset + headerSize
-	// if message is response
-	if msg.Header.QR == 1 {
-		// if answers count is > 0
-		if msg.Header.ANCount > 0 {
-			anOffset := decodeAnswers(msg, data[mSize:])
-			mSize += anOffset
+	var n int
+	// responses carry answers and authority, so do NOTIFY and UPDATE
+	// messages
+	if msg.Header.QR == 1 || msg.Header.Opcode != OpcodeQuery {
+		msg.Answers, n, err = decodeRecords(msg.Answers, data[mSize:], msg.Header.ANCount)
+		mSize += n
+		if err != nil {
+			return mSize, err
 		}
-		if msg.Header.NSCount > 0 {
-			nsOffset := decodeNS(msg, data[mSize:])
-			mSize += nsOffset
+		msg.Authority, n, err = decodeRecords(msg.Authority, data[mSize:], msg.Header.NSCount)
+		mSize += n
+		if err != nil {
+			return mSize, err
 		}
 	}
-	if msg.Header.ARCount > 0 {
-		adOffset := decodeAdditional(msg, data[mSize:])
-		mSize += adOffset
+	msg.Additional, n, err = decodeRecords(msg.Additional, data[mSize:], msg.Header.ARCount)
+	mSize += n
+	if err != nil {
+		return mSize, err
+	}
+	// a malformed option list keeps the options before the fault, as the
+	// OPT record is passed on as is
+	if opt, ok := msg.opt(); ok {
+		msg.Options, _ = ParseOptions(opt.RData, msg.Options[:0])
 	}
 
 	return mSize, nil
 }
 
-func Proxy(data []byte, nameServer string) ([]byte, error) {
+// Proxy sends the query in data to nameServer and returns the raw reply.
+// The exchange is abandoned as soon as ctx is done.
+func Proxy(ctx context.Context, data []byte, nameServer string) ([]byte, error) {
 	res := make([]byte, BUFFER_SIZE)
 
-	// Resolve the string address to a UDP address
-	udpAddr, err := net.ResolveUDPAddr("udp", nameServer)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-
 	// Dial to the address with UDP
-	conn, err := net.DialUDP("udp", nil, udpAddr)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", nameServer)
 	if err != nil {
-		log.Println(err)
+		resolverLog.Debug("upstream query failed", "upstream", nameServer, "err", err)
 		return nil, err
 	}
 	defer conn.Close()
 
+	// unblock the read when the query is cancelled or times out
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
 	// Send a message to the server
 	_, err = conn.Write(data)
 	if err != nil {
-		log.Println(err)
+		resolverLog.Debug("upstream query failed", "upstream", nameServer, "err", err)
 		return nil, err
 	}
 
 	// Read from the connection into the buffer
-	_, err = bufio.NewReader(conn).Read(res)
+	n, err := bufio.NewReader(conn).Read(res)
 	if err != nil {
-		log.Println(err)
-		return res, nil
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		resolverLog.Debug("upstream query failed", "upstream", nameServer, "err", err)
+		return nil, err
 	}
-	return res, nil
+	return res[:n], nil
 }
 
-func (msg *Message) Resolve(nameServer string) error {
-	// fmt.Println("nameServer: ", nameServer)
-	var newNameServer string
-	res, err := Proxy(msg.Bytes, nameServer)
-	if err != nil {
-		return err
-	}
-	message := Message{}
-	message.Decode(res)
-	if message.Header.ANCount != 0 {
-		for _, answer := range message.Answers {
-			if answer.Type == uint16(msg.Question.QType) {
-				msg.Answers = append(msg.Answers, answer)
-			}
-		}
-	} else if message.Header.NSCount != 0 {
-		for _, additional := range message.Additional {
-			if additional.Type == uint16(TypeA) {
-				newNameServer = net.IPv4(additional.RData[0], additional.RData[1], additional.RData[2], additional.RData[3]).String() + ":53"
-				break
-			}
+// Resolve follows referrals from upstream until an answer is found,
+// querying referred name servers with the same retry policy. Each referral
+// must move closer to the name: lame delegations fail with
+// ErrLameDelegation, loops and more than MaxReferrals referrals with
+// ErrReferralLoop, and answers whose aliases loop or chain too long with
+// ErrCNAMEChain. Replies whose ID or question differ from msg fail with
+// ErrMismatchedReply. Only records in the bailiwick of the servers
+// answering are kept, and referrals are only followed through the glue of
+// their name servers. With the Delegations of ctx, resolution starts at
+// the closest delegation cached, or with the RootZone copy of ctx at the
+// name servers of the TLD, and starts over from upstream when those fail.
+func (msg *Message) Resolve(ctx context.Context, upstream Upstream) error {
+	now := time.Now()
+	delegations := delegationsOf(ctx)
+	if del, ok := delegations.closest(upstream.Address, msg.Question.DomainName, now); ok {
+		trace(ctx, "forward", "starting at %s, cached name server of %s", del.address, del.zone)
+		if done, err := msg.startAt(ctx, upstream, del.address, string(del.zone)); done {
+			return err
 		}
-		err = msg.Resolve(newNameServer)
-		if err != nil {
+		delegations.forget(upstream.Address, del.zone)
+	} else if zone, address, ok := rootZoneOf(ctx).Referral(msg.Question.DomainName, now); ok {
+		trace(ctx, "forward", "starting at %s, name server of %s in the root zone copy", address, zone)
+		if done, err := msg.startAt(ctx, upstream, address, zone); done {
 			return err
 		}
 	}
-	msg.Header.QR = 1
-	msg.Header.RA = 1
-	return nil
+	return msg.resolve(ctx, upstream, upstream, "")
 }
 
-func (msg *Message) BuildResponse(zones map[string]Zone, dnsCache cache.Cache[Message], blocklist map[string]bool) []byte {
-	// msg.Additional = nil
-	msg.Authority = nil
-
-	msg.Header.RA = 1
-	zone := zones[msg.Question.DomainName]
-	if blocklist[msg.Question.DomainName] {
-
-		msg.Header.ARCount = 0
-		msg.Header.QR = 1
-		msg.Header.ANCount = 1
-
-		answer := Answer{}
+// startAt resolves from the name server at address of zone, on behalf of
+// origin. It reports false when the resolution failed and should start
+// over from origin.
+func (msg *Message) startAt(ctx context.Context, origin Upstream, address, zone string) (bool, error) {
+	start := origin
+	start.Address = address
+	err := msg.resolve(ctx, origin, start, zone)
+	if err == nil || ctx.Err() != nil {
+		return true, err
+	}
+	resolverLog.Debug("starting below the root failed", "name", msg.Question.DomainName, "zone", zone, "upstream", address, "err", err)
+	trace(ctx, "forward", "name server of %s failed: %v", zone, err)
+	return false, nil
+}
 
-		// TODO: check if record.Name is "@"...
-		name, err := EncodeDomainName(msg.Question.DomainName)
-		if err != nil {
-			return nil
+// resolve follows referrals from upstream, the name servers of the zone
+// cut, storing the delegations followed in the cache of ctx as found from
+// origin
+func (msg *Message) resolve(ctx context.Context, origin, upstream Upstream, cut string) error {
+	delegations := delegationsOf(ctx)
+	for referrals := 0; ; referrals++ {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		answer.Name = name
-		answer.Type = uint16(msg.Question.QType)
-		answer.Class = uint16(msg.Question.QClass)
-		// answer.TTL = record.TTL
-		answer.TTL = uint32(0)
-		answer.RData = encodeIP("127.0.0.1")
-		answer.RDLength = uint16(len(answer.RData))
-		msg.Answers = append(msg.Answers, answer)
-
-	} else if val, ok := dnsCache.Get(msg.Question.DomainName); ok {
-		// check if the domain is in the cache
-
-		log.Printf("Cache hit for %s until %s\n", msg.Question.DomainName, val.Expiry.Format(time.RFC822))
-		msg.Answers = val.Answers
-		msg.Authority = val.Authority
-		msg.Additional = val.Additional
-
-	} else if zone.Origin == "" && !blocklist[msg.Question.DomainName] {
-
-		log.Printf("Cache miss for %s\n", msg.Question.DomainName)
-		nameServer := "198.41.0.4" + ":53"
-
-		err := msg.Resolve(nameServer)
-		dnsCache.Set(msg.Question.DomainName, *msg, msg.Answers[0].TTL)
+		res, err := upstream.Exchange(ctx, msg.Bytes)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-
-	} else if zone.Origin != "" && !blocklist[msg.Question.DomainName] {
-		switch msg.Question.QType {
-		case TypeA:
-			for _, record := range zone.A {
-				answer := Answer{}
-
-				// TODO: check if record.Name is "@"...
-				name, err := EncodeDomainName(msg.Question.DomainName)
-				if err != nil {
-					return nil
+		message := Message{}
+		if _, err := message.Decode(res); err != nil {
+			return fmt.Errorf("response of %s: %w", upstream.Address, err)
+		}
+		if err := checkReply(&message, msg); err != nil {
+			return err
+		}
+		if message.Header.ANCount != 0 {
+			if err := checkCNAMEChain(res, message.Answers, msg.Question.DomainName); err != nil {
+				return err
+			}
+			answers, dropped := answersInBailiwick(res, message.Answers, msg.Question.DomainName, msg.Question.QType, cut)
+			if dropped > 0 {
+				resolverLog.Debug("dropped records out of bailiwick", "name", msg.Question.DomainName, "upstream", upstream.Address, "zone", cut, "records", dropped)
+				trace(ctx, "forward", "dropped %d records of %s out of the bailiwick of %q", dropped, upstream.Address, cut)
+			}
+			msg.Answers = append(msg.Answers, answers...)
+		} else if message.Header.NSCount != 0 && message.Header.RCODE == RcodeSuccess {
+			zone, servers, err := referral(res, message.Authority, msg.Question.DomainName, cut)
+			if err != nil {
+				return err
+			}
+			// without NS records the authority holds the SOA of a negative
+			// answer, final like any other
+			if zone != "" {
+				if referrals == MaxReferrals {
+					return fmt.Errorf("%w: more than %d referrals", ErrReferralLoop, MaxReferrals)
 				}
-				answer.Name = name
-				answer.Type = uint16(msg.Question.QType)
-				answer.Class = uint16(msg.Question.QClass)
-				// answer.TTL = record.TTL
-				answer.TTL = uint32(0)
-				answer.RData = encodeIP(record.Value)
-				answer.RDLength = uint16(len(answer.RData))
-				msg.Answers = append(msg.Answers, answer)
+				newNameServer := glueAddress(glueInBailiwick(res, message.Additional, servers, cut))
+				if newNameServer == "" && selfReferential(zone, servers) {
+					return fmt.Errorf("%w: name servers of %s are inside it without glue", ErrLameDelegation, zone)
+				}
+				if newNameServer == "" {
+					return errors.New("referral without glue")
+				}
+				trace(ctx, "forward", "%s referred to %s", upstream.Address, newNameServer)
+				delegations.store(origin, zone, newNameServer, nsTTL(res, message.Authority, zone), time.Now())
+				upstream.Address = newNameServer
+				cut = zone
+				continue
 			}
-		default:
 		}
-
-		msg.Header.ARCount = 0
-		msg.Header.QR = 1
-		msg.Header.ANCount = uint16(len(msg.Answers))
-
-		dnsCache.Set(msg.Question.DomainName, *msg, msg.Answers[0].TTL)
+		msg.Authority = soaInBailiwick(res, message.Authority, msg.Question.DomainName, cut)
+		msg.Header.RCODE = message.Header.RCODE
+		break
 	}
-
 	msg.Header.QR = 1
-	msg.Header.ANCount = uint16(len(msg.Answers))
-	msg.Header.NSCount = uint16(len(msg.Authority))
-	msg.Header.ARCount = uint16(len(msg.Additional))
-	headerBytes := msg.Header.Encode()
-	questionBytes := msg.Question.Encode()
-	cap := len(headerBytes) + len(questionBytes)
-	for _, answer := range msg.Answers {
-		cap += len(answer.Name) + 10 + len(answer.RData)
-	}
-	for _, answer := range msg.Authority {
-		cap += len(answer.Name) + 10 + len(answer.RData)
-	}
-	for _, answer := range msg.Additional {
-		cap += len(answer.Name) + 10 + len(answer.RData)
-	}
-	res := make([]byte, 0, cap)
-	res = append(res, headerBytes...)
-	res = append(res, questionBytes...)
+	msg.Header.RA = 1
+	return nil
+}
 
-	for _, answer := range msg.Answers {
-		res = append(res, answer.Encode(msg)...)
-	}
-	for _, answer := range msg.Authority {
-		res = append(res, answer.Encode(msg)...)
+// glueAddress returns the address of the first name server in the glue
+// records of a referral, preferring IPv4 as not every host reaches IPv6
+func glueAddress(additional []Answer) string {
+	for _, want := range []struct {
+		qtype QType
+		len   int
+	}{{TypeA, net.IPv4len}, {TypeAAAA, net.IPv6len}} {
+		for _, glue := range additional {
+			if glue.Type == uint16(want.qtype) && len(glue.RData) == want.len {
+				return net.JoinHostPort(net.IP(glue.RData).String(), "53")
+			}
+		}
 	}
-	for _, answer := range msg.Additional {
-		res = append(res, answer.Encode(msg)...)
+	return ""
+}
+
+// CacheKey returns the cache key of a question. Answers differ by type and
+// class, and with the DO bit set they carry DNSSEC records, so all of them
+// are part of the key. Names are compared case insensitively. Client subnet
+// options are stripped from the queries forwarded upstream, so they do not
+// split the cache.
+func CacheKey(question Question, dnssec bool) string {
+	key := string(question.Name()) + "/" + question.QType.String() + "/" + ClassName(question.QClass)
+	if dnssec {
+		key += "/do"
 	}
-	return res
+	return key
+}
+
+// KeyName returns the name a cache key was built from
+func KeyName(key string) string {
+	name, _, _ := strings.Cut(key, "/")
+	return name
+}
+
+// FlushName removes the cached answers of every type and class for name
+func FlushName(c cache.Cache[Message], name string) int {
+	name = string(fqdn.Canonical(name))
+	return c.DeleteFunc(func(key string) bool { return KeyName(key) == name })
+}
+
+// FlushSuffix removes the cached answers for suffix and all names below it
+func FlushSuffix(c cache.Cache[Message], suffix string) int {
+	return c.DeleteFunc(func(key string) bool { return IsSubdomain(KeyName(key), suffix) })
 }
 
 func (c *RecordsCache) Get(key string) (*Message, bool) {
 	c.Mu.RLock()
-	defer c.Mu.RUnlock()
-
-	if val, ok := c.Records[key]; ok {
-		if val.Expiry.Before(time.Now()) {
-			delete(c.Records, key)
-			return nil, false
+	val, ok := c.Records[key]
+	hits := c.hits[key]
+	c.Mu.RUnlock()
+	if !ok {
+		c.missesTotal.Add(1)
+		return nil, false
+	}
+	if val.Expiry.Before(time.Now()) {
+		c.missesTotal.Add(1)
+		c.Mu.Lock()
+		// it may have been refreshed since we looked
+		if cur, ok := c.Records[key]; ok && cur.Expiry.Before(time.Now()) {
+			c.delete(key)
 		}
-		return &val, ok
+		c.Mu.Unlock()
+		return nil, false
 	}
-	return nil, false
+	c.hitsTotal.Add(1)
+	if hits != nil {
+		hits.Add(1)
+	}
+	return &val, true
 }
 
 func (c *RecordsCache) Set(key string, msg Message, ttl uint32) {
 	c.Mu.Lock()
 	defer c.Mu.Unlock()
 
-	msg.Expiry = time.Now().Add(time.Duration(ttl) * time.Second)
+	// the message may be reused for the next query, keep our own copy
+	msg = msg.Clone()
+	msg.Stored = time.Now()
+	msg.Expiry = msg.Stored.Add(time.Duration(ttl) * time.Second)
 	c.Records[key] = msg
+	if c.hits == nil {
+		c.hits = make(map[string]*atomic.Uint64)
+	}
+	c.hits[key] = new(atomic.Uint64)
+}
+
+// delete removes key, the caller holds Mu
+func (c *RecordsCache) delete(key string) {
+	delete(c.Records, key)
+	delete(c.hits, key)
 }
 
 func (c *RecordsCache) Delete(key string) {
 	c.Mu.Lock()
 	defer c.Mu.Unlock()
-	delete(c.Records, key)
+	c.delete(key)
+}
+
+func (c *RecordsCache) DeleteFunc(match func(key string) bool) int {
+	c.Mu.Lock()
+	defer c.Mu.Unlock()
+	n := 0
+	for key := range c.Records {
+		if match(key) {
+			c.delete(key)
+			n++
+		}
+	}
+	return n
+}
+
+// Range calls fn with a copy of every unexpired entry. The cache is locked
+// for reading meanwhile, so fn must not modify it.
+func (c *RecordsCache) Range(fn func(cache.Entry[Message]) bool) {
+	c.Mu.RLock()
+	defer c.Mu.RUnlock()
+	now := time.Now()
+	for key, msg := range c.Records {
+		if msg.Expiry.Before(now) {
+			continue
+		}
+		entry := cache.Entry[Message]{Key: key, Value: &msg}
+		if hits := c.hits[key]; hits != nil {
+			entry.Hits = hits.Load()
+		}
+		if !fn(entry) {
+			return
+		}
+	}
+}
+
+func (c *RecordsCache) Stats() cache.Stats {
+	c.Mu.RLock()
+	entries := len(c.Records)
+	c.Mu.RUnlock()
+	return cache.Stats{Entries: entries, Hits: c.hitsTotal.Load(), Misses: c.missesTotal.Load()}
 }
 
 func (c *RecordsCache) Invalidate() {
 	c.Mu.Lock()
 	defer c.Mu.Unlock()
 	c.Records = make(map[string]Message)
+	c.hits = nil
 }
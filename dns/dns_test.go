@@ -0,0 +1,167 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/cache"
+)
+
+func TestMessageReset(t *testing.T) {
+	packet := testResponse().Encode()
+	msg := &Message{}
+	if _, err := msg.Decode(packet); err != nil {
+		t.Fatal(err)
+	}
+	answers := cap(msg.Answers)
+	msg.Reset()
+	if msg.Header != (Header{}) || msg.Question != (Question{}) || msg.Bytes != nil {
+		t.Errorf("Reset() left header/question set: %+v", msg)
+	}
+	if len(msg.Answers) != 0 || len(msg.Authority) != 0 || len(msg.Additional) != 0 {
+		t.Errorf("Reset() left records in sections: %+v", msg)
+	}
+	if cap(msg.Answers) != answers {
+		t.Errorf("Reset() answers capacity = %d, want %d", cap(msg.Answers), answers)
+	}
+
+	if _, err := msg.Decode(packet); err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Answers) != 1 || len(msg.Authority) != 1 || len(msg.Additional) != 2 {
+		t.Errorf("Decode() after Reset() got %d/%d/%d records, want 1/1/2",
+			len(msg.Answers), len(msg.Authority), len(msg.Additional))
+	}
+}
+
+func TestCacheSetCopiesRecords(t *testing.T) {
+	msg := testResponse()
+	dnsCache := &RecordsCache{Records: make(map[string]Message)}
+	dnsCache.Set("example.com.", *msg, 60)
+	msg.Answers[0].RData[0] = 0xFF
+
+	cached, ok := dnsCache.Get("example.com.")
+	if !ok {
+		t.Fatal("Get() ok = false, want true")
+	}
+	if cached.Answers[0].RData[0] == 0xFF {
+		t.Error("cached record changed with the message it was stored from")
+	}
+}
+
+func BenchmarkMessageDecode(b *testing.B) {
+	packet := testResponse().Encode()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg := Message{}
+		msg.Decode(packet)
+	}
+}
+
+func BenchmarkMessageDecodeReset(b *testing.B) {
+	packet := testResponse().Encode()
+	msg := &Message{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg.Reset()
+		msg.Decode(packet)
+	}
+}
+
+func TestProxyHonoursContext(t *testing.T) {
+	// an upstream that reads queries but never answers
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = Proxy(ctx, testResponse().Encode(), conn.LocalAddr().String())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Proxy() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Proxy() returned after %s, want prompt return at the deadline", elapsed)
+	}
+}
+
+func TestCacheRangeSkipsExpired(t *testing.T) {
+	dnsCache := &RecordsCache{Records: make(map[string]Message)}
+	dnsCache.Set("live", Message{}, 60)
+	dnsCache.Set("expired", Message{}, 0)
+	dnsCache.Get("live")
+	dnsCache.Get("live")
+
+	var keys []string
+	dnsCache.Range(func(e cache.Entry[Message]) bool {
+		keys = append(keys, e.Key)
+		if e.Hits != 2 {
+			t.Errorf("%s hits = %d, want 2", e.Key, e.Hits)
+		}
+		return true
+	})
+	if len(keys) != 1 || keys[0] != "live" {
+		t.Errorf("Range() visited %v, want only live", keys)
+	}
+	if stats := dnsCache.Stats(); stats.Hits != 2 {
+		t.Errorf("Stats() = %+v, want 2 hits", stats)
+	}
+}
+
+func TestMessageAged(t *testing.T) {
+	stored := time.Now()
+	msg := Message{
+		Stored:     stored,
+		Answers:    []Answer{{Type: uint16(TypeA), TTL: 300}, {Type: uint16(TypeA), TTL: 30}},
+		Authority:  []Answer{{Type: uint16(TypeNS), TTL: 3600}},
+		Additional: []Answer{NewOPT(DefaultUDPSize, true)},
+	}
+	aged := msg.Aged(stored.Add(100*time.Second + 500*time.Millisecond))
+	if aged.Answers[0].TTL != 200 || aged.Answers[1].TTL != 0 || aged.Authority[0].TTL != 3500 {
+		t.Errorf("aged TTLs %d %d %d, want 200 0 3500", aged.Answers[0].TTL, aged.Answers[1].TTL, aged.Authority[0].TTL)
+	}
+	if aged.Additional[0].TTL != msg.Additional[0].TTL {
+		t.Errorf("OPT flags changed to %#x", aged.Additional[0].TTL)
+	}
+	if msg.Answers[0].TTL != 300 {
+		t.Errorf("cached TTL changed to %d", msg.Answers[0].TTL)
+	}
+}
+
+func TestMessageDecodeShort(t *testing.T) {
+	for n := 0; n < headerSize; n++ {
+		var msg Message
+		if _, err := msg.Decode(make([]byte, n)); err == nil {
+			t.Errorf("Decode() of %d bytes succeeded, want error", n)
+		}
+	}
+}
+
+func TestMessageDecodeMalformedRecords(t *testing.T) {
+	query := Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: "example.com.", QType: TypeA, QClass: 1}}
+	response := testResponse()
+	tests := []struct {
+		name    string
+		data    []byte
+		records int
+	}{
+		// a record counted in the header but missing used to stop the server
+		{"query without its additional record", func() []byte { q := query; q.Header.ARCount = 1; return q.Encode() }(), 0},
+		{"response cut in a record", response.Encode()[:response.Size()-3], len(response.Answers) + len(response.Authority) + len(response.Additional) - 1},
+	}
+	for _, tt := range tests {
+		var msg Message
+		if _, err := msg.Decode(tt.data); err == nil {
+			t.Errorf("%s: Decode() succeeded, want error", tt.name)
+		}
+		if got := len(msg.Answers) + len(msg.Authority) + len(msg.Additional); got != tt.records {
+			t.Errorf("%s: kept %d records, want %d", tt.name, got, tt.records)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package dns
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+// DefaultUDPSize is the payload size advertised in OPT records, the value
+// recommended by DNS flag day 2020 to avoid fragmentation
+const DefaultUDPSize = 1232
+
+// ednsDO is the DNSSEC OK bit in the TTL field of an OPT record
+const ednsDO = 1 << 15
+
+// NewOPT returns an EDNS(0) OPT pseudo record, RFC 6891, advertising
+// udpSize and setting the DO bit when dnssec is true
+func NewOPT(udpSize uint16, dnssec bool) Answer {
+	opt := Answer{Name: []byte{0}, Type: uint16(TypeOPT), Class: udpSize}
+	if dnssec {
+		opt.TTL |= ednsDO
+	}
+	return opt
+}
+
+// opt returns the OPT record of msg, if it has one
+func (msg *Message) opt() (*Answer, bool) {
+	for i := range msg.Additional {
+		if QType(msg.Additional[i].Type) == TypeOPT {
+			return &msg.Additional[i], true
+		}
+	}
+	return nil, false
+}
+
+// DNSSECOK reports whether the query has the DO bit set, RFC 3225
+func (msg *Message) DNSSECOK() bool {
+	opt, ok := msg.opt()
+	return ok && opt.TTL&ednsDO != 0
+}
+
+// minUDPSize is the UDP payload every client accepts, RFC 1035 4.2.1
+const minUDPSize = 512
+
+// UDPSize returns the largest UDP response the sender of msg accepts, the
+// payload size of its OPT record or 512 bytes without one
+func (msg *Message) UDPSize() int {
+	if opt, ok := msg.opt(); ok && opt.Class > minUDPSize {
+		return int(opt.Class)
+	}
+	return minUDPSize
+}
+
+// block sizes encrypted messages are padded to a multiple of, as RFC 8467
+// recommends
+const (
+	PaddingBlockSize      = 468
+	QueryPaddingBlockSize = 128
+)
+
+// Option returns the first option with code in the OPT record of msg
+func (msg *Message) Option(code uint16) (Option, bool) {
+	opt, ok := msg.opt()
+	if !ok {
+		return Option{}, false
+	}
+	for data := opt.RData; len(data) >= 4; {
+		length := 4 + int(binary.BigEndian.Uint16(data[2:]))
+		if len(data) < length {
+			break
+		}
+		if binary.BigEndian.Uint16(data) == code {
+			return Option{Code: code, Data: data[4:length]}, true
+		}
+		data = data[length:]
+	}
+	return Option{}, false
+}
+
+// SetOptions replaces the options of the OPT record of msg, adding an OPT
+// record when msg has none and opts is not empty
+func (msg *Message) SetOptions(opts ...Option) {
+	rdata := AppendOptions(nil, opts)
+	// the section may share its records with a query, copy before editing
+	additional := make([]Answer, 0, len(msg.Additional)+1)
+	found := false
+	for _, rr := range msg.Additional {
+		if QType(rr.Type) == TypeOPT && !found {
+			rr.RData, rr.RDLength = rdata, uint16(len(rdata))
+			found = true
+		}
+		additional = append(additional, rr)
+	}
+	if !found && len(opts) > 0 {
+		opt := NewOPT(DefaultUDPSize, false)
+		opt.RData, opt.RDLength = rdata, uint16(len(rdata))
+		additional = append(additional, opt)
+	}
+	msg.Additional = additional
+	if found || len(opts) > 0 {
+		msg.Options = opts
+	}
+}
+
+// NSID sets the name server identifier option in the OPT record of the
+// response, replacing the options echoed from the query. An empty nsid
+// leaves the OPT record without options.
+func (b *Builder) NSID(nsid string) *Builder {
+	if nsid == "" {
+		b.msg.SetOptions()
+	} else {
+		b.msg.SetOptions(Option{Code: OptionNSID, Data: []byte(nsid)})
+	}
+	return b
+}
+
+// ExtendedError adds an EDE option to the OPT record of the response, RFC
+// 8914, keeping its other options. A response without an OPT record, to a
+// client without EDNS, is left as is.
+func (b *Builder) ExtendedError(ede ExtendedError) *Builder {
+	opt, ok := b.msg.opt()
+	if !ok {
+		return b
+	}
+	opts, _ := ParseOptions(opt.RData, nil)
+	b.msg.SetOptions(append(opts, ede.Option())...)
+	return b
+}
+
+// Pad sets a padding option in the OPT record of the response so its wire
+// size is a multiple of block, RFC 7830, replacing any padding echoed from
+// the query. A response without an OPT record is left as is.
+func (b *Builder) Pad(block int) *Builder {
+	b.Message().Pad(block)
+	return b
+}
+
+// Pad sets a padding option in the OPT record of msg so its wire size is a
+// multiple of block, replacing any padding it had. A message without an OPT
+// record is left as is.
+func (msg *Message) Pad(block int) {
+	opt, ok := msg.opt()
+	if !ok || block <= 0 {
+		return
+	}
+	parsed, _ := ParseOptions(opt.RData, nil)
+	opts := slices.DeleteFunc(parsed, func(o Option) bool { return o.Code == OptionPadding })
+	opts = append(opts, Option{Code: OptionPadding})
+	msg.SetOptions(opts...)
+	padding := (block - len(msg.Encode())%block) % block
+	opts[len(opts)-1].Data = make([]byte, padding)
+	msg.SetOptions(opts...)
+}
+
+// withoutOPT returns the records but for OPT records, which belong to the
+// message they came with and are not cached with its answers
+func withoutOPT(records []Answer) []Answer {
+	var kept []Answer
+	for _, rr := range records {
+		if QType(rr.Type) != TypeOPT {
+			kept = append(kept, rr)
+		}
+	}
+	return kept
+}
+
+// clientOptions are the options about the client that sent a query, never
+// passed upstream: answers tailored to one subnet would be cached for all
+// clients, and cookies are between the client and this server
+var clientOptions = []uint16{OptionClientSubnet, OptionCookie}
+
+// upstreamQuery returns the wire format of msg to send upstream, without
+// its client subnet and cookie options
+func upstreamQuery(msg *Message) []byte {
+	opt, ok := msg.opt()
+	if !ok {
+		return msg.Bytes
+	}
+	parsed, _ := ParseOptions(opt.RData, nil)
+	opts := slices.DeleteFunc(slices.Clone(parsed), func(o Option) bool { return slices.Contains(clientOptions, o.Code) })
+	if len(opts) == len(parsed) {
+		return msg.Bytes
+	}
+	query := Message{Header: msg.Header, Question: msg.Question, Additional: msg.Additional}
+	query.SetOptions(opts...)
+	query.Header.ANCount, query.Header.NSCount, query.Header.ARCount = 0, 0, uint16(len(query.Additional))
+	return query.Encode()
+}
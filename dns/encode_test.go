@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+)
+
+func testResponse() *Message {
+	zone := testZone()
+	query := &Message{
+		Header:   Header{ID: 1, RD: 1, QDCount: 1},
+		Question: Question{DomainName: "example.com.", QType: TypeMX, QClass: 1},
+	}
+	return NewResponse(query).
+		Answer(zone.Lookup("example.com.", TypeMX, 1)...).
+		Authority(zone.Lookup("example.com.", TypeNS, 1)...).
+		Additional(zone.Additional("example.com.", TypeMX, 1)...).
+		Message()
+}
+
+func TestAppendEncodeMatchesEncode(t *testing.T) {
+	msg := testResponse()
+	want := msg.Encode()
+	prefix := []byte{0xAA, 0xBB}
+	got := msg.AppendEncode(prefix)
+	if string(got[:2]) != string(prefix) || string(got[2:]) != string(want) {
+		t.Errorf("AppendEncode() = %v, want prefix followed by %v", got, want)
+	}
+}
+
+func TestAppendEncodeAllocs(t *testing.T) {
+	msg := testResponse()
+	buf := make([]byte, 0, BUFFER_SIZE)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = msg.AppendEncode(buf[:0])
+	})
+	if allocs != 0 {
+		t.Errorf("AppendEncode() allocated %v times per run, want 0", allocs)
+	}
+}
+
+func BenchmarkMessageEncode(b *testing.B) {
+	msg := testResponse()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg.Encode()
+	}
+}
+
+func BenchmarkMessageAppendEncode(b *testing.B) {
+	msg := testResponse()
+	buf := make([]byte, 0, BUFFER_SIZE)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = msg.AppendEncode(buf[:0])
+	}
+}
+
+func BenchmarkCachedResponse(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(nil) })
+	cached := testResponse()
+	dnsCache := &RecordsCache{Records: make(map[string]Message)}
+	dnsCache.Set(CacheKey(cached.Question, false), *cached, 3600)
+	query := Message{Header: cached.Header, Question: cached.Question}
+	handler := &Handler{Cache: dnsCache}
+	buf := make([]byte, 0, BUFFER_SIZE)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg := query
+		buf = handler.AppendResponse(context.Background(), buf[:0], &msg)
+	}
+}
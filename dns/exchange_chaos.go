@@ -0,0 +1,44 @@
+//go:build chaos
+
+package dns
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// chaos are the faults injected, none until SetChaos
+var chaos atomic.Pointer[Chaos]
+
+// SetChaos injects the faults of c into the upstream exchanges from now on
+func SetChaos(c Chaos) {
+	chaos.Store(&c)
+}
+
+// exchange sends the query to nameServer like Proxy, delaying it, losing
+// it or mangling the reply as the faults set ask
+func exchange(ctx context.Context, data []byte, nameServer string) ([]byte, error) {
+	c := chaos.Load()
+	if c == nil {
+		return Proxy(ctx, data, nameServer)
+	}
+	if delay := c.delay(); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if c.lost() {
+		resolverLog.Debug("chaos lost upstream query", "upstream", nameServer)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	res, err := Proxy(ctx, data, nameServer)
+	if err == nil && c.malformed() {
+		resolverLog.Debug("chaos malformed upstream reply", "upstream", nameServer)
+		res = malform(res)
+	}
+	return res, err
+}
@@ -0,0 +1,37 @@
+//go:build chaos
+
+package dns
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExchangeChaos(t *testing.T) {
+	t.Cleanup(func() { chaos.Store(nil) })
+	upstream := Upstream{Address: staticUpstream(t, TypeA, 60, []byte{192, 0, 2, 1}), Timeout: 50 * time.Millisecond, Retries: 1, Backoff: time.Millisecond}
+	query := &Message{Header: Header{ID: 7, RD: 1, QDCount: 1}, Question: Question{DomainName: "example.com.", QType: TypeA, QClass: 1}}
+	data := query.Encode()
+
+	SetChaos(Chaos{Latency: 20 * time.Millisecond})
+	start := time.Now()
+	if _, err := upstream.Exchange(context.Background(), data); err != nil || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("delayed Exchange() = %v after %s, want an answer after 20ms", err, time.Since(start))
+	}
+
+	SetChaos(Chaos{Loss: 1})
+	if _, err := upstream.Exchange(context.Background(), data); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("lost Exchange() = %v, want the attempts timing out", err)
+	}
+
+	SetChaos(Chaos{Malformed: 1})
+	res, err := upstream.Exchange(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Message{}).Decode(res); err == nil {
+		t.Error("malformed reply decoded")
+	}
+}
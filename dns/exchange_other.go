@@ -0,0 +1,11 @@
+//go:build !chaos
+
+package dns
+
+import "context"
+
+// exchange sends the query to nameServer, see exchange_chaos.go for the
+// builds injecting faults
+func exchange(ctx context.Context, data []byte, nameServer string) ([]byte, error) {
+	return Proxy(ctx, data, nameServer)
+}
@@ -0,0 +1,52 @@
+package dns
+
+import (
+	"context"
+	"sync"
+)
+
+// flight is a resolution in progress that other queries can wait on
+type flight struct {
+	done chan struct{}
+	res  Message
+	err  error
+}
+
+// flightGroup coalesces concurrent resolutions of the same question so
+// only one upstream query is sent and its answers are fanned out.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// do runs fn once for all concurrent callers with the same key, the
+// CacheKey of their question. shared reports whether the response came
+// from another caller's lookup.
+// A waiting caller gives up when its own ctx is done.
+// Callers must not modify the records of the returned response.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (Message, error)) (res Message, err error, shared bool) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	if f, ok := g.flights[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.res, f.err, true
+		case <-ctx.Done():
+			return Message{}, ctx.Err(), true
+		}
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.mu.Unlock()
+
+	f.res, f.err = fn()
+
+	g.mu.Lock()
+	delete(g.flights, key)
+	g.mu.Unlock()
+	close(f.done)
+	return f.res, f.err, false
+}
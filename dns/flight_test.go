@@ -0,0 +1,94 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupCoalesces(t *testing.T) {
+	var g flightGroup
+	var calls, shared atomic.Int32
+	release := make(chan struct{})
+	question := Question{DomainName: "example.com.", QType: TypeA, QClass: 1}
+
+	const clients = 50
+	var started, done sync.WaitGroup
+	started.Add(clients)
+	done.Add(clients)
+	for i := 0; i < clients; i++ {
+		go func() {
+			defer done.Done()
+			started.Done()
+			res, err, wasShared := g.do(context.Background(), CacheKey(question, false), func() (Message, error) {
+				calls.Add(1)
+				<-release
+				return Message{Answers: []Answer{{Type: uint16(TypeA)}}}, nil
+			})
+			if err != nil || len(res.Answers) != 1 {
+				t.Errorf("do() = %v, %v, want one answer", res.Answers, err)
+			}
+			if wasShared {
+				shared.Add(1)
+			}
+		}()
+	}
+	started.Wait()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	done.Wait()
+
+	if calls.Load() != 1 || shared.Load() != clients-1 {
+		t.Errorf("fn called %d times with %d shared results, want 1 and %d", calls.Load(), shared.Load(), clients-1)
+	}
+}
+
+func TestFlightGroupKeysByQuestion(t *testing.T) {
+	var g flightGroup
+	var calls int
+	for _, question := range []Question{
+		{DomainName: "example.com.", QType: TypeA, QClass: 1},
+		{DomainName: "example.com.", QType: TypeAAAA, QClass: 1},
+	} {
+		g.do(context.Background(), CacheKey(question, false), func() (Message, error) {
+			calls++
+			return Message{}, nil
+		})
+	}
+	if calls != 2 {
+		t.Errorf("fn called %d times for different qtypes, want 2", calls)
+	}
+}
+
+func TestFlightGroupWaiterCancel(t *testing.T) {
+	var g flightGroup
+	question := Question{DomainName: "example.com.", QType: TypeA, QClass: 1}
+	release := make(chan struct{})
+	defer close(release)
+	go g.do(context.Background(), CacheKey(question, false), func() (Message, error) {
+		<-release
+		return Message{}, nil
+	})
+	for {
+		g.mu.Lock()
+		_, inFlight := g.flights[CacheKey(question, false)]
+		g.mu.Unlock()
+		if inFlight {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err, shared := g.do(ctx, CacheKey(question, false), func() (Message, error) {
+		t.Error("fn ran while another lookup was in flight")
+		return Message{}, nil
+	})
+	if !shared || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("do() = %v, shared %v, want deadline exceeded while waiting", err, shared)
+	}
+}
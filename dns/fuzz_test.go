@@ -0,0 +1,174 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// seedPackets are captured queries and responses, the starting points of
+// the fuzz targets
+var seedPackets = []string{
+	// dig example.com A, with EDNS and a client cookie
+	"b1f201200001000000000001076578616d706c6503636f6d0000010001000029100000000000000c000a0008a1b2c3d4" +
+		"e5f60718",
+	// response to it, the answer owner compressed against the question
+	"b1f281800001000100000001076578616d706c6503636f6d0000010001c00c000100010000012c00045db8d722000029" +
+		"0200000000000000",
+	// MX response whose exchange names point into the answer section, with
+	// the addresses of the exchanges
+	"3e8a8180000100020000000206676f6f676c6503636f6d00000f0001c00c000f000100000e100009000a04736d7470c0" +
+		"0cc00c000f000100000e100009001404616c7431c02ac02a000100010000012c00044a7d8c1bc03f001c00010000012c" +
+		"00102a00145040010c000000000000001a1b",
+	// referral to the net servers with glue
+	"9c1e8100000100000002000203777777076578616d706c65036e65740000010001c018000200010002a300001101610c" +
+		"67746c642d73657276657273c018c018000200010002a30000040162c02fc02d000100010002a3000004c005061ec04a" +
+		"000100010002a3000004c0210e1e",
+	// NXDOMAIN with the SOA of the root in the authority section
+	"51908183000100000001000007696e76616c69640000010001000006000100015180004001610c726f6f742d73657276" +
+		"657273036e657400056e73746c640c766572697369676e2d67727303636f6d0078a3f174000007080000038400093a80" +
+		"00015180",
+	// query with a client subnet and an extended DNS error option
+	"0a0a0100000100000000000103777777076578616d706c6503636f6d0000010001000029100000000000001a00080007" +
+		"00011800c00002000f000b000f626c6f636b6c697374",
+}
+
+func seed(f *testing.F) {
+	for _, packet := range seedPackets {
+		data, err := hex.DecodeString(packet)
+		if err != nil {
+			f.Fatalf("seed %s: %v", packet, err)
+		}
+		f.Add(data)
+	}
+	f.Add(testResponse().Encode())
+}
+
+func FuzzMessageDecode(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg := &Message{}
+		n, err := msg.Decode(data)
+		if n > len(data) {
+			t.Fatalf("Decode() read %d bytes of %d", n, len(data))
+		}
+		for _, section := range [][]Answer{msg.Answers, msg.Authority, msg.Additional} {
+			for i := range section {
+				section[i].Data(data)
+			}
+		}
+		for _, o := range msg.Options {
+			_ = o.String()
+		}
+		clone := msg.Clone()
+		if err != nil || msg.Header.QR == 0 {
+			return
+		}
+		if _, err := EncodeDomainName(msg.Question.DomainName); err != nil {
+			return
+		}
+		// a decoded response encodes back to one with the same records
+		again := &Message{}
+		if _, err := again.Decode(clone.Encode()); err != nil {
+			t.Fatalf("Decode(Encode()) error = %v", err)
+		}
+		if len(again.Answers) != len(msg.Answers) || len(again.Authority) != len(msg.Authority) || len(again.Additional) != len(msg.Additional) {
+			t.Fatalf("Decode(Encode()) has %d/%d/%d records, want %d/%d/%d", len(again.Answers), len(again.Authority), len(again.Additional),
+				len(msg.Answers), len(msg.Authority), len(msg.Additional))
+		}
+	})
+}
+
+func FuzzHeaderDecode(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		header := Header{}
+		if err := header.Decode(data); err != nil {
+			return
+		}
+		if got := header.Encode(); !bytes.Equal(got, data[:headerSize]) {
+			t.Fatalf("Encode(Decode(%x)) = %x", data[:headerSize], got)
+		}
+	})
+}
+
+func FuzzQuestionDecode(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) < headerSize {
+			return
+		}
+		data = data[headerSize:]
+		question := Question{}
+		n, err := question.Decode(data)
+		if err != nil {
+			return
+		}
+		if n > len(data) {
+			t.Fatalf("Decode() read %d bytes of %d", n, len(data))
+		}
+		if _, err := EncodeDomainName(question.DomainName); err != nil {
+			return
+		}
+		again := Question{}
+		if _, err := again.Decode(question.Encode()); err != nil || again.QType != question.QType || again.QClass != question.QClass {
+			t.Fatalf("Decode(Encode(%+v)) = %+v, %v", question, again, err)
+		}
+	})
+}
+
+func FuzzAnswerDecode(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		answer := Answer{}
+		n, err := answer.Decode(data)
+		if err != nil {
+			return
+		}
+		if n > len(data) || int(answer.RDLength) != len(answer.RData) {
+			t.Fatalf("Decode() read %d bytes of %d, rdata %d of %d", n, len(data), len(answer.RData), answer.RDLength)
+		}
+		answer.Data(data)
+		answer.Data(nil)
+	})
+}
+
+func FuzzDecodeName(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for offset := range min(len(data), 64) {
+			if _, end, err := DecodeName(data, offset); err == nil && end > len(data) {
+				t.Fatalf("DecodeName(%d) ended at %d of %d", offset, end, len(data))
+			}
+		}
+		DecodeDomainName(data)
+	})
+}
+
+func FuzzParseOptions(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		opts, err := ParseOptions(data, nil)
+		for _, o := range opts {
+			_ = o.String()
+		}
+		if err != nil {
+			return
+		}
+		if got := AppendOptions(nil, opts); !bytes.Equal(got, data) {
+			t.Fatalf("AppendOptions(ParseOptions(%x)) = %x", data, got)
+		}
+	})
+}
+
+func FuzzDissect(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fields, _ := Dissect(data)
+		for _, field := range fields {
+			if field.Offset < 0 || field.Offset+field.Length > len(data) {
+				t.Fatalf("field %s at %d+%d outside the %d byte packet", field.Name, field.Offset, field.Length, len(data))
+			}
+		}
+	})
+}
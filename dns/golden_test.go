@@ -0,0 +1,209 @@
+package dns
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The golden files in testdata/golden hold a message in hex, a line
+// holding --, then the message in the presentation format of dig and
+// miekg/dns. testdata/golden/gen builds and packs every message with
+// miekg/dns and writes both, so both directions check mercury against
+// another implementation rather than against itself. It is a module of
+// its own, run with go run . from its directory.
+
+// readGolden returns the wire bytes and text of the golden file name
+func readGolden(t *testing.T, name string) ([]byte, string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "golden", name+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire, text, ok := strings.Cut(string(data), "\n--\n")
+	if !ok {
+		t.Fatalf("%s: no -- line between the wire bytes and the text", name)
+	}
+	var hexText strings.Builder
+	for _, line := range strings.Split(wire, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			hexText.WriteString(strings.TrimSpace(line))
+		}
+	}
+	packet, err := hex.DecodeString(hexText.String())
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return packet, text
+}
+
+// present decodes packet and prints it in presentation format
+func present(packet []byte) (string, error) {
+	msg := Message{}
+	if _, err := msg.Decode(packet); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	h := msg.Header
+	opcode := "QUERY"
+	if h.Opcode != 0 {
+		opcode = fmt.Sprintf("OPCODE%d", h.Opcode)
+	}
+	fmt.Fprintf(&b, ";; opcode: %s, status: %s, id: %d\n", opcode, RcodeName(h.RCODE), h.ID)
+	b.WriteString(";; flags:")
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"qr", h.QR == 1}, {"aa", h.AA == 1}, {"tc", h.TC == 1}, {"rd", h.RD == 1},
+		{"ra", h.RA == 1}, {"ad", h.Z&0x02 != 0}, {"cd", h.Z&0x01 != 0},
+	} {
+		if f.set {
+			b.WriteString(" " + f.name)
+		}
+	}
+	fmt.Fprintf(&b, "; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n", h.QDCount, h.ANCount, h.NSCount, h.ARCount)
+	fmt.Fprintf(&b, ";; QUESTION SECTION:\n;%s\t%s\t%s\n", msg.Question.DomainName, ClassName(msg.Question.QClass), msg.Question.QType)
+	if opt, ok := msg.opt(); ok {
+		flags := ""
+		if opt.TTL&ednsDO != 0 {
+			flags = " do"
+		}
+		fmt.Fprintf(&b, ";; OPT PSEUDOSECTION:\n; EDNS: version %d; flags:%s; udp: %d\n", opt.TTL>>16&0xFF, flags, opt.Class)
+		for _, o := range msg.Options {
+			fmt.Fprintf(&b, "; %s\n", o)
+		}
+	}
+	for _, section := range []struct {
+		name    string
+		records []Answer
+	}{{"ANSWER", msg.Answers}, {"AUTHORITY", msg.Authority}, {"ADDITIONAL", msg.Additional}} {
+		header := false
+		for i := range section.records {
+			rr := &section.records[i]
+			if QType(rr.Type) == TypeOPT {
+				continue
+			}
+			if !header {
+				fmt.Fprintf(&b, ";; %s SECTION:\n", section.name)
+				header = true
+			}
+			offset, _ := offsetIn(packet, rr.Name)
+			owner, _, err := DecodeName(packet, offset)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "%s\t%d\t%s\t%s\t%s\n", owner, rr.TTL, ClassName(rr.Class), QType(rr.Type), rr.Data(packet))
+		}
+	}
+	return b.String(), nil
+}
+
+func TestGoldenDecode(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "golden", "*.txt"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no golden files: %v", err)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".txt")
+		t.Run(name, func(t *testing.T) {
+			packet, want := readGolden(t, name)
+			got, err := present(packet)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if got != want {
+				t.Errorf("decoded as\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestGoldenEncode(t *testing.T) {
+	zone := Zone{
+		Origin: "example.com.",
+		TTL:    3600,
+		A:      []Record{{Name: "@", Value: "192.0.2.1", TTL: 300}},
+		AAAA:   []Record{{Name: "@", Value: "2001:db8::1", TTL: 300}},
+		NS:     []NSRecord{{Name: "@", Host: "ns1"}},
+		MX:     []MXRecord{{Name: "@", Host: "mail", Preference: 10}},
+		SRV:    []SRVRecord{{Name: "_sip._udp", Priority: 10, Weight: 60, Port: 5060, Target: "pbx"}},
+		NAPTR:  []NAPTRRecord{{Name: "@", Order: 100, Preference: 10, Flags: "S", Service: "SIP+D2U", Replacement: "_sip._udp"}},
+		TLSA:   []TLSARecord{{Name: "_443._tcp.www", Usage: 3, Selector: 1, MatchingType: 1, Data: "8f2a7c15e1"}},
+		Generic: []GenericRecord{
+			{Name: "opaque", Type: "TYPE65280", Data: `\# 4 0a000001`, TTL: 60},
+			{Name: "www", Type: "CNAME", Data: `\# 13 076578616d706c6503636f6d00`, TTL: 300},
+			{Name: "1.2.0.192.in-addr.arpa.", Type: "PTR", Data: `\# 17 03777777076578616d706c6503636f6d00`, TTL: 300},
+		},
+	}
+	zone.TXT = []Record{{Name: "@"}}
+
+	tests := map[string]func() []byte{
+		"query_edns": func() []byte {
+			query := &Message{
+				Header:     Header{ID: 0xb1f2, RD: 1, Z: 0x02, QDCount: 1, ARCount: 1},
+				Question:   Question{DomainName: "example.com.", QType: TypeA, QClass: 1},
+				Additional: []Answer{NewOPT(DefaultUDPSize, true)},
+			}
+			query.SetOptions(Option{Code: OptionCookie, Data: []byte{0xa1, 0xb2, 0xc3, 0xd4, 0xe5, 0xf6, 0x07, 0x18}})
+			return query.Encode()
+		},
+		"records": func() []byte {
+			query := &Message{Header: Header{ID: 1}, Question: Question{DomainName: "example.com.", QType: TypeANY, QClass: 1}}
+			res := NewResponse(query).Authoritative(true)
+			txt := Answer{Name: []byte("\x07example\x03com\x00"), Type: uint16(TypeTXT), Class: 1, TTL: 3600,
+				RData: append(encodeTXT("v=spf1 -all"), encodeTXT("second string")...)}
+			txt.RDLength = uint16(len(txt.RData))
+			for _, q := range []struct {
+				name  string
+				qtype QType
+			}{
+				{"example.com.", TypeA}, {"example.com.", TypeAAAA}, {"example.com.", TypeNS}, {"", TypeTXT},
+				{"example.com.", TypeMX}, {"_sip._udp.example.com.", TypeSRV}, {"example.com.", TypeNAPTR},
+				{"_443._tcp.www.example.com.", TypeTLSA}, {"opaque.example.com.", QType(65280)},
+				{"www.example.com.", TypeCNAME}, {"1.2.0.192.in-addr.arpa.", TypePTR},
+			} {
+				if q.qtype == TypeTXT {
+					// a TXT record of two strings has no zone form
+					res.Answer(txt)
+					continue
+				}
+				res.Answer(zone.Lookup(q.name, q.qtype, 1)...)
+			}
+			return res.Encode()
+		},
+		"edns_options": func() []byte {
+			query := &Message{
+				Header:     Header{ID: 0x0a0a, RD: 1, QDCount: 1, ARCount: 1},
+				Question:   Question{DomainName: "ads.example.com.", QType: TypeA, QClass: 1},
+				Additional: []Answer{NewOPT(DefaultUDPSize, false)},
+			}
+			res := NewResponse(query).RecursionAvailable(true).SetRcode(RcodeNameError).Additional(query.Additional...).Message()
+			res.SetOptions(
+				Option{Code: OptionNSID, Data: []byte("ns1-ams")},
+				ClientSubnet{SourcePrefix: 24, Address: []byte{192, 0, 2, 0}}.Option(),
+				ExtendedError{InfoCode: EDEBlocked, ExtraText: "blocklist"}.Option(),
+				Option{Code: OptionPadding, Data: make([]byte, 4)},
+			)
+			return res.Encode()
+		},
+		"flags": func() []byte {
+			msg := &Message{
+				Header:   Header{ID: 0xffff, QR: 1, TC: 1, Z: 0x01, RCODE: RcodeRefused, QDCount: 1},
+				Question: Question{DomainName: "version.bind.", QType: TypeTXT, QClass: ClassCHAOS},
+			}
+			return msg.Encode()
+		},
+	}
+	for name, build := range tests {
+		t.Run(name, func(t *testing.T) {
+			want, _ := readGolden(t, name)
+			if got := build(); string(got) != string(want) {
+				t.Errorf("encoded\n%x\nwant\n%x", got, want)
+			}
+		})
+	}
+}
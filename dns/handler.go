@@ -0,0 +1,632 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/budget"
+	"github.com/bernoussama/mercury/cache"
+	"github.com/bernoussama/mercury/clients"
+	"github.com/bernoussama/mercury/detect"
+	"github.com/bernoussama/mercury/logging"
+	"github.com/bernoussama/mercury/querylog"
+)
+
+var (
+	cacheLog     = logging.For(logging.Cache)
+	resolverLog  = logging.For(logging.Resolver)
+	blocklistLog = logging.For(logging.Blocklist)
+)
+
+// Handler answers queries from its blocklist, zones, cache or by
+// recursive resolution through its upstreams.
+type Handler struct {
+	// Zones are answered authoritatively, replaced with SetZones while
+	// serving
+	Zones     map[string]Zone
+	zonesMu   sync.RWMutex
+	Cache     cache.Cache[Message]
+	Blocklist *blocklist.Sinkhole
+	// Clients names the clients in logs and counts their queries
+	Clients *clients.Directory
+	// Hosts answers for the hosts of the local domain and their addresses
+	Hosts *Hosts
+	// Challenges are the ACME challenge records answered in the zones
+	Challenges *Challenges
+	// LocalZones answers the reverse zones of private addresses and the
+	// other zones served locally outside the zones
+	LocalZones *LocalZones
+
+	// Upstreams are tried in order, defaulting to RootServer
+	Upstreams []Upstream
+	// NoRecursion leaves the names outside the zones to the hosts,
+	// blocklist and cache, the other queries are answered Unanswered and
+	// zones do not forward. Queries without RD are answered the same way.
+	NoRecursion bool
+	// Unanswered is the response code of the queries nothing can answer,
+	// REFUSED when zero
+	Unanswered uint16
+	// QueryBudget caps the upstream queries sent for one client query,
+	// counting retries and referrals. Zero means no limit.
+	QueryBudget int
+	// Identity answers CHAOS class and NSID queries
+	Identity Identity
+	// SinkholeAddrs are what blocked names resolve to, the first IPv4 one
+	// for A queries and the first IPv6 one for AAAA queries. A family
+	// without an address gets no answer. Loopback addresses by default.
+	SinkholeAddrs []net.IP
+	// QueryLog keeps the history of the queries answered, none when nil
+	QueryLog *querylog.Log
+	// Tap hands the queries answered to the clients watching them live
+	Tap *querylog.Tap
+	// Detector flags clients tunneling through DNS or resolving generated
+	// names, and refuses the flagged ones over their limit
+	Detector *detect.Detector
+	// ErrorBudget counts the queries answered SERVFAIL and alerts when
+	// too many are. Nil counts none.
+	ErrorBudget *budget.Monitor
+	// Outstanding caps the resolutions in flight per client and in all,
+	// the ones past it are answered SERVFAIL. Nil caps nothing.
+	Outstanding *Outstanding
+	// Delegations caches the zone cuts referrals lead to, so resolutions
+	// start below the root. Nil caches none.
+	Delegations *Delegations
+	// RootZone is a copy of the root zone answering NXDOMAIN for the TLDs
+	// it does not hold, resolutions starting at the name servers of their
+	// TLD. Used only when resolving from the root servers, without
+	// Upstreams. Nil holds none.
+	RootZone *RootZone
+	// TTLOverrides force the TTL of the forwarded answers of some names,
+	// after the zone min_ttl. Nil overrides none.
+	TTLOverrides *TTLOverrides
+	// CacheUsage counts the cache lookups by query type and zone. Nil
+	// counts none.
+	CacheUsage *CacheUsage
+
+	// DebugTrace answers TXT queries for names under TraceSuffix with the
+	// steps resolving the name before it took
+	DebugTrace bool
+
+	// Opcodes answers the messages of the other opcodes than QUERY, those
+	// without a handler are answered NOTIMP. Set before serving.
+	Opcodes map[uint16]OpcodeHandler
+
+	flights flightGroup
+}
+
+// OpcodeHandler answers a message of one opcode, appending the response
+// to buf
+type OpcodeHandler func(ctx context.Context, buf []byte, msg *Message) []byte
+
+type clientKey struct{}
+
+// WithClient tells the handler the address of the client whose query is
+// answered under ctx, to apply the blocklist categories of its group
+func WithClient(ctx context.Context, client net.IP) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+type encryptedKey struct{}
+
+// WithEncryption tells the handler the query under ctx came over an
+// encrypted transport, to pad the response when the client asks for it
+func WithEncryption(ctx context.Context) context.Context {
+	return context.WithValue(ctx, encryptedKey{}, true)
+}
+
+type udpKey struct{}
+
+// WithUDP tells the handler the query under ctx came over UDP, to truncate
+// responses larger than the client accepts
+func WithUDP(ctx context.Context) context.Context {
+	return context.WithValue(ctx, udpKey{}, true)
+}
+
+// clientOf returns the client address of ctx, nil when unknown
+func clientOf(ctx context.Context) net.IP {
+	client, _ := ctx.Value(clientKey{}).(net.IP)
+	return client
+}
+
+// BuildResponse answers the query in msg. When ctx is done before an
+// upstream answer arrives the response is SERVFAIL.
+func (h *Handler) BuildResponse(ctx context.Context, msg *Message) []byte {
+	return h.AppendResponse(ctx, nil, msg)
+}
+
+// AppendResponse builds the response like BuildResponse, appending it to buf
+// so the server can reuse its response buffers. Queries are answered by the
+// handler, the other opcodes by their handler in Opcodes.
+func (h *Handler) AppendResponse(ctx context.Context, buf []byte, msg *Message) []byte {
+	if msg.Header.Opcode == OpcodeQuery {
+		return h.appendQuery(ctx, buf, msg)
+	}
+	if handle, ok := h.Opcodes[msg.Header.Opcode]; ok {
+		return handle(ctx, buf, msg)
+	}
+	if traceOf(ctx) != nil {
+		trace(ctx, "opcode", "%s not implemented", OpcodeName(msg.Header.Opcode))
+	}
+	resolverLog.Debug("opcode not implemented", "opcode", OpcodeName(msg.Header.Opcode), "client", clientOf(ctx))
+	return NewResponse(msg).SetRcode(RcodeNotImplemented).AppendEncode(buf)
+}
+
+// appendQuery answers a query from the blocklist, hosts, zones, cache or
+// upstreams
+func (h *Handler) appendQuery(ctx context.Context, buf []byte, msg *Message) []byte {
+	if h.DebugTrace {
+		if name, qtype, ok := traceName(msg.Question.DomainName); ok {
+			return h.appendTrace(ctx, buf, msg, name, qtype)
+		}
+	}
+	var start time.Time
+	logged := h.QueryLog != nil || h.Tap.Active()
+	if logged {
+		start = time.Now()
+	}
+	// msg.Additional = nil
+	msg.Authority = nil
+	// source is what answered, for the query log
+	var source string
+	// failure is why resolution failed, told to the client in an EDE
+	var failure error
+
+	// RD=0 asks for what the server holds, names it would resolve are
+	// left unanswered
+	recurse := !h.NoRecursion && msg.Header.RD == 1
+	res := NewResponse(msg).RecursionAvailable(!h.NoRecursion)
+	key := CacheKey(msg.Question, msg.DNSSECOK())
+	zone, _ := FindZone(h.zones(), msg.Question.DomainName)
+	client := clientOf(ctx)
+	verdict, blocked := h.Blocklist.Match(client, msg.Question.DomainName)
+	category := verdict.Category
+	// dropped queries get no response, by the rule of their list
+	dropped := false
+	// steps are only formatted for traced queries, formatting allocates
+	traced := traceOf(ctx) != nil
+	if traced {
+		h.traceBlocklist(ctx, client, msg.Question.DomainName)
+	}
+	if blocked && h.Blocklist.DryRun() {
+		blocklistLog.Info("would block query", "name", msg.Question.DomainName, "category", category, "client", client, "device", h.Clients.Name(client))
+		trace(ctx, "blocklist", "dry run, resolving instead of blocking")
+		blocked = false
+	}
+	if blocked && !verdict.Rule.Blocks() {
+		blocklistLog.Info("matched log-only rule", "name", msg.Question.DomainName, "list", verdict.List, "category", category, "client", client, "device", h.Clients.Name(client))
+		trace(ctx, "blocklist", "log-only rule, resolving")
+		blocked = false
+	}
+	h.Clients.Count(client, blocked)
+	if h.Detector != nil && h.Detector.Check(client, msg.Question.DomainName, time.Now()) {
+
+		resolverLog.Debug("rate limited flagged client", "name", msg.Question.DomainName, "client", client, "device", h.Clients.Name(client))
+		trace(ctx, "detect", "client flagged and over its limit, refused")
+		source = "detect"
+		res.SetRcode(RcodeRefused)
+
+	} else if msg.Question.QClass == ClassCHAOS {
+
+		trace(ctx, "identity", "CHAOS class, answered with the server identity")
+		source = "identity"
+		h.Identity.chaos(res, msg.Question)
+
+	} else if blocked {
+
+		source = "blocklist"
+		action := verdict.Rule.Kind()
+		blocklistLog.Debug("blocked query", "name", msg.Question.DomainName, "category", category, "client", client, "device", h.Clients.Name(client))
+		switch action {
+		case blocklist.ActionNXDomain:
+			trace(ctx, "sinkhole", "answered NXDOMAIN")
+			res.SetRcode(RcodeNameError)
+		case blocklist.ActionRefuse:
+			trace(ctx, "sinkhole", "answered REFUSED")
+			res.SetRcode(RcodeRefused)
+		case blocklist.ActionDrop:
+			trace(ctx, "sinkhole", "dropped without an answer")
+			dropped = true
+		default:
+			// only addresses are synthesized, other types of a blocked name
+			// have no records
+			if msg.Question.QType != TypeA && msg.Question.QType != TypeAAAA {
+				trace(ctx, "sinkhole", "no %s records for a blocked name, answered without records", msg.Question.QType)
+				break
+			}
+			addrs := h.SinkholeAddrs
+			if action == blocklist.ActionRedirect {
+				addrs = verdict.Rule.RedirectIPs()
+			}
+			answer := Answer{}
+
+			name, err := EncodeDomainName(msg.Question.DomainName)
+			if err != nil {
+				return buf
+			}
+			answer.Name = name
+			answer.Type = uint16(msg.Question.QType)
+			answer.Class = uint16(msg.Question.QClass)
+			// answer.TTL = record.TTL
+			answer.TTL = uint32(0)
+			answer.RData = sinkholeAddr(addrs, msg.Question.QType == TypeAAAA)
+			answer.RDLength = uint16(len(answer.RData))
+			if answer.RData != nil {
+				if traced {
+					trace(ctx, "sinkhole", "answered with %s", net.IP(answer.RData))
+				}
+				res.Answer(answer)
+			} else {
+				trace(ctx, "sinkhole", "no %s address of the family, answered without records", action)
+			}
+		}
+		res.Additional(msg.Additional...)
+
+	} else if answers, rcode, ok := h.Hosts.Lookup(msg.Question.DomainName, msg.Question.QType, msg.Question.QClass); ok {
+
+		if traced {
+			trace(ctx, "hosts", "local host, answered %s with %d records", RcodeName(rcode), len(answers))
+		}
+		source = "hosts"
+		res.SetRcode(rcode).Answer(answers...).Additional(msg.Additional...)
+
+	} else if answers, authority, rcode, ok := h.localZone(zone, msg.Question); ok {
+
+		if traced {
+			trace(ctx, "local", "locally served zone, answered %s with %d records", RcodeName(rcode), len(answers))
+		}
+		source = "local"
+		res.SetRcode(rcode).Authoritative(true).Answer(answers...).Authority(authority...).Additional(msg.Additional...)
+
+	} else if zone.Origin != "" && (msg.Question.QType == TypeAXFR || msg.Question.QType == TypeIXFR) {
+
+		// transfers are not served yet, tell allowed clients so
+		source = "zone"
+		if zone.AllowsTransfer(client) {
+			if traced {
+				trace(ctx, "zone", "transfer of %s allowed but not implemented", zone.Origin)
+			}
+			res.SetRcode(RcodeNotImplemented)
+		} else {
+			if traced {
+				trace(ctx, "zone", "transfer of %s refused to the client", zone.Origin)
+			}
+			res.SetRcode(RcodeRefused)
+		}
+
+	} else if val, ok := h.cacheGet(key, msg.Question, zone); ok {
+		// check if the question is in the cache
+
+		cacheLog.Debug("cache hit", "key", key, "until", val.Expiry)
+		if traced {
+			trace(ctx, "cache", "hit, resolved by %s, expires %s", val.Source, val.Expiry.Format(time.RFC3339))
+		}
+		source = "cache"
+		aged := val.Aged(time.Now())
+		// the OPT record is the query's, not that of the query first cached
+		res.SetRcode(aged.Header.RCODE).Answer(aged.Answers...).Authority(aged.Authority...).Additional(withoutOPT(aged.Additional)...).Additional(msg.Additional...)
+
+	} else if soa, ok := h.noSuchTLD(zone, msg.Question.DomainName); ok {
+
+		trace(ctx, "root", "no such TLD in the root zone copy, answered NXDOMAIN")
+		source = "root"
+		res.SetRcode(RcodeNameError).Authority(soa).Additional(msg.Additional...)
+
+	} else if zone.Origin == "" && !recurse {
+
+		cacheLog.Debug("cache miss", "key", key)
+		if traced {
+			trace(ctx, "cache", "miss, recursion disabled or not desired, answered %s", RcodeName(h.unanswered()))
+		}
+		source = "none"
+		res.SetRcode(h.unanswered()).Additional(msg.Additional...)
+
+	} else if zone.Origin == "" && !blocked {
+
+		cacheLog.Debug("cache miss", "key", key)
+		trace(ctx, "cache", "miss")
+		source = "upstream"
+		if root := h.rootZone(); root != nil {
+			ctx = withRootZone(ctx, root)
+		}
+		resolved, err := h.forward(ctx, msg, key, h.upstreams(), 0)
+		if err != nil {
+			res.SetRcode(RcodeServerFailure)
+			failure = err
+		} else {
+			res.SetRcode(resolved.Header.RCODE)
+		}
+		res.Answer(resolved.Answers...).Authority(resolved.Authority...).Additional(msg.Additional...)
+
+	} else if zone.Origin != "" && !blocked {
+		source = "zone"
+		if traced {
+			trace(ctx, "zone", "in zone %s", zone.Origin)
+		}
+		if authority, glue, ok := zone.Delegation(msg.Question.DomainName, msg.Question.QClass); ok {
+			// referral to the child zone, we are not authoritative for it
+			if traced {
+				trace(ctx, "zone", "delegated, referred to %d name servers", len(authority))
+			}
+			res.Authority(authority...).Additional(msg.Additional...).Additional(glue...)
+		} else if answers := append(zone.Lookup(msg.Question.DomainName, msg.Question.QType, msg.Question.QClass), h.Challenges.Lookup(msg.Question.DomainName, msg.Question.QType, msg.Question.QClass)...); len(answers) == 0 && len(zone.Forward) > 0 && recurse {
+			trace(ctx, "zone", "no records, forwarding to the zone's servers")
+			resolved, err := h.forward(ctx, msg, key, zone.Forward, zone.MinTTL)
+			if err != nil {
+				res.SetRcode(RcodeServerFailure)
+				failure = err
+			} else {
+				res.SetRcode(resolved.Header.RCODE)
+			}
+			res.Answer(resolved.Answers...).Authority(resolved.Authority...).Additional(msg.Additional...)
+		} else {
+			if len(answers) == 0 && !zone.Exists(msg.Question.DomainName) && !h.Challenges.Exists(msg.Question.DomainName) {
+				trace(ctx, "zone", "no such name")
+				res.SetRcode(RcodeNameError)
+			} else {
+				if traced {
+					trace(ctx, "zone", "answered with %d records", len(answers))
+				}
+			}
+			if len(answers) == 0 {
+				// the SOA lets resolvers cache the negative answer
+				res.Authority(zone.NegativeAuthority(msg.Question.QClass)...)
+			}
+			res.Authoritative(zone.IsAuthoritative()).
+				Answer(answers...).
+				Additional(msg.Additional...).
+				Additional(zone.Additional(msg.Question.DomainName, msg.Question.QType, msg.Question.QClass)...)
+		}
+	}
+
+	if _, ok := msg.Option(OptionNSID); ok {
+		res.NSID(h.Identity.NSID)
+	}
+	if ede, ok := extendedError(failure); ok {
+		res.ExtendedError(ede)
+	}
+	// padding in the clear would only waste bytes, RFC 8467
+	if _, ok := msg.Option(OptionPadding); ok && ctx.Value(encryptedKey{}) != nil {
+		res.Pad(PaddingBlockSize)
+	}
+	out := buf
+	if !dropped {
+		out = res.AppendEncode(buf)
+		if ctx.Value(udpKey{}) != nil && len(out)-len(buf) > msg.UDPSize() {
+			out = res.Truncate().AppendEncode(buf)
+		}
+	}
+	if logged {
+		h.logQuery(msg, res.Message(), client, source, category, start)
+	}
+	if h.ErrorBudget != nil {
+		h.ErrorBudget.Count(time.Now(), res.Message().Header.RCODE == RcodeServerFailure, timedOut(failure))
+	}
+	return out
+}
+
+// timedOut reports whether resolution failed with err because the
+// upstreams or the query deadline ran out of time
+func timedOut(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// cacheGet looks key up in the cache, counting the hit or miss under the
+// type of question and zone, the zone holding the name if any
+func (h *Handler) cacheGet(key string, question Question, zone Zone) (*Message, bool) {
+	val, ok := h.Cache.Get(key)
+	if h.CacheUsage != nil {
+		origin := zone.Origin
+		if origin == "" {
+			origin = cacheZone(nil, question.DomainName)
+		}
+		h.CacheUsage.count(question.QType.String(), origin, ok)
+	}
+	return val, ok
+}
+
+// CacheUsageStats breaks the cache lookups and entries down by query type
+// and zone
+func (h *Handler) CacheUsageStats() CacheUsageStats {
+	return h.CacheUsage.Stats(h.Cache, h.zones())
+}
+
+// unanswered returns the response code of the queries nothing can answer
+func (h *Handler) unanswered() uint16 {
+	if h.Unanswered == RcodeSuccess {
+		return RcodeRefused
+	}
+	return h.Unanswered
+}
+
+// logQuery adds the answer to a query to the query log and tap
+func (h *Handler) logQuery(msg, res *Message, client net.IP, source, category string, start time.Time) {
+	if source != "blocklist" {
+		category = ""
+	}
+	address := ""
+	if client != nil {
+		address = client.String()
+	}
+	e := querylog.Entry{
+		Time:     start,
+		Client:   address,
+		Device:   h.Clients.Name(client),
+		Name:     msg.Question.DomainName,
+		Type:     msg.Question.QType.String(),
+		Rcode:    RcodeName(res.Header.RCODE),
+		Answers:  len(res.Answers),
+		Source:   source,
+		Category: category,
+		Duration: time.Since(start),
+	}
+	h.QueryLog.Add(e)
+	h.Tap.Add(e)
+}
+
+// forward resolves msg through the first of upstreams that answers and
+// caches the response, the TTLs of its answers raised to minTTL or set to
+// the override of the question name. Negative answers are cached for as
+// long as their SOA allows, RFC 2308. The response returned holds the
+// response code, answers and authority of the upstream. Concurrent
+// queries for the same question share one upstream lookup. Resolutions
+// past the caps of Outstanding fail with ErrTooManyQueries.
+func (h *Handler) forward(ctx context.Context, msg *Message, key string, upstreams []Upstream, minTTL uint32) (Message, error) {
+	if h.QueryBudget > 0 {
+		ctx = WithQueryBudget(ctx, h.QueryBudget)
+	}
+	if h.Delegations != nil {
+		ctx = WithDelegations(ctx, h.Delegations)
+	}
+	client := clientOf(ctx)
+	if err := h.Outstanding.acquire(client); err != nil {
+		resolverLog.Debug("resolution refused", "name", msg.Question.DomainName, "client", client, "err", err)
+		trace(ctx, "forward", "refused, %v", err)
+		return Message{}, err
+	}
+	defer h.Outstanding.release(client)
+	resolved, err, _ := h.flights.do(ctx, key, func() (Message, error) {
+		var err error
+		var source string
+		msg.Bytes = upstreamQuery(msg)
+		for _, upstream := range upstreams {
+			if err = msg.Resolve(ctx, upstream); err == nil {
+				source = upstream.Address
+				break
+			}
+			trace(ctx, "forward", "%s failed: %v", upstream.Address, err)
+		}
+		if err != nil {
+			return Message{}, err
+		}
+		trace(ctx, "forward", "resolved by %s, %s with %d answers", source, RcodeName(msg.Header.RCODE), len(msg.Answers))
+		for i := range msg.Answers {
+			msg.Answers[i].TTL = max(msg.Answers[i].TTL, minTTL)
+		}
+		if ttl, ok := h.TTLOverrides.TTL(msg.Question.DomainName); ok {
+			trace(ctx, "forward", "ttl overridden to %ds", ttl)
+			for i := range msg.Answers {
+				msg.Answers[i].TTL = ttl
+			}
+		}
+		var ttl uint32
+		cached := false
+		switch {
+		case len(msg.Answers) > 0:
+			ttl, cached = msg.Answers[0].TTL, true
+		case msg.Header.RCODE == RcodeSuccess || msg.Header.RCODE == RcodeNameError:
+			ttl, cached = negativeTTL(msg.Authority)
+			// the SOA lives no longer than the negative answer it tells of
+			for i := range msg.Authority {
+				msg.Authority[i].TTL = min(msg.Authority[i].TTL, ttl)
+			}
+		}
+		if cached {
+			trace(ctx, "cache", "stored for %ds", ttl)
+			entry := *msg
+			entry.Source = source
+			entry.Additional, entry.Options = withoutOPT(msg.Additional), nil
+			h.Cache.Set(key, entry, ttl)
+		}
+		// msg is reused after the response is sent, waiters need their own copy
+		return Message{
+			Header:    Header{RCODE: msg.Header.RCODE},
+			Answers:   cloneAnswers(msg.Answers),
+			Authority: cloneAnswers(msg.Authority),
+		}, nil
+	})
+	if err != nil {
+		resolverLog.Warn("resolution failed", "name", msg.Question.DomainName, "err", err)
+	}
+	return resolved, err
+}
+
+// SetZones replaces the zones of a serving handler
+func (h *Handler) SetZones(zones map[string]Zone) {
+	h.zonesMu.Lock()
+	h.Zones = zones
+	h.zonesMu.Unlock()
+}
+
+// CurrentZones returns the zones the handler answers from
+func (h *Handler) CurrentZones() map[string]Zone {
+	return h.zones()
+}
+
+func (h *Handler) zones() map[string]Zone {
+	h.zonesMu.RLock()
+	defer h.zonesMu.RUnlock()
+	return h.Zones
+}
+
+// traceBlocklist records the list blocking name for client, or why none
+// does, without counting a query
+func (h *Handler) traceBlocklist(ctx context.Context, client net.IP, name string) {
+	if h.Blocklist == nil {
+		trace(ctx, "blocklist", "sinkhole disabled")
+		return
+	}
+	if verdict, ok := h.Blocklist.Explain(client, name); ok {
+		trace(ctx, "blocklist", "listed in %s, category %s, action %s", verdict.List, verdict.Category, verdict.Rule.Kind())
+		return
+	}
+	trace(ctx, "blocklist", "not listed")
+}
+
+// sinkholeAddr returns the address of addrs blocked names resolve to in
+// wire format, nil when none of the family is given. Without addresses
+// they resolve to loopback.
+func sinkholeAddr(addrs []net.IP, ipv6 bool) []byte {
+	if len(addrs) == 0 {
+		if ipv6 {
+			return net.IPv6loopback
+		}
+		return net.IPv4(127, 0, 0, 1).To4()
+	}
+	for _, ip := range addrs {
+		if ip4 := ip.To4(); ip4 != nil && !ipv6 {
+			return ip4
+		} else if ip4 == nil && ipv6 {
+			return ip.To16()
+		}
+	}
+	return nil
+}
+
+// rootZone returns the root zone copy resolutions through the upstreams
+// use, nil unless they are the root servers
+func (h *Handler) rootZone() *RootZone {
+	if len(h.Upstreams) > 0 {
+		return nil
+	}
+	return h.RootZone
+}
+
+// localZone answers question from the locally served zones when it is
+// outside the zones
+func (h *Handler) localZone(zone Zone, question Question) ([]Answer, []Answer, uint16, bool) {
+	if zone.Origin != "" {
+		return nil, nil, RcodeSuccess, false
+	}
+	return h.LocalZones.Lookup(question.DomainName, question.QType, question.QClass)
+}
+
+// noSuchTLD returns the SOA of the root to answer NXDOMAIN with for a name
+// outside the zones under a TLD the root zone copy does not hold
+func (h *Handler) noSuchTLD(zone Zone, name string) (Answer, bool) {
+	if zone.Origin != "" {
+		return Answer{}, false
+	}
+	return h.rootZone().NoSuchTLD(name, time.Now())
+}
+
+func (h *Handler) upstreams() []Upstream {
+	if len(h.Upstreams) == 0 {
+		return []Upstream{RootServer}
+	}
+	return h.Upstreams
+}
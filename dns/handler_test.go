@@ -0,0 +1,701 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/budget"
+	"github.com/bernoussama/mercury/privacy"
+	"github.com/bernoussama/mercury/querylog"
+)
+
+func TestCacheKey(t *testing.T) {
+	a := Question{DomainName: "Example.COM.", QType: TypeA, QClass: 1}
+	tests := []struct {
+		question Question
+		dnssec   bool
+		same     bool
+	}{
+		{Question{DomainName: "example.com.", QType: TypeA, QClass: 1}, false, true},
+		{Question{DomainName: "example.com", QType: TypeA, QClass: 1}, false, true},
+		{Question{DomainName: "example.com.", QType: TypeAAAA, QClass: 1}, false, false},
+		{Question{DomainName: "example.com.", QType: TypeA, QClass: 3}, false, false},
+		{Question{DomainName: "example.com.", QType: TypeA, QClass: 1}, true, false},
+	}
+	for _, tt := range tests {
+		if same := CacheKey(tt.question, tt.dnssec) == CacheKey(a, false); same != tt.same {
+			t.Errorf("CacheKey(%v, %v) == CacheKey(%v) is %v, want %v", tt.question, tt.dnssec, a, same, tt.same)
+		}
+	}
+}
+
+func TestHandlerCacheNoCrossTypePollution(t *testing.T) {
+	zone := testZone()
+	aaaa := &Message{Question: Question{DomainName: "example.com.", QType: TypeAAAA, QClass: 1}}
+	aaaa.Answers = zone.Lookup("example.com.", TypeAAAA, 1)
+	if len(aaaa.Answers) == 0 {
+		t.Fatal("test zone has no AAAA records")
+	}
+
+	dnsCache := &RecordsCache{Records: make(map[string]Message)}
+	dnsCache.Set(CacheKey(aaaa.Question, false), *aaaa, 60)
+	// an unreachable upstream makes cache misses fail fast
+	handler := &Handler{
+		Cache:     dnsCache,
+		Upstreams: []Upstream{{Address: "127.0.0.1:1", Timeout: 20 * time.Millisecond, Backoff: time.Millisecond}},
+	}
+
+	tests := []struct {
+		name   string
+		query  Question
+		dnssec bool
+		hit    bool
+	}{
+		{"same question", aaaa.Question, false, true},
+		{"different case", Question{DomainName: "EXAMPLE.com.", QType: TypeAAAA, QClass: 1}, false, true},
+		{"other type", Question{DomainName: "example.com.", QType: TypeA, QClass: 1}, false, false},
+		{"other class", Question{DomainName: "example.com.", QType: TypeAAAA, QClass: 3}, false, false},
+		{"dnssec ok", aaaa.Question, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: tt.query}
+			if tt.dnssec {
+				query.Additional = []Answer{NewOPT(DefaultUDPSize, true)}
+				query.Header.ARCount = 1
+			}
+			res := Message{}
+			if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+				t.Fatal(err)
+			}
+			if hit := res.Header.RCODE == RcodeSuccess && res.Header.ANCount > 0; hit != tt.hit {
+				t.Fatalf("cache hit = %v (rcode %d, %d answers), want %v", hit, res.Header.RCODE, res.Header.ANCount, tt.hit)
+			}
+			for _, answer := range res.Answers {
+				if QType(answer.Type) != tt.query.QType {
+					t.Errorf("answered %v to a %v query", QType(answer.Type), tt.query.QType)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerSinkholeAddressFamily(t *testing.T) {
+	handler := &Handler{
+		Cache:     &RecordsCache{Records: make(map[string]Message)},
+		Blocklist: blocked("blocked.test."),
+	}
+	// other types than addresses get no records, an address would not be
+	// their RDATA
+	for qtype, want := range map[QType]string{TypeA: "127.0.0.1", TypeAAAA: "::1", TypeMX: "", TypeTXT: "", QType(65): ""} {
+		query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: "blocked.test.", QType: qtype, QClass: 1}}
+		res := Message{}
+		if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+			t.Fatal(err)
+		}
+		if want == "" {
+			if res.Header.RCODE != RcodeSuccess || len(res.Answers) != 0 {
+				t.Errorf("%v: %s with answers %v, want NOERROR without records", qtype, RcodeName(res.Header.RCODE), res.Answers)
+			}
+			continue
+		}
+		if len(res.Answers) != 1 || net.IP(res.Answers[0].RData).String() != want {
+			t.Errorf("%v: answers %v, want %s", qtype, res.Answers, want)
+		}
+	}
+}
+
+func TestHandlerSinkholeDryRun(t *testing.T) {
+	sinkhole := blocked("blocked.test.")
+	sinkhole.SetDryRun(true)
+	handler := &Handler{
+		Cache:     &RecordsCache{Records: make(map[string]Message)},
+		Blocklist: sinkhole,
+		Upstreams: []Upstream{{Address: staticUpstream(t, TypeA, 60, []byte{192, 0, 2, 7}), Timeout: time.Second}},
+	}
+	query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: "blocked.test.", QType: TypeA, QClass: 1}}
+	query.Bytes = query.Encode()
+	data := handler.BuildResponse(context.Background(), query)
+	res := Message{}
+	if _, err := res.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Answers) != 1 || res.Answers[0].Data(data) != "192.0.2.7" {
+		t.Errorf("answers %v, want the upstream address", res.Answers)
+	}
+	if stats := sinkhole.Stats(); !stats.DryRun || stats.Categories[blocklist.CategoryBlocklist] != 1 {
+		t.Errorf("stats = %+v, want a dry run counting the query", stats)
+	}
+}
+
+func TestHandlerUnanswered(t *testing.T) {
+	zone := testZone()
+	cached := &Message{Question: Question{DomainName: "example.com.", QType: TypeAAAA, QClass: 1}}
+	cached.Answers = zone.Lookup("example.com.", TypeAAAA, 1)
+	dnsCache := &RecordsCache{Records: make(map[string]Message)}
+	dnsCache.Set(CacheKey(cached.Question, false), *cached, 60)
+
+	tests := []struct {
+		name       string
+		unanswered uint16
+		query      string
+		rcode      uint16
+		answers    int
+	}{
+		{"refused by default", 0, "nowhere.test.", RcodeRefused, 0},
+		{"servfail", RcodeServerFailure, "nowhere.test.", RcodeServerFailure, 0},
+		{"cached", 0, "example.com.", RcodeSuccess, len(cached.Answers)},
+	}
+	for _, tt := range tests {
+		// no upstream is reachable, the query must not get that far
+		handler := &Handler{
+			Cache:       dnsCache,
+			Upstreams:   []Upstream{{Address: "127.0.0.1:1", Timeout: time.Second}},
+			NoRecursion: true,
+			Unanswered:  tt.unanswered,
+		}
+		query := &Message{Header: Header{ID: 7, RD: 1, QDCount: 1}, Question: Question{DomainName: tt.query, QType: TypeAAAA, QClass: 1}}
+		data := handler.BuildResponse(context.Background(), query)
+		res := Message{}
+		if _, err := res.Decode(data); err != nil {
+			t.Fatal(err)
+		}
+		h := res.Header
+		if h.RCODE != tt.rcode || len(res.Answers) != tt.answers {
+			t.Errorf("%s: %s with %d answers, want %s with %d", tt.name, RcodeName(h.RCODE), len(res.Answers), RcodeName(tt.rcode), tt.answers)
+		}
+		if tt.rcode != RcodeSuccess && (h.ID != 7 || h.QR != 1 || h.RD != 1 || h.AA != 0 || h.RA != 0 || h.QDCount != 1) {
+			t.Errorf("%s: header %+v, want the query echoed without AA and RA", tt.name, h)
+		}
+	}
+}
+
+func TestHandlerFlags(t *testing.T) {
+	zone := testZone()
+	hosts := NewHosts("lan")
+	hosts.Set(map[string][]net.IP{"laptop": {net.ParseIP("192.168.1.42")}})
+	upstream := staticUpstream(t, TypeA, 60, []byte{192, 0, 2, 7})
+
+	tests := []struct {
+		name        string
+		noRecursion bool
+		rd          uint16
+		qname       string
+		rcode       uint16
+		answers     int
+		aa, ra      uint16
+	}{
+		{"forwarded", false, 1, "www.test.", RcodeSuccess, 1, 0, 1},
+		{"recursion not desired", false, 0, "other.test.", RcodeRefused, 0, 0, 1},
+		{"zone", false, 1, "example.com.", RcodeSuccess, 1, 1, 1},
+		{"zone without rd", false, 0, "example.com.", RcodeSuccess, 1, 1, 1},
+		{"zone without recursion", true, 1, "example.com.", RcodeSuccess, 1, 1, 0},
+		{"hosts", false, 1, "laptop.lan.", RcodeSuccess, 1, 0, 1},
+		{"recursion disabled", true, 1, "www.test.", RcodeRefused, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		handler := &Handler{
+			Zones:       map[string]Zone{zone.Origin: zone},
+			Cache:       &RecordsCache{Records: make(map[string]Message)},
+			Hosts:       hosts,
+			Upstreams:   []Upstream{{Address: upstream, Timeout: time.Second}},
+			NoRecursion: tt.noRecursion,
+		}
+		query := &Message{Header: Header{ID: 1, RD: tt.rd, QDCount: 1}, Question: Question{DomainName: tt.qname, QType: TypeA, QClass: 1}}
+		query.Bytes = query.Encode()
+		data := handler.BuildResponse(context.Background(), query)
+		res := Message{}
+		if _, err := res.Decode(data); err != nil {
+			t.Fatal(err)
+		}
+		h := res.Header
+		if h.RCODE != tt.rcode || len(res.Answers) != tt.answers || h.AA != tt.aa || h.RA != tt.ra || h.RD != tt.rd {
+			t.Errorf("%s: %s with %d answers aa %d ra %d rd %d, want %s with %d aa %d ra %d rd %d", tt.name,
+				RcodeName(h.RCODE), len(res.Answers), h.AA, h.RA, h.RD, RcodeName(tt.rcode), tt.answers, tt.aa, tt.ra, tt.rd)
+		}
+	}
+}
+
+func TestHandlerOpcodes(t *testing.T) {
+	zone := testZone()
+	var notified string
+	handler := &Handler{
+		Zones: map[string]Zone{zone.Origin: zone},
+		Cache: &RecordsCache{Records: make(map[string]Message)},
+		Opcodes: map[uint16]OpcodeHandler{
+			OpcodeNotify: func(ctx context.Context, buf []byte, msg *Message) []byte {
+				notified = msg.Question.DomainName
+				return NewResponse(msg).Authoritative(true).AppendEncode(buf)
+			},
+		},
+	}
+	tests := []struct {
+		opcode  uint16
+		rcode   uint16
+		answers int
+	}{
+		{OpcodeQuery, RcodeSuccess, 1},
+		{OpcodeNotify, RcodeSuccess, 0},
+		{OpcodeUpdate, RcodeNotImplemented, 0},
+		{OpcodeStatus, RcodeNotImplemented, 0},
+		{3, RcodeNotImplemented, 0},
+	}
+	for _, tt := range tests {
+		query := &Message{Header: Header{ID: 9, Opcode: tt.opcode, QDCount: 1}, Question: Question{DomainName: "example.com.", QType: TypeA, QClass: 1}}
+		data := handler.BuildResponse(context.Background(), query)
+		res := Message{}
+		if _, err := res.Decode(data); err != nil {
+			t.Fatal(err)
+		}
+		if res.Header.RCODE != tt.rcode || len(res.Answers) != tt.answers || res.Header.Opcode != tt.opcode || res.Header.ID != 9 || res.Header.QR != 1 {
+			t.Errorf("%s: header %+v with %d answers, want %s with %d", OpcodeName(tt.opcode), res.Header, len(res.Answers), RcodeName(tt.rcode), tt.answers)
+		}
+	}
+	if notified != "example.com." {
+		t.Errorf("NOTIFY handler got %q", notified)
+	}
+}
+
+func TestHandlerQueryLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "queries.jsonl")
+	log, err := querylog.Open(querylog.Options{File: file}, privacy.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &Handler{
+		Cache:     &RecordsCache{Records: make(map[string]Message)},
+		Blocklist: blocked("blocked.test."),
+		QueryLog:  log,
+	}
+	query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: "blocked.test.", QType: TypeAAAA, QClass: 1}}
+	handler.BuildResponse(WithClient(context.Background(), net.ParseIP("192.0.2.1")), query)
+	log.Close()
+
+	var entries []querylog.Entry
+	querylog.Read(file, time.Time{}, func(e querylog.Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	want := querylog.Entry{Client: "192.0.2.1", Name: "blocked.test.", Type: "AAAA", Rcode: "NOERROR", Answers: 1, Source: "blocklist", Category: blocklist.CategoryBlocklist}
+	if len(entries) != 1 {
+		t.Fatalf("%d entries logged, want 1", len(entries))
+	}
+	got := entries[0]
+	got.Time, got.Duration = time.Time{}, 0
+	if got != want {
+		t.Errorf("logged %+v, want %+v", got, want)
+	}
+}
+
+func TestHandlerClientGroups(t *testing.T) {
+	sinkhole := blocked("blocked.test.")
+	sinkhole.SetGroups([]blocklist.GroupOptions{{Name: "trusted", Clients: []string{"10.0.0.0/8"}, Disabled: []string{blocklist.CategoryBlocklist}}})
+	handler := &Handler{
+		Cache:     &RecordsCache{Records: map[string]Message{}},
+		Blocklist: sinkhole,
+		// nothing to resolve unblocked names with
+		Upstreams: []Upstream{{Address: "127.0.0.1:1", Timeout: 10 * time.Millisecond}},
+	}
+	for client, want := range map[string]bool{"10.1.2.3": false, "192.168.1.2": true} {
+		query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: "blocked.test.", QType: TypeA, QClass: 1}}
+		ctx := WithClient(context.Background(), net.ParseIP(client))
+		res := Message{}
+		if _, err := res.Decode(handler.BuildResponse(ctx, query)); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(res.Answers) == 1 && res.Header.RCODE == RcodeSuccess; got != want {
+			t.Errorf("client %s: blocked = %v, want %v", client, got, want)
+		}
+	}
+}
+
+func TestHandlerSinkholeAddrs(t *testing.T) {
+	handler := &Handler{
+		Cache:     &RecordsCache{Records: make(map[string]Message)},
+		Blocklist: blocked("blocked.test."),
+	}
+	tests := []struct {
+		addrs []net.IP
+		qtype QType
+		want  string
+	}{
+		{[]net.IP{net.ParseIP("192.168.1.2"), net.ParseIP("fd00::2")}, TypeA, "192.168.1.2"},
+		{[]net.IP{net.ParseIP("192.168.1.2"), net.ParseIP("fd00::2")}, TypeAAAA, "fd00::2"},
+		// no address of the family, browsers fall back to the other one
+		{[]net.IP{net.ParseIP("192.168.1.2")}, TypeAAAA, ""},
+		{[]net.IP{net.ParseIP("fd00::2")}, TypeA, ""},
+	}
+	for _, tt := range tests {
+		handler.SinkholeAddrs = tt.addrs
+		query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: "blocked.test.", QType: tt.qtype, QClass: 1}}
+		res := Message{}
+		if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if len(res.Answers) == 1 {
+			got = net.IP(res.Answers[0].RData).String()
+		}
+		if got != tt.want || len(res.Answers) > 1 || res.Header.RCODE != RcodeSuccess {
+			t.Errorf("%v with %v: answers %v, want %q", tt.qtype, tt.addrs, res.Answers, tt.want)
+		}
+	}
+}
+
+func TestHandlerBlocklistActions(t *testing.T) {
+	store := blocklist.NewMap()
+	store.Reload([]string{"blocked.test."})
+	tests := []struct {
+		rule    blocklist.Rule
+		rcode   uint16
+		answer  string
+		dropped bool
+	}{
+		{blocklist.Rule{}, RcodeSuccess, "127.0.0.1", false},
+		{blocklist.Rule{Action: blocklist.ActionNXDomain}, RcodeNameError, "", false},
+		{blocklist.Rule{Action: blocklist.ActionRefuse}, RcodeRefused, "", false},
+		{blocklist.Rule{Action: blocklist.ActionRedirect, Redirect: []string{"fd00::2", "192.168.1.2"}}, RcodeSuccess, "192.168.1.2", false},
+		{blocklist.Rule{Action: blocklist.ActionDrop}, RcodeSuccess, "", true},
+	}
+	for _, tt := range tests {
+		sinkhole := blocklist.NewSinkhole()
+		sinkhole.SetList(blocklist.List{Name: "rules", Category: "rules", Store: store, Rule: tt.rule})
+		handler := &Handler{Cache: &RecordsCache{Records: make(map[string]Message)}, Blocklist: sinkhole}
+		query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: "blocked.test.", QType: TypeA, QClass: 1}}
+		data := handler.BuildResponse(context.Background(), query)
+		if tt.dropped {
+			if len(data) != 0 {
+				t.Errorf("%s: answered %d bytes, want none", tt.rule.Kind(), len(data))
+			}
+			continue
+		}
+		res := Message{}
+		if _, err := res.Decode(data); err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if len(res.Answers) == 1 {
+			got = net.IP(res.Answers[0].RData).String()
+		}
+		if res.Header.RCODE != tt.rcode || got != tt.answer {
+			t.Errorf("%s: %s answered %q, want %s answered %q", tt.rule.Kind(), RcodeName(res.Header.RCODE), got, RcodeName(tt.rcode), tt.answer)
+		}
+	}
+}
+
+func TestHandlerPadding(t *testing.T) {
+	handler := &Handler{
+		Cache:     &RecordsCache{Records: make(map[string]Message)},
+		Blocklist: blocked("blocked.test."),
+	}
+	padded := NewOPT(DefaultUDPSize, false)
+	padded.RData = []byte{0, byte(OptionPadding), 0, 3, 0, 0, 0}
+	padded.RDLength = uint16(len(padded.RData))
+
+	tests := []struct {
+		name      string
+		opt       Answer
+		encrypted bool
+		padded    bool
+	}{
+		{"requested over tls", padded, true, true},
+		{"requested in the clear", padded, false, false},
+		{"not requested", NewOPT(DefaultUDPSize, false), true, false},
+	}
+	for _, tt := range tests {
+		query := &Message{
+			Header:     Header{ID: 1, RD: 1, QDCount: 1, ARCount: 1},
+			Question:   Question{DomainName: "blocked.test.", QType: TypeA, QClass: 1},
+			Additional: []Answer{tt.opt},
+		}
+		ctx := context.Background()
+		if tt.encrypted {
+			ctx = WithEncryption(ctx)
+		}
+		data := handler.BuildResponse(ctx, query)
+		res := Message{}
+		if _, err := res.Decode(data); err != nil {
+			t.Fatal(err)
+		}
+		_, found := res.Option(OptionPadding)
+		if tt.padded && (!found || len(data)%PaddingBlockSize != 0) {
+			t.Errorf("%s: %d bytes, padding %v, want a multiple of %d", tt.name, len(data), found, PaddingBlockSize)
+		}
+		if !tt.padded && len(data) >= PaddingBlockSize {
+			t.Errorf("%s: padded to %d bytes", tt.name, len(data))
+		}
+		if len(query.Additional[0].RData) != len(tt.opt.RData) {
+			t.Errorf("%s: query OPT record modified", tt.name)
+		}
+	}
+}
+
+// blocked returns a sinkhole blocking names
+func blocked(names ...string) *blocklist.Sinkhole {
+	store := blocklist.NewMap()
+	store.Reload(names)
+	sinkhole := blocklist.NewSinkhole()
+	sinkhole.Set(blocklist.CategoryBlocklist, blocklist.CategoryBlocklist, store)
+	return sinkhole
+}
+
+// staticUpstream answers every query with one record of the queried name
+// and the given type, TTL and RDATA. It returns its address.
+func staticUpstream(t *testing.T, qtype QType, ttl uint32, rdata []byte) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, BUFFER_SIZE)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			query := &Message{}
+			if _, err := query.Decode(buf[:n]); err != nil {
+				continue
+			}
+			name, _ := EncodeDomainName(query.Question.DomainName)
+			answer := Answer{Name: name, Type: uint16(qtype), Class: 1, TTL: ttl, RData: rdata, RDLength: uint16(len(rdata))}
+			conn.WriteToUDP(NewResponse(query).Answer(answer).Message().Encode(), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestHandlerForwardsUnknownTypes(t *testing.T) {
+	const qtype = QType(65280)
+	opaque := []byte{0xde, 0xad, 0xbe, 0xef}
+	handler := &Handler{
+		Cache:     &RecordsCache{Records: make(map[string]Message)},
+		Upstreams: []Upstream{{Address: staticUpstream(t, qtype, 60, opaque), Timeout: time.Second}},
+	}
+	// the second query is answered from the cache
+	for _, source := range []string{"upstream", "cache"} {
+		query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: "opaque.test.", QType: qtype, QClass: 1}}
+		query.Bytes = query.Encode()
+		res := Message{}
+		if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Answers) != 1 {
+			t.Fatalf("%s: %d answers, want 1", source, len(res.Answers))
+		}
+		if got := res.Answers[0]; QType(got.Type) != qtype || string(got.RData) != string(opaque) {
+			t.Errorf("%s: answered %v %x, want %v %x", source, QType(got.Type), got.RData, qtype, opaque)
+		}
+	}
+}
+
+func TestHandlerZoneOptions(t *testing.T) {
+	notAuthoritative := false
+	zones := map[string]Zone{
+		"corp.test.": {
+			Origin:   "corp.test.",
+			MinTTL:   300,
+			A:        []Record{{Name: "www", Value: "192.0.2.1", TTL: 60}},
+			Forward:  []Upstream{{Address: staticUpstream(t, TypeA, 5, []byte{192, 0, 2, 9}), Timeout: time.Second}},
+			Transfer: []string{"10.0.0.0/8"},
+		},
+		"cache.test.": {
+			Origin:        "cache.test.",
+			Authoritative: &notAuthoritative,
+			A:             []Record{{Name: "@", Value: "192.0.2.2"}, {Name: "a.b", Value: "192.0.2.3"}},
+		},
+	}
+	handler := &Handler{
+		Zones:     zones,
+		Cache:     &RecordsCache{Records: make(map[string]Message)},
+		Upstreams: []Upstream{{Address: "127.0.0.1:1", Timeout: 10 * time.Millisecond}},
+	}
+
+	tests := []struct {
+		name   string
+		qname  string
+		qtype  QType
+		client net.IP
+		rcode  uint16
+		aa     uint16
+		want   string
+		ttl    uint32
+	}{
+		{name: "zone record at min ttl", qname: "www.corp.test.", qtype: TypeA, rcode: RcodeSuccess, aa: 1, want: "192.0.2.1", ttl: 300},
+		{name: "missing name forwarded", qname: "db.corp.test.", qtype: TypeA, rcode: RcodeSuccess, want: "192.0.2.9", ttl: 300},
+		{name: "not authoritative", qname: "cache.test.", qtype: TypeA, rcode: RcodeSuccess, want: "192.0.2.2"},
+		{name: "missing name", qname: "nope.cache.test.", qtype: TypeA, rcode: RcodeNameError},
+		{name: "no data", qname: "cache.test.", qtype: TypeAAAA, rcode: RcodeSuccess},
+		{name: "empty non-terminal", qname: "b.cache.test.", qtype: TypeA, rcode: RcodeSuccess},
+		{name: "transfer refused", qname: "corp.test.", qtype: TypeAXFR, client: net.IPv4(192, 0, 2, 1), rcode: RcodeRefused},
+		{name: "transfer allowed", qname: "corp.test.", qtype: TypeAXFR, client: net.IPv4(10, 1, 2, 3), rcode: RcodeNotImplemented},
+		{name: "transfer without acl", qname: "cache.test.", qtype: TypeIXFR, client: net.IPv4(10, 1, 2, 3), rcode: RcodeRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: tt.qname, QType: tt.qtype, QClass: 1}}
+			query.Bytes = query.Encode()
+			res := Message{}
+			data := handler.BuildResponse(WithClient(context.Background(), tt.client), query)
+			if _, err := res.Decode(data); err != nil {
+				t.Fatal(err)
+			}
+			if res.Header.RCODE != tt.rcode || res.Header.AA != tt.aa {
+				t.Fatalf("rcode %s aa %d, want %s aa %d", RcodeName(res.Header.RCODE), res.Header.AA, RcodeName(tt.rcode), tt.aa)
+			}
+			if tt.want == "" {
+				if len(res.Answers) != 0 {
+					t.Errorf("%d answers, want none", len(res.Answers))
+				}
+				return
+			}
+			if len(res.Answers) != 1 {
+				t.Fatalf("%d answers, want 1", len(res.Answers))
+			}
+			if got := res.Answers[0].Data(data); got != tt.want || (tt.ttl != 0 && res.Answers[0].TTL != tt.ttl) {
+				t.Errorf("answered %s ttl %d, want %s ttl %d", got, res.Answers[0].TTL, tt.want, tt.ttl)
+			}
+		})
+	}
+}
+
+func TestHandlerTTLOverrides(t *testing.T) {
+	handler := &Handler{
+		Cache:        &RecordsCache{Records: make(map[string]Message)},
+		Upstreams:    []Upstream{{Address: staticUpstream(t, TypeA, 3600, []byte{192, 0, 2, 1}), Timeout: time.Second}},
+		TTLOverrides: NewTTLOverrides([]TTLOverride{{Name: "*.cdn.test", TTL: 30}, {Name: "api.test", TTL: 5}}),
+	}
+	tests := []struct {
+		qname string
+		ttl   uint32
+	}{
+		{"edge.cdn.test.", 30},
+		{"a.edge.CDN.test.", 30},
+		{"cdn.test.", 3600},
+		{"api.test.", 5},
+		{"v1.api.test.", 5},
+		{"www.test.", 3600},
+	}
+	for _, tt := range tests {
+		query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: tt.qname, QType: TypeA, QClass: 1}}
+		query.Bytes = query.Encode()
+		res := Message{}
+		if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Answers) != 1 || res.Answers[0].TTL != tt.ttl {
+			t.Errorf("%s: answered %+v, want ttl %d", tt.qname, res.Answers, tt.ttl)
+		}
+	}
+}
+
+func TestHandlerCacheHitEchoesQueryOPT(t *testing.T) {
+	handler := &Handler{
+		Cache:     &RecordsCache{Records: make(map[string]Message)},
+		Upstreams: []Upstream{{Address: staticUpstream(t, TypeA, 3600, []byte{192, 0, 2, 1}), Timeout: time.Second}},
+	}
+	cookie := Option{Code: OptionCookie, Data: []byte("12345678")}
+	tests := []struct {
+		name string
+		opts []Option
+		edns bool
+	}{
+		{"first client with a cookie", []Option{cookie}, true},
+		{"cached, client without EDNS", nil, false},
+		{"cached, client with EDNS", []Option{}, true},
+	}
+	for _, tt := range tests {
+		query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: "www.test.", QType: TypeA, QClass: 1}}
+		if tt.edns {
+			query.Additional = []Answer{NewOPT(DefaultUDPSize, false)}
+			query.SetOptions(tt.opts...)
+			query.Header.ARCount = 1
+		}
+		query.Bytes = query.Encode()
+		res := Message{}
+		if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Answers) != 1 {
+			t.Fatalf("%s: answered %+v", tt.name, res.Answers)
+		}
+		if _, ok := res.opt(); ok != tt.edns {
+			t.Errorf("%s: OPT record %v, want %v", tt.name, ok, tt.edns)
+		}
+		if got, ok := res.Option(OptionCookie); ok != (len(tt.opts) > 0) {
+			t.Errorf("%s: cookie %x, want the query's", tt.name, got.Data)
+		}
+	}
+}
+
+func TestHandlerErrorBudget(t *testing.T) {
+	handler := &Handler{
+		Zones:       map[string]Zone{"corp.test.": {Origin: "corp.test.", A: []Record{{Name: "www", Value: "192.0.2.1"}}}},
+		Cache:       &RecordsCache{Records: make(map[string]Message)},
+		Upstreams:   []Upstream{{Address: "127.0.0.1:1", Timeout: 10 * time.Millisecond}},
+		ErrorBudget: budget.New(budget.Options{Threshold: 0.5}, ""),
+	}
+	for _, name := range []string{"www.corp.test.", "www.example.com."} {
+		query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: name, QType: TypeA, QClass: 1}}
+		query.Bytes = query.Encode()
+		handler.BuildResponse(context.Background(), query)
+	}
+	if s := handler.ErrorBudget.Stats(); s.Queries != 2 || s.ServFail != 1 || s.Rate != 0.5 {
+		t.Errorf("error budget %+v, want 2 queries and 1 servfail", s)
+	}
+	if !timedOut(context.DeadlineExceeded) || timedOut(ErrLameDelegation) || timedOut(nil) {
+		t.Error("timedOut() misjudged")
+	}
+}
+
+func TestHandlerTruncatesUDP(t *testing.T) {
+	txt := strings.Repeat("x", 200)
+	zone := Zone{Origin: "big.test.", TXT: []Record{{Name: "@", Value: txt}, {Name: "@", Value: txt + "y"}, {Name: "@", Value: txt + "z"}}}
+	handler := &Handler{
+		Zones: map[string]Zone{zone.Origin: zone},
+		Cache: &RecordsCache{Records: make(map[string]Message)},
+	}
+
+	tests := []struct {
+		name    string
+		udp     bool
+		edns    bool
+		tc      uint16
+		answers int
+	}{
+		{name: "udp", udp: true, tc: 1},
+		{name: "udp with edns", udp: true, edns: true, answers: 3},
+		{name: "tcp", answers: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := &Message{Header: Header{ID: 1, QDCount: 1}, Question: Question{DomainName: "big.test.", QType: TypeTXT, QClass: 1}}
+			if tt.edns {
+				query.Additional = []Answer{NewOPT(DefaultUDPSize, false)}
+				query.Header.ARCount = 1
+			}
+			ctx := context.Background()
+			if tt.udp {
+				ctx = WithUDP(ctx)
+			}
+			data := handler.BuildResponse(ctx, query)
+			res := Message{}
+			if _, err := res.Decode(data); err != nil {
+				t.Fatal(err)
+			}
+			if res.Header.TC != tt.tc || len(res.Answers) != tt.answers {
+				t.Fatalf("tc %d with %d answers, want tc %d with %d", res.Header.TC, len(res.Answers), tt.tc, tt.answers)
+			}
+			if tt.tc == 1 && len(data) > 512 {
+				t.Errorf("truncated response of %d bytes, want at most 512", len(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrFormat is the error of messages the server cannot interpret, answered
+// with FORMERR
+var ErrFormat = errors.New("format error")
+
+// bits of the Z field, which holds the reserved bit and the DNSSEC AD and
+// CD flags, RFC 4035 3.2
+const (
+	zReserved uint16 = 0x04
+	zAD       uint16 = 0x02
+	zCD       uint16 = 0x01
+)
+
+// IsQuery reports whether the message is a query, QR clear
+func (header *Header) IsQuery() bool {
+	return header.QR == 0
+}
+
+// IsResponse reports whether the message is a response, QR set
+func (header *Header) IsResponse() bool {
+	return header.QR == 1
+}
+
+// SetQR marks the message as a response, or a query when response is false
+func (header *Header) SetQR(response bool) {
+	header.QR = flag(response)
+}
+
+// Authoritative reports whether the AA flag is set
+func (header *Header) Authoritative() bool {
+	return header.AA == 1
+}
+
+// SetAuthoritative sets or clears the AA flag
+func (header *Header) SetAuthoritative(aa bool) {
+	header.AA = flag(aa)
+}
+
+// Truncated reports whether the TC flag is set
+func (header *Header) Truncated() bool {
+	return header.TC == 1
+}
+
+// SetTruncated sets or clears the TC flag
+func (header *Header) SetTruncated(tc bool) {
+	header.TC = flag(tc)
+}
+
+// RecursionDesired reports whether the RD flag is set
+func (header *Header) RecursionDesired() bool {
+	return header.RD == 1
+}
+
+// SetRecursionDesired sets or clears the RD flag
+func (header *Header) SetRecursionDesired(rd bool) {
+	header.RD = flag(rd)
+}
+
+// RecursionAvailable reports whether the RA flag is set
+func (header *Header) RecursionAvailable() bool {
+	return header.RA == 1
+}
+
+// SetRecursionAvailable sets or clears the RA flag
+func (header *Header) SetRecursionAvailable(ra bool) {
+	header.RA = flag(ra)
+}
+
+// AuthenticData reports whether the AD flag is set
+func (header *Header) AuthenticData() bool {
+	return header.Z&zAD != 0
+}
+
+// SetAuthenticData sets or clears the AD flag
+func (header *Header) SetAuthenticData(ad bool) {
+	header.Z = header.Z&^zAD | flag(ad)<<1
+}
+
+// CheckingDisabled reports whether the CD flag is set
+func (header *Header) CheckingDisabled() bool {
+	return header.Z&zCD != 0
+}
+
+// SetCheckingDisabled sets or clears the CD flag
+func (header *Header) SetCheckingDisabled(cd bool) {
+	header.Z = header.Z&^zCD | flag(cd)
+}
+
+// Rcode returns the response code of the header, the low four bits of
+// the extended code of EDNS
+func (header *Header) Rcode() uint16 {
+	return header.RCODE
+}
+
+// SetOpcode sets the operation code, failing for values beyond its four
+// bits
+func (header *Header) SetOpcode(opcode uint16) error {
+	if opcode > 0x0F {
+		return fmt.Errorf("opcode %d out of range", opcode)
+	}
+	header.Opcode = opcode
+	return nil
+}
+
+// SetRcode sets the response code, failing for values beyond its four
+// bits, which need the extended rcode of EDNS
+func (header *Header) SetRcode(rcode uint16) error {
+	if rcode > 0x0F {
+		return fmt.Errorf("rcode %d out of range", rcode)
+	}
+	header.RCODE = rcode
+	return nil
+}
+
+// Validate checks that the fields fit their bits, which encoding would
+// otherwise mix into their neighbours, and that the reserved Z bit is
+// clear, RFC 1035 4.1.1. The errors wrap ErrFormat. Unassigned opcodes
+// are valid, the handler answers them NOTIMP.
+func (header *Header) Validate() error {
+	switch {
+	case header.QR > 1 || header.AA > 1 || header.TC > 1 || header.RD > 1 || header.RA > 1:
+		return fmt.Errorf("%w: flag out of range", ErrFormat)
+	case header.Opcode > 0x0F:
+		return fmt.Errorf("%w: opcode %d out of range", ErrFormat, header.Opcode)
+	case header.RCODE > 0x0F:
+		return fmt.Errorf("%w: rcode %d out of range", ErrFormat, header.RCODE)
+	case header.Z > 0x07:
+		return fmt.Errorf("%w: z %d out of range", ErrFormat, header.Z)
+	case header.Z&zReserved != 0:
+		return fmt.Errorf("%w: reserved z bit set", ErrFormat)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHeaderFlags(t *testing.T) {
+	var h Header
+	if !h.IsQuery() || h.IsResponse() {
+		t.Error("zero header is not a query")
+	}
+	h.SetQR(true)
+	h.SetAuthoritative(true)
+	h.SetRecursionDesired(true)
+	h.SetAuthenticData(true)
+	h.SetCheckingDisabled(true)
+	if !h.IsResponse() || !h.Authoritative() || !h.RecursionDesired() || !h.AuthenticData() || !h.CheckingDisabled() {
+		t.Errorf("flags not set: %+v", h)
+	}
+	if h.Truncated() || h.RecursionAvailable() {
+		t.Errorf("flags set that were not: %+v", h)
+	}
+	h.SetAuthenticData(false)
+	if h.AuthenticData() || !h.CheckingDisabled() || h.Z != zCD {
+		t.Errorf("clearing AD: z = %d, want %d", h.Z, zCD)
+	}
+
+	var decoded Header
+	if err := decoded.Decode(h.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != h {
+		t.Errorf("decoded %+v, want %+v", decoded, h)
+	}
+}
+
+func TestHeaderSetters(t *testing.T) {
+	var h Header
+	if err := h.SetOpcode(OpcodeNotify); err != nil || h.Opcode != OpcodeNotify {
+		t.Errorf("SetOpcode(NOTIFY) = %v, opcode %d", err, h.Opcode)
+	}
+	if err := h.SetOpcode(16); err == nil || h.Opcode != OpcodeNotify {
+		t.Errorf("SetOpcode(16) = %v, opcode %d", err, h.Opcode)
+	}
+	if err := h.SetRcode(RcodeRefused); err != nil || h.Rcode() != RcodeRefused {
+		t.Errorf("SetRcode(REFUSED) = %v, rcode %d", err, h.Rcode())
+	}
+	if err := h.SetRcode(16); err == nil {
+		t.Error("SetRcode(16) accepted the extended rcode")
+	}
+}
+
+func TestHeaderValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		header Header
+		valid  bool
+	}{
+		{"query", Header{RD: 1, QDCount: 1}, true},
+		{"ad and cd", Header{Z: zAD | zCD}, true},
+		{"unassigned opcode", Header{Opcode: 3}, true},
+		{"reserved bit", Header{Z: zReserved}, false},
+		{"flag", Header{QR: 2}, false},
+		{"opcode", Header{Opcode: 16}, false},
+		{"rcode", Header{RCODE: 16}, false},
+		{"z", Header{Z: 8}, false},
+	}
+	for _, tt := range tests {
+		err := tt.header.Validate()
+		if (err == nil) != tt.valid || (err != nil && !errors.Is(err, ErrFormat)) {
+			t.Errorf("%s: Validate() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestDecodeReservedBit(t *testing.T) {
+	query := &Message{Header: Header{ID: 7, RD: 1, Z: zReserved, QDCount: 1}, Question: Question{DomainName: "example.com.", QType: TypeA, QClass: 1}}
+	var msg Message
+	_, err := msg.Decode(query.Encode())
+	if !errors.Is(err, ErrFormat) {
+		t.Fatalf("Decode() = %v, want a format error", err)
+	}
+	// the question is decoded for the FORMERR response to echo
+	res := NewResponse(&msg).SetRcode(RcodeFormatError).Message()
+	if res.Question != query.Question || res.Header.ID != 7 || res.Header.Rcode() != RcodeFormatError {
+		t.Errorf("response %+v", res)
+	}
+}
@@ -0,0 +1,413 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthCheck probes the address of an A or AAAA record, which is left out
+// of the answers while the probes fail
+type HealthCheck struct {
+	// Type is tcp, http, https or icmp. ICMP needs raw sockets, root or
+	// CAP_NET_RAW.
+	Type string `yaml:"type"`
+	// Port is the port connected to, required by tcp, 80 for http and 443
+	// for https by default
+	Port int `yaml:"port"`
+	// Path is the URL path of http and https checks, a status below 400
+	// passing
+	Path string `yaml:"path"`
+	// Host is the Host and TLS server name of http and https checks, the
+	// name of the record by default
+	Host string `yaml:"host"`
+	// Interval between two probes, 10s by default
+	Interval time.Duration `yaml:"interval"`
+	// Timeout of a probe, 2s by default
+	Timeout time.Duration `yaml:"timeout"`
+	// Fall is the failed probes in a row marking a healthy address down,
+	// 3 by default
+	Fall int `yaml:"fall"`
+	// Rise is the passed probes in a row marking a down address up again,
+	// 2 by default
+	Rise int `yaml:"rise"`
+}
+
+// HealthCheckTypes are the probes a health check may use
+var HealthCheckTypes = []string{"tcp", "http", "https", "icmp"}
+
+// Validate reports problems with the check
+func (c HealthCheck) Validate() []error {
+	var errs []error
+	if !slices.Contains(HealthCheckTypes, c.Type) {
+		errs = append(errs, fmt.Errorf("type %q is not one of %s", c.Type, strings.Join(HealthCheckTypes, ", ")))
+	}
+	if c.Type == "tcp" && c.Port == 0 {
+		errs = append(errs, errors.New("tcp check needs a port"))
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d out of range 1-65535", c.Port))
+	}
+	if c.Path != "" && !strings.HasPrefix(c.Path, "/") {
+		errs = append(errs, fmt.Errorf("path %q must start with /", c.Path))
+	}
+	if c.Interval < 0 || c.Timeout < 0 || c.Fall < 0 || c.Rise < 0 {
+		errs = append(errs, errors.New("interval, timeout, fall and rise must not be negative"))
+	}
+	return errs
+}
+
+// healthTarget is a health check of one address with its defaults
+// filled, the probes of records sharing it are shared
+type healthTarget struct {
+	HealthCheck
+	Address string
+}
+
+// target returns the check of address, the address of a record owned by
+// owner, nil without a check
+func (c *HealthCheck) target(address, owner string) *healthTarget {
+	if c == nil {
+		return nil
+	}
+	t := &healthTarget{HealthCheck: *c, Address: address}
+	if t.Interval == 0 {
+		t.Interval = 10 * time.Second
+	}
+	if t.Timeout == 0 {
+		t.Timeout = 2 * time.Second
+	}
+	if t.Fall == 0 {
+		t.Fall = 3
+	}
+	if t.Rise == 0 {
+		t.Rise = 2
+	}
+	switch t.Type {
+	case "http", "https":
+		if t.Port == 0 {
+			t.Port = map[string]int{"http": 80, "https": 443}[t.Type]
+		}
+		if t.Path == "" {
+			t.Path = "/"
+		}
+		if t.Host == "" {
+			t.Host = strings.TrimSuffix(owner, ".")
+		}
+	case "icmp":
+		t.Port, t.Path, t.Host = 0, "", ""
+	}
+	return t
+}
+
+// String describes the target like tcp 192.0.2.1:443
+func (t healthTarget) String() string {
+	switch t.Type {
+	case "icmp":
+		return "icmp " + t.Address
+	case "http", "https":
+		return fmt.Sprintf("%s %s %s%s", t.Type, net.JoinHostPort(t.Address, strconv.Itoa(t.Port)), t.Host, t.Path)
+	}
+	return t.Type + " " + net.JoinHostPort(t.Address, strconv.Itoa(t.Port))
+}
+
+// probe checks a target every interval and tells whether it is healthy
+type probe struct {
+	target  healthTarget
+	cancel  context.CancelFunc
+	healthy atomic.Bool
+
+	mu        sync.Mutex
+	passed    int
+	failed    int
+	since     time.Time
+	lastError string
+}
+
+// HealthStatus is the state of a health-checked address
+type HealthStatus struct {
+	Target  string `json:"target"`
+	Healthy bool   `json:"healthy"`
+	// Since is when the address was last marked up or down, or first
+	// probed
+	Since time.Time `json:"since"`
+	// Error is the failure of the last probe, empty when it passed
+	Error string `json:"error,omitempty"`
+}
+
+// HealthChecks probes the addresses of the health-checked records of the
+// zones it watches. Zones answer their health-checked records while they
+// are healthy, and their fallback records when none is. Addresses are
+// healthy until their probes fail. A nil HealthChecks probes nothing.
+type HealthChecks struct {
+	mu     sync.RWMutex
+	probes map[healthTarget]*probe
+	// check probes a target, replaced by tests
+	check func(ctx context.Context, t healthTarget) error
+}
+
+// NewHealthChecks returns health checks probing no address yet
+func NewHealthChecks() *HealthChecks {
+	return &HealthChecks{probes: make(map[healthTarget]*probe), check: checkHealth}
+}
+
+// Watch has zones answer by the health of their addresses, probing the
+// addresses they check and stopping the probes of those no zone checks
+// anymore. It updates the zones in place.
+func (h *HealthChecks) Watch(zones map[string]Zone) {
+	if h == nil {
+		return
+	}
+	targets := make(map[healthTarget]bool)
+	for name, zone := range zones {
+		zone.allRecords(func(_ string, _ QType, record zoneRecord, _ RecordMeta) {
+			if record.health != nil {
+				targets[*record.health] = true
+			}
+		})
+		zone.health = h
+		zones[name] = zone
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for t, p := range h.probes {
+		if !targets[t] {
+			p.cancel()
+			delete(h.probes, t)
+		}
+	}
+	for t := range targets {
+		if _, ok := h.probes[t]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		p := &probe{target: t, cancel: cancel, since: time.Now()}
+		p.healthy.Store(true)
+		h.probes[t] = p
+		go h.run(ctx, p)
+	}
+}
+
+// run probes p every interval until ctx is done
+func (h *HealthChecks) run(ctx context.Context, p *probe) {
+	ticker := time.NewTicker(p.target.Interval)
+	defer ticker.Stop()
+	for {
+		cctx, cancel := context.WithTimeout(ctx, p.target.Timeout)
+		err := h.check(cctx, p.target)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		p.record(err, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// record counts the result of a probe at now, marking the target down
+// after Fall failures in a row and up after Rise passes
+func (p *probe) record(err error, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		p.passed, p.failed, p.lastError = p.passed+1, 0, ""
+		if !p.healthy.Load() && p.passed >= p.target.Rise {
+			p.healthy.Store(true)
+			p.since = now
+			resolverLog.Info("health check passing, answering the address", "target", p.target, "passed", p.passed)
+		}
+		return
+	}
+	p.passed, p.failed, p.lastError = 0, p.failed+1, err.Error()
+	if p.healthy.Load() && p.failed >= p.target.Fall {
+		p.healthy.Store(false)
+		p.since = now
+		resolverLog.Warn("health check failing, leaving the address out", "target", p.target, "failed", p.failed, "err", err)
+	}
+}
+
+// healthy reports whether the target is healthy, true for targets not
+// probed
+func (h *HealthChecks) healthy(t *healthTarget) bool {
+	if h == nil {
+		return true
+	}
+	h.mu.RLock()
+	p := h.probes[*t]
+	h.mu.RUnlock()
+	return p == nil || p.healthy.Load()
+}
+
+// Stats returns the state of every address probed, sorted by target
+func (h *HealthChecks) Stats() []HealthStatus {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	stats := make([]HealthStatus, 0, len(h.probes))
+	for _, p := range h.probes {
+		p.mu.Lock()
+		stats = append(stats, HealthStatus{Target: p.target.String(), Healthy: p.healthy.Load(), Since: p.since, Error: p.lastError})
+		p.mu.Unlock()
+	}
+	slices.SortFunc(stats, func(a, b HealthStatus) int { return strings.Compare(a.Target, b.Target) })
+	return stats
+}
+
+// healthy returns the records of an RRset to answer: the health-checked
+// ones that are healthy along with those not checked, else the fallback
+// ones. When every record is down and there is no fallback, all of them
+// are answered rather than none.
+func (z *Zone) healthy(records []zoneRecord) []zoneRecord {
+	if !slices.ContainsFunc(records, func(r zoneRecord) bool { return r.health != nil || r.fallback }) {
+		return records
+	}
+	var up, down, fallback []zoneRecord
+	for _, r := range records {
+		switch {
+		case r.fallback:
+			fallback = append(fallback, r)
+		case r.health == nil || z.health.healthy(r.health):
+			up = append(up, r)
+		default:
+			down = append(down, r)
+		}
+	}
+	switch {
+	case len(up) > 0:
+		return up
+	case len(fallback) > 0:
+		return fallback
+	}
+	return down
+}
+
+// checkHealth probes t once
+func checkHealth(ctx context.Context, t healthTarget) error {
+	switch t.Type {
+	case "tcp":
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(t.Address, strconv.Itoa(t.Port)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "http", "https":
+		return checkHTTP(ctx, t)
+	case "icmp":
+		return ping(ctx, t.Address)
+	}
+	return fmt.Errorf("unknown health check type %q", t.Type)
+}
+
+// checkHTTP asks the address of t for its path, with the host of t, and
+// passes statuses below 400. Redirects are not followed.
+func checkHTTP(ctx context.Context, t healthTarget) error {
+	address := net.JoinHostPort(t.Address, strconv.Itoa(t.Port))
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+		TLSClientConfig:   &tls.Config{ServerName: t.Host},
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{
+		Transport:     transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	url := t.Type + "://" + net.JoinHostPort(t.Host, strconv.Itoa(t.Port)) + t.Path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "mercury-health-check")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("status %s", res.Status)
+	}
+	return nil
+}
+
+// ping sends an ICMP echo request to address and waits for the reply
+func ping(ctx context.Context, address string) error {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return fmt.Errorf("invalid address %q", address)
+	}
+	network, request, reply := "ip4:icmp", byte(8), byte(0)
+	if ip.To4() == nil {
+		// the kernel fills the checksums of ICMPv6
+		network, request, reply = "ip6:ipv6-icmp", 128, 129
+	}
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	id, seq := uint16(rand.Uint32()), uint16(rand.Uint32())
+	msg := []byte{request, 0, 0, 0}
+	msg = binary.BigEndian.AppendUint16(msg, id)
+	msg = binary.BigEndian.AppendUint16(msg, seq)
+	msg = append(msg, "mercury"...)
+	if request == 8 {
+		binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+	}
+	if _, err := conn.WriteTo(msg, &net.IPAddr{IP: ip}); err != nil {
+		return err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		addr, ok := peer.(*net.IPAddr)
+		if n < 8 || !ok || !addr.IP.Equal(ip) || buf[0] != reply {
+			continue
+		}
+		if binary.BigEndian.Uint16(buf[4:]) == id && binary.BigEndian.Uint16(buf[6:]) == seq {
+			return nil
+		}
+	}
+}
+
+// icmpChecksum is the internet checksum of an ICMP message, RFC 1071
+func icmpChecksum(msg []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(msg); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(msg[i:]))
+	}
+	if len(msg)%2 == 1 {
+		sum += uint32(msg[len(msg)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
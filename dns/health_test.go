@@ -0,0 +1,188 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// answered returns the addresses the zone answers for name
+func answered(z *Zone, name string) []string {
+	var addrs []string
+	for _, a := range z.Lookup(name, TypeA, 1) {
+		addrs = append(addrs, a.Data(nil))
+	}
+	slices.Sort(addrs)
+	return addrs
+}
+
+func TestZoneHealth(t *testing.T) {
+	var mu sync.Mutex
+	down := make(map[string]bool)
+	setDown := func(addr string, d bool) {
+		mu.Lock()
+		down[addr] = d
+		mu.Unlock()
+	}
+	h := NewHealthChecks()
+	h.check = func(_ context.Context, target healthTarget) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if down[target.Address] {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	check := &HealthCheck{Type: "tcp", Port: 80, Interval: 5 * time.Millisecond, Fall: 2, Rise: 2}
+	zone := Zone{
+		Origin: "example.com.",
+		TTL:    60,
+		A: []Record{
+			{Name: "www", Value: "192.0.2.1", Health: check},
+			{Name: "www", Value: "192.0.2.2", Health: check},
+			{Name: "www", Value: "192.0.2.9", Fallback: true},
+			{Name: "mail", Value: "192.0.2.25"},
+		},
+	}
+	zone.Index()
+	zones := map[string]Zone{"example.com.": zone}
+	h.Watch(zones)
+	z := zones["example.com."]
+
+	wait := func(want ...string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !slices.Equal(answered(&z, "www.example.com."), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("www answered %v, want %v", answered(&z, "www.example.com."), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wait("192.0.2.1", "192.0.2.2")
+	setDown("192.0.2.1", true)
+	wait("192.0.2.2")
+	setDown("192.0.2.2", true)
+	wait("192.0.2.9")
+	if got := answered(&z, "mail.example.com."); !slices.Equal(got, []string{"192.0.2.25"}) {
+		t.Errorf("mail answered %v", got)
+	}
+	records := z.AnnotatedRecords(time.Now())
+	for _, r := range records {
+		if r.Name == "www.example.com." && r.Data != "192.0.2.9" && (r.Healthy == nil || *r.Healthy) {
+			t.Errorf("record %+v, want it down", r)
+		}
+	}
+	setDown("192.0.2.1", false)
+	wait("192.0.2.1")
+
+	stats := h.Stats()
+	if len(stats) != 2 || stats[0].Target != "tcp 192.0.2.1:80" || !stats[0].Healthy || stats[1].Healthy || stats[1].Error != "connection refused" {
+		t.Errorf("Stats() = %+v", stats)
+	}
+	// the checks of the records reloaded away stop
+	h.Watch(map[string]Zone{"example.com.": {Origin: "example.com."}})
+	if stats := h.Stats(); len(stats) != 0 {
+		t.Errorf("Stats() after the records were removed = %+v", stats)
+	}
+}
+
+func TestZoneHealthAllDown(t *testing.T) {
+	h := NewHealthChecks()
+	zone := Zone{Origin: "example.com.", A: []Record{
+		{Name: "www", Value: "192.0.2.1", Health: &HealthCheck{Type: "icmp"}},
+		{Name: "www", Value: "192.0.2.2", Health: &HealthCheck{Type: "icmp"}},
+	}}
+	zone.health = h
+	for _, r := range zone.A {
+		target := *r.Health.target(r.Value, "www.example.com.")
+		h.probes[target] = &probe{target: target}
+	}
+	// without a fallback every address is answered rather than none
+	if got := answered(&zone, "www.example.com."); len(got) != 2 {
+		t.Errorf("www answered %v, want both addresses", got)
+	}
+	// zones not watched answer every checked address and no fallback
+	zone.health = nil
+	zone.A = append(zone.A, Record{Name: "www", Value: "192.0.2.9", Fallback: true})
+	if got := answered(&zone, "www.example.com."); !slices.Equal(got, []string{"192.0.2.1", "192.0.2.2"}) {
+		t.Errorf("www answered %v without health checks", got)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "www.example.com:"+r.URL.Query().Get("port") {
+			w.WriteHeader(http.StatusMisdirectedRequest)
+			return
+		}
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	query := "?port=" + strconv.Itoa(port)
+
+	tests := []struct {
+		name  string
+		check HealthCheck
+		ok    bool
+	}{
+		{"tcp open", HealthCheck{Type: "tcp", Port: port}, true},
+		{"tcp closed", HealthCheck{Type: "tcp", Port: open}, false},
+		{"http passing", HealthCheck{Type: "http", Port: port, Path: "/healthz" + query}, true},
+		{"http failing", HealthCheck{Type: "http", Port: port, Path: "/" + query}, false},
+		{"http other host", HealthCheck{Type: "http", Port: port, Path: "/healthz" + query, Host: "other.example.com"}, false},
+	}
+	for _, tt := range tests {
+		target := tt.check.target("127.0.0.1", "www.example.com.")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := checkHealth(ctx, *target)
+		cancel()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: checkHealth(%s) = %v, want ok %v", tt.name, target, err, tt.ok)
+		}
+	}
+}
+
+func TestHealthCheckValidate(t *testing.T) {
+	tests := []struct {
+		check HealthCheck
+		errs  int
+	}{
+		{HealthCheck{Type: "tcp", Port: 443}, 0},
+		{HealthCheck{Type: "https", Path: "/healthz", Interval: time.Second}, 0},
+		{HealthCheck{Type: "icmp"}, 0},
+		{HealthCheck{Type: "tcp"}, 1},
+		{HealthCheck{Type: "udp", Port: 53}, 1},
+		{HealthCheck{Type: "http", Port: 70000, Path: "healthz", Fall: -1}, 3},
+	}
+	for _, tt := range tests {
+		if errs := tt.check.Validate(); len(errs) != tt.errs {
+			t.Errorf("Validate(%+v) = %v, want %d errors", tt.check, errs, tt.errs)
+		}
+	}
+}
+
+func TestICMPChecksum(t *testing.T) {
+	// echo request, id 1, seq 1, without data
+	msg := []byte{8, 0, 0, 0, 0, 1, 0, 1}
+	if sum := icmpChecksum(msg); sum != 0xf7fd {
+		t.Errorf("icmpChecksum() = %#x, want 0xf7fd", sum)
+	}
+}
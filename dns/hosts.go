@@ -0,0 +1,139 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/bernoussama/mercury/fqdn"
+)
+
+// HostsTTL is the TTL of host records, short as leases come and go
+const HostsTTL = 60
+
+// Hosts answers for the hosts of a local domain, registered from DHCP
+// leases, and for the reverse names of their addresses. Names of the domain
+// without a host do not exist. A nil Hosts answers nothing.
+type Hosts struct {
+	domain string
+
+	mu    sync.RWMutex
+	addrs map[string][]net.IP
+	// ptrs holds the host name of each reverse name
+	ptrs map[string]string
+}
+
+// NewHosts returns the hosts of domain, none until Set
+func NewHosts(domain string) *Hosts {
+	return &Hosts{
+		domain: string(fqdn.Canonical(domain)),
+		addrs:  make(map[string][]net.IP),
+		ptrs:   make(map[string]string),
+	}
+}
+
+// Domain returns the domain of the hosts as an absolute name
+func (h *Hosts) Domain() string {
+	return h.domain
+}
+
+// Set replaces the hosts with the addresses of each host label
+func (h *Hosts) Set(hosts map[string][]net.IP) {
+	addrs := make(map[string][]net.IP, len(hosts))
+	ptrs := make(map[string]string, len(hosts))
+	for label, ips := range hosts {
+		name := string(fqdn.Canonical(label + "." + h.domain))
+		addrs[name] = ips
+		for _, ip := range ips {
+			ptrs[ReverseName(ip)] = name
+		}
+	}
+	h.mu.Lock()
+	h.addrs, h.ptrs = addrs, ptrs
+	h.mu.Unlock()
+}
+
+// Len returns the number of hosts
+func (h *Hosts) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.addrs)
+}
+
+// Lookup returns the records of type qtype owned by name and the response
+// code. It reports false when name is neither in the domain nor the reverse
+// name of a host address.
+func (h *Hosts) Lookup(name string, qtype QType, qclass uint16) ([]Answer, uint16, bool) {
+	if h == nil {
+		return nil, RcodeSuccess, false
+	}
+	name = string(fqdn.Canonical(name))
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if host, ok := h.ptrs[name]; ok {
+		if qtype != TypePTR {
+			return nil, RcodeSuccess, true
+		}
+		rdata, err := EncodeDomainName(host)
+		if err != nil {
+			return nil, RcodeSuccess, true
+		}
+		return h.answers(name, qtype, qclass, [][]byte{rdata}), RcodeSuccess, true
+	}
+	if !IsSubdomain(name, h.domain) {
+		return nil, RcodeSuccess, false
+	}
+	ips, ok := h.addrs[name]
+	if !ok && name != h.domain {
+		return nil, RcodeNameError, true
+	}
+	var rdata [][]byte
+	for _, ip := range ips {
+		ip4 := ip.To4()
+		switch {
+		case qtype == TypeA && ip4 != nil:
+			rdata = append(rdata, ip4)
+		case qtype == TypeAAAA && ip4 == nil:
+			rdata = append(rdata, ip.To16())
+		}
+	}
+	return h.answers(name, qtype, qclass, rdata), RcodeSuccess, true
+}
+
+func (h *Hosts) answers(name string, qtype QType, qclass uint16, rdata [][]byte) []Answer {
+	encodedName, err := EncodeDomainName(name)
+	if err != nil {
+		return nil
+	}
+	answers := make([]Answer, 0, len(rdata))
+	for _, data := range rdata {
+		answers = append(answers, Answer{
+			Name:     encodedName,
+			Type:     uint16(qtype),
+			Class:    qclass,
+			TTL:      HostsTTL,
+			RData:    data,
+			RDLength: uint16(len(data)),
+		})
+	}
+	return answers
+}
+
+// ReverseName returns the in-addr.arpa or ip6.arpa name of ip
+func ReverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	const hex = "0123456789abcdef"
+	var b strings.Builder
+	ip = ip.To16()
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(hex[ip[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hex[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String()
+}
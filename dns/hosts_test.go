@@ -0,0 +1,77 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReverseName(t *testing.T) {
+	for ip, want := range map[string]string{
+		"192.168.1.42": "42.1.168.192.in-addr.arpa.",
+		"fd00::42":     "2.4.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.",
+	} {
+		if got := ReverseName(net.ParseIP(ip)); got != want {
+			t.Errorf("ReverseName(%s) = %s, want %s", ip, got, want)
+		}
+	}
+}
+
+func TestHandlerHosts(t *testing.T) {
+	hosts := NewHosts("lan")
+	hosts.Set(map[string][]net.IP{
+		"laptop": {net.ParseIP("192.168.1.42"), net.ParseIP("fd00::42")},
+		"nas":    {net.ParseIP("192.168.1.2")},
+	})
+	handler := &Handler{
+		Cache: &RecordsCache{Records: make(map[string]Message)},
+		Hosts: hosts,
+		// names outside the local domain go nowhere
+		Upstreams: []Upstream{{Address: "127.0.0.1:1", Timeout: 10 * time.Millisecond}},
+	}
+	laptop, _ := EncodeDomainName("laptop.lan.")
+	tests := []struct {
+		name  string
+		qtype QType
+		rcode uint16
+		want  [][]byte
+	}{
+		{"LAPTOP.lan.", TypeA, RcodeSuccess, [][]byte{net.ParseIP("192.168.1.42").To4()}},
+		{"laptop.lan.", TypeAAAA, RcodeSuccess, [][]byte{net.ParseIP("fd00::42")}},
+		{"nas.lan.", TypeAAAA, RcodeSuccess, nil},
+		{"printer.lan.", TypeA, RcodeNameError, nil},
+		{"lan.", TypeA, RcodeSuccess, nil},
+		{"42.1.168.192.in-addr.arpa.", TypePTR, RcodeSuccess, [][]byte{laptop}},
+		{ReverseName(net.ParseIP("fd00::42")), TypePTR, RcodeSuccess, [][]byte{laptop}},
+		// not a host address, resolved elsewhere
+		{"7.1.168.192.in-addr.arpa.", TypePTR, RcodeServerFailure, nil},
+		{"laptop.example.", TypeA, RcodeServerFailure, nil},
+	}
+	for _, tt := range tests {
+		query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: tt.name, QType: tt.qtype, QClass: 1}}
+		res := Message{}
+		if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+			t.Fatal(err)
+		}
+		if res.Header.RCODE != tt.rcode || len(res.Answers) != len(tt.want) {
+			t.Errorf("%s %v: rcode %d with %d answers, want %d with %d", tt.name, tt.qtype, res.Header.RCODE, len(res.Answers), tt.rcode, len(tt.want))
+			continue
+		}
+		for i, answer := range res.Answers {
+			if !bytes.Equal(answer.RData, tt.want[i]) || answer.TTL != HostsTTL {
+				t.Errorf("%s %v: answer %v, want rdata %v", tt.name, tt.qtype, answer, tt.want[i])
+			}
+		}
+	}
+
+	// hosts gone from the leases stop resolving
+	hosts.Set(nil)
+	if _, rcode, _ := hosts.Lookup("laptop.lan.", TypeA, 1); rcode != RcodeNameError {
+		t.Errorf("removed host rcode %d", rcode)
+	}
+	if _, _, ok := hosts.Lookup("42.1.168.192.in-addr.arpa.", TypePTR, 1); ok {
+		t.Error("reverse name of a removed host answered")
+	}
+}
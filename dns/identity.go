@@ -0,0 +1,57 @@
+package dns
+
+import (
+	"os"
+	"strings"
+)
+
+// ClassCHAOS is the class of server identity queries, RFC 4892
+const ClassCHAOS uint16 = 3
+
+// Identity is what the server tells monitoring about itself, e.g. to tell
+// anycast instances apart. Empty strings refuse the matching queries.
+type Identity struct {
+	// Version answers CH TXT version.bind and version.server
+	Version string `yaml:"version"`
+	// Hostname answers CH TXT hostname.bind and id.server
+	Hostname string `yaml:"hostname"`
+	// NSID is sent in the EDNS NSID option to queries asking for it, RFC 5001
+	NSID string `yaml:"nsid"`
+	// Instance labels the logs and stats of the server, Hostname or the
+	// host name of the machine by default
+	Instance string `yaml:"instance"`
+}
+
+// InstanceName returns the label of the server in logs and stats
+func (id Identity) InstanceName() string {
+	if id.Instance != "" {
+		return id.Instance
+	}
+	if id.Hostname != "" {
+		return id.Hostname
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// chaos answers a CHAOS class query, refusing names it has no value for
+func (id Identity) chaos(res *Builder, question Question) {
+	var value string
+	switch strings.ToLower(question.DomainName) {
+	case "version.bind.", "version.server.":
+		value = id.Version
+	case "hostname.bind.", "id.server.":
+		value = id.Hostname
+	}
+	name, err := EncodeDomainName(question.DomainName)
+	if value == "" || err != nil {
+		res.SetRcode(RcodeRefused)
+		return
+	}
+	res.Authoritative(true)
+	if question.QType != TypeTXT {
+		return
+	}
+	txt := encodeTXT(value)
+	res.Answer(Answer{Name: name, Type: uint16(TypeTXT), Class: ClassCHAOS, RDLength: uint16(len(txt)), RData: txt})
+}
@@ -0,0 +1,112 @@
+package dns
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestHandlerChaos(t *testing.T) {
+	handler := &Handler{
+		Cache:    &RecordsCache{Records: make(map[string]Message)},
+		Identity: Identity{Version: "mercury 1.0", Hostname: "ns1-ams"},
+	}
+	tests := []struct {
+		name  string
+		qtype QType
+		rcode uint16
+		txt   string
+	}{
+		{"version.bind.", TypeTXT, RcodeSuccess, "mercury 1.0"},
+		{"VERSION.SERVER.", TypeTXT, RcodeSuccess, "mercury 1.0"},
+		{"hostname.bind.", TypeTXT, RcodeSuccess, "ns1-ams"},
+		{"id.server.", TypeTXT, RcodeSuccess, "ns1-ams"},
+		{"id.server.", TypeA, RcodeSuccess, ""},
+		{"authors.bind.", TypeTXT, RcodeRefused, ""},
+	}
+	for _, tt := range tests {
+		query := &Message{Header: Header{ID: 7, QDCount: 1}, Question: Question{DomainName: tt.name, QType: tt.qtype, QClass: ClassCHAOS}}
+		res := Message{}
+		if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+			t.Fatal(err)
+		}
+		if res.Header.RCODE != tt.rcode {
+			t.Errorf("%s %v: rcode %s, want %s", tt.name, tt.qtype, RcodeName(res.Header.RCODE), RcodeName(tt.rcode))
+		}
+		var txt string
+		if len(res.Answers) == 1 && res.Answers[0].Class == ClassCHAOS {
+			txt = string(res.Answers[0].RData[1:])
+		}
+		if txt != tt.txt || len(res.Answers) > 1 {
+			t.Errorf("%s %v: answers %v, want %q", tt.name, tt.qtype, res.Answers, tt.txt)
+		}
+	}
+
+	// without an identity every CHAOS query is refused
+	handler.Identity = Identity{}
+	query := &Message{Header: Header{QDCount: 1}, Question: Question{DomainName: "version.bind.", QType: TypeTXT, QClass: ClassCHAOS}}
+	res := Message{}
+	res.Decode(handler.BuildResponse(context.Background(), query))
+	if res.Header.RCODE != RcodeRefused {
+		t.Errorf("version.bind without a version: rcode %s, want REFUSED", RcodeName(res.Header.RCODE))
+	}
+}
+
+func TestHandlerNSID(t *testing.T) {
+	handler := &Handler{
+		Cache:     &RecordsCache{Records: make(map[string]Message)},
+		Blocklist: blocked("blocked.test."),
+	}
+	nsidRequest := NewOPT(DefaultUDPSize, false)
+	nsidRequest.RData = []byte{0, byte(OptionNSID), 0, 0}
+	nsidRequest.RDLength = 4
+
+	tests := []struct {
+		name  string
+		nsid  string
+		opt   []Answer
+		want  string
+		found bool
+	}{
+		{"requested", "ns1-ams", []Answer{nsidRequest}, "ns1-ams", true},
+		{"not requested", "ns1-ams", []Answer{NewOPT(DefaultUDPSize, false)}, "", false},
+		{"not configured", "", []Answer{nsidRequest}, "", false},
+	}
+	for _, tt := range tests {
+		handler.Identity.NSID = tt.nsid
+		query := &Message{
+			Header:     Header{ID: 1, RD: 1, QDCount: 1, ARCount: 1},
+			Question:   Question{DomainName: "blocked.test.", QType: TypeA, QClass: 1},
+			Additional: tt.opt,
+		}
+		res := Message{}
+		if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+			t.Fatal(err)
+		}
+		nsid, found := res.Option(OptionNSID)
+		if found != tt.found || string(nsid.Data) != tt.want {
+			t.Errorf("%s: NSID %q, %v, want %q, %v", tt.name, nsid.Data, found, tt.want, tt.found)
+		}
+		// the query keeps its own OPT record
+		if len(query.Additional[0].RData) != len(tt.opt[0].RData) {
+			t.Errorf("%s: query OPT record modified", tt.name)
+		}
+	}
+}
+
+func TestInstanceName(t *testing.T) {
+	host, _ := os.Hostname()
+	tests := []struct {
+		id   Identity
+		want string
+	}{
+		{Identity{Instance: "ams-1", Hostname: "ns1-ams"}, "ams-1"},
+		{Identity{Hostname: "ns1-ams"}, "ns1-ams"},
+		{Identity{}, host},
+	}
+	for _, tt := range tests {
+		if got := tt.id.InstanceName(); got != tt.want {
+			t.Errorf("%+v.InstanceName() = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
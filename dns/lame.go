@@ -0,0 +1,115 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bernoussama/mercury/fqdn"
+)
+
+// limits on the work of one resolution
+const (
+	// MaxReferrals caps the referrals followed for one query
+	MaxReferrals = 16
+	// MaxCNAMEChain caps the aliases followed from the query name
+	MaxCNAMEChain = 8
+)
+
+// errors of resolutions that would spin, answered SERVFAIL with an EDE
+var (
+	// ErrLameDelegation is a referral to servers that cannot answer, to a
+	// zone the name is not in or to name servers inside the zone without
+	// their glue
+	ErrLameDelegation = errors.New("lame delegation")
+	// ErrReferralLoop is a referral that does not move closer to the name
+	ErrReferralLoop = errors.New("referral loop")
+	// ErrCNAMEChain is an answer whose aliases loop or chain too long
+	ErrCNAMEChain = errors.New("CNAME chain looping or too long")
+)
+
+// referral checks a referral for name found in the authority section of
+// the response in packet, cut being the zone of the previous referral. It
+// returns the zone referred to and the name servers of the zone, no zone
+// when the section holds no NS record.
+func referral(packet []byte, authority []Answer, name, cut string) (string, []string, error) {
+	var zone string
+	var servers []string
+	for i := range authority {
+		if QType(authority[i].Type) != TypeNS {
+			continue
+		}
+		owner, err := authority[i].OwnerName(packet)
+		if err != nil {
+			continue
+		}
+		if zone == "" {
+			zone = owner
+		}
+		if strings.EqualFold(owner, zone) {
+			servers = append(servers, authority[i].Data(packet))
+		}
+	}
+	switch {
+	case zone == "":
+		return "", nil, nil
+	case !IsSubdomain(name, zone):
+		return "", nil, fmt.Errorf("%w: referred to %s, which %s is not in", ErrLameDelegation, zone, name)
+	case cut != "" && (strings.EqualFold(zone, cut) || !IsSubdomain(zone, cut)):
+		return "", nil, fmt.Errorf("%w: referred from %s to %s", ErrReferralLoop, cut, zone)
+	}
+	return zone, servers, nil
+}
+
+// selfReferential reports whether every name server of zone is inside it,
+// so none can be reached without glue
+func selfReferential(zone string, servers []string) bool {
+	for _, server := range servers {
+		if !IsSubdomain(server, zone) {
+			return false
+		}
+	}
+	return len(servers) > 0
+}
+
+// checkCNAMEChain follows the aliases of name through the answers decoded
+// from packet, failing when they loop or chain longer than MaxCNAMEChain
+func checkCNAMEChain(packet []byte, answers []Answer, name string) error {
+	aliases := make(map[string]string)
+	for i := range answers {
+		if QType(answers[i].Type) != TypeCNAME {
+			continue
+		}
+		if owner, err := answers[i].OwnerName(packet); err == nil {
+			aliases[string(fqdn.Canonical(owner))] = string(fqdn.Canonical(answers[i].Data(packet)))
+		}
+	}
+	name = string(fqdn.Canonical(name))
+	seen := map[string]bool{name: true}
+	for links := 0; ; links++ {
+		target, ok := aliases[name]
+		if !ok {
+			return nil
+		}
+		if seen[target] {
+			return fmt.Errorf("%w: %s loops back to %s", ErrCNAMEChain, name, target)
+		}
+		if links == MaxCNAMEChain {
+			return fmt.Errorf("%w: more than %d aliases", ErrCNAMEChain, MaxCNAMEChain)
+		}
+		seen[target] = true
+		name = target
+	}
+}
+
+// extendedError returns the EDE telling why a resolution failed, for the
+// failures the resolver gave up on by itself
+func extendedError(err error) (ExtendedError, bool) {
+	switch {
+	case errors.Is(err, ErrLameDelegation), errors.Is(err, ErrReferralLoop):
+		return ExtendedError{InfoCode: EDENoReachableAuthority, ExtraText: err.Error()}, true
+	case errors.Is(err, ErrCNAMEChain), errors.Is(err, ErrBudgetExhausted), errors.Is(err, ErrTooManyQueries):
+		return ExtendedError{InfoCode: EDEOther, ExtraText: err.Error()}, true
+	}
+	return ExtendedError{}, false
+}
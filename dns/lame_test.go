@@ -0,0 +1,204 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// record returns a record of name with the RDATA
+func record(t *testing.T, name string, qtype QType, rdata []byte) Answer {
+	t.Helper()
+	owner, err := EncodeDomainName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Answer{Name: owner, Type: uint16(qtype), Class: 1, TTL: 60, RData: rdata, RDLength: uint16(len(rdata))}
+}
+
+// nameRecord returns a record of name pointing at target, like NS or CNAME
+func nameRecord(t *testing.T, name string, qtype QType, target string) Answer {
+	t.Helper()
+	rdata, err := EncodeDomainName(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record(t, name, qtype, rdata)
+}
+
+// replyingUpstream answers every query with the sections fill adds to its
+// response. It returns its address.
+func replyingUpstream(t *testing.T, fill func(*Builder)) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, BUFFER_SIZE)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			query := &Message{}
+			if _, err := query.Decode(buf[:n]); err != nil {
+				continue
+			}
+			res := NewResponse(query)
+			fill(res)
+			conn.WriteToUDP(res.Encode(), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestReferral(t *testing.T) {
+	ns := func(zone, server string) []Answer {
+		return []Answer{nameRecord(t, zone, TypeNS, server), record(t, zone, TypeSOA, nil)}
+	}
+	tests := []struct {
+		name      string
+		authority []Answer
+		cut       string
+		wantZone  string
+		wantErr   error
+	}{
+		{"first referral", ns("com.", "a.gtld-servers.net."), "", "com.", nil},
+		{"closer to the name", ns("example.com.", "ns1.example.net."), "com.", "example.com.", nil},
+		{"not a referral", []Answer{record(t, "example.com.", TypeSOA, nil)}, "com.", "", nil},
+		{"zone without the name", ns("example.org.", "ns1.example.org."), "", "", ErrLameDelegation},
+		{"same zone again", ns("com.", "a.gtld-servers.net."), "com.", "", ErrReferralLoop},
+		{"upward", ns(".", "a.root-servers.net."), "com.", "", ErrReferralLoop},
+	}
+	for _, tt := range tests {
+		zone, _, err := referral(nil, tt.authority, "www.example.com.", tt.cut)
+		if zone != tt.wantZone || !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: referral() = %q, %v, want %q, %v", tt.name, zone, err, tt.wantZone, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckCNAMEChain(t *testing.T) {
+	chain := func(names ...string) []Answer {
+		var answers []Answer
+		for i := 0; i+1 < len(names); i++ {
+			answers = append(answers, nameRecord(t, names[i], TypeCNAME, names[i+1]))
+		}
+		return answers
+	}
+	long := []string{"www.example.com."}
+	for i := range MaxCNAMEChain + 1 {
+		long = append(long, string(rune('a'+i))+".example.net.")
+	}
+	tests := []struct {
+		name    string
+		answers []Answer
+		wantErr bool
+	}{
+		{"no alias", []Answer{record(t, "www.example.com.", TypeA, []byte{192, 0, 2, 1})}, false},
+		{"short chain", chain("www.example.com.", "cdn.example.net.", "edge.example.org."), false},
+		{"longest chain", chain(long[:MaxCNAMEChain+1]...), false},
+		{"too long", chain(long...), true},
+		{"loop", chain("www.example.com.", "a.example.net.", "WWW.example.com."), true},
+		{"self", chain("www.example.com.", "www.example.com."), true},
+	}
+	for _, tt := range tests {
+		err := checkCNAMEChain(nil, tt.answers, "www.example.com.")
+		if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrCNAMEChain)) {
+			t.Errorf("%s: checkCNAMEChain() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHandlerLameDelegation(t *testing.T) {
+	tests := []struct {
+		name string
+		fill func(*Builder)
+		code uint16
+	}{
+		{"referral elsewhere", func(b *Builder) {
+			b.Authority(nameRecord(t, "example.org.", TypeNS, "ns1.example.org.")).Additional(record(t, "ns1.example.org.", TypeA, []byte{192, 0, 2, 53}))
+		}, EDENoReachableAuthority},
+		{"self-referential name servers", func(b *Builder) {
+			b.Authority(nameRecord(t, "example.com.", TypeNS, "ns1.example.com."))
+		}, EDENoReachableAuthority},
+		{"CNAME loop", func(b *Builder) {
+			b.Answer(nameRecord(t, "www.example.com.", TypeCNAME, "a.example.net."), nameRecord(t, "a.example.net.", TypeCNAME, "www.example.com."))
+		}, EDEOther},
+	}
+	for _, tt := range tests {
+		handler := &Handler{
+			Cache:     &RecordsCache{Records: make(map[string]Message)},
+			Upstreams: []Upstream{{Address: replyingUpstream(t, tt.fill), Timeout: time.Second}},
+		}
+		query := &Message{
+			Header:     Header{ID: 1, RD: 1, QDCount: 1, ARCount: 1},
+			Question:   Question{DomainName: "www.example.com.", QType: TypeA, QClass: 1},
+			Additional: []Answer{NewOPT(DefaultUDPSize, false)},
+		}
+		query.Bytes = query.Encode()
+		res := Message{}
+		if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+			t.Fatal(err)
+		}
+		if res.Header.RCODE != RcodeServerFailure {
+			t.Errorf("%s: rcode %s, want SERVFAIL", tt.name, RcodeName(res.Header.RCODE))
+		}
+		opt, ok := res.Option(OptionExtendedError)
+		if !ok {
+			t.Errorf("%s: no EDE in the response", tt.name)
+			continue
+		}
+		if ede, _ := opt.ExtendedError(); ede.InfoCode != tt.code || ede.ExtraText == "" {
+			t.Errorf("%s: EDE %s, want code %d with a reason", tt.name, ede, tt.code)
+		}
+	}
+}
+
+func TestHandlerNegativeAnswers(t *testing.T) {
+	mname, _ := EncodeDomainName("ns1.example.com.")
+	rname, _ := EncodeDomainName("admin.example.com.")
+	soaData := append(append(mname, rname...), 0, 0, 0, 1, 0, 0, 14, 16, 0, 0, 2, 88, 0, 9, 58, 128, 0, 0, 0, 30)
+	tests := []struct {
+		name  string
+		rcode uint16
+	}{
+		{"missing.example.com.", RcodeNameError},
+		{"www.example.com.", RcodeSuccess},
+	}
+	for _, tt := range tests {
+		var queries atomic.Int32
+		handler := &Handler{
+			Cache: &RecordsCache{Records: make(map[string]Message)},
+			Upstreams: []Upstream{{Address: replyingUpstream(t, func(b *Builder) {
+				queries.Add(1)
+				b.SetRcode(tt.rcode).Authority(record(t, "example.com.", TypeSOA, soaData))
+			}), Timeout: time.Second}},
+		}
+		for range 2 {
+			query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: tt.name, QType: TypeA, QClass: 1}}
+			query.Bytes = query.Encode()
+			res := Message{}
+			if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+				t.Fatal(err)
+			}
+			if res.Header.RCODE != tt.rcode || len(res.Answers) != 0 || len(res.Authority) != 1 || QType(res.Authority[0].Type) != TypeSOA {
+				t.Fatalf("%s: %s with %d answers and authority %v, want %s with the SOA", tt.name, RcodeName(res.Header.RCODE), len(res.Answers), res.Authority, RcodeName(tt.rcode))
+			}
+			if res.Authority[0].TTL > 30 {
+				t.Errorf("%s: SOA TTL %d, want at most the minimum 30", tt.name, res.Authority[0].TTL)
+			}
+		}
+		if n := queries.Load(); n != 1 {
+			t.Errorf("%s: %d upstream queries, want the negative answer cached", tt.name, n)
+		}
+		if cached, ok := handler.Cache.Get(CacheKey(Question{DomainName: tt.name, QType: TypeA, QClass: 1}, false)); !ok || cached.Header.RCODE != tt.rcode {
+			t.Errorf("%s: cached %v, %v", tt.name, cached, ok)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/bernoussama/mercury/fqdn"
+)
+
+// LocalZoneOptions configures the zones answered locally instead of being
+// resolved upstream, RFC 6303
+type LocalZoneOptions struct {
+	// Enabled answers DefaultLocalZones and Zones locally, on by default
+	Enabled bool `yaml:"enabled"`
+	// Forward lists default zones resolved upstream all the same, like the
+	// reverse zone of a network the upstreams serve
+	Forward []string `yaml:"forward"`
+	// Zones are answered locally along with the default ones
+	Zones []string `yaml:"zones"`
+	// PTR maps addresses to the names their reverse lookups answer
+	PTR map[string]string `yaml:"ptr"`
+}
+
+// Validate reports problems with the options
+func (o LocalZoneOptions) Validate() []error {
+	var errs []error
+	for _, zone := range append(append([]string{}, o.Zones...), o.Forward...) {
+		if _, err := EncodeDomainName(zone); err != nil {
+			errs = append(errs, fmt.Errorf("invalid zone %q: %v", zone, err))
+		}
+	}
+	for addr, name := range o.PTR {
+		if net.ParseIP(addr) == nil {
+			errs = append(errs, fmt.Errorf("invalid ptr address %q", addr))
+		}
+		if _, err := EncodeDomainName(name); err != nil || name == "" {
+			errs = append(errs, fmt.Errorf("invalid ptr name %q for %s", name, addr))
+		}
+	}
+	return errs
+}
+
+// LocalZoneTTL is the TTL of the records of the locally served zones, and
+// how long their negative answers are cached
+const LocalZoneTTL = 10800
+
+// DefaultLocalZones are the zones no public server answers for, RFC 6303
+// section 4 and home.arpa: the reverse zones of private, shared, loopback,
+// link-local and documentation addresses
+var DefaultLocalZones = func() []string {
+	zones := []string{
+		// RFC 1918
+		"10.in-addr.arpa.", "168.192.in-addr.arpa.",
+		// RFC 5735
+		"0.in-addr.arpa.", "127.in-addr.arpa.", "254.169.in-addr.arpa.",
+		"2.0.192.in-addr.arpa.", "100.51.198.in-addr.arpa.", "113.0.203.in-addr.arpa.",
+		"255.255.255.255.in-addr.arpa.",
+		// ::/128 and ::1/128
+		"0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa.",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa.",
+		// unique local, link-local and documentation addresses
+		"d.f.ip6.arpa.", "8.e.f.ip6.arpa.", "9.e.f.ip6.arpa.", "a.e.f.ip6.arpa.", "b.e.f.ip6.arpa.",
+		"8.b.d.0.1.0.0.2.ip6.arpa.",
+		// RFC 8375
+		"home.arpa.",
+	}
+	// 172.16.0.0/12 of RFC 1918 and 100.64.0.0/10 of RFC 6598
+	for i := 16; i <= 31; i++ {
+		zones = append(zones, strconv.Itoa(i)+".172.in-addr.arpa.")
+	}
+	for i := 64; i <= 127; i++ {
+		zones = append(zones, strconv.Itoa(i)+".100.in-addr.arpa.")
+	}
+	return zones
+}()
+
+// LocalZones answers the locally served zones: their SOA and NS at the
+// apex, the PTR records configured and NXDOMAIN for the other names, so
+// reverse lookups of private addresses do not leak upstream. A nil
+// LocalZones answers nothing.
+type LocalZones struct {
+	zones map[fqdn.Name]bool
+	// ptrs holds the name of each reverse name configured
+	ptrs map[fqdn.Name]string
+	// exists holds the reverse names configured and the names above them
+	exists map[fqdn.Name]bool
+}
+
+// NewLocalZones returns the local zones of opts, nil when disabled
+func NewLocalZones(opts LocalZoneOptions) *LocalZones {
+	if !opts.Enabled {
+		return nil
+	}
+	l := &LocalZones{
+		zones:  make(map[fqdn.Name]bool),
+		ptrs:   make(map[fqdn.Name]string),
+		exists: make(map[fqdn.Name]bool),
+	}
+	for _, zone := range append(append([]string{}, DefaultLocalZones...), opts.Zones...) {
+		l.zones[fqdn.Canonical(zone)] = true
+	}
+	for _, zone := range opts.Forward {
+		delete(l.zones, fqdn.Canonical(zone))
+	}
+	for addr, name := range opts.PTR {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		reverse := fqdn.Canonical(ReverseName(ip))
+		l.ptrs[reverse] = string(fqdn.Canonical(name))
+		for n := reverse; n != fqdn.Root; n = n.Parent() {
+			l.exists[n] = true
+		}
+	}
+	return l
+}
+
+// zone returns the local zone of name, false when it is in none
+func (l *LocalZones) zone(name fqdn.Name) (fqdn.Name, bool) {
+	for n := name; ; n = n.Parent() {
+		if l.zones[n] {
+			return n, true
+		}
+		if n == fqdn.Root {
+			return "", false
+		}
+	}
+}
+
+// Lookup returns the answer and authority records of name and the
+// response code. It reports false when name is neither in a local zone
+// nor the reverse name of a PTR configured.
+func (l *LocalZones) Lookup(name string, qtype QType, qclass uint16) (answers, authority []Answer, rcode uint16, ok bool) {
+	if l == nil {
+		return nil, nil, RcodeSuccess, false
+	}
+	n := fqdn.Canonical(name)
+	zone, inZone := l.zone(n)
+	target, isPTR := l.ptrs[n]
+	if !inZone && !isPTR {
+		return nil, nil, RcodeSuccess, false
+	}
+	switch {
+	case isPTR && qtype == TypePTR:
+		answers = localRecords(name, TypePTR, qclass, target)
+	case inZone && n == zone && qtype == TypeSOA:
+		answers = []Answer{localSOA(zone, qclass)}
+	case inZone && n == zone && qtype == TypeNS:
+		answers = localRecords(name, TypeNS, qclass, string(zone))
+	case inZone && n != zone && !l.exists[n]:
+		rcode = RcodeNameError
+	}
+	if len(answers) == 0 && inZone {
+		authority = []Answer{localSOA(zone, qclass)}
+	}
+	return answers, authority, rcode, true
+}
+
+// localRecords returns the record of name pointing at target, when it can
+// be encoded
+func localRecords(name string, qtype QType, qclass uint16, target string) []Answer {
+	owner, err := EncodeDomainName(name)
+	if err != nil {
+		return nil
+	}
+	rdata, err := EncodeDomainName(target)
+	if err != nil {
+		return nil
+	}
+	return []Answer{{Name: owner, Type: uint16(qtype), Class: qclass, TTL: LocalZoneTTL, RData: rdata, RDLength: uint16(len(rdata))}}
+}
+
+// localSOA returns the SOA of a locally served zone, RFC 6303 section 3:
+// the zone as its own name server and nobody.invalid. as its contact
+func localSOA(zone fqdn.Name, qclass uint16) Answer {
+	owner, _ := EncodeDomainName(string(zone))
+	rdata := append([]byte{}, owner...)
+	rdata, _ = AppendDomainName(rdata, "nobody.invalid.")
+	for _, v := range []uint32{1, 3600, 1200, 604800, LocalZoneTTL} {
+		rdata = binary.BigEndian.AppendUint32(rdata, v)
+	}
+	return Answer{Name: owner, Type: uint16(TypeSOA), Class: qclass, TTL: LocalZoneTTL, RData: rdata, RDLength: uint16(len(rdata))}
+}
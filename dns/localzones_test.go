@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalZones(t *testing.T) {
+	l := NewLocalZones(LocalZoneOptions{
+		Enabled: true,
+		Forward: []string{"10.in-addr.arpa"},
+		Zones:   []string{"corp.internal."},
+		PTR:     map[string]string{"192.168.1.10": "nas.home.arpa", "fd00::1": "router.home.arpa."},
+	})
+	tests := []struct {
+		name      string
+		qtype     QType
+		rcode     uint16
+		answer    string
+		authority bool
+	}{
+		{"10.1.168.192.in-addr.arpa.", TypePTR, RcodeSuccess, "nas.home.arpa.", false},
+		{"10.1.168.192.in-addr.arpa.", TypeA, RcodeSuccess, "", true},
+		{"1.168.192.in-addr.arpa.", TypePTR, RcodeSuccess, "", true},
+		{"20.1.168.192.in-addr.arpa.", TypePTR, RcodeNameError, "", true},
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.", TypePTR, RcodeSuccess, "router.home.arpa.", false},
+		{"1.0.16.172.in-addr.arpa.", TypePTR, RcodeNameError, "", true},
+		{"1.0.100.100.in-addr.arpa.", TypePTR, RcodeNameError, "", true},
+		{"168.192.in-addr.arpa.", TypeSOA, RcodeSuccess, "168.192.in-addr.arpa. nobody.invalid. 1 3600 1200 604800 10800", false},
+		{"168.192.in-addr.arpa.", TypeNS, RcodeSuccess, "168.192.in-addr.arpa.", false},
+		{"www.corp.internal.", TypeA, RcodeNameError, "", true},
+	}
+	for _, tt := range tests {
+		answers, authority, rcode, ok := l.Lookup(tt.name, tt.qtype, 1)
+		if !ok {
+			t.Errorf("Lookup(%s, %s) not answered locally", tt.name, tt.qtype)
+			continue
+		}
+		got := ""
+		if len(answers) > 0 {
+			got = answers[0].Data(nil)
+		}
+		if rcode != tt.rcode || got != tt.answer || (len(authority) > 0) != tt.authority {
+			t.Errorf("Lookup(%s, %s) = %q, %d authority, %s, want %q, authority %v, %s", tt.name, tt.qtype,
+				got, len(authority), RcodeName(rcode), tt.answer, tt.authority, RcodeName(tt.rcode))
+		}
+	}
+	for _, name := range []string{"1.0.0.10.in-addr.arpa.", "1.2.0.192.in-addr.org.", "8.8.8.8.in-addr.arpa.", "example.com."} {
+		if _, _, _, ok := l.Lookup(name, TypePTR, 1); ok {
+			t.Errorf("Lookup(%s) answered locally", name)
+		}
+	}
+	if _, _, _, ok := NewLocalZones(LocalZoneOptions{}).Lookup("1.1.168.192.in-addr.arpa.", TypePTR, 1); ok {
+		t.Error("disabled local zones answered")
+	}
+}
+
+func TestHandlerLocalZones(t *testing.T) {
+	calls := 0
+	handler := &Handler{
+		Cache: &RecordsCache{Records: make(map[string]Message)},
+		Upstreams: []Upstream{{Address: replyingUpstream(t, func(b *Builder) {
+			calls++
+		}), Timeout: time.Second}},
+		LocalZones: NewLocalZones(LocalZoneOptions{Enabled: true}),
+	}
+	query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: "1.1.168.192.in-addr.arpa.", QType: TypePTR, QClass: 1}}
+	query.Bytes = query.Encode()
+	res := Message{}
+	if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+		t.Fatal(err)
+	}
+	if res.Header.RCODE != RcodeNameError || !res.Header.Authoritative() || len(res.Authority) != 1 {
+		t.Errorf("response %s with %d authority records, want an authoritative NXDOMAIN with the SOA", RcodeName(res.Header.RCODE), len(res.Authority))
+	}
+	if calls != 0 {
+		t.Errorf("the upstream was asked %d times", calls)
+	}
+}
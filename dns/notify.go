@@ -0,0 +1,48 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+)
+
+// NotifySecondaries tells the secondaries of the zone that it changed,
+// RFC 1996, sending each a NOTIFY carrying the SOA of the zone. The
+// secondaries that do not acknowledge it are reported in the error.
+func (z *Zone) NotifySecondaries(ctx context.Context) error {
+	msg := Message{
+		Header:   Header{ID: uint16(rand.UintN(1 << 16)), Opcode: OpcodeNotify, AA: 1, QDCount: 1},
+		Question: Question{DomainName: z.Origin, QType: TypeSOA, QClass: 1},
+	}
+	msg.Answers = z.Lookup(z.Origin, TypeSOA, 1)
+	msg.Header.ANCount = uint16(len(msg.Answers))
+	data := msg.Encode()
+
+	var errs []error
+	for _, secondary := range z.Notify {
+		if err := notify(ctx, secondary, msg.Header.ID, data); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", secondary.Address, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// notify sends the NOTIFY in data to secondary and checks its reply
+func notify(ctx context.Context, secondary Upstream, id uint16, data []byte) error {
+	res, err := secondary.Exchange(ctx, data)
+	if err != nil {
+		return err
+	}
+	var header Header
+	if err := header.Decode(res); err != nil {
+		return err
+	}
+	switch {
+	case header.ID != id || header.QR != 1 || header.Opcode != OpcodeNotify:
+		return errors.New("reply does not match the notify")
+	case header.RCODE != RcodeSuccess:
+		return fmt.Errorf("answered %s", RcodeName(header.RCODE))
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package dns
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// EDNS option codes
+const (
+	// OptionNSID is the name server identifier, RFC 5001
+	OptionNSID uint16 = 3
+	// OptionClientSubnet is the EDNS client subnet, RFC 7871
+	OptionClientSubnet uint16 = 8
+	// OptionCookie is a DNS cookie, RFC 7873
+	OptionCookie uint16 = 10
+	// OptionPadding is padding, RFC 7830
+	OptionPadding uint16 = 12
+	// OptionExtendedError is an extended DNS error, RFC 8914
+	OptionExtendedError uint16 = 15
+)
+
+var optionNames = map[uint16]string{
+	OptionNSID:          "NSID",
+	OptionClientSubnet:  "ECS",
+	OptionCookie:        "COOKIE",
+	OptionPadding:       "PADDING",
+	OptionExtendedError: "EDE",
+}
+
+// OptionName returns the mnemonic of an EDNS option code
+func OptionName(code uint16) string {
+	if name, ok := optionNames[code]; ok {
+		return name
+	}
+	return "OPTION" + strconv.Itoa(int(code))
+}
+
+var errOptionTruncated = errors.New("edns option truncated")
+
+// Option is an option of an OPT record, RFC 6891. The data of decoded
+// options aliases the packet.
+type Option struct {
+	Code uint16
+	Data []byte
+}
+
+// ParseOptions appends the options of the RDATA of an OPT record to opts.
+// A truncated option ends the list with an error, keeping the ones before.
+func ParseOptions(rdata []byte, opts []Option) ([]Option, error) {
+	for len(rdata) > 0 {
+		if len(rdata) < 4 {
+			return opts, errOptionTruncated
+		}
+		length := 4 + int(binary.BigEndian.Uint16(rdata[2:]))
+		if len(rdata) < length {
+			return opts, errOptionTruncated
+		}
+		opts = append(opts, Option{Code: binary.BigEndian.Uint16(rdata), Data: rdata[4:length]})
+		rdata = rdata[length:]
+	}
+	return opts, nil
+}
+
+// AppendOptions appends opts in wire format to buf, the RDATA of an OPT
+// record
+func AppendOptions(buf []byte, opts []Option) []byte {
+	for _, o := range opts {
+		buf = binary.BigEndian.AppendUint16(buf, o.Code)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(o.Data)))
+		buf = append(buf, o.Data...)
+	}
+	return buf
+}
+
+// String returns the option in the form dig prints it
+func (o Option) String() string {
+	name := OptionName(o.Code)
+	switch o.Code {
+	case OptionNSID:
+		return fmt.Sprintf("%s: %x (%q)", name, o.Data, o.Data)
+	case OptionClientSubnet:
+		if ecs, err := o.ClientSubnet(); err == nil {
+			return fmt.Sprintf("%s: %s/%d/%d", name, ecs.Address, ecs.SourcePrefix, ecs.ScopePrefix)
+		}
+	case OptionCookie:
+		if client, server, err := o.Cookie(); err == nil {
+			if len(server) == 0 {
+				return fmt.Sprintf("%s: %x", name, client)
+			}
+			return fmt.Sprintf("%s: %x %x", name, client, server)
+		}
+	case OptionPadding:
+		return fmt.Sprintf("%s: %d bytes", name, len(o.Data))
+	case OptionExtendedError:
+		if ede, err := o.ExtendedError(); err == nil {
+			return fmt.Sprintf("%s: %s", name, ede)
+		}
+	}
+	return fmt.Sprintf("%s: %s", name, hex.EncodeToString(o.Data))
+}
+
+// address families of client subnets
+const (
+	FamilyIPv4 uint16 = 1
+	FamilyIPv6 uint16 = 2
+)
+
+// ClientSubnet is the network a query was sent on behalf of, RFC 7871
+type ClientSubnet struct {
+	Family       uint16
+	SourcePrefix uint8
+	ScopePrefix  uint8
+	Address      net.IP
+}
+
+// ClientSubnet decodes an ECS option
+func (o Option) ClientSubnet() (ClientSubnet, error) {
+	if o.Code != OptionClientSubnet || len(o.Data) < 4 {
+		return ClientSubnet{}, errors.New("not a client subnet option")
+	}
+	ecs := ClientSubnet{Family: binary.BigEndian.Uint16(o.Data), SourcePrefix: o.Data[2], ScopePrefix: o.Data[3]}
+	size := net.IPv4len
+	if ecs.Family == FamilyIPv6 {
+		size = net.IPv6len
+	} else if ecs.Family != FamilyIPv4 {
+		return ClientSubnet{}, fmt.Errorf("unknown client subnet family %d", ecs.Family)
+	}
+	addr := o.Data[4:]
+	if len(addr) > size || int(ecs.SourcePrefix) > size*8 {
+		return ClientSubnet{}, errors.New("client subnet longer than its family")
+	}
+	ecs.Address = make(net.IP, size)
+	copy(ecs.Address, addr)
+	return ecs, nil
+}
+
+// Option encodes the subnet as an ECS option, keeping the bytes of the
+// address its source prefix covers. A zero family is the one of the address.
+func (ecs ClientSubnet) Option() Option {
+	addr := ecs.Address.To4()
+	if ecs.Family == FamilyIPv6 || addr == nil {
+		addr = ecs.Address.To16()
+	}
+	if ecs.Family == 0 {
+		ecs.Family = FamilyIPv4
+		if len(addr) == net.IPv6len {
+			ecs.Family = FamilyIPv6
+		}
+	}
+	masked := addr.Mask(net.CIDRMask(int(ecs.SourcePrefix), len(addr)*8))
+	data := binary.BigEndian.AppendUint16(nil, ecs.Family)
+	data = append(data, ecs.SourcePrefix, ecs.ScopePrefix)
+	data = append(data, masked[:(int(ecs.SourcePrefix)+7)/8]...)
+	return Option{Code: OptionClientSubnet, Data: data}
+}
+
+// Cookie decodes a cookie option into its 8 byte client cookie and the
+// server cookie, empty in a first query
+func (o Option) Cookie() (client, server []byte, err error) {
+	if o.Code != OptionCookie || len(o.Data) < 8 {
+		return nil, nil, errors.New("not a cookie option")
+	}
+	if n := len(o.Data) - 8; n != 0 && (n < 8 || n > 32) {
+		return nil, nil, fmt.Errorf("server cookie of %d bytes", n)
+	}
+	return o.Data[:8], o.Data[8:], nil
+}
+
+// ExtendedError tells why a response failed or was altered, RFC 8914
+type ExtendedError struct {
+	InfoCode  uint16
+	ExtraText string
+}
+
+// extended error info codes, RFC 8914
+const (
+	EDEOther                uint16 = 0
+	EDEStaleAnswer          uint16 = 3
+	EDEDNSSECBogus          uint16 = 6
+	EDENotReady             uint16 = 14
+	EDEBlocked              uint16 = 15
+	EDECensored             uint16 = 16
+	EDEFiltered             uint16 = 17
+	EDEProhibited           uint16 = 18
+	EDENoReachableAuthority uint16 = 22
+	EDENetworkError         uint16 = 23
+)
+
+var edeNames = map[uint16]string{
+	EDEOther:                "Other Error",
+	EDEStaleAnswer:          "Stale Answer",
+	EDEDNSSECBogus:          "DNSSEC Bogus",
+	EDENotReady:             "Not Ready",
+	EDEBlocked:              "Blocked",
+	EDECensored:             "Censored",
+	EDEFiltered:             "Filtered",
+	EDEProhibited:           "Prohibited",
+	EDENoReachableAuthority: "No Reachable Authority",
+	EDENetworkError:         "Network Error",
+}
+
+func (e ExtendedError) String() string {
+	name, ok := edeNames[e.InfoCode]
+	if !ok {
+		name = "Unknown"
+	}
+	s := fmt.Sprintf("%d (%s)", e.InfoCode, name)
+	if e.ExtraText != "" {
+		s += fmt.Sprintf(": %q", e.ExtraText)
+	}
+	return s
+}
+
+// ExtendedError decodes an EDE option
+func (o Option) ExtendedError() (ExtendedError, error) {
+	if o.Code != OptionExtendedError || len(o.Data) < 2 {
+		return ExtendedError{}, errors.New("not an extended error option")
+	}
+	return ExtendedError{InfoCode: binary.BigEndian.Uint16(o.Data), ExtraText: string(o.Data[2:])}, nil
+}
+
+// Option encodes the error as an EDE option
+func (e ExtendedError) Option() Option {
+	data := binary.BigEndian.AppendUint16(nil, e.InfoCode)
+	return Option{Code: OptionExtendedError, Data: append(data, e.ExtraText...)}
+}
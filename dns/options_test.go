@@ -0,0 +1,101 @@
+package dns
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestOptionsRoundTrip(t *testing.T) {
+	ecs := ClientSubnet{SourcePrefix: 24, Address: net.ParseIP("192.0.2.77")}.Option()
+	ede := ExtendedError{InfoCode: EDEBlocked, ExtraText: "ads"}.Option()
+	cookie := Option{Code: OptionCookie, Data: []byte("clientck")}
+	query := &Message{
+		Header:   Header{ID: 1, RD: 1, QDCount: 1},
+		Question: Question{DomainName: "example.com.", QType: TypeA, QClass: 1},
+	}
+	query.SetOptions(Option{Code: OptionNSID}, ecs, cookie, ede)
+	query.Header.ARCount = uint16(len(query.Additional))
+
+	var decoded Message
+	if _, err := decoded.Decode(query.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Options) != 4 {
+		t.Fatalf("decoded options %v, want 4", decoded.Options)
+	}
+	subnet, err := decoded.Options[1].ClientSubnet()
+	if err != nil || subnet.Family != FamilyIPv4 || subnet.SourcePrefix != 24 || !subnet.Address.Equal(net.ParseIP("192.0.2.0")) {
+		t.Errorf("client subnet %+v, %v, want 192.0.2.0/24", subnet, err)
+	}
+	if len(ecs.Data) != 7 {
+		t.Errorf("client subnet option of %d bytes, want the prefix bytes only", len(ecs.Data))
+	}
+	if client, server, err := decoded.Options[2].Cookie(); err != nil || string(client) != "clientck" || len(server) != 0 {
+		t.Errorf("cookie %q %q, %v", client, server, err)
+	}
+	if e, err := decoded.Options[3].ExtendedError(); err != nil || e.InfoCode != EDEBlocked || e.ExtraText != "ads" {
+		t.Errorf("extended error %+v, %v", e, err)
+	}
+	if o, ok := decoded.Option(OptionExtendedError); !ok || !bytes.Equal(o.Data, ede.Data) {
+		t.Errorf("Option(EDE) = %v, %v", o, ok)
+	}
+	if got := decoded.Options[3].String(); got != `EDE: 15 (Blocked): "ads"` {
+		t.Errorf("String() = %s", got)
+	}
+}
+
+func TestUpstreamQueryStripsClientOptions(t *testing.T) {
+	ecs := ClientSubnet{SourcePrefix: 24, Address: net.ParseIP("192.0.2.77")}.Option()
+	query := &Message{
+		Header:   Header{ID: 1, RD: 1, QDCount: 1},
+		Question: Question{DomainName: "example.com.", QType: TypeA, QClass: 1},
+	}
+	query.SetOptions(Option{Code: OptionNSID}, ecs, Option{Code: OptionCookie, Data: []byte("clientck")})
+	query.Header.ARCount = uint16(len(query.Additional))
+	var decoded Message
+	if _, err := decoded.Decode(query.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	decoded.Bytes = query.Encode()
+
+	var sent Message
+	if _, err := sent.Decode(upstreamQuery(&decoded)); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent.Options) != 1 || sent.Options[0].Code != OptionNSID || sent.Header.ID != 1 || sent.Question != decoded.Question {
+		t.Errorf("sent %+v with options %v, want the query with NSID only", sent.Header, sent.Options)
+	}
+	if len(decoded.Options) != 3 {
+		t.Errorf("query options %v changed", decoded.Options)
+	}
+
+	plain := &Message{Header: query.Header, Question: query.Question}
+	plain.Header.ARCount = 0
+	plain.Bytes = plain.Encode()
+	if got := upstreamQuery(plain); !bytes.Equal(got, plain.Bytes) {
+		t.Errorf("query without EDNS sent as %x, want it as is", got)
+	}
+}
+
+func TestParseOptionsTruncated(t *testing.T) {
+	rdata := AppendOptions(nil, []Option{{Code: OptionNSID, Data: []byte("ns1")}})
+	rdata = append(rdata, 0, byte(OptionPadding), 0, 9, 0)
+	opts, err := ParseOptions(rdata, nil)
+	if err == nil || len(opts) != 1 || string(opts[0].Data) != "ns1" {
+		t.Errorf("ParseOptions() = %v, %v, want the NSID and an error", opts, err)
+	}
+}
+
+func TestClientSubnetInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		{0, 1, 24},             // short
+		{0, 3, 24, 0, 1, 2, 3}, // unknown family
+		{0, 1, 33, 0, 1, 2, 3}, // prefix longer than IPv4
+		{0, 1, 32, 0, 1, 2, 3, 4, 5},
+	} {
+		if ecs, err := (Option{Code: OptionClientSubnet, Data: data}).ClientSubnet(); err == nil {
+			t.Errorf("ClientSubnet(%v) = %+v, want an error", data, ecs)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrTooManyQueries is returned when a resolution would take a client, or
+// the server, past its cap of outstanding queries
+var ErrTooManyQueries = errors.New("too many outstanding queries")
+
+// ConcurrencyLimits cap the resolutions in flight at once
+type ConcurrencyLimits struct {
+	// PerClient caps the resolutions of one client address, unlimited
+	// when zero
+	PerClient int `yaml:"per_client"`
+	// Total caps the resolutions of all clients, unlimited when zero
+	Total int `yaml:"total"`
+}
+
+// Validate reports problems with the limits
+func (cl ConcurrencyLimits) Validate() []error {
+	var errs []error
+	if cl.PerClient < 0 || cl.Total < 0 {
+		errs = append(errs, errors.New("per_client and total must not be negative"))
+	}
+	if cl.PerClient > 0 && cl.Total > 0 && cl.PerClient > cl.Total {
+		errs = append(errs, errors.New("per_client is above total"))
+	}
+	return errs
+}
+
+// Outstanding is the table of the resolutions in flight by client. New
+// ones past the caps are refused, so a single misbehaving device cannot
+// take every upstream socket. A nil Outstanding caps nothing.
+type Outstanding struct {
+	limits ConcurrencyLimits
+
+	mu       sync.Mutex
+	clients  map[string]int
+	inFlight int
+	rejected atomic.Uint64
+}
+
+// OutstandingStats describes the resolutions in flight
+type OutstandingStats struct {
+	InFlight int `json:"in_flight"`
+	// Clients counts the clients with resolutions in flight
+	Clients  int    `json:"clients"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// NewOutstanding returns a table enforcing limits, nil when they cap
+// nothing
+func NewOutstanding(limits ConcurrencyLimits) *Outstanding {
+	if limits.PerClient <= 0 && limits.Total <= 0 {
+		return nil
+	}
+	return &Outstanding{limits: limits, clients: make(map[string]int)}
+}
+
+// acquire counts a resolution for client, failing with ErrTooManyQueries
+// past a cap. Every successful acquire must be released.
+func (o *Outstanding) acquire(client net.IP) error {
+	if o == nil {
+		return nil
+	}
+	key := string(client.To16())
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if (o.limits.Total > 0 && o.inFlight >= o.limits.Total) ||
+		(o.limits.PerClient > 0 && client != nil && o.clients[key] >= o.limits.PerClient) {
+		o.rejected.Add(1)
+		return ErrTooManyQueries
+	}
+	o.inFlight++
+	if client != nil {
+		o.clients[key]++
+	}
+	return nil
+}
+
+// release ends a resolution acquired for client
+func (o *Outstanding) release(client net.IP) {
+	if o == nil {
+		return
+	}
+	key := string(client.To16())
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.inFlight--
+	if client == nil {
+		return
+	}
+	if o.clients[key] <= 1 {
+		delete(o.clients, key)
+	} else {
+		o.clients[key]--
+	}
+}
+
+// Stats returns the resolutions in flight and those refused so far
+func (o *Outstanding) Stats() OutstandingStats {
+	if o == nil {
+		return OutstandingStats{}
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return OutstandingStats{InFlight: o.inFlight, Clients: len(o.clients), Rejected: o.rejected.Load()}
+}
@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOutstandingCaps(t *testing.T) {
+	if NewOutstanding(ConcurrencyLimits{}) != nil {
+		t.Error("limits without caps made a table")
+	}
+	o := NewOutstanding(ConcurrencyLimits{PerClient: 2, Total: 3})
+	a, b := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+	for i, tt := range []struct {
+		client net.IP
+		err    error
+	}{
+		{a, nil},
+		{a, nil},
+		{a, ErrTooManyQueries},
+		{b, nil},
+		{b, ErrTooManyQueries},
+	} {
+		if err := o.acquire(tt.client); !errors.Is(err, tt.err) {
+			t.Errorf("acquire %d for %s = %v, want %v", i, tt.client, err, tt.err)
+		}
+	}
+	if got := o.Stats(); got.InFlight != 3 || got.Clients != 2 || got.Rejected != 2 {
+		t.Errorf("stats = %+v", got)
+	}
+	o.release(a)
+	if err := o.acquire(b); err != nil {
+		t.Errorf("acquire after a release = %v", err)
+	}
+	o.release(a)
+	o.release(b)
+	o.release(b)
+	if got := o.Stats(); got.InFlight != 0 || got.Clients != 0 {
+		t.Errorf("stats after releasing all = %+v", got)
+	}
+}
+
+func TestHandlerOutstandingCap(t *testing.T) {
+	client := net.ParseIP("192.0.2.1")
+	handler := &Handler{
+		Cache:       &RecordsCache{Records: make(map[string]Message)},
+		Upstreams:   []Upstream{{Address: staticUpstream(t, TypeA, 60, []byte{192, 0, 2, 10}), Timeout: time.Second}},
+		Outstanding: NewOutstanding(ConcurrencyLimits{PerClient: 1}),
+	}
+	// a resolution of the client still in flight
+	handler.Outstanding.acquire(client)
+
+	query := &Message{
+		Header:     Header{ID: 1, RD: 1, QDCount: 1, ARCount: 1},
+		Question:   Question{DomainName: "www.example.com.", QType: TypeA, QClass: 1},
+		Additional: []Answer{NewOPT(DefaultUDPSize, false)},
+	}
+	query.Bytes = query.Encode()
+	res := Message{}
+	if _, err := res.Decode(handler.BuildResponse(WithClient(context.Background(), client), query)); err != nil {
+		t.Fatal(err)
+	}
+	if res.Header.RCODE != RcodeServerFailure {
+		t.Errorf("rcode %s, want SERVFAIL", RcodeName(res.Header.RCODE))
+	}
+	if opt, ok := res.Option(OptionExtendedError); !ok {
+		t.Error("no EDE in the response")
+	} else if ede, _ := opt.ExtendedError(); ede.ExtraText != ErrTooManyQueries.Error() {
+		t.Errorf("EDE %s, want the outstanding queries reason", ede)
+	}
+
+	// another client is answered
+	res = Message{}
+	if _, err := res.Decode(handler.BuildResponse(WithClient(context.Background(), net.ParseIP("192.0.2.2")), query)); err != nil {
+		t.Fatal(err)
+	}
+	if res.Header.RCODE != RcodeSuccess || len(res.Answers) != 1 {
+		t.Errorf("other client: rcode %s with %d answers", RcodeName(res.Header.RCODE), len(res.Answers))
+	}
+	if got := handler.Outstanding.Stats(); got.InFlight != 1 || got.Rejected != 1 {
+		t.Errorf("stats = %+v, want the held resolution and 1 rejected", got)
+	}
+}
@@ -0,0 +1,207 @@
+package dns
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	errBadPointer = errors.New("invalid compression pointer")
+	errTruncated  = errors.New("message truncated")
+)
+
+// String returns the mnemonic of the type, or TYPEnnn for unknown types
+func (qtype QType) String() string {
+	if name, ok := types[qtype]; ok {
+		return strings.ToUpper(name)
+	}
+	return "TYPE" + strconv.Itoa(int(qtype))
+}
+
+// ParseQType parses a type mnemonic such as "AAAA" or the TYPEnnn form
+func ParseQType(s string) (QType, error) {
+	lower := strings.ToLower(s)
+	for qtype, name := range types {
+		if name == lower {
+			return qtype, nil
+		}
+	}
+	if n, ok := strings.CutPrefix(lower, "type"); ok {
+		if v, err := strconv.ParseUint(n, 10, 16); err == nil {
+			return QType(v), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown record type %q", s)
+}
+
+// ParseGenericData parses RDATA in the RFC 3597 generic format,
+// `\# length hex`, where the hex may be split by whitespace
+func ParseGenericData(s string) ([]byte, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 || fields[0] != `\#` {
+		return nil, fmt.Errorf(`rdata %q is not of the form \# length hex`, s)
+	}
+	length, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("rdata length %q is not a 16 bit unsigned integer", fields[1])
+	}
+	data, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil {
+		return nil, fmt.Errorf("rdata is not hex: %v", err)
+	}
+	if len(data) != int(length) {
+		return nil, fmt.Errorf("rdata of %d bytes, length says %d", len(data), length)
+	}
+	return append([]byte{}, data...), nil
+}
+
+// DecodeName reads the possibly compressed domain name at offset in packet.
+// It returns the name and the offset just past it in the original data.
+func DecodeName(packet []byte, offset int) (string, int, error) {
+	var name strings.Builder
+	end := -1
+	for jumps := 0; ; {
+		if offset >= len(packet) {
+			return "", 0, errors.New("invalid domain name")
+		}
+		length := int(packet[offset])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			if name.Len() == 0 {
+				return ".", end, nil
+			}
+			return name.String(), end, nil
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(packet) || jumps > 32 {
+				return "", 0, errBadPointer
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(packet[offset:]) & 0x3FFF)
+			jumps++
+		default:
+			if offset+1+length > len(packet) {
+				return "", 0, errors.New("invalid domain name")
+			}
+			name.Write(packet[offset+1 : offset+1+length])
+			name.WriteByte('.')
+			offset += 1 + length
+		}
+	}
+}
+
+// offsetIn returns where sub starts in packet, when sub was sliced from it
+func offsetIn(packet, sub []byte) (int, bool) {
+	if len(sub) == 0 || cap(sub) > cap(packet) {
+		return 0, false
+	}
+	offset := cap(packet) - cap(sub)
+	if offset+len(sub) > len(packet) || &packet[offset] != &sub[0] {
+		return 0, false
+	}
+	return offset, true
+}
+
+// OwnerName returns the owner of a record decoded from packet, following
+// compression pointers into the packet.
+func (answer *Answer) OwnerName(packet []byte) (string, error) {
+	if offset, ok := offsetIn(packet, answer.Name); ok {
+		name, _, err := DecodeName(packet, offset)
+		return name, err
+	}
+	name, _, err := DecodeName(answer.Name, 0)
+	return name, err
+}
+
+// Data renders the RDATA of a record decoded from packet in presentation
+// format. Names inside the RDATA may be compressed against the packet.
+func (answer *Answer) Data(packet []byte) string {
+	rdata := answer.RData
+	name := func(at int) (string, int) {
+		if offset, ok := offsetIn(packet, rdata); ok {
+			n, end, err := DecodeName(packet, offset+at)
+			if err == nil {
+				return n, end - offset
+			}
+		}
+		n, end, err := DecodeName(rdata, at)
+		if err != nil {
+			return "<invalid>", len(rdata)
+		}
+		return n, end
+	}
+
+	switch QType(answer.Type) {
+	case TypeA:
+		if len(rdata) == net.IPv4len {
+			return net.IP(rdata).String()
+		}
+	case TypeAAAA:
+		if len(rdata) == net.IPv6len {
+			return net.IP(rdata).String()
+		}
+	case TypeNS, TypeCNAME, TypePTR, TypeMB, TypeMD, TypeMF, TypeMG, TypeMR:
+		n, _ := name(0)
+		return n
+	case TypeMX:
+		if len(rdata) >= 3 {
+			n, _ := name(2)
+			return fmt.Sprintf("%d %s", binary.BigEndian.Uint16(rdata), n)
+		}
+	case TypeSRV:
+		if len(rdata) >= 7 {
+			n, _ := name(6)
+			return fmt.Sprintf("%d %d %d %s", binary.BigEndian.Uint16(rdata), binary.BigEndian.Uint16(rdata[2:]), binary.BigEndian.Uint16(rdata[4:]), n)
+		}
+	case TypeNAPTR:
+		if len(rdata) < 4 {
+			break
+		}
+		fields := []string{strconv.Itoa(int(binary.BigEndian.Uint16(rdata))), strconv.Itoa(int(binary.BigEndian.Uint16(rdata[2:])))}
+		i := 4
+		for range 3 {
+			if i >= len(rdata) || i+1+int(rdata[i]) > len(rdata) {
+				return fmt.Sprintf(`\# %d %x`, len(rdata), rdata)
+			}
+			fields = append(fields, strconv.Quote(string(rdata[i+1:i+1+int(rdata[i])])))
+			i += 1 + int(rdata[i])
+		}
+		n, _ := name(i)
+		return strings.Join(append(fields, n), " ")
+	case TypeTLSA:
+		if len(rdata) > 3 {
+			return fmt.Sprintf("%d %d %d %X", rdata[0], rdata[1], rdata[2], rdata[3:])
+		}
+	case TypeTXT:
+		var parts []string
+		for i := 0; i < len(rdata); {
+			length := int(rdata[i])
+			if i+1+length > len(rdata) {
+				break
+			}
+			parts = append(parts, strconv.Quote(string(rdata[i+1:i+1+length])))
+			i += 1 + length
+		}
+		return strings.Join(parts, " ")
+	case TypeSOA:
+		mname, next := name(0)
+		rname, next := name(next)
+		if next+20 <= len(rdata) {
+			v := rdata[next:]
+			return fmt.Sprintf("%s %s %d %d %d %d %d", mname, rname,
+				binary.BigEndian.Uint32(v), binary.BigEndian.Uint32(v[4:]), binary.BigEndian.Uint32(v[8:]),
+				binary.BigEndian.Uint32(v[12:]), binary.BigEndian.Uint32(v[16:]))
+		}
+	}
+	// RFC 3597 generic format
+	return fmt.Sprintf(`\# %d %x`, len(rdata), rdata)
+}
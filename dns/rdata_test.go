@@ -0,0 +1,107 @@
+package dns
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseQType(t *testing.T) {
+	tests := []struct {
+		in   string
+		want QType
+		err  bool
+	}{
+		{"A", TypeA, false},
+		{"aaaa", TypeAAAA, false},
+		{"Mx", TypeMX, false},
+		{"TYPE99", QType(99), false},
+		{"bogus", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseQType(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseQType(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+	if got := QType(99).String(); got != "TYPE99" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestDecodeName(t *testing.T) {
+	// example.com at 0, www pointing back to it at 13
+	packet := []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 3, 'w', 'w', 'w', 0xC0, 0}
+	tests := []struct {
+		offset int
+		want   string
+		end    int
+	}{
+		{0, "example.com.", 13},
+		{13, "www.example.com.", 19},
+		{12, ".", 13},
+	}
+	for _, tt := range tests {
+		got, end, err := DecodeName(packet, tt.offset)
+		if err != nil || got != tt.want || end != tt.end {
+			t.Errorf("DecodeName(%d) = %q, %d, %v", tt.offset, got, end, err)
+		}
+	}
+
+	loop := []byte{0xC0, 0}
+	if _, _, err := DecodeName(loop, 0); err == nil {
+		t.Error("expected an error for a pointer loop")
+	}
+}
+
+func TestAnswerData(t *testing.T) {
+	tests := []struct {
+		qtype QType
+		rdata []byte
+		want  string
+	}{
+		{TypeA, []byte{192, 0, 2, 1}, "192.0.2.1"},
+		{TypeMX, []byte{0, 10, 4, 'm', 'a', 'i', 'l', 0}, "10 mail."},
+		{TypeTXT, []byte{2, 'h', 'i'}, `"hi"`},
+		{TypeSRV, []byte{0, 10, 0, 5, 0x13, 0xc4, 3, 's', 'i', 'p', 0}, "10 5 5060 sip."},
+		{TypeNAPTR, []byte{0, 100, 0, 10, 1, 'S', 7, 'S', 'I', 'P', '+', 'D', '2', 'U', 0, 3, 's', 'i', 'p', 0}, `100 10 "S" "SIP+D2U" "" sip.`},
+		{TypeNAPTR, []byte{0, 100, 0, 10, 5, 'S'}, `\# 6 0064000a0553`},
+		{TypeTLSA, []byte{3, 1, 1, 0xab, 0xcd}, "3 1 1 ABCD"},
+		{QType(99), []byte{1, 2}, `\# 2 0102`},
+	}
+	for _, tt := range tests {
+		answer := Answer{Type: uint16(tt.qtype), RData: tt.rdata}
+		if got := answer.Data(nil); got != tt.want {
+			t.Errorf("Data(%v) = %q, want %q", tt.qtype, got, tt.want)
+		}
+	}
+}
+
+func TestParseGenericData(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []byte
+		wantErr bool
+	}{
+		{`\# 2 0102`, []byte{1, 2}, false},
+		{`\# 4 0a00 0001`, []byte{10, 0, 0, 1}, false},
+		{`\# 0`, []byte{}, false},
+		{`\# 3 0102`, nil, true},
+		{`\# 1 zz`, nil, true},
+		{`\# x 01`, nil, true},
+		{`0102`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseGenericData(tt.in)
+		if (err != nil) != tt.wantErr || !bytes.Equal(got, tt.want) {
+			t.Errorf("ParseGenericData(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+		if err != nil {
+			continue
+		}
+		// the generic format of Data parses back to the same bytes
+		answer := Answer{Type: 65280, RData: got}
+		if again, err := ParseGenericData(answer.Data(nil)); err != nil || !bytes.Equal(again, got) {
+			t.Errorf("ParseGenericData(%q) = %v, %v, want %v", answer.Data(nil), again, err, got)
+		}
+	}
+}
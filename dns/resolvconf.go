@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResolvConfPath is the system resolver config read for default upstreams
+const ResolvConfPath = "/etc/resolv.conf"
+
+// ResolvConf holds the settings of a resolv.conf file that apply to a
+// forwarding server
+type ResolvConf struct {
+	// Nameservers are host:port addresses, in the order of the file
+	Nameservers []string
+	// Search are the domains of the search or domain line, the last wins
+	Search []string
+	// Timeout bounds each attempt, zero when the file leaves it unset
+	Timeout time.Duration
+	// Attempts is the number of tries per name server, zero when unset
+	Attempts int
+}
+
+// ReadResolvConf parses the resolv.conf file at path
+func ReadResolvConf(path string) (ResolvConf, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ResolvConf{}, err
+	}
+	defer f.Close()
+	return ParseResolvConf(f)
+}
+
+// ParseResolvConf parses a resolv.conf file. Unknown keywords and options,
+// and malformed name servers are skipped like the system resolver does.
+func ParseResolvConf(r io.Reader) (ResolvConf, error) {
+	var conf ResolvConf
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			// scoped IPv6 addresses keep their zone
+			host, _, _ := strings.Cut(fields[1], "%")
+			if net.ParseIP(host) != nil {
+				conf.Nameservers = append(conf.Nameservers, net.JoinHostPort(fields[1], "53"))
+			}
+		case "domain", "search":
+			conf.Search = fields[1:]
+		case "options":
+			for _, option := range fields[1:] {
+				name, value, _ := strings.Cut(option, ":")
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 {
+					continue
+				}
+				switch name {
+				case "timeout":
+					conf.Timeout = time.Duration(n) * time.Second
+				case "attempts":
+					conf.Attempts = n
+				}
+			}
+		}
+	}
+	return conf, scanner.Err()
+}
+
+// Upstreams returns the name servers as upstreams, retried as the options
+// say or with the default policy
+func (c ResolvConf) Upstreams() []Upstream {
+	upstreams := make([]Upstream, 0, len(c.Nameservers))
+	for _, address := range c.Nameservers {
+		u := Upstream{Address: address, Timeout: c.Timeout}
+		if c.Attempts > 0 {
+			u.Retries = c.Attempts - 1
+		} else {
+			u.Retries = DefaultUpstreamRetries
+		}
+		upstreams = append(upstreams, u)
+	}
+	return upstreams
+}
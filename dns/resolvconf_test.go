@@ -0,0 +1,40 @@
+package dns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	conf, err := ParseResolvConf(strings.NewReader(`# generated by NetworkManager
+domain example.net
+search lan example.com ; the last search line wins
+nameserver 192.168.1.1
+nameserver fe80::1%eth0
+nameserver not-an-address
+nameserver 2001:db8::53
+options ndots:2 timeout:3 attempts:4 rotate attempts:x
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ResolvConf{
+		Nameservers: []string{"192.168.1.1:53", "[fe80::1%eth0]:53", "[2001:db8::53]:53"},
+		Search:      []string{"lan", "example.com"},
+		Timeout:     3 * time.Second,
+		Attempts:    4,
+	}
+	if !reflect.DeepEqual(conf, want) {
+		t.Errorf("ParseResolvConf() = %+v, want %+v", conf, want)
+	}
+
+	upstreams := conf.Upstreams()
+	if len(upstreams) != 3 || upstreams[0] != (Upstream{Address: "192.168.1.1:53", Timeout: 3 * time.Second, Retries: 3}) {
+		t.Errorf("Upstreams() = %+v", upstreams)
+	}
+	if u := (ResolvConf{Nameservers: []string{"10.0.0.1:53"}}).Upstreams(); u[0].Retries != DefaultUpstreamRetries || u[0].Timeout != 0 {
+		t.Errorf("Upstreams() without options = %+v, want the default policy", u)
+	}
+}
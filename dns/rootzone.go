@@ -0,0 +1,342 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bernoussama/mercury/fqdn"
+)
+
+// RootZoneOptions configures the local copy of the root zone, RFC 8806
+type RootZoneOptions struct {
+	// Mirror transfers the root zone and answers from it, resolving
+	// from the root servers
+	Mirror bool `yaml:"mirror"`
+	// Sources are the servers the zone is transferred from, in order,
+	// DefaultRootZoneSources when empty
+	Sources []string `yaml:"sources"`
+}
+
+// DefaultRootZoneSources are the servers of RFC 8806 appendix A serving
+// the root zone over AXFR: lax.xfr.dns.icann.org, iad.xfr.dns.icann.org
+// and the b, c, f and k root servers
+var DefaultRootZoneSources = []string{
+	"192.0.32.132:53", "192.0.47.132:53",
+	"199.9.14.201:53", "192.33.4.12:53", "192.5.5.241:53", "193.0.14.129:53",
+}
+
+// Validate reports problems with the options
+func (o RootZoneOptions) Validate() []error {
+	var errs []error
+	for _, source := range o.Sources {
+		if _, err := net.ResolveTCPAddr("tcp", source); err != nil {
+			errs = append(errs, fmt.Errorf("invalid source %q: %v", source, err))
+		}
+	}
+	return errs
+}
+
+// timing of the root zone transfers
+const (
+	// rootZoneTransferTimeout bounds one transfer of the zone
+	rootZoneTransferTimeout = 2 * time.Minute
+	// rootZoneRetry is the wait after a failed first transfer, later ones
+	// wait the SOA retry
+	rootZoneRetry = 5 * time.Minute
+)
+
+// RootZone is a local copy of the root zone. Names under TLDs it does not
+// hold are answered NXDOMAIN without asking the root servers, and
+// resolutions start at the name servers of their TLD. The copy is used
+// until the SOA expire after the last time its serial was checked, and
+// refreshed when the serial of its sources moves. A nil RootZone holds
+// nothing.
+type RootZone struct {
+	sources []string
+
+	mu      sync.RWMutex
+	soa     Answer
+	serial  uint32
+	refresh time.Duration
+	retry   time.Duration
+	expire  time.Duration
+	expiry  time.Time
+	// tlds holds the address of a name server of each TLD, from its glue
+	tlds map[fqdn.Name]string
+
+	nxdomain  atomic.Uint64
+	referrals atomic.Uint64
+}
+
+// RootZoneStats describes the copy of the root zone
+type RootZoneStats struct {
+	Serial uint32    `json:"serial"`
+	TLDs   int       `json:"tlds"`
+	Expiry time.Time `json:"expiry"`
+	// NXDomain counts the queries answered NXDOMAIN from the copy
+	NXDomain uint64 `json:"nxdomain"`
+	// Referrals counts the resolutions started at a TLD of the copy
+	Referrals uint64 `json:"referrals"`
+}
+
+// NewRootZone returns the empty copy of the root zone of opts, nil when
+// it is not mirrored
+func NewRootZone(opts RootZoneOptions) *RootZone {
+	if !opts.Mirror {
+		return nil
+	}
+	sources := opts.Sources
+	if len(sources) == 0 {
+		sources = DefaultRootZoneSources
+	}
+	return &RootZone{sources: sources}
+}
+
+type rootZoneKey struct{}
+
+// withRootZone has the resolutions on behalf of ctx start at the TLD name
+// servers of root
+func withRootZone(ctx context.Context, root *RootZone) context.Context {
+	return context.WithValue(ctx, rootZoneKey{}, root)
+}
+
+// rootZoneOf returns the root zone copy of ctx, nil without one
+func rootZoneOf(ctx context.Context) *RootZone {
+	root, _ := ctx.Value(rootZoneKey{}).(*RootZone)
+	return root
+}
+
+// Load replaces the copy with the records of a transfer of the root zone,
+// checked at now. The records must start with the SOA of the root and
+// hold its NS records and delegations.
+func (r *RootZone) Load(records []Answer, now time.Time) error {
+	if len(records) == 0 || QType(records[0].Type) != TypeSOA || len(records[0].RData) < 22 {
+		return errors.New("root zone does not start with its SOA")
+	}
+	soa := records[0]
+	if owner, err := soa.OwnerName(nil); err != nil || owner != "." {
+		return errors.New("root zone does not start with its SOA")
+	}
+	times := soa.RData[len(soa.RData)-20:]
+	serial := binary.BigEndian.Uint32(times)
+	refresh := time.Duration(binary.BigEndian.Uint32(times[4:])) * time.Second
+	retry := time.Duration(binary.BigEndian.Uint32(times[8:])) * time.Second
+	expire := time.Duration(binary.BigEndian.Uint32(times[12:])) * time.Second
+	// negative answers live for the lower of the SOA TTL and minimum,
+	// RFC 2308 section 3
+	soa.TTL = min(soa.TTL, binary.BigEndian.Uint32(times[16:]))
+
+	servers := make(map[fqdn.Name][]fqdn.Name)
+	glue := make(map[fqdn.Name][]Answer)
+	apex := false
+	for i := range records {
+		owner, err := records[i].OwnerName(nil)
+		if err != nil {
+			continue
+		}
+		name := fqdn.Canonical(owner)
+		switch QType(records[i].Type) {
+		case TypeNS:
+			if name == fqdn.Root {
+				apex = true
+				continue
+			}
+			if target, _, err := DecodeName(records[i].RData, 0); err == nil {
+				servers[name] = append(servers[name], fqdn.Canonical(target))
+			}
+		case TypeA, TypeAAAA:
+			glue[name] = append(glue[name], records[i])
+		}
+	}
+	if !apex || len(servers) == 0 {
+		return errors.New("root zone without its name servers or delegations")
+	}
+	tlds := make(map[fqdn.Name]string, len(servers))
+	for tld, names := range servers {
+		var addrs []Answer
+		for _, name := range names {
+			addrs = append(addrs, glue[name]...)
+		}
+		// TLDs without glue are resolved through the root servers
+		tlds[tld] = glueAddress(addrs)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.soa, r.serial, r.refresh, r.retry, r.expire = soa, serial, refresh, retry, expire
+	r.expiry = now.Add(expire)
+	r.tlds = tlds
+	return nil
+}
+
+// usable reports whether the copy is loaded and not expired at now, r.mu
+// held
+func (r *RootZone) usable(now time.Time) bool {
+	return r.tlds != nil && now.Before(r.expiry)
+}
+
+// tld returns the top level domain of name, false for the root itself
+func tld(name string) (fqdn.Name, bool) {
+	n := fqdn.Canonical(name)
+	if n == fqdn.Root {
+		return "", false
+	}
+	for n.Parent() != fqdn.Root {
+		n = n.Parent()
+	}
+	return n, true
+}
+
+// NoSuchTLD returns the SOA of the root to answer NXDOMAIN with when the
+// TLD of name is not in the copy, false when it is or the copy cannot be
+// used at now
+func (r *RootZone) NoSuchTLD(name string, now time.Time) (Answer, bool) {
+	if r == nil {
+		return Answer{}, false
+	}
+	top, ok := tld(name)
+	if !ok {
+		return Answer{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.usable(now) {
+		return Answer{}, false
+	}
+	if _, ok := r.tlds[top]; ok {
+		return Answer{}, false
+	}
+	r.nxdomain.Add(1)
+	return r.soa, true
+}
+
+// Referral returns the TLD of name and the address of one of its name
+// servers, false when the copy has no glue for it or cannot be used at
+// now
+func (r *RootZone) Referral(name string, now time.Time) (string, string, bool) {
+	if r == nil {
+		return "", "", false
+	}
+	top, ok := tld(name)
+	if !ok {
+		return "", "", false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.usable(now) {
+		return "", "", false
+	}
+	address := r.tlds[top]
+	if address == "" {
+		return "", "", false
+	}
+	r.referrals.Add(1)
+	return string(top), address, true
+}
+
+// Stats returns the serial and size of the copy and its counters
+func (r *RootZone) Stats() RootZoneStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return RootZoneStats{
+		Serial:    r.serial,
+		TLDs:      len(r.tlds),
+		Expiry:    r.expiry,
+		NXDomain:  r.nxdomain.Load(),
+		Referrals: r.referrals.Load(),
+	}
+}
+
+// Run keeps the copy up to date until ctx is done: it transfers the zone,
+// then checks the serial of the sources every SOA refresh, transferring
+// the zone again when it moved and checking again after the SOA retry
+// when no source answers.
+func (r *RootZone) Run(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	for {
+		wait := r.update(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// update refreshes the copy from the first source that answers and
+// returns the wait until the next refresh
+func (r *RootZone) update(ctx context.Context, now time.Time) time.Duration {
+	r.mu.RLock()
+	serial, loaded, retry := r.serial, r.tlds != nil, r.retry
+	r.mu.RUnlock()
+	if !loaded {
+		retry = rootZoneRetry
+	}
+	for _, source := range r.sources {
+		if loaded {
+			current, err := sourceSerial(ctx, source)
+			if err != nil {
+				resolverLog.Debug("root zone serial check failed", "source", source, "err", err)
+				continue
+			}
+			if !SerialAfter(current, serial) {
+				r.mu.Lock()
+				defer r.mu.Unlock()
+				r.expiry = now.Add(r.expire)
+				return max(r.refresh, time.Minute)
+			}
+		}
+		tctx, cancel := context.WithTimeout(ctx, rootZoneTransferTimeout)
+		records, err := Transfer(tctx, source, ".")
+		cancel()
+		if err == nil {
+			err = r.Load(records, now)
+		}
+		if err != nil {
+			resolverLog.Warn("root zone transfer failed", "source", source, "err", err)
+			continue
+		}
+		stats := r.Stats()
+		resolverLog.Info("transferred the root zone", "source", source, "serial", stats.Serial, "tlds", stats.TLDs)
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return max(r.refresh, time.Minute)
+	}
+	return max(retry, time.Minute)
+}
+
+// sourceSerial asks source for the SOA serial of the root
+func sourceSerial(ctx context.Context, source string) (uint32, error) {
+	query := &Message{
+		Header:   Header{ID: uint16(rand.Uint32()), QDCount: 1},
+		Question: Question{DomainName: ".", QType: TypeSOA, QClass: 1},
+	}
+	res, err := Upstream{Address: source}.Exchange(ctx, query.Encode())
+	if err != nil {
+		return 0, err
+	}
+	msg := Message{}
+	if _, err := msg.Decode(res); err != nil {
+		return 0, err
+	}
+	for i := range msg.Answers {
+		if QType(msg.Answers[i].Type) != TypeSOA {
+			continue
+		}
+		record, err := expand(res, &msg.Answers[i])
+		if err != nil || len(record.RData) < 20 {
+			break
+		}
+		return binary.BigEndian.Uint32(record.RData[len(record.RData)-20:]), nil
+	}
+	return 0, errors.New("no SOA in the reply")
+}
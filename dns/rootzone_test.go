@@ -0,0 +1,129 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// soaRecord returns the SOA of zone with serial, refresh 1800, retry 900,
+// expire a week and minimum 86400
+func soaRecord(t *testing.T, zone string, serial uint32) Answer {
+	t.Helper()
+	rdata, err := EncodeDomainName("a.root-servers.net.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rdata, err = AppendDomainName(rdata, "nstld.verisign-grs.com."); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []uint32{serial, 1800, 900, 604800, 86400} {
+		rdata = binary.BigEndian.AppendUint32(rdata, v)
+	}
+	soa := record(t, zone, TypeSOA, rdata)
+	soa.TTL = 86400
+	return soa
+}
+
+// rootRecords returns a small root zone: com. with glue and nowhere.
+// without
+func rootRecords(t *testing.T, serial uint32) []Answer {
+	soa := soaRecord(t, ".", serial)
+	soa.TTL = 3600
+	return []Answer{
+		soa,
+		nameRecord(t, ".", TypeNS, "a.root-servers.net."),
+		nameRecord(t, "com.", TypeNS, "a.gtld-servers.net."),
+		record(t, "a.gtld-servers.net.", TypeA, []byte{192, 0, 2, 30}),
+		nameRecord(t, "nowhere.", TypeNS, "ns.example.net."),
+		record(t, "a.root-servers.net.", TypeA, []byte{198, 41, 0, 4}),
+	}
+}
+
+func TestRootZone(t *testing.T) {
+	root := NewRootZone(RootZoneOptions{Mirror: true})
+	now := time.Now()
+	if _, ok := root.NoSuchTLD("www.junk.", now); ok {
+		t.Error("NoSuchTLD() answered before the zone was loaded")
+	}
+	if err := root.Load(rootRecords(t, 2026101600)[1:], now); err == nil {
+		t.Error("Load() accepted a zone without its SOA")
+	}
+	if err := root.Load(rootRecords(t, 2026101600), now); err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+
+	soa, ok := root.NoSuchTLD("www.Junk.", now)
+	if !ok || soa.TTL != 3600 {
+		t.Errorf("NoSuchTLD(www.Junk.) = %v, %v, want the SOA with the TTL of 3600", soa, ok)
+	}
+	for _, name := range []string{"www.example.COM.", "x.nowhere.", "."} {
+		if _, ok := root.NoSuchTLD(name, now); ok {
+			t.Errorf("NoSuchTLD(%s) answered NXDOMAIN", name)
+		}
+	}
+
+	if zone, address, ok := root.Referral("www.example.com.", now); !ok || zone != "com." || address != "192.0.2.30:53" {
+		t.Errorf("Referral(www.example.com.) = %s, %s, %v, want com. at 192.0.2.30:53", zone, address, ok)
+	}
+	if _, _, ok := root.Referral("x.nowhere.", now); ok {
+		t.Error("Referral() to a TLD without glue")
+	}
+
+	expired := now.Add(8 * 24 * time.Hour)
+	if _, ok := root.NoSuchTLD("www.junk.", expired); ok {
+		t.Error("NoSuchTLD() answered from an expired copy")
+	}
+	if stats := root.Stats(); stats.Serial != 2026101600 || stats.TLDs != 2 || stats.NXDomain != 1 || stats.Referrals != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestRootZoneUpdate(t *testing.T) {
+	source := transferServer(t, rootRecords(t, 2026101600), false)
+	root := NewRootZone(RootZoneOptions{Mirror: true, Sources: []string{"127.0.0.1:1", source}})
+	if wait := root.update(context.Background(), time.Now()); wait != 1800*time.Second {
+		t.Errorf("update() waits %v, want the SOA refresh", wait)
+	}
+	if stats := root.Stats(); stats.Serial != 2026101600 || stats.TLDs != 2 {
+		t.Errorf("Stats() after update = %+v", stats)
+	}
+}
+
+func TestHandlerRootZone(t *testing.T) {
+	root := NewRootZone(RootZoneOptions{Mirror: true})
+	if err := root.Load(rootRecords(t, 2026101600), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	upstream := Upstream{Address: replyingUpstream(t, func(b *Builder) {
+		b.Answer(record(t, "www.junk.", TypeA, []byte{192, 0, 2, 1}))
+	}), Timeout: time.Second}
+	tests := []struct {
+		name      string
+		upstreams []Upstream
+		rcode     uint16
+	}{
+		{"from the root", nil, RcodeNameError},
+		{"through upstreams", []Upstream{upstream}, RcodeSuccess},
+	}
+	for _, tt := range tests {
+		handler := &Handler{
+			Cache:     &RecordsCache{Records: make(map[string]Message)},
+			Upstreams: tt.upstreams,
+			RootZone:  root,
+		}
+		query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: "www.junk.", QType: TypeA, QClass: 1}}
+		query.Bytes = query.Encode()
+		res := Message{}
+		if _, err := res.Decode(handler.BuildResponse(context.Background(), query)); err != nil {
+			t.Fatal(err)
+		}
+		if res.Header.RCODE != tt.rcode {
+			t.Errorf("%s: rcode %s, want %s", tt.name, RcodeName(res.Header.RCODE), RcodeName(tt.rcode))
+		}
+		if tt.rcode == RcodeNameError && (len(res.Authority) != 1 || QType(res.Authority[0].Type) != TypeSOA) {
+			t.Errorf("%s: authority %v, want the SOA of the root", tt.name, res.Authority)
+		}
+	}
+}
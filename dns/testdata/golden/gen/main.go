@@ -0,0 +1,242 @@
+// Command gen writes the golden files of the dns package from messages
+// built and packed by miekg/dns, so that the fixtures come from another
+// implementation rather than from mercury. It is its own module to keep
+// miekg/dns out of mercury's dependencies; run it from this directory:
+//
+//	go run . [dir]
+//
+// It writes into dir, the parent directory by default.
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// fixture is a golden file: what it shows and the message it holds
+type fixture struct {
+	name     string
+	comment  string
+	compress bool
+	msg      func() *dns.Msg
+}
+
+var fixtures = []fixture{
+	{"query_edns", "dig example.com A +dnssec, recursion desired, authentic data and a client cookie", false, func() *dns.Msg {
+		m := message(0xb1f2, "example.com.", dns.TypeA, dns.ClassINET)
+		m.RecursionDesired = true
+		m.AuthenticatedData = true
+		o := opt(1232, true)
+		o.Option = append(o.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "a1b2c3d4e5f60718"})
+		m.Extra = append(m.Extra, o)
+		return m
+	}},
+	{"response_a", "A answer whose owner points at the question", true, func() *dns.Msg {
+		m := message(0xb1f2, "example.com.", dns.TypeA, dns.ClassINET)
+		m.Response, m.RecursionDesired, m.RecursionAvailable = true, true, true
+		m.Answer = rrs("example.com. 300 IN A 93.184.215.34")
+		return m
+	}},
+	{"response_mx", "MX answer whose exchanges point into the answer section, addresses in the additional section", true, func() *dns.Msg {
+		m := message(0x3e8a, "google.com.", dns.TypeMX, dns.ClassINET)
+		m.Response, m.RecursionDesired, m.RecursionAvailable = true, true, true
+		m.Answer = rrs(
+			"google.com. 3600 IN MX 10 smtp.google.com.",
+			"google.com. 3600 IN MX 20 alt1.smtp.google.com.",
+		)
+		m.Extra = rrs(
+			"smtp.google.com. 300 IN A 74.125.140.27",
+			"alt1.smtp.google.com. 300 IN AAAA 2a00:1450:4001:c00::1b",
+		)
+		return m
+	}},
+	{"referral", "referral from a root server, glue for both name servers", true, func() *dns.Msg {
+		m := message(0x9c1e, "www.example.net.", dns.TypeA, dns.ClassINET)
+		m.Response = true
+		m.Ns = rrs(
+			"net. 172800 IN NS a.gtld-servers.net.",
+			"net. 172800 IN NS b.gtld-servers.net.",
+		)
+		m.Extra = rrs(
+			"a.gtld-servers.net. 172800 IN A 192.5.6.30",
+			"b.gtld-servers.net. 172800 IN AAAA 2001:503:231d::2:30",
+		)
+		return m
+	}},
+	{"nxdomain", "authoritative NXDOMAIN with the SOA of the root for negative caching", true, func() *dns.Msg {
+		m := message(0x5190, "invalid.", dns.TypeA, dns.ClassINET)
+		m.Response, m.Authoritative, m.RecursionDesired, m.RecursionAvailable = true, true, true, true
+		m.Rcode = dns.RcodeNameError
+		m.Ns = rrs(". 86400 IN SOA a.root-servers.net. nstld.verisign-grs.com. 2024010100 1800 900 604800 86400")
+		return m
+	}},
+	{"records", "one record of every type mercury encodes, names uncompressed", false, func() *dns.Msg {
+		m := message(1, "example.com.", dns.TypeANY, dns.ClassINET)
+		m.Response, m.Authoritative = true, true
+		m.Answer = rrs(
+			"example.com. 300 IN A 192.0.2.1",
+			"example.com. 300 IN AAAA 2001:db8::1",
+			"example.com. 3600 IN NS ns1.example.com.",
+			`example.com. 3600 IN TXT "v=spf1 -all" "second string"`,
+			"example.com. 3600 IN MX 10 mail.example.com.",
+			"_sip._udp.example.com. 3600 IN SRV 10 60 5060 pbx.example.com.",
+			`example.com. 3600 IN NAPTR 100 10 "S" "SIP+D2U" "" _sip._udp.example.com.`,
+			"_443._tcp.www.example.com. 3600 IN TLSA 3 1 1 8F2A7C15E1",
+			`opaque.example.com. 60 IN TYPE65280 \# 4 0a000001`,
+			"www.example.com. 300 IN CNAME example.com.",
+			"1.2.0.192.in-addr.arpa. 300 IN PTR www.example.com.",
+		)
+		return m
+	}},
+	{"edns_options", "blocked name answered NXDOMAIN with NSID, client subnet, extended error and padding options", false, func() *dns.Msg {
+		m := message(0x0a0a, "ads.example.com.", dns.TypeA, dns.ClassINET)
+		m.Response, m.RecursionDesired, m.RecursionAvailable = true, true, true
+		m.Rcode = dns.RcodeNameError
+		o := opt(1232, false)
+		o.Option = append(o.Option,
+			&dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: hex.EncodeToString([]byte("ns1-ams"))},
+			&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: []byte{192, 0, 2, 0}},
+			&dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeBlocked, ExtraText: "blocklist"},
+			&dns.EDNS0_PADDING{Padding: make([]byte, 4)},
+		)
+		m.Extra = append(m.Extra, o)
+		return m
+	}},
+	{"flags", "truncated REFUSED reply to a CHAOS query with checking disabled, every remaining flag", false, func() *dns.Msg {
+		m := message(0xffff, "version.bind.", dns.TypeTXT, dns.ClassCHAOS)
+		m.Response, m.Truncated, m.CheckingDisabled = true, true, true
+		m.Rcode = dns.RcodeRefused
+		return m
+	}},
+}
+
+func main() {
+	dir := ".."
+	if len(os.Args) > 1 {
+		dir = os.Args[1]
+	}
+	for _, f := range fixtures {
+		m := f.msg()
+		m.Compress = f.compress
+		wire, err := m.Pack()
+		if err != nil {
+			log.Fatalf("%s: %v", f.name, err)
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "# %s\n", f.comment)
+		for s := hex.EncodeToString(wire); s != ""; {
+			n := min(len(s), 64)
+			b.WriteString(s[:n] + "\n")
+			s = s[n:]
+		}
+		b.WriteString("--\n")
+		present(&b, m)
+		if err := os.WriteFile(filepath.Join(dir, f.name+".txt"), []byte(b.String()), 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// message returns a message of one question
+func message(id uint16, name string, qtype, qclass uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.Id = id
+	m.Question = []dns.Question{{Name: name, Qtype: qtype, Qclass: qclass}}
+	return m
+}
+
+// opt returns an EDNS version 0 OPT record
+func opt(size uint16, do bool) *dns.OPT {
+	o := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	o.SetUDPSize(size)
+	if do {
+		o.SetDo()
+	}
+	return o
+}
+
+// rrs parses records in zone file format
+func rrs(lines ...string) []dns.RR {
+	var out []dns.RR
+	for _, line := range lines {
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			log.Fatalf("%q: %v", line, err)
+		}
+		out = append(out, rr)
+	}
+	return out
+}
+
+// present writes m in the dig-like layout of the golden files, the
+// records in the presentation format of miekg/dns
+func present(b *strings.Builder, m *dns.Msg) {
+	fmt.Fprintf(b, ";; opcode: %s, status: %s, id: %d\n", dns.OpcodeToString[m.Opcode], dns.RcodeToString[m.Rcode], m.Id)
+	b.WriteString(";; flags:")
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"qr", m.Response}, {"aa", m.Authoritative}, {"tc", m.Truncated}, {"rd", m.RecursionDesired},
+		{"ra", m.RecursionAvailable}, {"ad", m.AuthenticatedData}, {"cd", m.CheckingDisabled},
+	} {
+		if f.set {
+			b.WriteString(" " + f.name)
+		}
+	}
+	fmt.Fprintf(b, "; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n", len(m.Question), len(m.Answer), len(m.Ns), len(m.Extra))
+	q := m.Question[0]
+	fmt.Fprintf(b, ";; QUESTION SECTION:\n;%s\t%s\t%s\n", q.Name, dns.Class(q.Qclass), dns.Type(q.Qtype))
+	if o := m.IsEdns0(); o != nil {
+		flags := ""
+		if o.Do() {
+			flags = " do"
+		}
+		fmt.Fprintf(b, ";; OPT PSEUDOSECTION:\n; EDNS: version %d; flags:%s; udp: %d\n", o.Version(), flags, o.UDPSize())
+		for _, option := range o.Option {
+			switch option := option.(type) {
+			case *dns.EDNS0_NSID:
+				text, _ := hex.DecodeString(option.Nsid)
+				fmt.Fprintf(b, "; NSID: %s (%q)\n", option.Nsid, text)
+			case *dns.EDNS0_SUBNET:
+				fmt.Fprintf(b, "; ECS: %s\n", option)
+			case *dns.EDNS0_EDE:
+				fmt.Fprintf(b, "; EDE: %d (%s): %q\n", option.InfoCode, dns.ExtendedErrorCodeToString[option.InfoCode], option.ExtraText)
+			case *dns.EDNS0_PADDING:
+				fmt.Fprintf(b, "; PADDING: %d bytes\n", len(option.Padding))
+			case *dns.EDNS0_COOKIE:
+				fmt.Fprintf(b, "; COOKIE: %s\n", option.Cookie)
+			default:
+				log.Fatalf("no presentation for option %d", option.Option())
+			}
+		}
+	}
+	for _, section := range []struct {
+		name    string
+		records []dns.RR
+	}{{"ANSWER", m.Answer}, {"AUTHORITY", m.Ns}, {"ADDITIONAL", m.Extra}} {
+		header := false
+		for _, rr := range section.records {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if !header {
+				fmt.Fprintf(b, ";; %s SECTION:\n", section.name)
+				header = true
+			}
+			if rr, ok := rr.(*dns.RFC3597); ok {
+				// miekg/dns writes the class of an unknown type as CLASS1, dig writes IN
+				h := rr.Header()
+				fmt.Fprintf(b, "%s\t%d\t%s\t%s\t\\# %d %s\n", h.Name, h.Ttl, dns.Class(h.Class), dns.Type(h.Rrtype), len(rr.Rdata)/2, rr.Rdata)
+				continue
+			}
+			b.WriteString(rr.String() + "\n")
+		}
+	}
+}
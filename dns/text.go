@@ -0,0 +1,212 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Flags returns the names of the header flags set, in the order dig
+// prints them
+func (header *Header) Flags() []string {
+	flags := []string{}
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"qr", header.IsResponse()}, {"aa", header.Authoritative()}, {"tc", header.Truncated()},
+		{"rd", header.RecursionDesired()}, {"ra", header.RecursionAvailable()},
+		{"ad", header.AuthenticData()}, {"cd", header.CheckingDisabled()},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	return flags
+}
+
+// String returns the header the way dig prints it, on two lines
+func (header Header) String() string {
+	return fmt.Sprintf(";; ->>HEADER<<- opcode: %s, status: %s, id: %d\n;; flags: %s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d",
+		OpcodeName(header.Opcode), RcodeName(header.RCODE), header.ID, strings.Join(header.Flags(), " "),
+		header.QDCount, header.ANCount, header.NSCount, header.ARCount)
+}
+
+// headerJSON is the JSON form of a header
+type headerJSON struct {
+	ID      uint16   `json:"id"`
+	Opcode  string   `json:"opcode"`
+	Rcode   string   `json:"rcode"`
+	Flags   []string `json:"flags"`
+	QDCount uint16   `json:"qdcount"`
+	ANCount uint16   `json:"ancount"`
+	NSCount uint16   `json:"nscount"`
+	ARCount uint16   `json:"arcount"`
+}
+
+// MarshalJSON encodes the header with mnemonic opcode and rcode and the
+// flags set listed by name
+func (header Header) MarshalJSON() ([]byte, error) {
+	return json.Marshal(headerJSON{
+		ID:      header.ID,
+		Opcode:  OpcodeName(header.Opcode),
+		Rcode:   RcodeName(header.RCODE),
+		Flags:   header.Flags(),
+		QDCount: header.QDCount,
+		ANCount: header.ANCount,
+		NSCount: header.NSCount,
+		ARCount: header.ARCount,
+	})
+}
+
+// String returns the question in zone file order, name class type
+func (q Question) String() string {
+	return fmt.Sprintf("%s\t%s\t%s", q.DomainName, ClassName(q.QClass), q.QType)
+}
+
+// questionJSON is the JSON form of a question
+type questionJSON struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+}
+
+func (q Question) MarshalJSON() ([]byte, error) {
+	return json.Marshal(questionJSON{Name: q.DomainName, Type: q.QType.String(), Class: ClassName(q.QClass)})
+}
+
+// String returns the record in zone file format. Compressed names cannot
+// be followed without the packet, Message.String renders them against the
+// bytes it was decoded from.
+func (answer Answer) String() string {
+	return answer.text(nil)
+}
+
+// text renders the record decoded from packet in zone file format, or the
+// OPT pseudo record the way dig prints its EDNS line
+func (answer *Answer) text(packet []byte) string {
+	if QType(answer.Type) == TypeOPT {
+		flags := ""
+		if answer.TTL&ednsDO != 0 {
+			flags = " do"
+		}
+		return fmt.Sprintf("; EDNS: version: %d, flags:%s; udp: %d", answer.TTL>>16&0xFF, flags, answer.Class)
+	}
+	r := answer.record(packet)
+	return fmt.Sprintf("%s\t%d\t%s\t%s\t%s", r.Name, r.TTL, r.Class, r.Type, r.Data)
+}
+
+// answerJSON is the JSON form of a record, with the RDATA in presentation
+// format
+type answerJSON struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+	TTL   uint32 `json:"ttl"`
+	Data  string `json:"data"`
+}
+
+// record returns the JSON form of the record decoded from packet
+func (answer *Answer) record(packet []byte) answerJSON {
+	name, err := answer.OwnerName(packet)
+	if err != nil {
+		name = "<invalid>"
+	}
+	return answerJSON{
+		Name:  name,
+		Type:  QType(answer.Type).String(),
+		Class: ClassName(answer.Class),
+		TTL:   answer.TTL,
+		Data:  answer.Data(packet),
+	}
+}
+
+func (answer Answer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(answer.record(nil))
+}
+
+// ednsJSON is the JSON form of the OPT pseudo record of a message
+type ednsJSON struct {
+	Version uint8    `json:"version"`
+	UDPSize uint16   `json:"udp_size"`
+	DNSSEC  bool     `json:"dnssec_ok"`
+	Options []string `json:"options,omitempty"`
+}
+
+// messageJSON is the JSON form of a message. The OPT record is left out of
+// the additional section and described by EDNS.
+type messageJSON struct {
+	Header     Header       `json:"header"`
+	Question   Question     `json:"question"`
+	Answer     []answerJSON `json:"answer"`
+	Authority  []answerJSON `json:"authority"`
+	Additional []answerJSON `json:"additional"`
+	EDNS       *ednsJSON    `json:"edns,omitempty"`
+}
+
+// records returns the JSON form of the records of a section other than
+// OPT, decoded from packet
+func records(section []Answer, packet []byte) []answerJSON {
+	recs := []answerJSON{}
+	for i := range section {
+		if QType(section[i].Type) != TypeOPT {
+			recs = append(recs, section[i].record(packet))
+		}
+	}
+	return recs
+}
+
+func (msg Message) MarshalJSON() ([]byte, error) {
+	v := messageJSON{
+		Header:     msg.Header,
+		Question:   msg.Question,
+		Answer:     records(msg.Answers, msg.Bytes),
+		Authority:  records(msg.Authority, msg.Bytes),
+		Additional: records(msg.Additional, msg.Bytes),
+	}
+	if opt, ok := msg.opt(); ok {
+		v.EDNS = &ednsJSON{Version: uint8(opt.TTL >> 16), UDPSize: opt.Class, DNSSEC: opt.TTL&ednsDO != 0}
+		for _, o := range msg.Options {
+			v.EDNS.Options = append(v.EDNS.Options, o.String())
+		}
+	}
+	return json.Marshal(v)
+}
+
+// String returns the message the way dig prints it: the header, the EDNS
+// pseudo section and the question and record sections. Names are decoded
+// against Bytes when the message was decoded from it.
+func (msg Message) String() string {
+	var s strings.Builder
+	s.WriteString(msg.Header.String())
+	s.WriteString("\n")
+	if opt, ok := msg.opt(); ok {
+		s.WriteString("\n;; OPT PSEUDOSECTION:\n")
+		s.WriteString(opt.text(msg.Bytes))
+		s.WriteString("\n")
+		for _, o := range msg.Options {
+			fmt.Fprintf(&s, "; %s\n", o)
+		}
+	}
+	if msg.Question.DomainName != "" {
+		fmt.Fprintf(&s, "\n;; QUESTION SECTION:\n;%s\n", msg.Question)
+	}
+	for _, section := range []struct {
+		name    string
+		records []Answer
+	}{{"ANSWER", msg.Answers}, {"AUTHORITY", msg.Authority}, {"ADDITIONAL", msg.Additional}} {
+		header := false
+		for i := range section.records {
+			if QType(section.records[i].Type) == TypeOPT {
+				continue
+			}
+			if !header {
+				fmt.Fprintf(&s, "\n;; %s SECTION:\n", section.name)
+				header = true
+			}
+			s.WriteString(section.records[i].text(msg.Bytes))
+			s.WriteString("\n")
+		}
+	}
+	return s.String()
+}
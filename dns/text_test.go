@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestHeaderString(t *testing.T) {
+	h := Header{ID: 4660, QR: 1, RD: 1, RA: 1, Z: zAD, RCODE: RcodeNameError, QDCount: 1, NSCount: 1}
+	want := ";; ->>HEADER<<- opcode: QUERY, status: NXDOMAIN, id: 4660\n" +
+		";; flags: qr rd ra ad; QUERY: 1, ANSWER: 0, AUTHORITY: 1, ADDITIONAL: 0"
+	if got := h.String(); got != want {
+		t.Errorf("String() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMessageString(t *testing.T) {
+	query := Message{
+		Header:   Header{ID: 1, RD: 1},
+		Question: Question{DomainName: "example.com.", QType: TypeMX, QClass: 1},
+	}
+	data := NewResponse(&query).Answer(Answer{
+		Name: []byte{0xC0, headerSize}, Type: uint16(TypeMX), Class: 1, TTL: 300,
+		RDLength: 4, RData: []byte{0, 10, 0xC0, headerSize},
+	}).Additional(NewOPT(DefaultUDPSize, true)).Encode()
+	var msg Message
+	if _, err := msg.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	msg.Bytes = data
+
+	got := msg.String()
+	for _, want := range []string{
+		";; flags: qr rd; QUERY: 1, ANSWER: 1, AUTHORITY: 0, ADDITIONAL: 1",
+		";; OPT PSEUDOSECTION:\n; EDNS: version: 0, flags: do; udp: 1232\n",
+		";; QUESTION SECTION:\n;example.com.\tIN\tMX\n",
+		";; ANSWER SECTION:\nexample.com.\t300\tIN\tMX\t10 example.com.\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("String() lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "ADDITIONAL SECTION") {
+		t.Errorf("OPT printed as a record:\n%s", got)
+	}
+}
+
+func TestMessageJSON(t *testing.T) {
+	msg := Message{
+		Header:   Header{ID: 7, QR: 1, AA: 1, QDCount: 1, ANCount: 1},
+		Question: Question{DomainName: "example.com.", QType: TypeA, QClass: 1},
+		Answers: []Answer{{
+			Name: []byte("\x07example\x03com\x00"), Type: uint16(TypeA), Class: 1, TTL: 60,
+			RDLength: 4, RData: []byte{192, 0, 2, 1},
+		}},
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"header":{"id":7,"opcode":"QUERY","rcode":"NOERROR","flags":["qr","aa"],"qdcount":1,"ancount":1,"nscount":0,"arcount":0},` +
+		`"question":{"name":"example.com.","type":"A","class":"IN"},` +
+		`"answer":[{"name":"example.com.","type":"A","class":"IN","ttl":60,"data":"192.0.2.1"}],"authority":[],"additional":[]}`
+	if string(data) != want {
+		t.Errorf("json =\n%s\nwant\n%s", data, want)
+	}
+}
@@ -0,0 +1,59 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Step is a decision the handler took answering a query
+type Step struct {
+	// Stage is the part of the handler deciding, like blocklist or cache
+	Stage  string
+	Detail string
+}
+
+func (s Step) String() string {
+	return s.Stage + ": " + s.Detail
+}
+
+// Trace records the steps of the queries answered under its context, to
+// explain why a name resolved the way it did
+type Trace struct {
+	mu    sync.Mutex
+	steps []Step
+}
+
+// Steps returns the steps recorded so far, in order
+func (t *Trace) Steps() []Step {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Step(nil), t.steps...)
+}
+
+func (t *Trace) add(stage, detail string) {
+	t.mu.Lock()
+	t.steps = append(t.steps, Step{Stage: stage, Detail: detail})
+	t.mu.Unlock()
+}
+
+type traceKey struct{}
+
+// WithTrace records the decisions the handler takes answering the query
+// under ctx in t
+func WithTrace(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// traceOf returns the trace of ctx, nil when the query is not traced
+func traceOf(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// trace adds a step to the trace of ctx, if any
+func trace(ctx context.Context, stage, format string, args ...any) {
+	if t := traceOf(ctx); t != nil {
+		t.add(stage, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,64 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// TraceSuffix is the domain names are traced under when the handler has
+// DebugTrace: a TXT query for www.example.com.trace.mercury. is answered
+// with the steps resolving www.example.com. took
+const TraceSuffix = "trace.mercury."
+
+// traceName returns the name and type a query under TraceSuffix traces, A
+// unless a first label like _aaaa names another type. It reports false for
+// names outside TraceSuffix.
+func traceName(name string) (string, QType, bool) {
+	lower := strings.ToLower(name)
+	if !strings.HasSuffix(lower, "."+TraceSuffix) {
+		return "", 0, false
+	}
+	traced := name[:len(name)-len(TraceSuffix)]
+	qtype := TypeA
+	if label, rest, ok := strings.Cut(traced, "."); ok && strings.HasPrefix(label, "_") && rest != "" {
+		if t, err := ParseQType(label[1:]); err == nil {
+			traced, qtype = rest, t
+		}
+	}
+	return traced, qtype, true
+}
+
+// appendTrace resolves the name msg traces and answers the steps taken,
+// one TXT record each, ending with the response code and answers of the
+// traced query
+func (h *Handler) appendTrace(ctx context.Context, buf []byte, msg *Message, name string, qtype QType) []byte {
+	res := NewResponse(msg).RecursionAvailable(!h.NoRecursion).Authoritative(true)
+	owner, err := EncodeDomainName(msg.Question.DomainName)
+	if err != nil {
+		return res.SetRcode(RcodeFormatError).AppendEncode(buf)
+	}
+	if msg.Question.QType == TypeTXT || msg.Question.QType == TypeANY {
+		query := &Message{Header: Header{ID: msg.Header.ID, RD: msg.Header.RD, QDCount: 1}, Question: Question{DomainName: name, QType: qtype, QClass: 1}}
+		query.Bytes = query.Encode()
+		t := &Trace{}
+		// the traced response is read whole, not truncated for UDP
+		out := h.appendQuery(WithTrace(context.WithValue(ctx, udpKey{}, nil), t), nil, query)
+		result := &Message{}
+		steps := t.Steps()
+		if _, err := result.Decode(out); err != nil {
+			steps = append(steps, Step{Stage: "result", Detail: "no response"})
+		} else {
+			steps = append(steps, Step{Stage: "result", Detail: fmt.Sprintf("%s %s: %s, %d answers", name, qtype, RcodeName(result.Header.RCODE), len(result.Answers))})
+		}
+		for _, step := range steps {
+			txt := encodeTXT(step.String())
+			res.Answer(Answer{Name: owner, Type: uint16(TypeTXT), Class: 1, RDLength: uint16(len(txt)), RData: txt})
+		}
+	}
+	out := res.AppendEncode(buf)
+	if ctx.Value(udpKey{}) != nil && len(out)-len(buf) > msg.UDPSize() {
+		out = res.Truncate().AppendEncode(buf)
+	}
+	return out
+}
@@ -0,0 +1,94 @@
+package dns
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceName(t *testing.T) {
+	tests := []struct {
+		name   string
+		traced string
+		qtype  QType
+		ok     bool
+	}{
+		{"www.example.com.trace.mercury.", "www.example.com.", TypeA, true},
+		{"WWW.example.com.Trace.Mercury.", "WWW.example.com.", TypeA, true},
+		{"_aaaa.example.com.trace.mercury.", "example.com.", TypeAAAA, true},
+		{"_acme-challenge.example.com.trace.mercury.", "_acme-challenge.example.com.", TypeA, true},
+		{"trace.mercury.", "", 0, false},
+		{"example.com.", "", 0, false},
+		{"example.notrace.mercury.", "", 0, false},
+	}
+	for _, tt := range tests {
+		traced, qtype, ok := traceName(tt.name)
+		if traced != tt.traced || qtype != tt.qtype || ok != tt.ok {
+			t.Errorf("traceName(%s) = %s, %s, %v, want %s, %s, %v", tt.name, traced, qtype, ok, tt.traced, tt.qtype, tt.ok)
+		}
+	}
+}
+
+func TestHandlerDebugTrace(t *testing.T) {
+	zone := testZone()
+	handler := &Handler{
+		Zones:      map[string]Zone{zone.Origin: zone},
+		Cache:      &RecordsCache{Records: make(map[string]Message)},
+		Upstreams:  []Upstream{{Address: staticUpstream(t, TypeA, 60, []byte{192, 0, 2, 7}), Timeout: time.Second}},
+		DebugTrace: true,
+	}
+	steps := func(name string, qtype QType) (Message, []string) {
+		t.Helper()
+		query := &Message{Header: Header{ID: 1, RD: 1, QDCount: 1}, Question: Question{DomainName: name, QType: qtype, QClass: 1}}
+		query.Bytes = query.Encode()
+		data := handler.BuildResponse(context.Background(), query)
+		res := Message{}
+		if _, err := res.Decode(data); err != nil {
+			t.Fatal(err)
+		}
+		var txts []string
+		for _, a := range res.Answers {
+			if QType(a.Type) != TypeTXT {
+				t.Fatalf("%s answered %s records", name, QType(a.Type))
+			}
+			txts = append(txts, a.Data(data))
+		}
+		return res, txts
+	}
+	contains := func(txts []string, want string) bool {
+		for _, txt := range txts {
+			if strings.Contains(txt, want) {
+				return true
+			}
+		}
+		return false
+	}
+
+	res, txts := steps("mail.example.com.trace.mercury.", TypeTXT)
+	if res.Header.RCODE != RcodeSuccess || res.Header.AA != 1 || !contains(txts, "zone: in zone example.com.") || !contains(txts, "result: mail.example.com. A: NOERROR, 1 answers") {
+		t.Errorf("zone name traced as %v", txts)
+	}
+	_, txts = steps("_aaaa.mail.example.com.trace.mercury.", TypeTXT)
+	if !contains(txts, "mail.example.com. AAAA: NOERROR, 1 answers") {
+		t.Errorf("AAAA traced as %v", txts)
+	}
+	// the first lookup misses the cache, the second hits it
+	_, txts = steps("www.test.trace.mercury.", TypeTXT)
+	if !contains(txts, "cache: miss") || !contains(txts, "forward: resolved by") {
+		t.Errorf("forwarded name traced as %v", txts)
+	}
+	_, txts = steps("www.test.trace.mercury.", TypeTXT)
+	if !contains(txts, "cache: hit") {
+		t.Errorf("cached name traced as %v", txts)
+	}
+	if res, txts := steps("www.test.trace.mercury.", TypeA); res.Header.RCODE != RcodeSuccess || len(txts) != 0 {
+		t.Errorf("A query for a trace name answered %s %v, want no data", RcodeName(res.Header.RCODE), txts)
+	}
+
+	// without DebugTrace the name is resolved like any other
+	handler.DebugTrace = false
+	if _, txts := steps("mail.example.com.trace.mercury.", TypeTXT); contains(txts, "zone:") {
+		t.Errorf("traced without DebugTrace: %v", txts)
+	}
+}
@@ -0,0 +1,136 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/bernoussama/mercury/fqdn"
+)
+
+// MaxTransferRecords caps the records of a zone transfer, the root zone
+// holds about 22000
+const MaxTransferRecords = 1 << 20
+
+// ErrTransfer is a zone transfer the server refused or broke off
+var ErrTransfer = errors.New("zone transfer failed")
+
+// Transfer asks the server at address for zone over TCP, RFC 5936, and
+// returns its records from the SOA opening the transfer to the last
+// before the SOA closing it. Names are decompressed, so the records stand
+// without the messages they came in.
+func Transfer(ctx context.Context, address, zone string) ([]Answer, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	query := &Message{
+		Header:   Header{ID: uint16(rand.Uint32()), QDCount: 1},
+		Question: Question{DomainName: zone, QType: TypeAXFR, QClass: 1},
+	}
+	data := query.Encode()
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(data))), data...)); err != nil {
+		return nil, err
+	}
+
+	origin := fqdn.Canonical(zone)
+	var records []Answer
+	var length [2]byte
+	for {
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("%w: %v", ErrTransfer, err)
+		}
+		packet := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, packet); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTransfer, err)
+		}
+		msg := Message{}
+		if _, err := msg.Decode(packet); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTransfer, err)
+		}
+		switch {
+		case msg.Header.ID != query.Header.ID:
+			return nil, fmt.Errorf("%w: reply to another query", ErrTransfer)
+		case msg.Header.RCODE != RcodeSuccess:
+			return nil, fmt.Errorf("%w: %s", ErrTransfer, RcodeName(msg.Header.RCODE))
+		}
+		for i := range msg.Answers {
+			record, err := expand(packet, &msg.Answers[i])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrTransfer, err)
+			}
+			owner, _ := record.OwnerName(nil)
+			isSOA := QType(record.Type) == TypeSOA && fqdn.Canonical(owner) == origin
+			switch {
+			case len(records) == 0 && !isSOA:
+				return nil, fmt.Errorf("%w: does not start with the SOA of %s", ErrTransfer, origin)
+			case len(records) > 0 && isSOA:
+				return records, nil
+			case len(records) == MaxTransferRecords:
+				return nil, fmt.Errorf("%w: more than %d records", ErrTransfer, MaxTransferRecords)
+			}
+			records = append(records, record)
+		}
+	}
+}
+
+// expand returns the record decoded from packet with its owner and the
+// names in the RDATA of the types compressing them decompressed
+func expand(packet []byte, answer *Answer) (Answer, error) {
+	owner, err := answer.OwnerName(packet)
+	if err != nil {
+		return Answer{}, err
+	}
+	record := *answer
+	if record.Name, err = EncodeDomainName(owner); err != nil {
+		return Answer{}, err
+	}
+	// the fixed fields before the names of the RDATA, the names and the
+	// fixed fields after them
+	var prefix, names, fixed int
+	switch QType(answer.Type) {
+	case TypeNS, TypeCNAME, TypePTR:
+		names = 1
+	case TypeMX:
+		prefix, names = 2, 1
+	case TypeSOA:
+		names, fixed = 2, 20
+	}
+	offset, ok := offsetIn(packet, answer.RData)
+	if names == 0 || !ok || len(answer.RData) < prefix {
+		record.RData = append([]byte{}, answer.RData...)
+		return record, nil
+	}
+	rdata := append([]byte{}, answer.RData[:prefix]...)
+	offset += prefix
+	for range names {
+		var name string
+		if name, offset, err = DecodeName(packet, offset); err != nil {
+			return Answer{}, err
+		}
+		if rdata, err = AppendDomainName(rdata, name); err != nil {
+			return Answer{}, err
+		}
+	}
+	if offset+fixed > len(packet) {
+		return Answer{}, errTruncated
+	}
+	rdata = append(rdata, packet[offset:offset+fixed]...)
+	record.RData, record.RDLength = rdata, uint16(len(rdata))
+	return record, nil
+}
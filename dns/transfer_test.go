@@ -0,0 +1,91 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// transferServer serves the records in messages of two records each to
+// the AXFR queries it receives over TCP, refusing them with refuse
+func transferServer(t *testing.T, records []Answer, refuse bool) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				conn.Close()
+				continue
+			}
+			data := make([]byte, binary.BigEndian.Uint16(length[:]))
+			io.ReadFull(conn, data)
+			query := &Message{}
+			query.Decode(data)
+			var replies [][]byte
+			if refuse {
+				replies = append(replies, NewResponse(query).SetRcode(RcodeRefused).Encode())
+			} else {
+				all := append(append([]Answer{}, records...), records[0])
+				for i := 0; i < len(all); i += 2 {
+					replies = append(replies, NewResponse(query).Answer(all[i:min(i+2, len(all))]...).Encode())
+				}
+			}
+			for _, reply := range replies {
+				conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...))
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestTransfer(t *testing.T) {
+	records := rootRecords(t, 2026101600)
+	got, err := Transfer(context.Background(), transferServer(t, records, false), ".")
+	if err != nil {
+		t.Fatalf("Transfer() = %v", err)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("Transfer() returned %d records, want %d", len(got), len(records))
+	}
+	for i := range got {
+		if got[i].String() != records[i].String() {
+			t.Errorf("record %d = %s, want %s", i, got[i], records[i])
+		}
+	}
+
+	if _, err := Transfer(context.Background(), transferServer(t, records, true), "."); !errors.Is(err, ErrTransfer) {
+		t.Errorf("refused Transfer() = %v, want ErrTransfer", err)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	// example.com. NS ns1.example.com., with the owner and the end of the
+	// name server compressed against the question
+	packet := (&Header{ID: 1, QR: 1, QDCount: 1, ANCount: 1}).Encode()
+	packet = append(packet, (&Question{DomainName: "example.com.", QType: TypeNS, QClass: 1}).Encode()...)
+	packet = append(packet, 0xC0, 12, 0, byte(TypeNS), 0, 1, 0, 0, 0, 60, 0, 6, 3, 'n', 's', '1', 0xC0, 12)
+	msg := Message{}
+	if _, err := msg.Decode(packet); err != nil {
+		t.Fatal(err)
+	}
+	record, err := expand(packet, &msg.Answers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := record.String(), "example.com.\t60\tIN\tNS\tns1.example.com."; got != want {
+		t.Errorf("expand() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bernoussama/mercury/fqdn"
+)
+
+// MaxTTL is the largest TTL allowed, RFC 2181 section 8
+const MaxTTL = 1<<31 - 1
+
+// TTLOverride forces the TTL of the forwarded answers for the names of a
+// domain, whatever the upstreams say, so the addresses of a service
+// failing over are asked for again soon
+type TTLOverride struct {
+	// Name is a domain, covering it and the names below it, or a wildcard
+	// like *.cdn.example.com covering only the names below
+	Name string `yaml:"name"`
+	TTL  uint32 `yaml:"ttl"`
+}
+
+// Validate reports problems with the override
+func (o TTLOverride) Validate() error {
+	name := strings.TrimPrefix(o.Name, "*.")
+	if name == "" || strings.Contains(name, "*") {
+		return fmt.Errorf("invalid name %q", o.Name)
+	}
+	if _, err := EncodeDomainName(name); err != nil {
+		return fmt.Errorf("invalid name %q: %v", o.Name, err)
+	}
+	if o.TTL > MaxTTL {
+		return fmt.Errorf("ttl %d of %s exceeds %d", o.TTL, o.Name, MaxTTL)
+	}
+	return nil
+}
+
+// TTLOverrides finds the TTL override of a name. The override of the
+// longest matching domain wins. A nil TTLOverrides overrides nothing.
+type TTLOverrides struct {
+	// domains cover their name and the names below, wildcards only the
+	// names below
+	domains   map[fqdn.Name]uint32
+	wildcards map[fqdn.Name]uint32
+}
+
+// NewTTLOverrides returns the matcher of overrides, nil when there are
+// none
+func NewTTLOverrides(overrides []TTLOverride) *TTLOverrides {
+	if len(overrides) == 0 {
+		return nil
+	}
+	o := &TTLOverrides{domains: make(map[fqdn.Name]uint32), wildcards: make(map[fqdn.Name]uint32)}
+	for _, override := range overrides {
+		if name, ok := strings.CutPrefix(override.Name, "*."); ok {
+			o.wildcards[fqdn.Canonical(name)] = override.TTL
+		} else {
+			o.domains[fqdn.Canonical(override.Name)] = override.TTL
+		}
+	}
+	return o
+}
+
+// TTL returns the TTL forced for name, false when no override covers it
+func (o *TTLOverrides) TTL(name string) (uint32, bool) {
+	if o == nil {
+		return 0, false
+	}
+	n := fqdn.Canonical(name)
+	for cur := n; ; cur = cur.Parent() {
+		if cur != n {
+			if ttl, ok := o.wildcards[cur]; ok {
+				return ttl, true
+			}
+		}
+		if ttl, ok := o.domains[cur]; ok {
+			return ttl, true
+		}
+		if cur == fqdn.Root {
+			return 0, false
+		}
+	}
+}
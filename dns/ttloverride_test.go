@@ -0,0 +1,54 @@
+package dns
+
+import "testing"
+
+func TestTTLOverrides(t *testing.T) {
+	overrides := NewTTLOverrides([]TTLOverride{
+		{Name: "example.com", TTL: 300},
+		{Name: "*.cdn.example.com", TTL: 30},
+		{Name: "static.cdn.example.com.", TTL: 60},
+	})
+	tests := []struct {
+		name string
+		ttl  uint32
+		ok   bool
+	}{
+		{"example.com.", 300, true},
+		{"www.example.com.", 300, true},
+		{"cdn.example.com.", 300, true},
+		{"img.cdn.example.com.", 30, true},
+		{"a.b.cdn.example.com.", 30, true},
+		{"static.cdn.example.com.", 60, true},
+		{"img.static.cdn.example.com.", 60, true},
+		{"example.org.", 0, false},
+		{"notexample.com.", 0, false},
+	}
+	for _, tt := range tests {
+		if ttl, ok := overrides.TTL(tt.name); ttl != tt.ttl || ok != tt.ok {
+			t.Errorf("TTL(%s) = %d, %v, want %d, %v", tt.name, ttl, ok, tt.ttl, tt.ok)
+		}
+	}
+	var none *TTLOverrides
+	if _, ok := none.TTL("example.com."); ok {
+		t.Error("nil overrides override")
+	}
+}
+
+func TestTTLOverrideValidate(t *testing.T) {
+	tests := []struct {
+		override TTLOverride
+		ok       bool
+	}{
+		{TTLOverride{Name: "*.cdn.example.com", TTL: 30}, true},
+		{TTLOverride{Name: "example.com.", TTL: 0}, true},
+		{TTLOverride{Name: "", TTL: 30}, false},
+		{TTLOverride{Name: "*", TTL: 30}, false},
+		{TTLOverride{Name: "a.*.example.com", TTL: 30}, false},
+		{TTLOverride{Name: "example.com", TTL: 1 << 31}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.override.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) = %v, want ok %v", tt.override, err, tt.ok)
+		}
+	}
+}
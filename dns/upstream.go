@@ -0,0 +1,106 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Upstream is a name server queries are sent to, with its retry policy
+type Upstream struct {
+	Address string `yaml:"address"`
+	// Timeout bounds a single attempt
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is the number of attempts made after the first one fails,
+	// DefaultUpstreamRetries when left out of the configuration
+	Retries int `yaml:"retries"`
+	// Backoff is the wait before the first retry, doubled for each next one
+	Backoff time.Duration `yaml:"backoff"`
+}
+
+// default retry policy for upstreams that leave it unset
+const (
+	DefaultUpstreamTimeout = 2 * time.Second
+	DefaultUpstreamRetries = 2
+	DefaultUpstreamBackoff = 100 * time.Millisecond
+)
+
+// UnmarshalYAML fills in DefaultUpstreamRetries when the retries are left
+// out, an explicit 0 sending each query once
+func (u *Upstream) UnmarshalYAML(unmarshal func(any) error) error {
+	type upstream Upstream
+	decoded := upstream{Retries: DefaultUpstreamRetries}
+	if err := unmarshal(&decoded); err != nil {
+		return err
+	}
+	*u = Upstream(decoded)
+	return nil
+}
+
+// RootServer is a.root-servers.net, where recursion starts by default
+var RootServer = Upstream{
+	Address: "198.41.0.4:53",
+	Timeout: DefaultUpstreamTimeout,
+	Retries: DefaultUpstreamRetries,
+	Backoff: DefaultUpstreamBackoff,
+}
+
+// ErrBudgetExhausted is returned once a query has sent as many upstream
+// queries as its budget allows
+var ErrBudgetExhausted = errors.New("upstream query budget exhausted")
+
+type budgetKey struct{}
+
+// WithQueryBudget limits the upstream queries sent on behalf of ctx to n
+func WithQueryBudget(ctx context.Context, n int) context.Context {
+	budget := &atomic.Int64{}
+	budget.Store(int64(n))
+	return context.WithValue(ctx, budgetKey{}, budget)
+}
+
+// spend takes one query from the budget of ctx, if it has one
+func spend(ctx context.Context) bool {
+	budget, ok := ctx.Value(budgetKey{}).(*atomic.Int64)
+	if !ok {
+		return true
+	}
+	return budget.Add(-1) >= 0
+}
+
+// Exchange sends the query in data to the upstream and returns the reply,
+// retrying with exponential backoff when an attempt fails or times out.
+func (u Upstream) Exchange(ctx context.Context, data []byte) ([]byte, error) {
+	timeout, backoff := u.Timeout, u.Backoff
+	if timeout <= 0 {
+		timeout = DefaultUpstreamTimeout
+	}
+	if backoff <= 0 {
+		backoff = DefaultUpstreamBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		if !spend(ctx) {
+			return nil, ErrBudgetExhausted
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		res, err := exchange(attemptCtx, data, u.Address)
+		cancel()
+		if err == nil {
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if attempt >= u.Retries {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,118 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// lossyUpstream answers queries with the query itself after dropping the
+// first drop packets. It returns its address and the packets received.
+func lossyUpstream(t *testing.T, drop int32) (string, *atomic.Int32) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	var received atomic.Int32
+	go func() {
+		buf := make([]byte, BUFFER_SIZE)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if received.Add(1) > drop {
+				conn.WriteToUDP(buf[:n], addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), &received
+}
+
+func TestUpstreamExchangeRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		drop     int32
+		retries  int
+		wantErr  bool
+		wantSent int32
+	}{
+		{name: "first attempt answers", drop: 0, retries: 2, wantSent: 1},
+		{name: "recovers after loss", drop: 2, retries: 2, wantSent: 3},
+		{name: "gives up after retries", drop: 3, retries: 2, wantErr: true, wantSent: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, received := lossyUpstream(t, tt.drop)
+			upstream := Upstream{Address: address, Timeout: 20 * time.Millisecond, Retries: tt.retries, Backoff: time.Millisecond}
+			_, err := upstream.Exchange(context.Background(), testResponse().Encode())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Exchange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := received.Load(); got != tt.wantSent {
+				t.Errorf("Exchange() sent %d queries, want %d", got, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestUpstreamExchangeBudget(t *testing.T) {
+	address, received := lossyUpstream(t, 10)
+	upstream := Upstream{Address: address, Timeout: 20 * time.Millisecond, Retries: 5, Backoff: time.Millisecond}
+	ctx := WithQueryBudget(context.Background(), 2)
+	_, err := upstream.Exchange(ctx, testResponse().Encode())
+	if !errors.Is(err, ErrBudgetExhausted) {
+		t.Errorf("Exchange() error = %v, want %v", err, ErrBudgetExhausted)
+	}
+	if got := received.Load(); got != 2 {
+		t.Errorf("Exchange() sent %d queries, want the budget of 2", got)
+	}
+}
+
+func TestUpstreamExchangeIPv6(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, BUFFER_SIZE)
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err == nil {
+			conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	u := Upstream{Address: conn.LocalAddr().String(), Timeout: time.Second}
+	res, err := u.Exchange(context.Background(), []byte("ping"))
+	if err != nil || string(res) != "ping" {
+		t.Errorf("Exchange(%s) = %q, %v", u.Address, res, err)
+	}
+}
+
+func TestGlueAddress(t *testing.T) {
+	a := Answer{Type: uint16(TypeA), RData: []byte{192, 0, 2, 1}}
+	aaaa := Answer{Type: uint16(TypeAAAA), RData: net.ParseIP("2001:db8::1")}
+	tests := []struct {
+		name       string
+		additional []Answer
+		want       string
+	}{
+		{"ipv4", []Answer{a}, "192.0.2.1:53"},
+		{"ipv6 only", []Answer{aaaa}, "[2001:db8::1]:53"},
+		{"prefers ipv4", []Answer{aaaa, a}, "192.0.2.1:53"},
+		{"bad length", []Answer{{Type: uint16(TypeA), RData: []byte{1, 2}}, aaaa}, "[2001:db8::1]:53"},
+		{"no glue", []Answer{NewOPT(DefaultUDPSize, false)}, ""},
+	}
+	for _, tt := range tests {
+		if got := glueAddress(tt.additional); got != tt.want {
+			t.Errorf("%s: glueAddress() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,51 +1,121 @@
 package dns
 
 import (
-	"bytes"
 	"errors"
 	"strings"
+
+	"github.com/bernoussama/mercury/fqdn"
 )
 
 type DomainName string
 
+var (
+	errLabelTooLong = errors.New("label exceeds maximum length of 63 octets")
+	errEmptyLabel   = errors.New("empty label")
+	errNameTooLong  = errors.New("name exceeds maximum length of 255 octets")
+)
+
 // encode domain name to dns wire format
 func EncodeDomainName(dn string) ([]byte, error) {
+	buf, err := AppendDomainName(make([]byte, 0, len(dn)+2), dn)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// AppendDomainName appends dn in dns wire format to buf.
+// On error buf is returned unchanged.
+func AppendDomainName(buf []byte, dn string) ([]byte, error) {
 	if dn == "" || dn == "." {
-		return []byte{0}, nil
+		return append(buf, 0), nil
 	}
+	start := len(buf)
 	dn = strings.TrimSuffix(dn, ".")
-	var buf bytes.Buffer
-	buf.Grow(len(dn) + 2)
-	parts := strings.Split(dn, ".")
-	for _, part := range parts {
-		if len(part) > 63 {
-			return nil, errors.New("label exceeds maximum length of 63 octets")
+	for {
+		label, rest, more := strings.Cut(dn, ".")
+		if len(label) > 63 {
+			return buf[:start], errLabelTooLong
 		}
-
-		buf.WriteByte(byte(len(part)))
-		buf.WriteString(part)
+		if label == "" {
+			return buf[:start], errEmptyLabel
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+		if !more {
+			break
+		}
+		dn = rest
 	}
-	buf.WriteByte(0)
-	return buf.Bytes(), nil
+	if len(buf)-start+1 > 255 {
+		return buf[:start], errNameTooLong
+	}
+	return append(buf, 0), nil
 }
 
 func DecodeDomainName(data []byte) (string, int, error) {
 	if len(data) == 1 && data[0] == 0 {
 		return ".", 0, nil
 	}
-	var dn string
+	// measure first so the name is built with a single allocation
+	size, err := domainNameLength(data)
+	if err != nil {
+		return "", 0, err
+	}
+	var dn strings.Builder
+	dn.Grow(size)
 	i := 0
 	for data[i] != 0 {
+		length := int(data[i])
+		dn.Write(data[i+1 : i+1+length])
+		dn.WriteByte('.')
+		i += length + 1
+	}
+	return dn.String(), i + 1, nil
+}
+
+// domainNameLength returns the wire length of the uncompressed name at
+// the start of data, excluding the terminating zero octet
+func domainNameLength(data []byte) (int, error) {
+	i := 0
+	for i < len(data) && data[i] != 0 {
 		length := int(data[i])
 		if i+length >= len(data) {
-			return "", 0, errors.New("invalid domain name")
+			return 0, errors.New("invalid domain name")
 		}
-		dn += string(data[i+1:i+1+length]) + "."
 		i += length + 1
 	}
-	return dn, i + 1, nil
+	if i >= len(data) {
+		return 0, errors.New("invalid domain name")
+	}
+	return i, nil
+}
+
+// nameEnd returns the wire length of the name at the start of data,
+// including its terminating zero octet or compression pointer
+func nameEnd(data []byte) (int, error) {
+	for i := 0; i < len(data); {
+		switch {
+		case data[i] == 0:
+			return i + 1, nil
+		case data[i]&0xC0 == 0xC0:
+			if i+1 >= len(data) {
+				return 0, errBadPointer
+			}
+			return i + 2, nil
+		default:
+			i += int(data[i]) + 1
+		}
+	}
+	return 0, errors.New("invalid domain name")
 }
 
 type Encoder[T any] interface {
 	Encode() []byte
 }
+
+// IsSubdomain reports whether name is equal to or below parent, compared
+// in canonical form
+func IsSubdomain(name, parent string) bool {
+	return fqdn.Canonical(name).IsSubdomainOf(fqdn.Canonical(parent))
+}
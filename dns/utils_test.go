@@ -2,6 +2,7 @@ package dns
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -63,6 +64,18 @@ func TestEncodeDomain(t *testing.T) {
 			wantErr: true,
 			errMsg:  "label exceeds maximum length of 63 octets",
 		},
+		{
+			name:    "empty label",
+			input:   "www..example.com",
+			wantErr: true,
+			errMsg:  "empty label",
+		},
+		{
+			name:    "name too long",
+			input:   strings.Repeat(strings.Repeat("a", 63)+".", 4),
+			wantErr: true,
+			errMsg:  "name exceeds maximum length of 255 octets",
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,627 @@
+package dns
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/fqdn"
+)
+
+// Record is a zone record holding a single value (A, AAAA, TXT)
+type Record struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+	TTL   uint32 `yaml:"ttl"`
+	// Health probes the address of an A or AAAA record, left out of the
+	// answers while it is down
+	Health *HealthCheck `yaml:"health"`
+	// Fallback addresses of a name are answered only when none of its
+	// other addresses is healthy
+	Fallback bool `yaml:"fallback,omitempty"`
+	// Weight of an A or AAAA record: of the addresses of a name with
+	// weights, one is answered, picked in proportion to them. Those of
+	// weight 0 are not answered while another has a weight.
+	Weight uint16 `yaml:"weight"`
+
+	RecordMeta `yaml:",inline"`
+}
+
+type MXRecord struct {
+	Name       string `yaml:"name"`
+	Host       string `yaml:"host"`
+	Preference uint16 `yaml:"preference"`
+	TTL        uint32 `yaml:"ttl"`
+
+	RecordMeta `yaml:",inline"`
+}
+
+type NSRecord struct {
+	Name string `yaml:"name"`
+	Host string `yaml:"host"`
+	TTL  uint32 `yaml:"ttl"`
+
+	RecordMeta `yaml:",inline"`
+}
+
+// SRVRecord locates a service, RFC 2782. Its name is like
+// _sip._udp for the services of the origin.
+type SRVRecord struct {
+	Name     string `yaml:"name"`
+	Priority uint16 `yaml:"priority"`
+	Weight   uint16 `yaml:"weight"`
+	Port     uint16 `yaml:"port"`
+	Target   string `yaml:"target"`
+	TTL      uint32 `yaml:"ttl"`
+
+	RecordMeta `yaml:",inline"`
+}
+
+// NAPTRRecord rewrites a name into the next name or URI to look up, RFC
+// 3403, e.g. to find the SRV records of a SIP service
+type NAPTRRecord struct {
+	Name       string `yaml:"name"`
+	Order      uint16 `yaml:"order"`
+	Preference uint16 `yaml:"preference"`
+	// Flags are like S for a replacement with SRV records, A for one with
+	// addresses or U for a regexp giving a URI
+	Flags   string `yaml:"flags"`
+	Service string `yaml:"service"`
+	Regexp  string `yaml:"regexp"`
+	// Replacement is the next name to look up, "." or empty with a regexp
+	Replacement string `yaml:"replacement"`
+	TTL         uint32 `yaml:"ttl"`
+	RecordMeta  `yaml:",inline"`
+}
+
+// TLSARecord pins the certificate of a TLS service, RFC 6698. Its name is
+// like _443._tcp.www for the HTTPS server of www.
+type TLSARecord struct {
+	Name string `yaml:"name"`
+	// Usage is 0 to 3, from PKIX-TA to DANE-EE
+	Usage uint8 `yaml:"usage"`
+	// Selector is 0 for the full certificate, 1 for its public key
+	Selector uint8 `yaml:"selector"`
+	// MatchingType is 0 for the data itself, 1 for its SHA-256 and 2 for
+	// its SHA-512
+	MatchingType uint8 `yaml:"matching_type"`
+	// Data is the certificate association data in hex
+	Data string `yaml:"data"`
+	TTL  uint32 `yaml:"ttl"`
+
+	RecordMeta `yaml:",inline"`
+}
+
+// GenericRecord is a record of any type with its RDATA in the RFC 3597
+// generic format, for types the zone has no fields for
+type GenericRecord struct {
+	Name string `yaml:"name"`
+	// Type is a mnemonic or TYPEnnn
+	Type string `yaml:"type"`
+	// Data is the RDATA as \# length hex
+	Data string `yaml:"data"`
+	TTL  uint32 `yaml:"ttl"`
+
+	RecordMeta `yaml:",inline"`
+}
+
+// Zone represents DNS zone data
+type Zone struct {
+	SOA     map[string]interface{} `yaml:"soa"`
+	Origin  string                 `yaml:"origin"`
+	NS      []NSRecord             `yaml:"ns"`
+	A       []Record               `yaml:"a"`
+	AAAA    []Record               `yaml:"aaaa"`
+	TXT     []Record               `yaml:"txt"`
+	MX      []MXRecord             `yaml:"mx"`
+	SRV     []SRVRecord            `yaml:"srv"`
+	NAPTR   []NAPTRRecord          `yaml:"naptr"`
+	TLSA    []TLSARecord           `yaml:"tlsa"`
+	Generic []GenericRecord        `yaml:"generic"`
+	// TTL is the default of records without one
+	TTL int `yaml:"ttl"`
+	// MinTTL raises lower TTLs of the records of the zone and of the
+	// answers forwarded for it
+	MinTTL uint32 `yaml:"min_ttl"`
+	// Authoritative sets the AA flag of answers from the zone, true when
+	// unset
+	Authoritative *bool `yaml:"authoritative"`
+	// Forward resolves the names of the zone it has no answer for through
+	// these upstreams instead of answering them empty
+	Forward []Upstream `yaml:"forward"`
+	// Transfer lists the networks allowed to transfer the zone
+	Transfer []string `yaml:"transfer"`
+	// Notify are the secondaries sent a NOTIFY when the zone changes
+	Notify []Upstream `yaml:"notify"`
+
+	// index holds the records by lower case owner and type, see Index
+	index map[string]map[QType][]zoneRecord
+	// health tells which health-checked records are healthy, set by
+	// HealthChecks.Watch
+	health *HealthChecks
+}
+
+// zoneRecord is an encoded record of a zone. Target is the name whose
+// addresses go in the additional section, flags those of NAPTR records.
+type zoneRecord struct {
+	ttl    uint32
+	rdata  []byte
+	target string
+	flags  string
+	// health is the check of an address, nil when not checked
+	health *healthTarget
+	// fallback addresses are answered when none of the others is healthy
+	fallback bool
+	// weight of an address, the chance it is the one answered
+	weight uint16
+}
+
+// Fqdn returns name made absolute relative to the zone origin.
+// "@" and the empty name stand for the origin itself.
+func (z *Zone) Fqdn(name string) string {
+	switch {
+	case name == "" || name == "@":
+		return z.Origin
+	case strings.HasSuffix(name, "."):
+		return name
+	default:
+		return name + "." + z.Origin
+	}
+}
+
+// recordTTL falls back to the zone default when a record has no TTL, and
+// raises it to the zone minimum
+func (z *Zone) recordTTL(ttl uint32) uint32 {
+	if ttl == 0 {
+		ttl = uint32(z.TTL)
+	}
+	return max(ttl, z.MinTTL)
+}
+
+// IsAuthoritative reports whether answers from the zone set the AA flag
+func (z *Zone) IsAuthoritative() bool {
+	return z.Authoritative == nil || *z.Authoritative
+}
+
+// Serial returns the SOA serial of the zone, false when it has none
+func (z *Zone) Serial() (uint32, bool) {
+	serial, ok := z.SOA["serial"].(int)
+	return uint32(serial), ok
+}
+
+// SetSerial replaces the SOA serial of the zone, leaving the SOA of the
+// zone it was copied from as it is
+func (z *Zone) SetSerial(serial uint32) {
+	soa := maps.Clone(z.SOA)
+	if soa == nil {
+		soa = make(map[string]interface{})
+	}
+	soa["serial"] = int(serial)
+	z.SOA = soa
+	if z.index != nil {
+		z.Index()
+	}
+}
+
+// NextSerial returns the serial following serial at now. Serials in the
+// YYYYMMDDnn format move to the date of now, the others are counters.
+func NextSerial(serial uint32, now time.Time) uint32 {
+	if _, err := time.Parse("20060102", strconv.Itoa(int(serial/100))); err == nil {
+		today := uint32(now.Year()*10000+int(now.Month())*100+now.Day()) * 100
+		if SerialAfter(today, serial) {
+			return today
+		}
+	}
+	return serial + 1
+}
+
+// SerialAfter reports whether serial a is more recent than b in serial
+// number arithmetic, RFC 1982
+func SerialAfter(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
+
+// AllowsTransfer reports whether client is in a network of the transfer
+// list of the zone
+func (z *Zone) AllowsTransfer(client net.IP) bool {
+	for _, cidr := range z.Transfer {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && client != nil && network.Contains(client) {
+			return true
+		}
+	}
+	return false
+}
+
+// eachRecord calls fn with the owner and type of every record of the zone
+// that can be encoded and is active, in the order of the zone file
+func (z *Zone) eachRecord(fn func(owner string, qtype QType, record zoneRecord)) {
+	now := time.Now()
+	z.allRecords(func(owner string, qtype QType, record zoneRecord, meta RecordMeta) {
+		if meta.Active(now) {
+			fn(owner, qtype, record)
+		}
+	})
+}
+
+// allRecords calls fn with the owner, type and metadata of every record of
+// the zone that can be encoded, active or not, in the order of the zone
+// file
+func (z *Zone) allRecords(fn func(owner string, qtype QType, record zoneRecord, meta RecordMeta)) {
+	add := func(owner string, qtype QType, ttl uint32, rdata []byte, target string, meta RecordMeta) {
+		if rdata != nil {
+			fn(z.Fqdn(owner), qtype, zoneRecord{ttl: ttl, rdata: rdata, target: target}, meta)
+		}
+	}
+	add("@", TypeSOA, 0, z.encodeSOA(), "", RecordMeta{})
+	address := func(qtype QType, record Record, rdata []byte) {
+		if rdata != nil {
+			owner := z.Fqdn(record.Name)
+			fn(owner, qtype, zoneRecord{ttl: record.TTL, rdata: rdata, health: record.Health.target(record.Value, owner), fallback: record.Fallback, weight: record.Weight}, record.RecordMeta)
+		}
+	}
+	for _, record := range z.A {
+		address(TypeA, record, encodeIP(record.Value))
+	}
+	for _, record := range z.AAAA {
+		address(TypeAAAA, record, encodeIPv6(record.Value))
+	}
+	for _, record := range z.TXT {
+		add(record.Name, TypeTXT, record.TTL, encodeTXT(record.Value), "", record.RecordMeta)
+	}
+	for _, record := range z.MX {
+		add(record.Name, TypeMX, record.TTL, encodeMX(record.Preference, z.Fqdn(record.Host)), z.Fqdn(record.Host), record.RecordMeta)
+	}
+	for _, record := range z.NS {
+		host, err := EncodeDomainName(z.Fqdn(record.Host))
+		if err != nil {
+			continue
+		}
+		add(record.Name, TypeNS, record.TTL, host, z.Fqdn(record.Host), record.RecordMeta)
+	}
+	for _, record := range z.SRV {
+		add(record.Name, TypeSRV, record.TTL, encodeSRV(record.Priority, record.Weight, record.Port, z.Fqdn(record.Target)), z.Fqdn(record.Target), record.RecordMeta)
+	}
+	for _, record := range z.NAPTR {
+		replacement := z.replacement(record.Replacement)
+		if rdata := encodeNAPTR(record, replacement); rdata != nil {
+			fn(z.Fqdn(record.Name), TypeNAPTR, zoneRecord{ttl: record.TTL, rdata: rdata, target: replacement, flags: strings.ToUpper(record.Flags)}, record.RecordMeta)
+		}
+	}
+	for _, record := range z.TLSA {
+		add(record.Name, TypeTLSA, record.TTL, encodeTLSA(record), "", record.RecordMeta)
+	}
+	for _, record := range z.Generic {
+		qtype, err := ParseQType(record.Type)
+		if err != nil {
+			continue
+		}
+		rdata, err := ParseGenericData(record.Data)
+		if err != nil {
+			continue
+		}
+		add(record.Name, qtype, record.TTL, rdata, "", record.RecordMeta)
+	}
+}
+
+// Index builds the index answering lookups without scanning the records
+// of the zone. Lookups on a zone without an index scan them.
+func (z *Zone) Index() {
+	index := make(map[string]map[QType][]zoneRecord)
+	origin := fqdn.Canonical(z.Origin)
+	z.eachRecord(func(owner string, qtype QType, record zoneRecord) {
+		name := fqdn.Canonical(owner)
+		types, ok := index[string(name)]
+		if !ok {
+			types = make(map[QType][]zoneRecord)
+			index[string(name)] = types
+		}
+		types[qtype] = append(types[qtype], record)
+		// ancestors in the zone exist without records of their own
+		for parent := name.Parent(); parent != origin && parent.IsSubdomainOf(origin); parent = parent.Parent() {
+			if _, ok := index[string(parent)]; !ok {
+				index[string(parent)] = make(map[QType][]zoneRecord)
+			}
+		}
+	})
+	z.index = index
+}
+
+// Exists reports whether name is in the zone, owning records or being an
+// empty non-terminal above names that do, RFC 8020. Other names of the
+// zone are answered NXDOMAIN.
+func (z *Zone) Exists(name string) bool {
+	n, origin := fqdn.Canonical(name), fqdn.Canonical(z.Origin)
+	if n == origin {
+		return true
+	}
+	if !n.IsSubdomainOf(origin) {
+		return false
+	}
+	if z.index != nil {
+		_, ok := z.index[string(n)]
+		return ok
+	}
+	found := false
+	z.eachRecord(func(owner string, _ QType, _ zoneRecord) {
+		found = found || fqdn.Canonical(owner).IsSubdomainOf(n)
+	})
+	return found
+}
+
+// records returns the records of type qtype owned by name, those of
+// healthy addresses when they are health-checked and one of them when
+// they have weights
+func (z *Zone) records(name string, qtype QType) []zoneRecord {
+	if z.index != nil {
+		return weighted(z.healthy(z.index[string(fqdn.Canonical(name))][qtype]))
+	}
+	var found []zoneRecord
+	z.eachRecord(func(owner string, t QType, record zoneRecord) {
+		if t == qtype && fqdn.Canonical(owner) == fqdn.Canonical(name) {
+			found = append(found, record)
+		}
+	})
+	return weighted(z.healthy(found))
+}
+
+// weighted returns one record of an RRset with weights, picked with a
+// chance proportional to its weight, and an RRset without as it is
+func weighted(records []zoneRecord) []zoneRecord {
+	var total uint64
+	for _, r := range records {
+		total += uint64(r.weight)
+	}
+	if total == 0 {
+		return records
+	}
+	n := rand.Uint64N(total)
+	for i, r := range records {
+		if n < uint64(r.weight) {
+			return records[i : i+1]
+		}
+		n -= uint64(r.weight)
+	}
+	return records
+}
+
+// Lookup returns the zone records of type qtype owned by name
+func (z *Zone) Lookup(name string, qtype QType, qclass uint16) []Answer {
+	records := z.records(name, qtype)
+	if len(records) == 0 {
+		return nil
+	}
+	encodedName, err := EncodeDomainName(name)
+	if err != nil {
+		return nil
+	}
+	answers := make([]Answer, 0, len(records))
+	for _, record := range records {
+		answers = append(answers, Answer{
+			Name:     encodedName,
+			Type:     uint16(qtype),
+			Class:    qclass,
+			TTL:      z.recordTTL(record.ttl),
+			RData:    record.rdata,
+			RDLength: uint16(len(record.rdata)),
+		})
+	}
+	return answers
+}
+
+// replacement makes the replacement of a NAPTR record absolute, "." when
+// there is none
+func (z *Zone) replacement(name string) string {
+	if name == "" || name == "." {
+		return "."
+	}
+	return z.Fqdn(name)
+}
+
+// Additional returns the records for the additional section of a response
+// to a name/qtype query: the addresses of MX, NS and SRV targets (RFC 1035
+// section 3.3, RFC 2782), the SRV records and addresses NAPTR replacements
+// lead to (RFC 3403 section 4.2) and, for address queries, the other
+// address family.
+func (z *Zone) Additional(name string, qtype QType, qclass uint16) []Answer {
+	var targets []string
+	var additional []Answer
+	switch qtype {
+	case TypeA:
+		return z.Lookup(name, TypeAAAA, qclass)
+	case TypeAAAA:
+		return z.Lookup(name, TypeA, qclass)
+	case TypeMX, TypeNS, TypeSRV:
+		for _, record := range z.records(name, qtype) {
+			targets = append(targets, record.target)
+		}
+	case TypeNAPTR:
+		for _, record := range z.records(name, qtype) {
+			if record.target == "." {
+				continue
+			}
+			switch record.flags {
+			case "S":
+				additional = append(additional, z.Lookup(record.target, TypeSRV, qclass)...)
+				for _, srv := range z.records(record.target, TypeSRV) {
+					targets = append(targets, srv.target)
+				}
+			case "A":
+				targets = append(targets, record.target)
+			}
+		}
+	}
+
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		target = string(fqdn.Canonical(target))
+		if seen[target] {
+			continue
+		}
+		seen[target] = true
+		additional = append(additional, z.Lookup(target, TypeA, qclass)...)
+		additional = append(additional, z.Lookup(target, TypeAAAA, qclass)...)
+	}
+	return additional
+}
+
+// Delegation finds the closest zone cut at or above name, i.e. NS records
+// owned by a child of the origin. It returns the cut's NS records for the
+// authority section and the glue addresses of in-zone name servers.
+func (z *Zone) Delegation(name string, qclass uint16) (authority, glue []Answer, ok bool) {
+	// walk up from name, the first owner of NS records below the origin
+	// is the closest cut
+	cut := ""
+	for owner := name; !strings.EqualFold(owner, z.Origin) && IsSubdomain(owner, z.Origin); {
+		if len(z.records(owner, TypeNS)) > 0 {
+			cut = owner
+			break
+		}
+		_, parent, found := strings.Cut(owner, ".")
+		if !found || parent == "" {
+			break
+		}
+		owner = parent
+	}
+	if cut == "" {
+		return nil, nil, false
+	}
+
+	authority = z.Lookup(cut, TypeNS, qclass)
+	for _, record := range z.records(cut, TypeNS) {
+		if !IsSubdomain(record.target, z.Origin) {
+			continue
+		}
+		glue = append(glue, z.Lookup(record.target, TypeA, qclass)...)
+		glue = append(glue, z.Lookup(record.target, TypeAAAA, qclass)...)
+	}
+	return authority, glue, true
+}
+
+// NegativeAuthority returns the SOA of the zone for the authority section
+// of its NXDOMAIN and NODATA answers, the TTL lowered to the SOA minimum so
+// resolvers cache the negative answer no longer, RFC 2308 section 3
+func (z *Zone) NegativeAuthority(qclass uint16) []Answer {
+	soa := z.Lookup(z.Origin, TypeSOA, qclass)
+	if minimum, ok := z.SOA["minimum"].(int); ok && minimum >= 0 {
+		for i := range soa {
+			soa[i].TTL = min(soa[i].TTL, uint32(minimum))
+		}
+	}
+	return soa
+}
+
+// FindZone returns the zone closest enclosing name
+func FindZone(zones map[string]Zone, name string) (Zone, bool) {
+	for n := fqdn.Canonical(name); ; n = n.Parent() {
+		if zone, ok := zones[string(n)]; ok {
+			return zone, true
+		}
+		if n == fqdn.Root {
+			return Zone{}, false
+		}
+	}
+}
+
+func encodeIPv6(ip string) []byte {
+	ipBytes := net.ParseIP(ip)
+	if ipBytes == nil || ipBytes.To4() != nil {
+		return nil
+	}
+	return ipBytes.To16()
+}
+
+// encodeTXT splits the text into character-strings of at most 255 octets
+func encodeTXT(txt string) []byte {
+	txtBytes := make([]byte, 0, len(txt)+len(txt)/255+1)
+	for {
+		chunk := txt
+		if len(chunk) > 255 {
+			chunk = chunk[:255]
+		}
+		txtBytes = append(txtBytes, byte(len(chunk)))
+		txtBytes = append(txtBytes, chunk...)
+		txt = txt[len(chunk):]
+		if txt == "" {
+			return txtBytes
+		}
+	}
+}
+
+// encodeSOA encodes the SOA of the zone, nil without its mname and rname
+func (z *Zone) encodeSOA() []byte {
+	mname, _ := z.SOA["mname"].(string)
+	rname, _ := z.SOA["rname"].(string)
+	if mname == "" || rname == "" {
+		return nil
+	}
+	soaBytes, err := EncodeDomainName(z.Fqdn(mname))
+	if err != nil {
+		return nil
+	}
+	dn, err := EncodeDomainName(z.Fqdn(rname))
+	if err != nil {
+		return nil
+	}
+	soaBytes = append(soaBytes, dn...)
+	for _, field := range []string{"serial", "refresh", "retry", "expire", "minimum"} {
+		value, _ := z.SOA[field].(int)
+		soaBytes = binary.BigEndian.AppendUint32(soaBytes, uint32(value))
+	}
+	return soaBytes
+}
+
+func encodeMX(preference uint16, host string) []byte {
+	dn, err := EncodeDomainName(host)
+	if err != nil {
+		return nil
+	}
+	mxBytes := make([]byte, 2, 2+len(dn))
+	binary.BigEndian.PutUint16(mxBytes, preference)
+	return append(mxBytes, dn...)
+}
+
+func encodeSRV(priority, weight, port uint16, target string) []byte {
+	dn, err := EncodeDomainName(target)
+	if err != nil {
+		return nil
+	}
+	srvBytes := make([]byte, 6, 6+len(dn))
+	binary.BigEndian.PutUint16(srvBytes, priority)
+	binary.BigEndian.PutUint16(srvBytes[2:], weight)
+	binary.BigEndian.PutUint16(srvBytes[4:], port)
+	return append(srvBytes, dn...)
+}
+
+// encodeNAPTR encodes the record with its absolute replacement, nil when a
+// string is longer than 255 octets
+func encodeNAPTR(record NAPTRRecord, replacement string) []byte {
+	dn, err := EncodeDomainName(replacement)
+	if err != nil {
+		return nil
+	}
+	naptrBytes := make([]byte, 4, 7+len(record.Flags)+len(record.Service)+len(record.Regexp)+len(dn))
+	binary.BigEndian.PutUint16(naptrBytes, record.Order)
+	binary.BigEndian.PutUint16(naptrBytes[2:], record.Preference)
+	for _, s := range []string{record.Flags, record.Service, record.Regexp} {
+		if len(s) > 255 {
+			return nil
+		}
+		naptrBytes = append(naptrBytes, byte(len(s)))
+		naptrBytes = append(naptrBytes, s...)
+	}
+	return append(naptrBytes, dn...)
+}
+
+// encodeTLSA encodes the record, nil when its data is not hex
+func encodeTLSA(record TLSARecord) []byte {
+	data, err := hex.DecodeString(strings.ReplaceAll(record.Data, " ", ""))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	return append([]byte{record.Usage, record.Selector, record.MatchingType}, data...)
+}
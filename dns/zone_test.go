@@ -0,0 +1,365 @@
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testZone() Zone {
+	return Zone{
+		Origin: "example.com.",
+		TTL:    3600,
+		NS:     []NSRecord{{Name: "@", Host: "ns1"}},
+		A: []Record{
+			{Name: "@", Value: "192.0.2.1", TTL: 60},
+			{Name: "mail", Value: "192.0.2.25"},
+			{Name: "ns1.example.com.", Value: "192.0.2.53"},
+		},
+		AAAA: []Record{
+			{Name: "@", Value: "2001:db8::1"},
+			{Name: "mail", Value: "2001:db8::25"},
+		},
+		TXT:  []Record{{Name: "@", Value: "v=spf1 -all"}},
+		MX:   []MXRecord{{Name: "@", Host: "mail", Preference: 10}},
+		SRV:  []SRVRecord{{Name: "_sip._udp", Priority: 10, Weight: 5, Port: 5060, Target: "mail"}},
+		TLSA: []TLSARecord{{Name: "_443._tcp.www", Usage: 3, Selector: 1, MatchingType: 0, Data: "ab cd"}},
+		NAPTR: []NAPTRRecord{
+			{Name: "@", Order: 100, Preference: 10, Flags: "S", Service: "SIP+D2U", Replacement: "_sip._udp"},
+			{Name: "@", Order: 100, Preference: 20, Flags: "U", Service: "E2U+sip", Regexp: "!^.*$!sip:info@example.com!"},
+		},
+		Generic: []GenericRecord{
+			{Name: "opaque", Type: "TYPE65280", Data: `\# 4 0a00 0001`, TTL: 30},
+			{Name: "opaque", Type: "type65281", Data: `\# 0`},
+		},
+	}
+}
+
+// bothIndexes returns zone without and with an index, lookups must not
+// tell them apart
+func bothIndexes(zone Zone) map[string]Zone {
+	indexed := zone
+	indexed.Index()
+	return map[string]Zone{"scan": zone, "index": indexed}
+}
+
+func TestZoneLookup(t *testing.T) {
+	tests := []struct {
+		name      string
+		qname     string
+		want      [][]byte
+		qtype     QType
+		wantTTL   uint32
+		wantCount int
+	}{
+		{
+			name:    "apex A",
+			qname:   "example.com.",
+			qtype:   TypeA,
+			want:    [][]byte{{192, 0, 2, 1}},
+			wantTTL: 60,
+		},
+		{
+			name:    "relative name uses zone ttl",
+			qname:   "mail.example.com.",
+			qtype:   TypeA,
+			want:    [][]byte{{192, 0, 2, 25}},
+			wantTTL: 3600,
+		},
+		{
+			name:    "txt",
+			qname:   "example.com.",
+			qtype:   TypeTXT,
+			want:    [][]byte{append([]byte{11}, "v=spf1 -all"...)},
+			wantTTL: 3600,
+		},
+		{
+			name:    "mx",
+			qname:   "example.com.",
+			qtype:   TypeMX,
+			want:    [][]byte{{0, 10, 4, 'm', 'a', 'i', 'l', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0}},
+			wantTTL: 3600,
+		},
+		{
+			name:    "srv",
+			qname:   "_sip._udp.example.com.",
+			qtype:   TypeSRV,
+			want:    [][]byte{{0, 10, 0, 5, 0x13, 0xc4, 4, 'm', 'a', 'i', 'l', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0}},
+			wantTTL: 3600,
+		},
+		{
+			name:  "naptr",
+			qname: "example.com.",
+			qtype: TypeNAPTR,
+			want: [][]byte{
+				append([]byte{0, 100, 0, 10, 1, 'S', 7, 'S', 'I', 'P', '+', 'D', '2', 'U', 0, 4, '_', 's', 'i', 'p', 4, '_', 'u', 'd', 'p'}, 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0),
+				append(append([]byte{0, 100, 0, 20, 1, 'U', 7, 'E', '2', 'U', '+', 's', 'i', 'p', 27}, "!^.*$!sip:info@example.com!"...), 0),
+			},
+			wantTTL: 3600,
+		},
+		{
+			name:    "tlsa",
+			qname:   "_443._tcp.www.example.com.",
+			qtype:   TypeTLSA,
+			want:    [][]byte{{3, 1, 0, 0xab, 0xcd}},
+			wantTTL: 3600,
+		},
+		{
+			name:    "generic",
+			qname:   "opaque.example.com.",
+			qtype:   QType(65280),
+			want:    [][]byte{{10, 0, 0, 1}},
+			wantTTL: 30,
+		},
+		{
+			name:    "generic without rdata",
+			qname:   "opaque.example.com.",
+			qtype:   QType(65281),
+			want:    [][]byte{{}},
+			wantTTL: 3600,
+		},
+		{
+			name:  "missing name",
+			qname: "www.example.com.",
+			qtype: TypeA,
+		},
+	}
+
+	for kind, zone := range bothIndexes(testZone()) {
+		for _, tt := range tests {
+			t.Run(kind+" "+tt.name, func(t *testing.T) {
+				got := zone.Lookup(tt.qname, tt.qtype, 1)
+				if len(got) != len(tt.want) {
+					t.Fatalf("Lookup() returned %d answers, want %d", len(got), len(tt.want))
+				}
+				for i, answer := range got {
+					if !bytes.Equal(answer.RData, tt.want[i]) {
+						t.Errorf("Lookup() rdata = %v, want %v", answer.RData, tt.want[i])
+					}
+					if answer.TTL != tt.wantTTL {
+						t.Errorf("Lookup() ttl = %d, want %d", answer.TTL, tt.wantTTL)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestZoneAdditional(t *testing.T) {
+	tests := []struct {
+		name  string
+		qname string
+		want  []QType
+		qtype QType
+	}{
+		{name: "mx target addresses", qname: "example.com.", qtype: TypeMX, want: []QType{TypeA, TypeAAAA}},
+		{name: "ns target address", qname: "example.com.", qtype: TypeNS, want: []QType{TypeA}},
+		{name: "A adds AAAA", qname: "example.com.", qtype: TypeA, want: []QType{TypeAAAA}},
+		{name: "txt adds nothing", qname: "example.com.", qtype: TypeTXT},
+		{name: "srv target addresses", qname: "_sip._udp.example.com.", qtype: TypeSRV, want: []QType{TypeA, TypeAAAA}},
+		{name: "naptr replacement srv and addresses", qname: "example.com.", qtype: TypeNAPTR, want: []QType{TypeSRV, TypeA, TypeAAAA}},
+	}
+
+	for kind, zone := range bothIndexes(testZone()) {
+		for _, tt := range tests {
+			t.Run(kind+" "+tt.name, func(t *testing.T) {
+				got := zone.Additional(tt.qname, tt.qtype, 1)
+				if len(got) != len(tt.want) {
+					t.Fatalf("Additional() returned %d records, want %d", len(got), len(tt.want))
+				}
+				for i, record := range got {
+					if QType(record.Type) != tt.want[i] {
+						t.Errorf("Additional()[%d] type = %d, want %d", i, record.Type, tt.want[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestZoneDelegation(t *testing.T) {
+	zone := testZone()
+	zone.NS = append(zone.NS,
+		NSRecord{Name: "child", Host: "ns1.child"},
+		NSRecord{Name: "child", Host: "ns.other.net."},
+	)
+	zone.A = append(zone.A, Record{Name: "ns1.child", Value: "192.0.2.54"})
+
+	for kind, zone := range bothIndexes(zone) {
+		authority, glue, ok := zone.Delegation("www.child.example.com.", 1)
+		if !ok {
+			t.Fatalf("%s: Delegation() ok = false, want true", kind)
+		}
+		if len(authority) != 2 {
+			t.Errorf("%s: Delegation() authority has %d records, want 2", kind, len(authority))
+		}
+		if len(glue) != 1 || !bytes.Equal(glue[0].RData, []byte{192, 0, 2, 54}) {
+			t.Errorf("%s: Delegation() glue = %v, want ns1.child address", kind, glue)
+		}
+
+		if _, _, ok := zone.Delegation("mail.example.com.", 1); ok {
+			t.Errorf("%s: Delegation() of name outside any cut ok = true, want false", kind)
+		}
+	}
+}
+
+func TestZoneExists(t *testing.T) {
+	tests := map[string]bool{
+		"example.com.":                 true,
+		"MAIL.example.com.":            true,
+		"_tcp.www.example.com.":        true,
+		"www.example.com.":             true,
+		"_udp.example.com.":            true,
+		"missing.example.com.":         false,
+		"x._443._tcp.www.example.com.": false,
+		"ns1.example.com.example.com.": false,
+		"com.":                         false,
+	}
+	for kind, zone := range bothIndexes(testZone()) {
+		for name, want := range tests {
+			if got := zone.Exists(name); got != want {
+				t.Errorf("%s: Exists(%q) = %v, want %v", kind, name, got, want)
+			}
+		}
+	}
+}
+
+func TestFindZone(t *testing.T) {
+	zones := map[string]Zone{
+		"example.com.":     {Origin: "example.com."},
+		"sub.example.com.": {Origin: "sub.example.com."},
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "example.com.", want: "example.com."},
+		{name: "WWW.Example.com.", want: "example.com."},
+		{name: "a.sub.example.com.", want: "sub.example.com."},
+		{name: "Sub.Example.com.", want: "sub.example.com."},
+		{name: "www.example.com", want: "example.com."},
+		{name: "a.b.example.com.", want: "example.com."},
+		{name: "subexample.com.", want: ""},
+		{name: "example.org.", want: ""},
+	}
+	for _, tt := range tests {
+		zone, _ := FindZone(zones, tt.name)
+		if zone.Origin != tt.want {
+			t.Errorf("FindZone(%q) = %q, want %q", tt.name, zone.Origin, tt.want)
+		}
+	}
+}
+
+// largeZone has n A records and one MX record pointing at the last
+func largeZone(n int) Zone {
+	zone := Zone{Origin: "example.com.", TTL: 3600}
+	for i := range n {
+		zone.A = append(zone.A, Record{Name: fmt.Sprintf("host%d", i), Value: "192.0.2.1"})
+	}
+	zone.MX = []MXRecord{{Name: "@", Host: fmt.Sprintf("host%d", n-1), Preference: 10}}
+	return zone
+}
+
+func BenchmarkZoneLookup(b *testing.B) {
+	for kind, zone := range bothIndexes(largeZone(10000)) {
+		b.Run(kind, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if len(zone.Lookup("host9999.example.com.", TypeA, 1)) != 1 {
+					b.Fatal("record not found")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkZoneAdditional(b *testing.B) {
+	for kind, zone := range bothIndexes(largeZone(10000)) {
+		b.Run(kind, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if len(zone.Additional("example.com.", TypeMX, 1)) != 1 {
+					b.Fatal("mx target address not found")
+				}
+			}
+		})
+	}
+}
+
+func TestNextSerial(t *testing.T) {
+	now := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		serial, want uint32
+	}{
+		{1, 2},
+		{2026010105, 2026101600},
+		{2026101600, 2026101601},
+		{2026101699, 2026101700},
+		{1<<32 - 1, 0},
+	}
+	for _, tt := range tests {
+		if got := NextSerial(tt.serial, now); got != tt.want {
+			t.Errorf("NextSerial(%d) = %d, want %d", tt.serial, got, tt.want)
+		}
+	}
+	if !SerialAfter(0, 1<<32-1) || SerialAfter(5, 5) || SerialAfter(4, 5) {
+		t.Error("SerialAfter does not wrap around")
+	}
+}
+
+func TestZoneSOA(t *testing.T) {
+	zone := testZone()
+	zone.SOA = map[string]interface{}{"mname": "ns1", "rname": "hostmaster", "serial": 2026101601, "refresh": 3600, "retry": 600, "expire": 604800, "minimum": 300}
+	soa := zone.SOA
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			zone.Index()
+		}
+		zone.SetSerial(2026101602)
+		answers := zone.Lookup("example.com.", TypeSOA, 1)
+		if len(answers) != 1 {
+			t.Fatalf("%d SOA records, want 1", len(answers))
+		}
+		want := "ns1.example.com. hostmaster.example.com. 2026101602 3600 600 604800 300"
+		if got := answers[0].Data(nil); got != want {
+			t.Errorf("SOA %q, want %q", got, want)
+		}
+	}
+	if soa["serial"] != 2026101601 {
+		t.Error("SetSerial changed the SOA of the zone copied from")
+	}
+}
+
+func TestZoneWeights(t *testing.T) {
+	zone := Zone{
+		Origin: "example.com.",
+		A: []Record{
+			{Name: "www", Value: "192.0.2.1", Weight: 1},
+			{Name: "www", Value: "192.0.2.2", Weight: 3},
+			{Name: "www", Value: "192.0.2.3", Weight: 0},
+			{Name: "mail", Value: "192.0.2.25"},
+			{Name: "mail", Value: "192.0.2.26"},
+		},
+	}
+	for name, z := range bothIndexes(zone) {
+		counts := make(map[string]int)
+		const lookups = 4000
+		for range lookups {
+			answers := z.Lookup("www.example.com.", TypeA, 1)
+			if len(answers) != 1 {
+				t.Fatalf("%s: %d answers, want one address", name, len(answers))
+			}
+			counts[answers[0].Data(nil)]++
+		}
+		if counts["192.0.2.3"] != 0 {
+			t.Errorf("%s: the address of weight 0 answered %d times", name, counts["192.0.2.3"])
+		}
+		if share := float64(counts["192.0.2.2"]) / lookups; share < 0.7 || share > 0.8 {
+			t.Errorf("%s: the address of weight 3 answered %.2f of the time, want 0.75", name, share)
+		}
+		if answers := z.Lookup("mail.example.com.", TypeA, 1); len(answers) != 2 {
+			t.Errorf("%s: %d addresses without weights answered, want 2", name, len(answers))
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package dns
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/bernoussama/mercury/fqdn"
+)
+
+// DiffRecord is a record of a zone diff in presentation format
+type DiffRecord struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	Data string `json:"data"`
+}
+
+// String returns the record in zone file order
+func (r DiffRecord) String() string {
+	return fmt.Sprintf("%s\t%d\tIN\t%s\t%s", r.Name, r.TTL, r.Type, r.Data)
+}
+
+// RecordChange is a record whose value or TTL changed: the only record of
+// its name and type in both zones, or the same value with another TTL
+type RecordChange struct {
+	Old DiffRecord `json:"old"`
+	New DiffRecord `json:"new"`
+}
+
+// ZoneDiff is what changed from a zone to a later version of it
+type ZoneDiff struct {
+	Origin  string         `json:"origin"`
+	Added   []DiffRecord   `json:"added"`
+	Removed []DiffRecord   `json:"removed"`
+	Changed []RecordChange `json:"changed"`
+	// Settings names the zone settings other than records that changed,
+	// like ttl, forward or notify
+	Settings []string `json:"settings"`
+}
+
+// Empty reports whether the zones hold the same records and settings
+func (d ZoneDiff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Changed)+len(d.Settings) == 0
+}
+
+// String returns the diff one record per line, + for those added, - for
+// those removed and ~ for those changed, followed by the settings changed
+func (d ZoneDiff) String() string {
+	var b strings.Builder
+	for _, r := range d.Added {
+		b.WriteString("+ " + r.String() + "\n")
+	}
+	for _, r := range d.Removed {
+		b.WriteString("- " + r.String() + "\n")
+	}
+	for _, c := range d.Changed {
+		fmt.Fprintf(&b, "~ %s -> %d %s\n", c.Old, c.New.TTL, c.New.Data)
+	}
+	if len(d.Settings) > 0 {
+		b.WriteString("settings: " + strings.Join(d.Settings, ", ") + "\n")
+	}
+	return b.String()
+}
+
+// zoneSettings are the settings of a zone other than its records, by the
+// key of the zone file
+var zoneSettings = []struct {
+	key   string
+	value func(*Zone) any
+}{
+	{"ttl", func(z *Zone) any { return z.TTL }},
+	{"min_ttl", func(z *Zone) any { return z.MinTTL }},
+	{"authoritative", func(z *Zone) any { return z.IsAuthoritative() }},
+	{"forward", func(z *Zone) any { return z.Forward }},
+	{"transfer", func(z *Zone) any { return z.Transfer }},
+	{"notify", func(z *Zone) any { return z.Notify }},
+}
+
+// diffRecords returns the records of the zone in presentation format, with
+// the TTLs they are answered with, sorted by name, type and value
+func (z *Zone) diffRecords() []DiffRecord {
+	var records []DiffRecord
+	z.eachRecord(func(owner string, qtype QType, record zoneRecord) {
+		records = append(records, z.presentation(owner, qtype, record))
+	})
+	slices.SortFunc(records, func(a, b DiffRecord) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Type, b.Type); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Data, b.Data)
+	})
+	return records
+}
+
+// presentation returns a record of the zone in presentation format, with
+// the TTL it is answered with
+func (z *Zone) presentation(owner string, qtype QType, record zoneRecord) DiffRecord {
+	answer := Answer{Type: uint16(qtype), RData: record.rdata}
+	return DiffRecord{
+		Name: string(fqdn.Canonical(owner)),
+		Type: strings.ToUpper(qtype.String()),
+		TTL:  z.recordTTL(record.ttl),
+		Data: answer.Data(nil),
+	}
+}
+
+// DiffZone returns the records added, removed and changed from old to new
+// and the settings changed
+func DiffZone(old, new Zone) ZoneDiff {
+	d := ZoneDiff{
+		Origin:   string(fqdn.Canonical(new.Origin)),
+		Added:    []DiffRecord{},
+		Removed:  []DiffRecord{},
+		Changed:  []RecordChange{},
+		Settings: []string{},
+	}
+	for _, s := range zoneSettings {
+		if !reflect.DeepEqual(s.value(&old), s.value(&new)) {
+			d.Settings = append(d.Settings, s.key)
+		}
+	}
+
+	type key struct{ name, qtype, data string }
+	before := make(map[key]DiffRecord)
+	for _, r := range old.diffRecords() {
+		before[key{r.Name, r.Type, r.Data}] = r
+	}
+	var added []DiffRecord
+	for _, r := range new.diffRecords() {
+		k := key{r.Name, r.Type, r.Data}
+		prev, ok := before[k]
+		switch {
+		case !ok:
+			added = append(added, r)
+		case prev.TTL != r.TTL:
+			d.Changed = append(d.Changed, RecordChange{Old: prev, New: r})
+		}
+		delete(before, k)
+	}
+	var removed []DiffRecord
+	for _, r := range old.diffRecords() {
+		if _, ok := before[key{r.Name, r.Type, r.Data}]; ok {
+			removed = append(removed, r)
+		}
+	}
+
+	// a name and type left with one record on each side changed value
+	type rrset struct{ name, qtype string }
+	count := func(records []DiffRecord) map[rrset]int {
+		n := make(map[rrset]int)
+		for _, r := range records {
+			n[rrset{r.Name, r.Type}]++
+		}
+		return n
+	}
+	addedSets, removedSets := count(added), count(removed)
+	changed := make(map[rrset]DiffRecord)
+	for _, r := range removed {
+		set := rrset{r.Name, r.Type}
+		if addedSets[set] == 1 && removedSets[set] == 1 {
+			changed[set] = r
+			continue
+		}
+		d.Removed = append(d.Removed, r)
+	}
+	for _, r := range added {
+		if prev, ok := changed[rrset{r.Name, r.Type}]; ok {
+			d.Changed = append(d.Changed, RecordChange{Old: prev, New: r})
+			continue
+		}
+		d.Added = append(d.Added, r)
+	}
+	slices.SortFunc(d.Changed, func(a, b RecordChange) int {
+		if c := strings.Compare(a.New.Name, b.New.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.New.Type, b.New.Type)
+	})
+	return d
+}
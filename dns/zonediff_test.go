@@ -0,0 +1,71 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffZone(t *testing.T) {
+	if d := DiffZone(testZone(), testZone()); !d.Empty() || d.String() != "" {
+		t.Errorf("DiffZone() of a zone with itself = %+v", d)
+	}
+
+	updated := testZone()
+	updated.A = []Record{
+		{Name: "@", Value: "192.0.2.1", TTL: 300},
+		{Name: "mail", Value: "192.0.2.26"},
+		{Name: "ns1.example.com.", Value: "192.0.2.53"},
+		{Name: "www", Value: "192.0.2.80"},
+	}
+	updated.AAAA = updated.AAAA[:1]
+	updated.TXT = append(updated.TXT, Record{Name: "@", Value: "google-site-verification=x"})
+	updated.Forward = []Upstream{{Address: "192.0.2.53:53"}}
+	d := DiffZone(testZone(), updated)
+
+	want := []struct {
+		kind string
+		got  []string
+		want []string
+	}{
+		{"added", recordNames(d.Added), []string{"example.com. TXT", "www.example.com. A"}},
+		{"removed", recordNames(d.Removed), []string{"mail.example.com. AAAA"}},
+	}
+	for _, w := range want {
+		if strings.Join(w.got, ",") != strings.Join(w.want, ",") {
+			t.Errorf("%s %v, want %v", w.kind, w.got, w.want)
+		}
+	}
+	if len(d.Changed) != 2 {
+		t.Fatalf("changed %+v, want the TTL of the apex A and the value of mail A", d.Changed)
+	}
+	if c := d.Changed[0]; c.New.Name != "example.com." || c.Old.TTL != 60 || c.New.TTL != 300 {
+		t.Errorf("changed %+v, want the TTL of example.com. A from 60 to 300", c)
+	}
+	if c := d.Changed[1]; c.New.Name != "mail.example.com." || c.Old.Data != "192.0.2.25" || c.New.Data != "192.0.2.26" {
+		t.Errorf("changed %+v, want mail.example.com. A from 192.0.2.25 to 192.0.2.26", c)
+	}
+	if strings.Join(d.Settings, ",") != "forward" {
+		t.Errorf("settings %v, want forward", d.Settings)
+	}
+
+	out := d.String()
+	for _, line := range []string{
+		"+ www.example.com.\t3600\tIN\tA\t192.0.2.80\n",
+		"- mail.example.com.\t3600\tIN\tAAAA\t2001:db8::25\n",
+		"~ mail.example.com.\t3600\tIN\tA\t192.0.2.25 -> 3600 192.0.2.26\n",
+		"settings: forward\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("String() = %q, missing %q", out, line)
+		}
+	}
+}
+
+// recordNames returns the name and type of each record
+func recordNames(records []DiffRecord) []string {
+	var names []string
+	for _, r := range records {
+		names = append(names, r.Name+" "+r.Type)
+	}
+	return names
+}
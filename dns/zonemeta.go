@@ -0,0 +1,136 @@
+package dns
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RecordMeta annotates a zone record for the people editing the zone: who
+// asked for it, why and when it is answered. It is kept when the zone is
+// written back and never answered.
+type RecordMeta struct {
+	Comment string `yaml:"comment" json:"comment,omitempty"`
+	Owner   string `yaml:"owner" json:"owner,omitempty"`
+	Ticket  string `yaml:"ticket" json:"ticket,omitempty"`
+	// Labels are free form, like env: staging
+	Labels map[string]string `yaml:"labels" json:"labels,omitempty"`
+	// Activates is the date, in the local time zone, or the RFC 3339 time
+	// the record starts being answered, for planned changes
+	Activates string `yaml:"activates" json:"activates,omitempty"`
+	// Expires is the date or time the record stops being answered, for
+	// temporary records and those a planned change replaces
+	Expires string `yaml:"expires" json:"expires,omitempty"`
+}
+
+// ParseRecordTime parses the activation or expiry of a record, a date like
+// 2026-11-01 or an RFC 3339 time. A date stands for its start in the local
+// time zone.
+func ParseRecordTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("not a date like 2026-11-01 or an RFC 3339 time")
+}
+
+// recordTime returns the time of an activation or expiry, false when it is
+// not set or does not parse
+func recordTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := ParseRecordTime(value)
+	return t, err == nil
+}
+
+// Pending reports whether the record is not answered yet at now
+func (m RecordMeta) Pending(now time.Time) bool {
+	t, ok := recordTime(m.Activates)
+	return ok && now.Before(t)
+}
+
+// Expired reports whether the record is no longer answered at now
+func (m RecordMeta) Expired(now time.Time) bool {
+	t, ok := recordTime(m.Expires)
+	return ok && !now.Before(t)
+}
+
+// Active reports whether the record is answered at now
+func (m RecordMeta) Active(now time.Time) bool {
+	return !m.Pending(now) && !m.Expired(now)
+}
+
+// Switching reports whether a record of the zone activates or expires
+// after since and up to now, the zone then answering differently
+func (z *Zone) Switching(since, now time.Time) bool {
+	switching := false
+	z.allRecords(func(_ string, _ QType, _ zoneRecord, meta RecordMeta) {
+		for _, value := range []string{meta.Activates, meta.Expires} {
+			if t, ok := recordTime(value); ok && t.After(since) && !t.After(now) {
+				switching = true
+			}
+		}
+	})
+	return switching
+}
+
+// NextSwitch returns the first time after now a record of the zone
+// activates or expires, false when none is scheduled
+func (z *Zone) NextSwitch(now time.Time) (time.Time, bool) {
+	var next time.Time
+	z.allRecords(func(_ string, _ QType, _ zoneRecord, meta RecordMeta) {
+		for _, value := range []string{meta.Activates, meta.Expires} {
+			if t, ok := recordTime(value); ok && t.After(now) && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	})
+	return next, !next.IsZero()
+}
+
+// AnnotatedRecord is a record of a zone in presentation format along with
+// its metadata
+type AnnotatedRecord struct {
+	DiffRecord
+	Meta RecordMeta `json:"meta"`
+	// Pending records are not answered yet, expired ones no longer are,
+	// both staying in the zone file
+	Pending bool `json:"pending"`
+	Expired bool `json:"expired"`
+	// Healthy tells whether the address of a health-checked record is
+	// answered, unset for records without a check
+	Healthy *bool `json:"healthy,omitempty"`
+	// Weight is the chance of a weighted address to be answered
+	Weight uint16 `json:"weight,omitempty"`
+}
+
+// AnnotatedRecords returns the records of the zone with their metadata,
+// answered at now or not, sorted by name and type
+func (z *Zone) AnnotatedRecords(now time.Time) []AnnotatedRecord {
+	records := []AnnotatedRecord{}
+	z.allRecords(func(owner string, qtype QType, record zoneRecord, meta RecordMeta) {
+		annotated := AnnotatedRecord{
+			DiffRecord: z.presentation(owner, qtype, record),
+			Meta:       meta,
+			Pending:    meta.Pending(now),
+			Expired:    meta.Expired(now),
+			Weight:     record.weight,
+		}
+		if record.health != nil {
+			healthy := z.health.healthy(record.health)
+			annotated.Healthy = &healthy
+		}
+		records = append(records, annotated)
+	})
+	slices.SortStableFunc(records, func(a, b AnnotatedRecord) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Type, b.Type)
+	})
+	return records
+}
@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRecordTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"2026-11-01", time.Date(2026, 11, 1, 0, 0, 0, 0, time.Local), true},
+		{"2026-11-01T12:30:00Z", time.Date(2026, 11, 1, 12, 30, 0, 0, time.UTC), true},
+		{"2026-11-01T12:30:00+02:00", time.Date(2026, 11, 1, 10, 30, 0, 0, time.UTC), true},
+		{"next week", time.Time{}, false},
+		{"2026-13-01", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseRecordTime(tt.value)
+		if (err == nil) != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("ParseRecordTime(%q) = %v, %v, want %v, ok %v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestZoneScheduledRecords(t *testing.T) {
+	now := time.Now()
+	zone := Zone{
+		Origin: "example.com.",
+		TTL:    3600,
+		A: []Record{
+			{Name: "www", Value: "192.0.2.1", RecordMeta: RecordMeta{Owner: "web", Ticket: "OPS-12"}},
+			{Name: "demo", Value: "192.0.2.2", RecordMeta: RecordMeta{Comment: "trade show", Expires: now.Add(-time.Minute).Format(time.RFC3339)}},
+			{Name: "beta", Value: "192.0.2.3", RecordMeta: RecordMeta{Labels: map[string]string{"env": "staging"}, Expires: now.Add(time.Hour).Format(time.RFC3339)}},
+			{Name: "new", Value: "192.0.2.4", RecordMeta: RecordMeta{Activates: now.Add(2 * time.Hour).Format(time.RFC3339)}},
+		},
+	}
+	for name, z := range bothIndexes(zone) {
+		for _, host := range []string{"demo.example.com.", "new.example.com."} {
+			if answers := z.Lookup(host, TypeA, 1); len(answers) != 0 || z.Exists(host) {
+				t.Errorf("%s: Lookup(%s) = %v, want the inactive record left out", name, host, answers)
+			}
+		}
+		for _, host := range []string{"www.example.com.", "beta.example.com."} {
+			if answers := z.Lookup(host, TypeA, 1); len(answers) != 1 {
+				t.Errorf("%s: Lookup(%s) = %v, want its record", name, host, answers)
+			}
+		}
+	}
+
+	if zone.Switching(now, now.Add(time.Minute)) {
+		t.Error("Switching() before beta expires")
+	}
+	if !zone.Switching(now.Add(time.Minute), now.Add(time.Hour)) {
+		t.Error("Switching() missed beta expiring")
+	}
+	if !zone.Switching(now.Add(90*time.Minute), now.Add(3*time.Hour)) {
+		t.Error("Switching() missed new activating")
+	}
+	if next, ok := zone.NextSwitch(now.Add(time.Hour)); !ok || next.Unix() != now.Add(2*time.Hour).Unix() {
+		t.Errorf("NextSwitch() = %v, %v, want new activating in 2 hours", next, ok)
+	}
+	if _, ok := zone.NextSwitch(now.Add(3 * time.Hour)); ok {
+		t.Error("NextSwitch() after the last switch")
+	}
+
+	records := zone.AnnotatedRecords(now)
+	if len(records) != 4 {
+		t.Fatalf("AnnotatedRecords() = %+v, want the 4 A records", records)
+	}
+	for _, r := range records {
+		switch r.Name {
+		case "demo.example.com.":
+			if !r.Expired || r.Meta.Comment != "trade show" {
+				t.Errorf("demo %+v, want expired with its comment", r)
+			}
+		case "beta.example.com.":
+			if r.Expired || r.Meta.Labels["env"] != "staging" {
+				t.Errorf("beta %+v, want its labels, not expired", r)
+			}
+		case "new.example.com.":
+			if !r.Pending || r.Expired {
+				t.Errorf("new %+v, want pending", r)
+			}
+		case "www.example.com.":
+			if r.Meta.Owner != "web" || r.Meta.Ticket != "OPS-12" || r.Data != "192.0.2.1" {
+				t.Errorf("www %+v, want its owner and ticket", r)
+			}
+		}
+	}
+}
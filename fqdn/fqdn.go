@@ -0,0 +1,75 @@
+// Package fqdn puts domain names in the one form zones, the cache and the
+// blocklist compare them in, so "Example.com" and "example.com." are the
+// same name everywhere
+package fqdn
+
+import "strings"
+
+// Name is a domain name in canonical form: lower case and fully qualified,
+// ending with the dot of the root. Only ASCII letters are folded, like DNS
+// compares names, RFC 4343.
+type Name string
+
+// Root is the name of the root zone
+const Root Name = "."
+
+// Canonical returns name in canonical form. Names already in it are
+// returned without allocating, as the names decoded from queries mostly
+// are.
+func Canonical(name string) Name {
+	if name == "" {
+		return Root
+	}
+	name = lower(name)
+	if name[len(name)-1] != '.' {
+		name += "."
+	}
+	return Name(name)
+}
+
+// lower folds the ASCII upper case letters of s
+func lower(s string) string {
+	for i := 0; i < len(s); i++ {
+		if 'A' <= s[i] && s[i] <= 'Z' {
+			b := []byte(s)
+			for ; i < len(b); i++ {
+				if 'A' <= b[i] && b[i] <= 'Z' {
+					b[i] += 'a' - 'A'
+				}
+			}
+			return string(b)
+		}
+	}
+	return s
+}
+
+// String returns the name with its trailing dot
+func (n Name) String() string {
+	return string(n)
+}
+
+// IsSubdomainOf reports whether n is equal to or below parent
+func (n Name) IsSubdomainOf(parent Name) bool {
+	if n == parent || parent == Root {
+		return true
+	}
+	return len(n) > len(parent) && strings.HasSuffix(string(n), string(parent)) && n[len(n)-len(parent)-1] == '.'
+}
+
+// Parent returns the name one label up, the root for the root and top
+// level domains
+func (n Name) Parent() Name {
+	_, parent, _ := strings.Cut(string(n), ".")
+	if parent == "" {
+		return Root
+	}
+	return Name(parent)
+}
+
+// Labels counts the labels of the name, zero for the root
+func (n Name) Labels() int {
+	if n == Root {
+		return 0
+	}
+	return strings.Count(string(n), ".")
+}
@@ -0,0 +1,59 @@
+package fqdn
+
+import "testing"
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		name string
+		want Name
+	}{
+		{"", Root},
+		{".", Root},
+		{"example.com", "example.com."},
+		{"Example.COM.", "example.com."},
+		{"www.example.com.", "www.example.com."},
+		// only ASCII is folded
+		{"ÉCOLE.fr", "École.fr."},
+	}
+	for _, tt := range tests {
+		if got := Canonical(tt.name); got != tt.want {
+			t.Errorf("Canonical(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalAllocations(t *testing.T) {
+	name := "www.example.com."
+	if n := testing.AllocsPerRun(100, func() { Canonical(name) }); n != 0 {
+		t.Errorf("%v allocations for a canonical name, want 0", n)
+	}
+}
+
+func TestIsSubdomainOf(t *testing.T) {
+	tests := []struct {
+		name, parent Name
+		want         bool
+	}{
+		{"example.com.", "example.com.", true},
+		{"www.example.com.", "example.com.", true},
+		{"notexample.com.", "example.com.", false},
+		{"example.com.", "www.example.com.", false},
+		{"example.com.", Root, true},
+		{Root, "com.", false},
+	}
+	for _, tt := range tests {
+		if got := tt.name.IsSubdomainOf(tt.parent); got != tt.want {
+			t.Errorf("%s.IsSubdomainOf(%s) = %v, want %v", tt.name, tt.parent, got, tt.want)
+		}
+	}
+}
+
+func TestParent(t *testing.T) {
+	var chain []Name
+	for n := Name("www.example.com."); n != Root; n = n.Parent() {
+		chain = append(chain, n)
+	}
+	if len(chain) != 3 || chain[2] != "com." || Root.Parent() != Root || Name("www.example.com.").Labels() != 3 || Root.Labels() != 0 {
+		t.Errorf("chain %v", chain)
+	}
+}
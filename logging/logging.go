@@ -0,0 +1,326 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bernoussama/mercury/privacy"
+)
+
+// modules with their own level override
+const (
+	Server    = "server"
+	Cache     = "cache"
+	Resolver  = "resolver"
+	Blocklist = "blocklist"
+	API       = "api"
+	Peer      = "peer"
+	MQTT      = "mqtt"
+	ACME      = "acme"
+)
+
+// Options configures where and what mercury logs
+type Options struct {
+	// Level is the default level: debug, info, warn or error
+	Level string `yaml:"level"`
+	// Format is text or json
+	Format string `yaml:"format"`
+	// Target is stderr, file, syslog or journald. It defaults to file
+	// when File is set and stderr otherwise.
+	Target string `yaml:"target"`
+	// File is appended to instead of stderr when set
+	File   string        `yaml:"file"`
+	Syslog SyslogOptions `yaml:"syslog"`
+	// Modules overrides Level per module
+	Modules map[string]string `yaml:"modules"`
+	// Instance is added to every record to tell the logs of replicas apart,
+	// set from the identity settings
+	Instance string `yaml:"-"`
+	// Privacy anonymizes the clients and query names of the modules
+	// answering queries, set from the privacy settings
+	Privacy privacy.Options `yaml:"-"`
+}
+
+var (
+	output  atomic.Pointer[slog.Handler]
+	private atomic.Pointer[privacy.Options]
+	mu      sync.Mutex
+	levels  = make(map[string]*slog.LevelVar)
+	base    = new(slog.LevelVar)
+	closer  io.Closer
+	// verbose holds the levels of the modules before ToggleVerbose turned
+	// them to debug, nil when they are as configured
+	verbose map[string]slog.Level
+	formats = map[string]bool{"": true, "text": true, "json": true}
+	targets = map[string]bool{"": true, "stderr": true, "file": true, "syslog": true, "journald": true}
+)
+
+func init() {
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	output.Store(&h)
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", level)
+	}
+	return l, nil
+}
+
+// Validate reports problems with the options without applying them
+func (o Options) Validate() []error {
+	var errs []error
+	if o.Level != "" {
+		if _, err := ParseLevel(o.Level); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if !formats[strings.ToLower(o.Format)] {
+		errs = append(errs, fmt.Errorf("unknown log format %q", o.Format))
+	}
+	if !targets[strings.ToLower(o.Target)] {
+		errs = append(errs, fmt.Errorf("unknown log target %q", o.Target))
+	}
+	if strings.EqualFold(o.Target, "file") && o.File == "" {
+		errs = append(errs, fmt.Errorf("log target file needs a file"))
+	}
+	if _, ok := facilities[strings.ToLower(o.Syslog.Facility)]; o.Syslog.Facility != "" && !ok {
+		errs = append(errs, fmt.Errorf("unknown syslog facility %q", o.Syslog.Facility))
+	}
+	for module, level := range o.Modules {
+		if _, err := ParseLevel(level); err != nil {
+			errs = append(errs, fmt.Errorf("module %s: %w", module, err))
+		}
+	}
+	return errs
+}
+
+// Setup applies the options to every module logger, including the ones
+// already handed out by For.
+func Setup(opts Options) error {
+	level := slog.LevelInfo
+	if opts.Level != "" {
+		l, err := ParseLevel(opts.Level)
+		if err != nil {
+			return err
+		}
+		level = l
+	}
+	overrides := make(map[string]slog.Level, len(opts.Modules))
+	for module, override := range opts.Modules {
+		l, err := ParseLevel(override)
+		if err != nil {
+			return fmt.Errorf("module %s: %w", module, err)
+		}
+		overrides[module] = l
+	}
+
+	format := strings.ToLower(opts.Format)
+	if !formats[format] {
+		return fmt.Errorf("unknown log format %q", opts.Format)
+	}
+	target := strings.ToLower(opts.Target)
+	if target == "" && opts.File != "" {
+		target = "file"
+	}
+
+	var h slog.Handler
+	var c io.Closer
+	switch target {
+	case "", "stderr":
+		h = newWriterHandler(os.Stderr, format)
+	case "file":
+		if opts.File == "" {
+			return fmt.Errorf("log target file needs a file")
+		}
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		h, c = newWriterHandler(f, format), f
+	case "syslog":
+		s, err := dialSyslog(opts.Syslog)
+		if err != nil {
+			return err
+		}
+		h, c = newSinkHandler(s, format), s
+	case "journald":
+		s, err := dialJournal(JournalSocket, opts.Syslog.Tag)
+		if err != nil {
+			return err
+		}
+		h, c = newSinkHandler(s, format), s
+	default:
+		return fmt.Errorf("unknown log target %q", opts.Target)
+	}
+	if opts.Instance != "" {
+		h = h.WithAttrs([]slog.Attr{slog.String("instance", opts.Instance)})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	base.Set(level)
+	for _, lv := range levels {
+		lv.Set(level)
+	}
+	for module, l := range overrides {
+		lv, ok := levels[module]
+		if !ok {
+			lv = new(slog.LevelVar)
+			levels[module] = lv
+		}
+		lv.Set(l)
+	}
+	verbose = nil
+	output.Store(&h)
+	private.Store(&opts.Privacy)
+	if closer != nil {
+		closer.Close()
+	}
+	closer = c
+	slog.SetDefault(slog.New(&moduleHandler{level: base}))
+	return nil
+}
+
+// ToggleVerbose turns every module to debug, or back to the levels they had
+// before, and reports whether they are now at debug
+func ToggleVerbose() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if verbose != nil {
+		base.Set(verbose[""])
+		for module, lv := range levels {
+			// modules created meanwhile get the base level
+			level, ok := verbose[module]
+			if !ok {
+				level = verbose[""]
+			}
+			lv.Set(level)
+		}
+		verbose = nil
+		return false
+	}
+	verbose = map[string]slog.Level{"": base.Level()}
+	base.Set(slog.LevelDebug)
+	for module, lv := range levels {
+		verbose[module] = lv.Level()
+		lv.Set(slog.LevelDebug)
+	}
+	return true
+}
+
+func newWriterHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// For returns the logger of module
+func For(module string) *slog.Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	lv, ok := levels[module]
+	if !ok {
+		lv = new(slog.LevelVar)
+		lv.Set(base.Level())
+		levels[module] = lv
+	}
+	return slog.New(&moduleHandler{module: module, level: lv}).With("module", module)
+}
+
+// queryModules log the clients and names of queries
+var queryModules = map[string]bool{Server: true, Cache: true, Resolver: true, Blocklist: true}
+
+// moduleHandler filters records by its module level and writes the rest to
+// the current output, so Setup takes effect on existing loggers.
+type moduleHandler struct {
+	module string
+	level  *slog.LevelVar
+	// ops replays With and WithGroup calls on the current output
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h *moduleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := *output.Load()
+	for _, op := range h.ops {
+		out = op(out)
+	}
+	if p := private.Load(); p != nil && p.Enabled() && queryModules[h.module] {
+		r = anonymize(r, *p)
+	}
+	return out.Handle(ctx, r)
+}
+
+// anonymize truncates the client addresses and hashes the query names of
+// r. Device names are left out with the addresses they would give away.
+func anonymize(r slog.Record, p privacy.Options) slog.Record {
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "client", "from":
+			if ip := attrIP(a.Value); ip != nil && p.TruncatesAddresses() {
+				a.Value = slog.StringValue(p.Address(ip))
+			}
+		case "device":
+			if p.TruncatesAddresses() {
+				return true
+			}
+		case "name":
+			a.Value = slog.StringValue(p.Name(a.Value.String()))
+		}
+		out.AddAttrs(a)
+		return true
+	})
+	return out
+}
+
+// attrIP returns the address in an attribute holding an IP address, an
+// address with a port or a net.Addr, nil for other values
+func attrIP(v slog.Value) net.IP {
+	var s string
+	switch a := v.Any().(type) {
+	case net.IP:
+		return a
+	case net.Addr:
+		s = a.String()
+	case string:
+		s = a
+	default:
+		return nil
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(s)
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithAttrs(attrs) })
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithGroup(name) })
+}
+
+func (h *moduleHandler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	return &moduleHandler{
+		module: h.module,
+		level:  h.level,
+		ops:    append(h.ops[:len(h.ops):len(h.ops)], op),
+	}
+}
@@ -0,0 +1,132 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bernoussama/mercury/privacy"
+)
+
+func TestSetupModuleLevels(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mercury.log")
+	cache := For(Cache)
+	server := For(Server)
+
+	err := Setup(Options{
+		Level:   "warn",
+		Format:  "json",
+		File:    file,
+		Modules: map[string]string{Cache: "debug"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Setup(Options{}) })
+
+	cache.Debug("cache hit", "name", "example.com.")
+	server.Info("dropped by level")
+	server.Warn("kept", "n", 1)
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2:\n%s", len(lines), data)
+	}
+	want := []struct{ module, msg, level string }{
+		{Cache, "cache hit", "DEBUG"},
+		{Server, "kept", "WARN"},
+	}
+	for i, line := range lines {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("line %d is not json: %v", i, err)
+		}
+		if record["module"] != want[i].module || record["msg"] != want[i].msg || record["level"] != want[i].level {
+			t.Errorf("line %d = %v, want %+v", i, record, want[i])
+		}
+	}
+}
+
+func TestToggleVerbose(t *testing.T) {
+	if err := Setup(Options{Level: "warn", Modules: map[string]string{Cache: "error"}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Setup(Options{}) })
+	ctx := context.Background()
+	cache, server := For(Cache), For(Server)
+
+	if !ToggleVerbose() {
+		t.Fatal("ToggleVerbose() = false, want debug on")
+	}
+	if !cache.Enabled(ctx, slog.LevelDebug) || !server.Enabled(ctx, slog.LevelDebug) {
+		t.Error("debug not enabled")
+	}
+	if ToggleVerbose() {
+		t.Fatal("ToggleVerbose() = true, want debug off")
+	}
+	if cache.Enabled(ctx, slog.LevelWarn) || !server.Enabled(ctx, slog.LevelWarn) || server.Enabled(ctx, slog.LevelInfo) {
+		t.Error("levels not restored")
+	}
+
+	// a reload while verbose applies the config levels
+	ToggleVerbose()
+	Setup(Options{Level: "warn"})
+	if server.Enabled(ctx, slog.LevelDebug) || !ToggleVerbose() {
+		t.Error("Setup did not end verbose logging")
+	}
+}
+
+func TestSetupPrivacy(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mercury.log")
+	blocklist := For(Blocklist)
+	peer := For(Peer)
+	err := Setup(Options{
+		Format:  "json",
+		File:    file,
+		Privacy: privacy.Options{IPv4Prefix: 24, HashNames: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Setup(Options{}) })
+
+	blocklist.Info("blocked query", "name", "ads.example.com.", "client", net.ParseIP("192.168.1.20"), "device", "laptop")
+	peer.Info("peering running", "name", "router", "from", "192.168.1.1:8053")
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), data)
+	}
+	var query, other map[string]any
+	json.Unmarshal([]byte(lines[0]), &query)
+	json.Unmarshal([]byte(lines[1]), &other)
+	if query["client"] != "192.168.1.0/24" || query["device"] != nil || !strings.HasPrefix(query["name"].(string), "h:") {
+		t.Errorf("query log not anonymized: %s", lines[0])
+	}
+	if other["name"] != "router" || other["from"] != "192.168.1.1:8053" {
+		t.Errorf("peer log anonymized: %s", lines[1])
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	opts := Options{Level: "loud", Format: "xml", Modules: map[string]string{Cache: "debug", Resolver: "chatty"}}
+	if errs := opts.Validate(); len(errs) != 3 {
+		t.Errorf("Validate() = %v, want 3 errors", errs)
+	}
+	if errs := (Options{Level: "debug", Format: "json"}).Validate(); len(errs) != 0 {
+		t.Errorf("Validate() = %v, want none", errs)
+	}
+}
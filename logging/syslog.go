@@ -0,0 +1,235 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournalSocket is where systemd-journald accepts native protocol messages
+const JournalSocket = "/run/systemd/journal/socket"
+
+// SyslogOptions configures the syslog target. An empty address logs to
+// the local syslog daemon, otherwise messages are sent to a remote
+// collector in RFC 5424 format.
+type SyslogOptions struct {
+	// Network is udp, tcp or unixgram
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+	// Facility is a name such as daemon, user or local0 to local7
+	Facility string `yaml:"facility"`
+	// Tag is the APP-NAME, mercury by default
+	Tag string `yaml:"tag"`
+}
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// priority maps a level to a syslog severity
+func priority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // err
+	case level >= slog.LevelWarn:
+		return 4 // warning
+	case level >= slog.LevelInfo:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
+
+// sink delivers one formatted record at the given level
+type sink interface {
+	send(level slog.Level, msg []byte) error
+	Close() error
+}
+
+// sinkHandler formats records with inner and hands each one to the sink
+// along with its level, so the target can map it to a priority.
+type sinkHandler struct {
+	inner slog.Handler
+	w     *recordWriter
+}
+
+// recordWriter receives the single Write slog handlers make per record
+type recordWriter struct {
+	mu    sync.Mutex
+	level slog.Level
+	sink  sink
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	return len(p), w.sink.send(w.level, bytes.TrimRight(p, "\n"))
+}
+
+func newSinkHandler(s sink, format string) slog.Handler {
+	w := &recordWriter{sink: s}
+	opts := &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		// the collector timestamps messages itself
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}
+	if format == "json" {
+		return &sinkHandler{inner: slog.NewJSONHandler(w, opts), w: w}
+	}
+	return &sinkHandler{inner: slog.NewTextHandler(w, opts), w: w}
+}
+
+func (h *sinkHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *sinkHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.w.mu.Lock()
+	defer h.w.mu.Unlock()
+	h.w.level = r.Level
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *sinkHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sinkHandler{inner: h.inner.WithAttrs(attrs), w: h.w}
+}
+
+func (h *sinkHandler) WithGroup(name string) slog.Handler {
+	return &sinkHandler{inner: h.inner.WithGroup(name), w: h.w}
+}
+
+// syslogSink writes RFC 5424 messages to a syslog daemon or collector
+type syslogSink struct {
+	conn     net.Conn
+	stream   bool
+	facility int
+	tag      string
+	hostname string
+}
+
+func dialSyslog(opts SyslogOptions) (*syslogSink, error) {
+	facility := facilities["daemon"]
+	if opts.Facility != "" {
+		f, ok := facilities[strings.ToLower(opts.Facility)]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog facility %q", opts.Facility)
+		}
+		facility = f
+	}
+	tag := opts.Tag
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	network, address := opts.Network, opts.Address
+	var conn net.Conn
+	var err error
+	if address == "" {
+		// local daemon, the socket type depends on the system
+		for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+			for _, network = range []string{"unixgram", "unix"} {
+				if conn, err = net.Dial(network, path); err == nil {
+					break
+				}
+			}
+			if err == nil {
+				break
+			}
+		}
+	} else {
+		if network == "" {
+			network = "udp"
+		}
+		conn, err = net.Dial(network, address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connecting to syslog: %w", err)
+	}
+	return &syslogSink{
+		conn:     conn,
+		stream:   network == "tcp" || network == "tcp4" || network == "tcp6" || network == "unix",
+		facility: facility,
+		tag:      tag,
+		hostname: hostname,
+	}, nil
+}
+
+func (s *syslogSink) send(level slog.Level, msg []byte) error {
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	line := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		s.facility*8+priority(level), time.Now().Format(time.RFC3339Nano), s.hostname, s.tag, os.Getpid(), msg)
+	if s.stream {
+		// octet counting framing, RFC 6587
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+	_, err := s.conn.Write([]byte(line))
+	return err
+}
+
+func (s *syslogSink) Close() error {
+	return s.conn.Close()
+}
+
+// journalSink writes to systemd-journald using its native protocol
+type journalSink struct {
+	conn *net.UnixConn
+	tag  string
+}
+
+func dialJournal(path, tag string) (*journalSink, error) {
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to journald: %w", err)
+	}
+	return &journalSink{conn: conn, tag: tag}, nil
+}
+
+func (s *journalSink) send(level slog.Level, msg []byte) error {
+	var buf bytes.Buffer
+	appendJournalField(&buf, "PRIORITY", []byte(fmt.Sprint(priority(level))))
+	appendJournalField(&buf, "SYSLOG_IDENTIFIER", []byte(s.tag))
+	appendJournalField(&buf, "MESSAGE", msg)
+	_, err := s.conn.Write(buf.Bytes())
+	return err
+}
+
+// appendJournalField encodes one field, switching to the length prefixed
+// form for values spanning several lines
+func appendJournalField(buf *bytes.Buffer, key string, value []byte) {
+	buf.WriteString(key)
+	if bytes.IndexByte(value, '\n') < 0 {
+		buf.WriteByte('=')
+		buf.Write(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.Write(value)
+	buf.WriteByte('\n')
+}
+
+func (s *journalSink) Close() error {
+	return s.conn.Close()
+}
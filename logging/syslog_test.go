@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogRemote(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := dialSyslog(SyslogOptions{Network: "udp", Address: conn.LocalAddr().String(), Facility: "local0", Tag: "mercury"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	logger := slog.New(newSinkHandler(s, "text")).With("module", Server)
+	logger.Warn("upstream down", "upstream", "192.0.2.1:53")
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// local0 (16) * 8 + warning (4)
+	if !strings.HasPrefix(msg, "<132>1 ") {
+		t.Errorf("message %q does not start with the RFC 5424 priority and version", msg)
+	}
+	for _, want := range []string{" mercury ", `msg="upstream down"`, "module=server", "upstream=192.0.2.1:53"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message %q does not contain %q", msg, want)
+		}
+	}
+	if strings.Contains(msg, "time=") {
+		t.Errorf("message %q repeats the timestamp", msg)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s, err := dialJournal(path, "mercury")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	slog.New(newSinkHandler(s, "text")).Error("line one\nline two")
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := buf[:n]
+	for _, want := range []string{"PRIORITY=3\n", "SYSLOG_IDENTIFIER=mercury\n", "MESSAGE="} {
+		if !bytes.Contains(msg, []byte(want)) {
+			t.Errorf("journal entry %q does not contain %q", msg, want)
+		}
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"github.com/bernoussama/mercury/buildinfo"
 	"github.com/bernoussama/mercury/cmd"
 )
 
+// set with -ldflags "-X main.version=... -X main.commit=... -X main.date=...",
+// which goreleaser does by default
+var version, commit, date string
+
 func main() {
+	buildinfo.Set(version, commit, date)
 	cmd.Execute()
 }
@@ -0,0 +1,170 @@
+package migrate
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"gopkg.in/yaml.v3"
+)
+
+// adguardConfig holds the settings of AdGuardHome.yaml that are imported
+type adguardConfig struct {
+	DNS struct {
+		UpstreamDNS []string `yaml:"upstream_dns"`
+		// Rewrites moved to filtering in later versions
+		Rewrites []adguardRewrite `yaml:"rewrites"`
+	} `yaml:"dns"`
+	Filtering struct {
+		Rewrites []adguardRewrite `yaml:"rewrites"`
+	} `yaml:"filtering"`
+	Filters          []adguardFilter `yaml:"filters"`
+	WhitelistFilters []adguardFilter `yaml:"whitelist_filters"`
+	UserRules        []string        `yaml:"user_rules"`
+	Clients          struct {
+		Persistent []adguardClient `yaml:"persistent"`
+	} `yaml:"clients"`
+}
+
+type adguardFilter struct {
+	Enabled bool   `yaml:"enabled"`
+	URL     string `yaml:"url"`
+	Name    string `yaml:"name"`
+}
+
+type adguardRewrite struct {
+	Domain string `yaml:"domain"`
+	Answer string `yaml:"answer"`
+}
+
+type adguardClient struct {
+	Name              string   `yaml:"name"`
+	IDs               []string `yaml:"ids"`
+	UseGlobalSettings bool     `yaml:"use_global_settings"`
+	FilteringEnabled  bool     `yaml:"filtering_enabled"`
+}
+
+// AdGuard reads the settings of an AdGuardHome.yaml file: plain DNS
+// upstreams, enabled filters, user rules blocking or allowing a domain,
+// rewrites to addresses and persistent clients. Clients with filtering
+// turned off become a group blocking nothing.
+func AdGuard(file string) (*Setup, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfg adguardConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	s := &Setup{}
+	for _, upstream := range cfg.DNS.UpstreamDNS {
+		upstream = strings.TrimSpace(upstream)
+		if upstream == "" || strings.HasPrefix(upstream, "#") {
+			continue
+		}
+		if !s.addUpstream(strings.TrimPrefix(upstream, "udp://")) {
+			s.skip("upstream %s: only plain DNS upstreams without a domain are supported", upstream)
+		}
+	}
+	for _, filter := range cfg.Filters {
+		if filter.Enabled {
+			s.Sources = append(s.Sources, filter.URL)
+		}
+	}
+	for _, filter := range cfg.WhitelistFilters {
+		if !filter.Enabled {
+			continue
+		}
+		if u, err := url.Parse(filter.URL); err == nil && u.Scheme != "" {
+			s.skip("allowlist %s: allowlists are files, download it and add it to allowlists", filter.URL)
+			continue
+		}
+		s.AllowSources = append(s.AllowSources, filter.URL)
+	}
+	for _, rule := range cfg.UserRules {
+		s.addRule(strings.TrimSpace(rule))
+	}
+	for _, rewrite := range append(cfg.DNS.Rewrites, cfg.Filtering.Rewrites...) {
+		if !s.addHost(rewrite.Domain, rewrite.Answer) {
+			s.skip("rewrite of %s to %s: only rewrites of a name to an address are supported", rewrite.Domain, rewrite.Answer)
+		}
+	}
+	for _, client := range cfg.Clients.Persistent {
+		s.addAdGuardClient(client)
+	}
+	if s.Empty() {
+		return s, errors.New("no AdGuard Home settings found in " + file)
+	}
+	return s, nil
+}
+
+// addRule adds a user rule blocking or allowing a domain and its
+// subdomains, ||example.com^ or @@||example.com^, or a hosts file line
+// sinkholing a domain
+func (s *Setup) addRule(rule string) {
+	if rule == "" || strings.HasPrefix(rule, "!") || strings.HasPrefix(rule, "#") {
+		return
+	}
+	if fields := strings.Fields(rule); len(fields) == 2 && net.ParseIP(fields[0]) != nil {
+		if ip := net.ParseIP(fields[0]); ip.IsUnspecified() || ip.IsLoopback() {
+			s.addDomain(&s.Blocked, fields[1], rule)
+		} else if !s.addHost(fields[1], fields[0]) {
+			s.skip("rule %s: not a name", rule)
+		}
+		return
+	}
+	list, domain := &s.Blocked, rule
+	if allowed, ok := strings.CutPrefix(rule, "@@"); ok {
+		list, domain = &s.Allowed, allowed
+	}
+	domain, ok := strings.CutSuffix(strings.TrimPrefix(domain, "||"), "^")
+	if !ok {
+		s.skip("rule %s: only rules of a whole domain are supported", rule)
+		return
+	}
+	s.addDomain(list, domain, rule)
+}
+
+func (s *Setup) addDomain(list *[]string, domain, rule string) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if !validName(domain) {
+		s.skip("rule %s: only rules of a whole domain are supported", rule)
+		return
+	}
+	*list = append(*list, domain)
+}
+
+// addAdGuardClient names a persistent client after its IP and MAC ids,
+// and puts it in a group of its own when its filtering is off
+func (s *Setup) addAdGuardClient(client adguardClient) {
+	var networks []string
+	for _, id := range client.IDs {
+		if _, _, err := net.ParseCIDR(id); err == nil {
+			networks = append(networks, id)
+			continue
+		}
+		if !s.addClient(id, client.Name) {
+			s.skip("client %s: id %s is no address, network or MAC", client.Name, id)
+			continue
+		}
+		if net.ParseIP(id) != nil {
+			networks = append(networks, id)
+		}
+	}
+	if client.UseGlobalSettings || client.FilteringEnabled {
+		return
+	}
+	if len(networks) == 0 {
+		s.skip("client %s: filtering off, but no address or network to group it by", client.Name)
+		return
+	}
+	s.Groups = append(s.Groups, blocklist.GroupOptions{
+		Name:     client.Name,
+		Clients:  networks,
+		Disabled: []string{blocklist.CategoryBlocklist},
+	})
+}
@@ -0,0 +1,136 @@
+// Package migrate reads the settings of Pi-hole and AdGuard Home that
+// mercury has an equivalent for: upstreams, blocklists, allowed and
+// blocked domains, local DNS records and clients.
+package migrate
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/dns"
+)
+
+// Setup is what an import found
+type Setup struct {
+	// Upstreams are host:port addresses queries are forwarded to
+	Upstreams []string
+	// Sources are the blocklist URLs and files for blocklist compile
+	Sources []string
+	// AllowSources are files of domains blocklist compile leaves out
+	AllowSources []string
+	// Blocked and Allowed are single domains blocked and never blocked
+	Blocked []string
+	Allowed []string
+	// Hosts are local names and their addresses
+	Hosts map[string][]string
+	// Clients names clients by MAC or IP address
+	Clients map[string]string
+	// Groups are the clients blocking nothing
+	Groups []blocklist.GroupOptions
+	// Skipped says what was left out and why
+	Skipped []string
+}
+
+// Empty reports whether nothing was imported
+func (s *Setup) Empty() bool {
+	return len(s.Upstreams) == 0 && len(s.Sources) == 0 && len(s.AllowSources) == 0 &&
+		len(s.Blocked) == 0 && len(s.Allowed) == 0 && len(s.Hosts) == 0 &&
+		len(s.Clients) == 0 && len(s.Groups) == 0
+}
+
+// Names returns the local names sorted
+func (s *Setup) Names() []string {
+	names := make([]string, 0, len(s.Hosts))
+	for name := range s.Hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Setup) skip(format string, args ...any) {
+	s.Skipped = append(s.Skipped, fmt.Sprintf(format, args...))
+}
+
+// addUpstream adds a name server given as an address with an optional
+// port, reporting whether it is one
+func (s *Setup) addUpstream(server string) bool {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host, port = strings.Trim(server, "[]"), "53"
+	}
+	if net.ParseIP(host) == nil {
+		return false
+	}
+	if address := net.JoinHostPort(host, port); !slices.Contains(s.Upstreams, address) {
+		s.Upstreams = append(s.Upstreams, address)
+	}
+	return true
+}
+
+// addHost adds a local name answered with address, reporting whether both
+// are valid
+func (s *Setup) addHost(name, address string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if net.ParseIP(address) == nil || !validName(name) {
+		return false
+	}
+	if s.Hosts == nil {
+		s.Hosts = make(map[string][]string)
+	}
+	if !slices.Contains(s.Hosts[name], address) {
+		s.Hosts[name] = append(s.Hosts[name], address)
+	}
+	return true
+}
+
+// addClient names a client by MAC or IP address, reporting whether it is
+// one
+func (s *Setup) addClient(address, name string) bool {
+	if _, err := net.ParseMAC(address); err != nil && net.ParseIP(address) == nil {
+		return false
+	}
+	if s.Clients == nil {
+		s.Clients = make(map[string]string)
+	}
+	s.Clients[strings.ToLower(address)] = name
+	return true
+}
+
+// validName reports whether name is a domain name that can be encoded
+func validName(name string) bool {
+	if name == "" || strings.ContainsAny(name, " */") {
+		return false
+	}
+	_, err := dns.EncodeDomainName(name + ".")
+	return err == nil
+}
+
+// readLines returns the lines of file that are neither empty nor
+// comments, nil when there is no such file
+func readLines(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,146 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bernoussama/mercury/blocklist"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPihole(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"pihole/setupVars.conf": "WEBPASSWORD=x\nPIHOLE_DNS_1=1.1.1.1\nPIHOLE_DNS_2=127.0.0.1#5335\nPIHOLE_DNS_3=\n",
+		"pihole/adlists.list":   "# ads\nhttps://lists.example.com/ads.txt\n\n",
+		"pihole/whitelist.txt":  "ok.example.com\n",
+		"pihole/blacklist.txt":  "bad.example.com\nnot a domain\n",
+		"pihole/regex.list":     `(^|\.)tracker\.`,
+		"pihole/custom.list":    "192.168.1.2 nas.lan NAS.home\nfd00::2 nas.lan\n",
+		"pihole/pihole.toml": `[dns]
+  upstreams = [
+    "9.9.9.9", # quad9
+    "1.1.1.1"
+  ]
+  hosts = [ "10.0.0.5 printer.lan" ]
+  cnameRecords = [ "www.lan,nas.lan" ]
+[webserver]
+  hosts = [ "10.0.0.6 other.lan" ]
+`,
+		"dnsmasq.d/05-pihole-custom-cname.conf": "cname=files.lan,nas.lan\n",
+	})
+
+	s, err := Pihole(filepath.Join(root, "pihole"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Setup{
+		Upstreams: []string{"1.1.1.1:53", "127.0.0.1:5335", "9.9.9.9:53"},
+		Sources:   []string{"https://lists.example.com/ads.txt"},
+		Blocked:   []string{"bad.example.com"},
+		Allowed:   []string{"ok.example.com"},
+		Hosts: map[string][]string{
+			"nas.lan":     {"192.168.1.2", "fd00::2"},
+			"nas.home":    {"192.168.1.2"},
+			"printer.lan": {"10.0.0.5"},
+		},
+	}
+	skipped := s.Skipped
+	s.Skipped = nil
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("Pihole() = %+v, want %+v", s, want)
+	}
+	// the blacklist line, regex.list and both CNAME files
+	if len(skipped) != 4 {
+		t.Errorf("skipped %q, want 4 notes", skipped)
+	}
+
+	if _, err := Pihole(t.TempDir()); err == nil {
+		t.Error("Pihole() of an empty directory succeeded")
+	}
+}
+
+func TestAdGuard(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"AdGuardHome.yaml": `dns:
+  upstream_dns:
+    - 8.8.8.8
+    - udp://9.9.9.9:5353
+    - https://dns.example.com/dns-query
+    - '# comment'
+filters:
+  - enabled: true
+    url: https://lists.example.com/ads.txt
+  - enabled: false
+    url: https://lists.example.com/off.txt
+whitelist_filters:
+  - enabled: true
+    url: /opt/AdGuardHome/allow.txt
+  - enabled: true
+    url: https://lists.example.com/allow.txt
+user_rules:
+  - '! comment'
+  - '||ads.example.com^'
+  - '@@||cdn.example.com^'
+  - 0.0.0.0 track.example.com
+  - 192.168.1.3 tv.lan
+  - /regex/
+filtering:
+  rewrites:
+    - domain: nas.lan
+      answer: 192.168.1.2
+    - domain: www.lan
+      answer: nas.lan
+clients:
+  persistent:
+    - name: kids
+      ids: [192.168.1.20, aa:bb:cc:dd:ee:ff]
+      use_global_settings: true
+      filtering_enabled: true
+    - name: work
+      ids: [10.0.0.0/24, laptop]
+      use_global_settings: false
+      filtering_enabled: false
+`})
+
+	s, err := AdGuard(filepath.Join(dir, "AdGuardHome.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Setup{
+		Upstreams:    []string{"8.8.8.8:53", "9.9.9.9:5353"},
+		Sources:      []string{"https://lists.example.com/ads.txt"},
+		AllowSources: []string{"/opt/AdGuardHome/allow.txt"},
+		Blocked:      []string{"ads.example.com", "track.example.com"},
+		Allowed:      []string{"cdn.example.com"},
+		Hosts:        map[string][]string{"tv.lan": {"192.168.1.3"}, "nas.lan": {"192.168.1.2"}},
+		Clients:      map[string]string{"192.168.1.20": "kids", "aa:bb:cc:dd:ee:ff": "kids"},
+		Groups: []blocklist.GroupOptions{
+			{Name: "work", Clients: []string{"10.0.0.0/24"}, Disabled: []string{blocklist.CategoryBlocklist}},
+		},
+	}
+	skipped := s.Skipped
+	s.Skipped = nil
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("AdGuard() = %+v, want %+v", s, want)
+	}
+	// the DoH upstream, the remote allowlist, the regex rule, the CNAME
+	// rewrite and the laptop id
+	if len(skipped) != 5 {
+		t.Errorf("skipped %q, want 5 notes", skipped)
+	}
+}
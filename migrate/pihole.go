@@ -0,0 +1,162 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Pihole reads the settings of a Pi-hole config directory like /etc/pihole:
+// the upstreams of setupVars.conf or pihole.toml, adlists.list,
+// whitelist.txt, blacklist.txt and the local records of custom.list or
+// pihole.toml. Pi-hole 5 and later keep lists, domains and groups in the
+// SQLite database gravity.db, which is not read.
+func Pihole(dir string) (*Setup, error) {
+	if info, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	s := &Setup{}
+	vars, err := readLines(filepath.Join(dir, "setupVars.conf"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range vars {
+		key, value, _ := strings.Cut(line, "=")
+		if !strings.HasPrefix(key, "PIHOLE_DNS_") || value == "" {
+			continue
+		}
+		// Pi-hole writes ports after a #
+		if host, port, ok := strings.Cut(value, "#"); ok {
+			value = "[" + host + "]:" + port
+		}
+		if !s.addUpstream(value) {
+			s.skip("upstream %s: not an address", value)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "pihole.toml")); err == nil {
+		s.readToml(string(data))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	lists := []struct {
+		file string
+		add  *[]string
+	}{
+		{"adlists.list", &s.Sources},
+		{"whitelist.txt", &s.Allowed},
+		{"blacklist.txt", &s.Blocked},
+	}
+	for _, list := range lists {
+		lines, err := readLines(filepath.Join(dir, list.file))
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			if list.add != &s.Sources && !validName(strings.ToLower(line)) {
+				s.skip("%s: %q is not a domain", list.file, line)
+				continue
+			}
+			*list.add = append(*list.add, line)
+		}
+	}
+	for _, file := range []string{"regex.list", "regex_whitelist.list"} {
+		if lines, err := readLines(filepath.Join(dir, file)); err != nil {
+			return nil, err
+		} else if len(lines) > 0 {
+			s.skip("%s: %d regular expressions, mercury matches domains and their subdomains", file, len(lines))
+		}
+	}
+
+	hosts, err := readLines(filepath.Join(dir, "custom.list"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range hosts {
+		s.addHostsLine("custom.list", line)
+	}
+	cnames, err := readLines(filepath.Join(dir, "..", "dnsmasq.d", "05-pihole-custom-cname.conf"))
+	if err != nil {
+		return nil, err
+	}
+	if len(cnames) > 0 {
+		s.skip("05-pihole-custom-cname.conf: %d CNAME records, zones hold no CNAME records", len(cnames))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gravity.db")); err == nil {
+		s.skip("gravity.db: the adlists, domains and groups of the database are not read")
+	}
+	if s.Empty() {
+		return s, errors.New("no Pi-hole settings found in " + dir)
+	}
+	return s, nil
+}
+
+// addHostsLine adds the names of a hosts file line, an address and names
+func (s *Setup) addHostsLine(file, line string) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		s.skip("%s: %q is no address and name", file, line)
+		return
+	}
+	for _, name := range fields[1:] {
+		if !s.addHost(name, fields[0]) {
+			s.skip("%s: %q is no address and name", file, line)
+			return
+		}
+	}
+}
+
+// readToml reads the upstreams and local records of the pihole.toml of
+// Pi-hole 6
+func (s *Setup) readToml(data string) {
+	for _, upstream := range tomlStrings(data, "dns", "upstreams") {
+		if host, port, ok := strings.Cut(upstream, "#"); ok {
+			upstream = "[" + host + "]:" + port
+		}
+		if !s.addUpstream(upstream) {
+			s.skip("upstream %s: not an address", upstream)
+		}
+	}
+	for _, line := range tomlStrings(data, "dns", "hosts") {
+		s.addHostsLine("pihole.toml", line)
+	}
+	if cnames := tomlStrings(data, "dns", "cnameRecords"); len(cnames) > 0 {
+		s.skip("pihole.toml: %d CNAME records, zones hold no CNAME records", len(cnames))
+	}
+}
+
+var tomlString = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+
+// tomlStrings returns the strings of the array key of section in a TOML
+// document, enough of TOML for the arrays of pihole.toml
+func tomlStrings(data, section, key string) []string {
+	var values []string
+	current, collecting := "", false
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if !collecting {
+			if strings.HasPrefix(line, "[") {
+				current, _, _ = strings.Cut(strings.TrimPrefix(line, "["), "]")
+				continue
+			}
+			name, value, ok := strings.Cut(line, "=")
+			if current != section || !ok || strings.TrimSpace(name) != key {
+				continue
+			}
+			collecting, line = true, value
+		}
+		for _, match := range tomlString.FindAllStringSubmatch(line, -1) {
+			values = append(values, match[1])
+		}
+		rest, _, _ := strings.Cut(tomlString.ReplaceAllString(line, ""), "#")
+		if strings.Contains(rest, "]") {
+			return values
+		}
+	}
+	return values
+}
@@ -0,0 +1,368 @@
+// Package mqtt publishes the stats of the server to an MQTT broker and
+// takes commands turning blocking on and off, with the discovery messages
+// that make the server a device of Home Assistant
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/bernoussama/mercury/buildinfo"
+	"github.com/bernoussama/mercury/logging"
+)
+
+var mqttLog = logging.For(logging.MQTT)
+
+// defaults of the options
+const (
+	DefaultDiscoveryPrefix = "homeassistant"
+	DefaultInterval        = 30 * time.Second
+)
+
+// payloads of the blocking switch and the availability topic
+const (
+	On      = "ON"
+	Off     = "OFF"
+	online  = "online"
+	offline = "offline"
+)
+
+// timeouts of the exchanges with the broker
+const (
+	dialTimeout  = 10 * time.Second
+	writeTimeout = 10 * time.Second
+	maxBackoff   = time.Minute
+)
+
+// Options configures publishing to an MQTT broker
+type Options struct {
+	// Broker is the host:port of the broker, empty to publish nothing
+	Broker   string `yaml:"broker"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// TLS connects to the broker over TLS
+	TLS bool `yaml:"tls"`
+	// Topic prefixes the topics of the server, mercury/<instance> by
+	// default
+	Topic string `yaml:"topic"`
+	// DiscoveryPrefix is where Home Assistant reads discovery messages
+	DiscoveryPrefix string `yaml:"discovery_prefix"`
+	// Interval is how often the stats are published
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Validate reports problems with the options without applying them
+func (o Options) Validate() []error {
+	if o.Broker == "" {
+		return nil
+	}
+	var errs []error
+	if _, _, err := net.SplitHostPort(o.Broker); err != nil {
+		errs = append(errs, fmt.Errorf("invalid broker address %q, want host:port", o.Broker))
+	}
+	for _, topic := range []string{o.Topic, o.DiscoveryPrefix} {
+		if strings.ContainsAny(topic, "#+") || strings.HasSuffix(topic, "/") {
+			errs = append(errs, fmt.Errorf("invalid topic %q, without wildcards or a trailing /", topic))
+		}
+	}
+	if o.Interval != 0 && (o.Interval < time.Second || o.Interval > time.Hour) {
+		errs = append(errs, fmt.Errorf("interval must be between 1s and 1h, got %s", o.Interval))
+	}
+	if o.Password != "" && o.Username == "" {
+		errs = append(errs, errors.New("password needs a username"))
+	}
+	return errs
+}
+
+func (o Options) interval() time.Duration {
+	if o.Interval == 0 {
+		return DefaultInterval
+	}
+	return o.Interval
+}
+
+// Stats are the numbers published
+type Stats struct {
+	Queries        uint64  `json:"queries"`
+	Blocked        uint64  `json:"blocked"`
+	BlockedPercent float64 `json:"blocked_percent"`
+	BlockedDomains int     `json:"blocked_domains"`
+	CacheHits      uint64  `json:"cache_hits"`
+	Clients        int     `json:"clients"`
+	// Blocking is the state of the switch, published on its own topic
+	Blocking bool `json:"-"`
+}
+
+// Client keeps a connection to the broker, publishing the stats every
+// interval and applying the commands of the blocking switch
+type Client struct {
+	opts Options
+	// node identifies the server in topics and Home Assistant
+	node        string
+	topic       string
+	discovery   string
+	stats       func() Stats
+	setBlocking func(bool)
+}
+
+// New returns a client publishing the stats of the server labeled
+// instance, turning blocking on and off with setBlocking
+func New(opts Options, instance string, stats func() Stats, setBlocking func(bool)) *Client {
+	c := &Client{
+		opts:        opts,
+		node:        nodeID(instance),
+		topic:       opts.Topic,
+		discovery:   opts.DiscoveryPrefix,
+		stats:       stats,
+		setBlocking: setBlocking,
+	}
+	if c.topic == "" {
+		c.topic = "mercury/" + c.node
+	}
+	if c.discovery == "" {
+		c.discovery = DefaultDiscoveryPrefix
+	}
+	return c
+}
+
+// nodeID returns instance with the characters Home Assistant does not
+// take in discovery topics replaced
+func nodeID(instance string) string {
+	id := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, instance)
+	if id == "" {
+		return "mercury"
+	}
+	return id
+}
+
+// Run connects to the broker and publishes until ctx is done, connecting
+// again with a growing backoff when the connection fails
+func (c *Client) Run(ctx context.Context) {
+	backoff, lastErr := time.Second, ""
+	for {
+		connected, err := c.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+		// only a new failure is logged, not every retry
+		if msg := err.Error(); msg != lastErr {
+			mqttLog.Warn("MQTT connection failed", "broker", c.opts.Broker, "err", err)
+			lastErr = msg
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// session connects to the broker and publishes until the connection fails
+// or ctx is done, reporting whether the broker accepted the connection
+func (c *Client) session(ctx context.Context) (bool, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	interval := c.opts.interval()
+	// the stats published every interval keep the connection alive
+	keepAlive := uint16(2 * interval / time.Second)
+	availability := c.topic + "/availability"
+	r := bufio.NewReader(conn)
+	if err := c.write(conn, connectPacket("mercury-"+c.node, c.opts.Username, c.opts.Password, keepAlive, will{availability, offline})); err != nil {
+		return false, err
+	}
+	conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	ack, err := readPacket(r)
+	if err != nil {
+		return false, err
+	}
+	if err := connackError(ack); err != nil {
+		return false, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	mqttLog.Info("connected to MQTT broker", "broker", c.opts.Broker, "topic", c.topic)
+
+	command := c.topic + "/blocking/set"
+	for _, p := range c.discoveryPackets() {
+		if err := c.write(conn, p); err != nil {
+			return true, err
+		}
+	}
+	if err := c.write(conn, publishPacket(availability, []byte(online), true)); err != nil {
+		return true, err
+	}
+	if err := c.write(conn, subscribePacket(1, command)); err != nil {
+		return true, err
+	}
+	if err := c.publishStats(conn); err != nil {
+		return true, err
+	}
+
+	packets, errc, done := make(chan packet), make(chan error, 1), make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			p, err := readPacket(r)
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case packets <- p:
+			case <-done:
+				return
+			}
+		}
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.write(conn, publishPacket(availability, []byte(offline), true))
+			c.write(conn, packet{header: typeDisconnect << 4})
+			return true, ctx.Err()
+		case err := <-errc:
+			return true, err
+		case p := <-packets:
+			if p.kind() != typePublish {
+				continue
+			}
+			topic, payload, err := decodePublish(p)
+			if err != nil {
+				return true, err
+			}
+			if topic != command {
+				continue
+			}
+			switch strings.ToUpper(strings.TrimSpace(string(payload))) {
+			case On:
+				c.setBlocking(true)
+			case Off:
+				c.setBlocking(false)
+			default:
+				mqttLog.Warn("unknown blocking command", "payload", string(payload))
+				continue
+			}
+			mqttLog.Info("blocking set over MQTT", "blocking", string(payload))
+			if err := c.publishStats(conn); err != nil {
+				return true, err
+			}
+		case <-ticker.C:
+			if err := c.publishStats(conn); err != nil {
+				return true, err
+			}
+		}
+	}
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if !c.opts.TLS {
+		return dialer.DialContext(ctx, "tcp", c.opts.Broker)
+	}
+	host, _, _ := net.SplitHostPort(c.opts.Broker)
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}
+	return tlsDialer.DialContext(ctx, "tcp", c.opts.Broker)
+}
+
+func (c *Client) write(conn net.Conn, p packet) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := conn.Write(p.encode())
+	return err
+}
+
+// publishStats publishes the stats and the state of the blocking switch
+func (c *Client) publishStats(conn net.Conn) error {
+	stats := c.stats()
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	if err := c.write(conn, publishPacket(c.topic+"/state", data, false)); err != nil {
+		return err
+	}
+	state := Off
+	if stats.Blocking {
+		state = On
+	}
+	return c.write(conn, publishPacket(c.topic+"/blocking", []byte(state), true))
+}
+
+// sensors are the stats Home Assistant shows, by their key in the state
+var sensors = []struct {
+	key, name, unit, class, icon string
+}{
+	{"queries", "Queries", "queries", "total_increasing", "mdi:dns"},
+	{"blocked", "Blocked queries", "queries", "total_increasing", "mdi:shield-check"},
+	{"blocked_percent", "Blocked percentage", "%", "measurement", "mdi:percent"},
+	{"blocked_domains", "Blocked domains", "domains", "measurement", "mdi:format-list-bulleted"},
+	{"cache_hits", "Cache hits", "queries", "total_increasing", "mdi:cached"},
+	{"clients", "Clients", "clients", "measurement", "mdi:devices"},
+}
+
+// discoveryPackets returns the retained discovery messages of the sensors
+// and the blocking switch
+func (c *Client) discoveryPackets() []packet {
+	device := map[string]any{
+		"identifiers":  []string{"mercury_" + c.node},
+		"name":         "Mercury " + c.node,
+		"manufacturer": "mercury",
+		"model":        "DNS server",
+		"sw_version":   buildinfo.Get().Version,
+	}
+	availability := c.topic + "/availability"
+	var packets []packet
+	for _, s := range sensors {
+		config := map[string]any{
+			"name":                s.name,
+			"unique_id":           "mercury_" + c.node + "_" + s.key,
+			"object_id":           "mercury_" + c.node + "_" + s.key,
+			"state_topic":         c.topic + "/state",
+			"value_template":      "{{ value_json." + s.key + " }}",
+			"unit_of_measurement": s.unit,
+			"state_class":         s.class,
+			"icon":                s.icon,
+			"availability_topic":  availability,
+			"device":              device,
+		}
+		packets = append(packets, c.discoveryPacket("sensor", s.key, config))
+	}
+	packets = append(packets, c.discoveryPacket("switch", "blocking", map[string]any{
+		"name":               "Blocking",
+		"unique_id":          "mercury_" + c.node + "_blocking",
+		"object_id":          "mercury_" + c.node + "_blocking",
+		"state_topic":        c.topic + "/blocking",
+		"command_topic":      c.topic + "/blocking/set",
+		"payload_on":         On,
+		"payload_off":        Off,
+		"icon":               "mdi:shield",
+		"availability_topic": availability,
+		"device":             device,
+	}))
+	return packets
+}
+
+func (c *Client) discoveryPacket(component, key string, config map[string]any) packet {
+	data, _ := json.Marshal(config)
+	return publishPacket(c.discovery+"/"+component+"/"+c.node+"/"+key+"/config", data, true)
+}
@@ -0,0 +1,144 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		errs int
+	}{
+		{"disabled", Options{}, 0},
+		{"broker", Options{Broker: "10.0.0.2:1883", Username: "dns", Password: "s3cret"}, 0},
+		{"no port", Options{Broker: "10.0.0.2"}, 1},
+		{"wildcard topic", Options{Broker: "10.0.0.2:1883", Topic: "mercury/#"}, 1},
+		{"short interval", Options{Broker: "10.0.0.2:1883", Interval: time.Millisecond}, 1},
+		{"password alone", Options{Broker: "10.0.0.2:1883", Password: "s3cret"}, 1},
+	}
+	for _, tt := range tests {
+		if errs := tt.opts.Validate(); len(errs) != tt.errs {
+			t.Errorf("%s: errors %v, want %d", tt.name, errs, tt.errs)
+		}
+	}
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	payload := []byte(strings.Repeat("x", 300))
+	p := publishPacket("mercury/dns1/state", payload, true)
+	read, err := readPacket(bufio.NewReader(strings.NewReader(string(p.encode()))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic, got, err := decodePublish(read)
+	if err != nil || topic != "mercury/dns1/state" || string(got) != string(payload) || read.header&0x01 == 0 {
+		t.Errorf("decoded %q %d bytes, header %x, err %v", topic, len(got), read.header, err)
+	}
+}
+
+// broker accepts one connection, records the topics published to it and
+// sends it an OFF command once it subscribed
+type broker struct {
+	ln        net.Listener
+	published chan [2]string
+}
+
+func newBroker(t *testing.T) *broker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return &broker{ln: ln, published: make(chan [2]string, 64)}
+}
+
+func (b *broker) serve(t *testing.T, command string) {
+	conn, err := b.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	connect, err := readPacket(r)
+	if err != nil || connect.kind() != typeConnect || !strings.Contains(string(connect.body), "mercury-dns_1") {
+		t.Errorf("connect %q, err %v", connect.body, err)
+		return
+	}
+	conn.Write(packet{header: typeConnack << 4, body: []byte{0, 0}}.encode())
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			close(b.published)
+			return
+		}
+		switch p.kind() {
+		case typeSubscribe:
+			conn.Write(publishPacket(command, []byte("OFF"), false).encode())
+		case typePublish:
+			topic, payload, _ := decodePublish(p)
+			b.published <- [2]string{topic, string(payload)}
+		case typeDisconnect:
+			close(b.published)
+			return
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	b := newBroker(t)
+	var blocking atomic.Bool
+	blocking.Store(true)
+	c := New(Options{Broker: b.ln.Addr().String()}, "dns 1", func() Stats {
+		return Stats{Queries: 10, Blocked: 2, Blocking: blocking.Load()}
+	}, blocking.Store)
+	go b.serve(t, c.topic+"/blocking/set")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	last := map[string]string{}
+	timeout := time.After(5 * time.Second)
+	for last["mercury/dns_1/blocking"] != Off {
+		select {
+		case msg := <-b.published:
+			last[msg[0]] = msg[1]
+		case <-timeout:
+			t.Fatalf("blocking never turned off, published %v", last)
+		}
+	}
+	if blocking.Load() {
+		t.Error("blocking still on after the OFF command")
+	}
+	var stats Stats
+	if err := json.Unmarshal([]byte(last["mercury/dns_1/state"]), &stats); err != nil || stats.Queries != 10 {
+		t.Errorf("state %q, err %v", last["mercury/dns_1/state"], err)
+	}
+	var config map[string]any
+	if err := json.Unmarshal([]byte(last["homeassistant/switch/dns_1/blocking/config"]), &config); err != nil || config["command_topic"] != "mercury/dns_1/blocking/set" {
+		t.Errorf("switch discovery %q, err %v", last["homeassistant/switch/dns_1/blocking/config"], err)
+	}
+	if last["mercury/dns_1/availability"] != online {
+		t.Errorf("availability %q, want online", last["mercury/dns_1/availability"])
+	}
+
+	cancel()
+	for msg := range b.published {
+		last[msg[0]] = msg[1]
+	}
+	<-done
+	if last["mercury/dns_1/availability"] != offline {
+		t.Errorf("availability %q after stopping, want offline", last["mercury/dns_1/availability"])
+	}
+}
@@ -0,0 +1,195 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// control packet types of MQTT 3.1.1, in the high nibble of the first byte
+const (
+	typeConnect    = 1
+	typeConnack    = 2
+	typePublish    = 3
+	typeSubscribe  = 8
+	typeDisconnect = 14
+)
+
+// flags of the CONNECT packet
+const (
+	flagCleanSession = 0x02
+	flagWill         = 0x04
+	flagWillRetain   = 0x20
+	flagPassword     = 0x40
+	flagUsername     = 0x80
+)
+
+// maxPacket bounds the packets read from the broker, the commands are a few
+// bytes
+const maxPacket = 64 << 10
+
+// packet is a control packet with its first byte and the bytes after the
+// remaining length
+type packet struct {
+	header byte
+	body   []byte
+}
+
+func (p packet) kind() byte {
+	return p.header >> 4
+}
+
+// encode returns the packet on the wire
+func (p packet) encode() []byte {
+	b := []byte{p.header}
+	n := len(p.body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(b, p.body...)
+}
+
+// readPacket reads a control packet from r
+func readPacket(r *bufio.Reader) (packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	n, shift := 0, 0
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, errors.New("malformed remaining length")
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		n |= int(digit&0x7f) << shift
+		shift += 7
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	if n > maxPacket {
+		return packet{}, fmt.Errorf("packet of %d bytes is too large", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{header: header, body: body}, nil
+}
+
+// appendString appends s prefixed with its length
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// readString returns the length-prefixed string at the start of b and the
+// bytes after it
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+// will is the message the broker publishes when the connection is lost
+type will struct {
+	topic   string
+	payload string
+}
+
+// connectPacket returns the CONNECT of a clean session
+func connectPacket(clientID, username, password string, keepAlive uint16, w will) packet {
+	flags := byte(flagCleanSession | flagWill | flagWillRetain)
+	if username != "" {
+		flags |= flagUsername
+	}
+	if password != "" {
+		flags |= flagPassword
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = binary.BigEndian.AppendUint16(body, keepAlive)
+	body = appendString(body, clientID)
+	body = appendString(body, w.topic)
+	body = appendString(body, w.payload)
+	if username != "" {
+		body = appendString(body, username)
+	}
+	if password != "" {
+		body = appendString(body, password)
+	}
+	return packet{header: typeConnect << 4, body: body}
+}
+
+// publishPacket returns a PUBLISH at QoS 0
+func publishPacket(topic string, payload []byte, retain bool) packet {
+	header := byte(typePublish << 4)
+	if retain {
+		header |= 0x01
+	}
+	return packet{header: header, body: append(appendString(nil, topic), payload...)}
+}
+
+// subscribePacket returns a SUBSCRIBE to topic at QoS 0
+func subscribePacket(id uint16, topic string) packet {
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = append(appendString(body, topic), 0)
+	return packet{header: typeSubscribe<<4 | 0x02, body: body}
+}
+
+// decodePublish returns the topic and payload of a PUBLISH
+func decodePublish(p packet) (string, []byte, error) {
+	topic, rest, err := readString(p.body)
+	if err != nil {
+		return "", nil, err
+	}
+	// QoS 1 and 2 carry a packet identifier
+	if p.header&0x06 != 0 {
+		if len(rest) < 2 {
+			return "", nil, io.ErrUnexpectedEOF
+		}
+		rest = rest[2:]
+	}
+	return topic, rest, nil
+}
+
+// connackError returns the error of a CONNACK return code, nil when the
+// connection was accepted
+func connackError(p packet) error {
+	if p.kind() != typeConnack || len(p.body) != 2 {
+		return errors.New("broker did not acknowledge the connection")
+	}
+	switch p.body[1] {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("broker refused the protocol version")
+	case 2:
+		return errors.New("broker refused the client identifier")
+	case 3:
+		return errors.New("broker unavailable")
+	case 4:
+		return errors.New("broker refused the username or password")
+	case 5:
+		return errors.New("not authorized by the broker")
+	}
+	return fmt.Errorf("broker refused the connection with code %d", p.body[1])
+}
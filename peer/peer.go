@@ -0,0 +1,459 @@
+// Package peer shares the runtime state of the server with other instances,
+// so a failover resolver blocks, answers and resolves like the primary
+package peer
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/dns"
+	"github.com/bernoussama/mercury/logging"
+)
+
+var peerLog = logging.For(logging.Peer)
+
+// DefaultInterval is how often the full state is sent when no interval is
+// configured
+const DefaultInterval = time.Minute
+
+// limits of one exchange
+const (
+	maxBatch = 256
+	maxBody  = 32 << 20
+	// queueSize events wait for a peer before new ones are dropped, to be
+	// repaired by the next full sync
+	queueSize = 4096
+)
+
+// paths of the peer endpoints
+const (
+	eventsPath = "/peer/events"
+	statePath  = "/peer/state"
+)
+
+// Options configures state sync with other instances
+type Options struct {
+	// Listen is the address peers send their state to, empty to receive
+	// none
+	Listen string `yaml:"listen"`
+	// Peers are the base URLs of the other instances, like
+	// http://10.0.0.3:53190
+	Peers []string `yaml:"peers"`
+	// Secret authenticates the instances to each other, the same for all
+	Secret string `yaml:"secret"`
+	// Name identifies the instance to its peers, the instance label by
+	// default
+	Name string `yaml:"name"`
+	// Cache also shares cached answers
+	Cache bool `yaml:"cache"`
+	// Interval is how often the full state is sent, repairing what was
+	// missed
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Validate reports problems with the options without applying them
+func (o Options) Validate() []error {
+	var errs []error
+	if o.Listen == "" && len(o.Peers) == 0 {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(o.Listen); o.Listen != "" && err != nil {
+		errs = append(errs, fmt.Errorf("invalid listen address %q: %v", o.Listen, err))
+	}
+	for _, peer := range o.Peers {
+		if u, err := url.Parse(peer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid peer URL %q, want http://host:port", peer))
+		}
+	}
+	if len(o.Secret) < 16 {
+		errs = append(errs, errors.New("secret must be at least 16 characters"))
+	}
+	if o.Interval < 0 {
+		errs = append(errs, fmt.Errorf("interval must not be negative, got %s", o.Interval))
+	}
+	return errs
+}
+
+func (o Options) name() string {
+	if o.Name != "" {
+		return o.Name
+	}
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return o.Listen
+}
+
+func (o Options) interval() time.Duration {
+	if o.Interval == 0 {
+		return DefaultInterval
+	}
+	return o.Interval
+}
+
+// kinds of events
+const (
+	KindEdit  = "edit"
+	KindHosts = "hosts"
+	KindCache = "cache"
+)
+
+// Event is a piece of state an instance shares
+type Event struct {
+	Kind string `json:"kind"`
+	// From names the instance the event comes from
+	From string          `json:"from"`
+	Edit *blocklist.Edit `json:"edit,omitempty"`
+	// Hosts are all the hosts the instance registered from its leases
+	Hosts map[string][]net.IP `json:"hosts,omitempty"`
+	Cache *CacheEntry         `json:"cache,omitempty"`
+}
+
+// CacheEntry is a cached answer with its remaining TTL
+type CacheEntry struct {
+	Key     string      `json:"key"`
+	Message dns.Message `json:"-"`
+	TTL     uint32      `json:"ttl"`
+}
+
+// cacheEntryJSON is the form a CacheEntry travels in. The JSON form of
+// dns.Message is meant for people, so the message is sent field by field
+// in the shape earlier versions used.
+type cacheEntryJSON struct {
+	Key     string        `json:"key"`
+	Message cachedMessage `json:"message"`
+	TTL     uint32        `json:"ttl"`
+}
+
+type cachedMessage struct {
+	Question   cachedQuestion
+	Answers    []cachedRecord
+	Authority  []cachedRecord
+	Additional []cachedRecord
+}
+
+type cachedQuestion struct {
+	DomainName string
+	QType      dns.QType
+	QClass     uint16
+}
+
+type cachedRecord struct {
+	RData    []byte
+	Name     []byte
+	TTL      uint32
+	Type     uint16
+	Class    uint16
+	RDLength uint16
+}
+
+func (e CacheEntry) MarshalJSON() ([]byte, error) {
+	q := e.Message.Question
+	return json.Marshal(cacheEntryJSON{
+		Key: e.Key,
+		Message: cachedMessage{
+			Question:   cachedQuestion{DomainName: q.DomainName, QType: q.QType, QClass: q.QClass},
+			Answers:    toCached(e.Message.Answers),
+			Authority:  toCached(e.Message.Authority),
+			Additional: toCached(e.Message.Additional),
+		},
+		TTL: e.TTL,
+	})
+}
+
+func (e *CacheEntry) UnmarshalJSON(data []byte) error {
+	var v cacheEntryJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	q := v.Message.Question
+	*e = CacheEntry{
+		Key: v.Key,
+		Message: dns.Message{
+			Question:   dns.Question{DomainName: q.DomainName, QType: q.QType, QClass: q.QClass},
+			Answers:    fromCached(v.Message.Answers),
+			Authority:  fromCached(v.Message.Authority),
+			Additional: fromCached(v.Message.Additional),
+		},
+		TTL: v.TTL,
+	}
+	return nil
+}
+
+func toCached(answers []dns.Answer) []cachedRecord {
+	if answers == nil {
+		return nil
+	}
+	recs := make([]cachedRecord, len(answers))
+	for i, a := range answers {
+		recs[i] = cachedRecord{RData: a.RData, Name: a.Name, TTL: a.TTL, Type: a.Type, Class: a.Class, RDLength: a.RDLength}
+	}
+	return recs
+}
+
+func fromCached(recs []cachedRecord) []dns.Answer {
+	if recs == nil {
+		return nil
+	}
+	answers := make([]dns.Answer, len(recs))
+	for i, r := range recs {
+		answers[i] = dns.Answer{RData: r.RData, Name: r.Name, TTL: r.TTL, Type: r.Type, Class: r.Class, RDLength: r.RDLength}
+	}
+	return answers
+}
+
+// Stats describes the exchanges with one peer
+type Stats struct {
+	URL  string `json:"url"`
+	Sent uint64 `json:"sent"`
+	// Dropped counts the events not sent as the peer lagged
+	Dropped  uint64     `json:"dropped"`
+	LastSync *time.Time `json:"last_sync,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Node sends the events of the instance to its peers and applies theirs.
+// A nil Node publishes nothing.
+type Node struct {
+	opts   Options
+	name   string
+	apply  func(Event) error
+	state  func() []Event
+	peers  []*peer
+	client *http.Client
+}
+
+type peer struct {
+	url     string
+	queue   chan Event
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+
+	mu       sync.Mutex
+	lastSync time.Time
+	err      string
+}
+
+// New returns a node applying the events of peers with apply and sending
+// them the events of state in full syncs
+func New(opts Options, apply func(Event) error, state func() []Event) *Node {
+	n := &Node{
+		opts:   opts,
+		name:   opts.name(),
+		apply:  apply,
+		state:  state,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, u := range opts.Peers {
+		n.peers = append(n.peers, &peer{url: strings.TrimSuffix(u, "/"), queue: make(chan Event, queueSize)})
+	}
+	return n
+}
+
+// Publish queues an event for every peer, dropping it for the peers that
+// lag behind
+func (n *Node) Publish(e Event) {
+	if n == nil {
+		return
+	}
+	e.From = n.name
+	for _, p := range n.peers {
+		select {
+		case p.queue <- e:
+		default:
+			p.dropped.Add(1)
+		}
+	}
+}
+
+// Run fetches the state of the peers, then sends events as they are
+// published and the full state every interval until ctx is done
+func (n *Node) Run(ctx context.Context) {
+	for _, p := range n.peers {
+		go func() {
+			if err := n.pull(ctx, p); err != nil {
+				p.failed(err)
+			}
+			ticker := time.NewTicker(n.opts.interval())
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case e := <-p.queue:
+					batch := []Event{e}
+				drain:
+					for len(batch) < maxBatch {
+						select {
+						case e := <-p.queue:
+							batch = append(batch, e)
+						default:
+							break drain
+						}
+					}
+					n.push(ctx, p, batch)
+				case <-ticker.C:
+					n.push(ctx, p, n.fullState())
+				}
+			}
+		}()
+	}
+}
+
+// fullState returns the events of the state of the instance
+func (n *Node) fullState() []Event {
+	events := n.state()
+	for i := range events {
+		events[i].From = n.name
+	}
+	return events
+}
+
+// push sends events to the peer in batches
+func (n *Node) push(ctx context.Context, p *peer, events []Event) {
+	for len(events) > 0 {
+		batch := events[:min(len(events), maxBatch)]
+		events = events[len(batch):]
+		body, err := json.Marshal(batch)
+		if err != nil {
+			p.failed(err)
+			return
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url+eventsPath, bytes.NewReader(body))
+		if err != nil {
+			p.failed(err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if err := n.do(req, nil); err != nil {
+			p.failed(err)
+			return
+		}
+		p.sent.Add(uint64(len(batch)))
+	}
+	p.synced()
+}
+
+// pull applies the state of the peer
+func (n *Node) pull(ctx context.Context, p *peer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url+statePath, nil)
+	if err != nil {
+		return err
+	}
+	var events []Event
+	if err := n.do(req, &events); err != nil {
+		return err
+	}
+	n.applyAll(events)
+	peerLog.Info("fetched peer state", "peer", p.url, "events", len(events))
+	p.synced()
+	return nil
+}
+
+func (n *Node) do(req *http.Request, out any) error {
+	req.Header.Set("Authorization", "Bearer "+n.opts.Secret)
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %s", res.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(http.MaxBytesReader(nil, res.Body, maxBody)).Decode(out)
+}
+
+func (n *Node) applyAll(events []Event) {
+	for _, e := range events {
+		if err := n.apply(e); err != nil {
+			peerLog.Warn("peer event not applied", "from", e.From, "kind", e.Kind, "err", err)
+		}
+	}
+}
+
+func (p *peer) failed(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// only a new failure is logged, not every retry
+	if msg := err.Error(); msg != p.err {
+		peerLog.Warn("peer sync failed", "peer", p.url, "err", err)
+		p.err = msg
+	}
+}
+
+func (p *peer) synced() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != "" {
+		peerLog.Info("peer sync recovered", "peer", p.url)
+	}
+	p.lastSync, p.err = time.Now(), ""
+}
+
+// Stats returns the exchanges with each peer in the configured order
+func (n *Node) Stats() []Stats {
+	stats := []Stats{}
+	if n == nil {
+		return stats
+	}
+	for _, p := range n.peers {
+		s := Stats{URL: p.url, Sent: p.sent.Load(), Dropped: p.dropped.Load()}
+		p.mu.Lock()
+		if !p.lastSync.IsZero() {
+			last := p.lastSync
+			s.LastSync = &last
+		}
+		s.Error = p.err
+		p.mu.Unlock()
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// ServeHTTP receives the events of peers and serves the state of the
+// instance
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(n.opts.Secret)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.URL.Path == eventsPath && r.Method == http.MethodPost:
+		var events []Event
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody)).Decode(&events); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n.applyAll(events)
+		w.WriteHeader(http.StatusOK)
+	case r.URL.Path == statePath && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(n.fullState())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// ListenAndServe receives the events of peers until it fails
+func (n *Node) ListenAndServe() error {
+	peerLog.Info("peering running", "address", n.opts.Listen, "name", n.name, "peers", len(n.peers), "cache", n.opts.Cache)
+	srv := &http.Server{Addr: n.opts.Listen, Handler: n, ReadHeaderTimeout: 5 * time.Second}
+	return srv.ListenAndServe()
+}
@@ -0,0 +1,116 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/blocklist"
+	"github.com/bernoussama/mercury/dns"
+)
+
+const secret = "0123456789abcdef"
+
+// recorder collects the events applied by a node
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) apply(e Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recorder) wait(t *testing.T, n int) []Event {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		r.mu.Lock()
+		events := append([]Event(nil), r.events...)
+		r.mu.Unlock()
+		if len(events) >= n {
+			return events
+		}
+	}
+	t.Fatalf("fewer than %d events applied", n)
+	return nil
+}
+
+func TestNodeSync(t *testing.T) {
+	var primary, standby recorder
+	hosts := map[string][]net.IP{"laptop": {net.ParseIP("192.168.1.7")}}
+	// the standby only receives, the primary pulls its state then pushes
+	receiver := New(Options{Name: "standby", Secret: secret}, standby.apply, func() []Event {
+		return []Event{{Kind: KindHosts, Hosts: hosts}}
+	})
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+	sender := New(Options{Name: "primary", Secret: secret, Peers: []string{srv.URL + "/"}}, primary.apply, func() []Event { return nil })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sender.Run(ctx)
+
+	if events := primary.wait(t, 1); events[0].From != "standby" || events[0].Hosts["laptop"][0].String() != "192.168.1.7" {
+		t.Errorf("pulled %+v", events[0])
+	}
+	sender.Publish(Event{Kind: KindEdit, Edit: &blocklist.Edit{Kind: blocklist.EditAllow, Name: "ads.example.com.", At: time.Now()}})
+	if events := standby.wait(t, 1); events[0].From != "primary" || events[0].Edit == nil || events[0].Edit.Name != "ads.example.com." {
+		t.Errorf("pushed %+v", events[0])
+	}
+	answer := dns.Message{Question: dns.Question{DomainName: "example.com.", QType: dns.TypeA, QClass: 1}}
+	answer.Answers = []dns.Answer{{Name: []byte("\x07example\x03com\x00"), Type: uint16(dns.TypeA), Class: 1, TTL: 300, RDLength: 4, RData: []byte{93, 184, 215, 14}}}
+	sender.Publish(Event{Kind: KindCache, Cache: &CacheEntry{Key: "example.com.", Message: answer, TTL: 300}})
+	if events := standby.wait(t, 2); events[1].Cache == nil || !bytes.Equal(events[1].Cache.Message.Answers[0].RData, answer.Answers[0].RData) {
+		t.Errorf("pushed %+v", events[1])
+	}
+	stats := sender.Stats()
+	if len(stats) != 1 || stats[0].Sent != 2 || stats[0].LastSync == nil || stats[0].Error != "" {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestNodeSecret(t *testing.T) {
+	var applied recorder
+	srv := httptest.NewServer(New(Options{Secret: secret}, applied.apply, func() []Event { return nil }))
+	defer srv.Close()
+	for _, auth := range []string{"", "Bearer wrong", secret} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+statePath, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("authorization %q: HTTP %d, want 401", auth, res.StatusCode)
+		}
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts Options
+		errs int
+	}{
+		{Options{}, 0},
+		{Options{Listen: ":53190", Peers: []string{"http://10.0.0.3:53190"}, Secret: secret}, 0},
+		{Options{Listen: ":53190"}, 1},
+		{Options{Listen: "53190", Secret: secret}, 1},
+		{Options{Peers: []string{"10.0.0.3:53190", "ftp://10.0.0.3"}, Secret: secret}, 2},
+		{Options{Peers: []string{"https://dns2.lan"}, Secret: secret, Interval: -time.Second}, 1},
+	}
+	for _, tt := range tests {
+		if errs := tt.opts.Validate(); len(errs) != tt.errs {
+			t.Errorf("Validate(%+v) = %v, want %d errors", tt.opts, errs, tt.errs)
+		}
+	}
+}
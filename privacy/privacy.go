@@ -0,0 +1,86 @@
+// Package privacy truncates client addresses to their network and hashes
+// query names, so logs and stats tell less about who looked up what.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Options configures what logs and stats keep of clients and queries
+type Options struct {
+	// IPv4Prefix and IPv6Prefix truncate client addresses to their network,
+	// like 24 and 56. Zero keeps addresses whole.
+	IPv4Prefix int `yaml:"ipv4_prefix"`
+	IPv6Prefix int `yaml:"ipv6_prefix"`
+	// HashNames replaces query names in logs with a keyed hash. Queries for
+	// the same name share a hash until the server restarts.
+	HashNames bool `yaml:"hash_names"`
+	// Retention is how long client stats keep whole addresses and device
+	// names before they are counted under the truncated network
+	Retention time.Duration `yaml:"retention"`
+}
+
+// Validate reports problems with the options without applying them
+func (o Options) Validate() []error {
+	var errs []error
+	if o.IPv4Prefix < 0 || o.IPv4Prefix > 32 {
+		errs = append(errs, fmt.Errorf("ipv4_prefix must be between 0 and 32, got %d", o.IPv4Prefix))
+	}
+	if o.IPv6Prefix < 0 || o.IPv6Prefix > 128 {
+		errs = append(errs, fmt.Errorf("ipv6_prefix must be between 0 and 128, got %d", o.IPv6Prefix))
+	}
+	if o.Retention < 0 {
+		errs = append(errs, fmt.Errorf("retention must not be negative, got %s", o.Retention))
+	}
+	return errs
+}
+
+// TruncatesAddresses reports whether client addresses are truncated
+func (o Options) TruncatesAddresses() bool {
+	return o.IPv4Prefix > 0 || o.IPv6Prefix > 0
+}
+
+// Enabled reports whether anything is anonymized
+func (o Options) Enabled() bool {
+	return o.TruncatesAddresses() || o.HashNames
+}
+
+// Address returns the network of ip, like 192.168.1.0/24, or ip itself
+// when its family is not truncated
+func (o Options) Address(ip net.IP) string {
+	bits, prefix := 128, o.IPv6Prefix
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits, prefix = ip4, 32, o.IPv4Prefix
+	}
+	if prefix == 0 || prefix == bits {
+		return ip.String()
+	}
+	mask := net.CIDRMask(prefix, bits)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+// key of the name hashes, new on every start so hashes cannot be matched
+// against a dictionary hashed in advance
+var key = func() []byte {
+	k := make([]byte, 32)
+	rand.Read(k)
+	return k
+}()
+
+// Name returns the hash of the query name when names are hashed, name
+// itself otherwise
+func (o Options) Name(name string) string {
+	if !o.HashNames {
+		return name
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.ToLower(strings.TrimSuffix(name, "."))))
+	return "h:" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
@@ -0,0 +1,53 @@
+package privacy
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestAddress(t *testing.T) {
+	opts := Options{IPv4Prefix: 24, IPv6Prefix: 56}
+	tests := []struct {
+		opts Options
+		ip   string
+		want string
+	}{
+		{opts, "192.168.1.20", "192.168.1.0/24"},
+		{opts, "2001:db8:aa:bbcc:1::1", "2001:db8:aa:bb00::/56"},
+		{opts, "::ffff:10.1.2.3", "10.1.2.0/24"},
+		{Options{IPv6Prefix: 48}, "192.168.1.20", "192.168.1.20"},
+		{Options{IPv4Prefix: 32}, "192.168.1.20", "192.168.1.20"},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.Address(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("Address(%s) with %+v = %s, want %s", tt.ip, tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	if got := (Options{}).Name("example.com."); got != "example.com." {
+		t.Errorf("Name() without hashing = %q", got)
+	}
+	opts := Options{HashNames: true}
+	hashed := opts.Name("example.com.")
+	if !strings.HasPrefix(hashed, "h:") || strings.Contains(hashed, "example") {
+		t.Errorf("Name() = %q, want a hash", hashed)
+	}
+	if opts.Name("EXAMPLE.com") != hashed {
+		t.Error("case and trailing dot change the hash")
+	}
+	if opts.Name("example.org.") == hashed {
+		t.Error("different names share a hash")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if errs := (Options{IPv4Prefix: 33, IPv6Prefix: -1, Retention: -1}).Validate(); len(errs) != 3 {
+		t.Errorf("Validate() = %v, want 3 errors", errs)
+	}
+	if errs := (Options{IPv4Prefix: 24, IPv6Prefix: 56, HashNames: true}).Validate(); len(errs) != 0 {
+		t.Errorf("Validate() = %v", errs)
+	}
+}
@@ -0,0 +1,174 @@
+// Package proxyproto reads the PROXY protocol version 2 header that load
+// balancers send ahead of the TCP connections they pass on, so the server
+// sees the address of the client instead of the balancer's.
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// signature starts every version 2 header
+var signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrInvalid is the error of connections not starting with a valid header
+var ErrInvalid = errors.New("invalid PROXY protocol header")
+
+// HeaderTimeout bounds the time a connection takes to send its header
+const HeaderTimeout = 5 * time.Second
+
+// commands of the header
+const (
+	cmdLocal = 0x0
+	cmdProxy = 0x1
+)
+
+// address families and transports of the header
+const (
+	tcpOverIPv4 = 0x11
+	tcpOverIPv6 = 0x21
+)
+
+// Header is a decoded version 2 header
+type Header struct {
+	// Local is set on connections the balancer opened itself, like health
+	// checks, which carry no client address
+	Local       bool
+	Source      *net.TCPAddr
+	Destination *net.TCPAddr
+}
+
+// ReadHeader reads a version 2 header from r, reading no further than its
+// end. Addresses of families other than TCP over IPv4 and IPv6 are left
+// out, as are the TLVs after them.
+func ReadHeader(r io.Reader) (Header, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return Header{}, err
+	}
+	if !bytes.Equal(fixed[:12], signature) {
+		return Header{}, fmt.Errorf("%w: no signature", ErrInvalid)
+	}
+	if version := fixed[12] >> 4; version != 2 {
+		return Header{}, fmt.Errorf("%w: version %d", ErrInvalid, version)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Header{}, err
+	}
+
+	var h Header
+	switch fixed[12] & 0x0F {
+	case cmdLocal:
+		h.Local = true
+		return h, nil
+	case cmdProxy:
+	default:
+		return Header{}, fmt.Errorf("%w: command %d", ErrInvalid, fixed[12]&0x0F)
+	}
+	var size int
+	switch fixed[13] {
+	case tcpOverIPv4:
+		size = net.IPv4len
+	case tcpOverIPv6:
+		size = net.IPv6len
+	default:
+		// the balancer could not tell, keep the address of the connection
+		h.Local = true
+		return h, nil
+	}
+	if len(body) < 2*size+4 {
+		return Header{}, fmt.Errorf("%w: %d bytes of addresses", ErrInvalid, len(body))
+	}
+	h.Source = &net.TCPAddr{IP: net.IP(body[:size]), Port: int(binary.BigEndian.Uint16(body[2*size:]))}
+	h.Destination = &net.TCPAddr{IP: net.IP(body[size : 2*size]), Port: int(binary.BigEndian.Uint16(body[2*size+2:]))}
+	return h, nil
+}
+
+// Listener reads the header of the connections it accepts from the
+// balancers
+type Listener struct {
+	net.Listener
+	// Trusted are the networks of the balancers. Connections from other
+	// addresses are passed on as they are. Every connection must start
+	// with a header when empty.
+	Trusted []*net.IPNet
+}
+
+// Accept returns the next connection. The header is read on the first
+// Read or RemoteAddr, in the goroutine serving the connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusts(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn}, nil
+}
+
+// trusts reports whether addr is one of the balancers
+func (l *Listener) trusts(addr net.Addr) bool {
+	if len(l.Trusted) == 0 {
+		return true
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipnet := range l.Trusted {
+		if ipnet.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection starting with a header, whose RemoteAddr is the
+// client address of the header. Reads fail when the header is invalid.
+type Conn struct {
+	net.Conn
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// readHeader reads the header once, within HeaderTimeout
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		c.remote = c.Conn.RemoteAddr()
+		c.Conn.SetReadDeadline(time.Now().Add(HeaderTimeout))
+		h, err := ReadHeader(c.Conn)
+		c.Conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			c.err = err
+			return
+		}
+		if !h.Local {
+			c.remote = h.Source
+		}
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr returns the client address of the header, or the address
+// of the balancer for local connections and invalid headers
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	return c.remote
+}
@@ -0,0 +1,107 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// header returns a version 2 header with command and family, followed by
+// body
+func header(command, family byte, body []byte) []byte {
+	h := append([]byte{}, signature...)
+	h = append(h, 0x20|command, family)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(body)))
+	return append(h, body...)
+}
+
+func TestReadHeader(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xC3, 0x50, 0, 53}
+	ipv6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0x04, 0xD2, 0x03, 0x55)
+	tests := []struct {
+		name   string
+		in     []byte
+		source string
+		local  bool
+		err    error
+	}{
+		{"ipv4", header(cmdProxy, tcpOverIPv4, ipv4), "192.0.2.1:50000", false, nil},
+		{"ipv6", header(cmdProxy, tcpOverIPv6, ipv6), "[2001:db8::1]:1234", false, nil},
+		{"tlvs", header(cmdProxy, tcpOverIPv4, append(ipv4, 0x04, 0, 1, 'x')), "192.0.2.1:50000", false, nil},
+		{"local", header(cmdLocal, 0, nil), "", true, nil},
+		{"unspecified", header(cmdProxy, 0, nil), "", true, nil},
+		{"short addresses", header(cmdProxy, tcpOverIPv4, ipv4[:8]), "", false, ErrInvalid},
+		{"bad command", header(0x5, tcpOverIPv4, ipv4), "", false, ErrInvalid},
+		{"version 1", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 50000 53\r\n"), "", false, ErrInvalid},
+		{"truncated", header(cmdProxy, tcpOverIPv4, ipv4)[:20], "", false, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(append(tt.in, "query"...))
+			h, err := ReadHeader(r)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if h.Local != tt.local || (!tt.local && h.Source.String() != tt.source) {
+				t.Errorf("header = %+v, want source %s local %v", h, tt.source, tt.local)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "query" {
+				t.Errorf("read past the header, %q left", rest)
+			}
+		})
+	}
+}
+
+func TestListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, other, _ := net.ParseCIDR("10.0.0.0/8")
+
+	for _, tt := range []struct {
+		name    string
+		trusted []*net.IPNet
+		send    []byte
+		remote  string
+	}{
+		{"from a balancer", []*net.IPNet{loopback}, header(cmdProxy, tcpOverIPv4, []byte{192, 0, 2, 1, 127, 0, 0, 1, 0xC3, 0x50, 0, 53}), "192.0.2.1:50000"},
+		{"trusting all", nil, header(cmdProxy, tcpOverIPv4, []byte{192, 0, 2, 7, 127, 0, 0, 1, 0xC3, 0x50, 0, 53}), "192.0.2.7:50000"},
+		{"direct client", []*net.IPNet{other}, nil, "127.0.0.1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			pl := &Listener{Listener: ln, Trusted: tt.trusted}
+			client, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			client.Write(append(tt.send, "query"...))
+
+			conn, err := pl.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			remote := conn.RemoteAddr().String()
+			if tt.send == nil {
+				remote, _, _ = net.SplitHostPort(remote)
+			}
+			if remote != tt.remote {
+				t.Errorf("RemoteAddr() = %s, want %s", remote, tt.remote)
+			}
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "query" {
+				t.Errorf("Read() = %q, %v", buf, err)
+			}
+		})
+	}
+}
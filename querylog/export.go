@@ -0,0 +1,104 @@
+package querylog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Formats are the export formats
+var Formats = []string{"csv", "json", "jsonl"}
+
+// csvHeader names the columns of the CSV export
+var csvHeader = []string{"time", "client", "device", "name", "type", "rcode", "answers", "source", "category", "duration_ms"}
+
+// Exporter writes entries in an export format
+type Exporter interface {
+	Write(Entry) error
+	// Close ends the export, writing what the format needs at its end
+	Close() error
+}
+
+// NewExporter returns an exporter writing to w in format: csv with a
+// header line, a json array, or jsonl with an object per line. Parquet is
+// not written, it would take a dependency for a format the csv and jsonl
+// exports convert to.
+func NewExporter(w io.Writer, format string) (Exporter, error) {
+	switch format {
+	case "parquet":
+		return nil, errors.New("parquet export is not supported, export csv or jsonl and convert it")
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &csvExporter{w: cw}, nil
+	case "json":
+		return &jsonExporter{w: w, enc: json.NewEncoder(w)}, nil
+	case "jsonl":
+		return &jsonExporter{enc: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q, want csv, json or jsonl", format)
+}
+
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvExporter) Write(entry Entry) error {
+	return e.w.Write([]string{
+		entry.Time.UTC().Format(time.RFC3339Nano),
+		entry.Client,
+		entry.Device,
+		entry.Name,
+		entry.Type,
+		entry.Rcode,
+		strconv.Itoa(entry.Answers),
+		entry.Source,
+		entry.Category,
+		strconv.FormatFloat(float64(entry.Duration)/float64(time.Millisecond), 'f', 3, 64),
+	})
+}
+
+func (e *csvExporter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonExporter writes an object per line, wrapped in an array when w is
+// set
+type jsonExporter struct {
+	w       io.Writer
+	enc     *json.Encoder
+	written int
+}
+
+func (e *jsonExporter) Write(entry Entry) error {
+	if e.w != nil {
+		sep := ",\n"
+		if e.written == 0 {
+			sep = "[\n"
+		}
+		if _, err := io.WriteString(e.w, sep); err != nil {
+			return err
+		}
+	}
+	e.written++
+	return e.enc.Encode(entry)
+}
+
+func (e *jsonExporter) Close() error {
+	if e.w == nil {
+		return nil
+	}
+	end := "]\n"
+	if e.written == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
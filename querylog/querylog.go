@@ -0,0 +1,214 @@
+// Package querylog keeps the history of the queries answered, one JSON
+// line per query, and reads it back for export.
+package querylog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/bernoussama/mercury/logging"
+	"github.com/bernoussama/mercury/privacy"
+)
+
+var queryLog = logging.For(logging.Server)
+
+// DefaultMaxSize is the size in megabytes the log grows to before it is
+// rotated, when no size is configured
+const DefaultMaxSize = 100
+
+// queued bounds the entries waiting to be written, further ones are
+// dropped rather than slowing queries down
+const queued = 4096
+
+// Options configures where queries are logged
+type Options struct {
+	// File is appended a JSON line per query, no history is kept when empty
+	File string `yaml:"file"`
+	// MaxSize is the size in megabytes past which File is renamed to
+	// File.1, replacing the previous one, and started anew
+	MaxSize int `yaml:"max_size"`
+}
+
+// Validate reports problems with the options without applying them
+func (o Options) Validate() []error {
+	var errs []error
+	if o.MaxSize < 0 {
+		errs = append(errs, fmt.Errorf("max_size must not be negative, got %d", o.MaxSize))
+	}
+	if o.MaxSize > 0 && o.File == "" {
+		errs = append(errs, errors.New("max_size without a file"))
+	}
+	return errs
+}
+
+func (o Options) maxSize() int64 {
+	if o.MaxSize == 0 {
+		return DefaultMaxSize << 20
+	}
+	return int64(o.MaxSize) << 20
+}
+
+// Entry is an answered query
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client"`
+	Device string    `json:"device,omitempty"`
+	Name   string    `json:"name"`
+	Type   string    `json:"type"`
+	Rcode  string    `json:"rcode"`
+	// Answers counts the records of the answer section
+	Answers int `json:"answers"`
+	// Source is what answered: blocklist, hosts, zone, local for the
+	// locally served zones, cache, upstream, root for the copy of the root
+	// zone or identity, detect when the
+	// client was refused as flagged, or none when nothing could answer
+	Source string `json:"source"`
+	// Category is the blocklist category of blocked queries
+	Category string        `json:"category,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Log appends entries to its file in the background. A nil log keeps
+// nothing.
+type Log struct {
+	opts    Options
+	privacy privacy.Options
+	entries chan Entry
+	dropped atomic.Uint64
+	done    chan error
+}
+
+// Open starts logging to the file of opts, anonymizing the entries as
+// privacy says
+func Open(opts Options, p privacy.Options) (*Log, error) {
+	f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{opts: opts, privacy: p, entries: make(chan Entry, queued), done: make(chan error, 1)}
+	go func() { l.done <- l.write(f) }()
+	return l, nil
+}
+
+// Add queues e to be written, dropping it when the writer falls behind
+func (l *Log) Add(e Entry) {
+	if l == nil {
+		return
+	}
+	select {
+	case l.entries <- e:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of entries dropped so far
+func (l *Log) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// Close writes the queued entries and closes the file. Entries added
+// after Close panic.
+func (l *Log) Close() error {
+	close(l.entries)
+	return <-l.done
+}
+
+// write appends the entries to f until the log is closed, rotating f when
+// it grows past the maximum size
+func (l *Log) write(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	size := info.Size()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for e := range l.entries {
+		e = anonymize(e, l.privacy)
+		before := w.Buffered()
+		if err := enc.Encode(e); err != nil {
+			queryLog.Warn("query not logged", "err", err)
+			continue
+		}
+		size += int64(w.Buffered() - before)
+		if len(l.entries) > 0 {
+			continue
+		}
+		if err := w.Flush(); err != nil {
+			queryLog.Warn("query log not written", "file", l.opts.File, "err", err)
+		}
+		if size > l.opts.maxSize() {
+			if f, err = l.rotate(f); err != nil {
+				return err
+			}
+			size = 0
+			w.Reset(f)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate renames the full log to File.1 and opens a new one
+func (l *Log) rotate(f *os.File) (*os.File, error) {
+	f.Close()
+	if err := os.Rename(l.opts.File, l.opts.File+".1"); err != nil {
+		queryLog.Warn("query log not rotated", "file", l.opts.File, "err", err)
+	}
+	return os.OpenFile(l.opts.File, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+}
+
+// Read calls fn with the entries of the log at file logged at since or
+// later, oldest first, starting with those of the rotated file. Lines
+// that do not parse are skipped.
+func Read(file string, since time.Time, fn func(Entry) error) error {
+	read := 0
+	for _, path := range []string{file + ".1", file} {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		read++
+		err = readEntries(f, since, fn)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if read == 0 {
+		return fmt.Errorf("no query log at %s", file)
+	}
+	return nil
+}
+
+func readEntries(r io.Reader, since time.Time, fn func(Entry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.Time.Before(since) {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
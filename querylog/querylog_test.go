@@ -0,0 +1,157 @@
+package querylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bernoussama/mercury/privacy"
+)
+
+func TestLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "queries.jsonl")
+	now := time.Now()
+	// a rotated file holds the older queries
+	old := Entry{Time: now.Add(-2 * time.Hour), Client: "192.168.1.3", Name: "old.example.com.", Type: "A", Rcode: "NOERROR", Source: "cache"}
+	line, _ := json.Marshal(old)
+	if err := os.WriteFile(file+".1", append(line, '\n'), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Open(Options{File: file}, privacy.Options{IPv4Prefix: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Add(Entry{Time: now.Add(-time.Minute), Client: "192.168.1.20", Device: "laptop", Name: "ads.example.com.", Type: "A", Rcode: "NOERROR", Answers: 1, Source: "blocklist", Category: "ads"})
+	l.Add(Entry{Time: now, Client: "2001:db8::1", Name: "example.com.", Type: "AAAA", Rcode: "NOERROR", Answers: 2, Source: "upstream", Duration: 12 * time.Millisecond})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var nilLog *Log
+	nilLog.Add(Entry{})
+
+	var got []Entry
+	collect := func(e Entry) error {
+		got = append(got, e)
+		return nil
+	}
+	if err := Read(file, now.Add(-3*time.Hour), collect); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Name != "old.example.com." || got[2].Name != "example.com." {
+		t.Fatalf("entries %+v, want the rotated one first", got)
+	}
+	if got[1].Client != "192.168.1.0/24" || got[1].Device != "" || got[1].Category != "ads" {
+		t.Errorf("entry %+v, want the client anonymized", got[1])
+	}
+	if got[2].Client != "2001:db8::1" || got[2].Duration != 12*time.Millisecond {
+		t.Errorf("entry %+v", got[2])
+	}
+
+	got = nil
+	if err := Read(file, now.Add(-time.Hour), collect); err != nil || len(got) != 2 {
+		t.Errorf("since an hour ago: %d entries, err %v, want 2", len(got), err)
+	}
+	if err := Read(filepath.Join(t.TempDir(), "none.jsonl"), now, collect); err == nil {
+		t.Error("reading a missing log succeeded")
+	}
+}
+
+func TestExporter(t *testing.T) {
+	entries := []Entry{
+		{Time: time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC), Client: "192.168.1.20", Device: "laptop", Name: "ads.example.com.", Type: "A", Rcode: "NOERROR", Answers: 1, Source: "blocklist", Category: "ads"},
+		{Time: time.Date(2026, 10, 16, 8, 0, 1, 0, time.UTC), Client: "192.168.1.21", Name: "a,b.example.com.", Type: "AAAA", Rcode: "NXDOMAIN", Source: "upstream", Duration: 1500 * time.Microsecond},
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"csv", "time,client,device,name,type,rcode,answers,source,category,duration_ms\n" +
+			"2026-10-16T08:00:00Z,192.168.1.20,laptop,ads.example.com.,A,NOERROR,1,blocklist,ads,0.000\n" +
+			"2026-10-16T08:00:01Z,192.168.1.21,,\"a,b.example.com.\",AAAA,NXDOMAIN,0,upstream,,1.500\n"},
+		{"jsonl", `{"time":"2026-10-16T08:00:00Z","client":"192.168.1.20","device":"laptop","name":"ads.example.com.","type":"A","rcode":"NOERROR","answers":1,"source":"blocklist","category":"ads","duration_ns":0}` + "\n" +
+			`{"time":"2026-10-16T08:00:01Z","client":"192.168.1.21","name":"a,b.example.com.","type":"AAAA","rcode":"NXDOMAIN","answers":0,"source":"upstream","duration_ns":1500000}` + "\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		exporter, err := NewExporter(&buf, tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			exporter.Write(e)
+		}
+		if err := exporter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s export:\n%s\nwant:\n%s", tt.format, buf.String(), tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	exporter, _ := NewExporter(&buf, "json")
+	for _, e := range entries {
+		exporter.Write(e)
+	}
+	exporter.Close()
+	var decoded []Entry
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded) != 2 {
+		t.Errorf("json export is no array of 2 entries: %v\n%s", err, buf.String())
+	}
+	buf.Reset()
+	exporter, _ = NewExporter(&buf, "json")
+	exporter.Close()
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("empty json export = %q", buf.String())
+	}
+	for _, format := range []string{"parquet", "xml"} {
+		if _, err := NewExporter(&buf, format); err == nil {
+			t.Errorf("%s export accepted, it is not written", format)
+		}
+	}
+}
+
+func TestTap(t *testing.T) {
+	var nilTap *Tap
+	nilTap.Add(Entry{})
+	if nilTap.Active() {
+		t.Error("nil tap active")
+	}
+
+	tap := NewTap(privacy.Options{IPv4Prefix: 24})
+	tap.Add(Entry{Name: "before.example.com."})
+	all := tap.Subscribe(Filter{})
+	laptop := tap.Subscribe(Filter{Client: "Laptop", Suffix: "example.com"})
+	tap.Add(Entry{Client: "192.168.1.20", Device: "laptop", Name: "www.example.com."})
+	tap.Add(Entry{Client: "192.168.1.20", Device: "laptop", Name: "example.org."})
+	tap.Add(Entry{Client: "192.168.1.21", Name: "example.com."})
+
+	if e := <-all.Entries; e.Name != "www.example.com." || e.Client != "192.168.1.0/24" || e.Device != "" {
+		t.Errorf("first entry %+v, want the client anonymized", e)
+	}
+	if len(all.Entries) != 2 {
+		t.Errorf("%d more entries, want 2", len(all.Entries))
+	}
+	// the device name is gone once anonymized, so nothing matches
+	if len(laptop.Entries) != 0 {
+		t.Errorf("%d entries for the laptop, want none", len(laptop.Entries))
+	}
+
+	for range tapQueue + 1 {
+		tap.Add(Entry{Name: "flood.example.com."})
+	}
+	if all.Dropped() != 3 {
+		t.Errorf("dropped %d, want 3", all.Dropped())
+	}
+	tap.Unsubscribe(all)
+	tap.Unsubscribe(laptop)
+	tap.Unsubscribe(laptop)
+	if _, ok := <-laptop.Entries; ok || tap.Active() {
+		t.Error("tap still active after unsubscribing")
+	}
+}
@@ -0,0 +1,127 @@
+package querylog
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bernoussama/mercury/fqdn"
+	"github.com/bernoussama/mercury/privacy"
+)
+
+// tapQueue bounds the entries waiting for one subscriber, further ones are
+// dropped for it
+const tapQueue = 256
+
+// Tap hands the entries of the queries answered to the subscribers watching
+// them live, anonymized like the log. A nil tap has no subscribers.
+type Tap struct {
+	privacy privacy.Options
+	active  atomic.Int32
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Filter selects the entries of a subscription
+type Filter struct {
+	// Client is the address or device name of the client, any client when
+	// empty
+	Client string
+	// Suffix keeps the names at or under a domain, any name when empty
+	Suffix string
+}
+
+func (f Filter) match(e Entry) bool {
+	if f.Client != "" && f.Client != e.Client && !strings.EqualFold(f.Client, e.Device) {
+		return false
+	}
+	if f.Suffix != "" {
+		return fqdn.Canonical(e.Name).IsSubdomainOf(fqdn.Canonical(f.Suffix))
+	}
+	return true
+}
+
+// Subscription receives the entries of a tap matching its filter
+type Subscription struct {
+	// Entries are the entries in the order they were answered
+	Entries <-chan Entry
+	entries chan Entry
+	filter  Filter
+	dropped atomic.Uint64
+}
+
+// Dropped returns the number of entries dropped as the subscriber lagged
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// NewTap returns a tap anonymizing entries as privacy says
+func NewTap(p privacy.Options) *Tap {
+	return &Tap{privacy: p, subs: make(map[*Subscription]struct{})}
+}
+
+// Active reports whether anyone is subscribed, so queries are only turned
+// into entries when watched
+func (t *Tap) Active() bool {
+	return t != nil && t.active.Load() > 0
+}
+
+// Subscribe returns a subscription to the entries matching f, ended by
+// Unsubscribe
+func (t *Tap) Subscribe(f Filter) *Subscription {
+	entries := make(chan Entry, tapQueue)
+	s := &Subscription{Entries: entries, entries: entries, filter: f}
+	t.mu.Lock()
+	t.subs[s] = struct{}{}
+	t.mu.Unlock()
+	t.active.Add(1)
+	return s
+}
+
+// Unsubscribe ends a subscription, closing its entries
+func (t *Tap) Unsubscribe(s *Subscription) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.subs[s]; !ok {
+		return
+	}
+	delete(t.subs, s)
+	close(s.entries)
+	t.active.Add(-1)
+}
+
+// Add hands e to the subscribers it matches, dropping it for the ones that
+// lag behind
+func (t *Tap) Add(e Entry) {
+	if !t.Active() {
+		return
+	}
+	e = anonymize(e, t.privacy)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for s := range t.subs {
+		if !s.filter.match(e) {
+			continue
+		}
+		select {
+		case s.entries <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// anonymize returns e with its client truncated and its name hashed as p
+// says
+func anonymize(e Entry, p privacy.Options) Entry {
+	if p.TruncatesAddresses() {
+		if ip := net.ParseIP(e.Client); ip != nil {
+			e.Client = p.Address(ip)
+		}
+		e.Device = ""
+	}
+	e.Name = p.Name(e.Name)
+	return e
+}
@@ -0,0 +1,268 @@
+// Package update replaces the running binary with the latest release
+// published on GitHub, after checking the archive against the checksums
+// of the release.
+package update
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAPI is the GitHub API endpoint of the latest mercury release
+const DefaultAPI = "https://api.github.com/repos/bernoussama/mercury/releases/latest"
+
+// maxArchiveSize bounds the downloads, far above the size of a release
+const maxArchiveSize = 256 << 20
+
+// Asset is a file of a release
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// Release is a published version of mercury
+type Release struct {
+	Tag    string  `json:"tag_name"`
+	Assets []Asset `json:"assets"`
+}
+
+// Updater fetches releases
+type Updater struct {
+	// API is the URL of the latest release, DefaultAPI when empty
+	API  string
+	HTTP *http.Client
+}
+
+// New returns an updater querying the GitHub API
+func New() *Updater {
+	return &Updater{API: DefaultAPI, HTTP: &http.Client{Timeout: time.Minute}}
+}
+
+// Latest returns the latest release
+func (u *Updater) Latest(ctx context.Context) (Release, error) {
+	var release Release
+	body, err := u.get(ctx, u.API, 1<<20)
+	if err != nil {
+		return release, err
+	}
+	if err := json.Unmarshal(body, &release); err != nil {
+		return release, fmt.Errorf("release: %w", err)
+	}
+	if release.Tag == "" {
+		return release, errors.New("release without a tag")
+	}
+	return release, nil
+}
+
+// Apply downloads the archive of release for this platform, checks it
+// against the checksums of the release and replaces the binary at exe
+func (u *Updater) Apply(ctx context.Context, release Release, exe string) error {
+	archive, ok := release.Archive(runtime.GOOS, platformArch())
+	if !ok {
+		return fmt.Errorf("release %s has no archive for %s/%s", release.Tag, runtime.GOOS, platformArch())
+	}
+	sums, ok := release.Checksums()
+	if !ok {
+		return fmt.Errorf("release %s has no checksums, not updating", release.Tag)
+	}
+	list, err := u.get(ctx, sums.URL, 1<<20)
+	if err != nil {
+		return err
+	}
+	want, ok := checksum(list, archive.Name)
+	if !ok {
+		return fmt.Errorf("no checksum of %s in %s", archive.Name, sums.Name)
+	}
+	data, err := u.get(ctx, archive.URL, maxArchiveSize)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("%s: checksum %s, want %s", archive.Name, got, want)
+	}
+	binary, err := extract(data, "mercury")
+	if err != nil {
+		return fmt.Errorf("%s: %w", archive.Name, err)
+	}
+	return replace(exe, binary)
+}
+
+func (u *Updater) get(ctx context.Context, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := u.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s: larger than %d bytes", url, limit)
+	}
+	return data, nil
+}
+
+// Archive returns the tar.gz archive of the release for goos and arch,
+// named like mercury_1.4.0_linux_arm64.tar.gz by goreleaser
+func (r Release) Archive(goos, arch string) (Asset, bool) {
+	suffix := "_" + goos + "_" + arch + ".tar.gz"
+	for _, asset := range r.Assets {
+		if strings.HasSuffix(asset.Name, suffix) {
+			return asset, true
+		}
+	}
+	return Asset{}, false
+}
+
+// Checksums returns the list of SHA-256 checksums of the release
+func (r Release) Checksums() (Asset, bool) {
+	for _, asset := range r.Assets {
+		if strings.HasSuffix(asset.Name, "checksums.txt") {
+			return asset, true
+		}
+	}
+	return Asset{}, false
+}
+
+// platformArch returns the architecture as goreleaser names archives,
+// with the ARM version like armv7
+func platformArch() string {
+	if runtime.GOARCH != "arm" {
+		return runtime.GOARCH
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "GOARM" {
+				return "armv" + strings.TrimSuffix(strings.TrimSuffix(s.Value, ",softfloat"), ",hardfloat")
+			}
+		}
+	}
+	return "armv7"
+}
+
+// checksum returns the hex checksum of name in a sha256sum listing
+func checksum(list []byte, name string) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(list))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), true
+		}
+	}
+	return "", false
+}
+
+// extract returns the file called name in a tar.gz archive
+func extract(archive []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no %s in the archive", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && filepath.Base(hdr.Name) == name {
+			return io.ReadAll(io.LimitReader(tr, maxArchiveSize))
+		}
+	}
+}
+
+// replace writes binary next to exe and renames it over exe, so a failed
+// update leaves the old binary in place
+func replace(exe string, binary []byte) error {
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), "."+filepath.Base(exe)+".update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), exe)
+}
+
+// Newer reports whether the release tag latest is a later version than
+// current. Builds without a release version, like dev, are older than
+// every release.
+func Newer(current, latest string) bool {
+	l, ok := parseVersion(latest)
+	if !ok {
+		return false
+	}
+	c, ok := parseVersion(current)
+	if !ok {
+		return true
+	}
+	for i := range c {
+		if c[i] != l[i] {
+			return c[i] < l[i]
+		}
+	}
+	return false
+}
+
+// parseVersion parses vMAJOR.MINOR.PATCH, pre-release and pseudo versions
+// do not parse
+func parseVersion(v string) ([3]int, bool) {
+	var parsed [3]int
+	parts := strings.Split(strings.TrimPrefix(v, "v"), ".")
+	if len(parts) != 3 {
+		return parsed, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
@@ -0,0 +1,127 @@
+package update
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// archive returns a tar.gz holding a mercury binary with content
+func archive(t *testing.T, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range map[string]string{"README.md": "readme", "mercury": content} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(data))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// releaseServer serves a release with the archive for this platform and
+// checksums listing sum for it, the checksum of the archive when empty
+func releaseServer(t *testing.T, data []byte, sum string) *Updater {
+	t.Helper()
+	if sum == "" {
+		h := sha256.Sum256(data)
+		sum = hex.EncodeToString(h[:])
+	}
+	name := "mercury_1.4.0_" + runtime.GOOS + "_" + platformArch() + ".tar.gz"
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc("/latest", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Release{Tag: "v1.4.0", Assets: []Asset{
+			{Name: "mercury_1.4.0_checksums.txt", URL: srv.URL + "/checksums"},
+			{Name: "mercury_1.4.0_plan9_mips.tar.gz", URL: srv.URL + "/other"},
+			{Name: name, URL: srv.URL + "/archive"},
+		}})
+	})
+	mux.HandleFunc("/checksums", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0000  mercury_1.4.0_plan9_mips.tar.gz\n" + sum + "  " + name + "\n"))
+	})
+	mux.HandleFunc("/archive", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	})
+	return &Updater{API: srv.URL + "/latest", HTTP: srv.Client()}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name string
+		sum  string
+		err  string
+	}{
+		{name: "replaced"},
+		{name: "checksum mismatch", sum: strings.Repeat("ab", 32), err: "checksum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := releaseServer(t, archive(t, "new binary"), tt.sum)
+			exe := filepath.Join(t.TempDir(), "mercury")
+			if err := os.WriteFile(exe, []byte("old binary"), 0o750); err != nil {
+				t.Fatal(err)
+			}
+
+			release, err := u.Latest(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = u.Apply(context.Background(), release, exe)
+			want := "new binary"
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Apply() error = %v, want %q", err, tt.err)
+				}
+				want = "old binary"
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := os.ReadFile(exe)
+			if string(got) != want {
+				t.Errorf("binary = %q, want %q", got, want)
+			}
+			if info, _ := os.Stat(exe); info.Mode().Perm() != 0o750 {
+				t.Errorf("mode = %v, want 0750", info.Mode().Perm())
+			}
+			if entries, _ := os.ReadDir(filepath.Dir(exe)); len(entries) != 1 {
+				t.Errorf("%d files left next to the binary, want 1", len(entries))
+			}
+		})
+	}
+}
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		current, latest string
+		want            bool
+	}{
+		{"v1.3.9", "v1.4.0", true},
+		{"v1.4.0", "v1.4.0", false},
+		{"v1.10.0", "v1.9.0", false},
+		{"dev", "v0.1.0", true},
+		{"v0.0.0-20261016023209-6cc47284806b+dirty", "v0.1.0", true},
+		{"v1.4.0", "nightly", false},
+	}
+	for _, tt := range tests {
+		if got := Newer(tt.current, tt.latest); got != tt.want {
+			t.Errorf("Newer(%q, %q) = %v, want %v", tt.current, tt.latest, got, tt.want)
+		}
+	}
+}